@@ -131,7 +131,9 @@ func (s *Stamp) OlderThan(fn string) (bool, error) {
 	return ourInfo.ModTime().Before(theirInfo.ModTime()), nil
 }
 
-func Do(g *core.Grokker, args ...string) (err error) {
+// setPaths sets the aidda file names for the repository that holds
+// the knowledge base, and returns the .aidda directory.
+func setPaths(g *core.Grokker) (dir string, err error) {
 	defer Return(&err)
 
 	baseDir = g.Root
@@ -142,7 +144,7 @@ func Do(g *core.Grokker, args ...string) (err error) {
 	Ck(err)
 
 	// XXX location might want to be more flexible
-	dir := Spf("%s/.aidda", baseDir)
+	dir = Spf("%s/.aidda", baseDir)
 
 	// generate filenames
 	// XXX these should all be in a struct
@@ -155,6 +157,14 @@ func Do(g *core.Grokker, args ...string) (err error) {
 	// Initialize Stamp objects for generate and commit
 	generateStamp = NewStamp(generateStampFn)
 	commitStamp = NewStamp(commitStampFn)
+	return
+}
+
+func Do(g *core.Grokker, args ...string) (err error) {
+	defer Return(&err)
+
+	dir, err := setPaths(g)
+	Ck(err)
 
 	// Determine if interactive mode is active
 	isInteractive := false
@@ -331,7 +341,7 @@ func getPrompt(promptFn string) (p *Prompt, err error) {
 	editor := envi.String("AIDDA_EDITOR", "")
 	if editor != "" {
 		Pf("Opening editor %s\n", editor)
-		rc, err := RunInteractive(Spf("%s %s", editor, promptFn))
+		rc, err := RunInteractive(editor, promptFn)
 		Ck(err)
 		Assert(rc == 0, "editor failed")
 	}
@@ -581,6 +591,47 @@ func createPromptFile(path string) (err error) {
 	return
 }
 
+// WritePrompt replaces the prompt file with p, e.g. a fix suggested
+// by 'grok fix', so the next 'grok aidda generate' applies it.  The
+// files in p.In and p.Out are relative to the repository root.  Any
+// existing prompt is saved as prompt.bak.
+func WritePrompt(g *core.Grokker, p *Prompt) (err error) {
+	defer Return(&err)
+	dir, err := setPaths(g)
+	Ck(err)
+	if strings.HasPrefix(p.Txt, "#") {
+		return fmt.Errorf("prompt must not start with a comment")
+	}
+	err = os.MkdirAll(dir, 0755)
+	Ck(err)
+	err = ensureIgnoreFile(ignoreFn)
+	Ck(err)
+	now := time.Now()
+	err = generateStamp.Ensure(now)
+	Ck(err)
+	err = commitStamp.Ensure(now)
+	Ck(err)
+	old, err := os.ReadFile(promptFn)
+	if err == nil {
+		err = os.WriteFile(promptFn+".bak", old, 0644)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	Ck(err)
+	sysmsg := p.Sysmsg
+	if sysmsg == "" {
+		sysmsg = DefaultSysmsg
+	}
+	var buf strings.Builder
+	buf.WriteString(strings.TrimSpace(p.Txt) + "\n\n")
+	buf.WriteString(Spf("Sysmsg: %s\n", sysmsg))
+	buf.WriteString(Spf("In: %s\n", strings.Join(p.In, "\n    ")))
+	buf.WriteString(Spf("Out: %s\n", strings.Join(p.Out, "\n    ")))
+	err = os.WriteFile(promptFn, []byte(buf.String()), 0644)
+	Ck(err)
+	return
+}
+
 // ask asks the user a question and gets a response
 func ask(question, deflt string, others ...string) (response string, err error) {
 	defer Return(&err)
@@ -657,10 +708,20 @@ func generate(g *core.Grokker, p *Prompt) (err error) {
 	}
 
 	sysmsg := p.Sysmsg
+	if strings.HasPrefix(sysmsg, "persona:") {
+		// e.g. "Sysmsg: persona:security" uses a persona from the
+		// grokker config
+		name := strings.TrimSpace(strings.TrimPrefix(sysmsg, "persona:"))
+		err = g.SetPersona(name)
+		Ck(err)
+		Pf("Persona: %s\n", name)
+		sysmsg = DefaultSysmsg
+	}
 	if sysmsg == "" {
 		Pl("Sysmsg header missing, using default.")
 		sysmsg = DefaultSysmsg
 	}
+	sysmsg = g.Sysmsg(sysmsg)
 	Pf("Sysmsg: %s\n", sysmsg)
 
 	msgs := []core.ChatMsg{
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stevegt/grokker/v3/core"
@@ -283,3 +284,29 @@ func TestDoAbort(t *testing.T) {
 		t.Errorf("Expected error message %q, got %q", expectedError, err.Error())
 	}
 }
+
+func TestWritePrompt(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, ".git"), 0755)
+	Tassert(t, err == nil, "error creating .git: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	g := &core.Grokker{Root: dir}
+
+	p := &Prompt{Txt: "Fix undefined: conn\n\nDeclare conn.", In: []string{"main.go"}, Out: []string{"main.go"}}
+	err = WritePrompt(g, p)
+	Tassert(t, err == nil, "error writing prompt: %v", err)
+	got, err := readPrompt(filepath.Join(dir, ".aidda", "prompt"))
+	Tassert(t, err == nil, "error reading prompt: %v", err)
+	Tassert(t, strings.TrimSpace(got.Txt) == p.Txt, "expected %q, got %q", p.Txt, got.Txt)
+	Tassert(t, got.Sysmsg == DefaultSysmsg, "unexpected sysmsg %q", got.Sysmsg)
+	want := filepath.Join(dir, "main.go")
+	Tassert(t, len(got.In) == 1 && got.In[0] == want && len(got.Out) == 1 && got.Out[0] == want, "unexpected files %v %v", got.In, got.Out)
+
+	// the previous prompt is kept
+	err = WritePrompt(g, &Prompt{Txt: "Another fix", In: []string{"main.go"}})
+	Tassert(t, err == nil, "error writing prompt: %v", err)
+	bak, err := os.ReadFile(filepath.Join(dir, ".aidda", "prompt.bak"))
+	Tassert(t, err == nil, "error reading backup: %v", err)
+	Tassert(t, strings.HasPrefix(string(bak), "Fix undefined: conn\n"), "unexpected backup %q", bak)
+}
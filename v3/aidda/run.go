@@ -4,29 +4,18 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"sync"
 
-	"github.com/google/shlex"
 	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
 )
 
 // RunTee runs a command in the shell, with stdout and stderr tee'd to the terminal
 func RunTee(command string) (stdout, stderr []byte, rc int, err error) {
 	defer Return(&err)
-	// shlex the command to get the command and args
-	parts, err := shlex.Split(command)
+	// create the command, split the way the platform's shell would
+	cobj, err := util.Command(command)
 	Ck(err)
-	var args []string
-	var cmd string
-	if len(parts) > 1 {
-		cmd = parts[0]
-		args = parts[1:]
-	} else {
-		cmd = parts[0]
-	}
-	// create the command
-	cobj := exec.Command(cmd, args...)
 
 	// create a tee for stdout
 	stdoutPipe, err := cobj.StdoutPipe()
@@ -57,32 +46,23 @@ func RunTee(command string) (stdout, stderr []byte, rc int, err error) {
 	// start the command
 	err = cobj.Start()
 	Ck(err)
+	// wait for the goroutines to read all the output; Wait closes
+	// the pipes, so it must come after
+	wg.Wait()
 	// wait for the command to finish
 	err = cobj.Wait()
 	Ck(err)
 	// get the return code
 	rc = cobj.ProcessState.ExitCode()
-	// wait for the goroutines to finish
-	wg.Wait()
 	return
 }
 
 // Run runs a command in the shell, returning stdout, stderr, and rc
 func Run(command string, stdin []byte) (stdout, stderr []byte, rc int, err error) {
 	defer Return(&err)
-	// shlex the command to get the command and args
-	parts, err := shlex.Split(command)
+	// create the command, split the way the platform's shell would
+	cobj, err := util.Command(command)
 	Ck(err)
-	var args []string
-	var cmd string
-	if len(parts) > 1 {
-		cmd = parts[0]
-		args = parts[1:]
-	} else {
-		cmd = parts[0]
-	}
-	// create the command
-	cobj := exec.Command(cmd, args...)
 	// create a pipe for stdin
 	stdinPipe, err := cobj.StdinPipe()
 	Ck(err)
@@ -119,32 +99,25 @@ func Run(command string, stdin []byte) (stdout, stderr []byte, rc int, err error
 		Ck(err)
 		wg.Done()
 	}()
+	// wait for the goroutines to read all the output; Wait closes
+	// the pipes, so it must come after
+	wg.Wait()
 	// wait for the command to finish
 	err = cobj.Wait()
 	Ck(err)
 	// get the return code
 	rc = cobj.ProcessState.ExitCode()
-	// wait for the goroutines to finish
-	wg.Wait()
 	return
 }
 
-// RunInteractive runs a command in the shell, with stdio connected to the terminal
-func RunInteractive(command string) (rc int, err error) {
+// RunInteractive runs a command in the shell, with stdio connected to
+// the terminal.  Any args are appended to the command as is, without
+// further splitting, e.g. a file name that contains spaces.
+func RunInteractive(command string, args ...string) (rc int, err error) {
 	defer Return(&err)
-	// shlex the command to get the command and args
-	parts, err := shlex.Split(command)
+	// create the command, split the way the platform's shell would
+	cobj, err := util.Command(command, args...)
 	Ck(err)
-	var args []string
-	var cmd string
-	if len(parts) > 1 {
-		cmd = parts[0]
-		args = parts[1:]
-	} else {
-		cmd = parts[0]
-	}
-	// create the command
-	cobj := exec.Command(cmd, args...)
 	// connect the stdio to the terminal
 	cobj.Stdin = os.Stdin
 	cobj.Stdout = os.Stdout
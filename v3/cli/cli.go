@@ -1,20 +1,31 @@
 package cli
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/stevegt/grokker/v3/core"
 
 	"github.com/alecthomas/kong"
-	"github.com/anmitsu/go-shlex"
 	"github.com/gofrs/flock"
 	. "github.com/stevegt/goadapt"
 	"github.com/stevegt/grokker/v3/aidda"
+	"github.com/stevegt/grokker/v3/serve"
+	"github.com/stevegt/grokker/v3/tui"
 	"github.com/stevegt/grokker/v3/util"
 )
 
@@ -99,20 +110,219 @@ It should correctly interpret `test` as a required `Chatfile`, rather than an un
 
 */
 
+// cmdAction is the struct for the action subcommand, which runs grok
+// as a step in a GitHub Actions workflow; see core/ghaction.go.
+type cmdAction struct {
+	Index  struct{} `cmd:"" help:"Add the text files git tracks to the knowledge base and refresh it."`
+	Answer struct {
+		Trigger string   `default:"/grok" help:"Only answer comments that start with this; issues are always answered."`
+		Also    []string `enum:"reopened,edited" help:"Also answer issues that are reopened, or issues and comments that are edited; new issues and comments are always answered."`
+	} `cmd:"" help:"Answer the question in the comment or issue that triggered the workflow."`
+	Review struct {
+		Base string `help:"Review the changes since this revision (default: the pull request's base)."`
+		Head string `help:"Review the changes up to this revision (default: the pull request's head)."`
+	} `cmd:"" help:"Review the changes in the pull request that triggered the workflow."`
+	Publish struct {
+		File string `arg:"" help:"File to export the knowledge base to, for a later step to upload."`
+		Key  string `short:"k" env:"GROKKER_SIGNING_KEY" help:"Minisign secret key file; if set, the export is signed to FILE.minisig."`
+	} `cmd:"" help:"Export the knowledge base, optionally signed, to publish as an artifact or release asset."`
+}
+
+// cmdActions is the struct for the actions subcommand, which
+// extracts decisions and action items from recent meeting notes.
+type cmdActions struct {
+	Since      string   `default:"2w" help:"Only use notes dated since this time, e.g. 2w, 3d, 36h, or 2024-03-01."`
+	Collection []string `help:"Look for notes in this collection (repeatable; default: notes, transcripts, and meetings)."`
+	List       bool     `help:"Just list the notes and their dates; makes no requests."`
+}
+
 type cmdAdd struct {
-	Paths []string `arg:"" type:"string" help:"Path to file to add to knowledge base."`
+	Paths      []string `arg:"" type:"string" help:"Path to file, or http(s) URL of web page, to add to knowledge base."`
+	Batch      bool     `short:"b" help:"Create embeddings via the OpenAI Batch API (cheaper, but can take up to 24 hours); see 'grok batch status'."`
+	Collection string   `help:"Put the files in this collection; see 'grok collections'."`
+	Tag        []string `help:"Give the files this access tag, e.g. public, internal, or secret (repeatable); replaces any existing tags."`
 }
 
 type cmdAidda struct {
 	Subcommands []string `arg:"" type:"string" help:"AIDDA operation(s): init, commit, prompt"`
 }
 
+// cmdAsk is the struct for the ask subcommand, which answers a
+// question about command output piped to stdin.
+type cmdAsk struct {
+	Question   string   `arg:"" help:"Question about the output, e.g. \"why did this fail?\"."`
+	Collection []string `help:"Only use context from this collection (repeatable), in addition to the output."`
+	K          int      `short:"k" help:"Use at most this many chunks of context from each of the output and the knowledge base."`
+	CtxTokens  int      `name:"context-tokens" help:"Use up to this many tokens of context instead of half the model's token limit."`
+
+	profileFlags `embed:""`
+}
+
+// profileFlags select an answer profile; see core.Profile.
+type profileFlags struct {
+	Brief   bool   `xor:"profile" help:"Give a short answer from a little context; same as --profile brief."`
+	Normal  bool   `xor:"profile" help:"Use the default context and answer length; same as --profile normal."`
+	Deep    bool   `xor:"profile" help:"Give a thorough answer from as much context as fits; same as --profile deep."`
+	Profile string `xor:"profile" help:"Answer with this profile, setting -k, --context-tokens, and the answer length together; profiles can be added in the config file."`
+}
+
+// name returns the name of the selected profile, if any.
+func (f profileFlags) name() string {
+	switch {
+	case f.Brief:
+		return "brief"
+	case f.Normal:
+		return "normal"
+	case f.Deep:
+		return "deep"
+	}
+	return f.Profile
+}
+
+// cmdAudit is the struct for the audit subcommand, which reviews the
+// audit log of model requests; see the policy settings in the config
+// file.
+type cmdAudit struct {
+	Show struct {
+		Last    int  `short:"n" help:"Show only the last N entries."`
+		Content bool `short:"c" help:"Show the logged request and response content."`
+	} `cmd:"" default:"1" help:"Show the audit log."`
+	Verify struct{} `cmd:"" help:"Check the audit log for missing or modified entries."`
+}
+
 type cmdBackup struct{}
 
+// cmdBoost is the struct for the boost subcommand, which sets the
+// boost of documents in bulk.
+type cmdBoost struct {
+	Pattern string  `arg:"" help:"Documents to boost, as a .gitignore-style pattern, e.g. 'docs/runbooks/**'; quote it so the shell doesn't expand it."`
+	Boost   float64 `arg:"" help:"Amount added to the similarity score of the documents' chunks, e.g. 0.05; negative demotes them, 0 removes the boost."`
+	DryRun  bool    `short:"n" help:"List the documents that would change, without changing them."`
+}
+
+// cmdCompare is the struct for the compare subcommand, which
+// answers the same questions under different configurations.
+type cmdCompare struct {
+	Config []string `required:"" help:"Configuration file to compare (repeat for each); see 'grok compare --help'."`
+	File   string   `short:"f" required:"" help:"File of questions, one per line."`
+}
+
+// cmdEval is the struct for the eval subcommand, which replays the
+// retrieval for rated questions in the question log.
+type cmdEval struct{}
+
+// cmdFeedback is the struct for the feedback subcommand, which rates
+// an answer in the question log; see 'grok questions'.
+type cmdFeedback struct {
+	ID     int    `arg:"" help:"Question number, from 'grok questions'."`
+	Rating string `arg:"" enum:"good,bad" help:"good or bad."`
+	Note   string `help:"Why the answer was good or bad."`
+}
+
+// cmdBench is the struct for the bench subcommand, which prints
+// benchmark results as JSON.
+type cmdBench struct {
+	Sizes []int  `default:"1000,10000" help:"Corpus sizes, in chunks, for the similarity search benchmark."`
+	Dim   int    `help:"Embedding dimension of the synthetic corpus (default: the knowledge base's, or 1536)."`
+	Query string `help:"Also measure retrieval and end-to-end latency of this question against the knowledge base; makes provider requests."`
+	Runs  int    `default:"3" help:"Number of times to ask --query."`
+}
+
+// cmdBenchModel is the struct for the bench-model subcommand, which
+// compares chat models on the questions in a file.
+type cmdBenchModel struct {
+	Models   []string `arg:"" help:"Chat models to compare, e.g. gpt-4o gpt-4o-mini; see 'grok models'."`
+	File     string   `short:"f" required:"" help:"File of questions, one per line."`
+	Judge    string   `help:"Model that scores the answers from 1 to 5 (default: the knowledge base's model)."`
+	MinScore float64  `name:"min-score" default:"4" help:"Lowest mean score that counts as good enough when recommending the cheapest model."`
+}
+
+// cmdCache is the struct for the cache subcommand, which runs the
+// cache daemon that every grok process of the user shares; see
+// core/cached.go.
+type cmdCache struct {
+	Serve struct{} `cmd:"" help:"Serve the embedding and response caches on a unix socket until interrupted, evicting the least recently used entries to keep each cache under its cap."`
+	Stats struct{} `cmd:"" help:"Show the entries, size, and cap of each cache, and the daemon's hit and eviction counts if it is running."`
+	Prune struct{} `cmd:"" help:"Evict the least recently used entries of each cache until it is under its cap, without the daemon."`
+}
+
+// cmdChunk is the struct for the chunk subcommand, which prints a
+// chunk by its ID.
+type cmdChunk struct {
+	ID string `arg:"" help:"Chunk ID, or a unique prefix of 8 or more characters."`
+}
+
+// cmdDigest is the struct for the digest subcommand, which writes a
+// team digest of what changed in the knowledge base.
+type cmdDigest struct {
+	Since string `default:"1w" help:"Summarize changes since this git revision or time, e.g. v1.2.0, HEAD~20, 1w, or 2024-03-01."`
+	To    string `help:"Write the digest to this file instead of stdout."`
+}
+
+// cmdDrift is the struct for the drift subcommand, which checks
+// whether the embedding model has changed since the chunks were
+// embedded.
+type cmdDrift struct {
+	Sample    int     `short:"n" default:"50" help:"Number of chunks to re-embed."`
+	Threshold float64 `default:"0.99" help:"Report chunks whose fresh embedding is less similar than this to the stored one."`
+}
+
+// cmdDups is the struct for the dups subcommand, which reports
+// near-duplicate passages across documents.
+type cmdDups struct {
+	Threshold float64 `help:"Report passages whose embeddings are at least this similar (default: the pipeline's dedup threshold, or 0.97)."`
+}
+
+// cmdBatch is the struct for the batch subcommand, which manages
+// embedding jobs submitted with 'grok add --batch'.
+type cmdBatch struct {
+	Status struct{} `cmd:"" help:"Poll pending batch jobs and merge any completed embeddings into the knowledge base."`
+}
+
 // cmdChat is the struct for the chat subcommand.  The chat subcommand
 // is used to have a conversation with the knowledge base using
-// a chat history stored in a local file.
+// a chat history stored in a local file.  Send is the default, so
+// 'grok chat <chat-file>' still works; the other subcommands manage
+// forks of a chat history.
 type cmdChat struct {
+	Send cmdChatSend `cmd:"" default:"withargs" help:"Send a prompt and add the response to the chat history; the default."`
+	Fork struct {
+		ChatFile string `arg:"" help:"Chat history file to fork."`
+		At       int    `required:"" help:"Number of turns to keep; a turn is a prompt and its response."`
+		Output   string `short:"o" help:"Name of the fork; default is the chat file's name with a .branch-N suffix."`
+	} `cmd:"" help:"Copy the first turns of a chat history to a new chat file, to explore another line of questioning."`
+	Branches struct {
+		ChatFile string `arg:"" help:"Chat history file whose forks to list."`
+	} `cmd:"" help:"List the forks of a chat history, and their forks."`
+	Prune struct {
+		ChatFile string   `arg:"" help:"Chat history file whose forks to remove."`
+		Branches []string `arg:"" optional:"" help:"Forks to remove, with their own forks; default all."`
+	} `cmd:"" help:"Remove forks of a chat history."`
+	Scrub struct {
+		Dir         string `arg:"" optional:"" default:"." help:"Directory to look for chat files in, recursively."`
+		RedactAfter string `help:"Redact the policy's redact patterns in chat files older than this, e.g. 7d (default from \"chat: redact_after:\" in the config file)."`
+		ExpireAfter string `help:"Delete chat files older than this, e.g. 90d (default from \"chat: expire_after:\" in the config file)."`
+		Encrypt     bool   `help:"Encrypt plain chat files (default from \"chat: encrypt:\" in the config file)."`
+		DryRun      bool   `short:"n" help:"Only list what would be done."`
+	} `cmd:"" help:"Redact, delete, or encrypt old chat files, following the chat policy."`
+	Session struct {
+		Name      string `arg:"" optional:"" default:"default" help:"Name of the session to start, or to resume if it exists."`
+		Sysmsg    string `short:"s" help:"System message for the session, replacing a resumed session's."`
+		NoAddToDb bool   `short:"D" help:"Do not add the session's chat history to the knowledge base."`
+	} `cmd:"" help:"Chat interactively, with context from the knowledge base for every prompt; the session is saved by name after every turn.  Enter /exit or end the input to quit."`
+	Sessions struct{} `cmd:"" help:"List the saved chat sessions, most recently used first."`
+	Export   struct {
+		ChatFile string `arg:"" help:"Chat history file to export."`
+		Format   string `short:"f" default:"md" enum:"md,json" help:"Transcript format: md or json."`
+	} `cmd:"" help:"Write a transcript of a chat history, with citations, to stdout."`
+	Import struct {
+		ChatFile  string `arg:"" help:"Chat history file to create."`
+		NoAddToDb bool   `short:"D" help:"Do not add the chat history file to the knowledge base."`
+	} `cmd:"" help:"Create a chat history file from a md or json transcript on stdin."`
+}
+
+// cmdChatSend is the struct for the chat send subcommand.
+type cmdChatSend struct {
 	// grok chat -s sysmsg memoryfile < prompt
 	Sysmsg           string   `name:"sysmsg" short:"s" default:"" help:"System message to send to control behavior of openAI's API."`
 	ContextRepo      bool     `short:"C" help:"Add context from the entire grokker repository (includes chat file)."`
@@ -128,8 +338,11 @@ type cmdChat struct {
 	ChatFile         string   `arg:"" required:"" help:"File to store the chat history -- by default the tail is used for context."`
 	PromptTokenLimit int      `short:"P" help:"Override the default prompt token limit."`
 	NoAddToDb        bool     `short:"D" help:"Do not add the chat history file to the knowledge base."`
+	Attach           []string `help:"Use this file as context for this prompt only, without adding it to the knowledge base (repeatable); ignored with -N."`
 }
 
+type cmdCollections struct{}
+
 type cmdCommit struct {
 	Diffargs []string `arg:"" optional:"" type:"string" help:"Arguments to pass to git diff.  If not provided, defaults to '--staged'."`
 }
@@ -138,17 +351,49 @@ type cmdCtx struct {
 	Tokenlimit      int  `arg:"" type:"int" help:"Maximum number of tokens to include in the context."`
 	WithHeaders     bool `short:"h" help:"Include filename headers in the context."`
 	WithLineNumbers bool `short:"n" help:"Include line numbers in the context."`
+	K               int  `short:"k" help:"Include at most this many chunks."`
 }
 
 type cmdEmbed struct{}
 
+// cmdFix is the struct for the fix subcommand, which diagnoses an
+// error and suggests a patch.
+type cmdFix struct {
+	Error string `arg:"" optional:"" help:"Compiler or runtime error message; read from stdin if not given."`
+	Aidda bool   `help:"Write the diagnosis and patch to the aidda prompt file, with the files the error names as its In and Out files, so 'grok aidda generate' applies the fix."`
+}
+
 type cmdForget struct {
 	Paths []string `arg:"" type:"string" help:"Path to file to remove from knowledge base."`
 }
 
-type cmdInit struct{}
+type cmdInit struct {
+	Store string `default:"json" help:"Where to store the chunks: json, in the .grok file, sqlite, as rows of .grok.sqlite, which saves faster in large repositories, or the name of a grok-store-<name> plugin; sqlite needs a grok built with '-tags sqlite'."`
+}
+
+type cmdLs struct {
+	Forgotten bool `help:"List forgotten documents and whether their content has been purged."`
+}
 
-type cmdLs struct{}
+// cmdHook is the struct for the hook subcommand, which manages the
+// index hooks that transform content before it is chunked.
+type cmdHook struct {
+	Ls  struct{} `cmd:"" default:"1" help:"List the index hooks, in the order they run, marking those that aren't trusted."`
+	Add struct {
+		Pattern string `arg:"" help:"Documents to transform, as a .gitignore-style pattern, e.g. '*.go' or 'docs/**/*.tmpl'."`
+		Command string `arg:"" help:"Command that reads the content on stdin and writes the content to index on stdout, or strip-license."`
+	} `cmd:"" help:"Add an index hook; run 'grok refresh' to apply it."`
+	Rm struct {
+		Pattern string `arg:"" help:"Pattern of the hooks to remove."`
+	} `cmd:"" help:"Remove the index hooks with the given pattern; run 'grok refresh' to apply the change."`
+	Trust struct{} `cmd:"" help:"Trust the knowledge base's index hooks to run on this machine; review them with 'grok hook ls' first."`
+}
+
+// cmdPurge is the struct for the purge subcommand, which erases the
+// content of forgotten documents.
+type cmdPurge struct {
+	Paths []string `arg:"" optional:"" help:"Forgotten documents to purge (default: all of them)."`
+}
 
 type cmdModels struct{}
 
@@ -160,54 +405,370 @@ type cmdMsg struct {
 	Sysmsg string `arg:"" help:"System message to send to control behavior of openAI's API."`
 }
 
+// cmdPlugins is the struct for the plugins subcommand, which lists
+// the plugins found on PATH.
+type cmdPlugins struct{}
+
+// cmdPipeline is the struct for the pipeline subcommand, which shows
+// or changes the retrieval settings stored in the knowledge base.
+type cmdPipeline struct {
+	Show struct{} `cmd:"" default:"1" help:"Show the pipeline settings."`
+	Set  struct {
+		Name  string `arg:"" help:"Setting name, e.g. prefilter."`
+		Value string `arg:"" help:"New value; use '' to clear a setting."`
+	} `cmd:"" help:"Change a pipeline setting."`
+	Load struct {
+		Name string `arg:"" help:"Pipeline file name or path."`
+	} `cmd:"" help:"Replace the pipeline settings, and the index hooks if the file has a chunker stage, with those of a pipeline file."`
+	Export struct{} `cmd:"" help:"Write the pipeline settings and index hooks to stdout as a pipeline file."`
+	Files  struct{} `cmd:"" help:"List the pipeline files that can be used by name."`
+}
+
+// cmdPolicy is the struct for the policy subcommand, which shows the
+// guardrails for outgoing requests and what they caught; see the
+// policy settings in the config file.
+type cmdPolicy struct {
+	Show struct{} `cmd:"" default:"1" help:"Show the policy rules in effect, from the config file and .grok-policy.yaml."`
+	Log  struct {
+		Last       int  `short:"n" help:"Show only the last N entries."`
+		Violations bool `help:"Show only violations and the decisions on them."`
+	} `cmd:"" help:"Show the policy log of violations, redactions, and dropped context."`
+}
+
+type cmdPut struct {
+	Name       string   `arg:"" help:"Name of the virtual document, e.g. 'api-spec' or a URL."`
+	Collection string   `help:"Put the document in this collection; see 'grok collections'."`
+	Tag        []string `help:"Give the document this access tag, e.g. public, internal, or secret (repeatable); replaces any existing tags."`
+}
+
 type cmdQ struct {
-	Question string `arg:"" help:"Question to ask the knowledge base."`
+	Question   string   `arg:"" optional:"" help:"Question to ask the knowledge base; with --compare-last, defaults to the last question asked."`
+	AsOf       string   `name:"as-of" help:"Ask the named snapshot instead of the current knowledge base; see 'grok snapshot'."`
+	Symbol     []string `help:"Only use context that defines or mentions this symbol (repeatable); run 'grok refresh' to index symbols in older databases."`
+	Collection []string `help:"Only use context from this collection (repeatable).  Without this, the pipeline router, if any, picks collections."`
+	K          int      `short:"k" help:"Use at most this many chunks of context."`
+	CtxTokens  int      `name:"context-tokens" help:"Use up to this many tokens of context instead of half the model's token limit."`
+	Tag        []string `help:"Only use context from documents whose access tags are all among these tags (repeatable); untagged documents are always used."`
+	Label      []string `help:"Only use context from markdown documents with this tag in their frontmatter (repeatable)."`
+	Owner      []string `help:"Only use context from markdown documents with this owner in their frontmatter (repeatable)."`
+	Only       []string `help:"Only use context in these languages, e.g. go,markdown, or natural languages such as de; run 'grok refresh' to tag older databases."`
+	Buffer     []string `help:"Use the content of FILE in place of the document at PATH, e.g. an editor's unsaved buffer, given as PATH=FILE (repeatable)."`
+	Path       []string `help:"Only use context from documents under this path, relative to the repository root (repeatable); only the shards under it are loaded, see 'grok shard'."`
+	Decompose  bool     `help:"Split a compound question into parts, answer each from its own context, and combine the answers; 'grok pipeline set decompose true' does this for every question."`
+	Suggest    bool     `help:"Suggest follow-up questions after the answer."`
+	Verify     bool     `help:"Check each claim in the answer against the sources and note the unsupported ones; costs another request."`
+	Compare    bool     `name:"compare-last" help:"Ask the question again, with the filter it was asked with, and show how the answer differs from the last time it was asked, e.g. after re-indexing or switching models."`
+	Persona    string   `help:"Answer as this persona, e.g. security, techwriter, or sre; personas can be added in the config file."`
+	Attach     []string `help:"Use this file as context for this question only, without adding it to the knowledge base (repeatable)."`
+	Budget     float64  `help:"Keep the estimated cost of the answer under this many dollars, e.g. 0.02, by skipping verification and other extra stages, cutting the context, and using a cheaper model, as needed; what was given up goes to stderr."`
+	NoStream   bool     `name:"no-stream" help:"Print the answer once it is complete instead of as it arrives."`
+	NoCorrect  bool     `name:"no-correct" help:"Search for the question as asked, without correcting misspelled symbols, e.g. FindChunk to FindChunks."`
+
+	profileFlags `embed:""`
+}
+
+// streamTo returns where to stream the answer as it arrives, or nil
+// to print it once complete: JSON output and comparisons need the
+// whole answer.
+func (c *cmdQ) streamTo(ci bool, prev *core.Question, stdout io.Writer) io.Writer {
+	if c.NoStream || ci || prev != nil {
+		return nil
+	}
+	return stdout
 }
 
 type cmdQc struct{}
 
-type cmdQi struct{}
+// cmdQuestions is the struct for the questions subcommand, which
+// lists the question log.
+type cmdQuestions struct {
+	Last    int  `short:"n" default:"20" help:"Show only the last N questions; 0 shows all of them."`
+	Content bool `short:"c" help:"Show the sources and answer of each question."`
+}
+
+type cmdQi struct {
+	Suggest bool `help:"Suggest follow-up questions after the answer."`
+}
 
 type cmdQr struct {
 	SysMsg bool `short:"s" help:"expect sysmsg in first paragraph of stdin, return same on stdout."`
 }
 
-type cmdRefresh struct{}
+// cmdRetag is the struct for the retag subcommand, which replaces
+// the access tags of documents in bulk.
+type cmdRetag struct {
+	Pattern string   `arg:"" help:"Documents to retag, as a .gitignore-style pattern, e.g. 'docs/archive/**'; quote it so the shell doesn't expand it."`
+	Tag     []string `help:"Give the documents this access tag, e.g. public, internal, or secret (repeatable); replaces their tags."`
+	Clear   bool     `help:"Remove the documents' access tags, opening them to every query."`
+	DryRun  bool     `short:"n" help:"List the documents that would change, without changing them."`
+}
+
+// cmdRm is the struct for the rm subcommand, which forgets
+// documents in bulk.
+type cmdRm struct {
+	Pattern string `arg:"" help:"Documents to forget, as a .gitignore-style pattern, e.g. 'docs/archive/**'; quote it so the shell doesn't expand it."`
+	DryRun  bool   `short:"n" help:"List the documents that would be forgotten, without forgetting them."`
+}
+
+type cmdRefresh struct {
+	Reembed bool `help:"Discard all embeddings and re-create them with the current embedder, e.g. after changing GROKKER_EMBEDDER."`
+}
+
+// cmdServe is the struct for the serve subcommand, which shares the
+// knowledge base over HTTP; see the serve package.
+type cmdServe struct {
+	Listen string   `default:"localhost:7070" help:"Address to listen on."`
+	NoAuth bool     `help:"Give every client full access without an API token."`
+	Run    struct{} `cmd:"" default:"1" help:"Serve the knowledge base, and any tenants in the serve config, until interrupted."`
+	Token  struct{} `cmd:"" help:"Generate an API token and print the config entry for it."`
+}
+
+// cmdShard is the struct for the shard subcommand, which keeps the
+// chunks of subtrees in their own files; see core/shard.go.
+// cmdExclude is the struct for the exclude subcommand, which keeps
+// documents in the knowledge base but out of the context.
+type cmdExclude struct {
+	Ls  struct{} `cmd:"" default:"1" help:"List the excluded documents and why they are excluded."`
+	Add struct {
+		Paths  []string `arg:"" help:"Documents to exclude."`
+		Reason string   `help:"Why, e.g. archived or superseded by docs/v2.md."`
+	} `cmd:"" help:"Never use documents as context, but keep them in the knowledge base."`
+	Rm struct {
+		Paths []string `arg:"" help:"Documents to use as context again."`
+	} `cmd:"" help:"Use excluded documents as context again."`
+}
+
+type cmdShard struct {
+	Ls  struct{} `cmd:"" default:"1" help:"List the shards."`
+	Add struct {
+		Dir string `arg:"" type:"existingdir" help:"Subtree to keep in its own shard file, e.g. services/billing."`
+	} `cmd:"" help:"Keep the chunks of a subtree in their own file under .grok.d/, loaded only when needed."`
+	Rm struct {
+		Dir string `arg:"" help:"Subtree whose chunks go back into the knowledge base file."`
+	} `cmd:"" help:"Stop keeping a subtree in its own shard file."`
+}
 
 type cmdSimilarity struct {
 	Refpath string   `arg:"" help:"Reference file path."`
 	Paths   []string `arg:"" help:"Files to compare to reference file."`
 }
 
-type cmdTc struct{}
+// cmdDb is the struct for the db subcommand, which manages the
+// registry of named knowledge bases used by --db.
+type cmdDb struct {
+	List struct{} `cmd:"" default:"1" help:"List the registered knowledge bases; * marks the current one."`
+	Add  struct {
+		Name string `arg:"" help:"Name for the knowledge base."`
+		Path string `arg:"" optional:"" default:"." help:"The .grok file or the directory containing it."`
+	} `cmd:"" help:"Register a knowledge base under a name."`
+	Use struct {
+		Name string `arg:"" help:"Name of a registered knowledge base."`
+	} `cmd:"" help:"Use the named knowledge base when there is no .grok file in the current or any parent directory."`
+	Rm struct {
+		Name string `arg:"" help:"Name of a registered knowledge base."`
+	} `cmd:"" help:"Forget a registered name; the knowledge base itself is untouched."`
+}
+
+// cmdExport is the struct for the export subcommand, which writes
+// the knowledge base to a single file for distribution.
+type cmdExport struct {
+	File string `arg:"" help:"File to write."`
+	Key  string `short:"k" env:"GROKKER_SIGNING_KEY" help:"Minisign secret key file; if set, the export is signed to FILE.minisig."`
+}
+
+// cmdHydrate is the struct for the hydrate subcommand, which builds
+// the index of a new clone from a committed seed.
+type cmdHydrate struct {
+	File string `arg:"" optional:"" help:"Seed file (default .grok-seed.json)."`
+}
+
+// cmdMigrate is the struct for the migrate subcommand, which
+// upgrades a knowledge base saved by an older grokker.
+type cmdMigrate struct{}
+
+// cmdImport is the struct for the import subcommand, which creates a
+// .grok file in the current directory from an export.
+type cmdImport struct {
+	File     string   `arg:"" help:"Exported knowledge base."`
+	PubKey   []string `short:"p" help:"Accept signatures by this minisign public key, as a file or a base64 string, in addition to trusted_keys in the config file."`
+	Unsigned bool     `help:"Import without checking a signature."`
+}
+
+// cmdKeygen is the struct for the keygen subcommand, which creates a
+// minisign key pair for signing exports.
+type cmdKeygen struct {
+	Name string `arg:"" help:"Writes NAME.key and NAME.pub."`
+}
+
+// cmdSnapshot is the struct for the snapshot subcommand, which
+// freezes the knowledge base so it can be queried later with
+// 'grok q --as-of'.
+type cmdSnapshot struct {
+	Create struct {
+		Name string `arg:"" help:"Snapshot name, e.g. a release tag."`
+	} `cmd:"" help:"Save a snapshot of the knowledge base, including the current text of all documents."`
+	List struct{} `cmd:"" help:"List snapshots."`
+}
+
+// cmdStoplist is the struct for the stoplist subcommand, which shows
+// the boilerplate chunks that are excluded from context.
+type cmdStoplist struct {
+	List  struct{} `cmd:"" default:"1" help:"List the chunks on the stop-list."`
+	Allow struct {
+		Hash string `arg:"" help:"Hash (or unique prefix) of the chunk, as shown by 'grok stoplist'."`
+	} `cmd:"" help:"Take a chunk off the stop-list so it can be used as context."`
+}
+
+// cmdSaveSeed is the struct for the save-seed subcommand, which
+// writes a seed for 'grok hydrate'.
+type cmdSaveSeed struct {
+	File string `arg:"" optional:"" help:"File to write (default .grok-seed.json in the root of the knowledge base)."`
+}
+
+// cmdReport is the struct for the report subcommand, which writes a
+// markdown report on the content and health of the knowledge base.
+type cmdReport struct {
+	NoDups    bool    `help:"Don't look for duplicate passages, which takes a while on a large knowledge base."`
+	Threshold float64 `help:"Report passages whose embeddings are at least this similar as duplicates (default: the pipeline's dedup threshold, or 0.97)."`
+	To        string  `help:"Write the report to this file instead of stdout."`
+}
+
+// cmdStaleDocs is the struct for the stale-docs subcommand, which
+// reports documentation that mentions names the code no longer has.
+type cmdStaleDocs struct{}
+
+// cmdTc is the struct for the tc subcommand, which counts tokens in
+// stdin or in files and estimates what they would cost.
+type cmdTc struct {
+	Paths []string `arg:"" optional:"" help:"Files or directories to count, or - for stdin.  With none, print just the count of stdin."`
+}
+
+// cmdTodos is the struct for the todos subcommand, which turns the
+// TODO comments in the knowledge base into a work list.
+type cmdTodos struct {
+	List bool `help:"Just list the comments, without grouping or prioritizing them; makes no requests."`
+}
+
+// cmdTune is the struct for the tune subcommand, which suggests
+// exclusions and boosts from the question log.
+type cmdTune struct {
+	Suggest struct {
+		Apply        bool `help:"Apply the suggestions: boost documents, and add the excluded ones to .grokignore and forget them."`
+		MinRetrieved int  `default:"5" help:"Only judge documents by their citations once they were retrieved for this many questions."`
+	} `cmd:"" help:"Suggest documents to exclude or boost, from how often they are retrieved and cited in good answers."`
+}
+
+// cmdTui is the struct for the tui subcommand, which browses the
+// passages retrieved for questions and their answers in a terminal
+// UI; see the tui package.
+type cmdTui struct {
+	Question string `arg:"" optional:"" help:"Question to ask first."`
+}
 
 type cmdVersion struct{}
 
+// cmdVerify checks the chunk store against its checksums.
+type cmdVerify struct{}
+
+// cmdInjections runs the prompt-injection detector over the
+// knowledge base; see core/injection.go.
+type cmdInjections struct{}
+
+// cmdStore is the struct for the store subcommand, which shows or
+// changes where the chunks are stored.
+type cmdStore struct {
+	Kind string `arg:"" optional:"" default:"" help:"Move the chunks to this store, json, sqlite, or the name of a grok-store-<name> plugin; without it, show the current store."`
+}
+
+// cmdStatus shows whether the providers have been healthy lately,
+// along with the current model and the state of the database.
+type cmdStatus struct{}
+
 var cli struct {
-	Add           cmdAdd        `cmd:"" help:"Add a file to the knowledge base."`
-	Aidda         cmdAidda      `cmd:"" help:"Perform AIDDA operations."`
-	Backup        cmdBackup     `cmd:"" help:"Backup the knowledge base."`
-	Chat          cmdChat       `cmd:"" help:"Have a conversation with the knowledge base; accepts prompt on stdin."`
-	Commit        cmdCommit     `cmd:"" help:"Generate a git commit message on stdout."`
-	Ctx           cmdCtx        `cmd:"" help:"Extract the context from the knowledge base most closely related to stdin."`
-	Embed         cmdEmbed      `cmd:"" help:"print the embedding vector for the given stdin text."`
-	Forget        cmdForget     `cmd:"" help:"Forget about a file, removing it from the knowledge base."`
-	Global        bool          `short:"g" help:"Include results from OpenAI's global knowledge base as well as from local documents."`
-	Init          cmdInit       `cmd:"" help:"Initialize a new .grok file in the current directory."`
-	Ls            cmdLs         `cmd:"" help:"List all documents in the knowledge base."`
-	ModelOverride string        `name:"model" help:"Model to use during this execution (not persistent)."`
-	Model         cmdModel      `cmd:"" help:"Upgrade the model used by the knowledge base (persistent)."`
-	Models        cmdModels     `cmd:"" help:"List all available models."`
-	Msg           cmdMsg        `cmd:"" help:"Send message to openAI's API from stdin and print response on stdout."`
-	Q             cmdQ          `cmd:"" help:"Ask the knowledge base a question."`
-	Qc            cmdQc         `cmd:"" help:"Continue text from stdin based on the context in the knowledge base."`
-	Qi            cmdQi         `cmd:"" help:"Ask the knowledge base a question on stdin."`
-	Qr            cmdQr         `cmd:"" help:"Revise stdin based on the context in the knowledge base."`
-	Refresh       cmdRefresh    `cmd:"" help:"Refresh the embeddings for all documents in the knowledge base."`
-	Similarity    cmdSimilarity `cmd:"" help:"Calculate the similarity between two or more files in the knowledge base."`
-	Tc            cmdTc         `cmd:"" help:"Calculate the token count of stdin."`
-	Verbose       bool          `short:"v" help:"Show debug and progress information on stderr."`
-	Version       cmdVersion    `cmd:"" help:"Show version of grok and its database."`
+	Action        cmdAction      `cmd:"" help:"Run as a GitHub Actions step: index the checkout, answer a comment, review a pull request, or publish the index, with results as step outputs."`
+	Actions       cmdActions     `cmd:"" help:"Extract decisions and action items, with owners and citations, from recent meeting notes and transcripts."`
+	Add           cmdAdd         `cmd:"" help:"Add a file to the knowledge base."`
+	Aidda         cmdAidda       `cmd:"" help:"Perform AIDDA operations."`
+	Ask           cmdAsk         `cmd:"" help:"Answer a question about command output on stdin, e.g. 'make 2>&1 | grok ask \"why did this fail?\"'; the output is indexed only for the question."`
+	Audit         cmdAudit       `cmd:"" help:"Review the audit log of requests sent to models."`
+	Backup        cmdBackup      `cmd:"" help:"Backup the knowledge base."`
+	Batch         cmdBatch       `cmd:"" help:"Manage OpenAI Batch API embedding jobs."`
+	Boost         cmdBoost       `cmd:"" help:"Boost or demote the chunks of all documents matching a pattern in retrieval."`
+	Bench         cmdBench       `cmd:"" help:"Run the chunking, search, and query benchmarks and print the results as JSON."`
+	BenchModel    cmdBenchModel  `cmd:"" name:"bench-model" help:"Answer the questions in a file with two or more chat models and compare their quality, latency, and cost in markdown."`
+	Cache         cmdCache       `cmd:"" help:"Share one size-capped embedding and response cache across all knowledge bases through a cache daemon."`
+	CacheDir      string         `name:"cache-dir" help:"Directory for grokker's caches (default $GROKKER_CACHE_DIR, $XDG_CACHE_HOME/grokker, or the platform's user cache directory)."`
+	RespCache     bool           `name:"cache-responses" help:"Reuse cached model responses to identical requests."`
+	Chat          cmdChat        `cmd:"" help:"Have a conversation with the knowledge base; accepts prompt on stdin."`
+	CI            bool           `name:"ci" env:"GROKKER_CI" help:"Never prompt or open an editor, fail instead of reading a terminal, print output and errors as JSON, and exit with a distinct code for each kind of failure."`
+	Chunk         cmdChunk       `cmd:"" help:"Print the chunk with the given ID, e.g. one referenced by an issue or review."`
+	Collections   cmdCollections `cmd:"" help:"List the collections in the knowledge base."`
+	Commit        cmdCommit      `cmd:"" help:"Generate a git commit message on stdout."`
+	Compare       cmdCompare     `cmd:"" help:"Answer the questions in a file under two or more configurations and report the results side by side in markdown."`
+	ConfigDir     string         `name:"config-dir" help:"Directory for grokker's config.yaml (default $GROKKER_CONFIG_DIR, $XDG_CONFIG_HOME/grokker, or the platform's user config directory)."`
+	Ctx           cmdCtx         `cmd:"" help:"Extract the context from the knowledge base most closely related to stdin."`
+	Db            cmdDb          `cmd:"" help:"Manage the registry of named knowledge bases."`
+	DbName        string         `name:"db" env:"GROKKER_DB" help:"Use the knowledge base registered under this name instead of the one in the current directory."`
+	Digest        cmdDigest      `cmd:"" help:"Summarize new documents, changed areas, and notable commits since a revision or time into a markdown team digest; meant for cron or CI."`
+	Drift         cmdDrift       `cmd:"" help:"Re-embed a sample of chunks and warn if the embedding model has drifted since they were embedded."`
+	Dups          cmdDups        `cmd:"" help:"Report near-duplicate passages across documents, e.g. copy-pasted docs or boilerplate code."`
+	Embed         cmdEmbed       `cmd:"" help:"print the embedding vector for the given stdin text."`
+	Eval          cmdEval        `cmd:"" help:"Check that retrieval still finds the sources behind answers rated with 'grok feedback'."`
+	Exclude       cmdExclude     `cmd:"" help:"Keep documents in the knowledge base but never use them as context, e.g. archived or known-wrong docs."`
+	Export        cmdExport      `cmd:"" help:"Export the knowledge base to a single file, optionally signed."`
+	Feedback      cmdFeedback    `cmd:"" help:"Rate the answer to a logged question as good or bad."`
+	Fix           cmdFix         `cmd:"" help:"Diagnose a compiler or runtime error using the files it names and suggest a patch."`
+	Forget        cmdForget      `cmd:"" help:"Forget about a file, removing it from the knowledge base."`
+	Global        bool           `short:"g" help:"Include results from OpenAI's global knowledge base as well as from local documents."`
+	Hook          cmdHook        `cmd:"" help:"Manage the index hooks that transform documents before they are chunked."`
+	Hydrate       cmdHydrate     `cmd:"" help:"Build the knowledge base of a new clone from a committed seed, re-embedding only what the embedding cache doesn't have."`
+	Import        cmdImport      `cmd:"" help:"Create a knowledge base in the current directory from a signed export."`
+	Injections    cmdInjections  `cmd:"" help:"List the chunks that read like instructions to the model, which could hijack answers that use them as context."`
+	Init          cmdInit        `cmd:"" help:"Initialize a new .grok file in the current directory."`
+	Keygen        cmdKeygen      `cmd:"" help:"Create a minisign key pair for signing exports."`
+	Lang          string         `name:"lang" env:"GROKKER_LANG" help:"Answer in this language, e.g. de or pt-BR, keeping code and cited paths as they are (default from \"lang:\" in the config file)."`
+	LowConf       float64        `name:"low-confidence" help:"With --ci, also treat answers whose best source is less similar to the question than this as low-confidence."`
+	Ls            cmdLs          `cmd:"" help:"List all documents in the knowledge base."`
+	Manifest      string         `name:"manifest" placeholder:"FILE" help:"Write a JSON manifest of what the run used, e.g. the model, seed, config, document hashes, and retrieved chunk IDs, to reproduce or explain its answers."`
+	ModelOverride string         `name:"model" help:"Model to use during this execution (not persistent)."`
+	Model         cmdModel       `cmd:"" help:"Upgrade the model used by the knowledge base (persistent)."`
+	Migrate       cmdMigrate     `cmd:"" help:"Upgrade a knowledge base saved by an older grokker, e.g. a v1 .grok file, to the current format, keeping its embeddings; backs it up first and verifies the result."`
+	Models        cmdModels      `cmd:"" help:"List all available models."`
+	Msg           cmdMsg         `cmd:"" help:"Send message to openAI's API from stdin and print response on stdout."`
+	NoCache       bool           `help:"Don't use the embedding or response caches."`
+	Pipeline      cmdPipeline    `cmd:"" help:"Show or change the retrieval pipeline settings of the knowledge base."`
+	PipelineFile  string         `name:"pipeline" placeholder:"NAME" help:"Use this pipeline file for this run instead of the knowledge base's pipeline settings (not persistent); see 'grok pipeline files'."`
+	Plugins       cmdPlugins     `cmd:"" help:"List the grok-<name> subcommands and grok-load-<ext> loaders found on PATH."`
+	Policy        cmdPolicy      `cmd:"" help:"Show the policy for requests sent to providers, and the violations it logged."`
+	Purge         cmdPurge       `cmd:"" help:"Permanently erase the content of forgotten documents from the knowledge base, its snapshots, and the caches."`
+	Put           cmdPut         `cmd:"" help:"Add or update a virtual document with content from stdin, e.g. generated files or command output."`
+	Q             cmdQ           `cmd:"" help:"Ask the knowledge base a question."`
+	Qc            cmdQc          `cmd:"" help:"Continue text from stdin based on the context in the knowledge base."`
+	Qi            cmdQi          `cmd:"" help:"Ask the knowledge base a question on stdin."`
+	Qr            cmdQr          `cmd:"" help:"Revise stdin based on the context in the knowledge base."`
+	Questions     cmdQuestions   `cmd:"" help:"List the questions asked of the knowledge base, with their ratings."`
+	ReadOnly      bool           `env:"GROKKER_READ_ONLY" help:"Never modify the knowledge base; use it as is, e.g. a prebuilt index.  Commands that would modify it fail."`
+	Refresh       cmdRefresh     `cmd:"" help:"Refresh the embeddings for all documents in the knowledge base."`
+	Retag         cmdRetag       `cmd:"" help:"Replace the access tags of all documents matching a pattern."`
+	Report        cmdReport      `cmd:"" help:"Write a markdown report on the knowledge base: chunk sizes, stale documents, duplicates, coverage by directory, and recommendations."`
+	Rm            cmdRm          `cmd:"" help:"Forget all documents matching a pattern, removing them from the knowledge base."`
+	SaveSeed      cmdSaveSeed    `cmd:"" name:"save-seed" help:"Write a seed, the documents, chunk hashes, and settings without embeddings, to commit for 'grok hydrate'."`
+	Seed          *int           `name:"seed" help:"Ask the model to sample deterministically with this seed, as far as the provider supports it."`
+	Serve         cmdServe       `cmd:"" help:"Share the knowledge base over HTTP, with per-collection access for API tokens."`
+	Shard         cmdShard       `cmd:"" help:"Keep the chunks of monorepo subtrees in shard files that queries load only when needed."`
+	Similarity    cmdSimilarity  `cmd:"" help:"Calculate the similarity between two or more files in the knowledge base."`
+	Snapshot      cmdSnapshot    `cmd:"" help:"Create or list snapshots of the knowledge base."`
+	StaleDocs     cmdStaleDocs   `cmd:"" name:"stale-docs" help:"Report API names and flags mentioned in the documentation that the code no longer contains."`
+	Status        cmdStatus      `cmd:"" help:"Show provider health, the current model, database stats, and cache hit rates."`
+	Store         cmdStore       `cmd:"" help:"Show or change where the knowledge base stores its chunks: in the .grok file (json), in an SQLite database (sqlite), or in a store plugin."`
+	Stoplist      cmdStoplist    `cmd:"" help:"Review the boilerplate chunks that are excluded from context."`
+	Tc            cmdTc          `cmd:"" help:"Count the tokens in stdin or in files, with the cost of embedding them or sending them to the --model."`
+	Todos         cmdTodos       `cmd:"" help:"Collect the TODO, FIXME, and XXX comments in the knowledge base into a prioritized work list."`
+	Tune          cmdTune        `cmd:"" help:"Tune retrieval from the question log."`
+	Tui           cmdTui         `cmd:"" help:"Browse the passages retrieved for questions, with their scores and sources, and the answers, in a terminal UI; opens a source in GROKKER_EDITOR at the passage."`
+	Verbose       bool           `short:"v" help:"Show debug and progress information on stderr."`
+	Verify        cmdVerify      `cmd:"" help:"Check the knowledge base for corrupt or missing chunks."`
+	Version       cmdVersion     `cmd:"" help:"Show version of grok and its database."`
 }
 
 // CliConfig contains the configuration for grokker's cli
@@ -245,6 +806,29 @@ func cmdInSlice(cmd string, cmds []string) bool {
 	return util.StringInSlice(first, cmds)
 }
 
+// isCommand returns true if name is one of the parser's subcommands.
+func isCommand(parser *kong.Kong, name string) bool {
+	for _, node := range parser.Model.Children {
+		if node.Name == name || util.StringInSlice(name, node.Aliases) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPlugin runs a subcommand plugin and returns its exit status.
+func runPlugin(path string, args []string, config *CliConfig) (rc int, err error) {
+	cmd := core.PluginCommand(path, args)
+	cmd.Stdin = config.Stdin
+	cmd.Stdout = config.Stdout
+	cmd.Stderr = config.Stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	return
+}
+
 // Cli parses the given arguments and then executes the appropriate
 // subcommand.
 //
@@ -254,6 +838,38 @@ func cmdInSlice(cmd string, cmds []string) bool {
 //
 // XXX note how gitea/tea does this, also uses urfave instead of kong
 func Cli(args []string, config *CliConfig) (rc int, err error) {
+	// with --ci, report errors and what the command printed as
+	// JSON, after Return below has recovered them
+	var ciOut *bytes.Buffer
+	var hookCmd string
+	stdout := config.Stdout
+	defer func() {
+		if !cli.CI {
+			return
+		}
+		switch {
+		case err != nil:
+			rc = ciFail(config.Stderr, core.FailureClass(err), errText(err))
+			err = nil
+		case ciOut != nil:
+			ciPrint(stdout, hookCmd, ciOut.Bytes(), rc)
+		}
+	}()
+	// the post-command hooks run after Return below, so they see
+	// how the command ended; see core/cmdhooks.go
+	var postHooks []string
+	var output bytes.Buffer
+	defer func() {
+		for _, hook := range postHooks {
+			if err != nil || rc != 0 {
+				return
+			}
+			herr := core.RunCommandHook(hook, hookCmd, output.Bytes(), config.Stderr)
+			if herr != nil {
+				rc = fail(config, herr.Error())
+			}
+		}
+	}()
 	defer Return(&err)
 
 	// capture goadapt stdio
@@ -277,7 +893,18 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 	var parser *kong.Kong
 	parser, err = kong.New(&cli, options...)
 	Ck(err)
+	// hand subcommands we don't have to plugins; see core/plugin.go
+	if len(args) > 0 && !isCommand(parser, args[0]) {
+		if path, ok := core.FindPlugin(args[0]); ok {
+			rc, err = runPlugin(path, args[1:], config)
+			return
+		}
+	}
 	ctx, err := parser.Parse(args)
+	if err != nil && (cli.CI || ciRequested(args)) {
+		rc = ciFail(config.Stderr, ciUsage, err.Error())
+		return rc, nil
+	}
 	parser.FatalIfErrorf(err)
 
 	Debug("ctx: %+v", ctx)
@@ -286,11 +913,60 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		os.Setenv("DEBUG", "1")
 	}
 
+	core.SetDirs(cli.ConfigDir, cli.CacheDir)
+
 	cmd := ctx.Command()
 	Debug("cmd: %s", cmd)
+	hookCmd = cmd
+
+	// with --ci, the answers are printed as JSON, and whatever
+	// other commands print is wrapped in JSON when they end; a
+	// server's output is its log
+	if cli.CI && !util.StringInSlice(cmd, []string{"q", "qi", "ask <question>", "serve", "serve run"}) {
+		ciOut = &bytes.Buffer{}
+		captured := *config
+		captured.Stdout = ciOut
+		config = &captured
+		SetStdio(config.Stdin, config.Stdout, config.Stderr)
+	}
+
+	// run the pre-command hooks, and keep a copy of the output for
+	// the post-command hooks
+	userCfg, err := core.LoadConfig()
+	Ck(err)
+	var preHooks []string
+	preHooks, postHooks = userCfg.Hooks(cmd)
+	for _, hook := range preHooks {
+		err = core.RunCommandHook(hook, cmd, nil, config.Stderr)
+		if err != nil {
+			rc = fail(config, err.Error())
+			return rc, nil
+		}
+	}
+	if len(postHooks) > 0 {
+		teed := *config
+		teed.Stdout = io.MultiWriter(config.Stdout, &output)
+		config = &teed
+		SetStdio(config.Stdin, config.Stdout, config.Stderr)
+	}
+
+	if cli.CI {
+		core.SetInteractive(false)
+		// commands that read stdin
+		stdinCmds := []string{"put <name>", "chat import <chat-file>", "ctx <tokenlimit>", "embed", "fix", "ask <question>", "qc", "qi", "qr", "tc", "msg <sysmsg>"}
+		chatNeedsInput := cmd == "chat send <chat-file>" && cli.Chat.Send.Prompt == "" && cli.Chat.Send.Extract < 1 && !cli.Chat.Send.OutputFilesRegex
+		if chatNeedsInput && cli.Chat.Send.Edit {
+			rc = ciFail(config.Stderr, ciUsage, "chat --edit opens an editor, which --ci doesn't allow; pass --prompt")
+			return
+		}
+		if (chatNeedsInput || util.StringInSlice(cmd, stdinCmds)) && isTerminal(config.Stdin) {
+			rc = ciFail(config.Stderr, ciUsage, Spf("'%s' reads stdin, which is a terminal; --ci needs its input piped in", strings.Split(cmd, " ")[0]))
+			return
+		}
+	}
 
 	// list of commands that don't require an existing database
-	noDbCmds := []string{"init", "tc"}
+	noDbCmds := []string{"init", "tc", "db", "import", "keygen", "plugins", "hydrate", "cache", "migrate"}
 	needsDb := true
 	if cmdInSlice(cmd, noDbCmds) {
 		Debug("command %s does not require a grok db", cmd)
@@ -298,13 +974,25 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 	}
 
 	// list of commands that can use a read-only db
-	roCmds := []string{"ls", "models", "version", "backup", "msg", "ctx"}
+	roCmds := []string{"ls", "models", "version", "backup", "msg", "ctx", "collections", "audit", "status", "verify", "export", "questions", "feedback", "eval", "compare", "bench", "bench-model", "drift", "chunk", "todos", "stale-docs", "dups", "actions", "digest", "report", "save-seed", "injections", "policy"}
 	readonly := false
 	if cmdInSlice(cmd, roCmds) {
 		Debug("command %s can use a read-only grok db", cmd)
 		readonly = true
 	}
 
+	// list of commands that work with --read-only; the queries
+	// normally refresh embeddings and save, but skip that when the
+	// db is read-only
+	queryCmds := []string{"q", "qc", "qi", "qr", "similarity", "embed", "commit"}
+	if cli.ReadOnly && needsDb {
+		if !readonly && !cmdInSlice(cmd, queryCmds) {
+			rc = fail(config, Spf("'%s' would modify the knowledge base, which is read-only", strings.Split(cmd, " ")[0]))
+			return
+		}
+		readonly = true
+	}
+
 	var grok *core.Grokker
 	var save bool
 	var modelOverride string
@@ -320,8 +1008,29 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		var migrated bool
 		var was, now string
 		var lock *flock.Flock
-		grok, migrated, was, now, lock, err = core.Load(modelOverride, readonly)
+		// queries load only the shards they need
+		core.SetLazyShards(cmdInSlice(cmd, []string{"q", "serve"}))
+		grok, migrated, was, now, lock, err = core.LoadDB(cli.DbName, modelOverride, readonly)
 		Ck(err)
+		grok.SetCaches(!cli.NoCache, cli.RespCache && !cli.NoCache)
+		if cli.ReadOnly {
+			grok.SetReadOnly()
+		}
+		if cli.Seed != nil {
+			grok.SetSeed(*cli.Seed)
+		}
+		lang := cli.Lang
+		if lang == "" {
+			lang = userCfg.Lang
+		}
+		grok.SetLang(lang)
+		if cli.PipelineFile != "" {
+			err = grok.UsePipeline(cli.PipelineFile)
+			Ck(err)
+		}
+		if cli.Manifest != "" {
+			grok.StartManifest()
+		}
 		defer func() {
 			// unlock the db
 			Debug("unlocking db")
@@ -347,45 +1056,782 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		// specify rootdir on command line
 		// XXX use the default model for now, but we should accept an
 		// optional model name as an init argument
-		_, err = core.Init(".", "")
+		grok, err = core.Init(".", "")
+		Ck(err)
+		err = grok.SetStore(cli.Init.Store)
 		Ck(err)
 		Pl("Initialized a new .grok file in the current directory.")
 		// Init calls Save() for us
 		return
 	case "add <paths>":
 		if len(cli.Add.Paths) < 1 {
-			Fpf(config.Stderr, "Error: add command requires a filename argument\n")
-			rc = 1
+			rc = fail(config, "add command requires a filename argument")
 			return
 		}
+		if cli.Add.Batch {
+			for _, docfn := range cli.Add.Paths {
+				if core.IsURL(docfn) {
+					rc = fail(config, Spf("--batch can't add web pages: %s", docfn))
+					return
+				}
+			}
+			// skip what the batch can't add, so we don't set the
+			// collection or tags of a document that isn't there
+			var paths []string
+			for _, docfn := range cli.Add.Paths {
+				var ignored bool
+				ignored, err = grok.Ignored(docfn)
+				Ck(err)
+				if ignored {
+					Fpf(os.Stderr, " skipping %s, which is in .grokignore\n", docfn)
+					continue
+				}
+				_, err = os.Stat(docfn)
+				if os.IsNotExist(err) {
+					Fpf(os.Stderr, " skipping %s, which doesn't exist\n", docfn)
+					err = nil
+					continue
+				}
+				Ck(err)
+				paths = append(paths, docfn)
+			}
+			// submit the embeddings as batch jobs
+			Fpf(os.Stderr, " adding %d files via the batch API ...\n", len(paths))
+			jobs, err := grok.AddDocumentsBatch(paths)
+			Ck(err)
+			for _, docfn := range paths {
+				if cli.Add.Collection != "" {
+					err = grok.SetCollection(docfn, cli.Add.Collection)
+					Ck(err)
+				}
+				if len(cli.Add.Tag) > 0 {
+					err = grok.SetTags(docfn, cli.Add.Tag)
+					Ck(err)
+				}
+			}
+			for _, job := range jobs {
+				Pl(job)
+			}
+			save = true
+			break
+		}
 		// add the documents
 		for _, docfn := range cli.Add.Paths {
-			// add the document
-			Fpf(os.Stderr, " adding %s ...\n", docfn)
-			err = grok.AddDocument(docfn)
-			if err != nil {
-				return
+			if core.IsURL(docfn) {
+				// fetch the web page
+				Fpf(os.Stderr, " adding %s ...\n", docfn)
+				docfn, err = grok.AddURL(docfn)
+				if err != nil {
+					return
+				}
+			} else {
+				var ignored bool
+				ignored, err = grok.Ignored(docfn)
+				Ck(err)
+				if ignored {
+					Fpf(os.Stderr, " skipping %s, which is in .grokignore\n", docfn)
+					continue
+				}
+				// add the document
+				Fpf(os.Stderr, " adding %s ...\n", docfn)
+				err = grok.AddDocument(docfn)
+				if err != nil {
+					return
+				}
+			}
+			if cli.Add.Collection != "" {
+				err = grok.SetCollection(docfn, cli.Add.Collection)
+				Ck(err)
+			}
+			if len(cli.Add.Tag) > 0 {
+				err = grok.SetTags(docfn, cli.Add.Tag)
+				Ck(err)
 			}
 		}
 		// save the grok file
 		save = true
 	case "aidda <subcommands>":
 		if len(cli.Aidda.Subcommands) < 1 {
-			Fpf(config.Stderr, "Error: aidda command requires a subcommand argument\n")
-			rc = 1
+			rc = fail(config, "aidda command requires a subcommand argument")
 			return
 		}
 		// perform the AIDDA operations
 		err := aidda.Do(grok, cli.Aidda.Subcommands...)
 		Ck(err)
-	case "chat <chat-file>":
-		if cli.Chat.OutputFilesRegex {
+	case "put <name>":
+		// add or update a virtual document from stdin
+		buf, err := ioutil.ReadAll(config.Stdin)
+		Ck(err)
+		err = grok.PutDocument(cli.Put.Name, buf)
+		Ck(err)
+		if cli.Put.Collection != "" {
+			err = grok.SetCollection(cli.Put.Name, cli.Put.Collection)
+			Ck(err)
+		}
+		if len(cli.Put.Tag) > 0 {
+			err = grok.SetTags(cli.Put.Name, cli.Put.Tag)
+			Ck(err)
+		}
+		save = true
+	case "cache serve":
+		d, err := core.NewCacheDaemon()
+		Ck(err)
+		// remove the socket on the way out
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			d.Close()
+		}()
+		Fpf(config.Stderr, "serving the caches in %s on %s\n", core.CacheDir(), core.CacheSocket())
+		err = d.Serve(core.CacheSocket())
+		Ck(err)
+	case "cache stats":
+		usage, err := core.CacheUsages()
+		Ck(err)
+		for _, u := range usage {
+			Pl(u)
+		}
+	case "cache prune":
+		d, err := core.NewCacheDaemon()
+		Ck(err)
+		Pf("evicted %d entries\n", d.Prune())
+	case "serve run":
+		cfg, err := serve.LoadConfig()
+		Ck(err)
+		// tenants and reloaded indexes get the same command-line
+		// settings as the knowledge base grok serve runs in
+		configure := func(g *core.Grokker) {
+			g.SetCaches(!cli.NoCache, cli.RespCache && !cli.NoCache)
+			if cli.ReadOnly {
+				g.SetReadOnly()
+			}
+			if cli.Seed != nil {
+				g.SetSeed(*cli.Seed)
+			}
+			lang := cli.Lang
+			if lang == "" {
+				lang = userCfg.Lang
+			}
+			g.SetLang(lang)
+		}
+		srv, err := serve.NewServer(grok, cfg, cli.Serve.NoAuth)
+		Ck(err)
+		srv.SetConfigure(configure)
+		if len(cfg.Tenants) == 0 {
+			err = srv.ListenAndServe(cli.Serve.Listen)
+			Ck(err)
+			break
+		}
+		rt, err := serve.NewRouter(srv, cfg.Tenants, cli.Serve.NoAuth, configure)
+		Ck(err)
+		defer rt.Close()
+		err = rt.ListenAndServe(cli.Serve.Listen)
+		Ck(err)
+	case "serve token":
+		token, sum, err := serve.NewToken()
+		Ck(err)
+		Pf("token: %s\n\n", token)
+		Pf("Give the token to the client and add this to the serve tokens in %s:\n\n", core.ConfigPath())
+		Pf("    - name: <client name>\n      sha256: %s\n      read: [\"*\"]\n      write: []\n", sum)
+	case "db list", "db add <name> <path>", "db use <name>", "db rm <name>":
+		err = registry(cmd)
+		Ck(err)
+	case "audit show":
+		entries, err := grok.AuditLog()
+		Ck(err)
+		if cli.Audit.Show.Last > 0 && len(entries) > cli.Audit.Show.Last {
+			entries = entries[len(entries)-cli.Audit.Show.Last:]
+		}
+		for _, e := range entries {
+			Pl(e)
+			if cli.Audit.Show.Content {
+				for _, msg := range e.Request {
+					Pf("  %s: %s\n", msg.Role, msg.Content)
+				}
+				if e.Response != "" {
+					Pf("  response: %s\n", e.Response)
+				}
+			}
+		}
+	case "store":
+		Pl(grok.StoreKind())
+	case "store <kind>":
+		err = grok.SetStore(cli.Store.Kind)
+		Ck(err)
+		Pf("chunks are now stored in the %s store\n", grok.StoreKind())
+	case "policy show":
+		policy, err := grok.Policy()
+		Ck(err)
+		for _, rule := range policy.Rules() {
+			Pl(rule)
+		}
+	case "policy log":
+		entries, err := grok.PolicyLog()
+		Ck(err)
+		if cli.Policy.Log.Violations {
+			var violations []core.PolicyEntry
+			for _, e := range entries {
+				if util.StringInSlice(e.Event, []string{"violation", "allow", "block"}) {
+					violations = append(violations, e)
+				}
+			}
+			entries = violations
+		}
+		if cli.Policy.Log.Last > 0 && len(entries) > cli.Policy.Log.Last {
+			entries = entries[len(entries)-cli.Policy.Log.Last:]
+		}
+		for _, e := range entries {
+			Pl(e)
+		}
+	case "bench":
+		report, err := grok.Bench(core.BenchOptions{
+			Sizes: cli.Bench.Sizes,
+			Dim:   cli.Bench.Dim,
+			Query: cli.Bench.Query,
+			Runs:  cli.Bench.Runs,
+		})
+		Ck(err)
+		buf, err := json.MarshalIndent(report, "", "  ")
+		Ck(err)
+		Fpf(config.Stdout, "%s\n", buf)
+	case "bench-model <models>":
+		if len(cli.BenchModel.Models) < 2 {
+			rc = fail(config, "bench-model needs at least two models")
+			return
+		}
+		questions, err := core.LoadQuestions(cli.BenchModel.File)
+		Ck(err)
+		results, err := grok.BenchModels(cli.BenchModel.Models, questions, cli.BenchModel.Judge, cli.Global)
+		Ck(err)
+		showModelBench(config.Stdout, cli.BenchModel.Models, results, cli.BenchModel.MinScore)
+	case "compare":
+		if len(cli.Compare.Config) < 2 {
+			rc = fail(config, "compare needs at least two --config files")
+			return
+		}
+		var cfgs []*core.CompareConfig
+		for _, path := range cli.Compare.Config {
+			cfg, err := core.LoadCompareConfig(path)
+			Ck(err)
+			cfgs = append(cfgs, cfg)
+		}
+		questions, err := core.LoadQuestions(cli.Compare.File)
+		Ck(err)
+		results, err := grok.Compare(cfgs, questions, cli.Global)
+		Ck(err)
+		showComparison(config.Stdout, cfgs, results)
+	case "eval":
+		results, err := grok.Eval()
+		Ck(err)
+		regressed := 0
+		for _, res := range results {
+			q := res.Question
+			switch {
+			case res.Regressed():
+				regressed++
+				Pf("REGRESSED %d: %s\n", q.ID, q.Question)
+			case q.Rating == core.RatingBad && res.Changed():
+				Pf("changed   %d: %s\n", q.ID, q.Question)
+			case q.Rating == core.RatingBad:
+				Pf("unchanged %d: %s\n", q.ID, q.Question)
+			default:
+				Pf("ok        %d: %s\n", q.ID, q.Question)
+			}
+			if res.Changed() {
+				Pf("  was: %s\n", strings.Join(res.Want, " "))
+				Pf("  now: %s\n", strings.Join(res.Got, " "))
+			}
+		}
+		Pf("%d rated questions, %d regressed\n", len(results), regressed)
+		if regressed > 0 {
+			rc = 1
+		}
+	case "export <file>":
+		var key *core.SecretKey
+		key, err = loadSecretKey(cli.Export.Key)
+		Ck(err)
+		err = grok.Export(cli.Export.File, key)
+		Ck(err)
+		if key != nil {
+			Pf("exported to %s, signed by key %s\n", cli.Export.File, key.Public().KeyID())
+		}
+	case "action index":
+		core.SetInteractive(false)
+		var added []string
+		added, err = grok.IndexCheckout()
+		Ck(err)
+		save = true
+		Pf("added %d documents; %d in all\n", len(added), len(grok.Documents))
+		err = setActionOutputs("added", strings.Join(added, "\n"), "documents", Spf("%d", len(grok.Documents)))
+		Ck(err)
+	case "action answer":
+		core.SetInteractive(false)
+		var ev *core.GitHubEvent
+		ev, err = core.LoadGitHubEvent()
+		Ck(err)
+		question, ok := ev.Question(cli.Action.Answer.Trigger, cli.Action.Answer.Also...)
+		if !ok {
+			Pf("no question for grok in this %s %s event\n", ev.Name, ev.Action)
+			err = setActionOutputs("answered", "false")
+			Ck(err)
+			break
+		}
+		var resp string
+		resp, _, save, err = answer(grok, question, cli.Global, nil)
+		Ck(err)
+		md := resp
+		if sources := grok.Sources(); len(sources) > 0 {
+			md += "\n\nSources:\n"
+			for _, cite := range sources {
+				md += "- `" + cite + "`\n"
+			}
+		}
+		Pl(md)
+		commentID := ""
+		if ev.Comment != nil {
+			commentID = Spf("%d", ev.Comment.ID)
+		}
+		err = setActionOutputs("answered", "true", "question", question, "answer", md,
+			"sources", strings.Join(grok.Sources(), "\n"), "low-confidence", grok.LowConfidence(cli.LowConf),
+			"number", Spf("%d", ev.Number()), "comment-id", commentID)
+		Ck(err)
+		err = core.AddStepSummary("### " + firstLine(question) + "\n\n" + md)
+		Ck(err)
+	case "action review":
+		core.SetInteractive(false)
+		base, head := cli.Action.Review.Base, cli.Action.Review.Head
+		number := 0
+		if base == "" || head == "" {
+			var ev *core.GitHubEvent
+			ev, err = core.LoadGitHubEvent()
+			Ck(err)
+			if ev.PullRequest == nil {
+				rc = fail(config, Spf("a %s event has no pull request; pass --base and --head", ev.Name))
+				return
+			}
+			number = ev.PullRequest.Number
+			if base == "" {
+				base = ev.PullRequest.Base.SHA
+			}
+			if head == "" {
+				head = ev.PullRequest.Head.SHA
+			}
+		}
+		var review string
+		var files []string
+		review, files, err = grok.ReviewRange(base, head)
+		Ck(err)
+		Pl(review)
+		err = setActionOutputs("review", review, "files", strings.Join(files, "\n"), "number", Spf("%d", number))
+		Ck(err)
+		err = core.AddStepSummary("### Review\n\n" + review)
+		Ck(err)
+	case "action publish <file>":
+		var key *core.SecretKey
+		key, err = loadSecretKey(cli.Action.Publish.Key)
+		Ck(err)
+		err = grok.Export(cli.Action.Publish.File, key)
+		Ck(err)
+		sig := ""
+		if key != nil {
+			sig = cli.Action.Publish.File + ".minisig"
+		}
+		Pf("exported to %s\n", cli.Action.Publish.File)
+		err = setActionOutputs("path", cli.Action.Publish.File, "signature", sig)
+		Ck(err)
+	case "import <file>":
+		var keys []core.PublicKey
+		keys, err = core.TrustedKeys()
+		Ck(err)
+		for _, arg := range cli.Import.PubKey {
+			text := arg
+			if buf, err := ioutil.ReadFile(arg); err == nil {
+				text = string(buf)
+			}
+			k, err := core.ParsePublicKey(text)
+			Ck(err, "%s", arg)
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 && !cli.Import.Unsigned {
+			rc = fail(config, Spf("no trusted keys; pass --pubkey, add trusted_keys to %s, or use --unsigned", core.ConfigPath()))
+			return
+		}
+		if cli.Import.Unsigned {
+			keys = nil
+		}
+		var comment string
+		comment, err = core.Import(cli.Import.File, ".", keys)
+		Ck(err)
+		if comment != "" {
+			Pf("signature ok: %s\n", comment)
+		}
+		Pf("imported %s into .grok\n", cli.Import.File)
+	case "migrate":
+		var grokpath string
+		grokpath, err = core.FindDB(cli.DbName)
+		Ck(err)
+		var report *core.MigrateReport
+		report, err = core.Migrate(grokpath)
+		Ck(err)
+		if report.From == report.To {
+			Pf("%s is already at version %s\n", grokpath, report.To)
+		} else {
+			Pf("migrated %s from version %s to %s; backup at %s\n", grokpath, report.From, report.To, report.Backup)
+		}
+		if report.Converted > 0 || report.Dropped > 0 {
+			Pf("converted %d chunks, keeping their embeddings; dropped %d that are no longer in their documents\n", report.Converted, report.Dropped)
+		}
+		for _, path := range report.Missing {
+			Pf("forgot %s, which no longer exists\n", path)
+		}
+		if len(report.Stale) > 0 {
+			Pf("re-embed the documents that changed with: grok add %s\n", strings.Join(report.Stale, " "))
+		}
+		for _, problem := range report.Verify.Problems {
+			Pl(problem)
+		}
+		if len(report.Verify.Problems) > 0 {
+			rc = fail(config, Spf("%d problems in %d chunks", len(report.Verify.Problems), report.Verify.Chunks))
+			return
+		}
+		Pf("%d chunks ok\n", report.Verify.Chunks)
+	case "hydrate", "hydrate <file>":
+		fn := cli.Hydrate.File
+		if fn == "" {
+			fn = ".grok-seed.json"
+		}
+		var seed *core.Seed
+		seed, err = core.ReadSeed(fn)
+		Ck(err)
+		// a hydrate that was interrupted picks up where it left off
+		if _, err = os.Stat(".grok"); err == nil {
+			var lock *flock.Flock
+			grok, _, _, _, lock, err = core.LoadFrom(".grok", modelOverride, false)
+			Ck(err)
+			defer lock.Unlock()
+		} else {
+			model := seed.Model
+			if modelOverride != "" {
+				model = modelOverride
+			}
+			grok, err = core.Init(".", model)
+			Ck(err)
+		}
+		grok.SetCaches(!cli.NoCache, false)
+		var res *core.HydrateResult
+		res, err = grok.Hydrate(seed)
+		Ck(err)
+		for _, relpath := range res.Missing {
+			Fpf(config.Stderr, "missing: %s\n", relpath)
+		}
+		Pl(res)
+		save = true
+	case "keygen <name>":
+		pub, sec, err := core.GenerateKey()
+		Ck(err)
+		for _, fn := range []string{cli.Keygen.Name + ".key", cli.Keygen.Name + ".pub"} {
+			_, err = os.Stat(fn)
+			Assert(os.IsNotExist(err), "%s already exists", fn)
+		}
+		err = ioutil.WriteFile(cli.Keygen.Name+".key", []byte(sec.String()), 0600)
+		Ck(err)
+		err = ioutil.WriteFile(cli.Keygen.Name+".pub", []byte(pub.String()), 0644)
+		Ck(err)
+		Pf("wrote %s.key and %s.pub\n", cli.Keygen.Name, cli.Keygen.Name)
+	case "verify":
+		report := grok.Verify()
+		for _, problem := range report.Problems {
+			Pl(problem)
+		}
+		if report.Unsummed > 0 {
+			Pf("%d chunks have no checksum yet; they get one the next time the knowledge base is saved\n", report.Unsummed)
+		}
+		if len(report.Problems) > 0 {
+			rc = fail(config, Spf("%d problems in %d chunks", len(report.Problems), report.Chunks))
+			return
+		}
+		Pf("%d chunks ok\n", report.Chunks)
+	case "injections":
+		var flags []core.InjectionFlag
+		flags, err = grok.ScanInjections()
+		Ck(err)
+		for _, flag := range flags {
+			Pl(flag)
+		}
+		if len(flags) > 0 {
+			rc = fail(config, Spf("%d chunks read like instructions to the model", len(flags)))
+			return
+		}
+	case "chunk <id>":
+		var ref *core.ChunkRef
+		var ok bool
+		ref, ok, err = grok.ChunkByID(cli.Chunk.ID)
+		Ck(err)
+		if !ok {
+			rc = fail(config, Spf("no chunk %q; its document may have changed", cli.Chunk.ID))
+			return
+		}
+		loc := ref.Path
+		switch {
+		case ref.Line > 0:
+			loc += Spf(":%d", ref.Line)
+		case ref.Section != "":
+			loc += Spf(" (%s)", ref.Section)
+		}
+		Pf("%s %s\n\n%s\n", ref.ID, loc, ref.Text)
+	case "drift":
+		var report *core.DriftReport
+		report, err = grok.Drift(cli.Drift.Sample, cli.Drift.Threshold)
+		Ck(err)
+		for _, c := range report.Drifted {
+			Pf("%.4f %s@%d\n", c.Similarity, c.RelPath, c.Offset)
+		}
+		if report.Skipped > 0 {
+			Pf("%d sampled chunks skipped because their documents changed since they were embedded\n", report.Skipped)
+		}
+		if report.Sampled == 0 {
+			Pf("no unchanged chunks to sample\n")
+			return
+		}
+		Pf("%s:%s: %d chunks re-embedded, mean similarity %.4f, min %.4f\n",
+			report.Provider, report.Model, report.Sampled, report.Mean, report.Min)
+		if report.Degraded() {
+			rc = fail(config, Spf("embeddings have drifted below %.4f; the provider may have changed the model.  Run 'grok --no-cache refresh --reembed' to rebuild the index.", report.Threshold))
+			return
+		}
+	case "actions":
+		var since time.Time
+		since, err = core.ParseSince(cli.Actions.Since, time.Now())
+		Ck(err)
+		var notes []*core.Note
+		notes, err = grok.Notes(since, cli.Actions.Collection)
+		Ck(err)
+		if len(notes) == 0 {
+			rc = fail(config, Spf("no notes dated since %s; put meeting notes in a notes, transcripts, or meetings collection, or name one with --collection.", since.Format("2006-01-02")))
+			return
+		}
+		if cli.Actions.List {
+			for _, note := range notes {
+				Pf("%s %s\n", note.Date.Format("2006-01-02"), note.RelPath)
+			}
+			break
+		}
+		var items []*core.ActionItem
+		items, err = grok.Actions(notes)
+		Ck(err)
+		Pf("%s", core.ActionsReport(items))
+	case "digest":
+		var changes *core.Changes
+		changes, err = grok.Changes(cli.Digest.Since, time.Now())
+		Ck(err)
+		var digest string
+		digest, err = grok.Digest(changes, time.Now())
+		Ck(err)
+		if cli.Digest.To == "" {
+			Pf("%s", digest)
+			break
+		}
+		err = ioutil.WriteFile(cli.Digest.To, []byte(digest), 0644)
+		Ck(err)
+	case "dups":
+		var dups []*core.Duplicate
+		dups, err = grok.Duplicates(cli.Dups.Threshold)
+		Ck(err)
+		for _, dup := range dups {
+			Pf("%.4f %d passages\n", dup.Similarity, len(dup.Passages))
+			for _, cite := range dup.Passages {
+				Pf("  %s\n", cite)
+			}
+		}
+	case "save-seed", "save-seed <file>":
+		err = grok.WriteSeed(cli.SaveSeed.File)
+		Ck(err)
+		if cli.SaveSeed.File == "" {
+			Pf("wrote %s\n", grok.SeedPath())
+		}
+	case "report":
+		var report *core.Report
+		report, err = grok.Report(!cli.Report.NoDups, cli.Report.Threshold)
+		Ck(err)
+		md := report.Markdown()
+		if cli.Report.To == "" {
+			Pl(md)
+			break
+		}
+		err = ioutil.WriteFile(cli.Report.To, []byte(md+"\n"), 0644)
+		Ck(err)
+	case "tui", "tui <question>":
+		if cli.CI || !isTerminal(config.Stdout) {
+			rc = fail(config, "tui needs a terminal")
+			return
+		}
+		updated, err := grok.UpdateEmbeddings()
+		Ck(err)
+		if updated {
+			save = true
+		}
+		err = tui.Run(grok, config.Stdout, cli.Tui.Question, cli.Global)
+		Ck(err)
+	case "tune suggest":
+		var sugs []core.Suggestion
+		sugs, err = grok.Suggest(cli.Tune.Suggest.MinRetrieved)
+		Ck(err)
+		for _, s := range sugs {
+			Pl(s)
+		}
+		if len(sugs) == 0 {
+			Fpf(config.Stderr, "no suggestions; rate answers with 'grok feedback' to get more\n")
+			break
+		}
+		if cli.Tune.Suggest.Apply {
+			err = grok.ApplySuggestions(sugs)
+			Ck(err)
+			Fpf(config.Stderr, "applied %d suggestions\n", len(sugs))
+			save = true
+		}
+	case "status":
+		showStatus(grok)
+	case "audit verify":
+		count, chained, err := grok.VerifyAudit()
+		Ck(err)
+		Pf("%d entries ok, %d chained\n", count, chained)
+	case "chat export <chat-file>":
+		out, err := grok.ExportChat(cli.Chat.Export.ChatFile, cli.Chat.Export.Format)
+		Ck(err)
+		Pf("%s", out)
+	case "chat import <chat-file>":
+		buf, err := ioutil.ReadAll(config.Stdin)
+		Ck(err)
+		addToDb := !cli.Chat.Import.NoAddToDb
+		err = grok.ImportChat(cli.Chat.Import.ChatFile, string(buf), addToDb)
+		Ck(err)
+		save = addToDb
+	case "collections":
+		for _, info := range grok.Collections() {
+			Pl(info)
+		}
+	case "stoplist list":
+		for _, chunk := range grok.StopList() {
+			line, err := grok.StopListEntry(chunk)
+			Ck(err)
+			Pl(line)
+		}
+	case "stoplist allow <hash>":
+		err = grok.AllowChunk(cli.Stoplist.Allow.Hash)
+		Ck(err)
+		save = true
+	case "snapshot create <name>":
+		// make sure the snapshot reflects the current documents
+		_, err = grok.UpdateEmbeddings()
+		Ck(err)
+		err = grok.CreateSnapshot(cli.Snapshot.Create.Name)
+		Ck(err)
+		Pf("Created snapshot %s\n", cli.Snapshot.Create.Name)
+		save = true
+	case "exclude ls":
+		for _, doc := range grok.ExcludedDocuments() {
+			Pf("%s\t%s\n", doc.RelPath, doc.Excluded)
+		}
+	case "exclude add <paths>":
+		for _, path := range cli.Exclude.Add.Paths {
+			err = grok.ExcludeDocument(path, cli.Exclude.Add.Reason)
+			Ck(err)
+		}
+		save = true
+	case "exclude rm <paths>":
+		for _, path := range cli.Exclude.Rm.Paths {
+			err = grok.IncludeDocument(path)
+			Ck(err)
+		}
+		save = true
+	case "shard ls":
+		for _, info := range grok.ShardInfos() {
+			Pf("%-40s %6d documents %10d bytes\n", info.Dir, info.Documents, info.Size)
+		}
+	case "shard add <dir>":
+		shard, err := grok.AddShard(cli.Shard.Add.Dir)
+		Ck(err)
+		Pf("Added shard %s\n", shard)
+		save = true
+	case "shard rm <dir>":
+		shard, err := grok.RemoveShard(cli.Shard.Rm.Dir)
+		Ck(err)
+		Pf("Removed shard %s\n", shard)
+		save = true
+	case "snapshot list":
+		snaps, err := grok.ListSnapshots()
+		Ck(err)
+		for _, snap := range snaps {
+			Pl(snap)
+		}
+	case "hook ls":
+		untrusted := grok.UntrustedHooks()
+		for _, h := range grok.Hooks {
+			mark := ""
+			if slices.Contains(untrusted, h) {
+				mark = "  (untrusted)"
+			}
+			Pf("%-20s %s%s\n", h.Pattern, h.Command, mark)
+		}
+	case "hook add <pattern> <command>":
+		err = grok.AddHook(cli.Hook.Add.Pattern, cli.Hook.Add.Command)
+		Ck(err)
+		save = true
+	case "hook rm <pattern>":
+		err = grok.RemoveHooks(cli.Hook.Rm.Pattern)
+		Ck(err)
+		save = true
+	case "hook trust":
+		err = grok.TrustHooks()
+		Ck(err)
+	case "pipeline show":
+		for _, kv := range grok.PipelineSettings() {
+			Pf("%-20s %s\n", kv[0], kv[1])
+		}
+	case "pipeline set <name> <value>":
+		err = grok.SetPipeline(cli.Pipeline.Set.Name, cli.Pipeline.Set.Value)
+		Ck(err)
+		save = true
+	case "pipeline load <name>":
+		hooksChanged, err := grok.LoadPipeline(cli.Pipeline.Load.Name)
+		Ck(err)
+		if hooksChanged {
+			Fpf(config.Stderr, "the index hooks changed; run 'grok refresh' to re-index\n")
+			if len(grok.UntrustedHooks()) > 0 {
+				Fpf(config.Stderr, "review the new hooks with 'grok hook ls', then run 'grok hook trust'\n")
+			}
+		}
+		save = true
+	case "pipeline export":
+		buf, err := grok.ExportPipeline()
+		Ck(err)
+		Pf("%s", buf)
+	case "pipeline files":
+		infos, err := grok.PipelineFiles()
+		Ck(err)
+		for _, info := range infos {
+			Pf("%-20s %s\n", info.Name, info.Description)
+		}
+	case "batch status":
+		// poll pending batch jobs and merge completed embeddings
+		jobs, err := grok.BatchStatus()
+		Ck(err)
+		if len(jobs) == 0 {
+			Pl("no pending batch jobs")
+		}
+		for _, job := range jobs {
+			Pl(job)
+		}
+		save = true
+	case "chat send <chat-file>":
+		if cli.Chat.Send.OutputFilesRegex {
 			// if chatfile exists, check the regex against it
-			_, err = os.Stat(cli.Chat.ChatFile)
+			_, err = os.Stat(cli.Chat.Send.ChatFile)
 			if err == nil {
 				// chatfile exists
 				re := regexp.MustCompile(core.OutfilesRegex(nil))
-				buf, err := ioutil.ReadFile(cli.Chat.ChatFile)
+				buf, err := ioutil.ReadFile(cli.Chat.Send.ChatFile)
 				Ck(err)
 				txt := string(buf)
 				matches := re.FindAllStringSubmatch(txt, -1)
@@ -400,14 +1846,14 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 			return
 		}
 		var prompt string
-		extract := cli.Chat.Extract
-		edit := cli.Chat.Edit
+		extract := cli.Chat.Send.Extract
+		edit := cli.Chat.Send.Edit
 		if extract < 1 {
-			if cli.Chat.Prompt != "" {
-				prompt = cli.Chat.Prompt
+			if cli.Chat.Send.Prompt != "" {
+				prompt = cli.Chat.Send.Prompt
 			} else if edit {
 				// open the chat file in the editor
-				err = EditFile(cli.Chat.ChatFile)
+				err = EditFile(cli.Chat.Send.ChatFile)
 				Ck(err)
 			} else {
 				// get text from stdin and print the response
@@ -419,20 +1865,20 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 			prompt = strings.TrimSpace(prompt)
 		}
 		var level util.ContextLevel
-		if cli.Chat.ContextNone {
+		if cli.Chat.Send.ContextNone {
 			level = util.ContextNone
-		} else if cli.Chat.ContextRepo {
+		} else if cli.Chat.Send.ContextRepo {
 			level = util.ContextAll
-		} else if cli.Chat.ContextChat {
+		} else if cli.Chat.Send.ContextChat {
 			level = util.ContextChat
 		} else {
 			level = util.ContextRecent
 		}
-		infiles := cli.Chat.InputFiles
+		infiles := cli.Chat.Send.InputFiles
 		// split each outfile on equal sign to get the filename and language
 		// XXX maybe move this, and much of the rest of this case, into API
 		var outfiles []core.FileLang
-		for _, outfile := range cli.Chat.OutputFiles {
+		for _, outfile := range cli.Chat.Send.OutputFiles {
 			parts := strings.Split(outfile, "=")
 			if len(parts) == 2 {
 				outfiles = append(outfiles, core.FileLang{
@@ -444,8 +1890,7 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 				var known bool
 				lang, known, err = util.Ext2Lang(outfile)
 				if err != nil {
-					Fpf(config.Stderr, err.Error())
-					rc = 1
+					rc = fail(config, err.Error())
 					return
 				}
 				if !known {
@@ -457,12 +1902,65 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 				})
 			}
 		}
+		err = attachFiles(grok, cli.Chat.Send.Attach)
+		Ck(err)
+		defer grok.Detach()
 		// get the response
-		outtxt, err := grok.Chat(cli.Chat.Sysmsg, prompt, cli.Chat.ChatFile, level, infiles, outfiles, extract, cli.Chat.PromptTokenLimit, cli.Chat.ExtractToStdout, !cli.Chat.NoAddToDb, edit)
+		outtxt, err := grok.Chat(cli.Chat.Send.Sysmsg, prompt, cli.Chat.Send.ChatFile, level, infiles, outfiles, extract, cli.Chat.Send.PromptTokenLimit, cli.Chat.Send.ExtractToStdout, !cli.Chat.Send.NoAddToDb, edit)
 		Ck(err)
 		Pl(outtxt)
 		// save the grok file
 		save = true
+	case "chat fork <chat-file>":
+		out, err := grok.ForkChat(cli.Chat.Fork.ChatFile, cli.Chat.Fork.At, cli.Chat.Fork.Output)
+		Ck(err)
+		Pl(out)
+	case "chat branches <chat-file>":
+		branches, err := grok.ChatBranches(cli.Chat.Branches.ChatFile)
+		Ck(err)
+		for _, b := range branches {
+			Pf("%s%s  forked at turn %d, %d turns\n", strings.Repeat("  ", b.Depth-1), b.Path, b.ForkedAt, b.Turns)
+		}
+	case "chat prune <chat-file>", "chat prune <chat-file> <branches>":
+		removed, err := grok.PruneChat(cli.Chat.Prune.ChatFile, cli.Chat.Prune.Branches)
+		Ck(err)
+		for _, p := range removed {
+			Pf("removed %s\n", p)
+		}
+		save = true
+	case "chat scrub", "chat scrub <dir>":
+		var policy core.ChatConfig
+		if userCfg.Chat != nil {
+			policy = *userCfg.Chat
+		}
+		if cli.Chat.Scrub.RedactAfter != "" {
+			policy.RedactAfter = cli.Chat.Scrub.RedactAfter
+		}
+		if cli.Chat.Scrub.ExpireAfter != "" {
+			policy.ExpireAfter = cli.Chat.Scrub.ExpireAfter
+		}
+		policy.Encrypt = policy.Encrypt || cli.Chat.Scrub.Encrypt
+		actions, err := grok.ScrubChats(cli.Chat.Scrub.Dir, policy, cli.Chat.Scrub.DryRun)
+		Ck(err)
+		for _, a := range actions {
+			detail := ""
+			if a.Redactions > 0 {
+				detail = Spf(", %d redactions", a.Redactions)
+			}
+			Pf("%s %s (%.0f days old%s)\n", a.Action, a.Path, a.Age.Hours()/24, detail)
+		}
+		save = !cli.Chat.Scrub.DryRun
+	case "chat session", "chat session <name>":
+		s := cli.Chat.Session
+		err = chatSession(config, grok, s.Name, s.Sysmsg, !s.NoAddToDb && !readonly)
+		Ck(err)
+		save = true
+	case "chat sessions":
+		sessions, err := grok.ChatSessions()
+		Ck(err)
+		for _, s := range sessions {
+			Pf("%s  %d turns, last used %s\n", s.Name, s.Turns, s.Modified.Format("2006-01-02 15:04"))
+		}
 	case "ctx <tokenlimit>":
 		// get text from stdin and print the context
 		buf, err := ioutil.ReadAll(config.Stdin)
@@ -471,22 +1969,84 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		// trim whitespace
 		intxt = strings.TrimSpace(intxt)
 		// get the context
+		grok.SetContextLimits(cli.Ctx.K, 0)
 		outtxt, err := grok.Context(intxt, cli.Ctx.Tokenlimit, cli.Ctx.WithHeaders, cli.Ctx.WithLineNumbers)
 		Ck(err)
-		Pl(outtxt)
-	case "embed":
-		// get text from stdin and print the embedding vector
-		buf, err := ioutil.ReadAll(config.Stdin)
+		Pl(outtxt)
+	case "embed":
+		// get text from stdin and print the embedding vector
+		buf, err := ioutil.ReadAll(config.Stdin)
+		Ck(err)
+		intxt := string(buf)
+		// get the embedding vector
+		outtxt, err := grok.Embed(intxt)
+		Ck(err)
+		Pl(outtxt)
+	case "fix", "fix <error>":
+		// diagnose an error and suggest a patch
+		errText := cli.Fix.Error
+		if errText == "" {
+			buf, err := ioutil.ReadAll(config.Stdin)
+			Ck(err)
+			errText = string(buf)
+		}
+		var updated bool
+		updated, err = grok.UpdateEmbeddings()
+		Ck(err)
+		save = updated
+		var fix *core.Fix
+		fix, err = grok.Fix(errText)
+		Ck(err)
+		Pl(fix.Diagnosis)
+		if fix.Patch != "" {
+			Pf("\n```diff\n%s```\n", fix.Patch)
+		}
+		if cli.Fix.Aidda {
+			if len(fix.Files) == 0 {
+				rc = fail(config, "the error doesn't name any files in the knowledge base, so there's nothing for aidda to change")
+				return
+			}
+			// use the first line of the error that isn't a
+			// heading, like go build's "# package"
+			var title string
+			for _, line := range strings.Split(strings.TrimSpace(errText), "\n") {
+				if !strings.HasPrefix(line, "#") {
+					title = strings.TrimSpace(line)
+					break
+				}
+			}
+			txt := Spf("Fix %s\n\n%s\n\nError:\n\n%s\n\nSuggested patch:\n\n%s", title, fix.Diagnosis, strings.TrimSpace(errText), fix.Patch)
+			p := &aidda.Prompt{Txt: txt, In: fix.Files, Out: fix.Files}
+			err = aidda.WritePrompt(grok, p)
+			Ck(err)
+			Fpf(config.Stderr, "wrote the fix to the aidda prompt; run 'grok aidda generate' to apply it\n")
+		}
+	case "retag <pattern>":
+		if len(cli.Retag.Tag) == 0 && !cli.Retag.Clear {
+			rc = fail(config, "retag needs --tag, or --clear to remove the tags")
+			return
+		}
+		tags := cli.Retag.Tag
+		if cli.Retag.Clear {
+			tags = nil
+		}
+		paths, err := grok.RetagDocuments(cli.Retag.Pattern, tags, cli.Retag.DryRun)
+		Ck(err)
+		showBulk(config, "retag", "retagged", paths, cli.Retag.DryRun)
+		save = !cli.Retag.DryRun
+	case "boost <pattern> <boost>":
+		paths, err := grok.BoostDocuments(cli.Boost.Pattern, cli.Boost.Boost, cli.Boost.DryRun)
 		Ck(err)
-		intxt := string(buf)
-		// get the embedding vector
-		outtxt, err := grok.Embed(intxt)
+		showBulk(config, "boost", "boosted", paths, cli.Boost.DryRun)
+		save = !cli.Boost.DryRun
+	case "rm <pattern>":
+		paths, err := grok.ForgetDocuments(cli.Rm.Pattern, cli.Rm.DryRun)
 		Ck(err)
-		Pl(outtxt)
+		showBulk(config, "forget", "forgot", paths, cli.Rm.DryRun)
+		save = !cli.Rm.DryRun
 	case "forget <paths>":
 		if len(cli.Forget.Paths) < 1 {
-			Fpf(config.Stderr, "Error: forget command requires a filename argument\n")
-			rc = 1
+			rc = fail(config, "forget command requires a filename argument")
 			return
 		}
 		// forget the documents
@@ -498,32 +2058,201 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		}
 		// save the grok file
 		save = true
+	case "purge", "purge <paths>":
+		purged, err := grok.Purge(cli.Purge.Paths...)
+		Ck(err)
+		for _, path := range purged {
+			Fpf(config.Stderr, " purged %s\n", path)
+		}
+		if len(purged) == 0 {
+			Fpf(config.Stderr, "nothing to purge; forget documents first\n")
+			break
+		}
+		save = true
 	case "refresh":
 		// refresh the embeddings for all documents
-		err = grok.RefreshEmbeddings()
+		if cli.Refresh.Reembed {
+			err = grok.ReEmbed()
+		} else {
+			err = grok.RefreshEmbeddings()
+		}
 		Ck(err)
 		// save the db
 		save = true
 	case "ls":
+		if cli.Ls.Forgotten {
+			for _, ts := range grok.Tombstones {
+				status := "not purged"
+				if ts.Purged != nil {
+					status = "purged " + ts.Purged.Format("2006-01-02 15:04:05")
+				}
+				Pf("%s\tforgotten %s\t%s\n", ts.RelPath, ts.Deleted.Format("2006-01-02 15:04:05"), status)
+			}
+			break
+		}
 		// list the documents in the knowledge base
 		paths := grok.ListDocuments()
 		for _, path := range paths {
 			Pl(path)
 		}
-	case "q <question>":
+	case "q", "q <question>":
 		// get question from args and print the answer
-		if cli.Q.Question == "" {
-			Fpf(config.Stderr, "Error: q command requires a question argument\n")
-			rc = 1
+		question := cli.Q.Question
+		var prev *core.Question
+		if cli.Q.Compare {
+			// get the last answer before asking again
+			prev, err = grok.PreviousAnswer(question)
+			Ck(err)
+			question = prev.Question
+		}
+		if question == "" {
+			rc = fail(config, "q command requires a question argument")
 			return
 		}
-		question := cli.Q.Question
-		resp, _, updated, err := answer(grok, question, cli.Global)
+		filter := &core.Filter{Symbols: cli.Q.Symbol, Collections: cli.Q.Collection, Tags: cli.Q.Tag, Labels: cli.Q.Label, Owners: cli.Q.Owner, Langs: cli.Q.Only, Paths: cli.Q.Path}
+		if prev != nil {
+			// compare like with like: retrieve with the filter the
+			// question was asked with
+			filter = prev.Filter
+		}
+		grok.SetFilter(filter)
+		grok.SetContextLimits(cli.Q.K, cli.Q.CtxTokens)
+		for _, buf := range cli.Q.Buffer {
+			path, fn, ok := strings.Cut(buf, "=")
+			if !ok {
+				rc = fail(config, Spf("--buffer takes PATH=FILE, got %q", buf))
+				return
+			}
+			content, err := os.ReadFile(fn)
+			Ck(err)
+			err = grok.SetBuffer(path, content)
+			Ck(err)
+		}
+		err = grok.SetProfile(cli.Q.name())
+		Ck(err)
+		err = grok.SetPersona(cli.Q.Persona)
+		Ck(err)
+		if cli.Q.Decompose {
+			grok.SetDecompose(true)
+		}
+		if cli.Q.NoCorrect {
+			grok.SetCorrect(false)
+		}
+		if cli.Q.Suggest {
+			grok.SetFollowUps(core.DefaultFollowUps)
+		}
+		grok.SetCheckAnswers(cli.Q.Verify)
+		grok.SetBudget(cli.Q.Budget)
+		if cli.Q.AsOf != "" {
+			// answer from a snapshot, which is read-only
+			snap, err := grok.LoadSnapshot(cli.Q.AsOf)
+			Ck(err)
+			snap.SetFilter(filter)
+			snap.SetContextLimits(cli.Q.K, cli.Q.CtxTokens)
+			err = snap.SetProfile(cli.Q.name())
+			Ck(err)
+			err = snap.SetPersona(cli.Q.Persona)
+			Ck(err)
+			if cli.Q.Decompose {
+				snap.SetDecompose(true)
+			}
+			if cli.Q.NoCorrect {
+				snap.SetCorrect(false)
+			}
+			if cli.Q.Suggest {
+				snap.SetFollowUps(core.DefaultFollowUps)
+			}
+			snap.SetCheckAnswers(cli.Q.Verify)
+			snap.SetBudget(cli.Q.Budget)
+			snap.SetLang(grok.Lang())
+			err = attachFiles(snap, cli.Q.Attach)
+			Ck(err)
+			defer snap.Detach()
+			out := cli.Q.streamTo(cli.CI, prev, config.Stdout)
+			resp, err := streamAnswer(snap, question, cli.Global, out)
+			Ck(err)
+			if cli.CI {
+				rc = showAnswerJSON(snap, question, resp)
+				break
+			}
+			switch {
+			case prev != nil:
+				showAnswerDiff(prev, snap, resp)
+			case out != nil:
+				Fpf(out, "\n")
+			default:
+				Pl(resp)
+			}
+			showInjections(config.Stderr, snap)
+			showCorrections(config.Stderr, snap)
+			showDegradations(config.Stderr, snap)
+			showFollowUps(snap)
+			break
+		}
+		err = attachFiles(grok, cli.Q.Attach)
+		Ck(err)
+		defer grok.Detach()
+		out := cli.Q.streamTo(cli.CI, prev, config.Stdout)
+		resp, _, updated, err := answer(grok, question, cli.Global, out)
 		Ck(err)
-		Pl(resp)
+		switch {
+		case cli.CI:
+			rc = showAnswerJSON(grok, question, resp)
+		case prev != nil:
+			showAnswerDiff(prev, grok, resp)
+		case out != nil:
+			Fpf(out, "\n")
+		default:
+			Pl(resp)
+		}
+		if !cli.CI {
+			showInjections(config.Stderr, grok)
+			showCorrections(config.Stderr, grok)
+			showDegradations(config.Stderr, grok)
+			showFollowUps(grok)
+		}
 		if updated {
 			save = true
 		}
+	case "questions":
+		qs, err := grok.Questions()
+		Ck(err)
+		if cli.Questions.Last > 0 && len(qs) > cli.Questions.Last {
+			qs = qs[len(qs)-cli.Questions.Last:]
+		}
+		for _, q := range qs {
+			Pl(q)
+			if cli.Questions.Content {
+				if q.Note != "" {
+					Pf("  note: %s\n", q.Note)
+				}
+				for _, cite := range q.Sources {
+					Pf("  source: %s\n", cite)
+				}
+				Pf("  answer: %s\n", q.Answer)
+			}
+		}
+	case "feedback <id> <rating>":
+		err = grok.Feedback(cli.Feedback.ID, cli.Feedback.Rating, cli.Feedback.Note)
+		Ck(err)
+	case "ask <question>":
+		// answer a question about piped output
+		buf, err := ioutil.ReadAll(config.Stdin)
+		Ck(err)
+		_, err = grok.UpdateEmbeddings()
+		Ck(err)
+		grok.SetFilter(&core.Filter{Collections: cli.Ask.Collection})
+		grok.SetContextLimits(cli.Ask.K, cli.Ask.CtxTokens)
+		err = grok.SetProfile(cli.Ask.name())
+		Ck(err)
+		resp, err := grok.AskAbout(cli.Ask.Question, buf, cli.Global)
+		Ck(err)
+		if cli.CI {
+			rc = showAnswerJSON(grok, cli.Ask.Question, resp)
+		} else {
+			Pl(resp)
+		}
+		save = true
 	case "qc":
 		// get text from stdin and print both text and continuation
 		buf, err := ioutil.ReadAll(config.Stdin)
@@ -544,10 +2273,18 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		question := string(buf)
 		// trim whitespace
 		question = strings.TrimSpace(question)
-		resp, query, updated, err := answer(grok, question, cli.Global)
+		if cli.Qi.Suggest {
+			grok.SetFollowUps(core.DefaultFollowUps)
+		}
+		resp, query, updated, err := answer(grok, question, cli.Global, nil)
 		Ck(err)
 		_ = query
-		Pf("\n%s\n\n%s\n\n", question, resp)
+		if cli.CI {
+			rc = showAnswerJSON(grok, question, resp)
+		} else {
+			Pf("\n%s\n\n%s\n\n", question, resp)
+			showFollowUps(grok)
+		}
 		if updated {
 			save = true
 		}
@@ -568,8 +2305,7 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 	case "similarity <refpath> <paths>":
 		// get paths from args and print the similarity
 		if cli.Similarity.Refpath == "" || len(cli.Similarity.Paths) < 1 {
-			Fpf(config.Stderr, "Error: similarity command requires at least two filename arguments\n")
-			rc = 1
+			rc = fail(config, "similarity command requires at least two filename arguments")
 			return
 		}
 		refpath := cli.Similarity.Refpath
@@ -588,15 +2324,64 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		for i, sim := range sims {
 			Pf("%f %s\n", sim, paths[i])
 		}
+	case "stale-docs":
+		var refs []core.StaleRef
+		refs, err = grok.StaleDocs()
+		Ck(err)
+		for _, ref := range refs {
+			Pf("%s not found in the code\n", ref)
+		}
+		if len(refs) > 0 {
+			rc = 1
+			return
+		}
+	case "todos":
+		var todos []*core.Todo
+		todos, err = grok.Todos()
+		Ck(err)
+		if cli.Todos.List {
+			for _, t := range todos {
+				Pl(t)
+			}
+			break
+		}
+		var groups [][]*core.Todo
+		groups, err = grok.ClusterTodos(todos)
+		Ck(err)
+		var list string
+		list, err = grok.PrioritizeTodos(groups)
+		Ck(err)
+		Pf("%s", list)
+	case "plugins":
+		for _, p := range core.Plugins() {
+			Pf("%-7s %-20s %s\n", p.Kind, p.Name, p.Path)
+		}
 	case "tc":
 		// get content from stdin and emit token count on stdout
 		buf, err := ioutil.ReadAll(config.Stdin)
 		Ck(err)
 		in := string(buf)
 		in = strings.TrimSpace(in)
-		count, err := grok.TokenCount(in)
+		count, err := core.CountTokens(in)
 		Ck(err)
 		Pf("%d\n", count)
+	case "tc <paths>":
+		counts, err := core.CountFileTokens(cli.Tc.Paths, config.Stdin)
+		Ck(err)
+		total := 0
+		for _, c := range counts {
+			Pf("%8d %s\n", c.Tokens, c.Path)
+			total += c.Tokens
+		}
+		Pf("%8d total\n", total)
+		cost, err := core.EstimateCost(total, cli.ModelOverride)
+		Ck(err)
+		Pf("embedding with %s: $%.4f\n", cost.EmbeddingModel, cost.EmbeddingCost)
+		price := "price unknown"
+		if cost.PriceKnown {
+			price = Spf("$%.4f", cost.PromptCost)
+		}
+		Pf("prompt to %s: %s, %.1f%% of its %d-token context\n", cost.Model, price, 100*float64(total)/float64(cost.TokenLimit), cost.TokenLimit)
 	case "msg <sysmsg>":
 		// get message from stdin and print response
 		buf, err := ioutil.ReadAll(config.Stdin)
@@ -643,8 +2428,7 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		Ck(err)
 		Pf("backup of grok db saved to %s\n", fn)
 	default:
-		Fpf(config.Stderr, "Error: unrecognized command: %s\n", ctx.Command())
-		rc = 1
+		rc = fail(config, Spf("unrecognized command: %s", ctx.Command()))
 		return
 	}
 
@@ -657,11 +2441,453 @@ func Cli(args []string, config *CliConfig) (rc int, err error) {
 		Ck(err)
 	}
 
+	if cli.Manifest != "" && grok != nil {
+		err = grok.WriteManifest(cli.Manifest, args)
+		Ck(err)
+	}
+
+	return
+}
+
+// registry runs the db subcommands.
+func registry(cmd string) (err error) {
+	defer Return(&err)
+	r, err := core.LoadRegistry()
+	Ck(err)
+	switch cmd {
+	case "db list":
+		for _, name := range r.Names() {
+			mark := " "
+			if name == r.Current {
+				mark = "*"
+			}
+			Pf("%s %-20s %s\n", mark, name, r.Databases[name])
+		}
+		return
+	case "db add <name> <path>":
+		err = r.Add(cli.Db.Add.Name, cli.Db.Add.Path)
+	case "db use <name>":
+		err = r.Use(cli.Db.Use.Name)
+	case "db rm <name>":
+		err = r.Remove(cli.Db.Rm.Name)
+	}
+	Ck(err)
+	err = r.Save()
+	Ck(err)
+	return
+}
+
+// showAnswerDiff prints the differences between a previous answer
+// from the question log and the new answer.
+func showAnswerDiff(prev *core.Question, grok *core.Grokker, resp string) {
+	Pf("--- question %d, %s, %s\n", prev.ID, prev.Model, prev.Time.Format(time.RFC3339))
+	Pf("+++ now, %s\n", grok.Model)
+	if strings.TrimSpace(prev.Answer) == strings.TrimSpace(resp) {
+		Pf("(answer unchanged)\n")
+	} else {
+		Pf("%s", util.LineDiff(strings.TrimSpace(prev.Answer)+"\n", strings.TrimSpace(resp)+"\n"))
+	}
+	sources := grok.Sources()
+	for _, cite := range prev.Sources {
+		if !util.StringInSlice(cite, sources) {
+			Pf("- source: %s\n", cite)
+		}
+	}
+	for _, cite := range sources {
+		if !util.StringInSlice(cite, prev.Sources) {
+			Pf("+ source: %s\n", cite)
+		}
+	}
+}
+
+// The exit codes with --ci, so pipelines can tell failures apart.
+// Other failures exit 1.
+var ciExitCodes = map[string]int{
+	ciUsage:              2,
+	core.FailNoDB:        3,
+	core.FailRateLimited: 4,
+	core.FailBudget:      5,
+	ciLowConfidence:      6,
+}
+
+// The failure classes the cli adds to core's; see core.FailureClass.
+const (
+	// ciUsage means the command needs input that --ci won't ask
+	// for.
+	ciUsage = "usage"
+	// ciLowConfidence means an answer is low-confidence; see
+	// core.Grokker.LowConfidence.
+	ciLowConfidence = "low_confidence"
+)
+
+// ciError is an error as printed with --ci.
+type ciError struct {
+	Error string `json:"error"`
+	Class string `json:"class,omitempty"`
+	Code  int    `json:"exit_code"`
+}
+
+// ciFail prints an error as JSON and returns the exit code for its
+// class.
+func ciFail(w io.Writer, class, msg string) (rc int) {
+	rc, ok := ciExitCodes[class]
+	if !ok {
+		rc = 1
+	}
+	buf, _ := json.Marshal(ciError{Error: msg, Class: class, Code: rc})
+	Fpf(w, "%s\n", buf)
+	return
+}
+
+// fail prints the error of a command that failed without returning
+// one, as JSON with --ci, and returns the exit code.
+func fail(config *CliConfig, msg string) (rc int) {
+	if cli.CI {
+		return ciFail(config.Stderr, "", msg)
+	}
+	Fpf(config.Stderr, "Error: %s\n", msg)
+	return 1
+}
+
+// ciRequested returns true if args or the environment ask for --ci,
+// for when the arguments don't parse.
+func ciRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--ci" || arg == "--ci=true" || arg == "--ci=1" {
+			return true
+		}
+	}
+	ci, _ := strconv.ParseBool(os.Getenv("GROKKER_CI"))
+	return ci
+}
+
+// ciResult is what a command other than a query printed, as printed
+// with --ci.
+type ciResult struct {
+	Command string `json:"command"`
+	// Result is the output if it is JSON, and Output is the
+	// output otherwise.
+	Result json.RawMessage `json:"result,omitempty"`
+	Output string          `json:"output,omitempty"`
+	Code   int             `json:"exit_code"`
+}
+
+// ciPrint prints what a command printed as JSON.
+func ciPrint(w io.Writer, cmd string, out []byte, rc int) {
+	res := ciResult{Command: cmd, Code: rc}
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) > 0 && json.Valid(trimmed) {
+		res.Result = trimmed
+	} else {
+		res.Output = string(out)
+	}
+	buf, _ := json.MarshalIndent(res, "", "  ")
+	Fpf(w, "%s\n", buf)
+}
+
+// errText returns the message of err without the file names and line
+// numbers goadapt adds.
+func errText(err error) string {
+	var ae *AdaptErr
+	if errors.As(err, &ae) {
+		return ae.Msg()
+	}
+	return err.Error()
+}
+
+// isTerminal returns true if rw, a reader or writer, is a terminal.
+func isTerminal(rw interface{}) bool {
+	f, ok := rw.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ciAnswer is an answer as printed with --ci.
+type ciAnswer struct {
+	Question  string   `json:"question"`
+	Answer    string   `json:"answer"`
+	Sources   []string `json:"sources"`
+	SourceIDs []string `json:"source_ids"`
+	FollowUps []string `json:"follow_ups,omitempty"`
+	// LowConfidence says why the answer is low-confidence, if it
+	// is.
+	LowConfidence string `json:"low_confidence,omitempty"`
+	// Injections are the context chunks that read like
+	// instructions to the model; see core/injection.go.
+	Injections []core.InjectionFlag `json:"injections,omitempty"`
+	// Corrections are the misspelled symbols corrected before
+	// retrieval; see core/correct.go.
+	Corrections []core.Correction `json:"corrections,omitempty"`
+	// Degradations are what was given up to keep to --budget; see
+	// core/budget.go.
+	Degradations []string `json:"degradations,omitempty"`
+}
+
+// showAnswerJSON prints an answer as JSON, and returns the exit code
+// for a low-confidence answer if it is one.
+func showAnswerJSON(grok *core.Grokker, question, resp string) (rc int) {
+	a := ciAnswer{
+		Question:      question,
+		Answer:        resp,
+		Sources:       grok.Sources(),
+		SourceIDs:     grok.SourceIDs(),
+		FollowUps:     grok.FollowUps(),
+		LowConfidence: grok.LowConfidence(cli.LowConf),
+		Injections:    grok.InjectionFlags(),
+		Corrections:   grok.Corrections(),
+		Degradations:  grok.Degradations(),
+	}
+	buf, err := json.MarshalIndent(a, "", "  ")
+	Ck(err)
+	Pf("%s\n", buf)
+	if a.LowConfidence != "" {
+		rc = ciExitCodes[ciLowConfidence]
+	}
+	return
+}
+
+// loadSecretKey reads a minisign secret key file, or returns nil if
+// path is empty.
+func loadSecretKey(path string) (key *core.SecretKey, err error) {
+	defer Return(&err)
+	if path == "" {
+		return
+	}
+	buf, err := ioutil.ReadFile(path)
+	Ck(err)
+	k, err := core.ParseSecretKey(string(buf))
+	Ck(err, "%s", path)
+	key = &k
+	return
+}
+
+// setActionOutputs sets GitHub Actions step outputs, given as name,
+// value pairs.
+func setActionOutputs(pairs ...string) (err error) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		err = core.SetActionOutput(pairs[i], pairs[i+1])
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
+// firstLine returns the first line of s.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// showFollowUps prints the follow-up questions suggested after the
+// last answer, if any.
+// showInjections warns on w about the context chunks the injection
+// detector flagged.
+func showInjections(w io.Writer, grok *core.Grokker) {
+	for _, flag := range grok.InjectionFlags() {
+		if flag.Dropped {
+			Fpf(w, "warning: left out %s, which reads like instructions to the model (%s: %q)\n", flag.Cite, flag.Pattern, flag.Excerpt)
+		} else {
+			Fpf(w, "warning: the context includes %s, which reads like instructions to the model (%s: %q)\n", flag.Cite, flag.Pattern, flag.Excerpt)
+		}
+	}
+}
+
+// showCorrections tells what was corrected in the question before
+// retrieval.
+func showCorrections(w io.Writer, grok *core.Grokker) {
+	for _, c := range grok.Corrections() {
+		Fpf(w, "corrected: %s\n", c)
+	}
+}
+
+// showDegradations tells what was given up to keep an answer to its
+// budget.
+func showDegradations(w io.Writer, grok *core.Grokker) {
+	for _, what := range grok.Degradations() {
+		Fpf(w, "budget: %s\n", what)
+	}
+}
+
+// showBulk lists the documents a bulk operation changed, or with
+// dryRun would change, on stdout, and how many on stderr.
+func showBulk(config *CliConfig, verb, done string, paths []string, dryRun bool) {
+	for _, path := range paths {
+		Fpf(config.Stdout, "%s\n", path)
+	}
+	if dryRun {
+		Fpf(config.Stderr, "would %s %d documents; run again without --dry-run to do it\n", verb, len(paths))
+		return
+	}
+	Fpf(config.Stderr, "%s %d documents\n", done, len(paths))
+}
+
+func showFollowUps(grok *core.Grokker) {
+	suggestions := grok.FollowUps()
+	if len(suggestions) == 0 {
+		return
+	}
+	Pf("\nFollow-up questions:\n")
+	for i, q := range suggestions {
+		Pf("%d. %s\n", i+1, q)
+	}
+}
+
+// showComparison writes a markdown report of a comparison: a table
+// of tokens, latency, and sources for each question, followed by the
+// answers, and a summary table at the end.
+func showComparison(w io.Writer, cfgs []*core.CompareConfig, results []core.CompareResult) {
+	row := func(label string, cells []string) {
+		Fpf(w, "| %s | %s |\n", label, strings.Join(cells, " | "))
+	}
+	header := func() {
+		var names, rules []string
+		for _, cfg := range cfgs {
+			names = append(names, cfg.Name)
+			rules = append(rules, "---")
+		}
+		row("", names)
+		row("---", rules)
+	}
+	tokens := make([]int, len(cfgs))
+	latency := make([]time.Duration, len(cfgs))
+	errs := make([]int, len(cfgs))
+	for i, res := range results {
+		Fpf(w, "## %d. %s\n\n", i+1, res.Question)
+		header()
+		var tcells, lcells, scells []string
+		for j, run := range res.Runs {
+			tokens[j] += run.Tokens
+			latency[j] += run.Latency
+			if run.Error != "" {
+				errs[j]++
+			}
+			tcells = append(tcells, Spf("%d", run.Tokens))
+			lcells = append(lcells, run.Latency.Round(time.Millisecond).String())
+			scells = append(scells, strings.Join(run.Sources, "<br>"))
+		}
+		row("tokens", tcells)
+		row("latency", lcells)
+		row("sources", scells)
+		Fpf(w, "\n")
+		for j, run := range res.Runs {
+			Fpf(w, "### %s\n\n", cfgs[j].Name)
+			if run.Error != "" {
+				Fpf(w, "Error: %s\n\n", run.Error)
+				continue
+			}
+			Fpf(w, "%s\n\n", strings.TrimSpace(run.Answer))
+		}
+	}
+	Fpf(w, "## Summary\n\n")
+	header()
+	var tcells, lcells, ecells []string
+	for j := range cfgs {
+		tcells = append(tcells, Spf("%d", tokens[j]))
+		mean := time.Duration(0)
+		if len(results) > 0 {
+			mean = latency[j] / time.Duration(len(results))
+		}
+		lcells = append(lcells, mean.Round(time.Millisecond).String())
+		ecells = append(ecells, Spf("%d", errs[j]))
+	}
+	row("total tokens", tcells)
+	row("mean latency", lcells)
+	row("errors", ecells)
+}
+
+// showModelBench prints the results of BenchModels as markdown: a
+// summary table, a recommendation, and each question's answers with
+// their scores.
+func showModelBench(w io.Writer, models []string, results []core.ModelBenchResult, minScore float64) {
+	summaries := core.SummarizeModelBench(models, results)
+	Fpf(w, "## Summary\n\n")
+	Fpf(w, "| model | score | judged | mean latency | prompt tokens | completion tokens | cost | errors |\n")
+	Fpf(w, "| --- | --- | --- | --- | --- | --- | --- | --- |\n")
+	for _, sum := range summaries {
+		cost := "unknown"
+		if sum.PriceKnown {
+			cost = Spf("$%.4f", sum.Cost)
+		}
+		Fpf(w, "| %s | %.2f | %d/%d | %s | %d | %d | %s | %d |\n", sum.Model, sum.Score, sum.Judged, len(results),
+			sum.Latency.Round(time.Millisecond), sum.PromptTokens, sum.CompletionTokens, cost, sum.Errors)
+	}
+	Fpf(w, "\n")
+	if best := core.CheapestAdequate(summaries, minScore); best != "" {
+		Fpf(w, "Cheapest model with a mean score of at least %.1f: %s\n\n", minScore, best)
+	} else {
+		Fpf(w, "No model with a known price answered every question with a mean score of at least %.1f.\n\n", minScore)
+	}
+	for i, res := range results {
+		Fpf(w, "## %d. %s\n\n", i+1, res.Question)
+		for j, run := range res.Runs {
+			Fpf(w, "### %s\n\n", models[j])
+			if run.Error != "" {
+				Fpf(w, "Error: %s\n\n", run.Error)
+				continue
+			}
+			Fpf(w, "Score: %d/5 (%s), %s\n\n", run.Score, run.Reason, run.Latency.Round(time.Millisecond))
+			Fpf(w, "%s\n\n", strings.TrimSpace(run.Answer))
+		}
+	}
+}
+
+// showStatus prints provider health, the current model, database
+// stats, and cache hit rates.
+func showStatus(grok *core.Grokker) {
+	m, _, err := grok.GetModel()
+	Ck(err)
+	Pf("model: %s\n", m)
+	if grok.EmbeddingProvider != "" {
+		Pf("embedder: %s:%s\n", grok.EmbeddingProvider, grok.EmbeddingModel)
+	}
+
+	stats := grok.Stats()
+	if stats.Store == "sqlite" {
+		Pf("\ndatabase: %s (%d bytes, sqlite store %d bytes)\n", stats.Path, stats.Size, stats.SqliteSize)
+	} else {
+		Pf("\ndatabase: %s (%d bytes, journal %d bytes)\n", stats.Path, stats.Size, stats.JournalSize)
+	}
+	Pf("  documents: %d (%d virtual) in %d collections\n", stats.Documents, stats.Virtual, stats.Collections)
+	Pf("  chunks: %d, %d embedded, %d excluded\n", stats.Chunks, stats.Embedded, stats.Excluded)
+
+	providers, caches := core.Health()
+	Pf("\nproviders:\n")
+	if len(providers) == 0 {
+		Pf("  no requests recorded\n")
+	}
+	for _, h := range providers {
+		Pf("  %s: %d requests since %s, %d errors, p50 %s, p95 %s\n",
+			h.Name, h.Requests, h.Since.Format(time.RFC3339), h.Errors,
+			h.P50.Round(time.Millisecond), h.P95.Round(time.Millisecond))
+		if h.LastError != "" {
+			Pf("    last error at %s: %s\n", h.LastErrorTime.Format(time.RFC3339), h.LastError)
+		}
+	}
+
+	Pf("\ncaches:\n")
+	if len(caches) == 0 {
+		Pf("  no lookups recorded\n")
+	}
+	var names []string
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := caches[name]
+		Pf("  %s: %d hits, %d misses (%.0f%%)\n", name, c.Hits, c.Misses, c.HitRate()*100)
+	}
+}
+
 // answer a question
-func answer(grok *core.Grokker, question string, global bool) (resp, query string, updated bool, err error) {
+func answer(grok *core.Grokker, question string, global bool, out io.Writer) (resp, query string, updated bool, err error) {
 	defer Return(&err)
 
 	// update the knowledge base
@@ -669,9 +2895,74 @@ func answer(grok *core.Grokker, question string, global bool) (resp, query strin
 	Ck(err)
 
 	// answer the question
-	resp, err = grok.Answer(question, false, false, global)
+	resp, err = streamAnswer(grok, question, global, out)
+	Ck(err)
+
+	return
+}
+
+// streamAnswer answers a question, writing the answer to out as it
+// arrives unless out is nil.
+func streamAnswer(grok *core.Grokker, question string, global bool, out io.Writer) (resp string, err error) {
+	if out == nil {
+		return grok.Answer(question, false, false, global)
+	}
+	return grok.AnswerStream(question, false, false, global, func(text string) error {
+		_, err := io.WriteString(out, text)
+		return err
+	})
+}
+
+// chatSession reads prompts from config.Stdin, one per line, and
+// writes the responses to config.Stdout, until /exit or the end of
+// the input.  If addToDb is true, the session's chat history is added
+// to the knowledge base, which is saved, after every turn.
+func chatSession(config *CliConfig, grok *core.Grokker, name, sysmsg string, addToDb bool) (err error) {
+	defer Return(&err)
+	history, err := grok.OpenChatSession(name, sysmsg)
 	Ck(err)
+	if n := history.Turns(); n > 0 {
+		Fpf(config.Stderr, "resuming session %s, %d turns; /exit to quit\n", name, n)
+	} else {
+		Fpf(config.Stderr, "new session %s; /exit to quit\n", name)
+	}
+	in := bufio.NewReader(config.Stdin)
+	for {
+		Fpf(config.Stderr, "> ")
+		line, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		prompt := strings.TrimSpace(line)
+		if prompt == "/exit" || prompt == "/quit" {
+			return nil
+		}
+		if prompt != "" {
+			resp, err := history.Turn(prompt, addToDb)
+			Ck(err)
+			Fpf(config.Stdout, "%s\n\n", strings.TrimSpace(resp))
+			if addToDb {
+				err = grok.Save()
+				Ck(err)
+			}
+		}
+		if err == io.EOF {
+			Fpf(config.Stderr, "\n")
+			return nil
+		}
+	}
+}
 
+// attachFiles attaches files to g for the rest of the command; see
+// core/attach.go.
+func attachFiles(g *core.Grokker, paths []string) (err error) {
+	defer Return(&err)
+	for _, fn := range paths {
+		content, err := os.ReadFile(fn)
+		Ck(err)
+		err = g.Attach(fn, content)
+		Ck(err)
+	}
 	return
 }
 
@@ -752,21 +3043,8 @@ func EditFile(fn string) (err error) {
 	}
 
 	// open the file in the editor
-	editor := os.Getenv("GROKKER_EDITOR")
-	if editor == "" {
-		editor = "vi +"
-	}
-	// use shlex to split the editor command
-	cmdline, err := shlex.Split(editor, true)
+	cmd, err := util.Command(util.Editor("GROKKER_EDITOR"), fn)
 	Ck(err)
-	editor = cmdline[0]
-	var args []string
-	if len(cmdline) == 1 {
-		args = []string{fn}
-	} else {
-		args = append(cmdline[1:], fn)
-	}
-	cmd := exec.Command(editor, args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
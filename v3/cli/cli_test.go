@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -630,3 +631,28 @@ func TestCliChat(t *testing.T) {
 	Tassert(t, bytes.Equal(bufA, bufD), dumpDiff(bufA, bufD))
 
 }
+
+func TestCliCI(t *testing.T) {
+	t.Setenv("GROKKER_CONFIG_DIR", core.TmpTestDir())
+	var emptyStdin bytes.Buffer
+
+	// arguments that don't parse are a usage error
+	_, stderr, err := grok(emptyStdin, "--ci", "ls", "--no-such-flag")
+	Tassert(t, err != nil, "expected an error")
+	var ciErr ciError
+	jerr := json.Unmarshal(stderr.Bytes(), &ciErr)
+	Tassert(t, jerr == nil, "expected a JSON error, got %q", stderr.String())
+	Tassert(t, ciErr.Class == ciUsage && ciErr.Code == 2 && strings.Contains(ciErr.Error, "no-such-flag"), "got %+v", ciErr)
+	t.Setenv("GROKKER_CI", "1")
+	_, stderr, _ = grok(emptyStdin, "ls", "--no-such-flag")
+	Tassert(t, json.Valid(stderr.Bytes()), "expected a JSON error with GROKKER_CI, got %q", stderr.String())
+	t.Setenv("GROKKER_CI", "")
+
+	// what other commands print is wrapped
+	stdout, stderr, err := grok(emptyStdin, "--ci", "db", "list")
+	Tassert(t, err == nil, "CLI returned unexpected error: %v %s", err, stderr.String())
+	var res ciResult
+	jerr = json.Unmarshal(stdout.Bytes(), &res)
+	Tassert(t, jerr == nil, "expected JSON, got %q", stdout.String())
+	Tassert(t, res.Command == "db list" && res.Code == 0, "got %+v", res)
+}
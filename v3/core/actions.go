@@ -0,0 +1,319 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Meeting notes and transcripts record what was decided and who
+// agreed to do what, but the record is spread across many files.
+// Notes finds the notes dated since a given time, and Actions asks
+// the model for the decisions and action items in them, each with
+// its owner and the path and line it came from.  A note is dated by
+// its frontmatter date, a date in its file name, e.g.
+// 2024-03-01-standup.md, or else the time the file was last changed.
+// Notes too long for one request are sent in parts.
+
+// notesCollections are the collections searched for notes when none
+// are given.
+var notesCollections = []string{"notes", "transcripts", "meetings"}
+
+// noteDateFormats are the date formats accepted in frontmatter.
+var noteDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+}
+
+// noteNameDateRe matches a date in a file name.
+var noteNameDateRe = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+// sinceRe matches a relative time, e.g. 2w or 36h.
+var sinceRe = regexp.MustCompile(`^(\d+)([mhdw])$`)
+
+const actionsSysmsg = `You extract decisions and action items from meeting notes and transcripts.  Each file is given with its path and date, one line per line of the file, each starting with its line number.  Reply with only a JSON array, e.g.
+[{"kind": "decision", "text": "Move the launch to May.", "path": "notes/2024-03-01.md", "line": 12},
+ {"kind": "action", "text": "Update the release checklist.", "owner": "Ann", "due": "Friday", "path": "notes/2024-03-01.md", "line": 15}]
+where kind is "decision" or "action", owner is the person or team who agreed to do the work, if the notes say, due is the deadline, if any, and path and line are where the notes record it.  Leave out discussion that didn't end in a decision or an assignment, and don't invent owners or deadlines.  Reply with [] if there are none.`
+
+// Note is a meeting note or transcript.
+type Note struct {
+	RelPath string
+	Date    time.Time
+}
+
+// ActionItem is a decision or action item from a note.
+type ActionItem struct {
+	// Kind is "decision" or "action".
+	Kind  string `json:"kind"`
+	Text  string `json:"text"`
+	Owner string `json:"owner,omitempty"`
+	Due   string `json:"due,omitempty"`
+	Path  string `json:"path"`
+	Line  int    `json:"line"`
+}
+
+// String returns the item as a line of markdown, e.g. "Update the
+// checklist (owner: Ann; due: Friday) [notes/standup.md:15]".
+func (a *ActionItem) String() string {
+	var details []string
+	if a.Kind == "action" {
+		owner := a.Owner
+		if owner == "" {
+			owner = "unassigned"
+		}
+		details = append(details, "owner: "+owner)
+	}
+	if a.Due != "" {
+		details = append(details, "due: "+a.Due)
+	}
+	s := a.Text
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, "; ") + ")"
+	}
+	return Spf("%s [%s:%d]", s, a.Path, a.Line)
+}
+
+// ParseSince parses a relative time such as 30m, 36h, 3d, or 2w,
+// meaning that long before now, or a date such as 2024-03-01.
+func ParseSince(s string, now time.Time) (since time.Time, err error) {
+	s = strings.TrimSpace(s)
+	m := sinceRe.FindStringSubmatch(s)
+	if m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return since, fmt.Errorf("invalid time %q: %v", s, err)
+		}
+		unit := map[string]time.Duration{
+			"m": time.Minute,
+			"h": time.Hour,
+			"d": 24 * time.Hour,
+			"w": 7 * 24 * time.Hour,
+		}[m[2]]
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+	since, ok := parseNoteDate(s)
+	if !ok {
+		err = fmt.Errorf("invalid time %q: expected e.g. 2w, 3d, 36h, or 2024-03-01", s)
+	}
+	return
+}
+
+// parseNoteDate parses a date in one of noteDateFormats, in local
+// time unless it has a zone.
+func parseNoteDate(s string) (t time.Time, ok bool) {
+	for _, layout := range noteDateFormats {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, true
+		}
+	}
+	return
+}
+
+// noteDate returns the date of a note.
+func (g *Grokker) noteDate(doc *Document) (date time.Time, ok bool) {
+	if doc.Meta != nil && doc.Meta.Date != "" {
+		date, ok = parseNoteDate(doc.Meta.Date)
+		if ok {
+			return
+		}
+		Debug("%s: can't parse date %q", doc.RelPath, doc.Meta.Date)
+	}
+	if m := noteNameDateRe.FindString(path.Base(doc.RelPath)); m != "" {
+		date, ok = parseNoteDate(m)
+		if ok {
+			return
+		}
+	}
+	if !doc.Virtual {
+		info, err := os.Stat(g.absPath(doc))
+		if err == nil {
+			return info.ModTime(), true
+		}
+	}
+	if doc.Indexed != nil {
+		return *doc.Indexed, true
+	}
+	return
+}
+
+// Notes returns the notes in the given collections dated at or after
+// since, oldest first.  Without collections, the notes, transcripts,
+// and meetings collections are searched.
+func (g *Grokker) Notes(since time.Time, collections []string) (notes []*Note, err error) {
+	defer Return(&err)
+	if len(collections) == 0 {
+		collections = notesCollections
+	}
+	for _, doc := range g.Documents {
+		if !util.StringInSlice(doc.collection(), collections) {
+			continue
+		}
+		date, ok := g.noteDate(doc)
+		if !ok || date.Before(since) {
+			continue
+		}
+		notes = append(notes, &Note{RelPath: doc.RelPath, Date: date})
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].Date.Before(notes[j].Date)
+	})
+	return
+}
+
+// noteParts returns a note's lines, numbered, in parts that each
+// fit in budget tokens.
+func (g *Grokker) noteParts(note *Note, budget int) (parts []string, err error) {
+	defer Return(&err)
+	var doc *Document
+	for _, d := range g.Documents {
+		if d.RelPath == note.RelPath {
+			doc = d
+		}
+	}
+	Assert(doc != nil, "missing document %s", note.RelPath)
+	buf, err := os.ReadFile(g.absPath(doc))
+	if os.IsNotExist(err) {
+		// removed since it was indexed
+		return nil, nil
+	}
+	Ck(err)
+	header := Spf("File: %s (%s)\n", note.RelPath, note.Date.Format("2006-01-02"))
+	htc, err := g.TokenCount(header)
+	Ck(err)
+	var part strings.Builder
+	tc := htc
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	for i, line := range lines {
+		text := Spf("%d: %s\n", i+1, line)
+		ltc, err := g.TokenCount(text)
+		Ck(err)
+		if part.Len() > 0 && tc+ltc > budget {
+			parts = append(parts, header+part.String())
+			part.Reset()
+			tc = htc
+		}
+		part.WriteString(text)
+		tc += ltc
+	}
+	if part.Len() > 0 {
+		parts = append(parts, header+part.String())
+	}
+	return
+}
+
+// Actions returns the decisions and action items in notes, in the
+// order of the notes and their lines.  Notes are sent to the model
+// in as few requests as fit in half its token limit, leaving the
+// rest for the response.
+func (g *Grokker) Actions(notes []*Note) (items []*ActionItem, err error) {
+	defer Return(&err)
+	budget := g.TokenLimit / 2
+	order := make(map[string]int, len(notes))
+	for i, note := range notes {
+		order[note.RelPath] = i
+	}
+	var batch strings.Builder
+	used := 0
+	flush := func() (err error) {
+		defer Return(&err)
+		if batch.Len() == 0 {
+			return
+		}
+		resp, err := g.msg(actionsSysmsg, batch.String())
+		Ck(err)
+		found, err := parseActions(resp.Choices[0].Message.Content)
+		Ck(err)
+		for _, item := range found {
+			if _, ok := order[item.Path]; !ok {
+				Debug("dropping item citing unknown note %q: %s", item.Path, item.Text)
+				continue
+			}
+			items = append(items, item)
+		}
+		batch.Reset()
+		used = 0
+		return
+	}
+	for _, note := range notes {
+		parts, err := g.noteParts(note, budget)
+		Ck(err)
+		for _, part := range parts {
+			tc, err := g.TokenCount(part)
+			Ck(err)
+			if used+tc > budget {
+				err = flush()
+				Ck(err)
+			}
+			batch.WriteString(part + "\n")
+			used += tc
+		}
+	}
+	err = flush()
+	Ck(err)
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if order[a.Path] != order[b.Path] {
+			return order[a.Path] < order[b.Path]
+		}
+		return a.Line < b.Line
+	})
+	return
+}
+
+// parseActions parses the model's items, which may be wrapped in a
+// markdown code block.
+func parseActions(resp string) (items []*ActionItem, err error) {
+	start := strings.Index(resp, "[")
+	end := strings.LastIndex(resp, "]")
+	if start < 0 || end < start {
+		err = fmt.Errorf("expected a JSON array of action items, got %q", resp)
+		return
+	}
+	err = json.Unmarshal([]byte(resp[start:end+1]), &items)
+	if err != nil {
+		err = fmt.Errorf("can't parse action items %q: %v", resp, err)
+	}
+	return
+}
+
+// ActionsReport returns the decisions and action items as markdown.
+func ActionsReport(items []*ActionItem) string {
+	var decisions, actions []string
+	for _, item := range items {
+		switch item.Kind {
+		case "decision":
+			decisions = append(decisions, "- "+item.String())
+		default:
+			actions = append(actions, "- [ ] "+item.String())
+		}
+	}
+	if len(decisions)+len(actions) == 0 {
+		return "No decisions or action items found.\n"
+	}
+	var out strings.Builder
+	if len(decisions) > 0 {
+		out.WriteString("## Decisions\n\n" + strings.Join(decisions, "\n") + "\n")
+	}
+	if len(actions) > 0 {
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString("## Action items\n\n" + strings.Join(actions, "\n") + "\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local)
+	cases := map[string]time.Time{
+		"2w":         now.Add(-14 * 24 * time.Hour),
+		"3d":         now.Add(-3 * 24 * time.Hour),
+		"36h":        now.Add(-36 * time.Hour),
+		"30m":        now.Add(-30 * time.Minute),
+		"2024-03-01": time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local),
+	}
+	for in, want := range cases {
+		got, err := ParseSince(in, now)
+		Tassert(t, err == nil, "error parsing %q: %v", in, err)
+		Tassert(t, got.Equal(want), "%q: expected %v, got %v", in, want, got)
+	}
+	for _, in := range []string{"", "2y", "last week"} {
+		_, err := ParseSince(in, now)
+		Tassert(t, err != nil, "expected an error parsing %q", in)
+	}
+}
+
+func TestNotes(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	recent := time.Now().Add(-24 * time.Hour)
+	docs := []*Document{
+		// dated by frontmatter
+		{RelPath: "standup.md", Collection: "notes", Meta: &DocMeta{Date: recent.Format("2006-01-02")}},
+		// dated by file name
+		{RelPath: "2020-01-06-retro.md", Collection: "notes"},
+		// dated by modification time
+		{RelPath: "call.txt", Collection: "transcripts"},
+		// not a note
+		{RelPath: "main.go"},
+	}
+	for _, doc := range docs {
+		err = os.WriteFile(filepath.Join(dir, doc.RelPath), []byte("Ann will fix the build.\n"), 0644)
+		Tassert(t, err == nil, "error writing file: %v", err)
+		grok.Documents = append(grok.Documents, doc)
+	}
+
+	notes, err := grok.Notes(time.Now().Add(-7*24*time.Hour), nil)
+	Tassert(t, err == nil, "error finding notes: %v", err)
+	Tassert(t, len(notes) == 2, "expected 2 notes, got %d", len(notes))
+	Tassert(t, notes[0].RelPath == "standup.md", "expected standup.md first, got %s", notes[0].RelPath)
+	Tassert(t, notes[1].RelPath == "call.txt", "expected call.txt second, got %s", notes[1].RelPath)
+
+	notes, err = grok.Notes(time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local), []string{"notes"})
+	Tassert(t, err == nil, "error finding notes: %v", err)
+	Tassert(t, len(notes) == 2, "expected 2 notes, got %d", len(notes))
+	Tassert(t, notes[0].RelPath == "2020-01-06-retro.md", "expected the retro first, got %s", notes[0].RelPath)
+
+	// a long note is split into parts, each with the file header
+	var long string
+	for i := 0; i < 200; i++ {
+		long += "Bo will update the release checklist before the launch.\n"
+	}
+	err = os.WriteFile(filepath.Join(dir, "call.txt"), []byte(long), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	parts, err := grok.noteParts(&Note{RelPath: "call.txt", Date: recent}, 500)
+	Tassert(t, err == nil, "error splitting note: %v", err)
+	Tassert(t, len(parts) > 1, "expected the note in parts, got %d", len(parts))
+	for _, part := range parts {
+		tc, err := grok.TokenCount(part)
+		Tassert(t, err == nil, "error counting tokens: %v", err)
+		Tassert(t, tc <= 500, "expected at most 500 tokens, got %d", tc)
+		Tassert(t, strings.HasPrefix(part, "File: call.txt ("), "missing header in %q", part)
+	}
+	Tassert(t, strings.Contains(parts[len(parts)-1], "200: Bo will"), "expected the last line numbered 200")
+}
+
+func TestActionsReport(t *testing.T) {
+	resp := "```json\n" + `[{"kind": "decision", "text": "Ship on Friday.", "path": "standup.md", "line": 3},
+ {"kind": "action", "text": "Fix the build.", "owner": "Ann", "due": "Monday", "path": "standup.md", "line": 5},
+ {"kind": "action", "text": "Write the release notes.", "path": "standup.md", "line": 7}]` + "\n```\n"
+	items, err := parseActions(resp)
+	Tassert(t, err == nil, "error parsing items: %v", err)
+	Tassert(t, len(items) == 3, "expected 3 items, got %d", len(items))
+	want := "## Decisions\n\n" +
+		"- Ship on Friday. [standup.md:3]\n\n" +
+		"## Action items\n\n" +
+		"- [ ] Fix the build. (owner: Ann; due: Monday) [standup.md:5]\n" +
+		"- [ ] Write the release notes. (owner: unassigned) [standup.md:7]\n"
+	got := ActionsReport(items)
+	Tassert(t, got == want, "expected\n%s\ngot\n%s", want, got)
+
+	_, err = parseActions("There are none.")
+	Tassert(t, err != nil, "expected an error without a JSON array")
+	Tassert(t, ActionsReport(nil) == "No decisions or action items found.\n", "unexpected empty report")
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,6 +25,22 @@ import (
 // AddDocument adds a document to the Grokker database. It creates the
 // embeddings for the document and adds them to the database.
 func (g *Grokker) AddDocument(path string) (err error) {
+	defer Return(&err)
+	doc, err := g.addDoc(path)
+	Ck(err)
+	if doc == nil {
+		return
+	}
+	// update the embeddings for the document.
+	_, err = g.updateDocument(doc)
+	Ck(err)
+	return
+}
+
+// addDoc ensures a document is listed in the database and returns
+// it.  It returns a nil doc if the file does not exist or is ignored;
+// see grokignore.go.
+func (g *Grokker) addDoc(path string) (doc *Document, err error) {
 	defer Return(&err)
 	// assume we're in an arbitrary directory, so we need to
 	// convert the path to an absolute path.
@@ -31,15 +48,26 @@ func (g *Grokker) AddDocument(path string) (err error) {
 	Ck(err)
 	// always convert path to a relative path for consistency
 	relpath, err := filepath.Rel(g.Root, absPath)
-	doc := &Document{
-		RelPath: relpath,
+	Ck(err)
+	// store forward slashes so the db works on every platform
+	doc = &Document{
+		RelPath: filepath.ToSlash(relpath),
 	}
 	// ensure the document exists
 	_, err = os.Stat(g.absPath(doc))
 	if os.IsNotExist(err) {
+		doc = nil
+		err = nil
 		return
 	}
 	Ck(err)
+	ignored, err := newIgnorer(g.Root).ignored(doc.RelPath)
+	Ck(err)
+	if ignored {
+		Debug("%s is in .grokignore; not adding it", doc.RelPath)
+		doc = nil
+		return
+	}
 	// find out if the document is already in the database.
 	found := false
 	for _, d := range g.Documents {
@@ -51,14 +79,14 @@ func (g *Grokker) AddDocument(path string) (err error) {
 	if !found {
 		// add the document to the database.
 		g.Documents = append(g.Documents, doc)
+		g.unbury(doc.RelPath)
 	}
-	// update the embeddings for the document.
-	_, err = g.updateDocument(doc)
-	Ck(err)
 	return
 }
 
-// ForgetDocument removes a document from the Grokker database.
+// ForgetDocument removes a document from the Grokker database and
+// leaves a tombstone in its place.  Its content isn't erased until
+// it is purged; see Purge.
 func (g *Grokker) ForgetDocument(path string) (err error) {
 	defer Return(&err)
 	// remove the document from the database.
@@ -81,6 +109,8 @@ func (g *Grokker) ForgetDocument(path string) (err error) {
 		}
 		if match {
 			Debug("forgetting document %s ...", path)
+			err = g.bury(d)
+			Ck(err)
 			g.Documents = append(g.Documents[:i], g.Documents[i+1:]...)
 			break
 		}
@@ -127,10 +157,10 @@ func (g *Grokker) Continue(in string, global bool) (out, sysmsg string, err erro
 	defer Return(&err)
 	sysmsg = SysMsgContinue
 	// tokenize sysmsg
-	_, sysmsgTokens, err := Tokenizer.Encode(sysmsg)
+	sysmsgTokens, err := g.tokens(sysmsg)
 	Ck(err)
 	// tokenize input
-	_, inTokens, err := Tokenizer.Encode(in)
+	inTokens, err := g.tokens(in)
 	Ck(err)
 	// get chunks, sorted by similarity to the txt.
 	tokenLimit := int(float64(g.TokenLimit)*0.4) - len(sysmsgTokens) - len(inTokens)
@@ -139,23 +169,121 @@ func (g *Grokker) Continue(in string, global bool) (out, sysmsg string, err erro
 	// generate the answer.
 	resp, err := g.generate(sysmsg, in, context, global)
 	Ck(err)
-	out = resp.Choices[0].Message.Content
+	out, err = g.PostProcess(resp.Choices[0].Message.Content)
+	Ck(err)
 	Debug("Continue() in: %s\ncontext: %s\nout: %s\n", in, context, out)
 	return
 }
 
-// Answer returns the answer to a question.
+// Answer returns the answer to a question, and records both in the
+// question log; see questions.go.  If answer checking is on, the
+// answer ends with a note on its unsupported claims; see claims.go.
+// If follow-ups are on, the suggestions are available from
+// FollowUps afterwards.
 func (g *Grokker) Answer(question string, withHeaders, withLineNumbers, global bool) (resp string, err error) {
 	defer Return(&err)
-	// tokenize the question
-	qtokens, err := g.tokens(question)
+	g.corrections = nil
+	restore, err := g.fitBudget(question)
 	Ck(err)
-	maxTokens := int(float64(g.TokenLimit)*0.5) - len(qtokens)
-	context, err := g.getContext(question, maxTokens, withHeaders, withLineNumbers, nil)
+	defer restore()
+	var decomposed bool
+	if g.decomposing() {
+		resp, decomposed, err = g.answerDecomposed(question, withHeaders, withLineNumbers, global)
+		Ck(err)
+	}
+	if !decomposed {
+		context, err := g.answerContext(question, withHeaders, withLineNumbers)
+		Ck(err)
+		// generate the answer.
+		respmsg, err := g.generateStream(g.Sysmsg(SysMsgChat), question, context, global, g.answerStream())
+		Ck(err)
+		resp = respmsg.Choices[0].Message.Content
+	}
+	resp, err = g.PostProcess(resp)
+	Ck(err)
+	resp, err = g.checkClaims(resp)
+	Ck(err)
+	err = g.suggestFollowUps(question, resp)
+	Ck(err)
+	err = g.logQuestion(question, g.sources, resp)
 	Ck(err)
-	// generate the answer.
-	respmsg, err := g.generate(SysMsgChat, question, context, global)
-	resp = respmsg.Choices[0].Message.Content
+	return
+}
+
+// AnswerStream is Answer, but calls fn with each piece of the answer
+// as it arrives from the chat model, so it can be shown before it is
+// complete.  The pieces add up to the answer AnswerStream returns.
+// An answer that is rewritten once complete, by post-processors,
+// citation markers, or decomposition, can't be streamed; it is one
+// piece.  So is whatever is added after the answer, such as the note
+// on its unsupported claims, once it is ready.
+func (g *Grokker) AnswerStream(question string, withHeaders, withLineNumbers, global bool, fn func(text string) error) (resp string, err error) {
+	defer Return(&err)
+	var sent strings.Builder
+	g.stream = func(text string) error {
+		sent.WriteString(text)
+		return fn(text)
+	}
+	defer func() { g.stream = nil }()
+	resp, err = g.Answer(question, withHeaders, withLineNumbers, global)
+	Ck(err)
+	// send what wasn't streamed; checkClaims may trim trailing
+	// newlines before adding its note, so compare what was sent
+	// rather than assume it is a prefix
+	n := commonPrefix(resp, sent.String())
+	if n < len(resp) {
+		err = fn(resp[n:])
+		Ck(err)
+	}
+	return
+}
+
+// answerStream returns the func to stream an answer to, or nil if
+// the answer isn't being streamed or would be rewritten once
+// complete.
+func (g *Grokker) answerStream() func(text string) error {
+	if g.stream == nil || len(g.Pipeline.PostProcess) > 0 || g.Pipeline.CitationMarkers {
+		return nil
+	}
+	return g.stream
+}
+
+// commonPrefix returns the length of the longest common prefix of a
+// and b.
+func commonPrefix(a, b string) (n int) {
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return
+}
+
+// answerContext returns the context for answering a question.
+func (g *Grokker) answerContext(question string, withHeaders, withLineNumbers bool) (context string, err error) {
+	defer Return(&err)
+	maxTokens, err := g.answerTokens(question)
+	Ck(err)
+	context, err = g.getContext(question, maxTokens, withHeaders, withLineNumbers, nil)
+	Ck(err)
+	// say who to ask about the sources, in what's left of the
+	// budget
+	tokens, err := g.tokens(context)
+	Ck(err)
+	owners, err := g.ownership(sourceDocs(g.sources), maxTokens-len(tokens))
+	Ck(err)
+	context += owners
+	return
+}
+
+// answerTokens returns how many tokens of context an answer to
+// question may use.
+func (g *Grokker) answerTokens(question string) (maxTokens int, err error) {
+	defer Return(&err)
+	if g.contextTokens > 0 {
+		return g.contextTokens, nil
+	}
+	qtokens, err := g.tokens(question)
+	Ck(err)
+	maxTokens = int(float64(g.TokenLimit)*0.5) - len(qtokens)
 	return
 }
 
@@ -187,10 +315,12 @@ func (g *Grokker) Revise(in string, global, sysmsgin bool) (out, sysmsg string,
 	// generate the answer.
 	resp, err := g.generate(sysmsg, in, context, global)
 	Ck(err)
+	content, err := g.PostProcess(resp.Choices[0].Message.Content)
+	Ck(err)
 	if sysmsgin {
-		out = Spf("%s\n\n%s", sysmsg, resp.Choices[0].Message.Content)
+		out = Spf("%s\n\n%s", sysmsg, content)
 	} else {
-		out = resp.Choices[0].Message.Content
+		out = content
 	}
 
 	Debug("Revise() in: %s\ncontext: %s\nout: %s\n", in, context, out)
@@ -203,16 +333,44 @@ func (g *Grokker) Backup() (backpath string, err error) {
 	defer Return(&err)
 	Assert(g.grokpath != "", "g.grokpath is empty")
 	tmpdir := os.TempDir()
-	deslashed := strings.Replace(g.grokpath, "/", "-", -1)
-	backpath = fmt.Sprintf("%s/grokker-backup-%s%s", tmpdir, time.Now().Format("20060102-150405"), deslashed)
+	// flatten the path, including any Windows volume name, into
+	// the file name
+	deslashed := strings.NewReplacer("/", "-", ":", "").Replace(filepath.ToSlash(g.grokpath))
+	backpath = filepath.Join(tmpdir, fmt.Sprintf("grokker-backup-%s%s", time.Now().Format("20060102-150405"), deslashed))
 	err = util.CopyFile(g.grokpath, backpath)
 	Ck(err, "failed to backup %q to %q", g.grokpath, backpath)
+	for _, suffix := range stores {
+		_, err = os.Stat(g.grokpath + suffix)
+		if err == nil {
+			err = util.CopyFile(g.grokpath+suffix, backpath+suffix)
+			Ck(err, "failed to backup %q", g.grokpath+suffix)
+		}
+	}
+	shards, err := os.ReadDir(g.shardDir())
+	if err == nil {
+		err = os.MkdirAll(backpath+".d", 0755)
+		Ck(err)
+	}
+	for _, fi := range shards {
+		err = util.CopyFile(filepath.Join(g.shardDir(), fi.Name()), filepath.Join(backpath+".d", fi.Name()))
+		Ck(err, "failed to backup shard %q", fi.Name())
+	}
+	err = nil
 	return
 }
 
 // Save saves the Grokker database to the stored path.
 func (g *Grokker) Save() (err error) {
 	defer Return(&err)
+	Assert(g.snapshot == "", "snapshot %q is read-only", g.snapshot)
+	Assert(!g.readOnly, "the knowledge base is read-only")
+
+	if g.pipelineFromDb != nil {
+		// save the pipeline from the db, not the one being tried
+		tmpPipeline := g.Pipeline
+		g.Pipeline = *g.pipelineFromDb
+		defer func() { g.Pipeline = tmpPipeline }()
+	}
 
 	if g.modelOverride {
 		// Temporarily store the original model
@@ -233,37 +391,46 @@ func (g *Grokker) Save() (err error) {
 	return
 }
 
+// SetReadOnly makes the knowledge base read-only for the rest of the
+// process: Save fails and UpdateEmbeddings does nothing, so a
+// prebuilt index is queried exactly as it was built, even if the
+// documents it was built from have changed or are missing.
+func (g *Grokker) SetReadOnly() {
+	g.readOnly = true
+}
+
 // saveToFile handles the actual saving process
 func (g *Grokker) saveToFile() (err error) {
 	defer Return(&err)
-	// open
-	Debug("saving grok file")
-	tmpfn := g.grokpath + ".tmp"
-	fh, err := os.Create(tmpfn)
+	// the db file and the journal only hold the chunks that aren't
+	// in a shard
+	main, err := g.saveShards()
 	Ck(err)
-	// write
-	data, err := json.Marshal(g)
+	chunks := g.Chunks
+	g.Chunks = main
+	defer func() { g.Chunks = chunks }()
+	st, err := g.getStore()
 	Ck(err)
-	_, err = fh.Write(data)
+	err = st.save(g)
 	Ck(err)
-	// close
-	err = fh.Close()
-	Ck(err)
-	// move
-	err = os.Rename(tmpfn, g.grokpath)
-	Ck(err)
-	Debug(" done!")
+	g.markSaved()
 	return
 }
 
 // UpdateEmbeddings updates the embeddings for any documents that have
 // changed since the last time the embeddings were updated.  It returns
-// true if any embeddings were updated.
+// true if any embeddings were updated.  A read-only knowledge base is
+// used as is.
 func (g *Grokker) UpdateEmbeddings() (update bool, err error) {
 	defer Return(&err)
+	if g.readOnly {
+		return
+	}
+	g.gitHead, g.loaderPaths = nil, nil
 	// we use the timestamp of the grokfn as the last embedding update time.
 	lastUpdate, err := g.mtime()
 	Ck(err)
+	ign := newIgnorer(g.Root)
 	for _, doc := range g.Documents {
 		// check if the document has changed.
 		fi, err := os.Stat(g.absPath(doc))
@@ -279,6 +446,13 @@ func (g *Grokker) UpdateEmbeddings() (update bool, err error) {
 		}
 		Ck(err)
 		if fi.ModTime().After(lastUpdate) {
+			if !doc.Virtual {
+				ignored, err := ign.ignored(doc.RelPath)
+				Ck(err)
+				if ignored {
+					continue
+				}
+			}
 			// update the embeddings.
 			Debug("updating embeddings for %s ...", doc.RelPath)
 			updated, err := g.updateDocument(doc)
@@ -335,7 +509,8 @@ func (g *Grokker) Similarity(reftext string, texts ...string) (sims []float64, e
 	return
 }
 
-// TokenCount returns the number of tokens in a string.
+// TokenCount returns the number of tokens in a string, as the chat
+// model counts them.
 func (g *Grokker) TokenCount(text string) (count int, err error) {
 	defer Return(&err)
 	tokens, err := g.tokens(text)
@@ -348,7 +523,9 @@ func (g *Grokker) TokenCount(text string) (count int, err error) {
 // database.
 func (g *Grokker) RefreshEmbeddings() (err error) {
 	defer Return(&err)
+	g.gitHead, g.loaderPaths = nil, nil
 	// regenerate the embeddings for each document.
+	ign := newIgnorer(g.Root)
 	for _, doc := range g.Documents {
 		Fpf(os.Stderr, "refreshing embeddings for %s\n", doc.RelPath)
 		// remove file from list if it doesn't exist.
@@ -361,6 +538,16 @@ func (g *Grokker) RefreshEmbeddings() (err error) {
 			g.ForgetDocument(doc.RelPath)
 			continue
 		}
+		if !doc.Virtual {
+			ignored, err := ign.ignored(doc.RelPath)
+			Ck(err)
+			if ignored {
+				Fpf(os.Stderr, "forgetting %s, which is in .grokignore\n", doc.RelPath)
+				err = g.ForgetDocument(doc.RelPath)
+				Ck(err)
+				continue
+			}
+		}
 		_, err = g.updateDocument(doc)
 		Ck(err)
 	}
@@ -416,16 +603,27 @@ func LoadFrom(grokpath string, modelOverride string, readonly bool) (g *Grokker,
 		Ck(err)
 	}
 	// load the db
-	fh, err := os.Open(g.grokpath)
-	Ck(err)
-	buf, err := ioutil.ReadAll(fh)
+	// read the whole file and close it; Windows can't replace an
+	// open file when we save
+	buf, err := ioutil.ReadFile(g.grokpath)
 	Ck(err)
 	err = json.Unmarshal(buf, g)
 	Ck(err)
+	// read the chunks the db file doesn't have, e.g. from the
+	// journal
+	st, err := g.getStore()
+	Ck(err)
+	err = st.load(g)
+	Ck(err)
+	g.markSaved()
 	// set the root directory, overriding whatever was in the db
 	// - this is necessary because the db might have been moved
 	g.Root, err = filepath.Abs(filepath.Dir(g.grokpath))
 	Ck(err)
+	if !lazyShards {
+		err = g.LoadShards()
+		Ck(err)
+	}
 
 	migrated, oldver, newver, err = g.migrate()
 	Ck(err)
@@ -487,31 +685,41 @@ func InitNamed(rootdir, name, model string) (g *Grokker, err error) {
 	return
 }
 
-// Load loads a Grokker database from the current or any parent directory.
+// Load loads a Grokker database from the current or any parent
+// directory, or else the current database in the registry.
 func Load(modelOverride string, readonly bool) (g *Grokker, migrated bool, oldver, newver string, lock *flock.Flock, err error) {
-	defer Return(&err)
+	return LoadDB("", modelOverride, readonly)
+}
 
-	// find the .grok file in the current or any parent directory
-	grokfnbase := ".grok"
-	grokpath := ""
-	for level := 0; level < 99; level++ {
-		path := strings.Repeat("../", level) + grokfnbase
-		if _, err := os.Stat(path); err == nil {
-			grokpath = path
-			break
-		}
-	}
+// LoadDB loads the database registered under name, or, if name is
+// empty, the one Load would.  See FindDB.
+func LoadDB(name, modelOverride string, readonly bool) (g *Grokker, migrated bool, oldver, newver string, lock *flock.Flock, err error) {
+	defer Return(&err)
+	grokpath, err := FindDB(name)
+	Ck(err)
 	g, migrated, oldver, newver, lock, err = LoadFrom(grokpath, modelOverride, readonly)
 	Ck(err)
 	return
 }
 
-// ListModels lists the available models.
+// ListModels lists the available models, including those served by
+// any configured providers, sorted by name.
 func (g *Grokker) ListModels() (models []*Model, err error) {
 	defer Return(&err)
+	for name := range Providers() {
+		err = g.models.loadProvider(name)
+		if err != nil {
+			// don't let one unreachable provider hide the rest
+			Fpf(os.Stderr, "warning: cannot list %s models: %v\n", name, err)
+			err = nil
+		}
+	}
 	for _, model := range g.models.Available {
 		models = append(models, model)
 	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
 	return
 }
 
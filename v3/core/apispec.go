@@ -0,0 +1,182 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Splitting a large OpenAPI spec or JSON Schema into paragraphs
+// produces chunks that start and end in arbitrary places, so a
+// question about an endpoint retrieves a slice of YAML that may not
+// even say which endpoint it is.  Instead, API specs are rendered as
+// one paragraph per operation, headed by its method and path, e.g.
+// "POST /pets", and one paragraph per schema definition, headed by
+// its name.  Each paragraph becomes a chunk, with the heading as its
+// section and cited at the line where the operation or schema starts
+// in the spec.  The rendering is
+// stored like the output of an index hook, and a hook that matches a
+// spec replaces it; see the loaders in hooks.go.  Specs are
+// recognized by their extension and top-level keys: "openapi" or
+// "swagger" for OpenAPI, "$schema" for JSON Schema.
+
+// specExts are the extensions of files that may be API specs.
+var specExts = []string{".yaml", ".yml", ".json"}
+
+// httpMethods are the operations of an OpenAPI path item, in the
+// order they are rendered.
+var httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// renderSpec renders an OpenAPI spec or JSON Schema for chunking.  It
+// returns false if content isn't one.
+func renderSpec(content []byte) (sections []renderedSection, ok bool) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil || doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	switch {
+	case mapValue(root, "openapi") != nil, mapValue(root, "swagger") != nil:
+		sections = renderOpenAPI(root)
+	case mapValue(root, "$schema") != nil:
+		sections = renderSchema(root)
+	default:
+		return
+	}
+	return sections, true
+}
+
+// renderOpenAPI returns the sections of an OpenAPI spec: an
+// overview, the operations, and the components.
+func renderOpenAPI(root *yaml.Node) (sections []renderedSection) {
+	if info := mapValue(root, "info"); info != nil {
+		title := scalar(mapValue(info, "title"))
+		version := scalar(mapValue(info, "version"))
+		sections = append(sections, section(strings.TrimSpace("API "+title+" "+version), keyLine(root, "info"), info))
+	}
+	if paths := mapValue(root, "paths"); paths != nil {
+		for i := 0; i+1 < len(paths.Content); i += 2 {
+			p := paths.Content[i].Value
+			item := paths.Content[i+1]
+			// parameters shared by the path's operations
+			shared := mapValue(item, "parameters")
+			for _, method := range httpMethods {
+				op := mapValue(item, method)
+				if op == nil {
+					continue
+				}
+				if shared != nil && mapValue(op, "parameters") == nil {
+					op = withKey(op, "parameters", shared)
+				}
+				sections = append(sections, section(strings.ToUpper(method)+" "+p, keyLine(item, method), op))
+			}
+		}
+	}
+	// OpenAPI 3 components, or Swagger 2 definitions
+	if comps := mapValue(root, "components"); comps != nil {
+		for i := 0; i+1 < len(comps.Content); i += 2 {
+			kind := comps.Content[i].Value
+			sections = append(sections, namedSections(kind, comps.Content[i+1])...)
+		}
+	}
+	if defs := mapValue(root, "definitions"); defs != nil {
+		sections = append(sections, namedSections("schemas", defs)...)
+	}
+	return
+}
+
+// renderSchema returns the sections of a JSON Schema: the schema
+// itself, without its definitions, and each definition.
+func renderSchema(root *yaml.Node) (sections []renderedSection) {
+	title := scalar(mapValue(root, "title"))
+	rest := &yaml.Node{Kind: yaml.MappingNode}
+	var defs []*yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i].Value
+		if key == "definitions" || key == "$defs" {
+			defs = append(defs, root.Content[i+1])
+			continue
+		}
+		rest.Content = append(rest.Content, root.Content[i], root.Content[i+1])
+	}
+	sections = append(sections, section(strings.TrimSpace("schema "+title), root.Line, rest))
+	for _, d := range defs {
+		sections = append(sections, namedSections("definitions", d)...)
+	}
+	return
+}
+
+// namedSections returns a section for each entry of a mapping, e.g.
+// "schemas/Pet".
+func namedSections(kind string, m *yaml.Node) (sections []renderedSection) {
+	if m.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		sections = append(sections, section(kind+"/"+m.Content[i].Value, m.Content[i].Line, m.Content[i+1]))
+	}
+	return
+}
+
+// paragraphBreakRe matches the blank lines that would split a section
+// into paragraphs.
+var paragraphBreakRe = regexp.MustCompile(`\n\s*\n`)
+
+// section renders a heading and a node, from the given line of the
+// original, as one paragraph.
+func section(heading string, line int, n *yaml.Node) renderedSection {
+	sec := renderedSection{name: heading, line: line, text: heading}
+	buf, err := yaml.Marshal(n)
+	if err != nil {
+		return sec
+	}
+	body := paragraphBreakRe.ReplaceAllString(strings.TrimSpace(string(buf)), "\n")
+	sec.text += "\n" + body
+	return sec
+}
+
+// mapValue returns the value of key in a mapping node, or nil.
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// keyLine returns the line of key in a mapping node, or 0.
+func keyLine(m *yaml.Node, key string) int {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return 0
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i].Line
+		}
+	}
+	return 0
+}
+
+// withKey returns a copy of a mapping node with key added.
+func withKey(m *yaml.Node, key string, value *yaml.Node) *yaml.Node {
+	out := *m
+	out.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value}, m.Content...)
+	return &out
+}
+
+// scalar returns the value of a scalar node, or "".
+func scalar(n *yaml.Node) string {
+	if n == nil || n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+const petsSpec = `openapi: 3.0.0
+info:
+  title: Pets
+  version: "1.0"
+paths:
+  /pets:
+    get:
+      summary: List pets
+
+      description: Returns every pet.
+    post:
+      summary: Add a pet
+  /pets/{id}:
+    parameters:
+      - name: id
+        in: path
+    delete:
+      summary: Remove a pet
+components:
+  schemas:
+    Pet:
+      type: object
+`
+
+func TestRenderSpec(t *testing.T) {
+	secs, ok := renderSpec([]byte(petsSpec))
+	out, _ := joinSections(secs)
+	Tassert(t, ok, "expected an OpenAPI spec to be rendered")
+	sections := strings.Split(strings.TrimSpace(string(out)), "\n\n")
+	Tassert(t, len(sections) == 5, "expected 5 sections, got %d: %q", len(sections), out)
+	Tassert(t, strings.HasPrefix(sections[0], "API Pets 1.0\n"), "unexpected overview %q", sections[0])
+	Tassert(t, strings.HasPrefix(sections[1], "GET /pets\n"), "unexpected section %q", sections[1])
+	Tassert(t, strings.Contains(sections[1], "Returns every pet."), "expected the description in %q", sections[1])
+	Tassert(t, strings.HasPrefix(sections[2], "POST /pets\n"), "unexpected section %q", sections[2])
+	Tassert(t, strings.HasPrefix(sections[3], "DELETE /pets/{id}\n"), "unexpected section %q", sections[3])
+	Tassert(t, strings.Contains(sections[3], "in: path"), "expected path parameters in %q", sections[3])
+	Tassert(t, strings.HasPrefix(sections[4], "schemas/Pet\n"), "unexpected section %q", sections[4])
+	// sections are cited at their lines in the spec
+	Tassert(t, secs[2].name == "POST /pets" && secs[2].line == 11, "got %+v", secs[2])
+	Tassert(t, secs[3].line == 17 && secs[4].line == 21, "got %+v %+v", secs[3], secs[4])
+
+	schema := `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "Order",
+		"type": "object", "$defs": {"Item": {"type": "string"}, "Price": {"type": "number"}}}`
+	secs, ok = renderSpec([]byte(schema))
+	out, _ = joinSections(secs)
+	Tassert(t, ok, "expected a JSON Schema to be rendered")
+	sections = strings.Split(strings.TrimSpace(string(out)), "\n\n")
+	Tassert(t, len(sections) == 3, "expected 3 sections, got %d: %q", len(sections), out)
+	Tassert(t, strings.HasPrefix(sections[0], "schema Order\n"), "unexpected section %q", sections[0])
+	Tassert(t, !strings.Contains(sections[0], "Price"), "expected definitions to be split out of %q", sections[0])
+	Tassert(t, strings.HasPrefix(sections[2], "definitions/Price\n"), "unexpected section %q", sections[2])
+
+	_, ok = renderSpec([]byte("name: ci\non: push\n"))
+	Tassert(t, !ok, "expected plain YAML to be left alone")
+	_, ok = renderSpec([]byte("not: [valid"))
+	Tassert(t, !ok, "expected invalid YAML to be left alone")
+}
+
+func TestSpecChunks(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "config"))
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "pets.yaml"), []byte(petsSpec), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "pets.yaml"}
+	chunks, err := grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, doc.Transformed, "expected the spec to be rendered")
+	Tassert(t, len(chunks) == 5, "expected a chunk per section, got %d", len(chunks))
+	text, err := grok.chunkText(chunks[2], false, false)
+	Tassert(t, err == nil, "error reading chunk: %v", err)
+	Tassert(t, strings.HasPrefix(text, "POST /pets\n"), "unexpected chunk text %q", text)
+	Tassert(t, chunks[2].Section == "POST /pets", "expected the operation as the section, got %q", chunks[2].Section)
+	cite, err := grok.citation(chunks[2])
+	Tassert(t, err == nil && cite == "pets.yaml:11", "expected the line in the spec, got %q: %v", cite, err)
+
+	// a hook replaces the loader
+	err = grok.AddHook("*.yaml", "cat")
+	Tassert(t, err == nil, "error adding hook: %v", err)
+	chunks, err = grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	text, err = grok.chunkText(chunks[0], false, false)
+	Tassert(t, err == nil, "error reading chunk: %v", err)
+	Tassert(t, strings.HasPrefix(text, "openapi: 3.0.0"), "unexpected chunk text %q", text)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// 'grok ask' answers a question about the output of a command, e.g.
+//
+//	make test 2>&1 | grok ask "why did this fail?"
+//
+// The output is indexed as a virtual document in a temporary
+// collection, so the relevant parts of a long output can be found
+// the same way as the rest of the knowledge base, and discarded
+// once the question is answered.  Half the context comes from the
+// output and half from the rest of the knowledge base, found using
+// the question and the end of the output, where errors usually are.
+// The document is named like a log file, so timestamped output is
+// chunked by time window; see logs.go.
+
+// askCollection is the collection that holds command output while a
+// question about it is answered.
+const askCollection = "grok-ask"
+
+// askMaxBytes is how much of the end of a command's output is
+// indexed.
+const askMaxBytes = 1 << 20
+
+// askTailLines is how many lines from the end of the output are
+// used to find context in the knowledge base.
+const askTailLines = 20
+
+// AskAbout answers a question about a command's output, using
+// context from both the output and the knowledge base.  The output
+// is removed from the knowledge base before AskAbout returns.
+func (g *Grokker) AskAbout(question string, output []byte, global bool) (resp string, err error) {
+	defer Return(&err)
+	if len(strings.TrimSpace(string(output))) == 0 {
+		err = fmt.Errorf("no output to ask about")
+		return
+	}
+	if len(output) > askMaxBytes {
+		output = output[len(output)-askMaxBytes:]
+	}
+	qtokens, err := g.tokens(question)
+	Ck(err)
+	maxTokens := int(float64(g.TokenLimit)*0.5) - len(qtokens)
+	if g.contextTokens > 0 {
+		maxTokens = g.contextTokens
+	}
+
+	// find context in the knowledge base before the output is in it
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > askTailLines {
+		lines = lines[len(lines)-askTailLines:]
+	}
+	query := question + "\n\n" + strings.Join(lines, "\n")
+	kbContext, err := g.getContext(query, maxTokens/2, false, false, nil)
+	Ck(err)
+	sources := g.sources
+	ids := g.sourceIDs
+
+	doc, err := g.addOutput(output)
+	Ck(err)
+	defer func() {
+		derr := g.discard(doc)
+		if err == nil {
+			err = derr
+		}
+	}()
+	filter := g.filter
+	var f Filter
+	if filter != nil {
+		f = *filter
+	}
+	f.Collections = []string{askCollection}
+	g.filter = &f
+	outContext, err := g.getContext(question, maxTokens-maxTokens/2, false, false, nil)
+	g.filter = filter
+	Ck(err)
+	for _, cite := range sources {
+		if !util.StringInSlice(cite, g.sources) {
+			g.sources = append(g.sources, cite)
+		}
+	}
+	g.sourceIDs = append(g.sourceIDs, ids...)
+
+	context := Spf("The command output, from %s:\n%s\n%s", doc.RelPath, outContext, kbContext)
+	g.context = context
+	respmsg, err := g.generate(g.Sysmsg(SysMsgChat), question, context, global)
+	Ck(err)
+	resp, err = g.PostProcess(respmsg.Choices[0].Message.Content)
+	Ck(err)
+	// the question isn't logged, since its sources include the
+	// discarded output
+	return
+}
+
+// addOutput indexes command output as a virtual document in the
+// ask collection.
+func (g *Grokker) addOutput(output []byte) (doc *Document, err error) {
+	defer Return(&err)
+	name := Spf("output-%d.log", time.Now().UnixNano())
+	err = g.PutDocument(name, output)
+	Ck(err)
+	for _, d := range g.Documents {
+		if d.RelPath == name {
+			doc = d
+		}
+	}
+	Assert(doc != nil, "missing document %s", name)
+	doc.Collection = askCollection
+	return
+}
+
+// discard removes a document and everything made from it, including
+// its cached embeddings, without leaving a tombstone.
+func (g *Grokker) discard(doc *Document) (err error) {
+	defer Return(&err)
+	keys, err := g.cacheKeys(doc)
+	Ck(err)
+	for _, key := range keys {
+		err = g.cacheDelete("embeddings", key)
+		Ck(err)
+	}
+	var chunks []*Chunk
+	for _, c := range g.Chunks {
+		if c.Document == nil || c.Document.RelPath != doc.RelPath {
+			chunks = append(chunks, c)
+		}
+	}
+	g.Chunks = chunks
+	var docs []*Document
+	for _, d := range g.Documents {
+		if d.RelPath != doc.RelPath {
+			docs = append(docs, d)
+		}
+	}
+	g.Documents = docs
+	fns := []string{g.transformedPath(doc.RelPath)}
+	if doc.Virtual {
+		fns = append(fns, g.virtualPath(doc.RelPath))
+	}
+	for _, fn := range fns {
+		err = os.Remove(fn)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		Ck(err)
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestAddOutput(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	grok.embedder = &driftEmbedder{vec: []float64{1, 0}}
+	grok.EmbeddingProvider = "onnx"
+	grok.EmbeddingModel = "drift"
+
+	out := "2024-03-01T14:05:03Z ERROR connection refused\n\nFAIL\n"
+	doc, err := grok.addOutput([]byte(out))
+	Tassert(t, err == nil, "error adding output: %v", err)
+	Tassert(t, doc.Virtual && doc.Collection == askCollection, "unexpected document %+v", doc)
+	Tassert(t, len(grok.Chunks) > 0, "expected the output to be chunked")
+	_, err = os.Stat(grok.virtualPath(doc.RelPath))
+	Tassert(t, err == nil, "expected the output to be saved: %v", err)
+
+	err = grok.discard(doc)
+	Tassert(t, err == nil, "error discarding output: %v", err)
+	Tassert(t, len(grok.Documents) == 0 && len(grok.Chunks) == 0, "expected the output to be gone")
+	Tassert(t, len(grok.Tombstones) == 0, "expected no tombstone")
+	for _, fn := range []string{grok.virtualPath(doc.RelPath), grok.transformedPath(doc.RelPath)} {
+		_, err = os.Stat(fn)
+		Tassert(t, os.IsNotExist(err), "expected %s to be removed", fn)
+	}
+
+	_, err = grok.AskAbout("why?", []byte("  \n"), false)
+	Tassert(t, err != nil, "expected an error for empty output")
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Attachments are one-off reference material for a session, e.g.
+//
+//	grok q --attach notes.txt "does the plan in my notes match the code?"
+//
+// An attachment is chunked and embedded the way a document would be,
+// in SessionCollection, but like a buffer it lives only in memory:
+// it isn't saved, and Detach removes it along with its cached
+// embeddings, so the knowledge base is left as it was.  Attachments
+// are candidates for context whatever the filter or the router
+// picks, since the user asked for them.
+
+// SessionCollection is the collection of attached documents.
+const SessionCollection = "session"
+
+// Attach indexes content as the attached document name until
+// Detach, replacing any earlier attachment of that name.  Answers
+// cite the attachment by name.
+func (g *Grokker) Attach(name string, content []byte) (err error) {
+	defer Return(&err)
+	name = path.Clean(filepath.ToSlash(name))
+	if name == "." || name == "" {
+		err = fmt.Errorf("attachment name is empty")
+		return
+	}
+	doc := &Document{RelPath: name, Virtual: true, Collection: SessionCollection}
+	text := string(content)
+	chunks, err := g.chunksFromString(doc, text, Tokenizer, g.EmbeddingTokenLimit)
+	Ck(err)
+	var head string
+	if len(chunks) > 0 {
+		head = chunks[0].text
+	}
+	lang := fileLang(name, head)
+	for _, c := range chunks {
+		c.Document = doc
+		// the chunk's text comes from the attachment, not a file
+		c.Text = c.text
+		c.Line = strings.Count(text[:c.Offset], "\n") + 1
+		c.Symbols = chunkSymbols(c)
+		c.Langs = chunkLangs(lang, c.text)
+	}
+	err = g.embedChunks(embeddable(chunks))
+	Ck(err)
+	if g.attachments == nil {
+		g.attachments = make(map[string][]*Chunk)
+	}
+	g.attachments[name] = chunks
+	Debug("attachment %s: %d chunks", name, len(chunks))
+	return
+}
+
+// Detach removes all attachments and their cached embeddings.
+func (g *Grokker) Detach() (err error) {
+	defer Return(&err)
+	for _, name := range g.Attachments() {
+		for _, c := range g.attachments[name] {
+			text, err := g.chunkText(c, true, false)
+			Ck(err)
+			err = g.cacheDelete("embeddings", g.embeddingCacheKey(text))
+			Ck(err)
+		}
+	}
+	g.attachments = nil
+	return
+}
+
+// Attachments returns the names of the attached documents, sorted.
+func (g *Grokker) Attachments() (names []string) {
+	for name := range g.attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// attached returns the chunks of all attachments.
+func (g *Grokker) attached() (chunks []*Chunk) {
+	for _, name := range g.Attachments() {
+		chunks = append(chunks, g.attachments[name]...)
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestAttach(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	path := filepath.Join(dir, "widgets.md")
+	err = os.WriteFile(path, []byte("# Widgets\n\nWidgets are blue.\n"), 0644)
+	Ck(err)
+	err = grok.AddDocument(path)
+	Ck(err)
+	stored := len(grok.Chunks)
+
+	context := func() string {
+		chunks, err := grok.findChunks("what color are gadgets?", 2000, nil)
+		Tassert(t, err == nil, "error finding chunks: %v", err)
+		var texts []string
+		for _, c := range chunks {
+			text, err := grok.chunkText(c, true, false)
+			Ck(err)
+			texts = append(texts, text)
+		}
+		return strings.Join(texts, "\n")
+	}
+
+	err = grok.Attach("notes/gadgets.txt", []byte("Gadgets are red.\n"))
+	Tassert(t, err == nil, "error attaching: %v", err)
+	// attachments are used whatever the filter picks
+	grok.SetFilter(&Filter{Collections: []string{DefaultCollection}})
+	ctx := context()
+	Tassert(t, strings.Contains(ctx, "from notes/gadgets.txt:") && strings.Contains(ctx, "red"), "expected the attachment:\n%s", ctx)
+	Tassert(t, len(grok.Chunks) == stored, "the attachment changed the stored chunks")
+	coll, ok := grok.DocumentCollection("notes/gadgets.txt")
+	Tassert(t, ok && coll == SessionCollection, "got collection %q", coll)
+	Tassert(t, strings.Join(grok.Attachments(), " ") == "notes/gadgets.txt", "got %v", grok.Attachments())
+	c := grok.attachments["notes/gadgets.txt"][0]
+	text, err := grok.chunkText(c, true, false)
+	Ck(err)
+	key := grok.embeddingCacheKey(text)
+	var got []float64
+	Tassert(t, cacheGet("embeddings", key, &got), "expected the attachment's embedding in the cache")
+
+	err = grok.Detach()
+	Tassert(t, err == nil, "error detaching: %v", err)
+	Tassert(t, len(grok.Attachments()) == 0, "expected no attachments")
+	Tassert(t, !strings.Contains(context(), "red"), "expected the attachment to be gone")
+	Tassert(t, !cacheGet("embeddings", key, &got), "expected the attachment's embedding out of the cache")
+
+	err = grok.Attach("", []byte("x"))
+	Tassert(t, err != nil, "expected an error for an empty name")
+}
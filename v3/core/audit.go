@@ -0,0 +1,202 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The audit log records every request to a remote model and its
+// response, for compliance review.  It is enabled by the "audit"
+// setting of the policy in the user config file:
+//
+//	policy:
+//	  audit: truncated   # or full; off by default
+//	  audit_chain: true
+//
+// The log is a JSON-lines file in a .grok-audit directory next to
+// the database.  It is only ever appended to.  With audit_chain set,
+// each entry includes the hash of the previous one, so 'grok audit
+// verify' can detect entries that were edited or removed.
+
+// auditTruncate is the number of bytes of each message kept in the
+// log when Policy.Audit is "truncated".
+const auditTruncate = 500
+
+// AuditMsg is a message in an audited request.
+type AuditMsg struct {
+	Role    string
+	Content string
+}
+
+// AuditEntry is a single request/response in the audit log.
+type AuditEntry struct {
+	Seq              int
+	Time             time.Time
+	Kind             string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Request          []AuditMsg
+	Response         string `json:",omitempty"`
+	Truncated        bool   `json:",omitempty"`
+	// Prev is the hash of the previous entry and Hash is the hash
+	// of this entry, if the log is chained.
+	Prev string `json:",omitempty"`
+	Hash string `json:",omitempty"`
+}
+
+// hash returns the chain hash of the entry: the sha256 of the JSON
+// encoding of the entry without its Hash field.
+func (e AuditEntry) hash() string {
+	e.Hash = ""
+	buf, err := json.Marshal(e)
+	Ck(err)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditPath returns the path of the audit log, or an empty string if
+// the db has no file, e.g. in tests.
+func (g *Grokker) auditPath() string {
+	if g.grokpath == "" {
+		return ""
+	}
+	return filepath.Join(g.grokpath+"-audit", "log.jsonl")
+}
+
+// audit appends an entry to the audit log, if the policy enables it.
+// The sequence number and chain hash are filled in here.
+func (g *Grokker) audit(e *AuditEntry) (err error) {
+	defer Return(&err)
+	p, err := g.getPolicy()
+	Ck(err)
+	path := g.auditPath()
+	if p.Audit == "" || p.Audit == "off" || path == "" {
+		return
+	}
+	if p.Audit == "truncated" {
+		for i := range e.Request {
+			e.Request[i].Content, e.Truncated = truncate(e.Request[i].Content, e.Truncated)
+		}
+		e.Response, e.Truncated = truncate(e.Response, e.Truncated)
+	}
+	if g.auditLast == nil {
+		// find the end of the existing log
+		entries, err := g.AuditLog()
+		Ck(err)
+		g.auditLast = &AuditEntry{}
+		if len(entries) > 0 {
+			g.auditLast = entries[len(entries)-1]
+		}
+	}
+	e.Seq = g.auditLast.Seq + 1
+	e.Time = time.Now()
+	if p.AuditChain {
+		e.Prev = g.auditLast.Hash
+		e.Hash = e.hash()
+	}
+	buf, err := json.Marshal(e)
+	Ck(err)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	Ck(err)
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	Ck(err)
+	defer fh.Close()
+	_, err = fh.Write(append(buf, '\n'))
+	Ck(err)
+	g.auditLast = e
+	return
+}
+
+// logAudit audits e, reporting a failure rather than returning it:
+// the request has been paid for by now, and its response mustn't be
+// lost because the log couldn't be written.
+func (g *Grokker) logAudit(e *AuditEntry) {
+	err := g.audit(e)
+	if err != nil {
+		Fpf(os.Stderr, "warning: can't write the audit log: %v\n", err)
+	}
+}
+
+// truncate shortens s to auditTruncate bytes.  It returns true if s
+// was shortened or if truncated was already true.
+func truncate(s string, truncated bool) (string, bool) {
+	if len(s) <= auditTruncate {
+		return s, truncated
+	}
+	return s[:auditTruncate] + "...", true
+}
+
+// AuditLog returns the entries in the audit log, oldest first.
+func (g *Grokker) AuditLog() (entries []*AuditEntry, err error) {
+	defer Return(&err)
+	path := g.auditPath()
+	if path == "" {
+		return
+	}
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		e := &AuditEntry{}
+		err = json.Unmarshal(scanner.Bytes(), e)
+		Ck(err, "%s:%d", path, line)
+		entries = append(entries, e)
+	}
+	err = scanner.Err()
+	Ck(err)
+	return
+}
+
+// VerifyAudit checks that the audit log is intact: that the sequence
+// numbers have no gaps and, for chained entries, that each hash is
+// correct and links to the previous entry.  It returns the number of
+// entries checked and the number of those that are chained.
+func (g *Grokker) VerifyAudit() (count, chained int, err error) {
+	defer Return(&err)
+	entries, err := g.AuditLog()
+	Ck(err)
+	prev := &AuditEntry{}
+	for _, e := range entries {
+		if e.Seq != prev.Seq+1 {
+			err = fmt.Errorf("entry %d follows entry %d; entries are missing or out of order", e.Seq, prev.Seq)
+			return
+		}
+		if e.Hash != "" {
+			if e.hash() != e.Hash {
+				err = fmt.Errorf("entry %d has been modified: hash mismatch", e.Seq)
+				return
+			}
+			if e.Prev != prev.Hash {
+				err = fmt.Errorf("entry %d does not link to entry %d", e.Seq, prev.Seq)
+				return
+			}
+			chained++
+		} else if prev.Hash != "" {
+			err = fmt.Errorf("entry %d is not chained but follows a chained entry", e.Seq)
+			return
+		}
+		count++
+		prev = e
+	}
+	return
+}
+
+// String returns a one-line summary of the entry.
+func (e *AuditEntry) String() string {
+	return Spf("%d\t%s\t%s\t%s\t%d+%d tokens", e.Seq, e.Time.Format(time.RFC3339), e.Kind, e.Model, e.PromptTokens, e.CompletionTokens)
+}
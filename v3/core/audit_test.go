@@ -0,0 +1,68 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestAudit(t *testing.T) {
+	dir := TmpTestDir()
+	g := &Grokker{grokpath: filepath.Join(dir, ".grok"), policy: &Policy{}}
+
+	// off by default
+	err := g.audit(&AuditEntry{Kind: "chat"})
+	Tassert(t, err == nil, "error auditing: %v", err)
+	entries, err := g.AuditLog()
+	Tassert(t, err == nil && len(entries) == 0, "expected empty log, got %v %v", entries, err)
+
+	g.policy = &Policy{Audit: "truncated", AuditChain: true}
+	long := strings.Repeat("x", auditTruncate+10)
+	for i := 0; i < 3; i++ {
+		err = g.audit(&AuditEntry{Kind: "chat", Model: "m", Request: []AuditMsg{{"user", long}}, Response: "ok"})
+		Tassert(t, err == nil, "error auditing: %v", err)
+	}
+	entries, err = g.AuditLog()
+	Tassert(t, err == nil, "error reading log: %v", err)
+	Tassert(t, len(entries) == 3, "expected 3 entries, got %d", len(entries))
+	Tassert(t, entries[2].Seq == 3 && entries[2].Truncated, "got %#v", entries[2])
+	Tassert(t, len(entries[0].Request[0].Content) == auditTruncate+3, "content not truncated")
+	count, chained, err := g.VerifyAudit()
+	Tassert(t, err == nil && count == 3 && chained == 3, "got %d %d %v", count, chained, err)
+
+	// a new process continues the chain
+	g2 := &Grokker{grokpath: g.grokpath, policy: g.policy}
+	err = g2.audit(&AuditEntry{Kind: "embedding"})
+	Tassert(t, err == nil, "error auditing: %v", err)
+	_, _, err = g2.VerifyAudit()
+	Tassert(t, err == nil, "error verifying: %v", err)
+
+	// tampering is detected
+	buf, err := os.ReadFile(g.auditPath())
+	Tassert(t, err == nil, "error reading log: %v", err)
+	lines := strings.SplitAfter(string(buf), "\n")
+	tampered := strings.Replace(lines[1], `"Response":"ok"`, `"Response":"no"`, 1)
+	err = os.WriteFile(g.auditPath(), []byte(lines[0]+tampered+lines[2]+lines[3]), 0600)
+	Tassert(t, err == nil, "error writing log: %v", err)
+	_, _, err = g.VerifyAudit()
+	Tassert(t, err != nil && strings.Contains(err.Error(), "entry 2 has been modified"), "got %v", err)
+	err = os.WriteFile(g.auditPath(), []byte(lines[0]+lines[2]+lines[3]), 0600)
+	Tassert(t, err == nil, "error writing log: %v", err)
+	_, _, err = g.VerifyAudit()
+	Tassert(t, err != nil, "expected error for a missing entry")
+}
+
+func TestLogAudit(t *testing.T) {
+	dir := TmpTestDir()
+	g := &Grokker{grokpath: filepath.Join(dir, ".grok"), policy: &Policy{Audit: "full"}}
+	// a file where the log's directory should be
+	err := os.WriteFile(g.grokpath+"-audit", nil, 0600)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	err = g.audit(&AuditEntry{Kind: "embedding"})
+	Tassert(t, err != nil, "expected an error writing the log")
+	// a failure is only reported
+	g.logAudit(&AuditEntry{Kind: "embedding"})
+}
@@ -0,0 +1,287 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	gptLib "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+// The OpenAI Batch API accepts at most this many requests per batch.
+const maxBatchRequests = 50000
+
+// BatchJob tracks an OpenAI Batch API job that is creating
+// embeddings for chunks in the database.  Batch jobs are about half
+// the price of synchronous embedding calls, but can take up to 24
+// hours to complete, so we store the job in the database and merge
+// the results later.
+type BatchJob struct {
+	// The batch ID assigned by OpenAI.
+	ID string
+	// The ID of the uploaded JSONL input file.
+	InputFileID string
+	// The most recent status reported by the API, e.g.
+	// "validating", "in_progress", "completed", "failed".
+	Status string
+	// The time the job was submitted.
+	Created time.Time
+	// The hashes of the chunks in this job.  We use the chunk hash
+	// as the custom_id of each request.
+	Hashes []string
+	// Request counts as of the most recent poll.
+	Completed int
+	Failed    int
+	// The error from the most recent poll, if it failed.
+	Error string `json:",omitempty"`
+}
+
+// String returns a one-line summary of the job.
+func (job *BatchJob) String() (s string) {
+	s = Spf("%s %-12s %d/%d chunks embedded, %d failed, submitted %s",
+		job.ID, job.Status, job.Completed, len(job.Hashes), job.Failed,
+		job.Created.Format("2006-01-02 15:04:05"))
+	if job.Error != "" {
+		s += ": " + job.Error
+	}
+	return
+}
+
+// batchClient returns a client for the OpenAI Batch and Files APIs.
+// It's a variable so tests can point it at a fake server.
+var batchClient = func() *gptLib.Client {
+	authtoken := os.Getenv("OPENAI_API_KEY")
+	return gptLib.NewClient(authtoken)
+}
+
+// AddDocumentsBatch adds documents to the database and submits the
+// embeddings for any new chunks via the OpenAI Batch API instead of
+// creating them synchronously.  The new chunks have no embeddings,
+// and are not used as context, until BatchStatus() finds the job
+// complete and merges the results.
+func (g *Grokker) AddDocumentsBatch(paths []string) (jobs []*BatchJob, err error) {
+	defer Return(&err)
+	Assert(g.embedder == nil, "the batch API can't be used with GROKKER_EMBEDDER=%s", os.Getenv("GROKKER_EMBEDDER"))
+	err = g.checkEmbedder()
+	Ck(err)
+	var newChunks []*Chunk
+	for _, path := range paths {
+		var doc *Document
+		doc, err = g.addDoc(path)
+		Ck(err)
+		if doc == nil {
+			Fpf(os.Stderr, "warning: %s not found, skipping\n", path)
+			continue
+		}
+		var chunks []*Chunk
+		chunks, err = g.updateChunks(doc)
+		Ck(err)
+		newChunks = append(newChunks, embeddable(chunks)...)
+	}
+	// submit in batches no larger than the API allows
+	for start := 0; start < len(newChunks); start += maxBatchRequests {
+		end := start + maxBatchRequests
+		if end > len(newChunks) {
+			end = len(newChunks)
+		}
+		var job *BatchJob
+		job, err = g.submitBatch(newChunks[start:end])
+		Ck(err)
+		g.Batches = append(g.Batches, job)
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+// submitBatch uploads a JSONL file containing one embedding request
+// per chunk and creates a batch job for it.
+func (g *Grokker) submitBatch(chunks []*Chunk) (job *BatchJob, err error) {
+	defer Return(&err)
+	job = &BatchJob{Created: time.Now()}
+	req := gptLib.UploadBatchFileRequest{FileName: "grokker-embeddings.jsonl"}
+	_, model := g.embedderID()
+	entry := &AuditEntry{Kind: "embedding-batch", Model: model}
+	for _, chunk := range chunks {
+		text, err := g.chunkText(chunk, true, false)
+		Ck(err)
+		input, err := g.checkOutgoing(openaiEmbeddingProvider, []string{text})
+		Ck(err)
+		for _, in := range input {
+			entry.Request = append(entry.Request, AuditMsg{Role: "input", Content: in})
+		}
+		req.AddEmbedding(chunk.Hash, gptLib.EmbeddingRequest{
+			Input: input,
+			Model: gptLib.AdaEmbeddingV2,
+		})
+		job.Hashes = append(job.Hashes, chunk.Hash)
+	}
+	client := batchClient()
+	ctx := context.Background()
+	file, err := client.UploadBatchFile(ctx, req)
+	Ck(err)
+	job.InputFileID = file.ID
+	res, err := client.CreateBatch(ctx, gptLib.CreateBatchRequest{
+		InputFileID: file.ID,
+		Endpoint:    gptLib.BatchEndpointEmbeddings,
+	})
+	Ck(err)
+	job.ID = res.ID
+	job.Status = res.Status
+	// the embeddings are paid for when the job completes; the
+	// request is what leaves the machine, so audit it here
+	entry.Response = job.ID
+	g.logAudit(entry)
+	Debug("submitted batch %s with %d chunks", job.ID, len(chunks))
+	return
+}
+
+// BatchStatus polls the API for the status of each pending batch
+// job.  Completed jobs are merged into the database and removed from
+// the pending list.  Jobs that failed, expired, or were cancelled are
+// also removed, and any of their chunks that are still missing
+// embeddings are embedded synchronously.  A job that can't be polled
+// or merged keeps its error and stays pending, so the next poll
+// retries it.  It returns all of the jobs that were polled, with
+// their updated status.
+func (g *Grokker) BatchStatus() (jobs []*BatchJob, err error) {
+	defer Return(&err)
+	client := batchClient()
+	var pending []*BatchJob
+	for _, job := range g.Batches {
+		jobs = append(jobs, job)
+		done, err := g.pollBatch(client, job)
+		job.Error = ""
+		if err != nil {
+			job.Error = err.Error()
+			Fpf(os.Stderr, "batch %s: %v\n", job.ID, err)
+		}
+		if !done {
+			pending = append(pending, job)
+		}
+	}
+	g.Batches = pending
+	err = g.updateVectors()
+	Ck(err)
+	return
+}
+
+// pollBatch updates the status of a batch job and, if the job is
+// over, merges its embeddings and creates the rest synchronously.
+// It returns true if the job is done.
+func (g *Grokker) pollBatch(client *gptLib.Client, job *BatchJob) (done bool, err error) {
+	defer Return(&err)
+	res, err := client.RetrieveBatch(context.Background(), job.ID)
+	Ck(err)
+	job.Status = res.Status
+	job.Completed = res.RequestCounts.Completed
+	job.Failed = res.RequestCounts.Failed
+	switch job.Status {
+	case "completed":
+		if res.OutputFileID != nil {
+			err = g.mergeBatch(client, job, *res.OutputFileID)
+			Ck(err)
+		}
+		// requests that failed inside a completed batch still
+		// need embeddings
+		err = g.embedMissing(job)
+		Ck(err)
+	case "failed", "expired", "cancelled":
+		Fpf(os.Stderr, "batch %s %s; creating remaining embeddings synchronously\n", job.ID, job.Status)
+		err = g.embedMissing(job)
+		Ck(err)
+	default:
+		// still in progress
+		return
+	}
+	done = true
+	return
+}
+
+// batchOutput is one line of a batch output file.
+type batchOutput struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int `json:"status_code"`
+		Body       struct {
+			Data []struct {
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+		} `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// mergeBatch downloads the output file of a completed batch job and
+// stores the embeddings in the matching chunks.
+func (g *Grokker) mergeBatch(client *gptLib.Client, job *BatchJob, outputFileID string) (err error) {
+	defer Return(&err)
+	content, err := client.GetFileContent(context.Background(), outputFileID)
+	Ck(err)
+	defer content.Close()
+	// index the chunks that are waiting for embeddings
+	waiting := make(map[string]*Chunk)
+	for _, chunk := range g.Chunks {
+		if chunk.Embedding == nil {
+			waiting[chunk.Hash] = chunk
+		}
+	}
+	merged := 0
+	scanner := bufio.NewScanner(content)
+	// embedding responses are much longer than the default max
+	// token size
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var out batchOutput
+		err = json.Unmarshal(scanner.Bytes(), &out)
+		Ck(err)
+		if out.Error != nil {
+			Fpf(os.Stderr, "batch %s: chunk %s failed: %s\n", job.ID, out.CustomID, out.Error.Message)
+			continue
+		}
+		if out.Response == nil || out.Response.StatusCode != 200 || len(out.Response.Body.Data) == 0 {
+			Fpf(os.Stderr, "batch %s: chunk %s returned no embedding\n", job.ID, out.CustomID)
+			continue
+		}
+		chunk, ok := waiting[out.CustomID]
+		if !ok {
+			// the chunk was garbage collected after the job was
+			// submitted
+			continue
+		}
+		embedding := out.Response.Body.Data[0].Embedding
+		err = g.checkDims([][]float64{embedding})
+		Ck(err)
+		chunk.Embedding = embedding
+		merged++
+	}
+	err = scanner.Err()
+	Ck(err)
+	Debug("merged %d embeddings from batch %s", merged, job.ID)
+	return
+}
+
+// embedMissing synchronously creates embeddings for any chunks in a
+// batch job that still don't have them.
+func (g *Grokker) embedMissing(job *BatchJob) (err error) {
+	defer Return(&err)
+	hashes := make(map[string]bool)
+	for _, hash := range job.Hashes {
+		hashes[hash] = true
+	}
+	var chunks []*Chunk
+	for _, chunk := range g.Chunks {
+		if chunk.Embedding != nil || !hashes[chunk.Hash] {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	err = g.embedStored(chunks)
+	Ck(err)
+	return
+}
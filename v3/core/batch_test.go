@@ -0,0 +1,38 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gptLib "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+func TestBatchStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/batches/broken") {
+			http.Error(w, `{"error": {"message": "server error"}}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"id": "running", "status": "in_progress", "request_counts": {"total": 2, "completed": 1}}`))
+	}))
+	defer srv.Close()
+	defer func(old func() *gptLib.Client) { batchClient = old }(batchClient)
+	batchClient = func() *gptLib.Client {
+		config := gptLib.DefaultConfig("testkey")
+		config.BaseURL = srv.URL + "/v1"
+		return gptLib.NewClientWithConfig(config)
+	}
+
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Ck(err)
+	grok.Batches = []*BatchJob{{ID: "broken", Status: "in_progress"}, {ID: "running", Status: "validating"}}
+	jobs, err := grok.BatchStatus()
+	Tassert(t, err == nil, "one job's error failed the poll: %v", err)
+	Tassert(t, len(jobs) == 2 && len(grok.Batches) == 2, "expected both jobs pending, got %d polled, %d pending", len(jobs), len(grok.Batches))
+	Tassert(t, jobs[0].Error != "" && jobs[0].Status == "in_progress", "expected the error on the broken job, got %+v", jobs[0])
+	Tassert(t, jobs[1].Error == "" && jobs[1].Status == "in_progress" && jobs[1].Completed == 1, "expected the other job polled, got %+v", jobs[1])
+	Tassert(t, strings.Contains(jobs[0].String(), jobs[0].Error), "error not shown: %s", jobs[0])
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// 'grok bench' measures chunking throughput, similarity search
+// latency at several corpus sizes, and, optionally, end-to-end query
+// latency against the real knowledge base.  The results are JSON, so
+// runs from different releases can be compared by a script.  The
+// chunking and search benchmarks use synthetic data and make no
+// network requests; the query benchmarks call the providers.
+
+// benchTextSizes are the sizes, in bytes, of the texts chunked by
+// the chunking benchmark.
+var benchTextSizes = []int{64 << 10, 1 << 20}
+
+// BenchOptions configures Bench.
+type BenchOptions struct {
+	// Sizes are the corpus sizes, in chunks, for the search
+	// benchmark.
+	Sizes []int
+	// Dim is the embedding dimension of the synthetic corpus; 0
+	// uses the database's dimension, or 1536.
+	Dim int
+	// Query, if set, is asked Runs times to measure retrieval and
+	// end-to-end query latency.
+	Query string
+	Runs  int
+}
+
+// BenchResult is one benchmark measurement.
+type BenchResult struct {
+	Name string `json:"name"`
+	// Size is the input size: bytes for chunking, chunks for
+	// search.
+	Size       int     `json:"size,omitempty"`
+	Iterations int     `json:"iterations"`
+	NsPerOp    int64   `json:"ns_per_op"`
+	MBPerSec   float64 `json:"mb_per_s,omitempty"`
+	// Tokens is the mean number of model tokens used per query.
+	Tokens int    `json:"tokens,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BenchReport is the output of 'grok bench'.
+type BenchReport struct {
+	Version   string        `json:"version"`
+	GoVersion string        `json:"go_version"`
+	OS        string        `json:"os"`
+	Arch      string        `json:"arch"`
+	CPUs      int           `json:"cpus"`
+	Time      time.Time     `json:"time"`
+	Results   []BenchResult `json:"results"`
+}
+
+// Bench runs the benchmarks.
+func (g *Grokker) Bench(opts BenchOptions) (report *BenchReport, err error) {
+	defer Return(&err)
+	report = &BenchReport{
+		Version:   CodeVersion(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Time:      time.Now(),
+	}
+	rng := rand.New(rand.NewSource(1))
+	// the synthetic benchmarks use a scratch db, so the pipeline's
+	// reranker and the real chunks don't skew them
+	scratch := &Grokker{EmbeddingTokenLimit: g.EmbeddingTokenLimit}
+	for _, size := range benchTextSizes {
+		txt := benchText(rng, size)
+		var berr error
+		res := testing.Benchmark(func(b *testing.B) {
+			b.SetBytes(int64(len(txt)))
+			for i := 0; i < b.N; i++ {
+				_, err := scratch.chunksFromString(nil, txt, Tokenizer, scratch.EmbeddingTokenLimit)
+				if err != nil {
+					berr = err
+					b.FailNow()
+				}
+			}
+		})
+		report.Results = append(report.Results, benchResult("chunk", size, res, berr))
+	}
+	dim := opts.Dim
+	if dim == 0 {
+		dim = g.EmbeddingDim
+	}
+	if dim == 0 {
+		dim = 1536
+	}
+	for _, size := range opts.Sizes {
+		pool := benchCorpus(rng, size, dim)
+		query := benchVector(rng, dim)
+		var berr error
+		res := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := scratch.similarChunks("", query, 2000, nil, pool)
+				if err != nil {
+					berr = err
+					b.FailNow()
+				}
+			}
+		})
+		report.Results = append(report.Results, benchResult("search", size, res, berr))
+	}
+	if opts.Query == "" {
+		return
+	}
+	runs := opts.Runs
+	if runs <= 0 {
+		runs = 3
+	}
+	// don't fill the question log with benchmark queries
+	noLog := g.noQuestionLog
+	g.noQuestionLog = true
+	defer func() { g.noQuestionLog = noLog }()
+	report.Results = append(report.Results,
+		g.benchQuery("retrieve", runs, func() error {
+			_, err := g.answerContext(opts.Query, false, false)
+			return err
+		}),
+		g.benchQuery("query", runs, func() error {
+			_, err := g.Answer(opts.Query, false, false, false)
+			return err
+		}),
+	)
+	return
+}
+
+// benchQuery times fn, which makes provider requests, runs times.
+// It stops at the first error.
+func (g *Grokker) benchQuery(name string, runs int, fn func() error) (res BenchResult) {
+	res.Name = name
+	used := g.TokensUsed()
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		err := fn()
+		if err != nil {
+			res.Error = err.Error()
+			break
+		}
+		res.Iterations++
+	}
+	if res.Iterations > 0 {
+		res.NsPerOp = int64(time.Since(start)) / int64(res.Iterations)
+		res.Tokens = (g.TokensUsed() - used) / res.Iterations
+	}
+	return
+}
+
+// benchResult converts a testing.BenchmarkResult.
+func benchResult(name string, size int, r testing.BenchmarkResult, err error) (res BenchResult) {
+	res = BenchResult{Name: name, Size: size, Iterations: r.N, NsPerOp: r.NsPerOp()}
+	if r.Bytes > 0 && r.T > 0 {
+		res.MBPerSec = math.Round(float64(r.Bytes)*float64(r.N)/1e6/r.T.Seconds()*100) / 100
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return
+}
+
+// benchWords is the vocabulary of the synthetic text.
+var benchWords = strings.Fields(`the a knowledge base document chunk
+embedding query answer context model token index search retrieval
+file line function returns error value config pipeline collection`)
+
+// benchText returns about size bytes of prose-like text, in
+// paragraphs.
+func benchText(rng *rand.Rand, size int) string {
+	var sb strings.Builder
+	for sb.Len() < size {
+		n := 20 + rng.Intn(80)
+		for i := 0; i < n; i++ {
+			sb.WriteString(benchWords[rng.Intn(len(benchWords))])
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(".\n\n")
+	}
+	return sb.String()
+}
+
+// benchVector returns a random unit vector.
+func benchVector(rng *rand.Rand, dim int) (v []float64) {
+	v = make([]float64, dim)
+	var norm float64
+	for i := range v {
+		v[i] = rng.NormFloat64()
+		norm += v[i] * v[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] /= norm
+	}
+	return
+}
+
+// benchCorpus returns size chunks with random embeddings and a
+// fixed text, so search doesn't read any files.
+func benchCorpus(rng *rand.Rand, size, dim int) (chunks []*Chunk) {
+	doc := &Document{RelPath: "bench.txt", Virtual: true}
+	text := benchText(rng, 500)
+	for i := 0; i < size; i++ {
+		chunks = append(chunks, &Chunk{
+			Document:    doc,
+			Text:        text,
+			Line:        1,
+			Embedding:   benchVector(rng, dim),
+			tokenLength: 100,
+		})
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestBench(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmarks take a few seconds")
+	}
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	report, err := grok.Bench(BenchOptions{Sizes: []int{100}, Dim: 8})
+	Tassert(t, err == nil, "error running benchmarks: %v", err)
+	Tassert(t, len(report.Results) == len(benchTextSizes)+1, "unexpected results %+v", report.Results)
+	for _, res := range report.Results {
+		Tassert(t, res.Error == "" && res.Iterations > 0 && res.NsPerOp > 0, "unexpected result %+v", res)
+	}
+	Tassert(t, report.Results[0].Name == "chunk" && report.Results[0].MBPerSec > 0, "unexpected result %+v", report.Results[0])
+	Tassert(t, report.Results[len(benchTextSizes)].Name == "search", "unexpected result %+v", report.Results[len(benchTextSizes)])
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// 'grok bench-model' asks the same questions of the knowledge base
+// with each of two or more chat models and reports, per model, how
+// good the answers were, how long they took, and what they cost, so
+// users can pick the cheapest model that is good enough for their
+// corpus.  Retrieval doesn't depend on the chat model, so every model
+// answers from the same context.  A judge model, the database's model
+// unless another is given, scores each answer from 1 to 5 against
+// the question and that context; the scores are only as good as the
+// judge, so use a strong one.  Costs come from the models' prices
+// and the token counts the provider reports; models without a known
+// price show none.  Judging costs one more request per answer, which
+// isn't included.  Nothing is saved.
+
+// judgeSysmsg asks the judge for a score.
+const judgeSysmsg = `You grade answers to questions about a knowledge base.  Given the context the answer was built from, the question, and the answer, score the answer from 1 to 5:
+5: correct, complete, and supported by the context
+4: correct and supported, with minor omissions
+3: partly correct or partly unsupported
+2: mostly wrong, unsupported, or off the question
+1: wrong or no answer
+Reply with only a JSON object, e.g. {"score": 4, "reason": "Misses the default value."}, where reason is one short sentence.`
+
+// ModelBenchRun is the outcome of one question with one model.
+type ModelBenchRun struct {
+	Answer string
+	// Score is the judge's score from 1 to 5, and Reason its
+	// explanation; Score is 0 if the answer couldn't be judged, and
+	// Reason then says why.
+	Score  int
+	Reason string
+	// PromptTokens and CompletionTokens are the tokens the answer
+	// used, and Cost their price in USD.
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+	Latency          time.Duration
+	// Error is set instead of Answer if the question failed.
+	Error string `json:",omitempty"`
+}
+
+// ModelBenchResult holds the runs of a question, one per model, in
+// the order the models were given.
+type ModelBenchResult struct {
+	Question string
+	Runs     []ModelBenchRun
+}
+
+// ModelBenchSummary sums up the runs of one model.
+type ModelBenchSummary struct {
+	Model string
+	// Score is the mean score of the judged answers, and Judged
+	// their number.
+	Score  float64
+	Judged int
+	// Cost is the total cost in USD; PriceKnown is false if the
+	// model's price isn't known.
+	Cost             float64
+	PriceKnown       bool
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	Errors           int
+}
+
+// BenchModels answers each question with each model and has the
+// judge model score the answers; an empty judge means the database's
+// model.  A question that fails with a model, or an answer the judge
+// can't score, is reported in its run rather than stopping the
+// benchmark.
+func (g *Grokker) BenchModels(models []string, questions []string, judge string, global bool) (results []ModelBenchResult, err error) {
+	defer Return(&err)
+	var variants []*Grokker
+	for _, model := range models {
+		v, err := g.variant(&CompareConfig{Name: model, Model: model})
+		Ck(err)
+		variants = append(variants, v)
+	}
+	judgeV, err := g.variant(&CompareConfig{Name: "judge", Model: judge})
+	Ck(err)
+	for _, q := range questions {
+		res := ModelBenchResult{Question: q}
+		for _, v := range variants {
+			prompt, completion := v.promptTokens, v.completionTokens
+			start := time.Now()
+			answer, err := v.Answer(q, false, false, global)
+			run := ModelBenchRun{
+				Latency:          time.Since(start),
+				PromptTokens:     v.promptTokens - prompt,
+				CompletionTokens: v.completionTokens - completion,
+			}
+			run.Cost, _ = modelCost(v.Model, run.PromptTokens, run.CompletionTokens)
+			if err != nil {
+				run.Error = err.Error()
+				res.Runs = append(res.Runs, run)
+				continue
+			}
+			run.Answer = answer
+			run.Score, run.Reason, err = judgeV.judge(q, v.context, answer)
+			if err != nil {
+				run.Reason = Spf("not judged: %v", err)
+			}
+			res.Runs = append(res.Runs, run)
+		}
+		results = append(results, res)
+	}
+	return
+}
+
+// judge asks the model to score an answer to question built from
+// context.
+func (g *Grokker) judge(question, context, answer string) (score int, reason string, err error) {
+	defer Return(&err)
+	input := Spf("Context:\n\n%s\n\nQuestion:\n\n%s\n\nAnswer:\n\n%s", context, question, answer)
+	resp, err := g.msg(judgeSysmsg, input)
+	Ck(err)
+	score, reason, err = parseJudgement(resp.Choices[0].Message.Content)
+	return
+}
+
+// parseJudgement parses the judge's reply, which may be wrapped in a
+// markdown code block.
+func parseJudgement(resp string) (score int, reason string, err error) {
+	start := strings.Index(resp, "{")
+	end := strings.LastIndex(resp, "}")
+	if start < 0 || end < start {
+		err = fmt.Errorf("expected a JSON object with a score, got %q", resp)
+		return
+	}
+	var j struct {
+		Score  int    `json:"score"`
+		Reason string `json:"reason"`
+	}
+	err = json.Unmarshal([]byte(resp[start:end+1]), &j)
+	if err != nil {
+		err = fmt.Errorf("can't parse judgement %q: %v", resp, err)
+		return
+	}
+	if j.Score < 1 || j.Score > 5 {
+		err = fmt.Errorf("score %d is not between 1 and 5", j.Score)
+		return
+	}
+	return j.Score, j.Reason, nil
+}
+
+// modelCost returns the price in USD of prompt and completion tokens
+// sent to and received from model, and whether the price is known.
+func modelCost(model string, prompt, completion int) (cost float64, known bool) {
+	_, m, err := NewModels().FindModel(model)
+	if err != nil || (m.PromptPrice == 0 && m.CompletionPrice == 0) {
+		return
+	}
+	cost = (float64(prompt)*m.PromptPrice + float64(completion)*m.CompletionPrice) / 1e6
+	return cost, true
+}
+
+// SummarizeModelBench sums up the runs of each model; latency is the
+// mean over all questions.
+func SummarizeModelBench(models []string, results []ModelBenchResult) (summaries []ModelBenchSummary) {
+	for j, model := range models {
+		sum := ModelBenchSummary{Model: model}
+		_, sum.PriceKnown = modelCost(model, 1e6, 1e6)
+		total := 0
+		for _, res := range results {
+			run := res.Runs[j]
+			sum.Cost += run.Cost
+			sum.PromptTokens += run.PromptTokens
+			sum.CompletionTokens += run.CompletionTokens
+			sum.Latency += run.Latency
+			if run.Error != "" {
+				sum.Errors++
+			}
+			if run.Score > 0 {
+				sum.Judged++
+				total += run.Score
+			}
+		}
+		if sum.Judged > 0 {
+			sum.Score = float64(total) / float64(sum.Judged)
+		}
+		if len(results) > 0 {
+			sum.Latency /= time.Duration(len(results))
+		}
+		summaries = append(summaries, sum)
+	}
+	return
+}
+
+// CheapestAdequate returns the cheapest model whose mean score is at
+// least minScore, whose price is known, and that answered every
+// question, or "" if there is none.  Ties go to the faster model.
+func CheapestAdequate(summaries []ModelBenchSummary, minScore float64) string {
+	var ok []ModelBenchSummary
+	for _, sum := range summaries {
+		if sum.PriceKnown && sum.Errors == 0 && sum.Judged > 0 && sum.Score >= minScore {
+			ok = append(ok, sum)
+		}
+	}
+	if len(ok) == 0 {
+		return ""
+	}
+	sort.SliceStable(ok, func(i, j int) bool {
+		if ok[i].Cost != ok[j].Cost {
+			return ok[i].Cost < ok[j].Cost
+		}
+		return ok[i].Latency < ok[j].Latency
+	})
+	return ok[0].Model
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestModelBench(t *testing.T) {
+	score, reason, err := parseJudgement("```json\n{\"score\": 4, \"reason\": \"Misses the default.\"}\n```")
+	Tassert(t, err == nil && score == 4 && reason == "Misses the default.", "got %d %q %v", score, reason, err)
+	_, _, err = parseJudgement(`{"score": 7}`)
+	Tassert(t, err != nil, "expected an error for a score out of range")
+	_, _, err = parseJudgement("Pretty good.")
+	Tassert(t, err != nil, "expected an error for a reply without JSON")
+
+	cost, known := modelCost("gpt-4o", 1000000, 100000)
+	Tassert(t, known && cost > 3.49 && cost < 3.51, "got %f %v", cost, known)
+	_, known = modelCost("no-such-model", 1000, 1000)
+	Tassert(t, !known, "expected no price for an unknown model")
+
+	models := []string{"gpt-4o", "gpt-3.5-turbo", "gpt-4"}
+	results := []ModelBenchResult{
+		{Question: "a?", Runs: []ModelBenchRun{
+			{Score: 5, Cost: 0.02, Latency: 2 * time.Second},
+			{Score: 4, Cost: 0.001, Latency: time.Second},
+			{Score: 5, Cost: 0.1, Latency: 3 * time.Second},
+		}},
+		{Question: "b?", Runs: []ModelBenchRun{
+			{Score: 4, Cost: 0.02, Latency: 2 * time.Second},
+			{Score: 3, Cost: 0.001, Latency: 3 * time.Second},
+			{Error: "timeout", Latency: time.Second},
+		}},
+	}
+	sums := SummarizeModelBench(models, results)
+	Tassert(t, len(sums) == 3, "got %d summaries", len(sums))
+	Tassert(t, sums[0].Score == 4.5 && sums[0].Judged == 2 && sums[0].Latency == 2*time.Second && sums[0].PriceKnown, "got %+v", sums[0])
+	Tassert(t, sums[1].Score == 3.5 && sums[1].Cost > 0.0019 && sums[1].Cost < 0.0021, "got %+v", sums[1])
+	Tassert(t, sums[2].Judged == 1 && sums[2].Errors == 1, "got %+v", sums[2])
+	best := CheapestAdequate(sums, 4)
+	Tassert(t, best == "gpt-4o", "expected gpt-4o, got %q", best)
+	best = CheapestAdequate(sums, 3.5)
+	Tassert(t, best == "gpt-3.5-turbo", "expected gpt-3.5-turbo, got %q", best)
+	best = CheapestAdequate(sums, 4.8)
+	Tassert(t, best == "", "expected no model, got %q", best)
+}
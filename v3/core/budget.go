@@ -0,0 +1,250 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A budget caps what one answer may cost, e.g.
+//
+//	grok q --budget 0.02 "how do I rotate the keys?"
+//
+// Before answering, Answer estimates the cost from the model's
+// prices: the question, the context, and an answer of
+// budgetAnswerTokens tokens, once for the answer and once more for
+// each extra request the settings call for.  Until the estimate
+// fits the budget, the query is degraded, least harmful first:
+//
+//  1. the extra stages are skipped: answer verification,
+//     decomposition, follow-up suggestions, the llm router and
+//     summarizer, and reranking;
+//  2. the context, and -k, is cut, to no less than half;
+//  3. a cheaper model is used, the priciest one that fits with at
+//     least half the context;
+//  4. the cheapest model is used with as much context as fits, but
+//     no less than budgetMinContext tokens.
+//
+// If even that doesn't fit, Answer fails rather than overspend.
+// Degradations says what was given up; the settings are restored
+// once the answer is done.  The estimate can't know how long the
+// answer will be, so it is a cap on the expected cost, not a
+// guarantee.
+
+// budgetAnswerTokens is the answer length the estimate assumes.
+const budgetAnswerTokens = 800
+
+// budgetOverhead is the estimate's allowance for the system message
+// and message framing.
+const budgetOverhead = 300
+
+// budgetMinContext is the least context, in tokens, a budget cuts
+// an answer to.
+const budgetMinContext = 1000
+
+// SetBudget caps the estimated cost, in USD, of each subsequent
+// answer; 0 means no cap.  The budget is not stored in the
+// database.
+func (g *Grokker) SetBudget(usd float64) {
+	g.budget = usd
+}
+
+// Degradations returns what was given up to fit the most recent
+// answer into the budget, e.g. "skipped answer verification", in the
+// order it was given up.
+func (g *Grokker) Degradations() []string {
+	return g.degradations
+}
+
+// fitBudget degrades the settings for an answer to question until
+// its estimated cost fits the budget, and returns a func that
+// restores them.
+func (g *Grokker) fitBudget(question string) (restore func(), err error) {
+	defer Return(&err)
+	restore = func() {}
+	g.degradations = nil
+	if g.budget <= 0 {
+		return
+	}
+	m := g.modelObj
+	if m.PromptPrice == 0 && m.CompletionPrice == 0 {
+		err = fmt.Errorf("can't answer within a budget: the price of %s isn't known", g.Model)
+		return
+	}
+	qtokens, err := g.tokens(question)
+	Ck(err)
+	context, err := g.answerTokens(question)
+	Ck(err)
+	// cost is the estimated cost of requests requests with ctx
+	// tokens of context on model m
+	cost := func(m *Model, ctx, requests int) float64 {
+		prompt := len(qtokens) + ctx + budgetOverhead
+		return float64(requests) * (float64(prompt)*m.PromptPrice + budgetAnswerTokens*m.CompletionPrice) / 1e6
+	}
+	// affordable is the most context a single request on model m
+	// can have within the budget
+	affordable := func(m *Model) int {
+		if m.PromptPrice == 0 {
+			return m.TokenLimit / 2
+		}
+		n := int((g.budget*1e6-budgetAnswerTokens*m.CompletionPrice)/m.PromptPrice) - len(qtokens) - budgetOverhead
+		if limit := m.TokenLimit/2 - len(qtokens); n > limit {
+			n = limit
+		}
+		return n
+	}
+	if cost(m, context, 1+g.extraRequests()) <= g.budget {
+		return
+	}
+
+	// save what a budget may change
+	model := g.Model
+	checkAnswers, verify := g.checkAnswers, g.Pipeline.Verify
+	decompose, followUps := g.decompose, g.followUpCount
+	router, summarizer := g.Pipeline.Router, g.Pipeline.Summarizer
+	reranker := g.reranker
+	maxChunks, contextTokens := g.maxChunks, g.contextTokens
+	restore = func() {
+		if g.Model != model {
+			err := g.Setup(model)
+			Ck(err)
+		}
+		g.checkAnswers, g.Pipeline.Verify = checkAnswers, verify
+		g.decompose, g.followUpCount = decompose, followUps
+		g.Pipeline.Router, g.Pipeline.Summarizer = router, summarizer
+		g.reranker = reranker
+		g.maxChunks, g.contextTokens = maxChunks, contextTokens
+	}
+	defer func() {
+		if err != nil {
+			restore()
+		}
+	}()
+
+	// 1. skip the extra stages
+	skip := func(on bool, what string) bool {
+		if on {
+			g.degradations = append(g.degradations, "skipped "+what)
+		}
+		return on
+	}
+	if skip(g.checkAnswers || g.Pipeline.Verify, "answer verification") {
+		g.checkAnswers, g.Pipeline.Verify = false, false
+	}
+	if skip(g.decomposing(), "question decomposition") {
+		g.SetDecompose(false)
+	}
+	if skip(g.followUpCount > 0, "follow-up suggestions") {
+		g.followUpCount = 0
+	}
+	if skip(g.Pipeline.Router == "llm", "the llm router") {
+		g.Pipeline.Router = "embedding"
+	}
+	if skip(g.Pipeline.Summarizer == "llm", "document summaries") {
+		g.Pipeline.Summarizer = ""
+	}
+	if skip(g.reranker != nil, "reranking") {
+		g.reranker = nil
+	}
+	if cost(m, context, 1) <= g.budget {
+		return
+	}
+
+	// 2. cut the context
+	if n := affordable(m); n >= context/2 {
+		g.cutContext(context, n)
+		return
+	}
+
+	// 3. use a cheaper model
+	var cheaper []*Model
+	for _, other := range g.models.Available {
+		if other.provider == m.provider && other.PromptPrice > 0 && other.PromptPrice < m.PromptPrice {
+			cheaper = append(cheaper, other)
+		}
+	}
+	sort.Slice(cheaper, func(i, j int) bool {
+		if cheaper[i].PromptPrice != cheaper[j].PromptPrice {
+			return cheaper[i].PromptPrice > cheaper[j].PromptPrice
+		}
+		return cheaper[i].Name < cheaper[j].Name
+	})
+	for _, other := range cheaper {
+		if n := affordable(other); n >= context/2 {
+			err = g.useModel(other.Name, model)
+			Ck(err)
+			if n < context {
+				g.cutContext(context, n)
+			} else {
+				// not half the new model's token limit
+				g.contextTokens = context
+			}
+			return
+		}
+	}
+
+	// 4. use the cheapest model with what context fits
+	cheapest := m
+	if len(cheaper) > 0 {
+		cheapest = cheaper[len(cheaper)-1]
+	}
+	n := affordable(cheapest)
+	if n < budgetMinContext {
+		err = fmt.Errorf("a budget of $%.4f is too small: the cheapest answer, from %s with %d tokens of context, would cost about $%.4f", g.budget, cheapest.Name, budgetMinContext, cost(cheapest, budgetMinContext, 1))
+		return
+	}
+	if cheapest != m {
+		err = g.useModel(cheapest.Name, model)
+		Ck(err)
+	}
+	g.cutContext(context, n)
+	return
+}
+
+// extraRequests returns how many requests besides the answer itself
+// the current settings make, counting each as a full one.
+func (g *Grokker) extraRequests() (n int) {
+	if g.checkAnswers || g.Pipeline.Verify {
+		n++
+	}
+	if g.decomposing() {
+		// a request per part plus two, less the answer
+		n += maxSubQuestions + 1
+	}
+	if g.followUpCount > 0 {
+		n++
+	}
+	if g.Pipeline.Router == "llm" {
+		n++
+	}
+	if g.Pipeline.Summarizer == "llm" {
+		n++
+	}
+	return
+}
+
+// cutContext cuts the context of an answer from tokens to n tokens,
+// and -k, if set, in proportion.
+func (g *Grokker) cutContext(tokens, n int) {
+	g.contextTokens = n
+	what := Spf("cut the context from %d to %d tokens", tokens, n)
+	if g.maxChunks > 0 {
+		k := g.maxChunks * n / tokens
+		if k < 1 {
+			k = 1
+		}
+		what += Spf(" and -k from %d to %d", g.maxChunks, k)
+		g.maxChunks = k
+	}
+	g.degradations = append(g.degradations, what)
+}
+
+// useModel switches the chat model for a budget.
+func (g *Grokker) useModel(name, was string) (err error) {
+	defer Return(&err)
+	err = g.Setup(name)
+	Ck(err)
+	g.degradations = append(g.degradations, Spf("used %s instead of %s", name, was))
+	return
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestBudget(t *testing.T) {
+	dir := TmpTestDir()
+	// gpt-4 costs $30/$60 per million tokens, so an answer with
+	// half its 8192-token limit as context is about $0.18
+	grok, err := Init(dir, "gpt-4")
+	Ck(err)
+	grok.SetCheckAnswers(true)
+	grok.SetFollowUps(3)
+
+	fit := func(usd float64) (degradations string, restore func()) {
+		grok.SetBudget(usd)
+		restore, err := grok.fitBudget("q")
+		Tassert(t, err == nil, "error fitting $%v: %v", usd, err)
+		return strings.Join(grok.Degradations(), "; "), restore
+	}
+
+	got, restore := fit(1)
+	Tassert(t, got == "", "degraded within the budget: %s", got)
+	restore()
+
+	got, restore = fit(0.2)
+	Tassert(t, got == "skipped answer verification; skipped follow-up suggestions", "got %s", got)
+	Tassert(t, !grok.checkAnswers && grok.followUpCount == 0, "the extra stages are still on")
+	restore()
+	Tassert(t, grok.checkAnswers && grok.followUpCount == 3, "the extra stages weren't restored")
+
+	grok.SetContextLimits(8, 0)
+	got, restore = fit(0.12)
+	Tassert(t, strings.HasSuffix(got, "; cut the context from 4095 to 2099 tokens and -k from 8 to 4"), "got %s", got)
+	Tassert(t, grok.contextTokens == 2099 && grok.maxChunks == 4, "got %d tokens, k %d", grok.contextTokens, grok.maxChunks)
+	restore()
+	Tassert(t, grok.contextTokens == 0 && grok.maxChunks == 8, "the context limits weren't restored")
+	grok.SetContextLimits(0, 0)
+
+	got, restore = fit(0.05)
+	Tassert(t, strings.HasSuffix(got, "; used gpt-4-turbo-preview instead of gpt-4; cut the context from 4095 to 2299 tokens"), "got %s", got)
+	Tassert(t, grok.Model == "gpt-4-turbo-preview", "got model %s", grok.Model)
+	restore()
+	Tassert(t, grok.Model == "gpt-4" && grok.TokenLimit == 8192, "the model wasn't restored: %s", grok.Model)
+
+	grok.SetBudget(0.0001)
+	_, err = grok.fitBudget("q")
+	Tassert(t, err != nil && strings.Contains(err.Error(), "too small"), "expected a budget too small, got %v", err)
+	Tassert(t, grok.Model == "gpt-4" && grok.checkAnswers, "a failed fit wasn't undone")
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// An editor knows about changes before they are saved.  An editor
+// integration hands its unsaved buffers to SetBuffer, and until they
+// are cleared, retrieval uses a buffer's content in place of the
+// document on disk: the buffer is chunked and embedded the way the
+// document would be, its chunks replace the document's stored
+// chunks as candidates for context, and answers quote and cite the
+// buffer.  A buffer can also be a new file that isn't in the
+// knowledge base yet.  Buffers live only in memory; they aren't
+// saved, and the stored chunks are left as they are, so clearing a
+// buffer brings back the saved document.  Like the filter, buffers
+// only affect queries.  Index hooks aren't run on buffers.
+
+// SetBuffer overlays content over the document at path, which is
+// relative to the current directory or absolute, replacing any
+// earlier buffer for it.  The buffer's chunks are embedded now;
+// chunks that are unchanged from the stored document reuse its
+// embeddings.
+func (g *Grokker) SetBuffer(path string, content []byte) (err error) {
+	defer Return(&err)
+	relpath, err := g.bufferPath(path)
+	Ck(err)
+	err = g.checkPath(relpath)
+	Ck(err)
+	doc := &Document{RelPath: relpath}
+	text := string(content)
+	chunks, err := g.chunksFromString(doc, text, Tokenizer, g.EmbeddingTokenLimit)
+	Ck(err)
+	var head string
+	if len(chunks) > 0 {
+		head = chunks[0].text
+	}
+	lang := fileLang(relpath, head)
+	err = g.loadShard(g.shardOf(relpath))
+	Ck(err)
+	stored := make(map[string]*Chunk)
+	for _, c := range g.Chunks {
+		if c.Document != nil && c.Document.RelPath == relpath && c.Embedding != nil {
+			stored[c.Hash] = c
+		}
+	}
+	var embed []*Chunk
+	for _, c := range chunks {
+		c.Document = doc
+		// the chunk's text comes from the buffer, not the file
+		c.Text = c.text
+		c.Line = strings.Count(text[:c.Offset], "\n") + 1
+		c.Symbols = chunkSymbols(c)
+		c.Langs = chunkLangs(lang, c.text)
+		if !g.StopAllow[c.Hash] {
+			c.Excluded = boilerplate(relpath, c.text)
+		}
+		if old, ok := stored[c.Hash]; ok {
+			c.Embedding = old.Embedding
+			c.Vectors = old.Vectors
+			continue
+		}
+		embed = append(embed, c)
+	}
+	err = g.embedChunks(embeddable(embed))
+	Ck(err)
+	if g.buffers == nil {
+		g.buffers = make(map[string][]*Chunk)
+	}
+	g.buffers[relpath] = chunks
+	Debug("buffer %s: %d chunks, %d embedded", relpath, len(chunks), len(embed))
+	return
+}
+
+// ClearBuffer removes the buffer for the document at path, if any,
+// so queries use the saved document again.
+func (g *Grokker) ClearBuffer(path string) (err error) {
+	defer Return(&err)
+	relpath, err := g.bufferPath(path)
+	Ck(err)
+	delete(g.buffers, relpath)
+	return
+}
+
+// ClearBuffers removes all buffers.
+func (g *Grokker) ClearBuffers() {
+	g.buffers = nil
+}
+
+// Buffers returns the paths of the documents that have buffers,
+// relative to the root, sorted.
+func (g *Grokker) Buffers() (paths []string) {
+	for relpath := range g.buffers {
+		paths = append(paths, relpath)
+	}
+	sort.Strings(paths)
+	return
+}
+
+// bufferPath returns the path of a buffer's document relative to
+// the root, with forward slashes.
+func (g *Grokker) bufferPath(path string) (relpath string, err error) {
+	defer Return(&err)
+	abs, err := filepath.Abs(path)
+	Ck(err)
+	rel, err := filepath.Rel(g.Root, abs)
+	Ck(err)
+	relpath = filepath.ToSlash(rel)
+	if relpath == ".." || strings.HasPrefix(relpath, "../") {
+		err = fmt.Errorf("%s is outside of %s", path, g.Root)
+	}
+	return
+}
+
+// withBuffers returns the chunks with those of buffered documents
+// replaced by the buffers' chunks.
+func (g *Grokker) withBuffers(chunks []*Chunk) (out []*Chunk) {
+	if len(g.buffers) == 0 {
+		return chunks
+	}
+	for _, c := range chunks {
+		if c.Document == nil {
+			out = append(out, c)
+			continue
+		}
+		if _, ok := g.buffers[c.Document.RelPath]; ok {
+			continue
+		}
+		out = append(out, c)
+	}
+	for _, relpath := range g.Buffers() {
+		out = append(out, g.buffers[relpath]...)
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestBuffers(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	saved := "# Widgets\n\nWidgets are blue.\n"
+	path := filepath.Join(dir, "widgets.md")
+	err = os.WriteFile(path, []byte(saved), 0644)
+	Ck(err)
+	err = grok.AddDocument(path)
+	Ck(err)
+	stored := len(grok.Chunks)
+
+	// context comes from the text of the chunks found for a query
+	context := func() string {
+		chunks, err := grok.findChunks("what color are widgets?", 2000, nil)
+		Tassert(t, err == nil, "error finding chunks: %v", err)
+		var texts []string
+		for _, c := range chunks {
+			text, err := grok.chunkText(c, true, false)
+			Ck(err)
+			texts = append(texts, text)
+		}
+		return strings.Join(texts, "\n")
+	}
+	Tassert(t, strings.Contains(context(), "blue"), "expected the saved document")
+
+	fetched := grok.embeddingsFetched
+	err = grok.SetBuffer(path, []byte("# Widgets\n\nWidgets are green now.\n"))
+	Tassert(t, err == nil, "error setting buffer: %v", err)
+	err = grok.SetBuffer(filepath.Join(dir, "new.md"), []byte("# Gadgets\n\nGadgets are red.\n"))
+	Ck(err)
+	Tassert(t, grok.embeddingsFetched-fetched < 4, "expected the unchanged heading to reuse its embedding")
+	ctx := context()
+	Tassert(t, strings.Contains(ctx, "green") && !strings.Contains(ctx, "blue"), "expected the buffer in place of the document:\n%s", ctx)
+	Tassert(t, strings.Contains(ctx, "from new.md (Gadgets):") && strings.Contains(ctx, "red"), "expected the new file's buffer:\n%s", ctx)
+	Tassert(t, strings.Join(grok.Buffers(), " ") == "new.md widgets.md", "got %v", grok.Buffers())
+	Tassert(t, len(grok.Chunks) == stored, "buffers changed the stored chunks")
+
+	err = grok.ClearBuffer(path)
+	Ck(err)
+	ctx = context()
+	Tassert(t, strings.Contains(ctx, "blue") && !strings.Contains(ctx, "green"), "expected the saved document again:\n%s", ctx)
+	grok.ClearBuffers()
+	Tassert(t, len(grok.Buffers()) == 0, "expected no buffers")
+
+	err = grok.SetBuffer(filepath.Join(dir, "..", "outside.md"), []byte("x"))
+	Tassert(t, err != nil, "expected an error for a buffer outside of the root")
+}
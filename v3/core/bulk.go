@@ -0,0 +1,89 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	. "github.com/stevegt/goadapt"
+)
+
+// Bulk operations change the metadata of every document matching a
+// .gitignore-style pattern, as index hooks are matched, e.g.
+//
+//	grok retag --tag internal 'docs/archive/**'
+//	grok boost 'docs/runbooks/**' 0.05
+//	grok rm -n '*.bak'
+//
+// With dryRun, each returns the documents it would change without
+// changing them.  A pattern that matches no document is an error, so
+// a typo doesn't pass for a change that did nothing.
+
+// MatchDocuments returns the paths of the documents matching a
+// .gitignore-style pattern, in the order they were added.
+func (g *Grokker) MatchDocuments(pattern string) (paths []string, err error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		err = fmt.Errorf("the pattern is empty")
+		return
+	}
+	ign := gitignore.CompileIgnoreLines(pattern)
+	for _, doc := range g.Documents {
+		if ign.MatchesPath(doc.RelPath) {
+			paths = append(paths, doc.RelPath)
+		}
+	}
+	if len(paths) == 0 {
+		err = fmt.Errorf("no documents match %q", pattern)
+	}
+	return
+}
+
+// RetagDocuments replaces the access tags of the documents matching
+// pattern; no tags removes them.  See tags.go.
+func (g *Grokker) RetagDocuments(pattern string, tags []string, dryRun bool) (paths []string, err error) {
+	defer Return(&err)
+	paths, err = g.MatchDocuments(pattern)
+	Ck(err)
+	if dryRun {
+		return
+	}
+	for _, path := range paths {
+		err = g.SetTags(path, tags)
+		Ck(err)
+	}
+	return
+}
+
+// BoostDocuments sets the boost of the documents matching pattern;
+// 0 removes it.  See tune.go.
+func (g *Grokker) BoostDocuments(pattern string, boost float64, dryRun bool) (paths []string, err error) {
+	defer Return(&err)
+	paths, err = g.MatchDocuments(pattern)
+	Ck(err)
+	if dryRun {
+		return
+	}
+	for _, path := range paths {
+		doc, err := g.lookupDocument(path)
+		Ck(err)
+		doc.Boost = boost
+	}
+	return
+}
+
+// ForgetDocuments forgets the documents matching pattern; see
+// ForgetDocument.
+func (g *Grokker) ForgetDocuments(pattern string, dryRun bool) (paths []string, err error) {
+	defer Return(&err)
+	paths, err = g.MatchDocuments(pattern)
+	Ck(err)
+	if dryRun {
+		return
+	}
+	for _, path := range paths {
+		err = g.ForgetDocument(path)
+		Ck(err)
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestBulk(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	for _, relpath := range []string{"docs/archive/v1.md", "docs/archive/old/v0.md", "docs/current.md", "notes.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(relpath))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		Ck(err)
+		err = os.WriteFile(path, []byte("About "+relpath+".\n"), 0644)
+		Ck(err)
+		err = grok.AddDocument(path)
+		Ck(err)
+	}
+	match := func(pattern string) string {
+		paths, err := grok.MatchDocuments(pattern)
+		Tassert(t, err == nil, "error matching %q: %v", pattern, err)
+		return strings.Join(paths, " ")
+	}
+	Tassert(t, match("docs/archive/**") == "docs/archive/v1.md docs/archive/old/v0.md", "got %q", match("docs/archive/**"))
+	Tassert(t, match("*.md") == "docs/archive/v1.md docs/archive/old/v0.md docs/current.md", "got %q", match("*.md"))
+	_, err = grok.MatchDocuments("docs/missing/**")
+	Tassert(t, err != nil, "expected an error for a pattern that matches nothing")
+
+	// a dry run changes nothing
+	paths, err := grok.RetagDocuments("docs/archive/**", []string{"Internal"}, true)
+	Tassert(t, err == nil && len(paths) == 2, "got %v, %v", paths, err)
+	tags, _ := grok.DocumentTags("docs/archive/v1.md")
+	Tassert(t, len(tags) == 0, "a dry run retagged: %v", tags)
+
+	_, err = grok.RetagDocuments("docs/archive/**", []string{"Internal"}, false)
+	Ck(err)
+	for _, relpath := range []string{"docs/archive/v1.md", "docs/archive/old/v0.md"} {
+		tags, _ := grok.DocumentTags(relpath)
+		Tassert(t, strings.Join(tags, " ") == "internal", "%s: got tags %v", relpath, tags)
+	}
+	tags, _ = grok.DocumentTags("docs/current.md")
+	Tassert(t, len(tags) == 0, "retagged a document that doesn't match: %v", tags)
+
+	_, err = grok.BoostDocuments("docs/current.md", 0.05, false)
+	Ck(err)
+	for _, doc := range grok.Documents {
+		want := 0.0
+		if doc.RelPath == "docs/current.md" {
+			want = 0.05
+		}
+		Tassert(t, doc.Boost == want, "%s: got boost %v", doc.RelPath, doc.Boost)
+	}
+
+	_, err = grok.ForgetDocuments("docs/archive/**", true)
+	Ck(err)
+	Tassert(t, len(grok.Documents) == 4, "a dry run forgot documents")
+	paths, err = grok.ForgetDocuments("docs/archive/**", false)
+	Ck(err)
+	Tassert(t, len(paths) == 2, "got %v", paths)
+	got := strings.Join(grok.ListDocuments(), " ")
+	Tassert(t, got == "docs/current.md notes.txt", "got %q", got)
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The embedding and response caches live in CacheDir() and are
+// shared by every knowledge base, so re-adding a file to another
+// knowledge base, or re-embedding after a 'grok forget', doesn't pay
+// for the same embeddings twice.  Each entry is a JSON file named by
+// the hash of its key:
+//
+//	embeddings/<hh>/<sha256>.json   keyed by embedder, model, and text,
+//	                                or by summarizer and document text
+//	responses/<hh>/<sha256>.json    keyed by provider and whole request
+//
+// The embedding cache is used unless turned off with SetCaches.  The
+// response cache is off by default, since a cached response is
+// always the same answer to the same question.  Like the health
+// record, the caches are best effort: a cache that can't be read or
+// written is ignored.  Delete the directories to clear them.  When
+// the cache daemon is running, entries are read and written through
+// it instead, which also caps the size of each cache; see cached.go.
+// A Grokker can keep its caches in a directory of its own instead,
+// e.g. for each of the knowledge bases 'grok serve' hosts; see
+// SetCacheDir.
+
+// SetCaches turns the embedding and response caches on or off for
+// this Grokker.
+func (g *Grokker) SetCaches(embeddings, responses bool) {
+	g.noEmbeddingCache = !embeddings
+	g.responseCache = responses
+}
+
+// SetCacheDir keeps this Grokker's embedding and response caches in
+// dir instead of the shared ones in CacheDir(), without the cache
+// daemon.  An empty dir uses the shared caches again.
+func (g *Grokker) SetCacheDir(dir string) {
+	g.cachePath = dir
+}
+
+// cacheDir returns the directory this Grokker's caches are in, or an
+// empty string if there is none.
+func (g *Grokker) cacheDir() string {
+	if g.cachePath != "" {
+		return g.cachePath
+	}
+	return CacheDir()
+}
+
+// cacheGet, cachePut, and cacheDelete use this Grokker's caches.
+func (g *Grokker) cacheGet(name, key string, v interface{}) bool {
+	return cacheGetIn(g.cachePath, name, key, v)
+}
+
+func (g *Grokker) cachePut(name, key string, v interface{}) {
+	cachePutIn(g.cachePath, name, key, v)
+}
+
+func (g *Grokker) cacheDelete(name, key string) error {
+	return cacheDeleteIn(g.cachePath, name, key)
+}
+
+// cacheKey returns the key that identifies a cache entry.
+func cacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheFile returns the path of an entry in the named shared cache,
+// or an empty string if there is no cache directory.
+func cacheFile(name, key string) string {
+	return cacheFileIn("", name, key)
+}
+
+// cacheFileIn is cacheFile for the caches in dir, or the shared ones
+// if dir is empty.
+func cacheFileIn(dir, name, key string) string {
+	if dir == "" {
+		dir = CacheDir()
+	}
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, name, key[:2], key+".json")
+}
+
+// cacheGet reads an entry into v, returning false if it isn't cached.
+func cacheGet(name, key string, v interface{}) bool {
+	return cacheGetIn("", name, key, v)
+}
+
+// cacheGetIn is cacheGet for the caches in dir, or the shared ones,
+// through the daemon if it is running, if dir is empty.
+func cacheGetIn(dir, name, key string, v interface{}) bool {
+	if dir == "" {
+		if resp, ok := callDaemon(cacheRequest{Op: "get", Cache: name, Key: key}); ok {
+			return resp.Found && json.Unmarshal(resp.Value, v) == nil
+		}
+	}
+	path := cacheFileIn(dir, name, key)
+	if path == "" {
+		return false
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	err = json.Unmarshal(buf, v)
+	if err != nil {
+		Debug("ignoring corrupt cache entry %s: %v", path, err)
+		return false
+	}
+	return true
+}
+
+// cachePut writes an entry.
+func cachePut(name, key string, v interface{}) {
+	cachePutIn("", name, key, v)
+}
+
+// cachePutIn is cachePut for the caches in dir, or the shared ones
+// if dir is empty.
+func cachePutIn(dir, name, key string, v interface{}) {
+	path := cacheFileIn(dir, name, key)
+	if path == "" {
+		return
+	}
+	buf, err := json.Marshal(v)
+	if err == nil && dir == "" {
+		resp, ok := callDaemon(cacheRequest{Op: "put", Cache: name, Key: key, Value: buf})
+		if ok && resp.Error == "" {
+			return
+		}
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		// write and rename so a concurrent reader never sees a
+		// partial entry
+		tmpfn := Spf("%s.%d.tmp", path, os.Getpid())
+		err = os.WriteFile(tmpfn, buf, 0644)
+		if err == nil {
+			err = os.Rename(tmpfn, path)
+		}
+	}
+	if err != nil {
+		Debug("cannot write cache entry %s: %v", path, err)
+	}
+}
+
+// cacheDelete removes an entry, if it exists.
+func cacheDelete(name, key string) (err error) {
+	return cacheDeleteIn("", name, key)
+}
+
+// cacheDeleteIn is cacheDelete for the caches in dir, or the shared
+// ones if dir is empty.
+func cacheDeleteIn(dir, name, key string) (err error) {
+	path := cacheFileIn(dir, name, key)
+	if path == "" {
+		return
+	}
+	if dir == "" {
+		if resp, ok := callDaemon(cacheRequest{Op: "delete", Cache: name, Key: key}); ok && resp.Error == "" {
+			return
+		}
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestDirs(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG", "")
+	t.Setenv("GROKKER_CONFIG_DIR", "")
+	t.Setenv("GROKKER_CACHE_DIR", "")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdgconfig"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "xdgcache"))
+	defer func() { configDirOverride, cacheDirOverride = "", "" }()
+
+	Tassert(t, ConfigDir() == filepath.Join(dir, "xdgconfig", "grokker"), "unexpected config dir %q", ConfigDir())
+	Tassert(t, CacheDir() == filepath.Join(dir, "xdgcache", "grokker"), "unexpected cache dir %q", CacheDir())
+	Tassert(t, ConfigPath() == filepath.Join(dir, "xdgconfig", "grokker", "config.yaml"), "unexpected config path %q", ConfigPath())
+
+	// relative XDG paths are ignored
+	t.Setenv("XDG_CACHE_HOME", "relative")
+	Tassert(t, CacheDir() != filepath.Join("relative", "grokker"), "relative XDG_CACHE_HOME was used")
+
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "env"))
+	Tassert(t, ConfigDir() == filepath.Join(dir, "env"), "unexpected config dir %q", ConfigDir())
+
+	SetDirs(filepath.Join(dir, "flagconfig"), filepath.Join(dir, "flagcache"))
+	Tassert(t, ConfigDir() == filepath.Join(dir, "flagconfig"), "unexpected config dir %q", ConfigDir())
+	Tassert(t, CacheDir() == filepath.Join(dir, "flagcache"), "unexpected cache dir %q", CacheDir())
+	// an empty flag leaves the directory alone
+	SetDirs("", "")
+	Tassert(t, CacheDir() == filepath.Join(dir, "flagcache"), "unexpected cache dir %q", CacheDir())
+
+	t.Setenv("GROKKER_CONFIG", filepath.Join(dir, "my.yaml"))
+	Tassert(t, ConfigPath() == filepath.Join(dir, "my.yaml"), "unexpected config path %q", ConfigPath())
+}
+
+func TestCache(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+
+	key := cacheKey("openai", "text-embedding-ada-002", "hello")
+	Tassert(t, key != cacheKey("openai", "text-embedding-ada-002hello"), "key parts must not run together")
+	var got []float64
+	Tassert(t, !cacheGet("embeddings", key, &got), "expected a miss")
+	want := []float64{0.25, -0.5, 1}
+	cachePut("embeddings", key, want)
+	Tassert(t, cacheGet("embeddings", key, &got), "expected a hit")
+	Tassert(t, reflect.DeepEqual(got, want), "expected %v, got %v", want, got)
+
+	// a corrupt entry is a miss
+	err := os.WriteFile(cacheFile("embeddings", key), []byte("{"), 0644)
+	Ck(err)
+	Tassert(t, !cacheGet("embeddings", key, &got), "expected a miss for a corrupt entry")
+
+	g := &Grokker{}
+	g.SetCaches(false, true)
+	Tassert(t, g.noEmbeddingCache && g.responseCache, "unexpected cache settings %v %v", g.noEmbeddingCache, g.responseCache)
+
+	// a Grokker with its own cache directory doesn't share entries
+	own := TmpTestDir()
+	g.SetCacheDir(own)
+	other := cacheKey("tenant")
+	g.cachePut("embeddings", other, want)
+	Tassert(t, g.cacheGet("embeddings", other, &got), "expected a hit in the Grokker's cache")
+	Tassert(t, !cacheGet("embeddings", other, &got), "expected a miss in the shared cache")
+	Tassert(t, !g.cacheGet("embeddings", key, &got), "expected a miss for a shared entry")
+	_, err = os.Stat(cacheFileIn(own, "embeddings", other))
+	Tassert(t, err == nil, "expected the entry in %s: %v", own, err)
+	err = g.cacheDelete("embeddings", other)
+	Tassert(t, err == nil && !g.cacheGet("embeddings", other, &got), "expected the entry to be deleted: %v", err)
+}
+
+func TestCacheDaemon(t *testing.T) {
+	dir := TmpTestDir()
+	SetDirs(filepath.Join(dir, "config"), filepath.Join(dir, "cache"))
+	defer func() { configDirOverride, cacheDirOverride = "", "" }()
+	err := os.MkdirAll(ConfigDir(), 0755)
+	Ck(err)
+	err = os.WriteFile(ConfigPath(), []byte("cache:\n  max_size:\n    embeddings: 30B\n"), 0644)
+	Ck(err)
+	t.Setenv("GROKKER_CONFIG", "")
+
+	// an entry written before the daemon starts is indexed
+	old := cacheKey("old")
+	cachePut("embeddings", old, []float64{1, 2, 3})
+
+	d, err := NewCacheDaemon()
+	Tassert(t, err == nil, "error creating daemon: %v", err)
+	done := make(chan error)
+	go func() { done <- d.Serve(CacheSocket()) }()
+	defer func() {
+		d.Close()
+		Tassert(t, <-done == nil, "serve failed")
+	}()
+	for i := 0; i < 100; i++ {
+		if _, ok := callDaemon(cacheRequest{Op: "usage"}); ok {
+			break
+		}
+		cacheClient.Lock()
+		cacheClient.socket = ""
+		cacheClient.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	d2, err := NewCacheDaemon()
+	Ck(err)
+	err = d2.Serve(CacheSocket())
+	Tassert(t, err != nil, "expected a second daemon to fail")
+
+	var got []float64
+	Tassert(t, cacheGet("embeddings", old, &got) && len(got) == 3, "expected a hit for the old entry, got %v", got)
+	// each entry is 7 bytes, so the cap holds four of them, and
+	// the old entry, used again, outlives the first new ones
+	var keys []string
+	for i := 0; i < 6; i++ {
+		if i == 3 {
+			cacheGet("embeddings", old, &got)
+		}
+		key := cacheKey(Spf("new%d", i))
+		keys = append(keys, key)
+		cachePut("embeddings", key, []float64{float64(i), 2, 3})
+	}
+	Tassert(t, !cacheGet("embeddings", keys[0], &got), "expected the least recently used entry to be evicted")
+	Tassert(t, cacheGet("embeddings", old, &got), "expected the recently used entry to survive")
+	Tassert(t, cacheGet("embeddings", keys[5], &got) && got[0] == 5, "expected the newest entry, got %v", got)
+	_, err = os.Stat(cacheFile("embeddings", keys[0]))
+	Tassert(t, os.IsNotExist(err), "expected the evicted file to be removed")
+
+	usage, err := CacheUsages()
+	Tassert(t, err == nil && len(usage) == 2 && usage[0].Name == "embeddings", "got %v, %v", usage, err)
+	Tassert(t, usage[0].Size <= 30 && usage[0].Evictions > 0 && usage[0].Hits > 0, "got %v", usage[0])
+
+	err = cacheDelete("embeddings", old)
+	Ck(err)
+	Tassert(t, !cacheGet("embeddings", old, &got), "expected a miss after delete")
+
+	resp, ok := callDaemon(cacheRequest{Op: "get", Cache: "embeddings", Key: "../../etc/passwd"})
+	Tassert(t, ok && resp.Error != "" && !resp.Found, "expected an invalid key error, got %+v", resp)
+}
+
+func TestCacheDaemonTimeout(t *testing.T) {
+	dir := TmpTestDir()
+	SetDirs(filepath.Join(dir, "config"), filepath.Join(dir, "cache"))
+	defer func() { configDirOverride, cacheDirOverride = "", "" }()
+	defer func(d time.Duration) { cacheTimeout = d }(cacheTimeout)
+	cacheTimeout = 100 * time.Millisecond
+	err := os.MkdirAll(CacheDir(), 0755)
+	Ck(err)
+
+	// a daemon that accepts connections but never answers
+	ln, err := net.Listen("unix", CacheSocket())
+	Ck(err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	key := cacheKey("hung")
+	cachePut("embeddings", key, []float64{1, 2, 3})
+	start := time.Now()
+	var got []float64
+	Tassert(t, cacheGet("embeddings", key, &got) && len(got) == 3, "expected a hit from the cache files, got %v", got)
+	Tassert(t, time.Since(start) < 2*time.Second, "took %v to give up on the daemon", time.Since(start))
+	_, err = os.Stat(cacheFile("embeddings", key))
+	Tassert(t, err == nil, "expected the entry in the cache files: %v", err)
+}
@@ -0,0 +1,535 @@
+package core
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The cache daemon, 'grok cache serve', owns the embedding and
+// response caches in CacheDir() for every grok process of the user:
+// the processes send it their lookups and writes over a unix socket
+// instead of reading and writing the cache files themselves.  It
+// keeps the entries of each cache in least-recently-used order and
+// evicts the oldest once the cache grows past its cap, set in the
+// config file:
+//
+//	cache:
+//	  max_size:
+//	    embeddings: 2GB
+//	    responses: 200MB
+//
+// Without a cap a cache grows without limit, as it does without the
+// daemon.  A process that can't reach the daemon uses the files
+// directly, so the daemon is optional; it picks up those entries the
+// next time it starts.  Entries keep their files and the last use of
+// each is its file's modification time, so the order survives a
+// restart and 'grok cache prune' can apply the caps without a
+// daemon.
+
+// CacheConfig holds the settings of the caches.
+type CacheConfig struct {
+	// MaxSize caps the size of each cache, e.g. "2GB" or "500MB",
+	// keyed by cache name.
+	MaxSize map[string]string `yaml:"max_size"`
+}
+
+// daemonCaches are the caches the daemon manages.
+var daemonCaches = []string{"embeddings", "responses"}
+
+// CacheSocket returns the path of the daemon's socket, or an empty
+// string if there is no cache directory.
+func CacheSocket() string {
+	dir := CacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "cached.sock")
+}
+
+// cacheRequest is a line sent to the daemon.  Op is "get", "put",
+// "delete", or "usage".
+type cacheRequest struct {
+	Op    string
+	Cache string          `json:",omitempty"`
+	Key   string          `json:",omitempty"`
+	Value json.RawMessage `json:",omitempty"`
+}
+
+// cacheResponse is the daemon's answer to a cacheRequest.
+type cacheResponse struct {
+	Found bool            `json:",omitempty"`
+	Value json.RawMessage `json:",omitempty"`
+	Usage []CacheUsage    `json:",omitempty"`
+	Error string          `json:",omitempty"`
+}
+
+// CacheUsage describes one cache managed by the daemon.
+type CacheUsage struct {
+	Name    string
+	Entries int
+	Size    int64
+	// MaxSize is the cap, or zero for none.
+	MaxSize int64 `json:",omitempty"`
+	// Hits, Misses, and Evictions count since the daemon started.
+	Hits      int `json:",omitempty"`
+	Misses    int `json:",omitempty"`
+	Evictions int `json:",omitempty"`
+}
+
+func (u CacheUsage) String() string {
+	max := "no cap"
+	if u.MaxSize > 0 {
+		max = Spf("cap %d bytes", u.MaxSize)
+	}
+	return Spf("%-12s %8d entries %12d bytes (%s)  %d hits, %d misses, %d evicted", u.Name, u.Entries, u.Size, max, u.Hits, u.Misses, u.Evictions)
+}
+
+// parseSize parses a size such as "512", "200MB", or "2GB", in
+// bytes, with binary multiples.
+func parseSize(s string) (size int64, err error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			mult = unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		err = fmt.Errorf("invalid size %q; expected e.g. 500MB or 2GB", s)
+		return
+	}
+	size = int64(n * float64(mult))
+	return
+}
+
+// lruEntry is an entry in a daemon cache.
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+// lruCache is the state of one cache: its entries, most recently
+// used first, and their total size.
+type lruCache struct {
+	order   *list.List
+	entries map[string]*list.Element
+	size    int64
+	max     int64
+	hits    int
+	misses  int
+	evicted int
+}
+
+// CacheDaemon serves the caches in CacheDir(); see cached.go.
+type CacheDaemon struct {
+	mu       sync.Mutex
+	dir      string
+	caches   map[string]*lruCache
+	listener net.Listener
+	conns    map[net.Conn]bool
+}
+
+// NewCacheDaemon returns a daemon for the caches in CacheDir(), with
+// the caps from the config file, after indexing the entries already
+// there.
+func NewCacheDaemon() (d *CacheDaemon, err error) {
+	defer Return(&err)
+	dir := CacheDir()
+	if dir == "" {
+		err = fmt.Errorf("no cache directory")
+		return
+	}
+	cfg, err := LoadConfig()
+	Ck(err)
+	d = &CacheDaemon{dir: dir, caches: make(map[string]*lruCache), conns: make(map[net.Conn]bool)}
+	for _, name := range daemonCaches {
+		c := &lruCache{order: list.New(), entries: make(map[string]*list.Element)}
+		if cfg.Cache != nil && cfg.Cache.MaxSize[name] != "" {
+			c.max, err = parseSize(cfg.Cache.MaxSize[name])
+			Ck(err, "cache: max_size: %s", name)
+		}
+		err = d.index(name, c)
+		Ck(err)
+		d.caches[name] = c
+	}
+	for name := range cfg.Cache.maxSizes() {
+		if _, ok := d.caches[name]; !ok {
+			err = fmt.Errorf("cache: max_size: unknown cache %q; expected one of %v", name, daemonCaches)
+			return
+		}
+	}
+	return
+}
+
+// maxSizes returns the caps, which may be nil.
+func (c *CacheConfig) maxSizes() map[string]string {
+	if c == nil {
+		return nil
+	}
+	return c.MaxSize
+}
+
+// index adds the entries on disk to c, oldest use last.
+func (d *CacheDaemon) index(name string, c *lruCache) (err error) {
+	type file struct {
+		key   string
+		size  int64
+		mtime time.Time
+	}
+	var files []file
+	root := filepath.Join(d.dir, name)
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		key := strings.TrimSuffix(fi.Name(), ".json")
+		if fi.IsDir() || !validCacheKey(key) || key+".json" != fi.Name() {
+			return nil
+		}
+		files = append(files, file{key, fi.Size(), fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.After(files[j].mtime) })
+	for _, f := range files {
+		c.entries[f.key] = c.order.PushBack(&lruEntry{f.key, f.size})
+		c.size += f.size
+	}
+	return
+}
+
+// validCacheKey returns true if key is a cacheKey, which keeps
+// requests from naming files outside the cache.
+func validCacheKey(key string) bool {
+	if len(key) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// Serve listens on the socket and answers requests until Close.  It
+// fails if another daemon is already listening.
+func (d *CacheDaemon) Serve(socket string) (err error) {
+	defer Return(&err)
+	conn, dialErr := net.Dial("unix", socket)
+	if dialErr == nil {
+		conn.Close()
+		err = fmt.Errorf("a cache daemon is already listening on %s", socket)
+		return
+	}
+	// a socket left behind by a daemon that didn't exit cleanly
+	os.Remove(socket)
+	err = os.MkdirAll(filepath.Dir(socket), 0755)
+	Ck(err)
+	l, err := net.Listen("unix", socket)
+	Ck(err)
+	err = os.Chmod(socket, 0600)
+	Ck(err)
+	d.mu.Lock()
+	d.listener = l
+	d.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		Ck(err)
+		d.mu.Lock()
+		d.conns[conn] = true
+		d.mu.Unlock()
+		go d.handle(conn)
+	}
+}
+
+// Close stops Serve, removes the socket, and drops the clients, which
+// go back to using the files.
+func (d *CacheDaemon) Close() (err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.listener != nil {
+		err = d.listener.Close()
+		d.listener = nil
+	}
+	for conn := range d.conns {
+		conn.Close()
+	}
+	return
+}
+
+// handle answers the requests on one connection, one per line.
+func (d *CacheDaemon) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		d.mu.Lock()
+		delete(d.conns, conn)
+		d.mu.Unlock()
+	}()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 256*1024*1024)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req cacheRequest
+		var resp cacheResponse
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err == nil {
+			resp, err = d.do(req)
+		}
+		if err != nil {
+			resp = cacheResponse{Error: err.Error()}
+		}
+		if enc.Encode(resp) != nil {
+			return
+		}
+	}
+}
+
+// do carries out a request.
+func (d *CacheDaemon) do(req cacheRequest) (resp cacheResponse, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if req.Op == "usage" {
+		resp.Usage = d.usage()
+		return
+	}
+	c, ok := d.caches[req.Cache]
+	if !ok {
+		err = fmt.Errorf("unknown cache %q", req.Cache)
+		return
+	}
+	if !validCacheKey(req.Key) {
+		err = fmt.Errorf("invalid cache key %q", req.Key)
+		return
+	}
+	path := filepath.Join(d.dir, req.Cache, req.Key[:2], req.Key+".json")
+	switch req.Op {
+	case "get":
+		var buf []byte
+		buf, err = os.ReadFile(path)
+		if err != nil || !json.Valid(buf) {
+			// a miss, or an entry written without the daemon
+			// that has since been removed
+			err = nil
+			d.forget(c, req.Key)
+			c.misses++
+			return
+		}
+		if el, ok := c.entries[req.Key]; ok {
+			c.order.MoveToFront(el)
+		} else {
+			c.entries[req.Key] = c.order.PushFront(&lruEntry{req.Key, int64(len(buf))})
+			c.size += int64(len(buf))
+			d.evict(req.Cache, c)
+		}
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		c.hits++
+		resp.Found = true
+		resp.Value = buf
+	case "put":
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return
+		}
+		tmpfn := Spf("%s.%d.tmp", path, os.Getpid())
+		err = os.WriteFile(tmpfn, req.Value, 0644)
+		if err == nil {
+			err = os.Rename(tmpfn, path)
+		}
+		if err != nil {
+			return
+		}
+		d.forget(c, req.Key)
+		c.entries[req.Key] = c.order.PushFront(&lruEntry{req.Key, int64(len(req.Value))})
+		c.size += int64(len(req.Value))
+		d.evict(req.Cache, c)
+	case "delete":
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		d.forget(c, req.Key)
+	default:
+		err = fmt.Errorf("unknown cache op %q", req.Op)
+	}
+	return
+}
+
+// forget drops an entry from c's index.
+func (d *CacheDaemon) forget(c *lruCache, key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.size -= el.Value.(*lruEntry).size
+	c.order.Remove(el)
+	delete(c.entries, key)
+}
+
+// evict removes the least recently used entries of the named cache
+// until it is within its cap, and returns the number removed.
+func (d *CacheDaemon) evict(name string, c *lruCache) (n int) {
+	for c.max > 0 && c.size > c.max && c.order.Len() > 0 {
+		e := c.order.Back().Value.(*lruEntry)
+		path := filepath.Join(d.dir, name, e.key[:2], e.key+".json")
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			Debug("cannot evict cache entry %s: %v", path, err)
+			return
+		}
+		d.forget(c, e.key)
+		c.evicted++
+		n++
+	}
+	return
+}
+
+// Prune evicts entries until every cache is within its cap, and
+// returns the number evicted.
+func (d *CacheDaemon) Prune() (n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, c := range d.caches {
+		n += d.evict(name, c)
+	}
+	return
+}
+
+// Usage returns the usage of each cache, sorted by name.
+func (d *CacheDaemon) Usage() []CacheUsage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.usage()
+}
+
+func (d *CacheDaemon) usage() (usage []CacheUsage) {
+	for name, c := range d.caches {
+		usage = append(usage, CacheUsage{
+			Name:      name,
+			Entries:   c.order.Len(),
+			Size:      c.size,
+			MaxSize:   c.max,
+			Hits:      c.hits,
+			Misses:    c.misses,
+			Evictions: c.evicted,
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return
+}
+
+// cacheRedial is how long a process waits before it looks for a
+// daemon again, e.g. one started after 'grok serve'.
+const cacheRedial = 30 * time.Second
+
+// cacheTimeout is how long a process waits for the daemon to answer
+// before it gives up on it, e.g. a hung daemon, and uses the cache
+// files.  A variable, for tests.
+var cacheTimeout = 2 * time.Second
+
+// cacheClient is this process's connection to the daemon.
+var cacheClient struct {
+	sync.Mutex
+	// the socket last dialed and when, and the connection, which
+	// is nil if no daemon was listening
+	socket string
+	dialed time.Time
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// callDaemon sends a request to the daemon and returns its response.
+// It returns false if no daemon is running, or the daemon stopped or
+// didn't answer in time, in which case the caller uses the cache
+// files itself.
+func callDaemon(req cacheRequest) (resp cacheResponse, ok bool) {
+	cc := &cacheClient
+	cc.Lock()
+	defer cc.Unlock()
+	socket := CacheSocket()
+	if socket == "" {
+		return
+	}
+	if socket != cc.socket || (cc.conn == nil && time.Since(cc.dialed) > cacheRedial) {
+		// first use, the cache directory changed, or time to look
+		// for a daemon again
+		if cc.conn != nil {
+			cc.conn.Close()
+		}
+		cc.socket, cc.dialed, cc.conn = socket, time.Now(), nil
+		conn, err := net.DialTimeout("unix", socket, cacheTimeout)
+		if err != nil {
+			return
+		}
+		cc.conn, cc.reader = conn, bufio.NewReader(conn)
+	}
+	if cc.conn == nil {
+		return
+	}
+	buf, err := json.Marshal(req)
+	if err == nil {
+		err = cc.conn.SetDeadline(time.Now().Add(cacheTimeout))
+	}
+	if err == nil {
+		_, err = cc.conn.Write(append(buf, '\n'))
+	}
+	var line []byte
+	if err == nil {
+		line, err = cc.reader.ReadBytes('\n')
+	}
+	if err == nil {
+		err = json.Unmarshal(line, &resp)
+	}
+	if err != nil {
+		Debug("cache daemon: %v; using the cache files", err)
+		// a daemon that timed out may be hung, so wait as long
+		// as for a missing one before trying it again
+		cc.conn.Close()
+		cc.conn, cc.dialed = nil, time.Now()
+		return
+	}
+	if resp.Error != "" {
+		Debug("cache daemon: %s", resp.Error)
+	}
+	ok = true
+	return
+}
+
+// CacheUsages returns the usage of each cache from the daemon, or,
+// if none is running, from the cache files.
+func CacheUsages() (usage []CacheUsage, err error) {
+	defer Return(&err)
+	if resp, ok := callDaemon(cacheRequest{Op: "usage"}); ok {
+		usage = resp.Usage
+		return
+	}
+	d, err := NewCacheDaemon()
+	Ck(err)
+	usage = d.Usage()
+	return
+}
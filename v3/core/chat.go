@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -16,9 +17,19 @@ import (
 type ChatHistory struct {
 	Sysmsg  string
 	Version string
-	relPath string
-	msgs    []ChatMsg
-	g       *Grokker
+	// Sources maps the index of each AI message to the document
+	// chunks that were used as context for it, as "relpath:line".
+	Sources map[int][]string `json:",omitempty"`
+	// Parent is the name of the chat file this one was forked from,
+	// and ForkedAt the number of its turns the fork kept; see
+	// ForkChat.
+	Parent   string `json:",omitempty"`
+	ForkedAt int    `json:",omitempty"`
+	relPath  string
+	msgs     []ChatMsg
+	g        *Grokker
+	// whether the file is encrypted; see chatcrypt.go
+	encrypted bool
 }
 
 type ChatMsg struct {
@@ -59,14 +70,11 @@ func (g *Grokker) OpenChatHistory(sysmsg, relPath string) (history *ChatHistory,
 		err = nil
 	} else {
 		// file exists
-		fh, err := os.Open(path)
+		buf, encrypted, err := g.readChatFile(path)
 		Ck(err)
-		defer fh.Close()
 		// the first line of the file is the ChatHistory struct; load
 		// that into a ChatHistory object using json.Unmarshal
-		history = &ChatHistory{}
-		buf, err := ioutil.ReadAll(fh)
-		Ck(err)
+		history = &ChatHistory{encrypted: encrypted}
 		// get first line
 		lines := strings.Split(string(buf), "\n")
 		// unmarshal first line
@@ -110,6 +118,7 @@ func (history *ChatHistory) ContinueChat(prompt string, contextLevel util.Contex
 	getContext := false
 	appendMsgs := false
 	var files []string
+	var sources []string
 	switch contextLevel {
 	case util.ContextNone:
 		// no context
@@ -131,6 +140,13 @@ func (history *ChatHistory) ContinueChat(prompt string, contextLevel util.Contex
 	default:
 		Assert(false, "invalid context level: %s", contextLevel)
 	}
+	if attached := g.Attachments(); len(attached) > 0 && contextLevel != util.ContextNone {
+		// attachments are searched at every level but none
+		getContext = true
+		if files != nil || contextLevel == util.ContextRecent {
+			files = append(files, attached...)
+		}
+	}
 
 	if getContext {
 		maxTokens := int(float64(g.TokenLimit) * 0.5)
@@ -140,6 +156,7 @@ func (history *ChatHistory) ContinueChat(prompt string, contextLevel util.Contex
 		var context string
 		context, err = g.getContext(prompt, maxTokens, false, false, files)
 		Ck(err)
+		sources = g.sources
 		if context != "" {
 			// make context look like a message exchange
 			msgs = []ChatMsg{
@@ -194,6 +211,12 @@ func (history *ChatHistory) ContinueChat(prompt string, contextLevel util.Contex
 	// append the prompt and response to the stored messages
 	history.msgs = append(history.msgs, ChatMsg{Role: "USER", Txt: prompt})
 	history.msgs = append(history.msgs, ChatMsg{Role: "AI", Txt: resp})
+	if len(sources) > 0 {
+		if history.Sources == nil {
+			history.Sources = make(map[int][]string)
+		}
+		history.Sources[len(history.msgs)-1] = sources
+	}
 
 	// save the output files
 	err = ExtractFiles(outfiles, resp, false, false)
@@ -211,6 +234,10 @@ func (history *ChatHistory) ContinueChat(prompt string, contextLevel util.Contex
 func (g *Grokker) SendWithFiles(sysmsg string, msgs []ChatMsg, infiles []string, outfiles []FileLang) (resp string, err error) {
 	defer Return(&err)
 
+	for _, fn := range infiles {
+		err = g.checkPath(fn)
+		Ck(err)
+	}
 	if len(infiles) > 0 {
 		// include the input files in the prompt
 		promptFrag, err := IncludeFiles(infiles)
@@ -441,26 +468,21 @@ func (history *ChatHistory) fixRole(role string) (fixed string) {
 // Save saves the chat history file.
 func (history *ChatHistory) Save(addToDb bool) (err error) {
 	defer Return(&err)
-	// marshal the struct into a json string
-	buf, err := json.Marshal(history)
+	// marshal the struct into a json string, followed by the chat
+	// history, encrypted if need be
+	buf, err := history.marshal()
 	Ck(err)
-	// open a temp file
-	fh, err := ioutil.TempFile("", "chat")
+	// open a temp file next to the chat file, since Windows can't
+	// rename a file across volumes
+	Assert(history.relPath != "", "relPath is required")
+	fh, err := ioutil.TempFile(filepath.Dir(history.relPath), ".chat")
 	Ck(err)
-	// write the json string to the temp file
+	// write the chat file to the temp file
 	_, err = fh.Write(buf)
 	Ck(err)
-	// write a newline to the temp file
-	_, err = fh.Write([]byte("\n"))
-	Ck(err)
-	// write the chat history to the temp file
-	txt := history.chat2txt(history.msgs)
-	_, err = fh.Write([]byte(txt))
-	Ck(err)
 	// close the temp file
 	err = fh.Close()
 	Ck(err)
-	Assert(history.relPath != "", "relPath is required")
 	path := history.relPath
 	// move the existing chat history file to a backup file
 	var backup string
@@ -493,7 +515,12 @@ func (history *ChatHistory) Save(addToDb bool) (err error) {
 		}
 	}
 
-	if addToDb {
+	if addToDb && history.encrypted {
+		// the db can't read encrypted files for context
+		Debug("not adding encrypted chat history %s to the db", path)
+		err = history.g.ForgetDocument(path)
+		Ck(err)
+	} else if addToDb {
 		// call AddDocument to update the embeddings
 		err = history.g.AddDocument(path)
 		Ck(err)
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Chat histories can hold code and secrets, so they can be stored
+// encrypted, and old ones redacted or deleted by 'grok chat scrub'.
+// It's set under "chat:" in the user config file:
+//
+//	chat:
+//	  encrypt: true
+//	  redact_after: 7d    # apply policy.redact to older sessions
+//	  expire_after: 90d   # delete older sessions
+//
+// Encrypted chat files are sealed with AES-256-GCM and a key kept in
+// the OS keyring (see keyring.go), created the first time a chat is
+// saved, or taken from $GROKKER_CHAT_KEY, base64, where there is no
+// keyring.  An encrypted file is one line saying so and one line of
+// base64; files that were encrypted stay encrypted when saved again.
+// The knowledge base doesn't store the text of documents, so it can't
+// use encrypted chat files for context; they're kept out of it.
+
+// ChatConfig is the "chat:" section of the user config.
+type ChatConfig struct {
+	// Encrypt encrypts chat files when they're saved.
+	Encrypt bool `yaml:"encrypt"`
+	// RedactAfter and ExpireAfter are ages, e.g. "12h" or "30d",
+	// after which 'grok chat scrub' redacts or deletes a session.
+	RedactAfter string `yaml:"redact_after"`
+	ExpireAfter string `yaml:"expire_after"`
+}
+
+// chatKeyEnv holds the chat key where there is no keyring.
+const chatKeyEnv = "GROKKER_CHAT_KEY"
+
+// encryptedChatMagic is the first line of an encrypted chat file.
+const encryptedChatMagic = "grokker encrypted chat v1"
+
+// getChatConfig returns the chat settings from the user config file,
+// loading them on first use.
+func (g *Grokker) getChatConfig() (cfg *ChatConfig, err error) {
+	defer Return(&err)
+	if g.chatCfg == nil {
+		userCfg, err := LoadConfig()
+		Ck(err)
+		g.chatCfg = userCfg.Chat
+		if g.chatCfg == nil {
+			g.chatCfg = &ChatConfig{}
+		}
+	}
+	cfg = g.chatCfg
+	return
+}
+
+// getChatKey returns the key chat files are encrypted with.  If
+// create is true and there is no key yet, one is made and stored in
+// the keyring, unless there are encrypted chat files under the root:
+// their key is missing, not new, and a new one would only hide that.
+func (g *Grokker) getChatKey(create bool) (key []byte, err error) {
+	defer Return(&err)
+	if g.chatKey != nil {
+		return g.chatKey, nil
+	}
+	secret := os.Getenv(chatKeyEnv)
+	if secret == "" {
+		var ok bool
+		secret, ok, err = keyringGet("chat")
+		Ck(err, "getting the chat key")
+		if !ok {
+			if !create {
+				err = fmt.Errorf("no chat key in the keyring; set %s to the key the chat was encrypted with", chatKeyEnv)
+				return
+			}
+			var path string
+			path, err = g.findEncryptedChat()
+			Ck(err)
+			if path != "" {
+				err = fmt.Errorf("no chat key in the keyring, but %s is encrypted; set %s to the key it was encrypted with", path, chatKeyEnv)
+				return
+			}
+			key = make([]byte, 32)
+			_, err = rand.Read(key)
+			Ck(err)
+			secret = base64.StdEncoding.EncodeToString(key)
+			err = keyringSet("chat", secret)
+			Ck(err)
+		}
+	}
+	key, err = base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(key) != 32 {
+		err = fmt.Errorf("the chat key must be 32 bytes, base64")
+		return
+	}
+	g.chatKey = key
+	return
+}
+
+// findEncryptedChat returns the path of an encrypted chat file under
+// the root, looking where ScrubChats does, or "" if there is none.
+func (g *Grokker) findEncryptedChat() (found string, err error) {
+	if g.Root == "" {
+		return
+	}
+	magic := []byte(encryptedChatMagic + "\n")
+	errFound := errors.New("found")
+	err = filepath.WalkDir(g.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable directories can't hold chats we save
+			return nil
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != g.Root && strings.HasPrefix(name, ".") && name != chatSessionDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fh, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer fh.Close()
+		buf := make([]byte, len(magic))
+		n, _ := io.ReadFull(fh, buf)
+		if isEncryptedChat(buf[:n]) {
+			found = path
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		err = nil
+	}
+	return
+}
+
+// isEncryptedChat returns true if buf is an encrypted chat file.
+func isEncryptedChat(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte(encryptedChatMagic+"\n"))
+}
+
+// encryptChat seals the text of a chat file.
+func encryptChat(key, plain []byte) (buf []byte, err error) {
+	defer Return(&err)
+	block, err := aes.NewCipher(key)
+	Ck(err)
+	gcm, err := cipher.NewGCM(block)
+	Ck(err)
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	Ck(err)
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(encryptedChatMagic))
+	buf = []byte(encryptedChatMagic + "\n" + base64.StdEncoding.EncodeToString(sealed) + "\n")
+	return
+}
+
+// decryptChat opens an encrypted chat file.
+func decryptChat(key, buf []byte) (plain []byte, err error) {
+	defer Return(&err)
+	Assert(isEncryptedChat(buf), "not an encrypted chat file")
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf[len(encryptedChatMagic)+1:])))
+	Ck(err)
+	block, err := aes.NewCipher(key)
+	Ck(err)
+	gcm, err := cipher.NewGCM(block)
+	Ck(err)
+	if len(sealed) < gcm.NonceSize() {
+		err = fmt.Errorf("encrypted chat file is truncated")
+		return
+	}
+	nonce := sealed[:gcm.NonceSize()]
+	plain, err = gcm.Open(nil, nonce, sealed[gcm.NonceSize():], []byte(encryptedChatMagic))
+	if err != nil {
+		err = fmt.Errorf("can't decrypt chat file; is the chat key the one it was encrypted with?")
+	}
+	return
+}
+
+// readChatFile returns the plain text of the chat file in path,
+// decrypting it if needed, and whether it was encrypted.
+func (g *Grokker) readChatFile(path string) (buf []byte, encrypted bool, err error) {
+	defer Return(&err)
+	buf, err = os.ReadFile(path)
+	Ck(err)
+	if !isEncryptedChat(buf) {
+		return
+	}
+	key, err := g.getChatKey(false)
+	Ck(err)
+	buf, err = decryptChat(key, buf)
+	Ck(err, "%s", path)
+	encrypted = true
+	return
+}
+
+// marshal returns the content of the chat file, encrypted if the
+// history was or the config says to.
+func (history *ChatHistory) marshal() (buf []byte, err error) {
+	defer Return(&err)
+	header, err := json.Marshal(history)
+	Ck(err)
+	buf = append(header, '\n')
+	buf = append(buf, history.chat2txt(history.msgs)...)
+	cfg, err := history.g.getChatConfig()
+	Ck(err)
+	if !history.encrypted && !cfg.Encrypt {
+		return
+	}
+	// a file that was encrypted needs the key it was encrypted
+	// with, not a new one
+	key, err := history.g.getChatKey(!history.encrypted)
+	Ck(err)
+	buf, err = encryptChat(key, buf)
+	Ck(err)
+	history.encrypted = true
+	return
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestEncryptedChat(t *testing.T) {
+	dir := TmpTestDir()
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	t.Setenv(chatKeyEnv, key)
+	g := &Grokker{Root: dir, chatCfg: &ChatConfig{Encrypt: true}}
+	md := "## USER\n\nthe password is hunter2\n\n## AI\n\nnoted\n"
+	fn := filepath.Join(dir, "s.chat")
+	err := g.ImportChat(fn, md, false)
+	Tassert(t, err == nil, "error importing: %v", err)
+	buf, err := os.ReadFile(fn)
+	Tassert(t, err == nil, "error reading: %v", err)
+	Tassert(t, isEncryptedChat(buf), "expected encrypted file, got %q", buf)
+	Tassert(t, !strings.Contains(string(buf), "hunter2"), "plain text in %q", buf)
+
+	// encrypted files stay encrypted, and can be forked
+	g2 := &Grokker{Root: dir, chatCfg: &ChatConfig{}}
+	fork, err := g2.ForkChat(fn, 1, "")
+	Tassert(t, err == nil, "error forking: %v", err)
+	buf, err = os.ReadFile(fork)
+	Tassert(t, err == nil && isEncryptedChat(buf), "expected encrypted fork: %v", err)
+	branches, err := g2.ChatBranches(fn)
+	Tassert(t, err == nil && len(branches) == 1, "expected 1 branch, got %v, %v", branches, err)
+	out, err := g2.ExportChat(fn, "md")
+	Tassert(t, err == nil, "error exporting: %v", err)
+	Tassert(t, strings.Contains(out, "hunter2"), "expected plain text, got %q", out)
+
+	// the wrong key fails
+	t.Setenv(chatKeyEnv, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32))))
+	g3 := &Grokker{Root: dir, chatCfg: &ChatConfig{}}
+	_, err = g3.OpenChatHistory("", fn)
+	Tassert(t, err != nil, "expected error with the wrong key")
+}
+
+func TestScrubChats(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv(chatKeyEnv, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	g := &Grokker{Root: dir, chatCfg: &ChatConfig{}}
+	g.policy = &Policy{Redact: []string{`hunter\d`}}
+	err := g.policy.compile()
+	Tassert(t, err == nil, "error compiling policy: %v", err)
+	md := "## USER\n\nthe password is hunter2\n\n## AI\n\nnoted\n"
+	week := time.Now().Add(-8 * 24 * time.Hour)
+	year := time.Now().Add(-400 * 24 * time.Hour)
+	for name, mtime := range map[string]time.Time{"new.chat": time.Now(), "week.chat": week, "year.chat": year} {
+		fn := filepath.Join(dir, name)
+		err = g.ImportChat(fn, md, false)
+		Tassert(t, err == nil, "error importing: %v", err)
+		err = os.Chtimes(fn, mtime, mtime)
+		Tassert(t, err == nil, "error setting mtime: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "notes.md"), []byte("hunter2\n"), 0644)
+	Tassert(t, err == nil, "error writing: %v", err)
+
+	age, err := ParseAge("7d")
+	Tassert(t, err == nil && age == 7*24*time.Hour, "got %v, %v", age, err)
+	_, err = ParseAge("7 days")
+	Tassert(t, err != nil, "expected error parsing age")
+
+	cfg := ChatConfig{RedactAfter: "7d", ExpireAfter: "365d", Encrypt: true}
+	actions, err := g.ScrubChats(dir, cfg, true)
+	Tassert(t, err == nil, "error scrubbing: %v", err)
+	Tassert(t, len(actions) == 3, "expected 3 actions, got %v", actions)
+	_, err = os.Stat(filepath.Join(dir, "year.chat"))
+	Tassert(t, err == nil, "dry run removed a file")
+
+	actions, err = g.ScrubChats(dir, cfg, false)
+	Tassert(t, err == nil, "error scrubbing: %v", err)
+	got := map[string]string{}
+	for _, a := range actions {
+		got[filepath.Base(a.Path)] = a.Action
+	}
+	Tassert(t, got["year.chat"] == "expired" && got["week.chat"] == "redacted" && got["new.chat"] == "encrypted", "got %v", got)
+	_, err = os.Stat(filepath.Join(dir, "year.chat"))
+	Tassert(t, os.IsNotExist(err), "expected year.chat removed")
+	history, err := g.OpenChatHistory("", filepath.Join(dir, "week.chat"))
+	Tassert(t, err == nil, "error opening: %v", err)
+	Tassert(t, history.encrypted && strings.Contains(history.msgs[0].Txt, redactedText), "got %v", history.msgs)
+	info, err := os.Stat(filepath.Join(dir, "week.chat"))
+	Tassert(t, err == nil && info.ModTime().Unix() == week.Unix(), "expected mtime kept: %v", err)
+	buf, err := os.ReadFile(filepath.Join(dir, "notes.md"))
+	Tassert(t, err == nil && string(buf) == "hunter2\n", "scrubbed a file that isn't a chat")
+
+	// nothing left to do
+	actions, err = g.ScrubChats(dir, cfg, false)
+	Tassert(t, err == nil && len(actions) == 0, "expected no actions, got %v, %v", actions, err)
+}
+
+func TestChatKeyCreation(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv(chatKeyEnv, "")
+	defer func(get func(string) (string, bool, error), set func(string, string) error) {
+		keyringGet, keyringSet = get, set
+	}(keyringGet, keyringSet)
+	stored := ""
+	var lookupErr error
+	keyringGet = func(account string) (string, bool, error) {
+		return stored, stored != "", lookupErr
+	}
+	keyringSet = func(account, secret string) error {
+		stored = secret
+		return nil
+	}
+
+	// a keyring that can't be read isn't taken to be empty
+	lookupErr = errors.New("keyring is locked")
+	g := &Grokker{Root: dir}
+	_, err := g.getChatKey(true)
+	Tassert(t, err != nil && stored == "", "expected an error and no new key, got %v", err)
+	lookupErr = nil
+
+	// with an encrypted chat and no key, no key is made
+	fn := filepath.Join(dir, "sub", "old.chat")
+	err = os.MkdirAll(filepath.Dir(fn), 0755)
+	Ck(err)
+	err = os.WriteFile(fn, []byte(encryptedChatMagic+"\nAAAA\n"), 0644)
+	Ck(err)
+	_, err = g.getChatKey(true)
+	Tassert(t, err != nil && strings.Contains(err.Error(), fn) && stored == "", "expected an error naming %s, got %v", fn, err)
+
+	// otherwise the first key is made and stored
+	err = os.Remove(fn)
+	Ck(err)
+	key, err := g.getChatKey(true)
+	Tassert(t, err == nil && len(key) == 32 && stored != "", "expected a new key, got %v", err)
+
+	Tassert(t, keyringNotFound("linux", 1, ""), "expected secret-tool's silent exit to be not found")
+	Tassert(t, !keyringNotFound("linux", 1, "Cannot autolaunch D-Bus without X11 $DISPLAY"), "expected a D-Bus error to be an error")
+	Tassert(t, keyringNotFound("darwin", 44, "The specified item could not be found in the keychain."), "expected security's 44 to be not found")
+	Tassert(t, !keyringNotFound("darwin", 51, "User interaction is not allowed."), "expected a locked keychain to be an error")
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A fork is a copy of a chat history that keeps its first turns, so
+// another line of questioning can be explored from there without
+// losing the original.  A turn is a USER message and the AI's answer
+// to it.  The fork is an ordinary chat file next to the original,
+// named e.g. "debug.branch-1.chat" for "debug.chat" unless given a
+// name, and its header records the name of the file it was forked
+// from.  Forks can be forked in turn; ChatBranches finds them all by
+// reading the headers of the chat files in the directory, so forks
+// moved elsewhere are no longer listed.
+
+// ChatBranch is a fork of a chat history.
+type ChatBranch struct {
+	Path string
+	// Parent is the path of the chat history it was forked from.
+	Parent   string
+	ForkedAt int
+	// Turns is the number of turns in the fork now.
+	Turns int
+	// Depth is 1 for forks of the original, 2 for forks of those,
+	// and so on.
+	Depth int
+}
+
+// backupRe matches the names Save gives backups of chat files, which
+// carry the same header as the chat they back up.
+var backupRe = regexp.MustCompile(`\.\d{8}-\d{6}(\.|$)`)
+
+// turns returns the number of turns in msgs.  Text before the first
+// USER message counts as a turn of its own.
+func turns(msgs []ChatMsg) (n int) {
+	for i, msg := range msgs {
+		if msg.Role == "USER" || i == 0 {
+			n++
+		}
+	}
+	return
+}
+
+// ForkChat copies the first at turns of the chat history in path to
+// a new chat file and returns its path.  If forkPath is empty, the
+// fork is named after path with the next free ".branch-N" suffix.
+func (g *Grokker) ForkChat(path string, at int, forkPath string) (out string, err error) {
+	defer Return(&err)
+	_, err = os.Stat(path)
+	Ck(err)
+	history, err := g.OpenChatHistory("", path)
+	Ck(err)
+	n := turns(history.msgs)
+	if at < 1 || at > n {
+		err = fmt.Errorf("%s has %d turns; can't fork at turn %d", path, n, at)
+		return
+	}
+	if forkPath == "" {
+		forkPath = branchPath(path)
+	}
+	_, err = os.Stat(forkPath)
+	if err == nil {
+		err = fmt.Errorf("%s already exists", forkPath)
+		return
+	}
+	err = nil
+	// keep the messages before the turn after at
+	keep := len(history.msgs)
+	seen := 0
+	for i, msg := range history.msgs {
+		if msg.Role == "USER" || i == 0 {
+			seen++
+			if seen > at {
+				keep = i
+				break
+			}
+		}
+	}
+	fork := &ChatHistory{
+		Sysmsg:    history.Sysmsg,
+		Version:   history.Version,
+		Parent:    filepath.Base(path),
+		ForkedAt:  at,
+		relPath:   forkPath,
+		msgs:      history.msgs[:keep],
+		g:         g,
+		encrypted: history.encrypted,
+	}
+	for i, sources := range history.Sources {
+		if i < keep {
+			if fork.Sources == nil {
+				fork.Sources = make(map[int][]string)
+			}
+			fork.Sources[i] = sources
+		}
+	}
+	err = fork.Save(false)
+	Ck(err)
+	out = forkPath
+	return
+}
+
+// branchPath returns the first unused ".branch-N" name for a fork of
+// the chat file in path.
+func branchPath(path string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		p := Spf("%s.branch-%d%s", stem, i, ext)
+		_, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			return p
+		}
+	}
+}
+
+// readChatHeader returns the header of the chat file in path, or nil
+// if it isn't a chat file.
+func (g *Grokker) readChatHeader(path string) (history *ChatHistory, err error) {
+	defer Return(&err)
+	fh, err := os.Open(path)
+	Ck(err)
+	defer fh.Close()
+	line, err := bufio.NewReader(fh).ReadString('\n')
+	if err != nil && err != io.EOF {
+		Ck(err)
+	}
+	err = nil
+	if isEncryptedChat([]byte(line)) {
+		buf, _, err := g.readChatFile(path)
+		Ck(err)
+		line, _, _ = strings.Cut(string(buf), "\n")
+	}
+	// Save always writes Sysmsg first
+	if !strings.HasPrefix(line, `{"Sysmsg":`) {
+		return nil, nil
+	}
+	history = &ChatHistory{}
+	if json.Unmarshal([]byte(line), history) != nil {
+		return nil, nil
+	}
+	return
+}
+
+// ChatBranches returns the forks of the chat history in path, and
+// the forks of those, each followed by its own forks.
+func (g *Grokker) ChatBranches(path string) (branches []ChatBranch, err error) {
+	defer Return(&err)
+	_, err = os.Stat(path)
+	Ck(err)
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	Ck(err)
+	// map each chat file name to the names of its forks
+	children := make(map[string][]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || backupRe.MatchString(name) {
+			continue
+		}
+		header, err := g.readChatHeader(filepath.Join(dir, name))
+		Ck(err)
+		if header == nil || header.Parent == "" {
+			continue
+		}
+		children[header.Parent] = append(children[header.Parent], name)
+	}
+	seen := map[string]bool{filepath.Base(path): true}
+	var walk func(parent string, depth int) error
+	walk = func(parent string, depth int) (err error) {
+		defer Return(&err)
+		for _, name := range children[parent] {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			p := filepath.Join(dir, name)
+			history, err := g.OpenChatHistory("", p)
+			Ck(err)
+			branches = append(branches, ChatBranch{
+				Path:     p,
+				Parent:   filepath.Join(dir, parent),
+				ForkedAt: history.ForkedAt,
+				Turns:    turns(history.msgs),
+				Depth:    depth,
+			})
+			err = walk(name, depth+1)
+			Ck(err)
+		}
+		return
+	}
+	err = walk(filepath.Base(path), 1)
+	Ck(err)
+	return
+}
+
+// PruneChat removes forks of the chat history in path, along with
+// their own forks, and forgets them if they were added to the
+// knowledge base.  If only is empty, all forks are removed; otherwise
+// only the named ones.  It returns the paths removed.
+func (g *Grokker) PruneChat(path string, only []string) (removed []string, err error) {
+	defer Return(&err)
+	branches, err := g.ChatBranches(path)
+	Ck(err)
+	prune := make(map[string]bool)
+	for _, p := range only {
+		found := false
+		for _, b := range branches {
+			if filepath.Clean(p) == b.Path {
+				found = true
+			}
+		}
+		if !found {
+			err = fmt.Errorf("%s is not a fork of %s", p, path)
+			return
+		}
+		prune[filepath.Clean(p)] = true
+	}
+	for _, b := range branches {
+		// forks are listed after their parents
+		if len(only) > 0 && !prune[b.Path] && !prune[b.Parent] {
+			continue
+		}
+		prune[b.Path] = true
+		err = os.Remove(b.Path)
+		Ck(err)
+		err = g.ForgetDocument(b.Path)
+		Ck(err)
+		removed = append(removed, b.Path)
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestForkChat(t *testing.T) {
+	dir := TmpTestDir()
+	g := &Grokker{Root: dir}
+	md := "## USER\n\none\n\n## AI\n\nuno\n\nSources:\n\n- a.md:1\n\n" +
+		"## USER\n\ntwo\n\n## AI\n\ndos\n\n## USER\n\nthree\n\n## AI\n\ntres\n"
+	fn := filepath.Join(dir, "s.chat")
+	err := g.ImportChat(fn, md, false)
+	Tassert(t, err == nil, "error importing: %v", err)
+
+	_, err = g.ForkChat(fn, 4, "")
+	Tassert(t, err != nil, "expected error forking past the last turn")
+	fork, err := g.ForkChat(fn, 2, "")
+	Tassert(t, err == nil, "error forking: %v", err)
+	Tassert(t, fork == filepath.Join(dir, "s.branch-1.chat"), "got fork %s", fork)
+	history, err := g.OpenChatHistory("", fork)
+	Tassert(t, err == nil, "error opening fork: %v", err)
+	Tassert(t, len(history.msgs) == 4, "expected 4 messages, got %v", history.msgs)
+	Tassert(t, history.Parent == "s.chat" && history.ForkedAt == 2, "got parent %q at %d", history.Parent, history.ForkedAt)
+	Tassert(t, len(history.Sources[1]) == 1, "expected sources kept, got %v", history.Sources)
+
+	// fork the fork, and fork the original again
+	sub, err := g.ForkChat(fork, 1, filepath.Join(dir, "sub.chat"))
+	Tassert(t, err == nil, "error forking: %v", err)
+	fork2, err := g.ForkChat(fn, 1, "")
+	Tassert(t, err == nil, "error forking: %v", err)
+	Tassert(t, fork2 == filepath.Join(dir, "s.branch-2.chat"), "got fork %s", fork2)
+	_, err = g.ForkChat(fn, 1, sub)
+	Tassert(t, err != nil, "expected error forking over an existing file")
+
+	branches, err := g.ChatBranches(fn)
+	Tassert(t, err == nil, "error listing branches: %v", err)
+	Tassert(t, len(branches) == 3, "expected 3 branches, got %v", branches)
+	depths := map[string]int{}
+	for _, b := range branches {
+		depths[b.Path] = b.Depth
+	}
+	Tassert(t, depths[fork] == 1 && depths[sub] == 2 && depths[fork2] == 1, "got %v", branches)
+
+	// pruning a fork removes its forks too
+	removed, err := g.PruneChat(fn, []string{fork})
+	Tassert(t, err == nil, "error pruning: %v", err)
+	Tassert(t, len(removed) == 2, "expected 2 removed, got %v", removed)
+	_, err = os.Stat(sub)
+	Tassert(t, os.IsNotExist(err), "expected %s removed", sub)
+	_, err = g.PruneChat(fn, []string{fn})
+	Tassert(t, err != nil, "expected error pruning the original")
+	removed, err = g.PruneChat(fn, nil)
+	Tassert(t, err == nil, "error pruning: %v", err)
+	Tassert(t, len(removed) == 1 && removed[0] == fork2, "got %v", removed)
+	_, err = os.Stat(fn)
+	Tassert(t, err == nil, "expected original kept: %v", err)
+}
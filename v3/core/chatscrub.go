@@ -0,0 +1,159 @@
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// ScrubAction is what ScrubChats did, or would do, to a chat file.
+type ScrubAction struct {
+	Path string
+	// Action is "expired", "redacted", or "encrypted".
+	Action string
+	// Redactions is the number of matches of policy.redact replaced.
+	Redactions int
+	Age        time.Duration
+}
+
+// ParseAge parses an age such as "36h" or "30d".
+func ParseAge(s string) (age time.Duration, err error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	age, err = time.ParseDuration(s)
+	if err != nil {
+		err = fmt.Errorf("invalid age %q; expected e.g. 36h or 30d", s)
+	}
+	return
+}
+
+// ScrubChats applies the chat policy in cfg to the chat files under
+// dir, including Save's backups: files not modified for ExpireAfter
+// are deleted and forgotten; files not modified for RedactAfter have
+// the matches of the policy's redact patterns replaced; and, if
+// Encrypt is set, plain files are encrypted.  Rewritten files keep
+// their modification time, so they still age.  If dryRun is true,
+// nothing is changed.
+func (g *Grokker) ScrubChats(dir string, cfg ChatConfig, dryRun bool) (actions []ScrubAction, err error) {
+	defer Return(&err)
+	var redactAfter, expireAfter time.Duration
+	if cfg.RedactAfter != "" {
+		redactAfter, err = ParseAge(cfg.RedactAfter)
+		Ck(err)
+	}
+	if cfg.ExpireAfter != "" {
+		expireAfter, err = ParseAge(cfg.ExpireAfter)
+		Ck(err)
+	}
+	p, err := g.getPolicy()
+	Ck(err)
+	if redactAfter > 0 && len(p.redactRes) == 0 {
+		err = fmt.Errorf("redact_after is set, but the policy has no redact patterns")
+		return
+	}
+	now := time.Now()
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			// chat sessions are kept in a dot directory; see
+			// chatsession.go
+			if path != dir && strings.HasPrefix(name, ".") && name != chatSessionDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || !d.Type().IsRegular() {
+			return nil
+		}
+		header, err := g.readChatHeader(path)
+		if err != nil || header == nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		action, err := g.scrubChat(path, info.ModTime(), now, redactAfter, expireAfter, cfg.Encrypt, dryRun)
+		if err != nil || action == nil {
+			return err
+		}
+		actions = append(actions, *action)
+		return nil
+	})
+	Ck(err)
+	return
+}
+
+// scrubChat applies the chat policy to one chat file, returning nil
+// if there's nothing to do.
+func (g *Grokker) scrubChat(path string, mtime, now time.Time, redactAfter, expireAfter time.Duration, encrypt, dryRun bool) (action *ScrubAction, err error) {
+	defer Return(&err)
+	age := now.Sub(mtime)
+	if expireAfter > 0 && age > expireAfter {
+		action = &ScrubAction{Path: path, Action: "expired", Age: age}
+		if !dryRun {
+			err = os.Remove(path)
+			Ck(err)
+			err = g.ForgetDocument(path)
+			Ck(err)
+		}
+		return
+	}
+	history, err := g.OpenChatHistory("", path)
+	Ck(err)
+	count := 0
+	if redactAfter > 0 && age > redactAfter {
+		p, err := g.getPolicy()
+		Ck(err)
+		var n int
+		history.Sysmsg, n = p.redact(history.Sysmsg)
+		count += n
+		for i := range history.msgs {
+			history.msgs[i].Txt, n = p.redact(history.msgs[i].Txt)
+			count += n
+		}
+	}
+	switch {
+	case count > 0:
+		action = &ScrubAction{Path: path, Action: "redacted", Redactions: count, Age: age}
+	case encrypt && !history.encrypted:
+		action = &ScrubAction{Path: path, Action: "encrypted", Age: age}
+	default:
+		return
+	}
+	if dryRun {
+		return
+	}
+	if encrypt {
+		history.encrypted = true
+	}
+	// rewrite in place; a backup would keep what was scrubbed
+	buf, err := history.marshal()
+	Ck(err)
+	tmp := path + ".scrub"
+	err = os.WriteFile(tmp, buf, 0600)
+	Ck(err)
+	err = os.Rename(tmp, path)
+	Ck(err)
+	err = os.Chtimes(path, mtime, mtime)
+	Ck(err)
+	if history.encrypted {
+		err = g.ForgetDocument(path)
+		Ck(err)
+	}
+	return
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A chat session is a chat history kept by name for 'grok chat
+// session', which reads prompts interactively, e.g.
+//
+//	grok chat session deploy    # starts "deploy", or resumes it
+//
+// Sessions are ordinary chat files in .grok-chats/<name>.chat in the
+// root of the knowledge base, so they can be forked, scrubbed, and
+// added to the knowledge base like any other.  Each turn retrieves
+// context for the prompt from the whole knowledge base, and the
+// history sent with it is summarized to fit the model's context
+// window, as with 'grok chat -C'; see ContinueChat.  The history is
+// saved after every turn, so a session can be resumed after the
+// process ends.
+
+// chatSessionDir is the directory, in the root of the knowledge
+// base, that chat sessions are kept in.
+const chatSessionDir = ".grok-chats"
+
+// chatSessionNameRe matches valid session names.
+var chatSessionNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ChatSession describes a saved chat session.
+type ChatSession struct {
+	Name     string
+	Path     string
+	Turns    int
+	Modified time.Time
+}
+
+// ChatSessionPath returns the path of the chat file for the named
+// session.
+func (g *Grokker) ChatSessionPath(name string) (path string, err error) {
+	if !chatSessionNameRe.MatchString(name) {
+		err = fmt.Errorf("invalid session name %q; use letters, digits, '.', '_', and '-'", name)
+		return
+	}
+	path = filepath.Join(g.Root, chatSessionDir, name+".chat")
+	return
+}
+
+// OpenChatSession opens the named chat session, creating it if it
+// doesn't exist.  If sysmsg isn't empty, it replaces the session's
+// system message.
+func (g *Grokker) OpenChatSession(name, sysmsg string) (history *ChatHistory, err error) {
+	defer Return(&err)
+	path, err := g.ChatSessionPath(name)
+	Ck(err)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	Ck(err)
+	history, err = g.OpenChatHistory(sysmsg, path)
+	Ck(err)
+	return
+}
+
+// ChatSessions returns the saved chat sessions, most recently used
+// first.
+func (g *Grokker) ChatSessions() (sessions []ChatSession, err error) {
+	defer Return(&err)
+	dir := filepath.Join(g.Root, chatSessionDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	Ck(err)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".chat") || backupRe.MatchString(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		history, err := g.OpenChatHistory("", path)
+		Ck(err)
+		info, err := e.Info()
+		Ck(err)
+		sessions = append(sessions, ChatSession{
+			Name:     strings.TrimSuffix(name, ".chat"),
+			Path:     path,
+			Turns:    history.Turns(),
+			Modified: info.ModTime(),
+		})
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Modified.After(sessions[j].Modified)
+	})
+	return
+}
+
+// Turns returns the number of turns in the chat history; a turn is
+// a prompt and its response.
+func (history *ChatHistory) Turns() int {
+	return turns(history.msgs)
+}
+
+// Turn sends a prompt with context from the whole knowledge base,
+// returns the response, and saves the history; addToDb is as for
+// Save.
+func (history *ChatHistory) Turn(prompt string, addToDb bool) (resp string, err error) {
+	defer Return(&err)
+	resp, _, err = history.ContinueChat(prompt, util.ContextAll, nil, nil, 0, false)
+	Ck(err)
+	err = history.Save(addToDb)
+	Ck(err)
+	return
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	oai "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+func TestChatSession(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	var requests []oai.ChatCompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req oai.ChatCompletionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		Ck(err)
+		requests = append(requests, req)
+		w.Write([]byte(Spf(`{"choices": [{"message": {"role": "assistant", "content": "answer %d"}}]}`, len(requests))))
+	}))
+	defer srv.Close()
+
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	config := oai.DefaultConfig("testkey")
+	config.BaseURL = srv.URL + "/v1"
+	grok.chatClient = oai.NewClientWithConfig(config)
+	path := filepath.Join(dir, "deploy.md")
+	err = os.WriteFile(path, []byte("Deploy with make deploy.\n"), 0644)
+	Ck(err)
+	err = grok.AddDocument(path)
+	Ck(err)
+
+	history, err := grok.OpenChatSession("deploy", "")
+	Ck(err)
+	Tassert(t, history.Turns() == 0, "expected a new session, got %d turns", history.Turns())
+	resp, err := history.Turn("how do I deploy?", true)
+	Tassert(t, err == nil, "error in turn: %v", err)
+	Tassert(t, resp == "answer 1", "got %q", resp)
+	sent := Spf("%v", requests[0].Messages)
+	Tassert(t, strings.Contains(sent, "make deploy"), "expected retrieved context, got %s", sent)
+
+	// resume in a new process
+	history, err = grok.OpenChatSession("deploy", "")
+	Ck(err)
+	Tassert(t, history.Turns() == 1, "expected 1 turn, got %d", history.Turns())
+	_, err = history.Turn("and to staging?", true)
+	Tassert(t, err == nil, "error in turn: %v", err)
+	sent = Spf("%v", requests[1].Messages)
+	Tassert(t, strings.Contains(sent, "how do I deploy?") && strings.Contains(sent, "answer 1"), "expected the history, got %s", sent)
+
+	sessionPath, err := grok.ChatSessionPath("deploy")
+	Ck(err)
+	Tassert(t, sessionPath == filepath.Join(dir, chatSessionDir, "deploy.chat"), "got %s", sessionPath)
+	Tassert(t, strings.Contains(strings.Join(grok.ListDocuments(), " "), "deploy.chat"), "expected the session in the knowledge base")
+	_, err = grok.OpenChatSession("other", "")
+	Ck(err)
+	sessions, err := grok.ChatSessions()
+	Ck(err)
+	// the second turn's save backed up the first; new sessions
+	// aren't saved until their first turn
+	Tassert(t, len(sessions) == 1 && sessions[0].Name == "deploy" && sessions[0].Turns == 2, "got %+v", sessions)
+
+	_, err = grok.OpenChatSession("../escape", "")
+	Tassert(t, err != nil, "expected an error for an invalid name")
+}
@@ -12,6 +12,7 @@ import (
 
 	. "github.com/stevegt/goadapt"
 	"github.com/stevegt/grokker/v3/util"
+	"github.com/tiktoken-go/tokenizer"
 )
 
 // Chunk is a single chunk of text from a document.
@@ -28,28 +29,51 @@ type Chunk struct {
 	Hash string
 	// The text of the chunk.  This is not stored in the db.
 	text string
+	// The text of the chunk and its starting line number in the
+	// document.  These are only stored in snapshots, where the
+	// document may have changed since.
+	Text string `json:",omitempty"`
+	Line int    `json:",omitempty"`
+	// The headings a markdown chunk is under, e.g. "Install >
+	// Linux", or what a code chunk defines, e.g.
+	// "Grokker.Retrieve"; see mdchunk.go and codechunk.go.
+	Section string `json:",omitempty"`
+	// The names a code chunk defines, for its Symbols; see
+	// codechunk.go.
+	defs []string
 	// The embedding of the chunk.
 	Embedding []float64
+	// Symbols defined or mentioned in the chunk; see symbols.go.
+	Symbols []string `json:",omitempty"`
+	// The chunk's languages, e.g. "go", or "markdown" and "en"; see
+	// chunklang.go.
+	Langs []string `json:",omitempty"`
+	// If not empty, the reason the chunk is on the stop-list; see
+	// stoplist.go.
+	Excluded string `json:",omitempty"`
+	// Additional embeddings of the chunk from other embedders, keyed
+	// by embedder spec; see Pipeline.Prefilter.
+	Vectors map[string][]float64 `json:",omitempty"`
+	// Checksum of the chunk's stored content; see verify.go.
+	Sum string `json:",omitempty"`
 	// The grokker that this chunk belongs to.
 	// g *Grokker
 	// true if needs to be garbage collected
 	stale bool
 }
 
-// newChunk creates a new chunk given an offset, length, and text. It
-// computes the sha256 hash of the text if doc is not nil.  It does
-// not compute the embedding or add the chunk to the db.
-func newChunk(doc *Document, offset, length int, text string) (c *Chunk) {
-	var prefixedText string
+// newChunk creates a new chunk given a section, offset, length, and
+// text. It computes the sha256 hash of the text if doc is not nil.
+// It does not compute the embedding or add the chunk to the db.
+func newChunk(doc *Document, section string, offset, length int, text string) (c *Chunk) {
 	var hashStr string
 	if doc != nil {
-		prefixedText = fmt.Sprintf("from %s:\n%s\n", doc.RelPath, text)
-		hash := sha256.Sum256([]byte(prefixedText))
-		hashStr = hex.EncodeToString(hash[:])
+		hashStr = chunkHash(doc.RelPath, section, text)
 	}
 	c = &Chunk{
 		// g:        g,
 		Document: doc,
+		Section:  section,
 		Offset:   offset,
 		Length:   length,
 		Hash:     hashStr,
@@ -59,12 +83,20 @@ func newChunk(doc *Document, offset, length int, text string) (c *Chunk) {
 	return
 }
 
+// chunkHash returns the sha256 hash of a chunk's text with its
+// header, which is what gets embedded.
+func chunkHash(relpath, section, text string) string {
+	hash := sha256.Sum256([]byte(chunkHeader(relpath, section) + text + "\n"))
+	return hex.EncodeToString(hash[:])
+}
+
 // splitChunk recursively splits a Chunk into smaller chunks until
-// each chunk is no longer than the token limit.
-func (chunk *Chunk) splitChunk(g *Grokker, tokenLimit int) (newChunks []*Chunk, err error) {
+// each chunk is no longer than the token limit, as codec counts
+// tokens.
+func (chunk *Chunk) splitChunk(g *Grokker, codec tokenizer.Codec, tokenLimit int) (newChunks []*Chunk, err error) {
 	defer Return(&err)
 	// if the chunk is short enough, then we're done
-	tc, err := chunk.tokenCount(g)
+	tc, err := chunk.tokenCount(g, codec)
 	Ck(err)
 	Debug("chunk token count: %d, token limit: %d", tc, tokenLimit)
 	if tc < tokenLimit {
@@ -87,11 +119,12 @@ func (chunk *Chunk) splitChunk(g *Grokker, tokenLimit int) (newChunks []*Chunk,
 		var text string
 		text, err = g.chunkText(chunk, false, false)
 		Ck(err)
-		subChunk := newChunk(chunk.Document, docOffset, end-start, text[start:end])
+		subChunk := newChunk(chunk.Document, chunk.Section, docOffset, end-start, text[start:end])
+		subChunk.defs = chunk.defs
 		// recurse
 		Debug("splitting subChunk %d of %d ...", i+1, numChunks)
 		var newSubChunks []*Chunk
-		newSubChunks, err = subChunk.splitChunk(g, tokenLimit)
+		newSubChunks, err = subChunk.splitChunk(g, codec, tokenLimit)
 		Ck(err)
 		newChunks = append(newChunks, newSubChunks...)
 	}
@@ -107,9 +140,55 @@ func (g *Grokker) chunkText(c *Chunk, withHeader, withLineNumbers bool) (text st
 		return
 	}
 
-	// read the chunk from the document
+	rawText := c.Text
+	startLine := c.Line
+	if rawText == "" {
+		// read the chunk from the document
+		rawText, startLine, err = g.rawChunkText(c)
+		Ck(err)
+		if rawText == "" {
+			return
+		}
+	}
+	if withLineNumbers && startLine > 0 && !c.Document.Transformed {
+		// add line numbers to the text
+		chunkLines := strings.Split(rawText, "\n")
+		for i := startLine; i < startLine+len(chunkLines); i++ {
+			// get the text of the line
+			lineTxt := chunkLines[i-startLine]
+			// add the line number
+			text += fmt.Sprintf("%d: %s\n", i, lineTxt)
+		}
+	} else {
+		text = rawText
+	}
+	if withHeader {
+		text = chunkHeader(c.Document.RelPath, c.Section) + text + "\n"
+	}
+
+	// Debug("ChunkText: %q", text)
+	return
+}
+
+// chunkHeader returns the header of a chunk's text, which names its
+// document and, for markdown, its section; see mdchunk.go.
+func chunkHeader(relpath, section string) string {
+	if section == "" {
+		return fmt.Sprintf("from %s:\n", relpath)
+	}
+	return fmt.Sprintf("from %s (%s):\n", relpath, section)
+}
+
+// rawChunkText reads the text of a chunk from its document, and
+// returns it along with the line number the chunk starts on.  For
+// transformed content, the line is that of the chunk's section in
+// the original document, or 0 if it can't be known, e.g. for the
+// output of a hook.  It returns empty text if the document doesn't
+// exist.
+func (g *Grokker) rawChunkText(c *Chunk) (text string, startLine int, err error) {
+	defer Return(&err)
 	var buf []byte
-	buf, err = ioutil.ReadFile(g.absPath(c.Document))
+	buf, err = ioutil.ReadFile(g.contentPath(c.Document))
 	if os.IsNotExist(err) {
 		// document has been removed; don't remove it from the
 		// database, but don't return any text either.  The
@@ -127,31 +206,20 @@ func (g *Grokker) chunkText(c *Chunk, withHeader, withLineNumbers bool) (text st
 	if stop > len(buf) {
 		stop = len(buf)
 	}
-	rawText := string(buf[start:stop])
-	if withLineNumbers {
-		// count the lines before start
-		// XXX this is inefficient because it has to be done for
-		// every chunk.  it would be better to do it once for
-		// the whole document and store that in the db or at least
-		// cache it during a single grok run.
-		docLines := strings.Split(string(buf[:start]), "\n")
-		startLine := len(docLines)
-		// add line numbers to the text
-		chunkLines := strings.Split(rawText, "\n")
-		for i := startLine; i < startLine+len(chunkLines); i++ {
-			// get the text of the line
-			lineTxt := chunkLines[i-startLine]
-			// add the line number
-			text += fmt.Sprintf("%d: %s\n", i, lineTxt)
+	text = string(buf[start:stop])
+	// count the lines before start
+	// XXX this is inefficient because it has to be done for
+	// every chunk.  it would be better to do it once for
+	// the whole document and store that in the db or at least
+	// cache it during a single grok run.
+	docLines := strings.Split(string(buf[:start]), "\n")
+	startLine = len(docLines)
+	if c.Document.Transformed {
+		startLine = 0
+		if sec := c.Document.sectionAt(c.Offset); sec != nil {
+			startLine = sec.Line
 		}
-	} else {
-		text = rawText
 	}
-	if withHeader {
-		text = fmt.Sprintf("from %s:\n%s\n", c.Document.RelPath, text)
-	}
-
-	// Debug("ChunkText: %q", text)
 	return
 }
 
@@ -175,7 +243,7 @@ func splitIntoChunks(doc *Document, txt, delimiter string) (chunks []*Chunk) {
 		// if we found a delimiter, then create a chunk
 		// and add it to the list of chunks.
 		if t != "" {
-			chunk := newChunk(doc, start, len(t), t)
+			chunk := newChunk(doc, "", start, len(t), t)
 			chunks = append(chunks, chunk)
 			// start the next chunk after the delimiter
 			start = i + len(delimiter)
@@ -186,19 +254,27 @@ func splitIntoChunks(doc *Document, txt, delimiter string) (chunks []*Chunk) {
 	return
 }
 
-// similarChunks returns the most similar chunks to an embedding,
-// limited by tokenLimit.
-func (g *Grokker) similarChunks(embedding []float64, tokenLimit int, files []string) (chunks []*Chunk, err error) {
+// similarChunks returns the chunks in pool that are most similar to
+// the query embedding, limited by tokenLimit.
+func (g *Grokker) similarChunks(query string, embedding []float64, tokenLimit int, files []string, pool []*Chunk) (chunks []*Chunk, err error) {
 	defer Return(&err)
-	Debug("chunks in database: %d", len(g.Chunks))
+	Debug("chunks in database: %d, in pool: %d", len(g.Chunks), len(pool))
 	// Assert(tokenLimit > 100, tokenLimit)
 	// find the most similar chunks.
 	type Sim struct {
 		chunk *Chunk
 		score float64
 	}
-	sims := make([]Sim, 0, len(g.Chunks))
-	for _, chunk := range g.Chunks {
+	mentions := querySymbols(query)
+	boosts := make(map[string]float64)
+	for _, doc := range g.Documents {
+		if doc.Boost != 0 {
+			boosts[doc.RelPath] = doc.Boost
+		}
+	}
+	sims := make([]Sim, 0, len(pool))
+	g.topSimilarity = 0
+	for _, chunk := range pool {
 		// skip chunks from other files if files is not nil
 		if files != nil {
 			var found bool
@@ -212,60 +288,152 @@ func (g *Grokker) similarChunks(embedding []float64, tokenLimit int, files []str
 				continue
 			}
 		}
+		// skip chunks that are still waiting for an embedding,
+		// e.g. from a batch job, and boilerplate
+		if chunk.Embedding == nil || chunk.Excluded != "" {
+			continue
+		}
 		score := util.Similarity(embedding, chunk.Embedding)
+		if score > g.topSimilarity {
+			g.topSimilarity = score
+		}
+		// prefer chunks that contain symbols named in the query
+		for _, sym := range chunk.Symbols {
+			if mentions[strings.ToLower(sym)] {
+				score += symbolBoost
+				break
+			}
+		}
+		score += boosts[chunk.Document.RelPath]
 		sims = append(sims, Sim{chunk, score})
 	}
 	// sort the chunks by similarity.
 	sort.Slice(sims, func(i, j int) bool {
 		return sims[i].score > sims[j].score
 	})
-	// collect the top chunks until we pass the token limit
-	var totalTokens int
-	var bigChunks []*Chunk
-	for _, sim := range sims {
-		chunk := sim.chunk
-		tc, err := chunk.tokenCount(g)
+	// rescore the best candidates with the reranker, if any
+	if g.reranker != nil {
+		n := g.Pipeline.RerankK
+		if n <= 0 {
+			n = defaultRerankK
+		}
+		if n > len(sims) {
+			n = len(sims)
+		}
+		var passages []string
+		for _, sim := range sims[:n] {
+			text, err := g.chunkText(sim.chunk, true, false)
+			Ck(err)
+			passages = append(passages, text)
+		}
+		scores, err := g.reranker.score(query, passages)
 		Ck(err)
-		totalTokens += tc
-		bigChunks = append(bigChunks, chunk)
-		if totalTokens > tokenLimit {
-			break
+		for i := range scores {
+			sims[i].score = scores[i]
 		}
+		// the rest still have similarities, which can't be
+		// compared with the reranker's scores, so only the
+		// rescored candidates are packed
+		sims = sims[:n]
+		sort.SliceStable(sims, func(i, j int) bool {
+			return sims[i].score > sims[j].score
+		})
+	}
+	ranked := make([]*Chunk, len(sims))
+	scores := make(map[*Chunk]float64, len(sims))
+	for i, sim := range sims {
+		ranked[i] = sim.chunk
+		scores[sim.chunk] = sim.score
 	}
-	// split the big chunks so none are larger than the token limit.
-	// stop before we reach the token limit.
-	totalTokens = 0
-	for _, chunk := range bigChunks {
+	threshold := g.dedupThreshold()
+	ranked = diversify(ranked, g.Pipeline.MinSources, threshold)
+	// gather the candidates for packing, skipping near-duplicates
+	// of chunks already gathered, and splitting chunks so none are
+	// too large to pack
+	splitLimit := tokenLimit
+	if splitLimit > 2*packOverhead {
+		splitLimit -= packOverhead
+	}
+	var items []packItem
+	var kept [][]float64
+	var windowTokens int
+	for i, chunk := range ranked {
+		if windowTokens > tokenLimit*packWindow {
+			break
+		}
+		if nearDuplicate(chunk, kept, threshold) {
+			Debug("skipping near-duplicate chunk from %s", chunk.Document.RelPath)
+			continue
+		}
+		kept = append(kept, chunk.Embedding)
 		var subChunks []*Chunk
-		subChunks, err = chunk.splitChunk(g, tokenLimit)
+		subChunks, err = chunk.splitChunk(g, g.codec(), splitLimit)
 		Ck(err)
 		for _, subChunk := range subChunks {
-			tc, err := subChunk.tokenCount(g)
+			tc, err := subChunk.tokenCount(g, g.codec())
 			Ck(err)
-			totalTokens += tc
-			if totalTokens > tokenLimit {
-				break
-			}
-			chunks = append(chunks, subChunk)
-		}
-		if totalTokens > tokenLimit {
-			break
+			forced := g.Pipeline.MinSources > 1 && i < g.Pipeline.MinSources
+			items = append(items, packItem{subChunk, scores[chunk], tc, forced})
+			windowTokens += tc
 		}
 	}
+	chunks = pack(items, tokenLimit)
+	if g.maxChunks > 0 && len(chunks) > g.maxChunks {
+		chunks = chunks[:g.maxChunks]
+	}
+	g.chunkScores = make(map[*Chunk]float64, len(items))
+	for _, item := range items {
+		g.chunkScores[item.chunk] = item.score
+	}
 	Debug("sims len: %d", len(sims))
-	Debug("total tokens: %d", totalTokens)
+	Debug("packed %d of %d candidates, %d tokens", len(chunks), len(items), windowTokens)
 	Debug("found %d similar chunks", len(chunks))
 	return
 }
 
 // findChunks returns the most relevant chunks for a query, limited by tokenLimit.
 func (g *Grokker) findChunks(query string, tokenLimit int, files []string) (chunks []*Chunk, err error) {
+	defer Return(&err)
+	queryStrings, queryEmbedding, err := g.embedQuery(query)
+	Ck(err)
+	if queryEmbedding == nil {
+		return
+	}
+	// load the shards the filter's paths need, then apply the
+	// filter, routing the query to collections if needed.
+	err = g.LoadShards(g.filter.paths()...)
+	Ck(err)
+	filter, err := g.routeFilter(query, queryEmbedding)
+	Ck(err)
+	candidates := filter.apply(g, g.withBuffers(g.Chunks))
+	candidates, err = g.allowedChunks(candidates)
+	Ck(err)
+	candidates = includedChunks(candidates)
+	candidates = append(candidates, g.attached()...)
+	// correct misspelled symbols, and search for the corrected
+	// query; see correct.go
+	if fixed := g.correctQuery(query, queryEmbedding, candidates); fixed != query {
+		query = fixed
+		queryStrings, queryEmbedding, err = g.embedQuery(query)
+		Ck(err)
+	}
+	// narrow the search with the prefilter, if any.
+	pool, err := g.prefilterChunks(queryStrings, candidates)
+	Ck(err)
+	// find the most similar chunks.
+	chunks, err = g.similarChunks(query, queryEmbedding, tokenLimit, files, pool)
+	Ck(err)
+	return
+}
+
+// embedQuery returns the pieces a query is embedded in and their
+// mean embedding, or a nil embedding if the query is empty.
+func (g *Grokker) embedQuery(query string) (queryStrings []string, queryEmbedding []float64, err error) {
 	defer Return(&err)
 	// break the query into chunks.
-	queryChunks, err := g.chunksFromString(nil, query, g.EmbeddingTokenLimit)
+	queryChunks, err := g.chunksFromString(nil, query, Tokenizer, g.EmbeddingTokenLimit)
 	Ck(err)
 	// get the embeddings for the chunks.
-	var queryStrings []string
 	for _, chunk := range queryChunks {
 		queryStrings = append(queryStrings, chunk.text)
 	}
@@ -275,19 +443,16 @@ func (g *Grokker) findChunks(query string, tokenLimit int, files []string) (chun
 		return
 	}
 	// average the embeddings.
-	queryEmbedding := util.MeanVector(embeddings)
-	// find the most similar chunks.
-	chunks, err = g.similarChunks(queryEmbedding, tokenLimit, files)
-	Ck(err)
+	queryEmbedding = util.MeanVector(embeddings)
 	return
 }
 
-// stringsFromString splits a string into a slice of strings.  Each
-// string will be no longer than tokenLimit tokens.
+// stringsFromString splits a string into a slice of strings for
+// embedding.  Each string will be no longer than tokenLimit tokens.
 func (g *Grokker) stringsFromString(txt string, tokenLimit int) (strings []string, err error) {
 	defer Return(&err)
 	Assert(tokenLimit > 0)
-	chunks, err := g.chunksFromString(nil, txt, tokenLimit)
+	chunks, err := g.chunksFromString(nil, txt, Tokenizer, tokenLimit)
 	Ck(err)
 	for _, chunk := range chunks {
 		strings = append(strings, chunk.text)
@@ -298,31 +463,36 @@ func (g *Grokker) stringsFromString(txt string, tokenLimit int) (strings []strin
 // chunksFromString splits a string into a slice of Chunks.  If doc is
 // not nil, it is used to set the Document field of each chunk.  Each
 // chunk will be no longer than tokenLimit tokens.
-func (g *Grokker) chunksFromString(doc *Document, txt string, tokenLimit int) (chunks []*Chunk, err error) {
+func (g *Grokker) chunksFromString(doc *Document, txt string, codec tokenizer.Codec, tokenLimit int) (chunks []*Chunk, err error) {
 	defer Return(&err)
 	Assert(tokenLimit > 0)
 
-	/*
-		lang, _, _ := Ext2Lang(doc.RelPath)
-		switch lang {
-		case "go":
-			chunks = lang.SplitGo(doc, txt)
-		default:
-			// split the text into paragraphs
-			// XXX splitting on paragraphs is not ideal.  smarter splitting
-			// might look at the structure of the text and split on
-			// sections, chapters, etc.  it might also be useful to include
-			// metadata such as file names.
-			chunks = splitIntoChunks(doc, txt, "\n\n")
+	// markdown is split at headings, source files at definitions,
+	// and everything else at paragraphs
+	var lang string
+	if doc != nil {
+		lang = fileLang(doc.RelPath, txt)
+		if doc.Transformed && isHTML(doc.RelPath) {
+			// the HTML loader renders markdown
+			lang = "markdown"
 		}
-	*/
-	chunks = splitIntoChunks(doc, txt, "\n\n")
+	}
+	code := false
+	if lang != "" && lang != "markdown" {
+		chunks, code = splitCode(doc, txt, lang)
+	}
+	switch {
+	case lang == "markdown":
+		chunks = splitMarkdown(doc, txt)
+	case !code:
+		chunks = splitIntoChunks(doc, txt, "\n\n")
+	}
 
 	// ensure no chunk is longer than the token limit
 	var newChunks []*Chunk
 	for _, chunk := range chunks {
 		var subChunks []*Chunk
-		subChunks, err = chunk.splitChunk(g, tokenLimit)
+		subChunks, err = chunk.splitChunk(g, codec, tokenLimit)
 		Ck(err)
 		newChunks = append(newChunks, subChunks...)
 	}
@@ -334,15 +504,31 @@ func (g *Grokker) chunksFromString(doc *Document, txt string, tokenLimit int) (c
 // chunksFromDoc returns a slice containing the chunks for a document.
 func (g *Grokker) chunksFromDoc(doc *Document) (chunks []*Chunk, err error) {
 	defer Return(&err)
-	// read the document.
-	buf, err := ioutil.ReadFile(g.absPath(doc))
+	// run the index hooks, then read the content.
+	err = g.transform(doc)
 	Ck(err)
+	buf, err := ioutil.ReadFile(g.contentPath(doc))
+	Ck(err)
+	if doc.Transformed {
+		// the frontmatter is in the original
+		src, err := ioutil.ReadFile(g.absPath(doc))
+		Ck(err)
+		g.setMeta(doc, src)
+	} else {
+		g.setMeta(doc, buf)
+	}
+	g.setAuthors(doc)
 	// break the document up into chunks.
-	chunks, err = g.chunksFromString(doc, string(buf), g.EmbeddingTokenLimit)
+	chunks, err = g.chunksFromString(doc, string(buf), Tokenizer, g.EmbeddingTokenLimit)
 	Ck(err)
-	// add the document to each chunk.
+	// add the document to each chunk, and the section a loader
+	// rendered it from.
 	for _, chunk := range chunks {
 		chunk.Document = doc
+		if sec := doc.sectionAt(chunk.Offset); sec != nil && sec.Name != "" {
+			chunk.Section = sec.Name
+			chunk.Hash = chunkHash(doc.RelPath, sec.Name, chunk.text)
+		}
 	}
 	return
 }
@@ -360,6 +546,9 @@ func (g *Grokker) setChunk(chunk *Chunk) (newChunk *Chunk) {
 			foundChunk = c
 			foundChunk.Offset = chunk.Offset
 			foundChunk.Length = chunk.Length
+			foundChunk.Excluded = chunk.Excluded
+			foundChunk.Symbols = chunk.Symbols
+			foundChunk.Langs = chunk.Langs
 			foundChunk.stale = false
 		}
 	}
@@ -396,6 +585,13 @@ func (g *Grokker) gc() (err error) {
 	// replace the old chunks with the new chunks.
 	g.Chunks = keepChunks
 	newLen := len(g.Chunks)
+	// drop the prefilter embeddings of any prefilter no longer in
+	// use
+	spec := ""
+	if g.prefilter != nil {
+		spec = g.prefilter.spec()
+	}
+	g.dropVectors(spec)
 	Debug("garbage collected %d chunks from the database", oldLen-newLen)
 	return
 }
@@ -407,26 +603,124 @@ func (g *Grokker) getContext(query string, tokenLimit int, withHeaders, withLine
 	// get chunks, sorted by similarity to the query.
 	chunks, err := g.findChunks(query, tokenLimit, files)
 	Ck(err)
+	g.sources = nil
+	g.sourceIDs = nil
+	g.contextChunks = nil
+	g.injectionFlags = nil
+	summarized := make(map[string]bool)
 	for _, chunk := range chunks {
-		text, err := g.chunkText(chunk, withHeaders, withLineNumbers)
+		// look for prompt injection, if the pipeline says to; see
+		// injection.go
+		if g.Pipeline.InjectionCheck != "" && chunk.Document != nil {
+			flag, ok, err := g.checkChunk(chunk)
+			Ck(err)
+			if ok {
+				flag.Dropped = g.Pipeline.InjectionCheck == "drop"
+				g.injectionFlags = append(g.injectionFlags, flag)
+				if flag.Dropped {
+					continue
+				}
+			}
+		}
+		// use one summary in place of all of a short document's
+		// chunks, if the pipeline has a summarizer
+		if chunk.Document != nil && summarized[chunk.Document.RelPath] {
+			continue
+		}
+		summary, ok, err := g.docSummary(chunk.Document)
 		Ck(err)
+		var text string
+		if ok {
+			summarized[chunk.Document.RelPath] = true
+			text = Spf("summary of %s:\n%s\n", chunk.Document.RelPath, summary)
+		} else {
+			text, err = g.chunkText(chunk, withHeaders, withLineNumbers)
+			Ck(err)
+		}
 		context += text
+		cite, err := g.citation(chunk)
+		Ck(err)
+		g.contextChunks = append(g.contextChunks, contextPart{text: text, cite: cite})
+		if cite != "" && !util.StringInSlice(cite, g.sources) {
+			g.sources = append(g.sources, cite)
+		}
+		if chunk.Hash != "" {
+			g.sourceIDs = append(g.sourceIDs, chunk.Hash)
+		}
 	}
+	g.context = context
 	Debug("using %d chunks as context", len(chunks))
 	return
 }
 
-// tokenCount returns the number of tokens in a chunk, and caches the
-// result in the chunk.
-func (chunk *Chunk) tokenCount(g *Grokker) (count int, err error) {
+// TopSimilarity returns the highest similarity of any candidate
+// chunk to the most recent query, before symbol boosts and
+// reranking.  A low value means the knowledge base has little on the
+// question, so the answer is likely to be weak.
+func (g *Grokker) TopSimilarity() float64 {
+	return g.topSimilarity
+}
+
+// Sources returns the citations, as "relpath:line", for the context
+// used by the most recent query.
+func (g *Grokker) Sources() []string {
+	return g.sources
+}
+
+// citation returns "relpath:line" for a chunk, or an empty string if
+// the chunk isn't from a document.  If the line can't be known, as
+// for a transformed document without a section map, it returns
+// "relpath (section)", or just the relpath if there is no section.
+func (g *Grokker) citation(c *Chunk) (cite string, err error) {
 	defer Return(&err)
-	if chunk.tokenLength == 0 {
-		text, err := g.chunkText(chunk, false, false)
-		Ck(err)
-		tokens, err := g.tokens(text)
+	if c.Document == nil {
+		return
+	}
+	line := c.Line
+	if c.Text == "" {
+		_, line, err = g.rawChunkText(c)
 		Ck(err)
-		chunk.tokenLength = len(tokens)
 	}
-	count = chunk.tokenLength
+	switch {
+	case line > 0:
+		cite = Spf("%s:%d", c.Document.RelPath, line)
+	case c.Section != "":
+		cite = Spf("%s (%s)", c.Document.RelPath, c.Section)
+	default:
+		cite = c.Document.RelPath
+	}
+	return
+}
+
+// citePath returns the document path of a citation, e.g. "docs/a.md"
+// for "docs/a.md:12" or "docs/a.md (Install)".
+func citePath(cite string) string {
+	if i := strings.LastIndex(cite, " ("); i > 0 && strings.HasSuffix(cite, ")") {
+		return cite[:i]
+	}
+	i := strings.LastIndex(cite, ":")
+	if i < 0 || strings.Trim(cite[i+1:], "0123456789") != "" || i == len(cite)-1 {
+		return cite
+	}
+	return cite[:i]
+}
+
+// tokenCount returns the number of tokens in a chunk as codec counts
+// them.  The chat model's count is cached in the chunk.
+func (chunk *Chunk) tokenCount(g *Grokker, codec tokenizer.Codec) (count int, err error) {
+	defer Return(&err)
+	chat := codec == g.codec()
+	if chat && chunk.tokenLength > 0 {
+		count = chunk.tokenLength
+		return
+	}
+	text, err := g.chunkText(chunk, false, false)
+	Ck(err)
+	ids, _, err := codec.Encode(text)
+	Ck(err)
+	count = len(ids)
+	if chat {
+		chunk.tokenLength = count
+	}
 	return
 }
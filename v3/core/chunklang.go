@@ -0,0 +1,209 @@
+package core
+
+import (
+	"path"
+	"strings"
+	"unicode"
+)
+
+// We tag each chunk with its languages while indexing, so a query
+// can be limited to, say, the Go code and the markdown docs, keeping
+// vendored JavaScript out of answers about a Go backend.  The
+// programming language, or format, comes from the file name; prose
+// chunks are also tagged with their natural language, e.g. "en" or
+// "de", guessed from common words.  Chunks indexed before tagging
+// existed fall back to the file name until 'grok refresh'.
+
+// extLangs maps file extensions to language names.
+var extLangs = map[string]string{
+	"go":       "go",
+	"md":       "markdown",
+	"markdown": "markdown",
+	"txt":      "text",
+	"rst":      "rst",
+	"adoc":     "asciidoc",
+	"org":      "org",
+	"py":       "python",
+	"rb":       "ruby",
+	"rs":       "rust",
+	"js":       "javascript",
+	"mjs":      "javascript",
+	"cjs":      "javascript",
+	"jsx":      "javascript",
+	"ts":       "typescript",
+	"tsx":      "typescript",
+	"java":     "java",
+	"kt":       "kotlin",
+	"swift":    "swift",
+	"c":        "c",
+	"h":        "c",
+	"cc":       "cpp",
+	"cpp":      "cpp",
+	"cxx":      "cpp",
+	"hpp":      "cpp",
+	"cs":       "csharp",
+	"php":      "php",
+	"sh":       "shell",
+	"bash":     "shell",
+	"sql":      "sql",
+	"html":     "html",
+	"htm":      "html",
+	"css":      "css",
+	"json":     "json",
+	"yaml":     "yaml",
+	"yml":      "yaml",
+	"toml":     "toml",
+	"tf":       "terraform",
+	"proto":    "protobuf",
+}
+
+// nameLangs maps the names of files without a telling extension to
+// language names.
+var nameLangs = map[string]string{
+	"makefile":   "make",
+	"dockerfile": "dockerfile",
+	"readme":     "text",
+	"license":    "text",
+}
+
+// langAliases are other names users call the languages by.
+var langAliases = map[string]string{
+	"golang": "go",
+	"js":     "javascript",
+	"ts":     "typescript",
+	"py":     "python",
+	"c++":    "cpp",
+	"c#":     "csharp",
+}
+
+// proseLangs are the formats whose chunks get a natural language tag.
+var proseLangs = map[string]bool{
+	"markdown": true,
+	"text":     true,
+	"rst":      true,
+	"asciidoc": true,
+	"org":      true,
+}
+
+// stopWords are common words of each natural language we recognize.
+// Some words are shared; the language with the most hits wins.
+var stopWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "for", "with", "are", "this", "be", "on", "not", "you"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "mit", "den", "zu", "auf", "für", "sich", "auch", "dem"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "pour", "dans", "que", "qui", "pas", "sur", "avec", "du", "au"},
+	"es": {"el", "la", "los", "las", "y", "es", "que", "en", "una", "por", "para", "con", "del", "se", "no", "como"},
+	"it": {"il", "di", "che", "è", "la", "per", "una", "non", "sono", "con", "del", "della", "gli", "le", "un", "nel"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "voor", "met", "zijn", "ook", "worden", "naar"},
+	"pt": {"o", "os", "as", "que", "não", "uma", "para", "com", "por", "do", "da", "em", "é", "se", "um", "mais"},
+}
+
+// stopWordLangs maps each stop word to the languages it belongs to.
+var stopWordLangs = func() map[string][]string {
+	m := make(map[string][]string)
+	for lang, words := range stopWords {
+		for _, w := range words {
+			m[w] = append(m[w], lang)
+		}
+	}
+	return m
+}()
+
+// minNaturalHits is the fewest stop words a chunk needs before we
+// guess its natural language.
+const minNaturalHits = 5
+
+// fileLang returns the language of a document from its name and, if
+// the name doesn't tell, the shebang line of text.
+func fileLang(relpath, text string) string {
+	base := strings.ToLower(path.Base(relpath))
+	if lang, ok := nameLangs[base]; ok {
+		return lang
+	}
+	if i := strings.LastIndex(base, "."); i >= 0 {
+		if lang, ok := extLangs[base[i+1:]]; ok {
+			return lang
+		}
+	}
+	if strings.HasPrefix(text, "#!") {
+		line, _, _ := strings.Cut(text, "\n")
+		switch {
+		case strings.Contains(line, "python"):
+			return "python"
+		case strings.Contains(line, "ruby"):
+			return "ruby"
+		case strings.Contains(line, "node"):
+			return "javascript"
+		case strings.Contains(line, "sh"):
+			return "shell"
+		}
+	}
+	return ""
+}
+
+// naturalLang guesses the natural language of text, returning "" if
+// it can't tell.
+func naturalLang(text string) string {
+	hits := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		for _, lang := range stopWordLangs[w] {
+			hits[lang]++
+		}
+	}
+	best, bestN, secondN := "", 0, 0
+	for lang, n := range hits {
+		if n > bestN {
+			secondN = bestN
+			best, bestN = lang, n
+		} else if n > secondN {
+			secondN = n
+		}
+	}
+	if bestN < minNaturalHits || bestN == secondN {
+		return ""
+	}
+	return best
+}
+
+// chunkLangs returns the language tags of a chunk of a document in
+// the given language.
+func chunkLangs(lang, text string) (langs []string) {
+	if lang == "" {
+		return
+	}
+	langs = append(langs, lang)
+	if proseLangs[lang] {
+		if natural := naturalLang(text); natural != "" {
+			langs = append(langs, natural)
+		}
+	}
+	return
+}
+
+// NormalizeLang returns the name grokker tags chunks with for a
+// language given by a user, e.g. "markdown" for "md" or "go" for
+// "golang".
+func NormalizeLang(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if alias, ok := langAliases[lang]; ok {
+		return alias
+	}
+	if name, ok := extLangs[lang]; ok {
+		return name
+	}
+	return lang
+}
+
+// langs returns the language tags of the chunk, falling back to its
+// document's name for chunks indexed before tagging.
+func (c *Chunk) langs() []string {
+	if len(c.Langs) > 0 || c.Document == nil {
+		return c.Langs
+	}
+	if lang := fileLang(c.Document.RelPath, ""); lang != "" {
+		return []string{lang}
+	}
+	return nil
+}
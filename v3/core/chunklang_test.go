@@ -0,0 +1,71 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestChunkLangs(t *testing.T) {
+	Tassert(t, fileLang("cmd/main.go", "") == "go", "got %q", fileLang("cmd/main.go", ""))
+	Tassert(t, fileLang("vendor/lib.min.js", "") == "javascript", "got %q", fileLang("vendor/lib.min.js", ""))
+	Tassert(t, fileLang("Makefile", "") == "make", "got %q", fileLang("Makefile", ""))
+	Tassert(t, fileLang("bin/deploy", "#!/usr/bin/env python3\n") == "python", "got %q", fileLang("bin/deploy", "#!/usr/bin/env python3\n"))
+	Tassert(t, fileLang("bin/deploy", "") == "", "got %q", fileLang("bin/deploy", ""))
+
+	en := "The server reads the config file and starts the workers.  It is safe to restart it with the same flags."
+	de := "Der Server liest die Konfiguration und startet die Worker.  Es ist sicher, ihn mit den gleichen Optionen neu zu starten, auch wenn das nicht nötig ist."
+	Tassert(t, naturalLang(en) == "en", "got %q", naturalLang(en))
+	Tassert(t, naturalLang(de) == "de", "got %q", naturalLang(de))
+	Tassert(t, naturalLang("func main() {}") == "", "got %q", naturalLang("func main() {}"))
+	langs := chunkLangs("markdown", de)
+	Tassert(t, strings.Join(langs, ",") == "markdown,de", "got %v", langs)
+	langs = chunkLangs("go", en)
+	Tassert(t, strings.Join(langs, ",") == "go", "got %v", langs)
+
+	Tassert(t, NormalizeLang("md") == "markdown" && NormalizeLang("Golang") == "go" && NormalizeLang("de") == "de", "unexpected normalization")
+}
+
+func TestLangFilter(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	// the fake embeddings are all alike; keep them
+	grok.Pipeline.DedupThreshold = 2
+	files := map[string]string{
+		"server.go":        "package main\n\nfunc serve() {}\n",
+		"vendor/widget.js": "function serve() { return 1; }\n",
+		"docs/guide.md":    "# Guide\n\nThe server is started with the serve command, and it is stopped with the stop command.\n",
+	}
+	for fn, content := range files {
+		path := filepath.Join(dir, fn)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		Ck(err)
+		err = os.WriteFile(path, []byte(content), 0644)
+		Ck(err)
+		err = grok.AddDocument(path)
+		Ck(err)
+	}
+	context := func(langs ...string) string {
+		grok.SetFilter(&Filter{Langs: langs})
+		defer grok.SetFilter(nil)
+		_, err := grok.Context("serve", 1000, false, false)
+		Ck(err)
+		return strings.Join(grok.Sources(), " ")
+	}
+	srcs := context("go", "md")
+	Tassert(t, strings.Contains(srcs, "server.go") && strings.Contains(srcs, "docs/guide.md") && !strings.Contains(srcs, "widget.js"), "unexpected sources %q", srcs)
+	srcs = context("en")
+	Tassert(t, strings.HasPrefix(srcs, "docs/guide.md") && !strings.Contains(srcs, ".go") && !strings.Contains(srcs, ".js"), "unexpected sources %q", srcs)
+
+	// chunks indexed before tagging fall back to the file name
+	for _, c := range grok.Chunks {
+		c.Langs = nil
+	}
+	srcs = context("javascript")
+	Tassert(t, strings.HasPrefix(srcs, "vendor/widget.js") && !strings.Contains(srcs, " "), "unexpected sources %q", srcs)
+}
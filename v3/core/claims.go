@@ -0,0 +1,117 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// With answer checking on, a second request asks the model to split
+// the answer into claims and check each one against the context the
+// answer was built from.  Answer then appends a note that says how
+// many claims the sources support and lists the ones they don't, so
+// readers know which statements to double-check.  It costs one more
+// request, so it's off unless turned on with SetCheckAnswers.
+
+const claimsSysmsg = `You check answers against their sources.  Given some context and an answer based on it, split the answer into its factual claims and decide whether the context supports each one.  Reply with only a JSON array, e.g.
+[{"claim": "X defaults to 5.", "supported": true, "source": "config.go:12"},
+ {"claim": "Y is deprecated.", "supported": false}]
+where source is the "from" path of the supporting context, with a line number if one is shown.  Leave out opinions, advice, and restatements of the question.`
+
+// ClaimCheck is the verdict on one claim in an answer.
+type ClaimCheck struct {
+	Claim     string `json:"claim"`
+	Supported bool   `json:"supported"`
+	Source    string `json:"source,omitempty"`
+}
+
+// SetCheckAnswers turns answer checking on or off for subsequent
+// calls to Answer.  See ClaimChecks.
+func (g *Grokker) SetCheckAnswers(on bool) {
+	g.checkAnswers = on
+}
+
+// ClaimChecks returns the verdicts on the claims in the most recent
+// answer, if it was checked.
+func (g *Grokker) ClaimChecks() []ClaimCheck {
+	return g.claimChecks
+}
+
+// checkClaims checks the claims in an answer against the context of
+// the most recent query, and returns the answer with a note
+// appended.
+func (g *Grokker) checkClaims(answer string) (out string, err error) {
+	defer Return(&err)
+	g.claimChecks = nil
+	if !g.checkAnswers && !g.Pipeline.Verify {
+		return answer, nil
+	}
+	input := Spf("Context:\n\n%s\n\nAnswer:\n\n%s", g.context, answer)
+	resp, err := g.msg(claimsSysmsg, input)
+	Ck(err)
+	g.claimChecks, err = parseClaimChecks(resp.Choices[0].Message.Content)
+	Ck(err)
+	out = strings.TrimRight(answer, "\n") + "\n\n" + claimsNote(g.claimChecks)
+	return
+}
+
+// parseClaimChecks parses the model's verdicts, which may be wrapped
+// in a markdown code block.
+func parseClaimChecks(resp string) (checks []ClaimCheck, err error) {
+	start := strings.Index(resp, "[")
+	end := strings.LastIndex(resp, "]")
+	if start < 0 || end < start {
+		err = fmt.Errorf("expected a JSON array of claims, got %q", resp)
+		return
+	}
+	err = json.Unmarshal([]byte(resp[start:end+1]), &checks)
+	if err != nil {
+		err = fmt.Errorf("can't parse claims %q: %v", resp, err)
+	}
+	return
+}
+
+// claimsNote returns the note appended to a checked answer.
+func claimsNote(checks []ClaimCheck) string {
+	var unsupported []string
+	for _, c := range checks {
+		if !c.Supported {
+			unsupported = append(unsupported, c.Claim)
+		}
+	}
+	if len(unsupported) == 0 {
+		return Spf("Verified: the sources support all %d claims in this answer.\n", len(checks))
+	}
+	note := Spf("Unsupported: the sources support %d of %d claims in this answer.  Not supported:\n", len(checks)-len(unsupported), len(checks))
+	for _, claim := range unsupported {
+		note += "- " + claim + "\n"
+	}
+	return note
+}
+
+// LowConfidence returns why the answer to the most recent question is
+// low-confidence, or "" if it isn't: it has no sources, it was
+// checked and some of its claims aren't supported, or no chunk is at
+// least threshold similar to the question; see TopSimilarity.  Good
+// thresholds depend on the embedding model, so a threshold of 0
+// skips the last test.
+func (g *Grokker) LowConfidence(threshold float64) string {
+	if len(g.Sources()) == 0 {
+		return "no sources"
+	}
+	unsupported := 0
+	for _, c := range g.ClaimChecks() {
+		if !c.Supported {
+			unsupported++
+		}
+	}
+	if unsupported > 0 {
+		return Spf("%d of %d claims unsupported", unsupported, len(g.ClaimChecks()))
+	}
+	if threshold > 0 && g.TopSimilarity() < threshold {
+		return Spf("best source similarity %.3f is below %.3f", g.TopSimilarity(), threshold)
+	}
+	return ""
+}
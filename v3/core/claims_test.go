@@ -0,0 +1,28 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestClaims(t *testing.T) {
+	resp := "```json\n[{\"claim\": \"X defaults to 5.\", \"supported\": true, \"source\": \"config.go:12\"},\n {\"claim\": \"Y is deprecated.\", \"supported\": false}]\n```"
+	checks, err := parseClaimChecks(resp)
+	Tassert(t, err == nil, "error parsing claims: %v", err)
+	Tassert(t, len(checks) == 2 && checks[0].Supported && checks[0].Source == "config.go:12" && !checks[1].Supported, "unexpected checks %+v", checks)
+	_, err = parseClaimChecks("I can't do that.")
+	Tassert(t, err != nil, "expected error for a response without claims")
+
+	note := claimsNote(checks)
+	Tassert(t, strings.HasPrefix(note, "Unsupported: the sources support 1 of 2 claims") && strings.Contains(note, "- Y is deprecated.\n"), "unexpected note %q", note)
+	note = claimsNote(checks[:1])
+	Tassert(t, strings.HasPrefix(note, "Verified:"), "unexpected note %q", note)
+
+	// checking is off by default and costs no request
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	out, err := grok.checkClaims("an answer")
+	Tassert(t, err == nil && out == "an answer" && grok.ClaimChecks() == nil, "unexpected result %q %v", out, err)
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Command hooks fit grok into existing automation without wrapper
+// scripts: pre hooks run before a subcommand, e.g. to generate docs
+// before 'grok refresh', and post hooks run after it succeeds, with
+// its output on stdin, e.g. to post an answer to a chat channel.
+// They are set in the "commands:" section of config.yaml, keyed by
+// subcommand:
+//
+//	commands:
+//	  refresh:
+//	    pre: [make generate-docs]
+//	  q:
+//	    post: ["curl -s -d @- https://hooks.example.com/answers"]
+//	  db use:
+//	    post: [notify-send "switched knowledge base"]
+//
+// The hooks for a subcommand's first word, e.g. "db", apply to all of
+// its subcommands, and run before those for the whole subcommand.
+// Each hook is a command line, split as by util.Command, run in the
+// current directory with GROKKER_COMMAND set to the subcommand.  A
+// hook's stdout goes to stderr, as with git hooks, so it doesn't mix
+// with grok's output.  If a pre hook fails, the subcommand doesn't
+// run; if a post hook fails, grok fails.  Unlike index hooks, command
+// hooks belong to the user, not the knowledge base.
+
+// CommandHooks are the hooks for a subcommand.
+type CommandHooks struct {
+	Pre  []string `yaml:"pre"`
+	Post []string `yaml:"post"`
+}
+
+// commandWords returns the words that name a subcommand, as kong
+// prints it, e.g. "db use" for "db use <name>".
+func commandWords(cmd string) (words []string) {
+	for _, word := range strings.Fields(cmd) {
+		if !strings.HasPrefix(word, "<") {
+			words = append(words, word)
+		}
+	}
+	return
+}
+
+// Hooks returns the hooks for a subcommand, as kong prints it, e.g.
+// "q <question>".
+func (cfg *Config) Hooks(cmd string) (pre, post []string) {
+	words := commandWords(cmd)
+	if len(words) == 0 {
+		return
+	}
+	keys := []string{words[0]}
+	if len(words) > 1 {
+		keys = append(keys, strings.Join(words, " "))
+	}
+	for _, key := range keys {
+		h := cfg.Commands[key]
+		if h == nil {
+			continue
+		}
+		pre = append(pre, h.Pre...)
+		post = append(post, h.Post...)
+	}
+	return
+}
+
+// RunCommandHook runs a hook for a subcommand, with input on stdin,
+// sending its stdout and stderr to stderr.
+func RunCommandHook(hook, cmd string, input []byte, stderr io.Writer) (err error) {
+	c, err := util.Command(hook)
+	if err != nil {
+		return fmt.Errorf("hook %q: %v", hook, err)
+	}
+	c.Env = append(os.Environ(), "GROKKER_COMMAND="+strings.Join(commandWords(cmd), " "))
+	c.Stdin = bytes.NewReader(input)
+	c.Stdout = stderr
+	c.Stderr = stderr
+	err = c.Run()
+	if err != nil {
+		return fmt.Errorf("%s hook %q: %v", strings.Join(commandWords(cmd), " "), hook, err)
+	}
+	return
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestCommandHooks(t *testing.T) {
+	cfg := &Config{Commands: map[string]*CommandHooks{
+		"db":     {Pre: []string{"a"}},
+		"db use": {Pre: []string{"b"}, Post: []string{"c"}},
+		"q":      {Post: []string{"d"}},
+	}}
+	pre, post := cfg.Hooks("db use <name>")
+	Tassert(t, fmt.Sprint(pre, post) == "[a b] [c]", "unexpected hooks %v %v", pre, post)
+	pre, post = cfg.Hooks("q <question>")
+	Tassert(t, fmt.Sprint(pre, post) == "[] [d]", "unexpected hooks %v %v", pre, post)
+	pre, post = (&Config{}).Hooks("refresh")
+	Tassert(t, len(pre)+len(post) == 0, "unexpected hooks %v %v", pre, post)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell command")
+	}
+	var stderr bytes.Buffer
+	err := RunCommandHook(`sh -c 'echo "$GROKKER_COMMAND: $(cat)"'`, "db use <name>", []byte("answer"), &stderr)
+	Tassert(t, err == nil, "unexpected error: %v", err)
+	Tassert(t, stderr.String() == "db use: answer\n", "unexpected output %q", stderr.String())
+	err = RunCommandHook("false", "refresh", nil, &stderr)
+	Tassert(t, err != nil, "expected an error from a failing hook")
+}
@@ -0,0 +1,252 @@
+package core
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Source files are chunked at their definitions rather than at blank
+// lines, so a function or type, with its doc comment, is one chunk
+// unless it is longer than the embedding model's token limit.  Go
+// is parsed; the other languages util.Ext2Lang knows -- Python,
+// Ruby, and Rust -- are split where a line starts a definition, and
+// so is Go that doesn't parse.  The comments, decorators, and
+// attributes right above a definition go with it.  Whatever comes
+// before the first definition, e.g. the package clause and imports,
+// is a chunk of its own.
+//
+// A code chunk's Section names what it defines, e.g.
+// "Grokker.Retrieve", so the name is in the header that is embedded
+// and sent as context, and the names are added to the chunk's
+// Symbols, so a question that names a function finds it.
+
+// maxSectionDefs is the most definitions a code chunk's Section
+// names; a const block may define dozens.
+const maxSectionDefs = 4
+
+// defPattern finds the definitions of a language.
+type defPattern struct {
+	// def matches a line that starts a definition; its first group
+	// is the name.
+	def *regexp.Regexp
+	// attached are the prefixes of the lines above a definition
+	// that go with it, e.g. comments and decorators.
+	attached []string
+}
+
+// defPatterns maps languages to their definition patterns.
+// Definitions can be indented a little, so methods in a class or an
+// impl block are chunks of their own, but not nested functions.
+var defPatterns = map[string]defPattern{
+	"go": {
+		def:      regexp.MustCompile(`^(?:func[ \t]+(?:\([^)]*\)[ \t]*)?|type[ \t]+)([A-Za-z_][A-Za-z0-9_]*)`),
+		attached: []string{"//"},
+	},
+	"python": {
+		def:      regexp.MustCompile(`^[ \t]{0,4}(?:async[ \t]+)?(?:def|class)[ \t]+([A-Za-z_][A-Za-z0-9_]*)`),
+		attached: []string{"#", "@"},
+	},
+	"ruby": {
+		def:      regexp.MustCompile(`^[ \t]{0,4}(?:def|class|module)[ \t]+(?:self\.)?([A-Za-z_][A-Za-z0-9_:]*[?!=]?)`),
+		attached: []string{"#"},
+	},
+	"rust": {
+		def:      regexp.MustCompile(`^[ \t]{0,4}(?:pub(?:\([^)]*\))?[ \t]+)?(?:(?:async|const|unsafe|extern(?:[ \t]+"[^"]*")?)[ \t]+)*(?:fn|struct|enum|trait|mod|type|union)[ \t]+([A-Za-z_][A-Za-z0-9_]*)`),
+		attached: []string{"//", "#["},
+	},
+}
+
+// splitCode splits a source file in the given language into chunks
+// at its definitions, and returns false if it doesn't know the
+// language.  The chunks cover all of the text.
+func splitCode(doc *Document, txt, lang string) (chunks []*Chunk, ok bool) {
+	var starts []int
+	var defs [][]string
+	if lang == "go" {
+		starts, defs, ok = goDefs(txt)
+	}
+	if !ok {
+		var pat defPattern
+		pat, ok = defPatterns[lang]
+		if !ok {
+			return
+		}
+		starts, defs = pat.find(txt)
+	}
+	prev := 0
+	var names []string
+	add := func(end int) {
+		if end > prev {
+			section := names
+			if len(section) > maxSectionDefs {
+				section = append(section[:maxSectionDefs:maxSectionDefs], "...")
+			}
+			c := newChunk(doc, strings.Join(section, ", "), prev, end-prev, txt[prev:end])
+			c.defs = defSymbols(names)
+			chunks = append(chunks, c)
+		}
+		prev = end
+	}
+	for i, start := range starts {
+		add(start)
+		names = defs[i]
+	}
+	add(len(txt))
+	return
+}
+
+// goDefs returns the offsets at which Go source's top-level
+// declarations start, with their doc comments, and the names each
+// one defines.  Imports stay with the package clause.  It returns
+// false if the source doesn't parse.
+func goDefs(txt string) (starts []int, defs [][]string, ok bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", txt, parser.ParseComments)
+	if err != nil {
+		return
+	}
+	ok = true
+	for _, decl := range f.Decls {
+		var names []string
+		pos := decl.Pos()
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				pos = d.Doc.Pos()
+			}
+			name := d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				if recv := recvName(d.Recv.List[0].Type); recv != "" {
+					name = recv + "." + name
+				}
+			}
+			names = append(names, name)
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			if d.Doc != nil {
+				pos = d.Doc.Pos()
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, id := range s.Names {
+						if id.Name != "_" {
+							names = append(names, id.Name)
+						}
+					}
+				}
+			}
+		}
+		start := fset.Position(pos).Offset
+		// start at the beginning of the line
+		start = strings.LastIndex(txt[:start], "\n") + 1
+		if len(starts) > 0 && start <= starts[len(starts)-1] {
+			// e.g. two declarations on one line
+			defs[len(defs)-1] = append(defs[len(defs)-1], names...)
+			continue
+		}
+		starts = append(starts, start)
+		defs = append(defs, names)
+	}
+	return
+}
+
+// recvName returns the name of a method receiver's type, e.g.
+// "Grokker" for "*Grokker" or "List" for "List[T]".
+func recvName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return recvName(t.X)
+	case *ast.IndexExpr:
+		return recvName(t.X)
+	case *ast.IndexListExpr:
+		return recvName(t.X)
+	case *ast.ParenExpr:
+		return recvName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// find returns the offsets at which the definitions in txt start,
+// with the lines attached above them, and the name each defines.
+func (pat defPattern) find(txt string) (starts []int, defs [][]string) {
+	offset := 0
+	// the start of the run of attached lines we're in, or -1
+	attached := -1
+	for _, line := range strings.SplitAfter(txt, "\n") {
+		lineStart := offset
+		offset += len(line)
+		if m := pat.def.FindStringSubmatch(line); m != nil {
+			start := lineStart
+			if attached >= 0 {
+				start = attached
+			}
+			starts = append(starts, start)
+			defs = append(defs, []string{m[1]})
+			attached = -1
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		isAttached := false
+		for _, prefix := range pat.attached {
+			if strings.HasPrefix(trimmed, prefix) {
+				isAttached = true
+				break
+			}
+		}
+		switch {
+		case !isAttached:
+			attached = -1
+		case attached < 0:
+			attached = lineStart
+		}
+	}
+	return
+}
+
+// defSymbols returns the symbols for the names a code chunk defines:
+// each name, and for a method, e.g. "Grokker.Retrieve", its bare
+// name too.
+func defSymbols(names []string) (symbols []string) {
+	for _, name := range names {
+		symbols = append(symbols, name)
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			symbols = append(symbols, name[i+1:])
+		}
+	}
+	return
+}
+
+// chunkSymbols returns the symbols of a chunk: the names it
+// defines, if it is a code chunk, and those extractSymbols finds in
+// its text.
+func chunkSymbols(c *Chunk) (symbols []string) {
+	symbols = extractSymbols(c.text)
+	if len(c.defs) == 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	var merged []string
+	for _, sym := range append(append([]string{}, c.defs...), symbols...) {
+		if !seen[sym] {
+			seen[sym] = true
+			merged = append(merged, sym)
+		}
+	}
+	if len(merged) > maxSymbols {
+		// the definitions come first, so they are kept
+		merged = merged[:maxSymbols]
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+func TestSplitCode(t *testing.T) {
+	// sections returns the section of each chunk, checking that the
+	// chunks cover the text
+	sections := func(relpath, txt string) string {
+		doc := &Document{RelPath: relpath}
+		chunks, ok := splitCode(doc, txt, fileLang(relpath, txt))
+		Tassert(t, ok, "%s: expected a code split", relpath)
+		var got []string
+		var all string
+		for _, c := range chunks {
+			got = append(got, c.Section)
+			all += c.text
+		}
+		Tassert(t, all == txt, "%s: the chunks don't cover the text: %q", relpath, all)
+		return strings.Join(got, "|")
+	}
+
+	gosrc := `package widgets
+
+import "fmt"
+
+// Max is the most widgets.
+const (
+	Max = 10
+	min = 1
+)
+
+// Widget is a widget.
+type Widget struct{}
+
+// Paint paints a widget.
+//
+// It has a blank line in it.
+
+func (w *Widget) Paint(color string) {
+
+	fmt.Println(color)
+}
+
+func New() *Widget { return &Widget{} }
+`
+	got := sections("widgets.go", gosrc)
+	Tassert(t, got == "|Max, min|Widget|Widget.Paint|New", "got %q", got)
+	chunks, _ := splitCode(&Document{RelPath: "widgets.go"}, gosrc, "go")
+	paint := chunks[3]
+	Tassert(t, strings.HasPrefix(paint.text, "func (w *Widget) Paint"), "got %q", paint.text)
+	syms := chunkSymbols(paint)
+	Tassert(t, util.StringInSlice("Paint", syms) && util.StringInSlice("Widget.Paint", syms), "got %v", syms)
+
+	// Go that doesn't parse is split by pattern
+	got = sections("broken.go", "package x\n\n// F does things.\nfunc F() {\n\n}\nfunc G( {\n")
+	Tassert(t, got == "|F|G", "got %q", got)
+
+	pysrc := "import os\n\n# A widget.\n@dataclass\nclass Widget:\n    x: int\n\n    def paint(self):\n        def inner():\n            pass\n\nasync def main():\n    pass\n"
+	got = sections("widgets.py", pysrc)
+	Tassert(t, got == "|Widget|paint|main", "got %q", got)
+
+	rssrc := "use std::fmt;\n\n/// A widget.\n#[derive(Debug)]\npub struct Widget {}\n\nimpl Widget {\n    pub fn paint(&self) {}\n}\n"
+	got = sections("widgets.rs", rssrc)
+	Tassert(t, got == "|Widget|paint", "got %q", got)
+
+	_, ok := splitCode(&Document{RelPath: "notes.txt"}, "text\n", "text")
+	Tassert(t, !ok, "split a text file as code")
+}
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Collections group documents, e.g. "docs", "code", and "tickets",
+// so a query can search only the ones that are relevant.  Documents
+// that were added without a collection are in DefaultCollection.
+
+// DefaultCollection is the collection of documents added without
+// one.
+const DefaultCollection = "default"
+
+// maxRoutes is the most collections the router picks for a query.
+const maxRoutes = 2
+
+// routeMargin is how far below the best collection's score another
+// collection can be and still be picked by the embedding router.
+const routeMargin = 0.02
+
+// CollectionInfo describes a collection.
+type CollectionInfo struct {
+	Name      string
+	Documents int
+}
+
+func (c CollectionInfo) String() string {
+	return Spf("%-20s %d documents", c.Name, c.Documents)
+}
+
+// collection returns the name of the document's collection.
+func (doc *Document) collection() string {
+	if doc.Collection == "" {
+		return DefaultCollection
+	}
+	return doc.Collection
+}
+
+// docCollections maps document paths to collection names.
+func (g *Grokker) docCollections() (colls map[string]string) {
+	colls = make(map[string]string, len(g.Documents))
+	for _, doc := range g.Documents {
+		colls[doc.RelPath] = doc.collection()
+	}
+	return
+}
+
+// Collections returns the collections in the database, sorted by
+// name.
+func (g *Grokker) Collections() (infos []CollectionInfo) {
+	counts := make(map[string]int)
+	for _, doc := range g.Documents {
+		counts[doc.collection()]++
+	}
+	for name, n := range counts {
+		infos = append(infos, CollectionInfo{name, n})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return
+}
+
+// DocumentCollection returns the collection of the document with the
+// given relative path, and false if there is no such document.
+func (g *Grokker) DocumentCollection(relpath string) (collection string, ok bool) {
+	for _, doc := range g.Documents {
+		if doc.RelPath == relpath {
+			return doc.collection(), true
+		}
+	}
+	if _, ok = g.attachments[relpath]; ok {
+		collection = SessionCollection
+	}
+	return
+}
+
+// SetCollection moves a document to the named collection.
+func (g *Grokker) SetCollection(path, collection string) (err error) {
+	defer Return(&err)
+	if collection == DefaultCollection {
+		collection = ""
+	}
+	for _, doc := range g.Documents {
+		if doc.RelPath == path {
+			doc.Collection = collection
+			return
+		}
+	}
+	absPath, err := filepath.Abs(path)
+	Ck(err)
+	for _, doc := range g.Documents {
+		if g.absPath(doc) == absPath {
+			doc.Collection = collection
+			return
+		}
+	}
+	err = fmt.Errorf("%s is not in the knowledge base", path)
+	return
+}
+
+// routeFilter returns the filter for a query.  If the user didn't
+// pick collections and the pipeline has a router, the router picks
+// the collections most likely to answer the query.
+func (g *Grokker) routeFilter(query string, embedding []float64) (f *Filter, err error) {
+	defer Return(&err)
+	f = g.filter
+	if f != nil && len(f.Collections) > 0 {
+		return
+	}
+	infos := g.Collections()
+	if len(infos) < 2 {
+		return
+	}
+	var picks []string
+	switch g.Pipeline.Router {
+	case "":
+		return
+	case "embedding":
+		picks = g.routeByEmbedding(embedding)
+	case "llm":
+		picks, err = g.routeByLLM(query, infos)
+		Ck(err)
+	default:
+		err = fmt.Errorf("unknown router %q; use embedding or llm", g.Pipeline.Router)
+		return
+	}
+	if len(picks) == 0 {
+		return
+	}
+	Debug("routing query to collections %v", picks)
+	routed := Filter{}
+	if f != nil {
+		routed = *f
+	}
+	routed.Collections = picks
+	f = &routed
+	return
+}
+
+// routeByEmbedding picks the collections whose centroid, the mean
+// of their chunk embeddings, is closest to the query.
+func (g *Grokker) routeByEmbedding(embedding []float64) (picks []string) {
+	colls := g.docCollections()
+	members := make(map[string][][]float64)
+	for _, c := range g.Chunks {
+		if c.Embedding == nil || c.Excluded != "" {
+			continue
+		}
+		name := colls[c.Document.RelPath]
+		members[name] = append(members[name], c.Embedding)
+	}
+	type score struct {
+		name string
+		sim  float64
+	}
+	var scores []score
+	for name, vecs := range members {
+		scores = append(scores, score{name, util.Similarity(embedding, util.MeanVector(vecs))})
+	}
+	if len(scores) == 0 {
+		return
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].sim > scores[j].sim
+	})
+	for _, s := range scores {
+		if len(picks) >= maxRoutes || s.sim < scores[0].sim-routeMargin {
+			break
+		}
+		picks = append(picks, s.name)
+	}
+	return
+}
+
+// routeByLLM asks the chat model which collections are most likely
+// to answer the query, showing it a sample of each collection's
+// document paths.
+func (g *Grokker) routeByLLM(query string, infos []CollectionInfo) (picks []string, err error) {
+	defer Return(&err)
+	samples := make(map[string][]string)
+	for _, doc := range g.Documents {
+		name := doc.collection()
+		if len(samples[name]) < 10 {
+			samples[name] = append(samples[name], doc.RelPath)
+		}
+	}
+	var desc strings.Builder
+	for _, info := range infos {
+		desc.WriteString(Spf("%s: %s\n", info.Name, strings.Join(samples[info.Name], ", ")))
+	}
+	sysmsg := "You route questions to document collections.  Reply with the names of the one or two collections most likely to answer the question, separated by commas, and nothing else."
+	input := Spf("Collections and some of their documents:\n%s\nQuestion: %s", desc.String(), query)
+	resp, err := g.msg(sysmsg, input)
+	Ck(err)
+	known := make(map[string]bool)
+	for _, info := range infos {
+		known[info.Name] = true
+	}
+	for _, name := range strings.Split(resp.Choices[0].Message.Content, ",") {
+		name = strings.Trim(strings.TrimSpace(name), "`'\".")
+		if known[name] && len(picks) < maxRoutes {
+			picks = append(picks, name)
+		}
+	}
+	return
+}
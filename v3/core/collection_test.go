@@ -0,0 +1,47 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestCollections(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	a := &Document{RelPath: "a.md", Collection: "docs"}
+	b := &Document{RelPath: "b.go"}
+	grok.Documents = []*Document{a, b}
+	grok.Chunks = []*Chunk{
+		{Document: a, Hash: "a1", Embedding: []float64{1, 0}},
+		{Document: a, Hash: "a2", Embedding: []float64{0.9, 0.1}},
+		{Document: b, Hash: "b1", Embedding: []float64{0, 1}},
+	}
+	infos := grok.Collections()
+	Tassert(t, len(infos) == 2 && infos[0].Name == "default" && infos[1].Name == "docs", "unexpected collections %v", infos)
+
+	err = grok.SetCollection("b.go", "code")
+	Tassert(t, err == nil, "error setting collection: %v", err)
+	Tassert(t, b.Collection == "code", "collection not set")
+	err = grok.SetCollection("nope.go", "code")
+	Tassert(t, err != nil, "expected error for unknown document")
+
+	// no router, no filter
+	f, err := grok.routeFilter("q", []float64{1, 0})
+	Tassert(t, err == nil && f == nil, "unexpected filter %v %v", f, err)
+
+	// the embedding router picks the closest collection
+	grok.Pipeline.Router = "embedding"
+	f, err = grok.routeFilter("q", []float64{1, 0})
+	Tassert(t, err == nil, "error routing: %v", err)
+	Tassert(t, len(f.Collections) == 1 && f.Collections[0] == "docs", "unexpected route %v", f.Collections)
+	chunks := f.apply(grok, grok.Chunks)
+	Tassert(t, len(chunks) == 2, "expected 2 chunks, got %d", len(chunks))
+
+	// an explicit collection wins
+	grok.SetFilter(&Filter{Collections: []string{"code"}})
+	f, err = grok.routeFilter("q", []float64{1, 0})
+	Tassert(t, err == nil, "error routing: %v", err)
+	chunks = f.apply(grok, grok.Chunks)
+	Tassert(t, len(chunks) == 1 && chunks[0].Hash == "b1", "unexpected chunks %v", chunks)
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"gopkg.in/yaml.v3"
+)
+
+// 'grok compare' runs the same questions against the knowledge base
+// under two or more configurations and reports the answers side by
+// side, so a tuning decision can be justified with numbers.  A
+// configuration is a small YAML file:
+//
+//	model: gpt-4o
+//	persona: sre
+//	pipeline_file: precise
+//	pipeline:
+//	  reranker: onnx:/models/ms-marco-MiniLM-L-6-v2
+//	  rerankk: 50
+//
+// Settings that are left out keep their values from the database.
+// Pipeline settings take the same names and values as 'grok pipeline
+// set', and override those of the pipeline file, if any.  Nothing is
+// saved.
+
+// CompareConfig is a configuration to compare.
+type CompareConfig struct {
+	// Name identifies the configuration in the report; it defaults
+	// to the file's base name.
+	Name     string            `yaml:"name"`
+	Model    string            `yaml:"model"`
+	Persona  string            `yaml:"persona"`
+	Pipeline map[string]string `yaml:"pipeline"`
+	// PipelineFile names a pipeline file to start from; see
+	// pipelinefile.go.  Pipeline settings are applied after it.
+	PipelineFile string `yaml:"pipeline_file"`
+}
+
+// LoadCompareConfig reads a configuration file for Compare.
+func LoadCompareConfig(path string) (cfg *CompareConfig, err error) {
+	defer Return(&err)
+	buf, err := os.ReadFile(path)
+	Ck(err)
+	cfg = &CompareConfig{}
+	err = yaml.Unmarshal(buf, cfg)
+	Ck(err, "%s", path)
+	if cfg.Name == "" {
+		cfg.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return
+}
+
+// LoadQuestions reads questions from a file, one per line, skipping
+// blank lines and lines that start with #.
+func LoadQuestions(path string) (questions []string, err error) {
+	defer Return(&err)
+	fh, err := os.Open(path)
+	Ck(err)
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		questions = append(questions, line)
+	}
+	err = scanner.Err()
+	Ck(err)
+	return
+}
+
+// CompareRun is the outcome of one question under one
+// configuration.
+type CompareRun struct {
+	Answer  string
+	Sources []string
+	Tokens  int
+	Latency time.Duration
+	// Error is set instead of Answer if the question failed.
+	Error string `json:",omitempty"`
+}
+
+// CompareResult holds the runs of a question, one per
+// configuration, in the order the configurations were given.
+type CompareResult struct {
+	Question string
+	Runs     []CompareRun
+}
+
+// variant returns a read-only copy of the database set up with cfg.
+func (g *Grokker) variant(cfg *CompareConfig) (v *Grokker, err error) {
+	defer Return(&err)
+	buf, err := json.Marshal(g)
+	Ck(err)
+	v = &Grokker{}
+	err = json.Unmarshal(buf, v)
+	Ck(err)
+	v.Root = g.Root
+	v.grokpath = g.grokpath
+	v.filter = g.filter
+	v.maxChunks = g.maxChunks
+	v.contextTokens = g.contextTokens
+	v.lang = g.lang
+	v.profile = g.profile
+	v.noEmbeddingCache = g.noEmbeddingCache
+	v.responseCache = g.responseCache
+	v.noQuestionLog = true
+	v.SetReadOnly()
+	model := cfg.Model
+	if model == "" {
+		model = g.Model
+	}
+	err = v.Setup(model)
+	Ck(err)
+	if cfg.PipelineFile != "" {
+		err = v.UsePipeline(cfg.PipelineFile)
+		Ck(err, "%s", cfg.Name)
+	}
+	var names []string
+	for name := range cfg.Pipeline {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = v.SetPipeline(name, cfg.Pipeline[name])
+		Ck(err, "%s: pipeline setting %s", cfg.Name, name)
+	}
+	err = v.SetPersona(cfg.Persona)
+	Ck(err)
+	return
+}
+
+// Compare answers each question under each configuration.  A
+// question that fails under a configuration is reported in its run
+// rather than stopping the comparison.
+func (g *Grokker) Compare(cfgs []*CompareConfig, questions []string, global bool) (results []CompareResult, err error) {
+	defer Return(&err)
+	var variants []*Grokker
+	for _, cfg := range cfgs {
+		v, err := g.variant(cfg)
+		Ck(err)
+		variants = append(variants, v)
+	}
+	for _, q := range questions {
+		res := CompareResult{Question: q}
+		for _, v := range variants {
+			used := v.TokensUsed()
+			start := time.Now()
+			answer, err := v.Answer(q, false, false, global)
+			run := CompareRun{Latency: time.Since(start), Tokens: v.TokensUsed() - used}
+			if err != nil {
+				run.Error = err.Error()
+			} else {
+				run.Answer = answer
+				run.Sources = v.Sources()
+			}
+			res.Runs = append(res.Runs, run)
+		}
+		results = append(results, res)
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestCompare(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+
+	path := filepath.Join(dir, "routed.yaml")
+	err = os.WriteFile(path, []byte("model: gpt-4\npersona: sre\npipeline:\n  router: embedding\n  rerankk: 50\n"), 0644)
+	Tassert(t, err == nil, "error writing config: %v", err)
+	cfg, err := LoadCompareConfig(path)
+	Tassert(t, err == nil, "error loading config: %v", err)
+	Tassert(t, cfg.Name == "routed", "unexpected name %q", cfg.Name)
+
+	v, err := grok.variant(cfg)
+	Tassert(t, err == nil, "error creating variant: %v", err)
+	Tassert(t, v.Model == "gpt-4" && v.Pipeline.Router == "embedding" && v.Pipeline.RerankK == 50, "unexpected variant %s %+v", v.Model, v.Pipeline)
+	Tassert(t, v.persona != nil && v.readOnly && v.noQuestionLog, "unexpected variant state")
+	// the original is untouched
+	Tassert(t, grok.Model == "gpt-3.5-turbo" && grok.Pipeline.Router == "", "original changed: %s %+v", grok.Model, grok.Pipeline)
+
+	_, err = grok.variant(&CompareConfig{Name: "bad", Pipeline: map[string]string{"nope": "1"}})
+	Tassert(t, err != nil, "expected error for an unknown pipeline setting")
+
+	path = filepath.Join(dir, "questions.txt")
+	err = os.WriteFile(path, []byte("# smoke tests\nwhat is a chunk?\n\n  why? \n"), 0644)
+	Tassert(t, err == nil, "error writing questions: %v", err)
+	qs, err := LoadQuestions(path)
+	Tassert(t, err == nil, "error loading questions: %v", err)
+	Tassert(t, strings.Join(qs, "|") == "what is a chunk?|why?", "unexpected questions %q", qs)
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/stevegt/goadapt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds user-level settings that apply to every knowledge
+// base, as opposed to the settings stored in a .grok file.  It is
+// read from ConfigPath(), which is optional.
+type Config struct {
+	// Personas adds to or overrides the built-in personas; see
+	// Persona.
+	Personas map[string]*Persona `yaml:"personas"`
+	// Profiles adds to or overrides the built-in answer profiles;
+	// see Profile.
+	Profiles map[string]*Profile `yaml:"profiles"`
+	// Policy sets guardrails for outgoing requests.
+	Policy *Policy `yaml:"policy"`
+	// TrustedKeys are minisign public keys, as base64 strings,
+	// whose signatures 'grok import' accepts; see sign.go.
+	TrustedKeys []string `yaml:"trusted_keys"`
+	// Commands are the hooks to run around grok subcommands, keyed
+	// by subcommand; see cmdhooks.go.
+	Commands map[string]*CommandHooks `yaml:"commands"`
+	// Lang is the default language of answers; see lang.go.
+	Lang string `yaml:"lang"`
+	// Chat sets how chat histories are stored; see chatcrypt.go.
+	Chat *ChatConfig `yaml:"chat"`
+	// Cache caps the size of the caches; see cached.go.
+	Cache *CacheConfig `yaml:"cache"`
+	// QuestionLog set to false turns off the question log; see
+	// questions.go.
+	QuestionLog *bool `yaml:"question_log"`
+}
+
+// ConfigPath returns the path of the user config file:
+// $GROKKER_CONFIG if set, else config.yaml in ConfigDir(), e.g.
+// ~/.config/grokker/config.yaml.
+func ConfigPath() string {
+	path := os.Getenv("GROKKER_CONFIG")
+	if path != "" {
+		return path
+	}
+	dir := ConfigDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// LoadConfig reads the user config file.  A missing file yields an
+// empty config.
+func LoadConfig() (cfg *Config, err error) {
+	defer Return(&err)
+	cfg = &Config{}
+	path := ConfigPath()
+	if path == "" {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	err = yaml.Unmarshal(buf, cfg)
+	Ck(err, "%s", path)
+	return
+}
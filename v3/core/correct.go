@@ -0,0 +1,205 @@
+package core
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A query that misspells a symbol, e.g. "how does FindChunk rank
+// results?", embeds close enough to the right chunks to look fine
+// but not close enough to find them, and the symbol boost never
+// fires, so the answer is quietly about something else.  Before
+// retrieval we compare the symbols named in the query with the
+// vocabulary of the chunks it searches, i.e. their Symbols; see
+// symbols.go.  An unknown symbol within a small edit distance of a
+// known one is corrected to it, the nearest first, and among equally
+// near ones, the one whose chunks are most similar to the query's
+// embedding.  The corrected query is embedded again and used for
+// the rest of retrieval; the question itself, as the model sees it,
+// is unchanged.  Corrections says what was corrected.  It is on by
+// default; turn it off per query with SetCorrect or for the database
+// with Pipeline.NoCorrect.
+
+// minCorrectLen is the shortest symbol that is corrected; shorter
+// ones are too near too many others.
+const minCorrectLen = 4
+
+// Correction is a symbol in a query that was corrected before
+// retrieval, e.g. FindChunk to FindChunks.
+type Correction struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// String returns the correction as "from -> to".
+func (c Correction) String() string {
+	return Spf("%s -> %s", c.From, c.To)
+}
+
+// SetCorrect turns query correction on or off for subsequent calls
+// to Answer, overriding Pipeline.NoCorrect.
+func (g *Grokker) SetCorrect(on bool) {
+	g.correct = &on
+}
+
+// correcting returns true if queries should be corrected.
+func (g *Grokker) correcting() bool {
+	if g.correct != nil {
+		return *g.correct
+	}
+	return !g.Pipeline.NoCorrect
+}
+
+// Corrections returns the symbols corrected in the most recent
+// question before retrieval, in the order they were corrected.
+func (g *Grokker) Corrections() []Correction {
+	return g.corrections
+}
+
+// vocabTerm is a symbol in the vocabulary and the chunks that have
+// it.
+type vocabTerm struct {
+	name   string
+	chunks []*Chunk
+}
+
+// vocabulary returns the symbols of chunks, keyed by their lower
+// case form.
+func vocabulary(chunks []*Chunk) (vocab map[string]*vocabTerm) {
+	vocab = make(map[string]*vocabTerm)
+	for _, c := range chunks {
+		for _, sym := range c.Symbols {
+			key := strings.ToLower(sym)
+			term, ok := vocab[key]
+			if !ok {
+				term = &vocabTerm{name: sym}
+				vocab[key] = term
+			}
+			term.chunks = append(term.chunks, c)
+		}
+	}
+	return
+}
+
+// correctQuery returns query with its misspelled symbols corrected
+// to the nearest symbols of chunks, and records the corrections.
+func (g *Grokker) correctQuery(query string, queryEmbedding []float64, chunks []*Chunk) (out string) {
+	out = query
+	if !g.correcting() {
+		return
+	}
+	var vocab map[string]*vocabTerm
+	for _, sym := range extractSymbols(query) {
+		// the last part of a dotted name is checked on its own
+		if strings.Contains(sym, ".") || len(sym) < minCorrectLen {
+			continue
+		}
+		if vocab == nil {
+			vocab = vocabulary(chunks)
+		}
+		key := strings.ToLower(sym)
+		if vocab[key] != nil {
+			continue
+		}
+		term := nearestTerm(key, queryEmbedding, vocab)
+		if term == nil {
+			continue
+		}
+		re := regexp.MustCompile(`\b` + regexp.QuoteMeta(sym) + `\b`)
+		out = re.ReplaceAllLiteralString(out, term.name)
+		g.addCorrection(Correction{From: sym, To: term.name})
+	}
+	return
+}
+
+// nearestTerm returns the vocabulary term nearest to key, or nil if
+// none is near enough.  Ties go to the term whose chunks are most
+// similar to the query.
+func nearestTerm(key string, queryEmbedding []float64, vocab map[string]*vocabTerm) (best *vocabTerm) {
+	maxDist := 1
+	if len(key) > 6 {
+		maxDist = 2
+	}
+	type candidate struct {
+		term *vocabTerm
+		dist int
+		sim  float64
+	}
+	var candidates []candidate
+	for k, term := range vocab {
+		if len(k) < len(key)-maxDist || len(k) > len(key)+maxDist {
+			continue
+		}
+		dist := editDistance(key, k)
+		if dist > maxDist {
+			continue
+		}
+		sim := -1.0
+		for _, c := range term.chunks {
+			if c.Embedding == nil {
+				continue
+			}
+			if s := util.Similarity(queryEmbedding, c.Embedding); s > sim {
+				sim = s
+			}
+		}
+		candidates = append(candidates, candidate{term, dist, sim})
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.dist != b.dist {
+			return a.dist < b.dist
+		}
+		if a.sim != b.sim {
+			return a.sim > b.sim
+		}
+		return a.term.name < b.term.name
+	})
+	return candidates[0].term
+}
+
+// addCorrection records a correction once.
+func (g *Grokker) addCorrection(c Correction) {
+	for _, had := range g.corrections {
+		if had == c {
+			return
+		}
+	}
+	g.corrections = append(g.corrections, c)
+}
+
+// editDistance returns the number of single-character insertions,
+// deletions, substitutions, and transpositions of adjacent
+// characters that turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows i-2, i-1, and i of the distance matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
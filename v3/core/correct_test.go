@@ -0,0 +1,71 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"findchunk", "findchunks", 1},
+		{"findchunsk", "findchunks", 1},
+		{"fidnchunks", "findchunks", 1},
+		{"findchnuk", "findchunks", 2},
+		{"answer", "answer", 0},
+		{"", "abc", 3},
+	} {
+		got := editDistance(c.a, c.b)
+		Tassert(t, got == c.want, "editDistance(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+	}
+}
+
+func TestCorrectQuery(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	path := filepath.Join(dir, "chunk.go")
+	err = os.WriteFile(path, []byte("package core\n\n// FindChunks ranks the chunks by similarity.\nfunc FindChunks(query string) {}\n"), 0644)
+	Ck(err)
+	err = grok.AddDocument(path)
+	Ck(err)
+
+	_, err = grok.findChunks("how does FindChunk rank results?", 2000, nil)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	got := grok.Corrections()
+	Tassert(t, len(got) == 1 && got[0].String() == "FindChunk -> FindChunks", "got %v", got)
+
+	// known symbols, in any case, symbols with nothing near, and
+	// short ones aren't corrected
+	grok.corrections = nil
+	_, err = grok.findChunks("how does findChunks rank SortResults and Fnd?", 2000, nil)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(grok.Corrections()) == 0, "got %v", grok.Corrections())
+
+	grok.SetCorrect(false)
+	_, err = grok.findChunks("how does FindChunk rank results?", 2000, nil)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(grok.Corrections()) == 0, "got %v", grok.Corrections())
+
+	// among equally near symbols, the one in the chunks nearest the
+	// query wins
+	chunks := []*Chunk{
+		{Symbols: []string{"ParseURL"}, Embedding: []float64{0, 1}},
+		{Symbols: []string{"ParseURI"}, Embedding: []float64{1, 0}},
+	}
+	vocab := vocabulary(chunks)
+	term := nearestTerm("parseurx", []float64{1, 0.1}, vocab)
+	Tassert(t, term != nil && term.name == "ParseURI", "got %v", term)
+	term = nearestTerm("parseurx", []float64{0.1, 1}, vocab)
+	Tassert(t, term != nil && term.name == "ParseURL", "got %v", term)
+	term = nearestTerm("renderhtml", []float64{1, 0}, vocab)
+	Tassert(t, term == nil, "got %v", term)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A compound question, e.g. "how do I configure X, and why does Y
+// fail?", retrieves context that is a compromise between its parts.
+// With decomposition on, the model first splits the question into
+// sub-questions; each is answered from its own context, and the
+// model then combines the answers, citing the sources of each part.
+// It costs a request per part plus two, so it's off by default; turn
+// it on per query with SetDecompose or for the database with
+// Pipeline.Decompose.
+
+// maxSubQuestions is the most sub-questions a question is split
+// into.
+const maxSubQuestions = 5
+
+const decomposeSysmsg = "You split compound questions into independent sub-questions.  Reply with one sub-question per line and nothing else.  Each sub-question must make sense on its own.  If the question has only one part, reply with it unchanged."
+
+const synthesizeSysmsg = "You combine the answers to the parts of a question into one response to the whole question.  Use only the answers given.  After the material from each part, cite that part's sources in brackets, e.g. [path:line], exactly as they are listed.  Don't mention the parts or this process."
+
+// SetDecompose turns question decomposition on or off for subsequent
+// calls to Answer, overriding Pipeline.Decompose.
+func (g *Grokker) SetDecompose(on bool) {
+	g.decompose = &on
+}
+
+// decomposing returns true if Answer should decompose questions.
+func (g *Grokker) decomposing() bool {
+	if g.decompose != nil {
+		return *g.decompose
+	}
+	return g.Pipeline.Decompose
+}
+
+// listMarkerRe matches the numbers or bullets a model may put in
+// front of list items.
+var listMarkerRe = regexp.MustCompile(`^\s*(\d+[.)]|[-*•])\s*`)
+
+// parseList returns up to max distinct items, one per line, from a
+// model response, without list numbers or bullets.
+func parseList(resp string, max int) (items []string) {
+	for _, line := range strings.Split(resp, "\n") {
+		line = strings.TrimSpace(listMarkerRe.ReplaceAllString(line, ""))
+		if line == "" || util.StringInSlice(line, items) {
+			continue
+		}
+		items = append(items, line)
+		if len(items) == max {
+			break
+		}
+	}
+	return
+}
+
+// answerDecomposed answers a question part by part.  It returns
+// false if the question has only one part, so the caller can answer
+// it as usual.  The sources and context of all parts are left in
+// g.sources, g.sourceIDs, and g.context.
+func (g *Grokker) answerDecomposed(question string, withHeaders, withLineNumbers, global bool) (resp string, ok bool, err error) {
+	defer Return(&err)
+	split, err := g.msg(decomposeSysmsg, question)
+	Ck(err)
+	subs := parseList(split.Choices[0].Message.Content, maxSubQuestions)
+	if len(subs) < 2 {
+		return
+	}
+	Debug("decomposed question into %d parts: %q", len(subs), subs)
+	var parts, contexts strings.Builder
+	var sources, ids []string
+	for i, sub := range subs {
+		context, err := g.answerContext(sub, withHeaders, withLineNumbers)
+		Ck(err)
+		contexts.WriteString(context)
+		respmsg, err := g.generate(g.Sysmsg(SysMsgChat), sub, context, global)
+		Ck(err)
+		parts.WriteString(Spf("Part %d: %s\n\nAnswer:\n%s\n\nSources: %s\n\n", i+1, sub, respmsg.Choices[0].Message.Content, strings.Join(g.sources, ", ")))
+		for _, cite := range g.sources {
+			if !util.StringInSlice(cite, sources) {
+				sources = append(sources, cite)
+			}
+		}
+		for _, id := range g.sourceIDs {
+			if !util.StringInSlice(id, ids) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	input := Spf("Question: %s\n\n%s", question, parts.String())
+	combined, err := g.msg(synthesizeSysmsg, input)
+	Ck(err)
+	g.sources = sources
+	g.sourceIDs = ids
+	g.context = contexts.String()
+	return combined.Choices[0].Message.Content, true, nil
+}
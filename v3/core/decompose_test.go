@@ -0,0 +1,28 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestDecompose(t *testing.T) {
+	subs := parseList("1. How is X configured?\n2) Why does Y fail?\n\n- How is X configured?\n", maxSubQuestions)
+	Tassert(t, strings.Join(subs, "|") == "How is X configured?|Why does Y fail?", "unexpected sub-questions %q", subs)
+	subs = parseList("a\nb\nc\nd\ne\nf\n", maxSubQuestions)
+	Tassert(t, len(subs) == maxSubQuestions, "expected %d sub-questions, got %d", maxSubQuestions, len(subs))
+
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	Tassert(t, !grok.decomposing(), "decomposition should be off by default")
+	grok.Pipeline.Decompose = true
+	Tassert(t, grok.decomposing(), "the pipeline should turn decomposition on")
+	grok.SetDecompose(false)
+	Tassert(t, !grok.decomposing(), "SetDecompose should override the pipeline")
+
+	// follow-ups are off unless asked for, and cost no request
+	grok.followUps = []string{"stale"}
+	err = grok.suggestFollowUps("q", "a")
+	Tassert(t, err == nil && grok.FollowUps() == nil, "unexpected follow-ups %v %v", grok.FollowUps(), err)
+}
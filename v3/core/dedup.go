@@ -0,0 +1,126 @@
+package core
+
+import (
+	"sort"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Copied text, e.g. in several versions of a document, yields chunks
+// with nearly identical embeddings.  Only the best scoring of them is
+// used as context, so the context budget isn't spent on repetition.
+// The same comparison finds copy-pasted passages across documents
+// for a report, so teams can consolidate them; see Duplicates.
+
+// defaultDedupThreshold is used when Pipeline.DedupThreshold is not
+// set.
+const defaultDedupThreshold = 0.97
+
+// dedupThreshold returns the similarity at or above which a chunk is
+// a near-duplicate of another.
+func (g *Grokker) dedupThreshold() float64 {
+	if g.Pipeline.DedupThreshold > 0 {
+		return g.Pipeline.DedupThreshold
+	}
+	return defaultDedupThreshold
+}
+
+// nearDuplicate returns true if the chunk's embedding is at least
+// threshold similar to any of the kept embeddings.
+func nearDuplicate(chunk *Chunk, kept [][]float64, threshold float64) bool {
+	for _, emb := range kept {
+		if util.Similarity(chunk.Embedding, emb) >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Duplicate is a set of near-identical passages in different
+// documents.
+type Duplicate struct {
+	// Similarity is the lowest similarity between linked
+	// passages in the set.
+	Similarity float64
+	// Passages are the passages as "relpath:line".
+	Passages []string
+}
+
+// Duplicates returns the sets of passages in different documents
+// whose embeddings are at least threshold similar, largest set
+// first.  If threshold is zero, the pipeline's dedup threshold is
+// used.  Every pair of chunks is compared, so this takes a while on
+// a large knowledge base.
+func (g *Grokker) Duplicates(threshold float64) (dups []*Duplicate, err error) {
+	defer Return(&err)
+	if threshold <= 0 {
+		threshold = g.dedupThreshold()
+	}
+	var chunks []*Chunk
+	for _, c := range g.Chunks {
+		if c.Document != nil && c.Embedding != nil {
+			chunks = append(chunks, c)
+		}
+	}
+	// union-find over the chunks, recording the weakest link of
+	// each set
+	parent := make([]int, len(chunks))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	weakest := make(map[int]float64)
+	for i := 0; i < len(chunks); i++ {
+		for j := i + 1; j < len(chunks); j++ {
+			if chunks[i].Document.RelPath == chunks[j].Document.RelPath {
+				continue
+			}
+			sim := util.Similarity(chunks[i].Embedding, chunks[j].Embedding)
+			if sim < threshold {
+				continue
+			}
+			a, b := find(i), find(j)
+			low := sim
+			for _, root := range []int{a, b} {
+				if w, ok := weakest[root]; ok && w < low {
+					low = w
+				}
+			}
+			delete(weakest, a)
+			delete(weakest, b)
+			parent[b] = a
+			weakest[a] = low
+		}
+	}
+	sets := make(map[int]*Duplicate)
+	for i, c := range chunks {
+		root := find(i)
+		sim, ok := weakest[root]
+		if !ok {
+			continue
+		}
+		dup := sets[root]
+		if dup == nil {
+			dup = &Duplicate{Similarity: sim}
+			sets[root] = dup
+			dups = append(dups, dup)
+		}
+		cite, err := g.citation(c)
+		Ck(err)
+		dup.Passages = append(dup.Passages, cite)
+	}
+	sort.SliceStable(dups, func(i, j int) bool {
+		if len(dups[i].Passages) != len(dups[j].Passages) {
+			return len(dups[i].Passages) > len(dups[j].Passages)
+		}
+		return dups[i].Similarity > dups[j].Similarity
+	})
+	return
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestDedup(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	v1 := &Document{RelPath: "v1/a.md"}
+	v2 := &Document{RelPath: "v2/a.md"}
+	other := &Document{RelPath: "b.md"}
+	pool := []*Chunk{
+		{Document: v1, Text: "x", Embedding: []float64{1, 0, 0}, tokenLength: 10},
+		{Document: v2, Text: "x", Embedding: []float64{0.99, 0.01, 0}, tokenLength: 10},
+		{Document: other, Text: "y", Embedding: []float64{0.7, 0.7, 0}, tokenLength: 10},
+	}
+	query := []float64{1, 0, 0}
+	chunks, err := grok.similarChunks("q", query, 1000, nil, pool)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(chunks) == 2 && chunks[0].Document == v1 && chunks[1].Document == other, "expected the copy to be dropped: %v", chunks)
+
+	grok.Pipeline.DedupThreshold = 1.1
+	chunks, err = grok.similarChunks("q", query, 1000, nil, pool)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(chunks) == 3, "expected all chunks, got %d", len(chunks))
+}
+
+func TestDuplicates(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	a := &Document{RelPath: "a.md"}
+	b := &Document{RelPath: "b.md"}
+	c := &Document{RelPath: "c.md"}
+	grok.Chunks = []*Chunk{
+		{Document: a, Text: "x", Line: 1, Embedding: []float64{1, 0, 0}},
+		{Document: a, Text: "x", Line: 9, Embedding: []float64{1, 0, 0}},
+		{Document: b, Text: "x", Line: 3, Embedding: []float64{0.99, 0.01, 0}},
+		{Document: c, Text: "x", Line: 5, Embedding: []float64{0.98, 0.03, 0}},
+		{Document: c, Text: "y", Line: 7, Embedding: []float64{0, 1, 0}},
+		{Document: b, Text: "y", Line: 8, Embedding: []float64{0, 0.999, 0.01}},
+		{Document: b, Text: "z", Line: 2, Embedding: []float64{0, 0, 1}},
+	}
+	dups, err := grok.Duplicates(0)
+	Tassert(t, err == nil, "error finding duplicates: %v", err)
+	Tassert(t, len(dups) == 2, "expected 2 sets, got %d", len(dups))
+	Tassert(t, len(dups[0].Passages) == 4, "expected the x passages first, got %v", dups[0].Passages)
+	Tassert(t, dups[0].Similarity < dups[1].Similarity, "expected the weakest link, got %f", dups[0].Similarity)
+	Tassert(t, dups[1].Passages[0] == "c.md:7" && dups[1].Passages[1] == "b.md:8", "unexpected set %v", dups[1].Passages)
+
+	dups, err = grok.Duplicates(0.99999)
+	Tassert(t, err == nil, "error finding duplicates: %v", err)
+	Tassert(t, len(dups) == 0, "expected no sets, got %v", dups)
+}
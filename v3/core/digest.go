@@ -0,0 +1,352 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A digest tells a team what changed in the knowledge base since a
+// given git revision or time: new documents, the areas of the tree
+// that changed, and the commits that changed them.  Changes gathers
+// the facts, from git if the tree is a git work tree and otherwise
+// from file times and tombstones, and Digest asks the model to write
+// them up as markdown.  Digests are meant to be generated on a
+// schedule, e.g. from cron or CI:
+//
+//	grok digest --since 1w --to digest.md
+
+// digestExcerptLines is how many lines of each new document are
+// shown to the model.
+const digestExcerptLines = 20
+
+// digestAreaDepth is how many directory levels name an area of the
+// tree.
+const digestAreaDepth = 2
+
+const digestSysmsg = "You write a short digest for a team about what changed in their project's documents and code.  You are given the commits, the documents that were added, changed, and removed, the areas of the tree that changed, and the beginnings of the new documents.  Reply in markdown with these sections, leaving out any that would be empty: ## Highlights, a few sentences on the most important changes; ## New documents, a list item per new document saying what it covers; ## Changed areas, a list item per area saying what changed there; ## Notable commits, the commits worth reading, each with its short hash.  Mention paths and hashes exactly as given.  Don't invent changes that aren't listed."
+
+// Commit is a git commit.
+type Commit struct {
+	Hash    string
+	Author  string
+	Date    time.Time
+	Subject string
+	// Files are the paths the commit changed, relative to the root.
+	Files []string
+}
+
+// String returns the commit as "hash date author: subject".
+func (c *Commit) String() string {
+	hash := c.Hash
+	if len(hash) > 7 {
+		hash = hash[:7]
+	}
+	return Spf("%s %s %s: %s", hash, c.Date.Format("2006-01-02"), c.Author, c.Subject)
+}
+
+// Changes are the changes to the knowledge base since a revision or
+// time.
+type Changes struct {
+	// Since is the revision or time, as given.
+	Since string
+	// Commits are the commits that changed documents, newest
+	// first; nil outside a git work tree.
+	Commits []*Commit
+	// Added are the documents created since then.  Outside a git
+	// work tree, new documents can't be told from changed ones, and
+	// are listed in Changed.
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// Area is a directory of the tree and how many of its documents
+// changed.
+type Area struct {
+	Dir   string
+	Files int
+}
+
+// Empty returns true if nothing changed.
+func (c *Changes) Empty() bool {
+	return len(c.Commits)+len(c.Added)+len(c.Changed)+len(c.Removed) == 0
+}
+
+// Areas returns the directories with changed documents, most
+// changes first.
+func (c *Changes) Areas() (areas []Area) {
+	counts := make(map[string]int)
+	for _, list := range [][]string{c.Added, c.Changed, c.Removed} {
+		for _, relpath := range list {
+			dir := path.Dir(relpath)
+			parts := strings.Split(dir, "/")
+			if len(parts) > digestAreaDepth {
+				dir = strings.Join(parts[:digestAreaDepth], "/")
+			}
+			if counts[dir] == 0 {
+				areas = append(areas, Area{Dir: dir})
+			}
+			counts[dir]++
+		}
+	}
+	for i := range areas {
+		areas[i].Files = counts[areas[i].Dir]
+	}
+	sort.SliceStable(areas, func(i, j int) bool {
+		if areas[i].Files != areas[j].Files {
+			return areas[i].Files > areas[j].Files
+		}
+		return areas[i].Dir < areas[j].Dir
+	})
+	return
+}
+
+// Changes returns the changes to the knowledge base since a git
+// revision or a time, e.g. v1.2.0, HEAD~10, 1w, or 2024-03-01.
+// Revisions need a git work tree.
+func (g *Grokker) Changes(since string, now time.Time) (changes *Changes, err error) {
+	defer Return(&err)
+	changes = &Changes{Since: since}
+	_, err = os.Stat(filepath.Join(g.Root, ".git"))
+	isGit := err == nil
+	err = nil
+	t, terr := ParseSince(since, now)
+	if !isGit {
+		if terr != nil {
+			err = fmt.Errorf("%v; revisions need a git work tree", terr)
+			return
+		}
+		g.fileChanges(changes, t)
+		return
+	}
+	var rangeArgs []string
+	if terr == nil {
+		rangeArgs = []string{"--since=" + t.Format(time.RFC3339), "HEAD"}
+	} else {
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", since+"^{commit}")
+		cmd.Dir = g.Root
+		if cmd.Run() != nil {
+			err = fmt.Errorf("%q is neither a time, e.g. 1w or 2024-03-01, nor a git revision", since)
+			return
+		}
+		rangeArgs = []string{since + "..HEAD"}
+	}
+	err = g.gitChanges(changes, rangeArgs)
+	Ck(err)
+	return
+}
+
+// fileChanges fills in changes from the times the documents' files
+// were changed and the tombstones of removed documents.
+func (g *Grokker) fileChanges(changes *Changes, since time.Time) {
+	for _, doc := range g.Documents {
+		var changed time.Time
+		if doc.Virtual {
+			if doc.Indexed == nil {
+				continue
+			}
+			changed = *doc.Indexed
+		} else {
+			info, err := os.Stat(g.absPath(doc))
+			if err != nil {
+				changes.Removed = append(changes.Removed, doc.RelPath)
+				continue
+			}
+			changed = info.ModTime()
+		}
+		if !changed.Before(since) {
+			changes.Changed = append(changes.Changed, doc.RelPath)
+		}
+	}
+	for _, ts := range g.Tombstones {
+		if !ts.Deleted.Before(since) && !util.StringInSlice(ts.RelPath, changes.Removed) {
+			changes.Removed = append(changes.Removed, ts.RelPath)
+		}
+	}
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Removed)
+}
+
+// gitChanges fills in changes from the git log over the given range.
+// Only the commits and files that touch the knowledge base, or
+// documents forgotten from it, are kept.
+func (g *Grokker) gitChanges(changes *Changes, rangeArgs []string) (err error) {
+	defer Return(&err)
+	args := append([]string{"log", "--no-renames", "--name-status", "--format=%x1e%H%x1f%an%x1f%aI%x1f%s"}, rangeArgs...)
+	args = append(args, "--")
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.Root
+	out, err := cmd.Output()
+	Ck(err)
+
+	inKB := make(map[string]bool)
+	for _, doc := range g.Documents {
+		inKB[doc.RelPath] = true
+	}
+	for _, ts := range g.Tombstones {
+		inKB[ts.RelPath] = true
+	}
+	// the status of each file in its oldest commit in the range
+	first := make(map[string]string)
+	var files []string
+	for _, entry := range strings.Split(string(out), "\x1e") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader([]byte(entry)))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		scanner.Scan()
+		fields := strings.Split(scanner.Text(), "\x1f")
+		Assert(len(fields) == 4, "unexpected git log line %q", scanner.Text())
+		date, err := time.Parse(time.RFC3339, fields[2])
+		Ck(err)
+		commit := &Commit{Hash: fields[0], Author: fields[1], Date: date, Subject: fields[3]}
+		for scanner.Scan() {
+			status, relpath, ok := strings.Cut(scanner.Text(), "\t")
+			if !ok || !inKB[relpath] {
+				continue
+			}
+			commit.Files = append(commit.Files, relpath)
+			if _, ok := first[relpath]; !ok {
+				files = append(files, relpath)
+			}
+			// the log is newest first
+			first[relpath] = status[:1]
+		}
+		if len(commit.Files) > 0 {
+			changes.Commits = append(changes.Commits, commit)
+		}
+	}
+	sort.Strings(files)
+	for _, relpath := range files {
+		_, err := os.Stat(filepath.Join(g.Root, filepath.FromSlash(relpath)))
+		exists := err == nil
+		switch {
+		case !exists && first[relpath] == "A":
+			// added and removed again
+		case !exists:
+			changes.Removed = append(changes.Removed, relpath)
+		case first[relpath] == "A":
+			changes.Added = append(changes.Added, relpath)
+		default:
+			changes.Changed = append(changes.Changed, relpath)
+		}
+	}
+	return
+}
+
+// Digest returns a markdown digest of changes.  The facts are sent
+// to the model in as much detail as fits in half its token limit,
+// leaving the rest for the response; if nothing changed, no request
+// is made.
+func (g *Grokker) Digest(changes *Changes, now time.Time) (digest string, err error) {
+	defer Return(&err)
+	title := Spf("# Digest: changes since %s\n\n", changes.Since)
+	if changes.Empty() {
+		digest = title + "Nothing in the knowledge base changed.\n"
+		return
+	}
+	counts := Spf("_Generated %s from %d commits: %d documents added, %d changed, %d removed._\n\n",
+		now.Format("2006-01-02"), len(changes.Commits), len(changes.Added), len(changes.Changed), len(changes.Removed))
+	if changes.Commits == nil {
+		counts = Spf("_Generated %s: %d documents changed, %d removed._\n\n",
+			now.Format("2006-01-02"), len(changes.Changed), len(changes.Removed))
+	}
+
+	budget := g.TokenLimit / 2
+	var input strings.Builder
+	add := func(text string) bool {
+		tc, err := g.TokenCount(text)
+		Ck(err)
+		if tc > budget {
+			return false
+		}
+		budget -= tc
+		input.WriteString(text)
+		return true
+	}
+	if !add(digestFacts(changes)) {
+		err = classify(FailBudget, fmt.Errorf("the changes since %s don't fit in the model's token limit; try a later revision or time", changes.Since))
+		return
+	}
+	for _, relpath := range changes.Added {
+		excerpt, err := g.digestExcerpt(relpath)
+		Ck(err)
+		if excerpt != "" && !add(excerpt) {
+			break
+		}
+	}
+	resp, err := g.msg(digestSysmsg, input.String())
+	Ck(err)
+	digest = title + counts + strings.TrimSpace(resp.Choices[0].Message.Content) + "\n"
+	return
+}
+
+// digestFacts returns the commits, documents, and areas in changes
+// as text for the model.  Long lists are cut short.
+func digestFacts(changes *Changes) string {
+	const maxItems = 200
+	var b strings.Builder
+	list := func(heading string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		b.WriteString(heading + ":\n")
+		for i, item := range items {
+			if i == maxItems {
+				b.WriteString(Spf("- and %d more\n", len(items)-maxItems))
+				break
+			}
+			b.WriteString("- " + item + "\n")
+		}
+		b.WriteString("\n")
+	}
+	var commits []string
+	for _, c := range changes.Commits {
+		commits = append(commits, Spf("%s (%d files)", c, len(c.Files)))
+	}
+	var areas []string
+	for _, a := range changes.Areas() {
+		areas = append(areas, Spf("%s (%d files)", a.Dir, a.Files))
+	}
+	list("Commits, newest first", commits)
+	list("Added documents", changes.Added)
+	list("Changed documents", changes.Changed)
+	list("Removed documents", changes.Removed)
+	list("Changed areas", areas)
+	return b.String()
+}
+
+// digestExcerpt returns the beginning of a new document for the
+// model, or "" if it isn't in the knowledge base.
+func (g *Grokker) digestExcerpt(relpath string) (excerpt string, err error) {
+	defer Return(&err)
+	for _, doc := range g.Documents {
+		if doc.RelPath != relpath {
+			continue
+		}
+		buf, err := os.ReadFile(g.absPath(doc))
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		Ck(err)
+		lines := strings.Split(string(buf), "\n")
+		if len(lines) > digestExcerptLines {
+			lines = lines[:digestExcerptLines]
+		}
+		excerpt = Spf("Beginning of %s:\n%s\n\n", relpath, strings.Join(lines, "\n"))
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestGitChanges(t *testing.T) {
+	dir := TmpTestDir()
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=Ann Lee", "-c", "user.email=ann@example.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		Tassert(t, err == nil, "git %v: %v: %s", args, err, out)
+	}
+	write := func(fn, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755)
+		Tassert(t, err == nil, "error creating directory: %v", err)
+		err = os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Tassert(t, err == nil, "error writing file: %v", err)
+	}
+	git("init", "-q")
+	write("README.md", "# Project\n")
+	write("api/v1/handler.go", "package v1\n")
+	write("old.md", "# Old\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "start")
+	git("tag", "v1")
+	write("docs/guide.md", "# Guide\n\nHow to use it.\n")
+	write("api/v1/handler.go", "package v1\n\nfunc Handle() {}\n")
+	write("scratch.txt", "not in the knowledge base\n")
+	git("rm", "-q", "old.md")
+	git("add", ".")
+	git("commit", "-q", "-m", "add the guide")
+
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	for _, fn := range []string{"README.md", "api/v1/handler.go", "docs/guide.md"} {
+		grok.Documents = append(grok.Documents, &Document{RelPath: fn})
+	}
+	grok.Tombstones = append(grok.Tombstones, &Tombstone{RelPath: "old.md", Deleted: time.Now()})
+
+	changes, err := grok.Changes("v1", time.Now())
+	Tassert(t, err == nil, "error getting changes: %v", err)
+	Tassert(t, len(changes.Commits) == 1, "expected 1 commit, got %d", len(changes.Commits))
+	c := changes.Commits[0]
+	Tassert(t, c.Author == "Ann Lee" && c.Subject == "add the guide", "unexpected commit %s", c)
+	Tassert(t, len(c.Files) == 3, "expected 3 files in the knowledge base, got %v", c.Files)
+	Tassert(t, strings.Join(changes.Added, ",") == "docs/guide.md", "unexpected added %v", changes.Added)
+	Tassert(t, strings.Join(changes.Changed, ",") == "api/v1/handler.go", "unexpected changed %v", changes.Changed)
+	Tassert(t, strings.Join(changes.Removed, ",") == "old.md", "unexpected removed %v", changes.Removed)
+
+	areas := changes.Areas()
+	Tassert(t, len(areas) == 3, "expected 3 areas, got %v", areas)
+	Tassert(t, areas[0].Dir == "." && areas[1].Dir == "api/v1" && areas[2].Dir == "docs", "unexpected areas %v", areas)
+
+	// a time includes both commits
+	changes, err = grok.Changes("1w", time.Now())
+	Tassert(t, err == nil, "error getting changes: %v", err)
+	Tassert(t, len(changes.Commits) == 2, "expected 2 commits, got %d", len(changes.Commits))
+	Tassert(t, strings.Join(changes.Added, ",") == "README.md,api/v1/handler.go,docs/guide.md", "unexpected added %v", changes.Added)
+	Tassert(t, len(changes.Removed) == 0, "expected a file added and removed to be left out, got %v", changes.Removed)
+
+	_, err = grok.Changes("no-such-rev", time.Now())
+	Tassert(t, err != nil, "expected an error for an unknown revision")
+
+	// nothing changed since HEAD, so no request is made
+	changes, err = grok.Changes("HEAD", time.Now())
+	Tassert(t, err == nil, "error getting changes: %v", err)
+	digest, err := grok.Digest(changes, time.Now())
+	Tassert(t, err == nil, "error writing digest: %v", err)
+	Tassert(t, digest == "# Digest: changes since HEAD\n\nNothing in the knowledge base changed.\n", "unexpected digest %q", digest)
+}
+
+func TestFileChanges(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	for _, fn := range []string{"new.md", "old.md"} {
+		err = os.WriteFile(filepath.Join(dir, fn), []byte("text\n"), 0644)
+		Tassert(t, err == nil, "error writing file: %v", err)
+		grok.Documents = append(grok.Documents, &Document{RelPath: fn})
+	}
+	long := time.Now().Add(-30 * 24 * time.Hour)
+	err = os.Chtimes(filepath.Join(dir, "old.md"), long, long)
+	Tassert(t, err == nil, "error setting file time: %v", err)
+	grok.Tombstones = append(grok.Tombstones,
+		&Tombstone{RelPath: "gone.md", Deleted: time.Now()},
+		&Tombstone{RelPath: "ancient.md", Deleted: long})
+
+	changes, err := grok.Changes("1w", time.Now())
+	Tassert(t, err == nil, "error getting changes: %v", err)
+	Tassert(t, changes.Commits == nil && len(changes.Added) == 0, "expected no commits or added documents")
+	Tassert(t, strings.Join(changes.Changed, ",") == "new.md", "unexpected changed %v", changes.Changed)
+	Tassert(t, strings.Join(changes.Removed, ",") == "gone.md", "unexpected removed %v", changes.Removed)
+
+	_, err = grok.Changes("v1.2.0", time.Now())
+	Tassert(t, err != nil, "expected an error for a revision outside a git work tree")
+}
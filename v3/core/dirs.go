@@ -0,0 +1,64 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Grokker keeps its user-level state in two directories shared by
+// every knowledge base: the config directory, for config.yaml, and
+// the cache directory, for the model lists, the embedding and
+// response caches, and the provider health record.  Each is chosen
+// by the first of:
+//
+//   - the --config-dir or --cache-dir flag, via SetDirs
+//   - $GROKKER_CONFIG_DIR or $GROKKER_CACHE_DIR
+//   - $XDG_CONFIG_HOME/grokker or $XDG_CACHE_HOME/grokker
+//   - grokker in the platform's user config or cache directory,
+//     e.g. ~/.config/grokker and ~/.cache/grokker on Linux
+//
+// Nothing user-level is kept in the working directory.
+
+var configDirOverride, cacheDirOverride string
+
+// SetDirs overrides the config and cache directories for the rest of
+// the process.  An empty string leaves that directory alone.
+func SetDirs(configDir, cacheDir string) {
+	if configDir != "" {
+		configDirOverride = configDir
+	}
+	if cacheDir != "" {
+		cacheDirOverride = cacheDir
+	}
+}
+
+// ConfigDir returns grokker's user config directory, or an empty
+// string if there is none.
+func ConfigDir() string {
+	return userDir(configDirOverride, "GROKKER_CONFIG_DIR", "XDG_CONFIG_HOME", os.UserConfigDir)
+}
+
+// CacheDir returns grokker's user cache directory, or an empty string
+// if there is none.
+func CacheDir() string {
+	return userDir(cacheDirOverride, "GROKKER_CACHE_DIR", "XDG_CACHE_HOME", os.UserCacheDir)
+}
+
+func userDir(override, grokkerVar, xdgVar string, platform func() (string, error)) string {
+	if override != "" {
+		return override
+	}
+	if dir := os.Getenv(grokkerVar); dir != "" {
+		return dir
+	}
+	// the XDG spec says relative paths are invalid and should be
+	// ignored
+	if dir := os.Getenv(xdgVar); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "grokker")
+	}
+	dir, err := platform()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "grokker")
+}
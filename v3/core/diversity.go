@@ -0,0 +1,30 @@
+package core
+
+// For a broad question, the best scoring chunks can all come from
+// one long document, leaving no room in the context for any other.
+// With Pipeline.MinSources set, the best chunk of each of the first
+// MinSources documents is moved to the front of the ranking, ahead
+// of the other chunks, which keep their order.
+
+// diversify reorders ranked chunks so the first n come from distinct
+// documents, if there are that many.  Near-duplicates of a chunk
+// already moved to the front don't count as a new document.
+func diversify(ranked []*Chunk, n int, threshold float64) (out []*Chunk) {
+	if n <= 1 {
+		return ranked
+	}
+	seen := make(map[string]bool)
+	var seeds, rest []*Chunk
+	var kept [][]float64
+	for _, chunk := range ranked {
+		doc := chunk.Document.RelPath
+		if len(seeds) < n && !seen[doc] && !nearDuplicate(chunk, kept, threshold) {
+			seen[doc] = true
+			seeds = append(seeds, chunk)
+			kept = append(kept, chunk.Embedding)
+			continue
+		}
+		rest = append(rest, chunk)
+	}
+	return append(seeds, rest...)
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestDiversify(t *testing.T) {
+	long := &Document{RelPath: "long.md"}
+	copied := &Document{RelPath: "copy.md"}
+	short := &Document{RelPath: "short.md"}
+	ranked := []*Chunk{
+		{Document: long, Hash: "l1", Embedding: []float64{1, 0, 0}},
+		{Document: long, Hash: "l2", Embedding: []float64{0.9, 0.3, 0}},
+		{Document: copied, Hash: "c1", Embedding: []float64{1, 0, 0}},
+		{Document: long, Hash: "l3", Embedding: []float64{0.8, 0, 0.5}},
+		{Document: short, Hash: "s1", Embedding: []float64{0, 1, 0}},
+	}
+	hashes := func(chunks []*Chunk) (out string) {
+		for _, c := range chunks {
+			out += c.Hash + " "
+		}
+		return
+	}
+	got := hashes(diversify(ranked, 0, defaultDedupThreshold))
+	Tassert(t, got == "l1 l2 c1 l3 s1 ", "unexpected order %q", got)
+	// the copy doesn't count as another source
+	got = hashes(diversify(ranked, 2, defaultDedupThreshold))
+	Tassert(t, got == "l1 s1 l2 c1 l3 ", "unexpected order %q", got)
+	// asking for more documents than there are is fine
+	got = hashes(diversify(ranked, 5, defaultDedupThreshold))
+	Tassert(t, got == "l1 s1 l2 c1 l3 ", "unexpected order %q", got)
+}
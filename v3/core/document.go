@@ -2,6 +2,7 @@ package core
 
 import (
 	"path/filepath"
+	"time"
 
 	"github.com/stevegt/envi"
 	. "github.com/stevegt/goadapt"
@@ -11,12 +12,55 @@ import (
 type Document struct {
 	// XXX deprecated because we weren't precise about what it meant.
 	Path string
-	// The path to the document file, relative to g.Root
+	// The path to the document file, relative to g.Root, or the
+	// name of a virtual document.
 	RelPath string
+	// True if this is a virtual document; see PutDocument().
+	Virtual bool `json:",omitempty"`
+	// The collection the document belongs to; see collection.go.
+	Collection string `json:",omitempty"`
+	// Access tags, e.g. "public" or "secret"; see tags.go.
+	Tags []string `json:",omitempty"`
+	// When the document's embeddings were last brought up to date.
+	Indexed *time.Time `json:",omitempty"`
+	// True if index hooks transformed the content; see hooks.go.
+	Transformed bool `json:",omitempty"`
+	// The sections of the transformed content, if a built-in loader
+	// rendered it; see hooks.go.
+	Sections []*DocSection `json:",omitempty"`
+	// Metadata from the document's frontmatter; see
+	// frontmatter.go.
+	Meta *DocMeta `json:",omitempty"`
+	// The authors of most of the document's lines, most lines
+	// first, from git blame; see owners.go.
+	Authors []string `json:",omitempty"`
+	// The commit the authors were found at.
+	AuthorsAt string `json:",omitempty"`
+	// Added to the similarity score of the document's chunks; see
+	// tune.go.
+	Boost float64 `json:",omitempty"`
+	// Why the document is never used as context, or "" if it may
+	// be; see exclude.go.
+	Excluded string `json:",omitempty"`
+}
+
+// sectionAt returns the section of the document's transformed
+// content that offset is in, or nil if there is none.
+func (doc *Document) sectionAt(offset int) (sec *DocSection) {
+	for _, s := range doc.Sections {
+		if s.Offset > offset {
+			break
+		}
+		sec = s
+	}
+	return
 }
 
 // absPath returns the absolute path of a document.
 func (g *Grokker) absPath(doc *Document) string {
+	if doc.Virtual {
+		return g.virtualPath(doc.RelPath)
+	}
 	return filepath.Join(g.Root, doc.RelPath)
 }
 
@@ -24,9 +68,31 @@ func (g *Grokker) absPath(doc *Document) string {
 // true if the document was updated.
 func (g *Grokker) updateDocument(doc *Document) (updated bool, err error) {
 	defer Return(&err)
+	newChunks, err := g.updateChunks(doc)
+	Ck(err)
+	if len(newChunks) > 0 {
+		updated = true
+	}
+	err = g.embedChunks(embeddable(newChunks))
+	Ck(err)
+	now := time.Now()
+	doc.Indexed = &now
+	return
+}
+
+// updateChunks brings the chunks for a document up to date in the
+// database and returns the chunks that are new.  The new chunks do
+// not have embeddings yet; the caller needs to either call
+// embedChunks() or submit them to a batch job.
+func (g *Grokker) updateChunks(doc *Document) (newChunks []*Chunk, err error) {
+	defer Return(&err)
+	err = g.checkPath(doc.RelPath)
+	Ck(err)
 	// XXX much of this code is inefficient and will be replaced
 	// when we have a kv store.
-	Debug("updating embeddings for %s ...", doc.RelPath)
+	Debug("updating chunks for %s ...", doc.RelPath)
+	err = g.loadShard(g.shardOf(doc.RelPath))
+	Ck(err)
 
 	// mark all existing chunks as stale
 	for _, chunk := range g.Chunks {
@@ -38,9 +104,13 @@ func (g *Grokker) updateDocument(doc *Document) (updated bool, err error) {
 	// break the current doc up into chunks.
 	chunks, err := g.chunksFromDoc(doc)
 	Ck(err)
+	var head string
+	if len(chunks) > 0 {
+		head = chunks[0].text
+	}
+	lang := fileLang(doc.RelPath, head)
 	// For each chunk, ensure it exists in the database with the right
 	// hash, offset, and length.  We'll get embeddings later.
-	var newChunks []*Chunk
 	for _, chunk := range chunks {
 		if envi.Bool("DEBUG", false) {
 			// verify chunk text length
@@ -51,12 +121,17 @@ func (g *Grokker) updateDocument(doc *Document) (updated bool, err error) {
 			tc := len(tokens)
 			Assert(tc < g.EmbeddingTokenLimit, "chunk tokens %d exceeds limit %d: %v", tc, g.EmbeddingTokenLimit, chunk)
 		}
+		chunk.Symbols = chunkSymbols(chunk)
+		chunk.Langs = chunkLangs(lang, chunk.text)
+		// keep boilerplate out of the context
+		if !g.StopAllow[chunk.Hash] {
+			chunk.Excluded = boilerplate(doc.RelPath, chunk.text)
+		}
 		// setChunk unsets the stale bit if the chunk is already in the
 		// database.
 		// XXX move the stale bit unset to this loop instead, for readability.
 		newChunk := g.setChunk(chunk)
 		if newChunk != nil {
-			updated = true
 			newChunks = append(newChunks, newChunk)
 		}
 	}
@@ -75,12 +150,51 @@ func (g *Grokker) updateDocument(doc *Document) (updated bool, err error) {
 		}
 	}
 
+	return
+}
+
+// embeddable returns the chunks that are not on the stop-list.
+func embeddable(chunks []*Chunk) (out []*Chunk) {
+	for _, c := range chunks {
+		if c.Excluded == "" {
+			out = append(out, c)
+		}
+	}
+	return
+}
+
+// embedStored generates and stores embeddings for chunks that are
+// already in the database, reading their text from the documents.
+func (g *Grokker) embedStored(chunks []*Chunk) (err error) {
+	defer Return(&err)
+	if len(chunks) == 0 {
+		return
+	}
+	var texts []string
+	for _, chunk := range chunks {
+		text, err := g.chunkText(chunk, true, false)
+		Ck(err)
+		texts = append(texts, text)
+	}
+	embeddings, err := g.createEmbeddings(texts)
+	Ck(err)
+	Assert(len(embeddings) == len(chunks), "expected %d embeddings, got %d", len(chunks), len(embeddings))
+	for i, chunk := range chunks {
+		chunk.Embedding = embeddings[i]
+	}
+	err = g.updateVectors()
+	Ck(err)
+	return
+}
+
+// embedChunks generates and stores embeddings for the given chunks.
+func (g *Grokker) embedChunks(newChunks []*Chunk) (err error) {
+	defer Return(&err)
 	// For each new chunk, generate an embedding using the
 	// openai.Embedding.create() function. Store the embeddings for each
 	// chunk in a data structure such as a list or dictionary.
 	var newChunkStrings []string
 	for _, chunk := range newChunks {
-		Assert(chunk.Document.RelPath == doc.RelPath, "chunk document does not match")
 		Assert(len(chunk.text) > 0, "chunk text is empty")
 		Assert(chunk.Embedding == nil, "chunk embedding is not nil")
 		Assert(chunk.stale == false, "chunk is stale")
@@ -89,10 +203,15 @@ func (g *Grokker) updateDocument(doc *Document) (updated bool, err error) {
 		Ck(err)
 		newChunkStrings = append(newChunkStrings, text)
 	}
+	provider, _ := g.embedderID()
+	err = g.checkProvider(provider, newChunks)
+	Ck(err)
 	embeddings, err := g.createEmbeddings(newChunkStrings)
 	Ck(err)
 	for i, chunk := range newChunks {
 		chunk.Embedding = embeddings[i]
 	}
+	err = g.updateVectors()
+	Ck(err)
 	return
 }
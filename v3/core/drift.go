@@ -0,0 +1,125 @@
+package core
+
+import (
+	"math/rand"
+	"sort"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A hosted embedding model can change behind the same name.  When it
+// does, new queries are embedded into a slightly different space
+// than the stored chunks, and retrieval quietly gets worse.  'grok
+// drift' re-embeds a random sample of chunks whose documents haven't
+// changed and compares the fresh embeddings with the stored ones; a
+// healthy index scores close to 1.  Only the sample is re-embedded,
+// and nothing is saved.
+
+// defaultDriftThreshold is the similarity below which a chunk is
+// reported as drifted when Drift is given no threshold.
+const defaultDriftThreshold = 0.99
+
+// DriftedChunk is a sampled chunk whose fresh embedding is less
+// similar to its stored embedding than the threshold.
+type DriftedChunk struct {
+	RelPath    string
+	Offset     int
+	Similarity float64
+}
+
+// DriftReport is the outcome of Drift.
+type DriftReport struct {
+	Provider string
+	Model    string
+	// Sampled is the number of chunks re-embedded.
+	Sampled int
+	// Skipped is the number of sampled chunks whose documents
+	// changed or disappeared since they were embedded.
+	Skipped   int
+	Mean      float64
+	Min       float64
+	Threshold float64
+	// Drifted is sorted by similarity, lowest first.
+	Drifted []DriftedChunk
+}
+
+// Degraded returns true if the mean similarity of the sample is below
+// the threshold, i.e. the index should be re-embedded.
+func (r *DriftReport) Degraded() bool {
+	return r.Sampled > 0 && r.Mean < r.Threshold
+}
+
+// Drift re-embeds up to sample chunks with the current embedder and
+// reports how similar the results are to the stored embeddings.
+func (g *Grokker) Drift(sample int, threshold float64) (report *DriftReport, err error) {
+	defer Return(&err)
+	err = g.checkEmbedder()
+	Ck(err)
+	if threshold <= 0 {
+		threshold = defaultDriftThreshold
+	}
+	report = &DriftReport{
+		Provider:  g.EmbeddingProvider,
+		Model:     g.EmbeddingModel,
+		Threshold: threshold,
+	}
+	var pool []*Chunk
+	for _, chunk := range g.Chunks {
+		if chunk.Embedding != nil && chunk.Document != nil {
+			pool = append(pool, chunk)
+		}
+	}
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	if sample > 0 && len(pool) > sample {
+		pool = pool[:sample]
+	}
+	var chunks []*Chunk
+	var texts []string
+	for _, chunk := range pool {
+		text, err := g.chunkText(chunk, true, false)
+		Ck(err)
+		if !chunk.matches(text) {
+			report.Skipped++
+			continue
+		}
+		chunks = append(chunks, chunk)
+		texts = append(texts, text)
+	}
+	if len(texts) == 0 {
+		return
+	}
+	// skip the embedding cache, which would hand back the stored
+	// embeddings
+	embeddings, err := g.fetchEmbeddings(texts)
+	Ck(err)
+	Assert(len(embeddings) == len(chunks), "expected %d embeddings, got %d", len(chunks), len(embeddings))
+	var total float64
+	report.Min = 1
+	for i, chunk := range chunks {
+		if embeddings[i] == nil {
+			report.Skipped++
+			continue
+		}
+		sim := util.Similarity(chunk.Embedding, embeddings[i])
+		report.Sampled++
+		total += sim
+		if sim < report.Min {
+			report.Min = sim
+		}
+		if sim < threshold {
+			report.Drifted = append(report.Drifted, DriftedChunk{
+				RelPath:    chunk.Document.RelPath,
+				Offset:     chunk.Offset,
+				Similarity: sim,
+			})
+		}
+	}
+	if report.Sampled > 0 {
+		report.Mean = total / float64(report.Sampled)
+	}
+	sort.SliceStable(report.Drifted, func(i, j int) bool {
+		return report.Drifted[i].Similarity < report.Drifted[j].Similarity
+	})
+	return
+}
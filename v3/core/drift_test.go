@@ -0,0 +1,55 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// driftEmbedder embeds every text as the same vector.
+type driftEmbedder struct {
+	vec []float64
+}
+
+func (e *driftEmbedder) embed(texts []string) (embeddings [][]float64, err error) {
+	for range texts {
+		embeddings = append(embeddings, e.vec)
+	}
+	return
+}
+
+func (e *driftEmbedder) spec() string {
+	return "onnx:/models/drift"
+}
+
+func TestDrift(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello world"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "a.md"}
+	same := newChunk(doc, "", 0, 5, "hello")
+	same.Embedding = []float64{1, 0}
+	moved := newChunk(doc, "", 6, 5, "world")
+	moved.Embedding = []float64{0.6, 0.8}
+	changed := newChunk(doc, "", 0, 5, "howdy")
+	changed.Embedding = []float64{1, 0}
+	grok.Chunks = []*Chunk{same, moved, changed}
+	grok.embedder = &driftEmbedder{vec: []float64{1, 0}}
+	grok.EmbeddingProvider = "onnx"
+	grok.EmbeddingModel = "drift"
+
+	report, err := grok.Drift(10, 0)
+	Tassert(t, err == nil, "error checking drift: %v", err)
+	Tassert(t, report.Sampled == 2 && report.Skipped == 1, "expected 2 sampled and 1 skipped, got %d and %d", report.Sampled, report.Skipped)
+	Tassert(t, len(report.Drifted) == 1 && report.Drifted[0].Offset == 6, "expected the moved chunk to drift: %v", report.Drifted)
+	Tassert(t, report.Min > 0.59 && report.Min < 0.61, "unexpected min %f", report.Min)
+	Tassert(t, report.Degraded(), "expected a degraded index, mean %f", report.Mean)
+
+	report, err = grok.Drift(10, 0.5)
+	Tassert(t, err == nil, "error checking drift: %v", err)
+	Tassert(t, !report.Degraded() && len(report.Drifted) == 0, "expected no drift above 0.5: %v", report.Drifted)
+}
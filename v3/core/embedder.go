@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// localEmbedder creates embeddings on this machine instead of
+// calling a hosted API, so indexing is free and works offline.
+type localEmbedder interface {
+	// embed returns one embedding per text.
+	embed(texts []string) ([][]float64, error)
+	// spec returns the GROKKER_EMBEDDER value the embedder was
+	// created from.
+	spec() string
+}
+
+// Embedder creates embeddings with a model chosen by a program that
+// embeds grokker, so it can index and search without provider
+// credentials; see SetEmbedder and package retrieval.
+type Embedder interface {
+	// Embed returns one embedding per text.
+	Embed(texts []string) ([][]float64, error)
+	// Name identifies the model as "provider:model", e.g.
+	// "acme:e5-large".  A knowledge base remembers the name of the
+	// model that embedded it and won't be searched with another.
+	Name() string
+}
+
+// customEmbedder adapts an Embedder set with SetEmbedder.
+type customEmbedder struct {
+	e          Embedder
+	tokenLimit int
+}
+
+func (c *customEmbedder) embed(texts []string) ([][]float64, error) {
+	return c.e.Embed(texts)
+}
+
+func (c *customEmbedder) spec() string {
+	return c.e.Name()
+}
+
+// SetEmbedder makes e create all embeddings from now on, in place of
+// GROKKER_EMBEDDER or the OpenAI API.  Documents are split into
+// chunks of at most tokenLimit tokens, as counted by TokenCount, so
+// that each fits in the model's input.
+func (g *Grokker) SetEmbedder(e Embedder, tokenLimit int) {
+	g.embedder = &customEmbedder{e, tokenLimit}
+	g.EmbeddingTokenLimit = tokenLimit
+}
+
+// The embedding model used when GROKKER_EMBEDDER is empty.
+const (
+	openaiEmbeddingProvider = "openai"
+	openaiEmbeddingModel    = "text-embedding-ada-002"
+)
+
+// localTokenLimit is the chunk size we use with local embedders.
+// Small BERT-style models accept at most 512 WordPiece tokens, and
+// WordPiece produces more tokens than cl100k for most text, so we
+// leave plenty of headroom.
+const localTokenLimit = 256
+
+// initEmbedder selects the embedding backend based on the
+// GROKKER_EMBEDDER environment variable.  If it is empty, embeddings
+// come from the OpenAI API.  If it is "onnx:<dir>", embeddings come
+// from a local ONNX model; <dir> must contain model.onnx and
+// vocab.txt, e.g. as exported from all-MiniLM-L6-v2 or bge-small-en.
+// Local embeddings require building with '-tags onnx' and the
+// ONNX runtime shared library; see ONNXRUNTIME_LIB.
+//
+// This function needs to be idempotent because it might be called
+// multiple times during the lifetime of a Grokker object.
+func (g *Grokker) initEmbedder() (err error) {
+	defer Return(&err)
+	if c, ok := g.embedder.(*customEmbedder); ok {
+		// set by the program; see SetEmbedder
+		g.EmbeddingTokenLimit = c.tokenLimit
+		return
+	}
+	spec := os.Getenv("GROKKER_EMBEDDER")
+	if spec == "" {
+		g.embedder = nil
+		return
+	}
+	g.EmbeddingTokenLimit = localTokenLimit
+	if g.embedder != nil && g.embedder.spec() == spec {
+		return
+	}
+	g.embedder, err = newEmbedder(spec)
+	Ck(err)
+	return
+}
+
+// newEmbedder creates a local embedder from a spec such as
+// "onnx:<dir>".
+func newEmbedder(spec string) (e localEmbedder, err error) {
+	defer Return(&err)
+	kind, dir, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "onnx":
+		Assert(dir != "", "%s: onnx:<dir> requires a model directory", spec)
+		e, err = newOnnxEmbedder(spec, dir)
+		Ck(err)
+	default:
+		err = fmt.Errorf("unknown embedder %q", spec)
+	}
+	return
+}
+
+// meanPool averages the token vectors of a [seq, dim] hidden state
+// over the tokens where mask is set, then normalizes the result to
+// unit length.
+func meanPool(hidden []float32, mask []int64, dim int) (vec []float64) {
+	vec = make([]float64, dim)
+	var n float64
+	for i, m := range mask {
+		if m == 0 {
+			continue
+		}
+		n++
+		for j := 0; j < dim; j++ {
+			vec[j] += float64(hidden[i*dim+j])
+		}
+	}
+	var norm float64
+	for j := range vec {
+		if n > 0 {
+			vec[j] /= n
+		}
+		norm += vec[j] * vec[j]
+	}
+	norm = math.Sqrt(norm)
+	if norm > 0 {
+		for j := range vec {
+			vec[j] /= norm
+		}
+	}
+	return
+}
+
+// embedderID returns the provider and model name of the current
+// embedder.
+func (g *Grokker) embedderID() (provider, model string) {
+	if g.embedder == nil {
+		return openaiEmbeddingProvider, openaiEmbeddingModel
+	}
+	provider, dir, _ := strings.Cut(g.embedder.spec(), ":")
+	return provider, filepath.Base(dir)
+}
+
+// checkEmbedder verifies that the current embedder is the one that
+// created the embeddings already in the database.  Embeddings from
+// different models live in unrelated vector spaces, so comparing them
+// produces meaningless similarity scores.  A database that predates
+// this check and already has embeddings is assumed to have been
+// embedded by OpenAI.  An empty database adopts the current embedder.
+func (g *Grokker) checkEmbedder() (err error) {
+	provider, model := g.embedderID()
+	if g.EmbeddingProvider == "" {
+		for _, chunk := range g.Chunks {
+			if chunk.Embedding != nil {
+				g.EmbeddingProvider = openaiEmbeddingProvider
+				g.EmbeddingModel = openaiEmbeddingModel
+				g.EmbeddingDim = len(chunk.Embedding)
+				break
+			}
+		}
+	}
+	if g.EmbeddingProvider == "" {
+		g.EmbeddingProvider = provider
+		g.EmbeddingModel = model
+		return
+	}
+	if g.EmbeddingProvider != provider || g.EmbeddingModel != model {
+		err = fmt.Errorf("the knowledge base was embedded with %s:%s, but the current embedder is %s:%s; "+
+			"fix GROKKER_EMBEDDER or run 'grok refresh --reembed'",
+			g.EmbeddingProvider, g.EmbeddingModel, provider, model)
+	}
+	return
+}
+
+// checkDims verifies that all embeddings have the same dimension as
+// those already in the database, recording the dimension if this is
+// the first embedding.
+func (g *Grokker) checkDims(embeddings [][]float64) (err error) {
+	for _, em := range embeddings {
+		if em == nil {
+			continue
+		}
+		if g.EmbeddingDim == 0 {
+			g.EmbeddingDim = len(em)
+		}
+		if len(em) != g.EmbeddingDim {
+			err = fmt.Errorf("embedding dimension %d does not match the knowledge base dimension %d", len(em), g.EmbeddingDim)
+			return
+		}
+	}
+	return
+}
+
+// ReEmbed discards all chunks and embeddings and re-creates them
+// with the current embedder.  Use it after changing
+// GROKKER_EMBEDDER.
+func (g *Grokker) ReEmbed() (err error) {
+	defer Return(&err)
+	g.Chunks = nil
+	g.Batches = nil
+	g.EmbeddingProvider, g.EmbeddingModel = g.embedderID()
+	g.EmbeddingDim = 0
+	err = g.RefreshEmbeddings()
+	Ck(err)
+	return
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestCheckEmbedder(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+
+	// an empty db adopts the current embedder
+	err = grok.checkEmbedder()
+	Tassert(t, err == nil, "unexpected error: %v", err)
+	Tassert(t, grok.EmbeddingProvider == "openai", "unexpected provider %q", grok.EmbeddingProvider)
+
+	// the first embedding sets the dimension
+	err = grok.checkDims([][]float64{nil, {1, 2, 3}})
+	Tassert(t, err == nil, "unexpected error: %v", err)
+	Tassert(t, grok.EmbeddingDim == 3, "unexpected dimension %d", grok.EmbeddingDim)
+	err = grok.checkDims([][]float64{{1, 2}})
+	Tassert(t, err != nil, "expected dimension mismatch error")
+
+	// a db embedded by a different model is rejected
+	grok.EmbeddingProvider = "onnx"
+	grok.EmbeddingModel = "all-MiniLM-L6-v2"
+	err = grok.checkEmbedder()
+	Tassert(t, err != nil, "expected embedder mismatch error")
+
+	// a legacy db with embeddings is assumed to be OpenAI
+	grok.EmbeddingProvider = ""
+	grok.EmbeddingModel = ""
+	grok.EmbeddingDim = 0
+	grok.Chunks = []*Chunk{{Embedding: make([]float64, 1536)}}
+	err = grok.checkEmbedder()
+	Tassert(t, err == nil, "unexpected error: %v", err)
+	Tassert(t, grok.EmbeddingDim == 1536, "unexpected dimension %d", grok.EmbeddingDim)
+}
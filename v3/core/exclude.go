@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Some documents belong in the knowledge base but not in answers:
+// an archived design, or a page known to be wrong.  Forgetting such
+// a document drops it from the index, and .grokignore keeps it out,
+// so instead it can be excluded, e.g.
+//
+//	grok exclude add --reason "superseded by docs/v2" docs/v1.md
+//
+// An excluded document stays listed and indexed, and its chunks keep
+// their embeddings, but retrieval drops them, so it is never used as
+// context, whatever the filter or the router picks.  Including it
+// again needs no re-embedding.  The reason is kept in the db, so
+// everyone who shares it excludes the same documents.
+
+// defaultExcludeReason is the reason recorded when none is given.
+const defaultExcludeReason = "excluded"
+
+// ExcludeDocument marks a document as never to be used as context,
+// for the given reason, e.g. "archived".
+func (g *Grokker) ExcludeDocument(path, reason string) (err error) {
+	defer Return(&err)
+	doc, err := g.lookupDocument(path)
+	Ck(err)
+	if reason == "" {
+		reason = defaultExcludeReason
+	}
+	doc.Excluded = reason
+	return
+}
+
+// IncludeDocument lets an excluded document be used as context
+// again.
+func (g *Grokker) IncludeDocument(path string) (err error) {
+	defer Return(&err)
+	doc, err := g.lookupDocument(path)
+	Ck(err)
+	if doc.Excluded == "" {
+		err = fmt.Errorf("%s is not excluded", doc.RelPath)
+		return
+	}
+	doc.Excluded = ""
+	return
+}
+
+// ExcludedDocuments returns the excluded documents, in the order
+// they were added.
+func (g *Grokker) ExcludedDocuments() (docs []*Document) {
+	for _, doc := range g.Documents {
+		if doc.Excluded != "" {
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+// lookupDocument returns the document with the given path, relative
+// to g.Root or not, or the given virtual document name.
+func (g *Grokker) lookupDocument(path string) (doc *Document, err error) {
+	defer Return(&err)
+	for _, d := range g.Documents {
+		if d.RelPath == path {
+			return d, nil
+		}
+	}
+	absPath, err := filepath.Abs(path)
+	Ck(err)
+	for _, d := range g.Documents {
+		if g.absPath(d) == absPath {
+			return d, nil
+		}
+	}
+	err = fmt.Errorf("%s is not in the knowledge base", path)
+	return
+}
+
+// includedChunks drops the chunks of excluded documents.
+func includedChunks(chunks []*Chunk) (out []*Chunk) {
+	for _, c := range chunks {
+		if c.Document != nil && c.Document.Excluded != "" {
+			continue
+		}
+		out = append(out, c)
+	}
+	return
+}
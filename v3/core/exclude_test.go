@@ -0,0 +1,64 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestExclude(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	for name, content := range map[string]string{
+		"current.md": "Widgets are blue.\n",
+		"old.md":     "Widgets used to be red.\n",
+	} {
+		path := filepath.Join(dir, name)
+		err = os.WriteFile(path, []byte(content), 0644)
+		Ck(err)
+		err = grok.AddDocument(path)
+		Ck(err)
+	}
+
+	context := func() string {
+		chunks, err := grok.findChunks("what color are widgets?", 2000, nil)
+		Tassert(t, err == nil, "error finding chunks: %v", err)
+		var paths []string
+		for _, c := range chunks {
+			paths = append(paths, c.Document.RelPath)
+		}
+		return strings.Join(paths, " ")
+	}
+	Tassert(t, strings.Contains(context(), "old.md"), "expected old.md in the context")
+
+	err = grok.ExcludeDocument(filepath.Join(dir, "old.md"), "")
+	Tassert(t, err == nil, "error excluding: %v", err)
+	got := context()
+	Tassert(t, got == "current.md", "expected only current.md in the context, got %q", got)
+	excluded := grok.ExcludedDocuments()
+	Tassert(t, len(excluded) == 1 && excluded[0].RelPath == "old.md" && excluded[0].Excluded == defaultExcludeReason, "got %v", excluded)
+	// still listed and indexed
+	Tassert(t, strings.Contains(strings.Join(grok.ListDocuments(), " "), "old.md"), "expected old.md to stay listed")
+	var kept int
+	for _, c := range grok.Chunks {
+		if c.Document.RelPath == "old.md" && c.Embedding != nil {
+			kept++
+		}
+	}
+	Tassert(t, kept > 0, "expected old.md to keep its embeddings")
+
+	err = grok.IncludeDocument("old.md")
+	Tassert(t, err == nil, "error including: %v", err)
+	Tassert(t, strings.Contains(context(), "old.md"), "expected old.md in the context again")
+	err = grok.IncludeDocument("old.md")
+	Tassert(t, err != nil, "expected an error including a document that isn't excluded")
+	err = grok.ExcludeDocument("missing.md", "archived")
+	Tassert(t, err != nil, "expected an error excluding a missing document")
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+
+	oai "github.com/sashabaranov/go-openai"
+)
+
+// Failure classes let scripts and CI pipelines tell apart the
+// failures they handle differently, e.g. retrying later when rate
+// limited but failing the build when there is no knowledge base.
+// See FailureClass.
+const (
+	// FailNoDB means there is no knowledge base to use.
+	FailNoDB = "no_db"
+	// FailRateLimited means a provider refused a request because
+	// of its rate limits or quota.
+	FailRateLimited = "rate_limited"
+	// FailBudget means a request is larger than the model's token
+	// limit or the policy's max_bytes.
+	FailBudget = "budget_exceeded"
+)
+
+// classError is an error in a failure class.
+type classError struct {
+	class string
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() error {
+	return e.err
+}
+
+// classify puts err in a failure class.
+func classify(class string, err error) error {
+	return &classError{class, err}
+}
+
+// FailureClass returns the failure class of err, or "" if it isn't in
+// one.
+func FailureClass(err error) string {
+	var ce *classError
+	if errors.As(err, &ce) {
+		return ce.class
+	}
+	var apiErr *oai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode == http.StatusTooManyRequests {
+		return FailRateLimited
+	}
+	var reqErr *oai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode == http.StatusTooManyRequests {
+		return FailRateLimited
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	oai "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+func TestFailureClass(t *testing.T) {
+	Tassert(t, FailureClass(fmt.Errorf("boom")) == "", "expected no class")
+	Tassert(t, FailureClass(&oai.APIError{HTTPStatusCode: 429}) == FailRateLimited, "expected rate limited")
+	Tassert(t, FailureClass(&oai.APIError{HTTPStatusCode: 500}) == "", "expected no class for a server error")
+	wrapped := func() (err error) {
+		defer Return(&err)
+		Ck(&oai.RequestError{HTTPStatusCode: 429, Err: fmt.Errorf("slow down")})
+		return
+	}()
+	Tassert(t, FailureClass(wrapped) == FailRateLimited, "expected rate limited through goadapt, got %q", FailureClass(wrapped))
+
+	// no knowledge base
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "config"))
+	cwd, err := os.Getwd()
+	Ck(err)
+	err = os.Chdir(dir)
+	Ck(err)
+	defer os.Chdir(cwd)
+	_, err = FindDB("")
+	Tassert(t, FailureClass(err) == FailNoDB, "expected no db, got %v", err)
+	_, err = FindDB("nosuch")
+	Tassert(t, FailureClass(err) == FailNoDB, "expected no db, got %v", err)
+
+	// a request over the token limit
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	_, err = grok.msg("", strings.Repeat("word ", grok.TokenLimit+1))
+	Tassert(t, FailureClass(err) == FailBudget, "expected budget exceeded, got %v", err)
+
+	// an answer without sources is low-confidence
+	Tassert(t, grok.LowConfidence(0) == "no sources", "got %q", grok.LowConfidence(0))
+}
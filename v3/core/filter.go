@@ -0,0 +1,137 @@
+package core
+
+import (
+	"path"
+	"path/filepath"
+)
+
+// Filter restricts which chunks a query can use as context.  The
+// zero value allows all chunks.  Conditions are ANDed; the values
+// within a condition are ORed.
+type Filter struct {
+	// Symbols limits context to chunks that define or mention at
+	// least one of these symbols.
+	Symbols []string
+	// Collections limits context to documents in these
+	// collections.  If empty, the pipeline's router may pick
+	// collections; see Pipeline.Router.
+	Collections []string
+	// Tags limits context to documents whose access tags are all
+	// among these tags; untagged documents always pass.  See
+	// tags.go.
+	Tags []string
+	// Labels limits context to documents with one of these tags
+	// in their frontmatter, and Owners to documents with one of
+	// these owners.  See frontmatter.go.
+	Labels []string
+	Owners []string
+	// Langs limits context to chunks in one of these languages,
+	// e.g. "go" or "markdown", or natural languages such as "de";
+	// see chunklang.go.
+	Langs []string
+	// Paths limits context to documents under these paths, which
+	// are relative to the root.  Only the shards that hold such
+	// documents are loaded; see shard.go.
+	Paths []string
+}
+
+// SetFilter sets the filter used by subsequent queries.  Pass nil to
+// remove the filter.  The filter is not stored in the database.
+func (g *Grokker) SetFilter(f *Filter) {
+	g.filter = f
+}
+
+// SetContextLimits limits subsequent queries to at most k chunks of
+// context, and answers to contextTokens tokens of context instead of
+// half the model's token limit.  Zero leaves a limit at its default.
+// Like the filter, the limits are not stored in the database.
+func (g *Grokker) SetContextLimits(k, contextTokens int) {
+	g.maxChunks = k
+	g.contextTokens = contextTokens
+}
+
+// apply returns the chunks that pass the filter.
+func (f *Filter) apply(g *Grokker, chunks []*Chunk) (out []*Chunk) {
+	if f == nil {
+		return chunks
+	}
+	colls := g.docCollections()
+	tags := g.docTags()
+	metas := g.docMeta()
+	for _, c := range chunks {
+		relpath := c.Document.RelPath
+		if f.match(c, colls) && f.cleared(tags[relpath]) && f.labeled(metas[relpath]) {
+			out = append(out, c)
+		}
+	}
+	return
+}
+
+// paths returns the filter's paths; a nil filter has none.
+func (f *Filter) paths() []string {
+	if f == nil {
+		return nil
+	}
+	return f.Paths
+}
+
+// match returns true if the chunk passes the filter.  colls maps
+// document paths to collection names.  A nil filter matches
+// everything.
+func (f *Filter) match(c *Chunk, colls map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Symbols) > 0 {
+		found := false
+		for _, sym := range f.Symbols {
+			if c.hasSymbol(sym) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Langs) > 0 {
+		found := false
+		for _, lang := range c.langs() {
+			for _, want := range f.Langs {
+				if NormalizeLang(want) == lang {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Paths) > 0 {
+		found := false
+		for _, p := range f.Paths {
+			p = path.Clean(filepath.ToSlash(p))
+			if p == "." || underPath(c.Document.RelPath, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Collections) > 0 {
+		coll := colls[c.Document.RelPath]
+		found := false
+		for _, name := range f.Collections {
+			if name == coll {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
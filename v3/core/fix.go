@@ -0,0 +1,144 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Given a compiler or runtime error, Fix finds the files the error
+// names in the knowledge base, e.g. main.go in "main.go:12:5:
+// undefined: foo", and asks the model for a diagnosis and a patch.
+// Half the context comes from the named files and half from the rest
+// of the knowledge base, so the model also sees the code the named
+// files call.  The result can be handed to aidda to apply.
+
+const fixSysmsg = "You diagnose errors in software projects.  Given an error message and source code from the project, explain the cause of the error in a few sentences, then give a fix as a unified diff of the files shown, in a ```diff block.  If the source shown isn't enough to fix the error, say what else you need instead of guessing."
+
+// Fix is a diagnosis of an error and a suggested patch.
+type Fix struct {
+	// Diagnosis is the model's explanation of the error.
+	Diagnosis string
+	// Patch is the suggested fix as a unified diff, or empty if
+	// the model didn't suggest one.
+	Patch string
+	// Files are the documents the error names.
+	Files []string
+}
+
+// errorPathRe matches the file locations in common error formats:
+// "path:line", as in Go, C, Rust, and JavaScript stack traces, and
+// 'File "path", line N', as in Python tracebacks.
+var errorPathRe = regexp.MustCompile(`([\w.~/\\-]+\.\w+)(?::\d+|", line \d+)`)
+
+// diffBlockRe matches a fenced diff in a response.
+var diffBlockRe = regexp.MustCompile("(?s)```(?:diff|patch)?\\s*\\n(.*?)```")
+
+// implicatedFiles returns the documents named in an error message,
+// in the order they are first named.
+func (g *Grokker) implicatedFiles(errText string) (files []string) {
+	for _, m := range errorPathRe.FindAllStringSubmatch(errText, -1) {
+		path := filepath.ToSlash(m[1])
+		if filepath.IsAbs(m[1]) {
+			rel, err := filepath.Rel(g.Root, m[1])
+			if err == nil {
+				path = filepath.ToSlash(rel)
+			}
+		}
+		path = strings.TrimPrefix(path, "./")
+		for _, doc := range g.Documents {
+			relpath := doc.RelPath
+			// the error may name the file relative to a
+			// subdirectory, or with a longer path
+			if relpath == path || strings.HasSuffix(relpath, "/"+path) || strings.HasSuffix(path, "/"+relpath) {
+				if !util.StringInSlice(relpath, files) {
+					files = append(files, relpath)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Fix diagnoses an error message and suggests a patch.
+func (g *Grokker) Fix(errText string) (fix *Fix, err error) {
+	defer Return(&err)
+	errText = strings.TrimSpace(errText)
+	if errText == "" {
+		err = fmt.Errorf("no error message to diagnose")
+		return
+	}
+	fix = &Fix{Files: g.implicatedFiles(errText)}
+	qtokens, err := g.tokens(errText)
+	Ck(err)
+	maxTokens := int(float64(g.TokenLimit)*0.5) - len(qtokens)
+	if g.contextTokens > 0 {
+		maxTokens = g.contextTokens
+	}
+	context, err := g.focusedContext(errText, fix.Files, maxTokens)
+	Ck(err)
+
+	input := Spf("Source:\n\n%s\n\nError:\n\n%s", context, errText)
+	resp, err := g.msg(fixSysmsg, input)
+	Ck(err)
+	fix.Diagnosis, fix.Patch = parseFix(resp.Choices[0].Message.Content)
+	return
+}
+
+// focusedContext returns up to maxTokens of context for query, half
+// from the given files and half from the rest of the knowledge base,
+// and records its sources as the last query's.
+func (g *Grokker) focusedContext(query string, files []string, maxTokens int) (context string, err error) {
+	defer Return(&err)
+	var sources, ids []string
+	if len(files) > 0 {
+		context, err = g.getContext(query, maxTokens/2, true, true, files)
+		Ck(err)
+		maxTokens -= maxTokens / 2
+		sources = g.sources
+		ids = g.sourceIDs
+	}
+	// the rest of the knowledge base
+	var others []string
+	for _, doc := range g.Documents {
+		if !util.StringInSlice(doc.RelPath, files) {
+			others = append(others, doc.RelPath)
+		}
+	}
+	g.sources = nil
+	g.sourceIDs = nil
+	if len(others) > 0 {
+		more, err := g.getContext(query, maxTokens, true, true, others)
+		Ck(err)
+		context += more
+	}
+	for _, cite := range g.sources {
+		if !util.StringInSlice(cite, sources) {
+			sources = append(sources, cite)
+		}
+	}
+	for _, id := range g.sourceIDs {
+		if !util.StringInSlice(id, ids) {
+			ids = append(ids, id)
+		}
+	}
+	g.sources = sources
+	g.sourceIDs = ids
+	g.context = context
+	return
+}
+
+// parseFix splits a response into the diagnosis and the patch.
+func parseFix(resp string) (diagnosis, patch string) {
+	loc := diffBlockRe.FindStringSubmatchIndex(resp)
+	if loc == nil {
+		return strings.TrimSpace(resp), ""
+	}
+	patch = resp[loc[2]:loc[3]]
+	diagnosis = strings.TrimSpace(strings.TrimSpace(resp[:loc[0]]) + "\n\n" + strings.TrimSpace(resp[loc[1]:]))
+	return
+}
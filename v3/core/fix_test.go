@@ -0,0 +1,38 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestImplicatedFiles(t *testing.T) {
+	grok := &Grokker{Root: "/src/app"}
+	for _, relpath := range []string{"main.go", "core/db.go", "web/app.js", "tools/run.py", "README.md"} {
+		grok.Documents = append(grok.Documents, &Document{RelPath: relpath})
+	}
+	errText := `# example.com/app/core
+core/db.go:12:5: undefined: conn
+./main.go:7:2: imported and not used: "os"
+    at render (/src/app/web/app.js:40:11)
+  File "/src/app/tools/run.py", line 3, in <module>
+core/db.go:30:1: missing return`
+	got := grok.implicatedFiles(errText)
+	want := []string{"core/db.go", "main.go", "web/app.js", "tools/run.py"}
+	Tassert(t, strings.Join(got, " ") == strings.Join(want, " "), "expected %v, got %v", want, got)
+
+	// errors from a subdirectory name files relative to it
+	got = grok.implicatedFiles("db.go:12:5: undefined: conn")
+	Tassert(t, len(got) == 1 && got[0] == "core/db.go", "unexpected files %v", got)
+}
+
+func TestParseFix(t *testing.T) {
+	resp := "conn is never declared.\n\n```diff\n--- a/core/db.go\n+++ b/core/db.go\n@@ -1 +1,2 @@\n+var conn *DB\n```\n\nRebuild afterwards."
+	diagnosis, patch := parseFix(resp)
+	Tassert(t, strings.HasPrefix(patch, "--- a/core/db.go\n") && strings.HasSuffix(patch, "+var conn *DB\n"), "unexpected patch %q", patch)
+	Tassert(t, diagnosis == "conn is never declared.\n\nRebuild afterwards.", "unexpected diagnosis %q", diagnosis)
+
+	diagnosis, patch = parseFix("I need to see db.go.")
+	Tassert(t, patch == "" && diagnosis == "I need to see db.go.", "unexpected fix %q %q", diagnosis, patch)
+}
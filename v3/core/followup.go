@@ -0,0 +1,43 @@
+package core
+
+import (
+	. "github.com/stevegt/goadapt"
+)
+
+// After answering, Answer can suggest follow-up questions that the
+// same context could answer, to help users explore the knowledge
+// base.  The suggestions cost one more request, so they're off
+// unless turned on with SetFollowUps.
+
+// DefaultFollowUps is the usual number of follow-up questions to
+// suggest.
+const DefaultFollowUps = 3
+
+const followUpSysmsg = "You suggest follow-up questions.  Given some context, a question, and its answer, reply with up to %d short questions the user might ask next that the context can answer, one per line and nothing else.  Don't repeat the question."
+
+// SetFollowUps sets the number of follow-up questions suggested
+// after each answer; 0 turns the suggestions off.  See FollowUps.
+func (g *Grokker) SetFollowUps(n int) {
+	g.followUpCount = n
+}
+
+// FollowUps returns the follow-up questions suggested after the most
+// recent answer, if any.
+func (g *Grokker) FollowUps() []string {
+	return g.followUps
+}
+
+// suggestFollowUps asks the model for follow-up questions grounded
+// in the context of the most recent answer.
+func (g *Grokker) suggestFollowUps(question, answer string) (err error) {
+	defer Return(&err)
+	g.followUps = nil
+	if g.followUpCount <= 0 {
+		return
+	}
+	input := Spf("Context:\n\n%s\n\nQuestion: %s\n\nAnswer: %s", g.context, question, answer)
+	resp, err := g.msg(g.withLang(Spf(followUpSysmsg, g.followUpCount)), input)
+	Ck(err)
+	g.followUps = parseList(resp.Choices[0].Message.Content, g.followUpCount)
+	return
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"gopkg.in/yaml.v3"
+)
+
+// Markdown documents often start with YAML frontmatter:
+//
+//	---
+//	title: Restarting the ingest service
+//	tags: [runbook, ingest]
+//	owner: sre
+//	date: 2024-03-01
+//	---
+//
+// The title, tags, owner, and date are kept as the document's
+// metadata each time it is chunked, and a query can be limited to
+// documents with given labels (the frontmatter tags) or owners; see
+// Filter.  Frontmatter tags are labels for finding documents, unlike
+// access tags, which limit who can see them; see tags.go.
+
+// markdownExts are the extensions of documents whose frontmatter is
+// parsed.
+var markdownExts = []string{".md", ".markdown", ".mdx"}
+
+// DocMeta is the metadata from a document's frontmatter.
+type DocMeta struct {
+	Title  string   `json:",omitempty"`
+	Labels []string `json:",omitempty"`
+	Owner  string   `json:",omitempty"`
+	Date   string   `json:",omitempty"`
+}
+
+// frontmatter is the part of the frontmatter we read.  Tags may be a
+// list or a comma-separated string.
+type frontmatter struct {
+	Title string    `yaml:"title"`
+	Tags  yaml.Node `yaml:"tags"`
+	Owner string    `yaml:"owner"`
+	Date  string    `yaml:"date"`
+}
+
+// parseFrontmatter returns the metadata from the frontmatter at the
+// start of content, or nil if there is none.
+func parseFrontmatter(content []byte) (meta *DocMeta, err error) {
+	defer Return(&err)
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+	lines := strings.Split(text, "\n")
+	if len(lines) < 2 || lines[0] != "---" {
+		return
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == "---" || lines[i] == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return
+	}
+	var fm frontmatter
+	err = yaml.Unmarshal([]byte(strings.Join(lines[1:end], "\n")), &fm)
+	Ck(err, "frontmatter")
+	meta = &DocMeta{
+		Title: strings.TrimSpace(fm.Title),
+		Owner: strings.TrimSpace(fm.Owner),
+		Date:  strings.TrimSpace(fm.Date),
+	}
+	switch fm.Tags.Kind {
+	case yaml.ScalarNode:
+		meta.Labels = normalizeTags(strings.Split(fm.Tags.Value, ","))
+	case yaml.SequenceNode:
+		var tags []string
+		for _, n := range fm.Tags.Content {
+			if n.Kind != yaml.ScalarNode {
+				err = fmt.Errorf("frontmatter tags must be strings")
+				return
+			}
+			tags = append(tags, n.Value)
+		}
+		meta.Labels = normalizeTags(tags)
+	}
+	if meta.Title == "" && meta.Owner == "" && meta.Date == "" && len(meta.Labels) == 0 {
+		meta = nil
+	}
+	return
+}
+
+// setMeta updates a document's metadata from its frontmatter.  A
+// document whose frontmatter can't be parsed is still indexed,
+// without metadata.
+func (g *Grokker) setMeta(doc *Document, content []byte) {
+	doc.Meta = nil
+	ext := strings.ToLower(path.Ext(doc.RelPath))
+	found := false
+	for _, e := range markdownExts {
+		if ext == e {
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	meta, err := parseFrontmatter(content)
+	if err != nil {
+		Fpf(os.Stderr, "warning: %s: %v\n", doc.RelPath, err)
+		return
+	}
+	doc.Meta = meta
+}
+
+// labeled returns true if the document has one of the filter's
+// labels and one of its owners, or the filter doesn't ask for them.
+func (f *Filter) labeled(meta *DocMeta) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Labels) > 0 {
+		if meta == nil {
+			return false
+		}
+		found := false
+		for _, want := range f.Labels {
+			for _, label := range meta.Labels {
+				if strings.EqualFold(want, label) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Owners) > 0 {
+		if meta == nil {
+			return false
+		}
+		found := false
+		for _, want := range f.Owners {
+			if strings.EqualFold(want, meta.Owner) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// docMeta maps document paths to metadata.
+func (g *Grokker) docMeta() (metas map[string]*DocMeta) {
+	metas = make(map[string]*DocMeta, len(g.Documents))
+	for _, doc := range g.Documents {
+		if doc.Meta != nil {
+			metas[doc.RelPath] = doc.Meta
+		}
+	}
+	return
+}
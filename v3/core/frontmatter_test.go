@@ -0,0 +1,62 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestParseFrontmatter(t *testing.T) {
+	meta, err := parseFrontmatter([]byte("---\ntitle: Restart ingest\ntags: [Runbook, ingest]\nowner: sre\ndate: 2024-03-01\n---\n# Restart\n"))
+	Tassert(t, err == nil, "error parsing: %v", err)
+	Tassert(t, meta != nil && meta.Title == "Restart ingest" && meta.Owner == "sre" && meta.Date == "2024-03-01", "unexpected meta %#v", meta)
+	Tassert(t, strings.Join(meta.Labels, ",") == "ingest,runbook", "unexpected labels %v", meta.Labels)
+
+	meta, err = parseFrontmatter([]byte("---\r\ntags: a, b\r\n...\r\nbody\r\n"))
+	Tassert(t, err == nil && meta != nil && strings.Join(meta.Labels, ",") == "a,b", "unexpected meta %#v: %v", meta, err)
+
+	for _, src := range []string{"# no frontmatter\n", "---\ntitle: unterminated\n", "---\nother: x\n---\n", "text\n---\ntitle: x\n---\n"} {
+		meta, err = parseFrontmatter([]byte(src))
+		Tassert(t, err == nil && meta == nil, "expected no meta for %q, got %#v: %v", src, meta, err)
+	}
+	_, err = parseFrontmatter([]byte("---\ntitle: [unclosed\n---\n"))
+	Tassert(t, err != nil, "expected an error for bad YAML")
+}
+
+func TestFrontmatterFilter(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	files := map[string]string{
+		"restart.md": "---\ntags: [runbook]\nowner: sre\n---\nrestart it\n",
+		"design.md":  "---\ntags: [design]\nowner: eng\n---\nwhy it is\n",
+		"notes.txt":  "---\ntags: [runbook]\n---\nnot markdown\n",
+	}
+	var pool []*Chunk
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		Tassert(t, err == nil, "error writing file: %v", err)
+		doc := &Document{RelPath: name}
+		grok.Documents = append(grok.Documents, doc)
+		chunks, err := grok.chunksFromDoc(doc)
+		Tassert(t, err == nil, "error chunking %s: %v", name, err)
+		pool = append(pool, chunks...)
+	}
+	paths := func(f *Filter) (out []string) {
+		for _, c := range f.apply(grok, pool) {
+			out = append(out, c.Document.RelPath)
+		}
+		return
+	}
+	got := paths(&Filter{Labels: []string{"RUNBOOK"}})
+	Tassert(t, strings.Join(got, ",") == "restart.md", "unexpected chunks %v", got)
+	got = paths(&Filter{Owners: []string{"eng"}})
+	Tassert(t, strings.Join(got, ",") == "design.md", "unexpected chunks %v", got)
+	got = paths(&Filter{Labels: []string{"runbook"}, Owners: []string{"eng"}})
+	Tassert(t, len(got) == 0, "unexpected chunks %v", got)
+	got = paths(&Filter{})
+	Tassert(t, len(got) == 3, "unexpected chunks %v", got)
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// GitHub Actions support for 'grok action'.  A workflow step runs grok
+// in the checkout; grok reads the event that triggered the workflow
+// from the file named by GITHUB_EVENT_PATH, and sets the step's
+// outputs by appending to the file named by GITHUB_OUTPUT, so later
+// steps can e.g. post an answer as a comment or upload the index as
+// an artifact.  Markdown results are also appended to the job summary,
+// GITHUB_STEP_SUMMARY.  See
+// https://docs.github.com/en/actions/learn-github-actions/variables
+// for the variables.
+
+// GitHubEvent is the part of a GitHub Actions event payload that grok
+// uses.
+type GitHubEvent struct {
+	// Name is the event name, e.g. "issue_comment" or
+	// "pull_request", from GITHUB_EVENT_NAME.
+	Name   string `json:"-"`
+	Action string `json:"action"`
+	// Comment is set for comment events.
+	Comment *struct {
+		ID      int64  `json:"id"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	} `json:"comment"`
+	// Issue is set for issue and issue comment events, including
+	// comments on pull requests.
+	Issue *struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+	} `json:"issue"`
+	// PullRequest is set for pull request events.
+	PullRequest *struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		Body   string `json:"body"`
+		Base   struct {
+			SHA string `json:"sha"`
+		} `json:"base"`
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// LoadGitHubEvent reads the event that triggered the workflow.
+func LoadGitHubEvent() (ev *GitHubEvent, err error) {
+	defer Return(&err)
+	path := os.Getenv("GITHUB_EVENT_PATH")
+	if path == "" {
+		err = fmt.Errorf("GITHUB_EVENT_PATH is not set; 'grok action' runs in GitHub Actions")
+		return
+	}
+	buf, err := os.ReadFile(path)
+	Ck(err)
+	ev = &GitHubEvent{}
+	err = json.Unmarshal(buf, ev)
+	Ck(err, "%s", path)
+	ev.Name = os.Getenv("GITHUB_EVENT_NAME")
+	return
+}
+
+// Number returns the issue or pull request number, or 0.
+func (ev *GitHubEvent) Number() int {
+	switch {
+	case ev.Issue != nil:
+		return ev.Issue.Number
+	case ev.PullRequest != nil:
+		return ev.PullRequest.Number
+	}
+	return 0
+}
+
+// Question returns the question a comment asks, given the trigger
+// that starts comments meant for grok, e.g. "/grok".  It returns
+// false if the comment doesn't start with the trigger.  For an issue
+// event without a comment, the question is the issue's title and
+// body, and the trigger isn't needed.
+//
+// Only new issues and comments are answered, so closing, labeling,
+// or deleting doesn't answer again; actions can add "reopened" to
+// answer reopened issues, and "edited" to answer edited issues and
+// comments.
+func (ev *GitHubEvent) Question(trigger string, actions ...string) (question string, ok bool) {
+	answered := ev.Action == "opened" || ev.Action == "created" ||
+		(ev.Action == "reopened" || ev.Action == "edited") && slices.Contains(actions, ev.Action)
+	if !answered {
+		return
+	}
+	if ev.Comment != nil {
+		body := strings.TrimSpace(ev.Comment.Body)
+		if trigger != "" {
+			if !strings.HasPrefix(body, trigger) {
+				return
+			}
+			body = strings.TrimSpace(strings.TrimPrefix(body, trigger))
+		}
+		return body, body != ""
+	}
+	if ev.Issue != nil {
+		question = strings.TrimSpace(ev.Issue.Title + "\n\n" + ev.Issue.Body)
+		return question, question != ""
+	}
+	return
+}
+
+// SetActionOutput sets a step output.  Outside GitHub Actions, where
+// GITHUB_OUTPUT isn't set, it does nothing.
+func SetActionOutput(name, value string) (err error) {
+	defer Return(&err)
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return
+	}
+	// a random delimiter, so the value can't end the output early
+	buf := make([]byte, 8)
+	_, err = rand.Read(buf)
+	Ck(err)
+	delim := "GROK_" + hex.EncodeToString(buf)
+	err = appendFile(path, Spf("%s<<%s\n%s\n%s\n", name, delim, value, delim))
+	Ck(err)
+	return
+}
+
+// AddStepSummary appends markdown to the job summary.  Outside
+// GitHub Actions it does nothing.
+func AddStepSummary(md string) (err error) {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return
+	}
+	return appendFile(path, strings.TrimRight(md, "\n")+"\n\n")
+}
+
+// appendFile appends text to a file, creating it if needed.
+func appendFile(path, text string) (err error) {
+	defer Return(&err)
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	Ck(err)
+	defer fh.Close()
+	_, err = fh.WriteString(text)
+	Ck(err)
+	return
+}
+
+// binarySniffBytes is how much of a file is checked for NUL bytes to
+// tell whether it is binary, as git does.
+const binarySniffBytes = 8000
+
+// IndexCheckout adds the text files git tracks under the root to the
+// knowledge base, except the knowledge base's own files and those in
+// .grokignore, and brings the embeddings of all documents up to date.
+// It returns the paths of the documents it added.  The checkout may
+// be a pull request's, db and all, so the index hooks that run
+// commands are skipped, trusted or not; only strip-license runs.
+func (g *Grokker) IndexCheckout() (added []string, err error) {
+	defer Return(&err)
+	g.noHooks = true
+	defer func() { g.noHooks = false }()
+	cmd := exec.Command("git", "ls-files", "-z")
+	cmd.Dir = g.Root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	Ck(err, "git ls-files: %s", strings.TrimSpace(stderr.String()))
+	have := make(map[string]bool)
+	for _, doc := range g.Documents {
+		have[doc.RelPath] = true
+	}
+	dbName := filepath.Base(g.grokpath)
+	for _, relpath := range strings.Split(string(out), "\x00") {
+		// the db and the files next to it, e.g. .grok.journal
+		if relpath == "" || have[relpath] || relpath == dbName || strings.HasPrefix(relpath, dbName+".") {
+			continue
+		}
+		abs := filepath.Join(g.Root, filepath.FromSlash(relpath))
+		if !isText(abs) {
+			continue
+		}
+		var doc *Document
+		doc, err = g.addDoc(abs)
+		Ck(err)
+		if doc == nil {
+			continue
+		}
+		_, err = g.updateDocument(doc)
+		Ck(err)
+		added = append(added, doc.RelPath)
+	}
+	_, err = g.UpdateEmbeddings()
+	Ck(err)
+	return
+}
+
+// isText returns true if the file exists and has no NUL bytes near
+// its start.
+func isText(path string) bool {
+	fh, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fh.Close()
+	buf := make([]byte, binarySniffBytes)
+	n, _ := fh.Read(buf)
+	return bytes.IndexByte(buf[:n], 0) < 0
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// lengthEmbedder embeds a text as its length, so tests can index
+// without a provider.
+type lengthEmbedder struct{}
+
+func (lengthEmbedder) Embed(texts []string) (embeddings [][]float64, err error) {
+	for _, text := range texts {
+		embeddings = append(embeddings, []float64{float64(len(text)), 1})
+	}
+	return
+}
+
+func (lengthEmbedder) Name() string { return "test:length" }
+
+func TestGitHubEvent(t *testing.T) {
+	dir := TmpTestDir()
+	fn := filepath.Join(dir, "event.json")
+	err := os.WriteFile(fn, []byte(`{"action": "created", "comment": {"id": 7, "body": "/grok how do I deploy?"}, "issue": {"number": 12, "title": "Deploying"}}`), 0644)
+	Ck(err)
+	t.Setenv("GITHUB_EVENT_PATH", fn)
+	t.Setenv("GITHUB_EVENT_NAME", "issue_comment")
+	ev, err := LoadGitHubEvent()
+	Tassert(t, err == nil, "error loading event: %v", err)
+	Tassert(t, ev.Name == "issue_comment" && ev.Number() == 12 && ev.Comment.ID == 7, "unexpected event %+v", ev)
+	q, ok := ev.Question("/grok")
+	Tassert(t, ok && q == "how do I deploy?", "unexpected question %q", q)
+	_, ok = ev.Question("/ask")
+	Tassert(t, !ok, "expected no question without the trigger")
+	ev.Action = "edited"
+	_, ok = ev.Question("/grok")
+	Tassert(t, !ok, "expected no question for an edited comment")
+	_, ok = ev.Question("/grok", "edited")
+	Tassert(t, ok, "expected a question for an edited comment with edited")
+	ev.Comment = nil
+	ev.Action = "opened"
+	q, ok = ev.Question("/grok")
+	Tassert(t, ok && q == "Deploying", "unexpected question %q", q)
+	for _, action := range []string{"closed", "labeled", "reopened"} {
+		ev.Action = action
+		_, ok = ev.Question("/grok")
+		Tassert(t, !ok, "expected no question for a %s issue", action)
+	}
+	_, ok = ev.Question("/grok", "reopened")
+	Tassert(t, ok, "expected a question for a reopened issue with reopened")
+
+	// multi-line outputs are delimited
+	out := filepath.Join(dir, "output")
+	t.Setenv("GITHUB_OUTPUT", out)
+	err = SetActionOutput("answer", "line 1\nline 2")
+	Ck(err)
+	buf, err := os.ReadFile(out)
+	Ck(err)
+	re := regexp.MustCompile(`^answer<<(GROK_[0-9a-f]+)\nline 1\nline 2\n(GROK_[0-9a-f]+)\n$`)
+	m := re.FindStringSubmatch(string(buf))
+	Tassert(t, m != nil && m[1] == m[2], "unexpected output file %q", buf)
+}
+
+func TestIndexCheckout(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "config"))
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		Tassert(t, err == nil, "git %v: %v: %s", args, err, out)
+	}
+	write := func(fn, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755)
+		Ck(err)
+		err = os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Ck(err)
+	}
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	git("init", "-q")
+	write("README.md", "# Project\n")
+	write("docs/guide.md", "How to use it.\n")
+	write("logo.png", "\x89PNG\x00\x00")
+	write("secret.txt", "hush\n")
+	write("untracked.md", "not in git\n")
+	write(".grokignore", "secret.txt\n")
+	git("add", "README.md", "docs", "logo.png", "secret.txt", ".grokignore", ".grok")
+	// a hook from the checkout's db doesn't run, even if trusted
+	err = grok.AddHook("docs/*.md", "touch hooked")
+	Ck(err)
+
+	added, err := grok.IndexCheckout()
+	Tassert(t, err == nil, "error indexing: %v", err)
+	sort.Strings(added)
+	Tassert(t, strings.Join(added, " ") == ".grokignore README.md docs/guide.md", "unexpected documents %v", added)
+	Tassert(t, len(grok.Chunks) >= 3, "expected chunks, got %d", len(grok.Chunks))
+	_, err = os.Stat(filepath.Join(dir, "hooked"))
+	Tassert(t, os.IsNotExist(err), "expected the hook not to run")
+	added, err = grok.IndexCheckout()
+	Tassert(t, err == nil && len(added) == 0, "expected nothing new, got %v: %v", added, err)
+}
+
+func TestChangedFiles(t *testing.T) {
+	diff := "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-x\n+y\ndiff --git a/b.md b/b.md\nnew file mode 100644\n--- /dev/null\n+++ b/b.md\n@@ -0,0 +1 @@\n+hi\n"
+	files := ChangedFiles(diff)
+	Tassert(t, strings.Join(files, " ") == "a.go b.md", "unexpected files %v", files)
+}
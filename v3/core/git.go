@@ -57,7 +57,7 @@ func (g *Grokker) summarizeDiff(diff string) (sumlines string, diffSummary strin
 			fileSummary = Spf("summary of diff --git %s\n", fns)
 		}
 		var chunks []*Chunk
-		chunks, err = g.chunksFromString(nil, fileChunk, maxTokens)
+		chunks, err = g.chunksFromString(nil, fileChunk, g.codec(), maxTokens)
 		// summarize each chunk
 		for _, chunk := range chunks {
 			// format the chunk
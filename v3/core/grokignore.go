@@ -0,0 +1,117 @@
+package core
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	. "github.com/stevegt/goadapt"
+)
+
+// A .grokignore file in any directory of the tree excludes files
+// under that directory from the knowledge base, using .gitignore
+// syntax, so a team can keep generated or sensitive subtrees out
+// without any central configuration.  As with .gitignore, patterns
+// are relative to the directory of the file they're in, and a deeper
+// file overrides a shallower one, so '!keep.md' in docs/.grokignore
+// re-includes a file that the root .grokignore excludes.  'grok add'
+// skips ignored files, 'grok refresh' forgets documents that have
+// become ignored, and the serve re-indexing schedule doesn't add
+// them.  Virtual documents are never ignored.
+
+// grokignoreName is the name of the ignore files.
+const grokignoreName = ".grokignore"
+
+// grokignore is one .grokignore file.  all is the same file with
+// everything ignored first, so a path that it doesn't match was
+// re-included by a negated pattern.
+type grokignore struct {
+	ig  *gitignore.GitIgnore
+	all *gitignore.GitIgnore
+}
+
+// ignorer answers whether paths are ignored, reading each directory's
+// .grokignore once.
+type ignorer struct {
+	root  string
+	files map[string]*grokignore
+}
+
+func newIgnorer(root string) *ignorer {
+	return &ignorer{root: root, files: make(map[string]*grokignore)}
+}
+
+// load returns the .grokignore in dir, relative to the root, or nil
+// if there is none.
+func (ign *ignorer) load(dir string) (gi *grokignore, err error) {
+	defer Return(&err)
+	gi, ok := ign.files[dir]
+	if ok {
+		return
+	}
+	buf, err := os.ReadFile(filepath.Join(ign.root, filepath.FromSlash(dir), grokignoreName))
+	if os.IsNotExist(err) {
+		err = nil
+		ign.files[dir] = nil
+		return
+	}
+	Ck(err)
+	lines := strings.Split(string(buf), "\n")
+	gi = &grokignore{
+		ig:  gitignore.CompileIgnoreLines(lines...),
+		all: gitignore.CompileIgnoreLines(append([]string{"*"}, lines...)...),
+	}
+	ign.files[dir] = gi
+	return
+}
+
+// ignored returns true if the file at relpath, relative to the root
+// and with forward slashes, is ignored.
+func (ign *ignorer) ignored(relpath string) (ignored bool, err error) {
+	defer Return(&err)
+	if strings.HasPrefix(relpath, "../") {
+		return
+	}
+	// the directories from the file's up to the root, deepest
+	// first
+	var dirs []string
+	for dir := path.Dir(relpath); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dirs = append(dirs, "")
+			break
+		}
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		gi, err := ign.load(dir)
+		Ck(err)
+		if gi == nil {
+			continue
+		}
+		rel := relpath
+		if dir != "" {
+			rel = strings.TrimPrefix(relpath, dir+"/")
+		}
+		if gi.ig.MatchesPath(rel) {
+			return true, nil
+		}
+		if !gi.all.MatchesPath(rel) {
+			// re-included here; shallower files don't apply
+			return false, nil
+		}
+	}
+	return
+}
+
+// Ignored returns true if a .grokignore file excludes the file at
+// path, which is relative to the current directory or absolute.
+func (g *Grokker) Ignored(path string) (ignored bool, err error) {
+	defer Return(&err)
+	absPath, err := filepath.Abs(path)
+	Ck(err)
+	relpath, err := filepath.Rel(g.Root, absPath)
+	Ck(err)
+	return newIgnorer(g.Root).ignored(filepath.ToSlash(relpath))
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestGrokignore(t *testing.T) {
+	dir := TmpTestDir()
+	write := func(relpath, content string) {
+		fn := filepath.Join(dir, relpath)
+		err := os.MkdirAll(filepath.Dir(fn), 0755)
+		Tassert(t, err == nil, "error creating dir: %v", err)
+		err = os.WriteFile(fn, []byte(content), 0644)
+		Tassert(t, err == nil, "error writing %s: %v", relpath, err)
+	}
+	write(".grokignore", "gen/\n*.secret\n")
+	write("docs/.grokignore", "!keep.secret\ndrafts/\n")
+	ign := newIgnorer(dir)
+	for relpath, want := range map[string]bool{
+		"a.md":                false,
+		"gen/x.go":            true,
+		"src/gen/y.go":        true,
+		"x.secret":            true,
+		"docs/x.secret":       true,
+		"docs/keep.secret":    false,
+		"docs/drafts/a.md":    true,
+		"drafts/a.md":         false,
+		"docs/sub/keep.md":    false,
+		"../outside/x.secret": false,
+	} {
+		got, err := ign.ignored(relpath)
+		Tassert(t, err == nil, "error checking %s: %v", relpath, err)
+		Tassert(t, got == want, "%s: expected ignored=%v, got %v", relpath, want, got)
+	}
+
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	write("gen/x.go", "package gen")
+	doc, err := grok.addDoc(filepath.Join(dir, "gen/x.go"))
+	Tassert(t, err == nil && doc == nil, "expected an ignored file to be skipped: %v %v", doc, err)
+	ignored, err := grok.Ignored(filepath.Join(dir, "docs/keep.secret"))
+	Tassert(t, err == nil && !ignored, "expected docs/keep.secret to be included: %v", err)
+}
@@ -51,12 +51,120 @@ import (
 const (
 	// See the "Semantic Versioning" section of the README for
 	// information on API and db stability and versioning.
-	Version = "3.0.25"
+	Version = "3.1.0"
 )
 
 type Grokker struct {
 	embeddingClient *openai.Client
 	chatClient      *oai.Client
+	// if set, used instead of embeddingClient
+	embedder localEmbedder
+	// first-stage embedder, see Pipeline.Prefilter
+	prefilter localEmbedder
+	// cross-encoder, see Pipeline.Reranker
+	reranker localReranker
+	// restricts the chunks used as context; see SetFilter
+	filter *Filter
+	// the chunks of unsaved editor buffers, keyed by document path;
+	// see buffer.go
+	buffers map[string][]*Chunk
+	// the chunks of attached documents, keyed by name; see
+	// attach.go
+	attachments map[string][]*Chunk
+	// per-query limits on the context; see SetContextLimits
+	maxChunks     int
+	contextTokens int
+	// citations for the context most recently built by getContext,
+	// and the IDs of its chunks
+	sources   []string
+	sourceIDs []string
+	// the chunks of that context the injection detector flagged;
+	// see injection.go
+	injectionFlags []InjectionFlag
+	// the highest similarity of a chunk to the query most recently
+	// searched; see TopSimilarity
+	topSimilarity float64
+	// the scores of the chunks that search returned; see Retrieve
+	chunkScores map[*Chunk]float64
+	// the context most recently built by getContext, and its
+	// chunks
+	context       string
+	contextChunks []contextPart
+	// the number of follow-up questions Answer suggests, and the
+	// last suggestions; see SetFollowUps
+	followUpCount int
+	followUps     []string
+	// whether Answer checks its claims, and the last verdicts; see
+	// SetCheckAnswers
+	checkAnswers bool
+	claimChecks  []ClaimCheck
+	// completion presets; see SetPersona
+	persona *Persona
+	// answer length presets; see SetProfile
+	profile *Profile
+	// the pipeline stored in the db while another is tried; see
+	// UsePipeline
+	pipelineFromDb *Pipeline
+	// the language of answers; see SetLang
+	lang string
+	// guardrails from the user config; see getPolicy
+	policy *Policy
+	// chat history settings from the user config, and the key chat
+	// files are encrypted with; see chatcrypt.go
+	chatCfg *ChatConfig
+	chatKey []byte
+	// the last entry written to the audit log
+	auditLast *AuditEntry
+	// overrides Pipeline.Decompose; see SetDecompose
+	decompose *bool
+	// overrides Pipeline.NoCorrect, and what the last question had
+	// corrected; see correct.go
+	correct     *bool
+	corrections []Correction
+	// the most an answer may cost, and what the last answer gave
+	// up to keep to it; see budget.go
+	budget       float64
+	degradations []string
+	// what a refresh looks up once: the commit checked out in the
+	// tree, see owners.go, and the paths of loader plugins by
+	// extension, see plugin.go
+	gitHead     *string
+	loaderPaths map[string]string
+	// whether refreshes skip the index hooks that run commands; see
+	// IndexCheckout
+	noHooks bool
+	// the directory this Grokker's caches are in, if not the
+	// shared CacheDir(); see SetCacheDir
+	cachePath string
+	// where AnswerStream sends the answer as it arrives
+	stream func(text string) error
+	// the last entry written to the question log
+	questionLast *Question
+	// set for the copies made by Compare, whose questions aren't
+	// logged
+	noQuestionLog bool
+	// what converting the chunks of an old database did; see
+	// Migrate
+	migration *MigrateReport
+	// model tokens used by this process; see TokensUsed
+	tokensUsed int
+	// the prompt and completion tokens of chat requests, which
+	// BenchModels prices separately
+	promptTokens     int
+	completionTokens int
+	// embeddings taken from the cache and requested from the
+	// embedder by this process
+	embeddingsCached  int
+	embeddingsFetched int
+	// which of the user caches to use; see SetCaches
+	noEmbeddingCache bool
+	responseCache    bool
+	// refuse to save or update embeddings; see SetReadOnly
+	readOnly bool
+	// the completion seed, and the run being recorded; see
+	// runmanifest.go
+	seed     *int
+	manifest *RunManifest
 	// The grokker version number this db was last updated with.
 	Version string
 	// The absolute path of the root directory of the document
@@ -65,21 +173,61 @@ type Grokker struct {
 	Root string
 	// The list of documents in the database.
 	Documents []*Document
+	// Documents that were forgotten; see tombstone.go.
+	Tombstones []*Tombstone `json:",omitempty"`
 	// The list of chunks in the database.
 	Chunks []*Chunk
+	// Pending OpenAI Batch API embedding jobs.
+	Batches []*BatchJob
+	// Retrieval settings.
+	Pipeline Pipeline
+	// Transforms applied to content before it is chunked; see
+	// hooks.go.
+	Hooks []*Hook `json:",omitempty"`
+	// Hashes of chunks the user has taken off the stop-list.
+	StopAllow map[string]bool `json:",omitempty"`
 	// model specs
-	models              *Models
-	Model               string
-	modelObj            *Model
+	models   *Models
+	Model    string
+	modelObj *Model
+	// the chat model's tokenizer; see initModel
+	tokenizer           tokenizer.Codec
 	TokenLimit          int
 	EmbeddingTokenLimit int
+	// The embedder that created the embeddings in the database,
+	// e.g. "openai" and "text-embedding-ada-002", and the length of
+	// each embedding vector.
+	EmbeddingProvider string
+	EmbeddingModel    string
+	EmbeddingDim      int
+	// Checksum over the checksums of all chunks; see verify.go.
+	ChunkSum string `json:",omitempty"`
+	// Subtrees whose chunks are stored in their own files; see
+	// shard.go.
+	Shards []string `json:",omitempty"`
+	// Where the other chunks are stored: "sqlite", or empty for the
+	// db file; see store.go.
+	Store string `json:",omitempty"`
 	// pathname of the grokker database file
 	grokpath      string
 	modelOverride bool
 	modelFromDb   string
+	// the name of the snapshot this db was loaded from, if any
+	snapshot string
+	// signatures of the chunks as of the last save, keyed by hash;
+	// see journal.go
+	savedSigs map[string]string
+	// signatures and store checksums of the loaded shards as of
+	// the last load or save, keyed by shard; see shard.go
+	shardSigs map[string]string
+	shardSums map[string]string
 	// lock                *flock.Flock
 }
 
+// Tokenizer is the cl100k_base BPE tokenizer, the one tiktoken uses
+// for OpenAI's embedding models.  Chunks are split with it to fit
+// EmbeddingTokenLimit; prompts and context are counted with the chat
+// model's tokenizer instead; see codec().
 // XXX get rid of this global
 var Tokenizer tokenizer.Codec
 
@@ -89,13 +237,31 @@ func (g *Grokker) mtime() (timestamp time.Time, err error) {
 	fi, err := os.Stat(g.grokpath)
 	Ck(err)
 	timestamp = fi.ModTime()
+	// the journal is part of the db
+	jtime := g.journalMtime()
+	if jtime.After(timestamp) {
+		timestamp = jtime
+	}
 	return
 }
 
-// tokens returns the tokens for a text segment.
+// codec returns the chat model's tokenizer, or Tokenizer if no model
+// is set up.
+func (g *Grokker) codec() tokenizer.Codec {
+	if g.tokenizer != nil {
+		return g.tokenizer
+	}
+	if Tokenizer == nil {
+		err := InitTokenizer()
+		Ck(err)
+	}
+	return Tokenizer
+}
+
+// tokens returns the chat model's tokens for a text segment.
 func (g *Grokker) tokens(text string) (tokens []string, err error) {
 	defer Return(&err)
-	_, tokens, err = Tokenizer.Encode(text)
+	_, tokens, err = g.codec().Encode(text)
 	Ck(err)
 	return
 }
@@ -3,6 +3,7 @@ package core
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -36,27 +37,27 @@ func TestSplitChunk(t *testing.T) {
 	length := len(text)
 	Tassert(t, length > 0, "expected text to be non-empty")
 	Tassert(t, length > grok.TokenLimit, "expected text to be longer than %d tokens", grok.TokenLimit)
-	chunk := newChunk(nil, 0, length, text)
-	tc, err := chunk.tokenCount(grok)
+	chunk := newChunk(nil, "", 0, length, text)
+	tc, err := chunk.tokenCount(grok, Tokenizer)
 	Tassert(t, err == nil, "error getting token count for chunk: %v", err)
 	Tassert(t, tc > grok.TokenLimit, "expected chunk to have more than %d tokens, got %d tokens", grok.TokenLimit, tc)
 
 	// call the splitChunk method
-	newChunks, err := chunk.splitChunk(grok, 2000)
+	newChunks, err := chunk.splitChunk(grok, Tokenizer, 2000)
 	Tassert(t, err == nil, "error splitting chunk: %v", err)
 
 	// verify that the original chunk was split
 	Tassert(t, len(newChunks) > 0, "expected chunk to be split into more than zero")
 	if len(newChunks) == 1 {
 		c := newChunks[0]
-		tc, err := c.tokenCount(grok)
+		tc, err := c.tokenCount(grok, Tokenizer)
 		Tassert(t, err == nil, "error getting token count for chunk: %v", err)
 		Tassert(t, false, "expected chunk to be split, got %d tokens", tc)
 	}
 
 	// verify that each new chunk is within the token limit
 	for i, ch := range newChunks {
-		tc, err := ch.tokenCount(grok)
+		tc, err := ch.tokenCount(grok, Tokenizer)
 		Tassert(t, err == nil, "error getting token count for chunk %d: %v", i, err)
 		Tassert(t, tc <= grok.TokenLimit, "expected chunk %d to have %d tokens or less, got %d tokens", i, grok.TokenLimit, tc)
 	}
@@ -87,14 +88,14 @@ func TestAddDoc(t *testing.T) {
 
 func TestChunkTextAfterRemovingFile(t *testing.T) {
 	// create a new Grokker database
-	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
 	Tassert(t, err == nil, "error creating grokker: %v", err)
 
-	// copy a document to a temporary file
+	// copy a document into the database's directory, so nothing is
+	// left in testdata if the test fails
 	srcPath := "testdata/te-full.txt"
-	docPath := "testdata/te-full-copy.txt"
-	// remove the temporary file first, in case it already exists
-	_ = os.Remove(docPath)
+	docPath := filepath.Join(dir, "te-full-copy.txt")
 	err = util.CopyFile(srcPath, docPath)
 	Tassert(t, err == nil, "error copying file: %v", err)
 
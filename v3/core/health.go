@@ -0,0 +1,256 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+	. "github.com/stevegt/goadapt"
+)
+
+// We keep a small record of recent provider requests and cache
+// lookups in CacheDir(), shared by every grok process, so 'grok
+// status' can show whether a provider has been slow or failing
+// lately.  Recording is best effort and never fails a request.
+
+// healthSamples is the number of recent requests kept per provider.
+const healthSamples = 100
+
+// healthSample is one request to a provider.
+type healthSample struct {
+	Time    time.Time
+	Latency time.Duration
+	Error   string `json:",omitempty"`
+}
+
+// healthData is the content of the health file.
+type healthData struct {
+	Providers map[string][]healthSample
+	Caches    map[string]*CacheStats
+}
+
+// CacheStats counts the lookups in a cache.
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// HitRate returns the fraction of lookups that were hits.
+func (c CacheStats) HitRate() float64 {
+	if c.Hits+c.Misses == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(c.Hits+c.Misses)
+}
+
+// ProviderHealth summarizes the recent requests to a provider.
+type ProviderHealth struct {
+	Name     string
+	Requests int
+	Errors   int
+	Since    time.Time
+	P50      time.Duration
+	P95      time.Duration
+	// the most recent error, if any
+	LastError     string
+	LastErrorTime time.Time
+}
+
+// healthPath returns the path of the health file, or an empty string
+// if there is no user cache directory.
+func healthPath() string {
+	dir := CacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "health.json")
+}
+
+// loadHealth reads the health file.  A missing or unreadable file
+// yields empty data.
+func loadHealth() (data *healthData) {
+	data = &healthData{
+		Providers: make(map[string][]healthSample),
+		Caches:    make(map[string]*CacheStats),
+	}
+	path := healthPath()
+	if path == "" {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(buf, data)
+	if err != nil {
+		Debug("ignoring corrupt health file %s: %v", path, err)
+	}
+	if data.Providers == nil {
+		data.Providers = make(map[string][]healthSample)
+	}
+	if data.Caches == nil {
+		data.Caches = make(map[string]*CacheStats)
+	}
+	return
+}
+
+// updateHealth applies fn to the health file.  The file is shared by
+// every grok process, so it is locked while it is read and written,
+// or an update could be lost.
+func updateHealth(fn func(data *healthData)) {
+	path := healthPath()
+	if path == "" {
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		Debug("cannot write health file: %v", err)
+		return
+	}
+	lock := flock.New(path + ".lock")
+	err = lock.Lock()
+	if err != nil {
+		Debug("cannot lock health file: %v", err)
+		return
+	}
+	defer lock.Unlock()
+	data := loadHealth()
+	fn(data)
+	buf, err := json.Marshal(data)
+	Ck(err)
+	tmpfn := Spf("%s.%d.tmp", path, os.Getpid())
+	err = os.WriteFile(tmpfn, buf, 0644)
+	if err == nil {
+		err = os.Rename(tmpfn, path)
+	}
+	if err != nil {
+		Debug("cannot write health file: %v", err)
+	}
+}
+
+// recordRequest records a request to a provider that started at
+// start and ended now with err.
+func recordRequest(provider string, start time.Time, err error) {
+	sample := healthSample{Time: start, Latency: time.Since(start)}
+	if err != nil {
+		sample.Error = err.Error()
+	}
+	updateHealth(func(data *healthData) {
+		samples := append(data.Providers[provider], sample)
+		if len(samples) > healthSamples {
+			samples = samples[len(samples)-healthSamples:]
+		}
+		data.Providers[provider] = samples
+	})
+}
+
+// recordCache records lookups in the named cache.
+func recordCache(name string, hits, misses int) {
+	if hits+misses == 0 {
+		return
+	}
+	updateHealth(func(data *healthData) {
+		stats, ok := data.Caches[name]
+		if !ok {
+			stats = &CacheStats{}
+			data.Caches[name] = stats
+		}
+		stats.Hits += hits
+		stats.Misses += misses
+	})
+}
+
+// Health returns a summary of the recent requests to each provider,
+// sorted by name, and the lookup counts of each cache.
+func Health() (providers []ProviderHealth, caches map[string]CacheStats) {
+	data := loadHealth()
+	for name, samples := range data.Providers {
+		if len(samples) == 0 {
+			continue
+		}
+		h := ProviderHealth{Name: name, Requests: len(samples), Since: samples[0].Time}
+		var latencies []time.Duration
+		for _, s := range samples {
+			if s.Error != "" {
+				h.Errors++
+				h.LastError = s.Error
+				h.LastErrorTime = s.Time
+				continue
+			}
+			latencies = append(latencies, s.Latency)
+		}
+		if len(latencies) > 0 {
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			h.P50 = latencies[len(latencies)/2]
+			h.P95 = latencies[len(latencies)*95/100]
+		}
+		providers = append(providers, h)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	caches = make(map[string]CacheStats)
+	for name, stats := range data.Caches {
+		caches[name] = *stats
+	}
+	return
+}
+
+// DBStats describes the contents of the database.
+type DBStats struct {
+	Path        string
+	Size        int64
+	JournalSize int64
+	// Store is the kind of chunk store, and SqliteSize the size of
+	// the sqlite store's file; see store.go.
+	Store       string
+	SqliteSize  int64
+	Documents   int
+	Virtual     int
+	Collections int
+	Chunks      int
+	Embedded    int
+	Excluded    int
+}
+
+// Stats returns statistics about the database.
+func (g *Grokker) Stats() (stats DBStats) {
+	stats.Path = g.grokpath
+	if fi, err := os.Stat(g.grokpath); err == nil {
+		stats.Size = fi.Size()
+	}
+	if fi, err := os.Stat(g.journalPath()); err == nil {
+		stats.JournalSize = fi.Size()
+	}
+	stats.Store = g.StoreKind()
+	if fi, err := os.Stat(g.grokpath + stores["sqlite"]); err == nil {
+		stats.SqliteSize = fi.Size()
+	}
+	stats.Documents = len(g.Documents)
+	for _, doc := range g.Documents {
+		if doc.Virtual {
+			stats.Virtual++
+		}
+	}
+	stats.Collections = len(g.Collections())
+	stats.Chunks = len(g.Chunks)
+	for _, c := range g.Chunks {
+		if c.Embedding != nil {
+			stats.Embedded++
+		}
+		if c.Excluded != "" {
+			stats.Excluded++
+		}
+	}
+	return
+}
+
+// chatProvider returns the name of the provider that serves chat
+// completions for the current model.
+func (g *Grokker) chatProvider() string {
+	if g.modelObj != nil && g.modelObj.provider != "" {
+		return g.modelObj.provider
+	}
+	return "openai"
+}
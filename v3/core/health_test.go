@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestHealth(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", TmpTestDir())
+	t.Setenv("HOME", TmpTestDir())
+
+	providers, caches := Health()
+	Tassert(t, len(providers) == 0 && len(caches) == 0, "expected no health data, got %v %v", providers, caches)
+
+	start := time.Now().Add(-time.Second)
+	for i := 0; i < healthSamples+10; i++ {
+		recordRequest("openai", start, nil)
+	}
+	recordRequest("openrouter", start, errors.New("503 Service Unavailable"))
+	recordRequest("openrouter", start, nil)
+	recordCache("models", 0, 1)
+	recordCache("models", 2, 0)
+
+	providers, caches = Health()
+	Tassert(t, len(providers) == 2, "expected 2 providers, got %v", providers)
+	h := providers[0]
+	Tassert(t, h.Name == "openai" && h.Requests == healthSamples && h.Errors == 0, "unexpected health %+v", h)
+	Tassert(t, h.P50 >= time.Second && h.P95 >= h.P50, "unexpected latency %+v", h)
+	h = providers[1]
+	Tassert(t, h.Name == "openrouter" && h.Requests == 2 && h.Errors == 1, "unexpected health %+v", h)
+	Tassert(t, h.LastError == "503 Service Unavailable", "unexpected last error %q", h.LastError)
+	c := caches["models"]
+	Tassert(t, c.Hits == 2 && c.Misses == 1, "unexpected cache stats %+v", c)
+	Tassert(t, c.HitRate() > 0.66 && c.HitRate() < 0.67, "unexpected hit rate %v", c.HitRate())
+}
+
+func TestHealthConcurrent(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", TmpTestDir())
+	t.Setenv("HOME", TmpTestDir())
+	// each recordCache takes the lock on its own, as separate
+	// processes would
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				recordCache("embeddings", 1, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	_, caches := Health()
+	Tassert(t, caches["embeddings"].Hits == 80, "expected 80 hits, got %+v", caches["embeddings"])
+}
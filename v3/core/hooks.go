@@ -0,0 +1,480 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Index hooks transform a document's content before it is chunked,
+// so what gets embedded is what users want to search: license
+// headers stripped, templates rendered, or 'go doc' output instead of
+// source.  Each hook applies to the documents matching a .gitignore
+// style pattern; the hooks that match a document run in the order
+// they were added, each reading the previous one's output on stdin
+// and writing the new content to stdout.  A hook runs in the root of
+// the tree with GROKKER_DOCUMENT set to the document's path.  The
+// command strip-license is built in.
+//
+// Chunks are offsets into the content they were made from, so the
+// transformed content is kept next to the db, like virtual document
+// content, and read from there.  Hooks are stored in the db, so
+// everyone who refreshes it indexes the same content; run 'grok
+// refresh' after changing them.
+//
+// Because a db can come from someone else, e.g. in a pull request or
+// an import, a hook only runs once the user has trusted it for that
+// db.  Trust is kept in trusted-hooks.yaml in ConfigDir(), keyed by
+// db path, as the digests of the hooks' patterns and commands;
+// AddHook trusts the hook it adds, and TrustHooks trusts all of the
+// db's hooks, e.g. after reviewing them with 'grok hook ls'.  An
+// untrusted hook is an error, not skipped, so the index never
+// silently holds content other than the hooks say.  The built-in
+// strip-license runs nothing, so it needs no trust.
+
+// Hook is an index hook.
+type Hook struct {
+	Pattern string
+	Command string
+}
+
+// stripLicense is the name of the built-in hook command.
+const stripLicense = "strip-license"
+
+// AddHook adds a hook that runs command on documents matching
+// pattern.
+func (g *Grokker) AddHook(pattern, command string) (err error) {
+	pattern = strings.TrimSpace(pattern)
+	command = strings.TrimSpace(command)
+	if pattern == "" || command == "" {
+		err = fmt.Errorf("a hook needs a pattern and a command")
+		return
+	}
+	h := &Hook{Pattern: pattern, Command: command}
+	err = g.trustHooks(append(g.trustedHookDigests(), h.digest()))
+	if err != nil {
+		return
+	}
+	g.Hooks = append(g.Hooks, h)
+	return
+}
+
+// RemoveHooks removes the hooks with the given pattern.
+func (g *Grokker) RemoveHooks(pattern string) (err error) {
+	var keep []*Hook
+	for _, h := range g.Hooks {
+		if h.Pattern != pattern {
+			keep = append(keep, h)
+		}
+	}
+	if len(keep) == len(g.Hooks) {
+		err = fmt.Errorf("no hook has pattern %q", pattern)
+		return
+	}
+	g.Hooks = keep
+	return
+}
+
+// digest identifies a hook's pattern and command for trust.
+func (h *Hook) digest() string {
+	sum := sha256.Sum256([]byte(h.Pattern + "\x00" + h.Command))
+	return hex.EncodeToString(sum[:])
+}
+
+// trustedHooksPath returns the path of the file that records trusted
+// hooks, or an empty string if there is no user config directory.
+func trustedHooksPath() string {
+	dir := ConfigDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "trusted-hooks.yaml")
+}
+
+// loadTrustedHooks reads the trusted hook digests of every db, keyed
+// by the absolute path of its .grok file.  A missing file yields an
+// empty map.
+func loadTrustedHooks() (trusted map[string][]string, err error) {
+	defer Return(&err)
+	trusted = make(map[string][]string)
+	path := trustedHooksPath()
+	if path == "" {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	err = yaml.Unmarshal(buf, &trusted)
+	Ck(err, "%s", path)
+	if trusted == nil {
+		trusted = make(map[string][]string)
+	}
+	return
+}
+
+// hookKey returns the key of the db's entry in trusted-hooks.yaml.
+func (g *Grokker) hookKey() string {
+	abs, err := filepath.Abs(g.grokpath)
+	if err != nil {
+		return g.grokpath
+	}
+	return abs
+}
+
+// trustedHookDigests returns the digests of the hooks trusted for the
+// db.  An unreadable trust file trusts nothing.
+func (g *Grokker) trustedHookDigests() []string {
+	trusted, err := loadTrustedHooks()
+	if err != nil {
+		Fpf(os.Stderr, "warning: %v\n", err)
+		return nil
+	}
+	return trusted[g.hookKey()]
+}
+
+// trustHooks records digests as the hooks trusted for the db.
+func (g *Grokker) trustHooks(digests []string) (err error) {
+	defer Return(&err)
+	path := trustedHooksPath()
+	if path == "" {
+		err = fmt.Errorf("no user config directory to record trusted hooks in")
+		return
+	}
+	trusted, err := loadTrustedHooks()
+	Ck(err)
+	sort.Strings(digests)
+	trusted[g.hookKey()] = slices.Compact(digests)
+	buf, err := yaml.Marshal(trusted)
+	Ck(err)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	Ck(err)
+	tmpfn := path + ".tmp"
+	err = os.WriteFile(tmpfn, buf, 0644)
+	Ck(err)
+	err = os.Rename(tmpfn, path)
+	Ck(err)
+	return
+}
+
+// TrustHooks trusts the db's current hooks, and only those, to run.
+func (g *Grokker) TrustHooks() (err error) {
+	var digests []string
+	for _, h := range g.Hooks {
+		digests = append(digests, h.digest())
+	}
+	return g.trustHooks(digests)
+}
+
+// UntrustedHooks returns the db's hooks that aren't trusted to run.
+func (g *Grokker) UntrustedHooks() (untrusted []*Hook) {
+	trusted := make(map[string]bool)
+	for _, d := range g.trustedHookDigests() {
+		trusted[d] = true
+	}
+	for _, h := range g.Hooks {
+		if h.Command != stripLicense && !trusted[h.digest()] {
+			untrusted = append(untrusted, h)
+		}
+	}
+	return
+}
+
+// transformedDir returns the directory that holds transformed
+// document content.
+func (g *Grokker) transformedDir() string {
+	return g.grokpath + ".transformed"
+}
+
+// transformedPath returns the path of the transformed content of a
+// document.
+func (g *Grokker) transformedPath(relpath string) string {
+	return filepath.Join(g.transformedDir(), escapeName(relpath))
+}
+
+// contentPath returns the path of the content a document's chunks
+// were made from: the transformed content if a hook applies, else
+// the document itself.
+func (g *Grokker) contentPath(doc *Document) string {
+	if doc.Transformed {
+		return g.transformedPath(doc.RelPath)
+	}
+	return g.absPath(doc)
+}
+
+// A loader renders a kind of structured document one section per
+// paragraph, so each section becomes a chunk; it returns false if
+// the content isn't that kind of document.  The loaders for a
+// document's extension are tried in order.  A loader that knows
+// where each section came from returns the sections, so chunks can
+// be cited by the section's name and its line in the original,
+// rather than by a line of the rendered content that the user never
+// sees.
+type loader struct {
+	exts   []string
+	render func(content []byte) ([]byte, []*DocSection, bool)
+}
+
+// DocSection is a section of a document rendered by a loader.
+type DocSection struct {
+	// Offset is where the section starts in the rendered content.
+	Offset int
+	// Name is what the section is, e.g. "POST /pets" or "resource
+	// aws_s3_bucket.logs".
+	Name string
+	// Line is the line of the original document the section starts
+	// on, counting from 1, or 0 if it isn't known.
+	Line int `json:",omitempty"`
+}
+
+// renderedSection is a section as a loader renders it: its name, the
+// line of the original it starts on, and its text, which must have
+// no blank lines.
+type renderedSection struct {
+	name string
+	line int
+	text string
+}
+
+// joinSections joins rendered sections into paragraphs, and returns
+// where each starts.
+func joinSections(secs []renderedSection) (out []byte, sections []*DocSection) {
+	var buf bytes.Buffer
+	for i, sec := range secs {
+		if i > 0 {
+			buf.WriteString("\n\n")
+		}
+		sections = append(sections, &DocSection{Offset: buf.Len(), Name: sec.name, Line: sec.line})
+		buf.WriteString(sec.text)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), sections
+}
+
+// sectioned adapts a loader that returns sections.
+func sectioned(render func(content []byte) ([]renderedSection, bool)) func([]byte) ([]byte, []*DocSection, bool) {
+	return func(content []byte) (out []byte, sections []*DocSection, ok bool) {
+		secs, ok := render(content)
+		if !ok {
+			return
+		}
+		out, sections = joinSections(secs)
+		return
+	}
+}
+
+// unsectioned adapts a loader whose output can't be traced back to
+// the original, e.g. markdown rendered from HTML.
+func unsectioned(render func(content []byte) ([]byte, bool)) func([]byte) ([]byte, []*DocSection, bool) {
+	return func(content []byte) (out []byte, sections []*DocSection, ok bool) {
+		out, ok = render(content)
+		return
+	}
+}
+
+// loaders are the built-in loaders; see apispec.go, iac.go, logs.go,
+// and html.go.
+var loaders = []loader{
+	{specExts, sectioned(renderSpec)},
+	{manifestExts, sectioned(renderManifest)},
+	{terraformExts, sectioned(renderTerraform)},
+	{logExts, sectioned(renderLog)},
+	{htmlExts, unsectioned(renderHTML)},
+}
+
+// loaderExt returns the extension that picks the loaders for
+// relpath, lower case.  A web page's URL often has no extension, or
+// one like .php, so a URL whose extension no loader claims is taken
+// to be HTML; renderHTML declines content that isn't.
+func loaderExt(relpath string) string {
+	if !IsURL(relpath) {
+		return strings.ToLower(path.Ext(relpath))
+	}
+	var ext string
+	u, err := url.Parse(relpath)
+	if err == nil {
+		ext = strings.ToLower(path.Ext(u.Path))
+	}
+	for _, l := range loaders {
+		for _, e := range l.exts {
+			if e == ext {
+				return ext
+			}
+		}
+	}
+	return ".html"
+}
+
+// isHTML returns true if the HTML loader may apply to relpath.
+func isHTML(relpath string) bool {
+	return util.StringInSlice(loaderExt(relpath), htmlExts)
+}
+
+// load renders content with the first loader for relpath that
+// accepts it.
+func load(relpath string, content []byte) (out []byte, sections []*DocSection, ok bool) {
+	ext := loaderExt(relpath)
+	for _, l := range loaders {
+		for _, e := range l.exts {
+			if e != ext {
+				continue
+			}
+			out, sections, ok = l.render(content)
+			if ok {
+				return
+			}
+		}
+	}
+	return
+}
+
+// hasLoader returns true if a loader may apply to relpath.
+func hasLoader(relpath string) bool {
+	ext := loaderExt(relpath)
+	for _, l := range loaders {
+		for _, e := range l.exts {
+			if e == ext {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transform runs the hooks that match a document, or if none do,
+// the built-in loader for it, saving the result as the content to
+// chunk.
+func (g *Grokker) transform(doc *Document) (err error) {
+	defer Return(&err)
+	var hooks []*Hook
+	for _, h := range g.Hooks {
+		if !gitignore.CompileIgnoreLines(h.Pattern).MatchesPath(doc.RelPath) {
+			continue
+		}
+		if g.noHooks && h.Command != stripLicense {
+			Debug("skipping hook %q on %s", h.Command, doc.RelPath)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	if len(hooks) > 0 {
+		for _, h := range g.UntrustedHooks() {
+			if slices.Contains(hooks, h) {
+				err = fmt.Errorf("index hook %q for %q isn't trusted; review the hooks with 'grok hook ls', then run 'grok hook trust'", h.Command, h.Pattern)
+				return
+			}
+		}
+	}
+	fn := g.transformedPath(doc.RelPath)
+	transformed := false
+	var content []byte
+	doc.Sections = nil
+	plugin := g.loaderPlugin(doc.RelPath)
+	if len(hooks) > 0 || plugin != "" || hasLoader(doc.RelPath) {
+		content, err = os.ReadFile(g.absPath(doc))
+		Ck(err)
+	}
+	if len(hooks) > 0 {
+		for _, h := range hooks {
+			Debug("running hook %q on %s", h.Command, doc.RelPath)
+			content, err = g.runHook(h.Command, doc.RelPath, content)
+			Ck(err)
+		}
+		transformed = true
+	} else if plugin != "" {
+		// hooks take the place of loader plugins, and loader
+		// plugins take the place of the built-in loaders; see
+		// plugin.go
+		Debug("running loader %s on %s", plugin, doc.RelPath)
+		content, err = g.runFilter(exec.Command(plugin), Spf("loader %s", plugin), doc.RelPath, content)
+		Ck(err)
+		transformed = true
+	} else if content != nil {
+		// hooks take the place of the built-in loaders
+		content, doc.Sections, transformed = load(doc.RelPath, content)
+	}
+	if !transformed {
+		doc.Transformed = false
+		err = os.Remove(fn)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		Ck(err)
+		return
+	}
+	err = os.MkdirAll(g.transformedDir(), 0755)
+	Ck(err)
+	tmpfn := fn + ".tmp"
+	err = os.WriteFile(tmpfn, content, 0644)
+	Ck(err)
+	err = os.Rename(tmpfn, fn)
+	Ck(err)
+	doc.Transformed = true
+	return
+}
+
+// runHook runs a hook command on content and returns its output.
+func (g *Grokker) runHook(command, relpath string, content []byte) (out []byte, err error) {
+	defer Return(&err)
+	if command == stripLicense {
+		return stripLicenseHeader(content), nil
+	}
+	cmd, err := util.Command(command)
+	Ck(err)
+	out, err = g.runFilter(cmd, Spf("hook %q", command), relpath, content)
+	Ck(err)
+	return
+}
+
+// runFilter runs a hook or loader command in the root of the tree,
+// with content on stdin, and returns its output.
+func (g *Grokker) runFilter(cmd *exec.Cmd, what, relpath string, content []byte) (out []byte, err error) {
+	defer Return(&err)
+	cmd.Dir = g.Root
+	cmd.Env = append(os.Environ(), "GROKKER_DOCUMENT="+relpath)
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err = cmd.Output()
+	Ck(err, "%s on %s: %s", what, relpath, strings.TrimSpace(stderr.String()))
+	return
+}
+
+// commentLineRe matches blank lines and lines of the usual comment
+// styles.
+var commentLineRe = regexp.MustCompile(`^\s*($|//|#|/\*|\*|<!--|-->|--|;)`)
+
+// licenseRe matches the words that identify a license header.
+var licenseRe = regexp.MustCompile(`(?i)\b(license|copyright|spdx-license-identifier)\b`)
+
+// stripLicenseHeader removes the comment block at the top of content
+// if it mentions a license or copyright.
+func stripLicenseHeader(content []byte) []byte {
+	lines := strings.SplitAfter(string(content), "\n")
+	n := 0
+	for n < len(lines) && commentLineRe.MatchString(lines[n]) {
+		n++
+	}
+	header := strings.Join(lines[:n], "")
+	if !licenseRe.MatchString(header) {
+		return content
+	}
+	return []byte(strings.Join(lines[n:], ""))
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestStripLicenseHeader(t *testing.T) {
+	src := "// Copyright 2024 Example\n// Licensed under MIT.\n\npackage foo\n"
+	got := string(stripLicenseHeader([]byte(src)))
+	Tassert(t, got == "package foo\n", "unexpected output %q", got)
+	src = "// Package foo does things.\npackage foo\n"
+	got = string(stripLicenseHeader([]byte(src)))
+	Tassert(t, got == src, "expected a plain comment to be kept, got %q", got)
+}
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell command")
+	}
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "config"))
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "a.go"), []byte("// Copyright me\n\npackage a\n"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "b.md"), []byte("hello\n"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+
+	err = grok.AddHook("", "cat")
+	Tassert(t, err != nil, "expected an error for an empty pattern")
+	err = grok.AddHook("*.go", stripLicense)
+	Tassert(t, err == nil, "error adding hook: %v", err)
+	err = grok.AddHook("*.go", "tr a-z A-Z")
+	Tassert(t, err == nil, "error adding hook: %v", err)
+
+	a := &Document{RelPath: "a.go"}
+	b := &Document{RelPath: "b.md"}
+	chunks, err := grok.chunksFromDoc(a)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, a.Transformed && len(chunks) > 0, "expected a.go to be transformed")
+	text, err := grok.chunkText(chunks[0], false, false)
+	Tassert(t, err == nil, "error reading chunk: %v", err)
+	Tassert(t, text == "PACKAGE A\n", "unexpected chunk text %q", text)
+	// a hook's output has no lines of the original to cite
+	cite, err := grok.citation(chunks[0])
+	Tassert(t, err == nil && cite == "a.go", "expected no line, got %q: %v", cite, err)
+	chunks, err = grok.chunksFromDoc(b)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, !b.Transformed, "expected b.md to be left alone")
+
+	// a hook that came with the db, e.g. from a pull request, doesn't
+	// run until it is trusted
+	grok.Hooks = append(grok.Hooks, &Hook{Pattern: "*.md", Command: "touch pwned"})
+	untrusted := grok.UntrustedHooks()
+	Tassert(t, len(untrusted) == 1 && untrusted[0].Command == "touch pwned", "got %v", untrusted)
+	_, err = grok.chunksFromDoc(b)
+	Tassert(t, err != nil && strings.Contains(err.Error(), "grok hook trust"), "expected an untrusted hook error, got %v", err)
+	_, err = os.Stat(filepath.Join(dir, "pwned"))
+	Tassert(t, os.IsNotExist(err), "expected the untrusted hook not to run")
+	err = grok.TrustHooks()
+	Tassert(t, err == nil, "error trusting hooks: %v", err)
+	Tassert(t, len(grok.UntrustedHooks()) == 0, "expected every hook trusted")
+	_, err = grok.chunksFromDoc(b)
+	Tassert(t, err == nil && b.Transformed, "expected the trusted hook to run: %v", err)
+	grok.Hooks = grok.Hooks[:len(grok.Hooks)-1]
+
+	// removing the hooks goes back to the original content
+	err = grok.RemoveHooks("*.go")
+	Tassert(t, err == nil, "error removing hooks: %v", err)
+	err = grok.RemoveHooks("*.go")
+	Tassert(t, err != nil, "expected an error for a missing hook")
+	chunks, err = grok.chunksFromDoc(a)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, !a.Transformed, "expected a.go to be untransformed")
+	_, err = os.Stat(grok.transformedPath("a.go"))
+	Tassert(t, os.IsNotExist(err), "expected the transformed copy to be removed")
+}
@@ -0,0 +1,493 @@
+package core
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// HTML files and web pages are indexed as the text a reader sees,
+// not their markup.  Scripts, styles, and comments are dropped, and
+// so is the boilerplate around the content: navigation, the page's
+// header and footer, sidebars, and forms.  If the page marks its
+// content with a main element, or failing that with article
+// elements, only that is kept.  What's left is rendered as markdown
+// -- headings as # lines, list items as - lines, and preformatted
+// text as code fences -- so it is chunked at its headings, like a
+// markdown file; see mdchunk.go.  Like API specs, this is a
+// built-in loader; see hooks.go.
+//
+// We scan the markup with a few patterns rather than parse it, the
+// way symbols.go finds identifiers: a tag we don't understand is
+// just skipped, and unclosed paragraphs and list items, which HTML
+// allows, are closed by their parent.
+
+// htmlExts are the extensions of HTML files.
+var htmlExts = []string{".html", ".htm", ".xhtml"}
+
+// htmlSniffRe matches the start of an HTML document, so that
+// renderHTML declines a web page that turns out to be something
+// else.
+var htmlSniffRe = regexp.MustCompile(`(?i)<(!doctype\s+html|html|head|body)[\s>]`)
+
+// htmlSniffBytes is how far into content we look for htmlSniffRe.
+const htmlSniffBytes = 4096
+
+// htmlTagRe matches the start of a tag, its name, and, if the tag is
+// an end tag, the slash.
+var htmlTagRe = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9-]*)`)
+
+// htmlRoleRe finds an element's ARIA role.
+var htmlRoleRe = regexp.MustCompile(`(?i)\brole\s*=\s*["']?([a-z]+)`)
+
+// htmlHiddenRe matches the attributes of an element that isn't shown.
+var htmlHiddenRe = regexp.MustCompile(`(?i)(^|\s)hidden(\s|=|$)|aria-hidden\s*=\s*["']?true`)
+
+// htmlRawText are the elements whose content isn't markup, which we
+// skip whole.
+var htmlRawText = map[string]bool{"script": true, "style": true}
+
+// htmlVoid are the elements that have no content or end tag.
+var htmlVoid = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlBoilerplate are the elements whose content is never the
+// page's content.  header is boilerplate only outside an article or
+// main, where it usually holds the title.
+var htmlBoilerplate = map[string]bool{
+	"head": true, "nav": true, "footer": true, "aside": true,
+	"form": true, "button": true, "select": true, "dialog": true,
+	"noscript": true, "template": true, "svg": true, "iframe": true,
+}
+
+// htmlBoilerplateRoles are the ARIA roles of boilerplate.
+var htmlBoilerplateRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true,
+	"complementary": true, "search": true,
+}
+
+// htmlBlocks are the elements that start a paragraph.
+var htmlBlocks = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "body": true,
+	"dd": true, "details": true, "div": true, "dl": true, "dt": true,
+	"figcaption": true, "figure": true, "hr": true, "main": true,
+	"ol": true, "p": true, "section": true, "summary": true,
+	"table": true, "ul": true,
+}
+
+// htmlToken is a tag or a run of text.
+type htmlToken struct {
+	// tag is the lower-case name of a tag, or "" for text
+	tag   string
+	end   bool
+	attrs string
+	// text is the text of a text token, unescaped
+	text string
+}
+
+// renderHTML renders the content of an HTML document as markdown,
+// without the boilerplate; it returns false if content isn't HTML or
+// has no text.
+func renderHTML(content []byte) (out []byte, ok bool) {
+	s := string(content)
+	head := s
+	if len(head) > htmlSniffBytes {
+		head = head[:htmlSniffBytes]
+	}
+	if !htmlSniffRe.MatchString(head) {
+		return
+	}
+	tokens := htmlTokens(s)
+
+	// keep only the main content if the page marks it
+	scope := ""
+	for _, t := range tokens {
+		if t.tag == "" || t.end {
+			continue
+		}
+		if t.tag == "main" || htmlRole(t.attrs) == "main" {
+			scope = "main"
+			break
+		}
+		if t.tag == "article" {
+			scope = "article"
+		}
+	}
+
+	type open struct {
+		tag              string
+		skip, scope, pre bool
+	}
+	var stack []open
+	var skipping, inScope, inPre, inArticle int
+	push := func(o open) {
+		stack = append(stack, o)
+		if o.skip {
+			skipping++
+		}
+		if o.scope {
+			inScope++
+		}
+		if o.pre {
+			inPre++
+		}
+		if o.tag == "article" || o.tag == "main" {
+			inArticle++
+		}
+	}
+	pop := func() open {
+		o := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if o.skip {
+			skipping--
+		}
+		if o.scope {
+			inScope--
+		}
+		if o.pre {
+			inPre--
+		}
+		if o.tag == "article" || o.tag == "main" {
+			inArticle--
+		}
+		return o
+	}
+
+	var w htmlWriter
+	var title strings.Builder
+	inTitle := false
+	h1 := false
+	for _, t := range tokens {
+		shown := skipping == 0 && (scope == "" || inScope > 0)
+		switch {
+		case t.tag == "":
+			switch {
+			case inTitle:
+				title.WriteString(t.text)
+			case !shown:
+			case inPre > 0:
+				w.raw(t.text)
+			default:
+				w.text(t.text)
+			}
+		case t.tag == "title":
+			// only the first; an svg can have a title too
+			inTitle = !t.end && title.Len() == 0
+		case !t.end:
+			if htmlVoid[t.tag] {
+				if shown && (t.tag == "br" || t.tag == "hr") {
+					w.lineBreak(inPre > 0)
+				}
+				continue
+			}
+			role := htmlRole(t.attrs)
+			o := open{tag: t.tag}
+			o.skip = htmlBoilerplate[t.tag] || htmlBoilerplateRoles[role] ||
+				(t.tag == "header" && inArticle == 0) ||
+				htmlHiddenRe.MatchString(t.attrs)
+			o.scope = (scope == "main" && (t.tag == "main" || role == "main")) ||
+				(scope == "article" && t.tag == "article")
+			o.pre = t.tag == "pre"
+			push(o)
+			if skipping > 0 || (scope != "" && inScope == 0) || (inPre > 0 && !(o.pre && inPre == 1)) {
+				continue
+			}
+			switch {
+			case t.tag == "pre":
+				w.fence()
+			case isHeading(t.tag):
+				w.paragraph()
+				w.prefix = strings.Repeat("#", int(t.tag[1]-'0')) + " "
+				if t.tag == "h1" {
+					h1 = true
+				}
+			case t.tag == "li":
+				w.line()
+				w.prefix = "- "
+			case t.tag == "tr":
+				w.line()
+			case t.tag == "td" || t.tag == "th":
+				w.cell()
+			case htmlBlocks[t.tag]:
+				w.paragraph()
+			}
+		default:
+			// close the element and any left open inside it
+			i := len(stack) - 1
+			for i >= 0 && stack[i].tag != t.tag {
+				i--
+			}
+			if i < 0 {
+				continue
+			}
+			for len(stack) > i {
+				o := pop()
+				if !shown {
+					continue
+				}
+				switch {
+				case o.pre && inPre == 0:
+					w.endFence()
+				case inPre > 0:
+				case o.tag == "li":
+					w.prefix = ""
+					w.line()
+				case isHeading(o.tag):
+					w.prefix = ""
+					w.paragraph()
+				case htmlBlocks[o.tag]:
+					w.paragraph()
+				}
+			}
+		}
+	}
+	if inPre > 0 {
+		w.endFence()
+	}
+
+	text := strings.TrimSpace(w.b.String())
+	if text == "" {
+		return
+	}
+	if t := strings.Join(strings.Fields(title.String()), " "); t != "" && !h1 {
+		text = "# " + t + "\n\n" + text
+	}
+	return []byte(text + "\n"), true
+}
+
+// htmlRole returns the ARIA role in an element's attributes, lower
+// case, or "".
+func htmlRole(attrs string) string {
+	m := htmlRoleRe.FindStringSubmatch(attrs)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// htmlTokens scans HTML into tags and text, dropping comments,
+// declarations, and the content of scripts and styles.
+func htmlTokens(s string) (tokens []htmlToken) {
+	textStart := 0
+	flush := func(end int) {
+		if end > textStart {
+			tokens = append(tokens, htmlToken{text: html.UnescapeString(s[textStart:end])})
+		}
+	}
+	i := 0
+	for i < len(s) {
+		lt := strings.IndexByte(s[i:], '<')
+		if lt < 0 {
+			break
+		}
+		i += lt
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			flush(i)
+			end := strings.Index(rest[4:], "-->")
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += 4 + end + 3
+			}
+			textStart = i
+			continue
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			flush(i)
+			i = tagEnd(s, i)
+			textStart = i
+			continue
+		}
+		m := htmlTagRe.FindStringSubmatch(rest)
+		if m == nil {
+			// a literal <
+			i++
+			continue
+		}
+		flush(i)
+		end := tagEnd(s, i)
+		tag := strings.ToLower(m[2])
+		attrs := strings.TrimSuffix(s[i+len(m[0]):end], ">")
+		i = end
+		textStart = i
+		if m[1] == "" && htmlRawText[tag] {
+			// skip to the end tag
+			close := indexEndTag(s[i:], tag)
+			if close < 0 {
+				i = len(s)
+			} else {
+				i = tagEnd(s, i+close)
+			}
+			textStart = i
+			continue
+		}
+		tokens = append(tokens, htmlToken{tag: tag, end: m[1] == "/", attrs: attrs})
+	}
+	flush(len(s))
+	return
+}
+
+// indexEndTag returns the offset of the first end tag for tag in s,
+// in any case, or -1.
+func indexEndTag(s, tag string) int {
+	i := 0
+	for {
+		j := strings.Index(s[i:], "</")
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if len(s) >= i+2+len(tag) && strings.EqualFold(s[i+2:i+2+len(tag)], tag) {
+			return i
+		}
+		i += 2
+	}
+}
+
+// isHeading returns true if tag is h1 through h6.
+func isHeading(tag string) bool {
+	return len(tag) == 2 && tag[0] == 'h' && tag[1] >= '1' && tag[1] <= '6'
+}
+
+// tagEnd returns the offset just past the > that ends the tag
+// starting at s[i], skipping quoted attribute values.
+func tagEnd(s string, i int) int {
+	var quote byte
+	for j := i + 1; j < len(s); j++ {
+		switch c := s[j]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// htmlWriter writes the text of an HTML document as markdown,
+// collapsing whitespace.  Breaks are written lazily, so there are
+// none at the start or end, and the strongest of several in a row
+// wins.
+type htmlWriter struct {
+	b strings.Builder
+	// breaks is the number of newlines to write before more text
+	breaks int
+	// space is true if a space is due before more text
+	space bool
+	// prefix is written before more text, e.g. "## "
+	prefix string
+	// preStart is true at the start of a pre, where a newline is
+	// ignored
+	preStart bool
+}
+
+// flush writes the pending breaks and prefix.
+func (w *htmlWriter) flush() {
+	if w.b.Len() > 0 && w.breaks > 0 {
+		w.b.WriteString(strings.Repeat("\n", w.breaks))
+		w.space = false
+	}
+	w.breaks = 0
+	if w.prefix != "" {
+		w.b.WriteString(w.prefix)
+		w.prefix = ""
+		w.space = false
+	}
+}
+
+// text writes text, collapsing its whitespace.
+func (w *htmlWriter) text(s string) {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+	leading := strings.TrimLeftFunc(s, unicode.IsSpace) != s
+	w.flush()
+	if (w.space || leading) && !w.atLineStart() {
+		w.b.WriteByte(' ')
+	}
+	w.b.WriteString(strings.Join(words, " "))
+	w.space = strings.TrimRightFunc(s, unicode.IsSpace) != s
+}
+
+// raw writes preformatted text as it is.
+func (w *htmlWriter) raw(s string) {
+	if w.preStart {
+		s = strings.TrimPrefix(s, "\n")
+		w.preStart = false
+	}
+	w.flush()
+	w.b.WriteString(s)
+}
+
+// atLineStart returns true if nothing has been written on the
+// current line, or the last thing written was a space.
+func (w *htmlWriter) atLineStart() bool {
+	s := w.b.String()
+	return s == "" || strings.HasSuffix(s, "\n") || strings.HasSuffix(s, " ")
+}
+
+// breakAt asks for n newlines before more text.
+func (w *htmlWriter) breakAt(n int) {
+	if n > w.breaks {
+		w.breaks = n
+	}
+	w.space = false
+}
+
+// paragraph starts a new paragraph.
+func (w *htmlWriter) paragraph() {
+	w.breakAt(2)
+}
+
+// line starts a new line.
+func (w *htmlWriter) line() {
+	w.breakAt(1)
+}
+
+// lineBreak writes a br, which inside a pre is a literal newline.
+func (w *htmlWriter) lineBreak(pre bool) {
+	if pre {
+		w.raw("\n")
+		return
+	}
+	w.line()
+}
+
+// cell separates a table cell from the one before it.
+func (w *htmlWriter) cell() {
+	if w.breaks == 0 && !w.atLineStart() {
+		w.b.WriteString(" | ")
+		w.space = false
+	}
+}
+
+// fence starts a code fence for a pre.
+func (w *htmlWriter) fence() {
+	w.paragraph()
+	w.flush()
+	w.b.WriteString("```\n")
+	w.preStart = true
+}
+
+// endFence ends the code fence of a pre.
+func (w *htmlWriter) endFence() {
+	w.preStart = false
+	if !strings.HasSuffix(w.b.String(), "\n") {
+		w.b.WriteString("\n")
+	}
+	w.b.WriteString("```")
+	w.paragraph()
+}
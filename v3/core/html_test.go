@@ -0,0 +1,112 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Widgets &amp; Gadgets</title>
+<style>body { color: red; }</style>
+<script>var nav = "<nav>";</script>
+</head>
+<body>
+<header><a href="/">Home</a></header>
+<nav><ul><li>Docs</li><li>Blog</li></ul></nav>
+<div role="main">
+<h2>Installing   widgets</h2>
+<!-- a comment -->
+<p>Widgets are <b>blue</b>,&nbsp;mostly.
+<p>Install them with:</p>
+<pre>
+go install widgets
+</pre>
+<ul>
+<li>fast
+<li>cheap</li>
+</ul>
+<table><tr><th>Size</th><th>Price</th></tr><tr><td>S</td><td>$1</td></tr></table>
+<aside>Subscribe!</aside>
+</div>
+<footer>Copyright</footer>
+</body>
+</html>
+`
+
+func TestRenderHTML(t *testing.T) {
+	out, ok := renderHTML([]byte(testPage))
+	Tassert(t, ok, "expected HTML")
+	expect := "# Widgets & Gadgets\n\n" +
+		"## Installing widgets\n\n" +
+		"Widgets are blue, mostly.\n\n" +
+		"Install them with:\n\n" +
+		"```\ngo install widgets\n```\n\n" +
+		"- fast\n- cheap\n\n" +
+		"Size | Price\nS | $1\n"
+	Tassert(t, string(out) == expect, "got:\n%s", out)
+
+	// without a main, the articles are the content
+	out, ok = renderHTML([]byte("<html><body><p>Ads</p><article><header><h1>Post</h1></header><p>Text</p></article></body></html>"))
+	Tassert(t, ok, "expected HTML")
+	Tassert(t, string(out) == "# Post\n\nText\n", "got:\n%s", out)
+
+	_, ok = renderHTML([]byte("# Widgets\n\nWidgets are <b>blue</b>.\n"))
+	Tassert(t, !ok, "rendered markdown as HTML")
+	_, ok = renderHTML([]byte("<html><body><nav>Home</nav></body></html>"))
+	Tassert(t, !ok, "rendered a page with no content")
+}
+
+func TestAddURL(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/docs/widgets":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(testPage))
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	name, err := grok.AddURL(srv.URL + "/docs/widgets#install")
+	Tassert(t, err == nil, "error adding the page: %v", err)
+	Tassert(t, name == srv.URL+"/docs/widgets", "got name %s", name)
+	var doc *Document
+	for _, d := range grok.Documents {
+		if d.RelPath == name {
+			doc = d
+		}
+	}
+	Tassert(t, doc != nil && doc.Virtual && doc.Transformed, "expected a transformed virtual document: %+v", doc)
+	var texts []string
+	for _, c := range grok.Chunks {
+		text, err := grok.chunkText(c, true, false)
+		Ck(err)
+		texts = append(texts, text)
+	}
+	all := strings.Join(texts, "\n")
+	Tassert(t, strings.Contains(all, "from "+name+" (Widgets & Gadgets > Installing widgets):"), "expected the URL and section in a header:\n%s", all)
+	Tassert(t, strings.Contains(all, "blue") && !strings.Contains(all, "Subscribe") && !strings.Contains(all, "Home"), "expected the content without the boilerplate:\n%s", all)
+
+	_, err = grok.AddURL(srv.URL + "/missing")
+	Tassert(t, err != nil && strings.Contains(err.Error(), "404"), "expected a 404, got %v", err)
+	_, err = grok.AddURL(srv.URL + "/logo.png")
+	Tassert(t, err != nil && strings.Contains(err.Error(), "not text"), "expected an error for an image, got %v", err)
+	_, err = grok.AddURL("ftp://example.com/x")
+	Tassert(t, err != nil, "expected an error for an ftp URL")
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Infrastructure-as-code files are indexed one chunk per resource,
+// so a question like "where is the prod S3 bucket policy defined?"
+// retrieves the whole resource rather than a fragment of a long
+// manifest.  Each Kubernetes object in a YAML file is headed by its
+// kind, namespace, and name, e.g. "Deployment prod/web", and each
+// top-level Terraform block by its address, e.g. "resource
+// aws_s3_bucket_policy.prod", which is also the chunk's section, and
+// cited at the line where it starts in the file.  Like API specs,
+// these are built-in loaders; see hooks.go.
+
+// manifestExts are the extensions of files that may be Kubernetes
+// manifests.
+var manifestExts = []string{".yaml", ".yml"}
+
+// terraformExts are the extensions of Terraform files.
+var terraformExts = []string{".tf"}
+
+// renderManifest renders each Kubernetes object in a YAML stream as
+// a section.  It returns false unless every document in the stream
+// is an object with an apiVersion and a kind.
+func renderManifest(content []byte) (sections []renderedSection, ok bool) {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if len(doc.Content) == 0 {
+			// an empty document between separators
+			continue
+		}
+		objs := []*yaml.Node{doc.Content[0]}
+		if scalar(mapValue(objs[0], "kind")) == "List" {
+			// kubectl output wraps objects in a List
+			items := mapValue(objs[0], "items")
+			if items == nil || items.Kind != yaml.SequenceNode {
+				return
+			}
+			objs = items.Content
+		}
+		for _, obj := range objs {
+			kind := scalar(mapValue(obj, "kind"))
+			if kind == "" || scalar(mapValue(obj, "apiVersion")) == "" {
+				return
+			}
+			meta := mapValue(obj, "metadata")
+			name := scalar(mapValue(meta, "name"))
+			if ns := scalar(mapValue(meta, "namespace")); ns != "" {
+				name = ns + "/" + name
+			}
+			sections = append(sections, section(strings.TrimSpace(kind+" "+name), obj.Line, obj))
+		}
+	}
+	if len(sections) == 0 {
+		return
+	}
+	return sections, true
+}
+
+// tfBlockRe matches the first line of a top-level Terraform block,
+// capturing its type and labels.
+var tfBlockRe = regexp.MustCompile(`^([A-Za-z_][\w-]*)((?:\s+(?:"[^"]*"|[A-Za-z_][\w-]*))*)\s*\{`)
+
+// tfHeredocRe matches the start of a heredoc string.
+var tfHeredocRe = regexp.MustCompile(`<<-?\s*([A-Za-z_]\w*)\s*$`)
+
+// tfLine is a line of a Terraform file and its line number, or 0
+// for a heading.
+type tfLine struct {
+	text string
+	n    int
+}
+
+// renderTerraform renders each top-level block of a Terraform file as
+// a section headed by its address.  Comments directly above a block
+// stay with it.  It returns false if the file has no blocks.
+func renderTerraform(content []byte) (sections []renderedSection, ok bool) {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	var cur []tfLine
+	name := ""
+	depth := 0
+	heredoc := ""
+	blocks := 0
+	flush := func() {
+		sec := renderedSection{name: name}
+		var keep []string
+		for _, l := range cur {
+			if strings.TrimSpace(l.text) == "" {
+				continue
+			}
+			keep = append(keep, l.text)
+			if sec.line == 0 {
+				sec.line = l.n
+			}
+		}
+		if len(keep) > 0 {
+			sec.text = strings.Join(keep, "\n")
+			sections = append(sections, sec)
+		}
+		cur = nil
+		name = ""
+	}
+	for i, line := range lines {
+		if heredoc != "" {
+			cur = append(cur, tfLine{line, i + 1})
+			if strings.TrimSpace(line) == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if depth == 0 {
+			m := tfBlockRe.FindStringSubmatch(trimmed)
+			switch {
+			case m != nil:
+				// keep the comments directly above the block
+				start := len(cur)
+				for start > 0 && isTfComment(cur[start-1].text) {
+					start--
+				}
+				comments := cur[start:]
+				cur = cur[:start]
+				flush()
+				name = tfAddress(m[1], m[2])
+				cur = append([]tfLine{{name, 0}}, comments...)
+				blocks++
+			case trimmed == "":
+				flush()
+			}
+		}
+		cur = append(cur, tfLine{line, i + 1})
+		depth += tfDepth(line)
+		if depth < 0 {
+			return
+		}
+		if m := tfHeredocRe.FindStringSubmatch(line); m != nil {
+			heredoc = m[1]
+		}
+		if depth == 0 && blocks > 0 && trimmed != "" && !isTfComment(line) {
+			flush()
+		}
+	}
+	flush()
+	if blocks == 0 || depth != 0 {
+		return
+	}
+	return sections, true
+}
+
+// tfAddress returns the address of a block, e.g.
+// "resource aws_s3_bucket.logs" or "module vpc".
+func tfAddress(typ, labels string) string {
+	var names []string
+	for _, label := range strings.Fields(labels) {
+		names = append(names, strings.Trim(label, `"`))
+	}
+	switch typ {
+	case "resource", "data":
+		return typ + " " + strings.Join(names, ".")
+	}
+	return strings.TrimSpace(typ + " " + strings.Join(names, " "))
+}
+
+// isTfComment returns true if line is a comment.
+func isTfComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") ||
+		strings.HasPrefix(trimmed, "/*") || strings.HasPrefix(trimmed, "*")
+}
+
+// tfDepth returns the change in brace depth over a line, ignoring
+// braces in strings and comments.
+func tfDepth(line string) (delta int) {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '#', c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return
+		case c == '{':
+			delta++
+		case c == '}':
+			delta--
+		}
+	}
+	return
+}
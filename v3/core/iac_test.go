@@ -0,0 +1,93 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestRenderManifest(t *testing.T) {
+	src := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  replicas: 3
+
+  template: {}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`
+	secs, ok := renderManifest([]byte(src))
+	out, _ := joinSections(secs)
+	Tassert(t, ok, "expected a manifest to be rendered")
+	sections := strings.Split(strings.TrimSpace(string(out)), "\n\n")
+	Tassert(t, len(sections) == 2, "expected 2 sections, got %d: %q", len(sections), out)
+	Tassert(t, strings.HasPrefix(sections[0], "Deployment prod/web\n"), "unexpected section %q", sections[0])
+	Tassert(t, strings.Contains(sections[0], "template"), "expected the whole object in %q", sections[0])
+	Tassert(t, strings.HasPrefix(sections[1], "Service web\n"), "unexpected section %q", sections[1])
+	Tassert(t, secs[1].name == "Service web" && secs[1].line == 11, "got %+v", secs[1])
+
+	_, ok = renderManifest([]byte("name: ci\non: push\n"))
+	Tassert(t, !ok, "expected plain YAML to be left alone")
+}
+
+func TestRenderTerraform(t *testing.T) {
+	src := `provider "aws" {
+  region = "us-east-1"
+}
+
+# the prod bucket policy
+resource "aws_s3_bucket_policy" "prod" {
+  bucket = aws_s3_bucket.prod.id
+
+  policy = <<EOF
+{"Statement": [
+EOF
+}
+module "vpc" {
+  source = "./vpc" # {
+}
+`
+	secs, ok := renderTerraform([]byte(src))
+	out, _ := joinSections(secs)
+	Tassert(t, ok, "expected Terraform to be rendered")
+	sections := strings.Split(strings.TrimSpace(string(out)), "\n\n")
+	Tassert(t, len(sections) == 3, "expected 3 sections, got %d: %q", len(sections), out)
+	Tassert(t, strings.HasPrefix(sections[0], "provider aws\n"), "unexpected section %q", sections[0])
+	Tassert(t, strings.HasPrefix(sections[1], "resource aws_s3_bucket_policy.prod\n# the prod bucket policy\n"), "unexpected section %q", sections[1])
+	Tassert(t, strings.HasSuffix(sections[1], "EOF\n}"), "expected the heredoc in %q", sections[1])
+	Tassert(t, strings.HasPrefix(sections[2], "module vpc\n"), "unexpected section %q", sections[2])
+	// a block is cited from the comments above it
+	Tassert(t, secs[1].name == "resource aws_s3_bucket_policy.prod" && secs[1].line == 5, "got %+v", secs[1])
+	Tassert(t, secs[2].line == 13, "got %+v", secs[2])
+
+	_, ok = renderTerraform([]byte("# nothing here\n"))
+	Tassert(t, !ok, "expected a file without blocks to be left alone")
+}
+
+func TestManifestChunks(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	src := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: b\n"
+	err = os.WriteFile(filepath.Join(dir, "cm.yaml"), []byte(src), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "cm.yaml"}
+	chunks, err := grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, doc.Transformed && len(chunks) == 2, "expected a chunk per object, got %d", len(chunks))
+	text, err := grok.chunkText(chunks[1], false, false)
+	Tassert(t, err == nil, "error reading chunk: %v", err)
+	Tassert(t, strings.HasPrefix(text, "ConfigMap b\n"), "unexpected chunk text %q", text)
+	Tassert(t, chunks[1].Section == "ConfigMap b", "got section %q", chunks[1].Section)
+	cite, err := grok.citation(chunks[1])
+	Tassert(t, err == nil && cite == "cm.yaml:6", "expected the line in the manifest, got %q: %v", cite, err)
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"errors"
+)
+
+// The iterators and Visit below let programs that embed grokker, and
+// subcommands, read the corpus without depending on how Documents and
+// Chunks are stored.  The iterators have the signatures of iter.Seq
+// and iter.Seq2, so Go 1.23 and later can range over them:
+//
+//	for doc := range g.AllDocuments() {
+//		...
+//	}
+//	for ref, err := range g.AllChunks() {
+//		...
+//	}
+//
+// They iterate over a copy of the database's lists, so the database
+// can be changed while iterating; the changes aren't seen until the
+// next iteration.
+
+// SkipDocument, returned by a VisitFunc, skips the rest of the
+// current document's chunks.
+var SkipDocument = errors.New("skip this document")
+
+// VisitFunc is called by Visit for each chunk.  Returning
+// SkipDocument skips the rest of the document's chunks; any other
+// error stops the visit and is returned by Visit.
+type VisitFunc func(doc *Document, ref *ChunkRef) error
+
+// AllDocuments returns an iterator over the documents in the
+// database, in the order they were added.
+func (g *Grokker) AllDocuments() func(yield func(*Document) bool) {
+	docs := append([]*Document(nil), g.Documents...)
+	return func(yield func(*Document) bool) {
+		for _, doc := range docs {
+			if !yield(doc) {
+				return
+			}
+		}
+	}
+}
+
+// AllChunks returns an iterator over the chunks in the database,
+// grouped by document, in the order AllDocuments returns the
+// documents.  Chunks are read from their documents as they are
+// yielded; a chunk whose document has changed since it was indexed
+// is skipped, as ChunkByID doesn't resolve it.  An error reading a
+// document is yielded with a nil ChunkRef.
+func (g *Grokker) AllChunks() func(yield func(*ChunkRef, error) bool) {
+	return func(yield func(*ChunkRef, error) bool) {
+		err := g.Visit(nil, func(doc *Document, ref *ChunkRef) error {
+			if !yield(ref, nil) {
+				return errStopVisit
+			}
+			return nil
+		})
+		if err != nil && err != errStopVisit {
+			yield(nil, err)
+		}
+	}
+}
+
+// errStopVisit stops the visit in AllChunks when the caller stops
+// ranging.
+var errStopVisit = errors.New("stop visit")
+
+// Visit calls fn for each chunk that passes the filter, grouped by
+// document, in the order AllDocuments returns the documents.  A nil
+// filter passes every chunk.  Chunks whose document has changed since
+// it was indexed are skipped; see AllChunks.
+func (g *Grokker) Visit(f *Filter, fn VisitFunc) (err error) {
+	err = g.LoadShards(f.paths()...)
+	if err != nil {
+		return err
+	}
+	byDoc := make(map[*Document][]*Chunk)
+	for _, c := range f.apply(g, g.Chunks) {
+		if c.Document != nil {
+			byDoc[c.Document] = append(byDoc[c.Document], c)
+		}
+	}
+	docs := append([]*Document(nil), g.Documents...)
+	for _, doc := range docs {
+		for _, c := range byDoc[doc] {
+			ref, ok, err := g.chunkRef(c)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			err = fn(doc, ref)
+			if err == SkipDocument {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return
+}
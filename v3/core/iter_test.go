@@ -0,0 +1,67 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestIterators(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello\nworld"), 0644)
+	Ck(err)
+	err = os.WriteFile(filepath.Join(dir, "b.md"), []byte("secret"), 0644)
+	Ck(err)
+	a := &Document{RelPath: "a.md", Collection: "docs"}
+	b := &Document{RelPath: "b.md", Collection: "private"}
+	grok.Documents = append(grok.Documents, a, b)
+	// chunks out of document order are grouped by document
+	grok.Chunks = []*Chunk{newChunk(a, "", 0, 5, "hello"), newChunk(b, "", 0, 6, "secret"), newChunk(a, "", 6, 5, "world")}
+
+	var paths []string
+	iter := grok.AllDocuments()
+	iter(func(doc *Document) bool {
+		paths = append(paths, doc.RelPath)
+		return true
+	})
+	Tassert(t, fmt.Sprint(paths) == "[a.md b.md]", "unexpected documents %v", paths)
+
+	var texts []string
+	grok.AllChunks()(func(ref *ChunkRef, err error) bool {
+		Tassert(t, err == nil, "unexpected error: %v", err)
+		texts = append(texts, ref.Text)
+		// stop early
+		return len(texts) < 2
+	})
+	Tassert(t, fmt.Sprint(texts) == "[hello world]", "unexpected chunks %v", texts)
+
+	// the visitor can filter and skip documents
+	texts = nil
+	err = grok.Visit(&Filter{Collections: []string{"docs"}}, func(doc *Document, ref *ChunkRef) error {
+		texts = append(texts, ref.Text)
+		return SkipDocument
+	})
+	Tassert(t, err == nil, "unexpected error: %v", err)
+	Tassert(t, fmt.Sprint(texts) == "[hello]", "unexpected chunks %v", texts)
+
+	// errors stop the visit
+	err = grok.Visit(nil, func(doc *Document, ref *ChunkRef) error {
+		return fmt.Errorf("stop at %s", ref.Path)
+	})
+	Tassert(t, err != nil && err.Error() == "stop at a.md", "unexpected error: %v", err)
+
+	// changed chunks are skipped
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello\nthere"), 0644)
+	Ck(err)
+	texts = nil
+	grok.AllChunks()(func(ref *ChunkRef, err error) bool {
+		texts = append(texts, ref.Text)
+		return true
+	})
+	Tassert(t, fmt.Sprint(texts) == "[hello secret]", "unexpected chunks %v", texts)
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The journal holds the changes made to the database since it was
+// last written in full.  Rewriting a large database means
+// serializing every embedding, so most saves instead append one line
+// to the journal describing the chunks that were added, changed, or
+// removed, along with the (small) rest of the database.  Load
+// replays the journal on top of the db file, and Save compacts the
+// journal back into the db file once it grows past
+// journalCompactRatio of the db file size.
+
+// journalCompactRatio is the journal size, as a fraction of the db
+// file size, at which Save rewrites the db file and removes the
+// journal.
+var journalCompactRatio = 0.25
+
+// journalEntry is one line of the journal.
+type journalEntry struct {
+	// The database without its chunks.
+	Header json.RawMessage
+	// Chunks that are new or changed, replacing any existing chunk
+	// with the same hash.
+	Chunks []*Chunk `json:",omitempty"`
+	// Hashes of chunks that were removed.
+	Removed []string `json:",omitempty"`
+}
+
+// journalPath returns the path of the journal file.
+func (g *Grokker) journalPath() string {
+	return g.grokpath + ".journal"
+}
+
+// chunkSig is a hash of the parts of a chunk that can change after
+// it is created, values and all, so we can tell which chunks need to
+// be journaled: a re-embedded chunk has a vector of the same length,
+// and a new prefilter replaces one of its vectors with another.
+// Floats are hashed as their bits, which is cheaper than JSON.
+func chunkSig(c *Chunk) string {
+	h := sha256.New()
+	str := func(s string) {
+		Fpf(h, "%d:%s", len(s), s)
+	}
+	vec := func(v []float64) {
+		if v == nil {
+			str("nil")
+			return
+		}
+		buf := make([]byte, 8*len(v))
+		for i, f := range v {
+			binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(f))
+		}
+		Fpf(h, "%d:", len(v))
+		h.Write(buf)
+	}
+	str(c.Document.RelPath)
+	Fpf(h, "%d/%d/%d/", c.Offset, c.Length, c.Line)
+	str(c.Section)
+	str(c.Text)
+	str(c.Excluded)
+	vec(c.Embedding)
+	for _, list := range [][]string{c.Symbols, c.Langs} {
+		Fpf(h, "%d:", len(list))
+		for _, s := range list {
+			str(s)
+		}
+	}
+	specs := make([]string, 0, len(c.Vectors))
+	for spec := range c.Vectors {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+	for _, spec := range specs {
+		str(spec)
+		vec(c.Vectors[spec])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// markSaved records the chunks as they are in the saved db, so the
+// next save only needs to journal the differences.
+func (g *Grokker) markSaved() {
+	g.savedSigs = make(map[string]string, len(g.Chunks))
+	for _, c := range g.Chunks {
+		g.savedSigs[c.Hash] = chunkSig(c)
+	}
+}
+
+// header returns the JSON for the database without its chunks.
+func (g *Grokker) header() (buf []byte, err error) {
+	defer Return(&err)
+	chunks := g.Chunks
+	g.Chunks = nil
+	defer func() { g.Chunks = chunks }()
+	buf, err = json.Marshal(g)
+	Ck(err)
+	return
+}
+
+// needsCompaction returns true if Save should rewrite the whole db
+// file instead of appending to the journal.
+func (g *Grokker) needsCompaction() bool {
+	if g.savedSigs == nil {
+		// we don't know what's on disk
+		return true
+	}
+	dbfi, err := os.Stat(g.grokpath)
+	if err != nil || dbfi.Size() == 0 {
+		return true
+	}
+	jfi, err := os.Stat(g.journalPath())
+	if err != nil {
+		return false
+	}
+	return float64(jfi.Size()) > float64(dbfi.Size())*journalCompactRatio
+}
+
+// appendJournal appends the changes since the last save to the
+// journal.
+func (g *Grokker) appendJournal() (err error) {
+	defer Return(&err)
+	entry := journalEntry{}
+	entry.Chunks, entry.Removed = g.changedChunks()
+	// the header carries the store checksum, so it comes last
+	g.setChecksums(entry.Chunks)
+	entry.Header, err = g.header()
+	Ck(err)
+	buf, err := json.Marshal(entry)
+	Ck(err)
+	buf = append(buf, '\n')
+	fh, err := os.OpenFile(g.journalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	Ck(err)
+	_, err = fh.Write(buf)
+	if err != nil {
+		fh.Close()
+		Ck(err)
+	}
+	err = fh.Close()
+	Ck(err)
+	Debug("journaled %d chunks, removed %d", len(entry.Chunks), len(entry.Removed))
+	return
+}
+
+// replayJournal applies the journal, if any, to the database that
+// was just loaded from the db file.
+func (g *Grokker) replayJournal() (err error) {
+	defer Return(&err)
+	fh, err := os.Open(g.journalPath())
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	// entries can be as large as a whole database
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		var entry journalEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// a torn write at the end of the journal; the save
+			// that wrote it didn't complete
+			Fpf(os.Stderr, "warning: ignoring incomplete journal entry %d in %s\n", n+1, g.journalPath())
+			err = nil
+			break
+		}
+		// the header replaces the rest of the database, including
+		// fields it omits because they were emptied
+		chunks, grokpath := g.Chunks, g.grokpath
+		*g = Grokker{}
+		err = json.Unmarshal(entry.Header, g)
+		Ck(err)
+		g.Chunks, g.grokpath = chunks, grokpath
+		g.applyJournal(entry)
+		n++
+	}
+	err = scanner.Err()
+	Ck(err)
+	Debug("replayed %d journal entries", n)
+	return
+}
+
+// applyJournal applies one journal entry to the chunks.
+func (g *Grokker) applyJournal(entry journalEntry) {
+	removed := make(map[string]bool)
+	for _, hash := range entry.Removed {
+		removed[hash] = true
+	}
+	changed := make(map[string]*Chunk)
+	for _, c := range entry.Chunks {
+		changed[c.Hash] = c
+	}
+	var chunks []*Chunk
+	for _, c := range g.Chunks {
+		if removed[c.Hash] {
+			continue
+		}
+		if nc, ok := changed[c.Hash]; ok {
+			c = nc
+			delete(changed, c.Hash)
+		}
+		chunks = append(chunks, c)
+	}
+	// keep the journal's order for new chunks
+	for _, c := range entry.Chunks {
+		if _, ok := changed[c.Hash]; ok {
+			chunks = append(chunks, c)
+		}
+	}
+	g.Chunks = chunks
+}
+
+// journalMtime returns the modification time of the journal, or the
+// zero time if there is no journal.
+func (g *Grokker) journalMtime() time.Time {
+	fi, err := os.Stat(g.journalPath())
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
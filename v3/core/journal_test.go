@@ -0,0 +1,91 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestJournal(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	for i := 0; i < 3; i++ {
+		grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: i, Length: 1, Hash: Spf("h%d", i), Embedding: []float64{float64(i)}})
+	}
+
+	// saves after Init append to the journal
+	journalCompactRatio = 100
+	err = grok.Save()
+	Tassert(t, err == nil, "error saving: %v", err)
+	_, err = os.Stat(grok.journalPath())
+	Tassert(t, err == nil, "expected a journal: %v", err)
+
+	load := func() *Grokker {
+		g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", true)
+		Tassert(t, err == nil, "error loading: %v", err)
+		lock.Unlock()
+		return g
+	}
+
+	// changes and removals are replayed in order
+	grok.Chunks = grok.Chunks[1:]
+	grok.Chunks[0].Embedding = []float64{42}
+	grok.Chunks[0].Vectors = map[string][]float64{"x": {1}}
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: 9, Length: 1, Hash: "h9"})
+	grok.Pipeline.PrefilterK = 7
+	err = grok.Save()
+	Tassert(t, err == nil, "error saving: %v", err)
+	_, err = os.Stat(grok.journalPath())
+	Tassert(t, err == nil, "expected a journal: %v", err)
+
+	g := load()
+	Tassert(t, len(g.Chunks) == 3, "expected 3 chunks, got %d", len(g.Chunks))
+	Tassert(t, g.Chunks[0].Hash == "h1" && g.Chunks[0].Vectors["x"] != nil, "unexpected chunk %v", g.Chunks[0])
+	Tassert(t, g.Chunks[2].Hash == "h9" && g.Chunks[2].Embedding == nil, "unexpected chunk %v", g.Chunks[2])
+	Tassert(t, g.Pipeline.PrefilterK == 7, "header not replayed")
+
+	// a field emptied since an earlier entry stays empty
+	grok.StopAllow = map[string]bool{"h1": true}
+	err = grok.Save()
+	Ck(err)
+	grok.StopAllow = nil
+	err = grok.Save()
+	Ck(err)
+	g = load()
+	Tassert(t, len(g.StopAllow) == 0, "expected no stop-list exceptions, got %v", g.StopAllow)
+
+	// an unchanged save journals no chunks
+	err = g.Save()
+	Tassert(t, err == nil, "error saving: %v", err)
+	g = load()
+	Tassert(t, len(g.Chunks) == 3, "expected 3 chunks, got %d", len(g.Chunks))
+
+	// values that change without changing the shape are journaled:
+	// a re-embedding, a new prefilter's vectors, and languages
+	grok.Chunks[0].Embedding = []float64{43}
+	grok.Chunks[0].Vectors = map[string][]float64{"y": {2}}
+	grok.Chunks[0].Langs = []string{"fr"}
+	err = grok.Save()
+	Ck(err)
+	g = load()
+	Tassert(t, g.Chunks[0].Embedding[0] == 43, "expected the new embedding, got %v", g.Chunks[0].Embedding)
+	Tassert(t, g.Chunks[0].Vectors["y"] != nil && g.Chunks[0].Vectors["x"] == nil, "expected the new vectors, got %v", g.Chunks[0].Vectors)
+	Tassert(t, len(g.Chunks[0].Langs) == 1 && g.Chunks[0].Langs[0] == "fr", "expected the new languages, got %v", g.Chunks[0].Langs)
+	report := g.Verify()
+	Tassert(t, len(report.Problems) == 0, "unexpected problems: %v", report.Problems)
+
+	// compaction rewrites the db file and removes the journal
+	journalCompactRatio = 0
+	err = grok.Save()
+	Tassert(t, err == nil, "error saving: %v", err)
+	_, err = os.Stat(grok.journalPath())
+	Tassert(t, os.IsNotExist(err), "expected no journal")
+	g = load()
+	Tassert(t, len(g.Chunks) == 3, "expected 3 chunks, got %d", len(g.Chunks))
+	Tassert(t, g.Chunks[0].Embedding[0] == 43, "unexpected chunk %v", g.Chunks[0])
+	journalCompactRatio = 0.25
+}
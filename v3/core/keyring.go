@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The OS keyring is reached through the tools that come with it, so
+// grokker needs no cgo: security(1) on macOS and secret-tool(1), from
+// libsecret, on Linux and the BSDs.  Windows has no tool that reads a
+// stored secret back, so there, and anywhere the tool is missing,
+// secrets must come from the environment instead.
+
+// keyringService is the service name grokker's secrets are stored
+// under.
+const keyringService = "grokker"
+
+// errNoKeyring is returned when the platform's keyring tool isn't
+// available.
+var errNoKeyring = fmt.Errorf("no OS keyring tool found; install secret-tool (libsecret) or use the environment variable")
+
+// keyringGet returns the secret stored for account, and false if
+// there is none.
+var keyringGet = func(account string) (secret string, ok bool, err error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	case "windows":
+		err = errNoKeyring
+		return
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+	if _, err = exec.LookPath(cmd.Path); err != nil {
+		err = errNoKeyring
+		return
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if exitErr, exit := err.(*exec.ExitError); exit {
+		msg := strings.TrimSpace(stderr.String())
+		if keyringNotFound(runtime.GOOS, exitErr.ExitCode(), msg) {
+			return "", false, nil
+		}
+		// e.g. a locked keyring, a dismissed unlock prompt, or
+		// no D-Bus session; the secret may well be there
+		err = fmt.Errorf("reading %s from the keyring: %v: %s", account, err, msg)
+		return
+	}
+	if err != nil {
+		return
+	}
+	secret = strings.TrimSpace(string(out))
+	return secret, secret != "", nil
+}
+
+// keyringNotFound returns true if a lookup that exited with code and
+// printed msg on stderr found no secret, rather than failing.
+// security exits 44 for a missing item; secret-tool exits 1 without a
+// word, and prints why for any other failure.
+func keyringNotFound(goos string, code int, msg string) bool {
+	if goos == "darwin" {
+		return code == 44
+	}
+	return code == 1 && msg == ""
+}
+
+// keyringSet stores a secret for account.  It never replaces a secret
+// that is already stored, which could be the only key to something.
+var keyringSet = func(account, secret string) (err error) {
+	_, ok, err := keyringGet(account)
+	if err != nil {
+		return
+	}
+	if ok {
+		return fmt.Errorf("the keyring already has a %s secret; not replacing it", account)
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		// security only takes the secret as an argument
+		cmd = exec.Command("security", "add-generic-password", "-s", keyringService, "-a", account, "-w", secret)
+	case "windows":
+		return errNoKeyring
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label", keyringService+" "+account, "service", keyringService, "account", account)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	if _, err = exec.LookPath(cmd.Path); err != nil {
+		return errNoKeyring
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("storing %s in the keyring: %v: %s", account, err, strings.TrimSpace(stderr.String()))
+	}
+	return
+}
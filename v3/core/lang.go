@@ -0,0 +1,89 @@
+package core
+
+import (
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Answers can be given in a language other than the question's, so a
+// team that shares one index can each read answers in their own
+// language.  The language is a code such as "de" or "pt-BR", or a
+// language name; the default comes from the user config file:
+//
+//	lang: de
+//
+// Only the prose is translated.  The system message tells the model
+// to leave code, commands, identifiers, and cited paths as they are,
+// so they still match the documents.
+
+// langNames are the names of the languages with common ISO 639-1
+// codes.
+var langNames = map[string]string{
+	"ar": "Arabic",
+	"cs": "Czech",
+	"da": "Danish",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fi": "Finnish",
+	"fr": "French",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"hu": "Hungarian",
+	"id": "Indonesian",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"no": "Norwegian",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ro": "Romanian",
+	"ru": "Russian",
+	"sv": "Swedish",
+	"th": "Thai",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+const langSysmsg = "Write your answer in %s.  Leave code blocks, inline code, commands, identifiers, file paths, and citations exactly as they appear in the context; don't translate them."
+
+// LangName returns the name of the language with the given code,
+// e.g. "German" for "de" and "Portuguese (pt-BR)" for "pt-BR".
+// Anything else is taken to be a language name already.
+func LangName(lang string) string {
+	lang = strings.TrimSpace(lang)
+	code, region, _ := strings.Cut(lang, "-")
+	name, ok := langNames[strings.ToLower(code)]
+	if !ok {
+		return lang
+	}
+	if region != "" {
+		name = Spf("%s (%s)", name, lang)
+	}
+	return name
+}
+
+// SetLang sets the language of subsequent answers; pass an empty
+// string to let the model choose, usually the question's language.
+// The language is not stored in the database.
+func (g *Grokker) SetLang(lang string) {
+	g.lang = strings.TrimSpace(lang)
+}
+
+// Lang returns the language set with SetLang.
+func (g *Grokker) Lang() string {
+	return g.lang
+}
+
+// withLang adds the answer language, if any, to a system message.
+func (g *Grokker) withLang(sysmsg string) string {
+	if g.lang == "" {
+		return sysmsg
+	}
+	return strings.TrimSpace(sysmsg) + "\n\n" + Spf(langSysmsg, LangName(g.lang))
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestLang(t *testing.T) {
+	Tassert(t, LangName("de") == "German", "got %q", LangName("de"))
+	Tassert(t, LangName("pt-BR") == "Portuguese (pt-BR)", "got %q", LangName("pt-BR"))
+	Tassert(t, LangName("Esperanto") == "Esperanto", "got %q", LangName("Esperanto"))
+
+	g := &Grokker{}
+	Tassert(t, g.Sysmsg("base") == "base", "sysmsg changed without a language")
+	g.SetLang(" de ")
+	Tassert(t, g.Lang() == "de", "got %q", g.Lang())
+	sysmsg := g.Sysmsg("base")
+	Tassert(t, strings.HasPrefix(sysmsg, "base\n\nWrite your answer in German.") && strings.Contains(sysmsg, "file paths"), "got %q", sysmsg)
+	// the language comes after the persona's format
+	g.persona = &Persona{Sysmsg: "Find bugs.", Format: "plain"}
+	sysmsg = g.Sysmsg("base")
+	Tassert(t, strings.HasPrefix(sysmsg, "Find bugs.\n\n"+outputFormats["plain"]+"\n\nWrite your answer in German."), "got %q", sysmsg)
+	g.SetLang("")
+	Tassert(t, !strings.Contains(g.Sysmsg("base"), "German"), "language not cleared")
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Log files are indexed one chunk per time window, so a question
+// like "what happened around 14:05?" retrieves the lines from that
+// window instead of whichever of many identical-looking chunks
+// embeds closest.  Each window is headed by its start and end time,
+// which is also the chunk's section, and cited at its first line.
+// Noisy fields that make otherwise identical lines look different
+// to the embedder, such as UUIDs and long hex IDs, are replaced with
+// placeholders, and a line repeated within a window is kept once
+// with a count.  Lines without a timestamp, such as stack traces,
+// stay with the line before them.  Like API specs, this is a
+// built-in loader; see hooks.go.
+
+// logExts are the extensions of log files.
+var logExts = []string{".log"}
+
+// logWindow is the length of the time window of a log chunk.
+const logWindow = 5 * time.Minute
+
+// logTimeFormat is a timestamp format and a pattern that finds it.
+type logTimeFormat struct {
+	re     *regexp.Regexp
+	layout string
+}
+
+// logTimeFormats are the timestamp formats we recognize: ISO 8601,
+// common log format, and syslog.
+var logTimeFormats = []logTimeFormat{
+	{regexp.MustCompile(`\d{4}-\d\d-\d\d[T ]\d\d:\d\d:\d\d`), "2006-01-02 15:04:05"},
+	{regexp.MustCompile(`\d\d/[A-Z][a-z]{2}/\d{4}:\d\d:\d\d:\d\d`), "02/Jan/2006:15:04:05"},
+	{regexp.MustCompile(`[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d`), "Jan _2 15:04:05"},
+}
+
+// logTimeSearch is how far into a line we look for a timestamp.
+const logTimeSearch = 64
+
+// uuidRe matches UUIDs.
+var uuidRe = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+
+// hexIDRe matches hex numbers and runs of hex digits long enough to
+// be IDs.
+var hexIDRe = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{12,}\b`)
+
+// logTime returns the timestamp of a log line and where it is.
+func logTime(line string) (t time.Time, loc []int, ok bool) {
+	head := line
+	if len(head) > logTimeSearch {
+		head = head[:logTimeSearch]
+	}
+	for _, f := range logTimeFormats {
+		loc = f.re.FindStringIndex(head)
+		if loc == nil {
+			continue
+		}
+		s := head[loc[0]:loc[1]]
+		if f.layout == logTimeFormats[0].layout {
+			s = s[:10] + " " + s[11:]
+		}
+		var err error
+		t, err = time.Parse(f.layout, s)
+		if err == nil {
+			return t, loc, true
+		}
+	}
+	return
+}
+
+// normalizeLogLine replaces the noisy fields in a log line.  A run of
+// hex digits is only replaced if it has both letters and digits, so
+// plain numbers and words are kept.
+func normalizeLogLine(line string) string {
+	line = uuidRe.ReplaceAllString(line, "<uuid>")
+	return hexIDRe.ReplaceAllStringFunc(line, func(s string) string {
+		if strings.HasPrefix(s, "0x") {
+			return "<hex>"
+		}
+		if strings.IndexAny(s, "0123456789") < 0 || strings.IndexAny(s, "abcdefABCDEF") < 0 {
+			return s
+		}
+		return "<hex>"
+	})
+}
+
+// logLine is a normalized log line and how many times it occurred in
+// its window.
+type logLine struct {
+	text  string
+	count int
+}
+
+// renderLog renders a log file one section per time window.  It
+// returns false if the file has no timestamps.
+func renderLog(content []byte) (sections []renderedSection, ok bool) {
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	var start time.Time
+	var cur []*logLine
+	// the line number of the window's first line
+	first := 0
+	// the timestamped lines in the window by their text without the
+	// timestamp
+	seen := make(map[string]*logLine)
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		layout := "2006-01-02 15:04"
+		if start.Year() == 0 {
+			// syslog timestamps have no year
+			layout = "Jan 2 15:04"
+		}
+		sec := renderedSection{line: first}
+		var body []string
+		if !start.IsZero() {
+			sec.name = "log " + start.Format(layout) + " to " + start.Add(logWindow).Format(layout)
+			body = append(body, sec.name)
+		}
+		for _, l := range cur {
+			if l.count > 1 {
+				body = append(body, Spf("%s (%d times)", l.text, l.count))
+			} else {
+				body = append(body, l.text)
+			}
+		}
+		sec.text = strings.Join(body, "\n")
+		sections = append(sections, sec)
+		cur = nil
+		seen = make(map[string]*logLine)
+	}
+	found := false
+	// a repeated line's continuation lines are dropped with it
+	repeat := false
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(cur) == 0 {
+			first = i + 1
+		}
+		t, loc, stamped := logTime(line)
+		if !stamped {
+			if !repeat {
+				cur = append(cur, &logLine{text: normalizeLogLine(line), count: 1})
+			}
+			continue
+		}
+		bucket := t.Truncate(logWindow)
+		if !found || !bucket.Equal(start) {
+			flush()
+			start = bucket
+			first = i + 1
+		}
+		found = true
+		key := normalizeLogLine(line[:loc[0]] + line[loc[1]:])
+		if l, ok := seen[key]; ok {
+			l.count++
+			repeat = true
+			continue
+		}
+		repeat = false
+		l := &logLine{text: normalizeLogLine(line), count: 1}
+		seen[key] = l
+		cur = append(cur, l)
+	}
+	if !found {
+		return
+	}
+	flush()
+	return sections, true
+}
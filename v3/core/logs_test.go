@@ -0,0 +1,52 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestNormalizeLogLine(t *testing.T) {
+	line := "req 3f2a9c1e-8b7d-4e6f-a1b2-c3d4e5f60718 trace 0x7ffde4 span 9f8e7d6c5b4a3f2e took 1200ms at beefcafe"
+	got := normalizeLogLine(line)
+	want := "req <uuid> trace <hex> span <hex> took 1200ms at beefcafe"
+	Tassert(t, got == want, "expected %q, got %q", want, got)
+}
+
+func TestRenderLog(t *testing.T) {
+	src := `starting up
+2024-03-01T14:01:00Z INFO listening on :8080
+2024-03-01T14:05:03Z ERROR request 3f2a9c1e-8b7d-4e6f-a1b2-c3d4e5f60718 failed
+  at handler.go:42
+2024-03-01T14:05:04Z ERROR request 0b1c2d3e-8b7d-4e6f-a1b2-c3d4e5f60718 failed
+  at handler.go:42
+2024-03-01T14:09:59Z WARN retrying
+2024-03-01T14:10:00Z INFO recovered
+`
+	secs, ok := renderLog([]byte(src))
+	out, _ := joinSections(secs)
+	Tassert(t, ok, "expected a log to be rendered")
+	sections := strings.Split(strings.TrimSpace(string(out)), "\n\n")
+	Tassert(t, len(sections) == 4, "expected 4 sections, got %d: %q", len(sections), out)
+	Tassert(t, sections[0] == "starting up", "unexpected section %q", sections[0])
+	Tassert(t, strings.HasPrefix(sections[1], "log 2024-03-01 14:00 to 2024-03-01 14:05\n"), "unexpected section %q", sections[1])
+	want := "log 2024-03-01 14:05 to 2024-03-01 14:10\n" +
+		"2024-03-01T14:05:03Z ERROR request <uuid> failed (2 times)\n" +
+		"  at handler.go:42\n" +
+		"2024-03-01T14:09:59Z WARN retrying"
+	Tassert(t, sections[2] == want, "expected %q, got %q", want, sections[2])
+	Tassert(t, strings.HasSuffix(sections[3], "INFO recovered"), "unexpected section %q", sections[3])
+	Tassert(t, secs[0].name == "" && secs[0].line == 1, "got %+v", secs[0])
+	Tassert(t, secs[2].name == "log 2024-03-01 14:05 to 2024-03-01 14:10" && secs[2].line == 3, "got %+v", secs[2])
+	Tassert(t, secs[3].line == 8, "got %+v", secs[3])
+
+	src = "Mar  1 14:05:03 host sshd[42]: accepted\n"
+	secs, ok = renderLog([]byte(src))
+	out, _ = joinSections(secs)
+	Tassert(t, ok, "expected a syslog to be rendered")
+	Tassert(t, strings.HasPrefix(string(out), "log Mar 1 14:05 to Mar 1 14:10\n"), "unexpected output %q", out)
+
+	_, ok = renderLog([]byte("no timestamps\nhere\n"))
+	Tassert(t, !ok, "expected a file without timestamps to be left alone")
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Markdown documents are chunked by their structure rather than by
+// blank lines alone:
+//
+//   - each heading starts a new chunk, which runs at least to the end
+//     of the paragraph under it, so a heading is never a chunk of its
+//     own;
+//   - a blank line inside a fenced code block, or inside the
+//     frontmatter, doesn't end a chunk, so code blocks are never split
+//     mid-block unless they are longer than the embedding model's
+//     token limit;
+//   - each chunk's Section is the breadcrumb of the headings it is
+//     under, e.g. "Installation > Linux", which its header carries, so
+//     both the embedding and the context say what part of the
+//     document the chunk is from.
+
+// headingRe matches an ATX heading, e.g. "## Install ##".
+var headingRe = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t]*#*[ \t]*$`)
+
+// fenceRe matches the line that opens or closes a fenced code block.
+var fenceRe = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+
+// sectionSep separates the headings of a breadcrumb.
+const sectionSep = " > "
+
+// splitMarkdown splits a markdown document into chunks at headings
+// and at blank lines outside code blocks.  The chunks cover all of
+// the text.
+func splitMarkdown(doc *Document, txt string) (chunks []*Chunk) {
+	var crumbs []string
+	// the start and section of the current chunk, and whether it
+	// has any text but headings
+	start := 0
+	section := ""
+	body := false
+	// the fence that closes the code block we're in, if any
+	fence := ""
+	add := func(end int) {
+		if end > start {
+			chunks = append(chunks, newChunk(doc, section, start, end-start, txt[start:end]))
+		}
+		start = end
+		body = false
+	}
+	offset := 0
+	for i, line := range strings.SplitAfter(txt, "\n") {
+		lineStart := offset
+		offset += len(line)
+		trimmed := strings.TrimSpace(line)
+		heading := false
+		switch {
+		case fence != "":
+			if len(trimmed) >= len(fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+			}
+		case i == 0 && trimmed == "---":
+			// frontmatter ends at the next "---"
+			fence = "---"
+		case fenceRe.MatchString(line):
+			fence = fenceRe.FindStringSubmatch(line)[1]
+		case headingRe.MatchString(strings.TrimRight(line, "\r\n")):
+			heading = true
+			if body {
+				add(lineStart)
+			}
+			m := headingRe.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+			level := len(m[1])
+			if len(crumbs) >= level {
+				crumbs = crumbs[:level-1]
+			}
+			for len(crumbs) < level-1 {
+				// a skipped level, e.g. "###" right under "#"
+				crumbs = append(crumbs, "")
+			}
+			crumbs = append(crumbs, m[2])
+		case trimmed == "":
+			if body {
+				add(offset)
+			}
+			continue
+		}
+		if !body {
+			// a chunk that starts with headings is in the
+			// section of the last of them
+			section = breadcrumb(crumbs)
+		}
+		if !heading {
+			body = true
+		}
+	}
+	add(len(txt))
+	return
+}
+
+// breadcrumb joins the non-empty headings of a breadcrumb.
+func breadcrumb(crumbs []string) string {
+	var parts []string
+	for _, c := range crumbs {
+		if c != "" {
+			parts = append(parts, c)
+		}
+	}
+	return strings.Join(parts, sectionSep)
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	txt := "---\ntitle: Guide\n\n# not a heading\n---\nIntro.\n\n" +
+		"# Install\n\n## Linux ##\nRun the script:\n\n```sh\n# a comment, not a heading\n\nmake install\n```\nThen log in.\n\n" +
+		"#### Deep\nSkipped levels.\n" +
+		"## Windows\n\nUse the installer.\n"
+	doc := &Document{RelPath: "guide.md"}
+	chunks := splitMarkdown(doc, txt)
+	var got []string
+	var all string
+	for _, c := range chunks {
+		got = append(got, Spf("%s|%q", c.Section, c.text))
+		all += c.text
+	}
+	Tassert(t, all == txt, "the chunks don't cover the text: %q", all)
+	want := []string{
+		`|"---\ntitle: Guide\n\n# not a heading\n---\nIntro.\n\n"`,
+		`Install > Linux|"# Install\n\n## Linux ##\nRun the script:\n\n"`,
+		"Install > Linux|\"```sh\\n# a comment, not a heading\\n\\nmake install\\n```\\nThen log in.\\n\\n\"",
+		`Install > Linux > Deep|"#### Deep\nSkipped levels.\n"`,
+		`Install > Windows|"## Windows\n\nUse the installer.\n"`,
+	}
+	Tassert(t, strings.Join(got, "\n") == strings.Join(want, "\n"), "got:\n%s", strings.Join(got, "\n"))
+
+	// the section is part of the header, and so of the hash
+	Tassert(t, chunkHeader("guide.md", chunks[1].Section) == "from guide.md (Install > Linux):\n", "got %q", chunkHeader("guide.md", chunks[1].Section))
+	plain := newChunk(doc, "", chunks[1].Offset, chunks[1].Length, chunks[1].text)
+	Tassert(t, plain.Hash != chunks[1].Hash, "the section doesn't change the hash")
+}
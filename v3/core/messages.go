@@ -0,0 +1,137 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gptLib "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Models differ in how well they use context depending on where it
+// is in the conversation, so the pipeline settles how generate lays
+// it out:
+//
+//   - ContextPlacement: "user" (the default) sends the context as a
+//     user message that the assistant acknowledges before the
+//     question; "system" sends it as a system message; "question"
+//     puts it in the same message as the question.
+//   - ContextMessages: "single" (the default) sends all the chunks
+//     in one message; "chunk" sends one message per chunk.
+//   - CitationMarkers numbers the chunks and asks the model to cite
+//     them as [n]; the markers in the answer are then replaced with
+//     the chunks' citations, e.g. [docs/deploy.md:12].
+//
+// 'grok compare' shows which layout works best for a model.
+
+// The values of Pipeline.ContextPlacement.
+var contextPlacements = []string{"user", "system", "question"}
+
+// The values of Pipeline.ContextMessages.
+var contextMessages = []string{"single", "chunk"}
+
+const markerSysmsg = "Each part of the context starts with a number in brackets.  When you use a part, cite it by that number, e.g. [2]."
+
+// markerRe matches citation markers in an answer, e.g. [2] or
+// [1, 3].
+var markerRe = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// contextPart is a chunk of the context, with its citation.
+type contextPart struct {
+	text string
+	cite string
+}
+
+// checkMessageShape returns an error if the pipeline's message
+// layout settings are unknown.
+func (g *Grokker) checkMessageShape() error {
+	p := g.Pipeline
+	if p.ContextPlacement != "" && !util.StringInSlice(p.ContextPlacement, contextPlacements) {
+		return fmt.Errorf("unknown context placement %q; expected one of %v", p.ContextPlacement, contextPlacements)
+	}
+	if p.ContextMessages != "" && !util.StringInSlice(p.ContextMessages, contextMessages) {
+		return fmt.Errorf("unknown context messages %q; expected one of %v", p.ContextMessages, contextMessages)
+	}
+	return nil
+}
+
+// contextParts splits ctxt into the chunks it was built from, if it
+// is the context most recently built by getContext.  Any other
+// context, e.g. command output, is a single part.
+func (g *Grokker) contextParts(ctxt string) (parts []contextPart) {
+	if ctxt == "" {
+		return
+	}
+	var texts []string
+	for _, p := range g.contextChunks {
+		texts = append(texts, p.text)
+	}
+	if len(g.contextChunks) > 0 && strings.Join(texts, "") == ctxt {
+		return g.contextChunks
+	}
+	return []contextPart{{text: ctxt}}
+}
+
+// placeContext adds the context parts to messages, or to the
+// question, as the pipeline says.
+func (g *Grokker) placeContext(messages []gptLib.ChatCompletionMessage, question string, parts []contextPart) ([]gptLib.ChatCompletionMessage, string) {
+	if len(parts) == 0 {
+		return messages, question
+	}
+	var texts []string
+	for i, p := range parts {
+		text := p.text
+		if g.Pipeline.CitationMarkers {
+			text = Spf("[%d] %s", i+1, text)
+		}
+		texts = append(texts, text)
+	}
+	if g.Pipeline.ContextMessages != "chunk" {
+		texts = []string{strings.Join(texts, "")}
+	}
+	switch g.Pipeline.ContextPlacement {
+	case "question":
+		question = Spf("Context:\n\n%s\n\nQuestion: %s", strings.Join(texts, "\n"), question)
+	case "system":
+		for _, text := range texts {
+			messages = append(messages, gptLib.ChatCompletionMessage{
+				Role:    g.systemRole(),
+				Content: Spf("Context:\n\n%s", text),
+			})
+		}
+	default:
+		for _, text := range texts {
+			messages = append(messages, gptLib.ChatCompletionMessage{
+				Role:    gptLib.ChatMessageRoleUser,
+				Content: Spf("Context:\n\n%s", text),
+			})
+		}
+		messages = append(messages, gptLib.ChatCompletionMessage{
+			Role:    gptLib.ChatMessageRoleAssistant,
+			Content: "Great! I've read the context.",
+		})
+	}
+	return messages, question
+}
+
+// expandMarkers replaces the citation markers in an answer with the
+// citations of the parts they number.  Markers that don't number a
+// part with a citation are left alone.
+func expandMarkers(answer string, parts []contextPart) string {
+	return markerRe.ReplaceAllStringFunc(answer, func(m string) string {
+		var cites []string
+		for _, num := range strings.Split(m[1:len(m)-1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(num))
+			if err != nil || n < 1 || n > len(parts) || parts[n-1].cite == "" {
+				return m
+			}
+			if !util.StringInSlice(parts[n-1].cite, cites) {
+				cites = append(cites, parts[n-1].cite)
+			}
+		}
+		return "[" + strings.Join(cites, ", ") + "]"
+	})
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	gptLib "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPlaceContext(t *testing.T) {
+	g := &Grokker{Model: "gpt-4o"}
+	g.contextChunks = []contextPart{{"from a.md:\nalpha\n", "a.md:1"}, {"from b.go:\nbeta\n", "b.go:7"}}
+	ctxt := "from a.md:\nalpha\nfrom b.go:\nbeta\n"
+	parts := g.contextParts(ctxt)
+	Tassert(t, len(parts) == 2, "expected the chunks, got %v", parts)
+	Tassert(t, len(g.contextParts("command output")) == 1, "expected other context to be one part")
+	Tassert(t, len(g.contextParts("")) == 0, "expected no parts for no context")
+
+	roles := func(msgs []gptLib.ChatCompletionMessage) string {
+		var rs []string
+		for _, m := range msgs {
+			rs = append(rs, m.Role)
+		}
+		return strings.Join(rs, ",")
+	}
+	// the default is one user message, acknowledged
+	msgs, q := g.placeContext(nil, "why?", parts)
+	Tassert(t, roles(msgs) == "user,assistant" && q == "why?", "unexpected messages %v %q", roles(msgs), q)
+	Tassert(t, msgs[0].Content == "Context:\n\n"+ctxt, "unexpected context %q", msgs[0].Content)
+
+	g.Pipeline.ContextPlacement = "system"
+	g.Pipeline.ContextMessages = "chunk"
+	msgs, _ = g.placeContext(nil, "why?", parts)
+	Tassert(t, roles(msgs) == "system,system" && strings.Contains(msgs[1].Content, "beta"), "unexpected messages %+v", msgs)
+	// models without system messages get user messages
+	g.Model = "o3-mini"
+	msgs, _ = g.placeContext(nil, "why?", parts)
+	Tassert(t, roles(msgs) == "user,user", "unexpected roles %v", roles(msgs))
+
+	g.Pipeline.ContextPlacement = "question"
+	g.Pipeline.CitationMarkers = true
+	msgs, q = g.placeContext(nil, "why?", parts)
+	Tassert(t, len(msgs) == 0 && strings.HasPrefix(q, "Context:\n\n[1] from a.md") && strings.Contains(q, "[2] from b.go") && strings.HasSuffix(q, "Question: why?"), "unexpected question %q", q)
+
+	answer := expandMarkers("Alpha [1] and beta [2], both [1, 2]; not [3] or [x].", parts)
+	Tassert(t, answer == "Alpha [a.md:1] and beta [b.go:7], both [a.md:1, b.go:7]; not [3] or [x].", "got %q", answer)
+}
+
+func TestMessageShapeSettings(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	err = grok.SetPipeline("contextplacement", "system")
+	Tassert(t, err == nil && grok.Pipeline.ContextPlacement == "system", "error setting placement: %v", err)
+	err = grok.SetPipeline("contextmessages", "every")
+	Tassert(t, err != nil, "expected an error for an unknown setting value")
+}
@@ -1,9 +1,12 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	. "github.com/stevegt/goadapt"
 	"github.com/stevegt/semver"
@@ -11,12 +14,24 @@ import (
 
 // canon returns our best guess at the canonical path of a
 // document, and warns if the document is not found at the
-// canonical path.
+// canonical path.  Versions before 1.0.0 stored absolute paths, so
+// if the database has moved since, the path is taken to be the
+// longest trailing part of the old one that exists under g.Root.
 func (g *Grokker) canon(path string) (rel string) {
 	rel, err := filepath.Rel(g.Root, path)
 	if err != nil {
 		Fpf(os.Stderr, "error: %s\n", err)
 	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		parts := strings.Split(filepath.Clean(path), string(filepath.Separator))
+		for i := 1; i < len(parts); i++ {
+			tail := filepath.Join(parts[i:]...)
+			if _, err := os.Stat(filepath.Join(g.Root, tail)); err == nil {
+				rel = tail
+				break
+			}
+		}
+	}
 	// check if the document exists at the canonical path
 	_, err = os.Stat(g.absPath(&Document{RelPath: rel}))
 	if err != nil {
@@ -101,47 +116,13 @@ func (g *Grokker) migrateOneVersion() (err error) {
 
 	switch vstr {
 
-	case "0.1.X":
-		// copy Document.Path to Document.RelPath, leave old content
-		// for now
-		for _, doc := range g.Documents {
-			doc.RelPath = g.canon(doc.Path)
-		}
-		// copy Chunk.Document.Path to Chunk.Document.RelPath, leave
-		// old content for now
-		for _, chunk := range g.Chunks {
-			// copy and canonicalize the path
-			chunk.Document.RelPath = g.canon(chunk.Document.Path)
-		}
-		// refresh embeddings now because we are about to save the grok file
-		// and that will make its timestamp newer than any possibly-modified
-		// documents
-		err = g.Setup(g.Model)
-		Ck(err)
-		err = g.RefreshEmbeddings()
-		Ck(err)
-		g.Version = "1.0.0"
-
-	case "1.0.X":
-		// add file paths to chunks -- all we need to do here is refresh
-		// all of the doc chunks to get the file paths added
-		err = g.Setup(g.Model)
-		Ck(err)
-		err = g.RefreshEmbeddings()
-		Ck(err)
-		g.Version = "1.1.0"
-
-	case "1.1.X":
-		// API change, so this is a no-op as far as the db is concerned
-		g.Version = "2.0.0"
-
-	case "2.0.X":
-		// replace Text field with Hash, Offset, and Length fields in
-		// Chunk struct -- all we need to do here is refresh all of the
-		// doc chunks to get the new fields added
-		err = g.Setup(g.Model)
-		Ck(err)
-		err = g.RefreshEmbeddings()
+	case "0.1.X", "1.0.X", "1.1.X", "2.0.X":
+		// these stored absolute document paths (0.1.X) and the text
+		// of each chunk, without its file path (1.0.X) or its hash,
+		// offset, and length (before 2.1.0) -- convert the chunks in
+		// place, keeping their embeddings, rather than re-embedding
+		// every document
+		err = g.convertFlatChunks()
 		Ck(err)
 		g.Version = "2.1.0"
 
@@ -149,6 +130,12 @@ func (g *Grokker) migrateOneVersion() (err error) {
 		// API change, so this is a no-op as far as the db is concerned
 		g.Version = "3.0.0"
 
+	case "3.0.X":
+		// Windows stored relative paths with backslashes
+		err = g.slashRelPaths()
+		Ck(err)
+		g.Version = "3.1.0"
+
 	// XXX remove doc.Path in a future version
 
 	default:
@@ -156,3 +143,248 @@ func (g *Grokker) migrateOneVersion() (err error) {
 	}
 	return
 }
+
+// MigrateReport describes what Migrate did.
+type MigrateReport struct {
+	// From and To are the database versions before and after.
+	From string
+	To   string
+	// Backup is where the database was backed up to, if it needed
+	// migrating.
+	Backup string
+	// Converted is the number of chunks of a database from before
+	// 2.1.0 that were found in their documents and kept with their
+	// embeddings, and Dropped the number that weren't.  Stale lists
+	// the documents that lost chunks and need re-embedding, and
+	// Missing the documents, by their old paths, that no longer
+	// exist and were forgotten.
+	Converted int
+	Dropped   int
+	Stale     []string `json:",omitempty"`
+	Missing   []string `json:",omitempty"`
+	// Verify is the result of verifying the migrated database.
+	Verify VerifyReport
+}
+
+// Migrate upgrades the database at grokpath to the current version,
+// backing it up first, saves it, and verifies the result.  Loading a
+// database migrates it too, but only in memory until something
+// saves it, and without a backup or a report.
+func Migrate(grokpath string) (report *MigrateReport, err error) {
+	defer Return(&err)
+	buf, err := os.ReadFile(grokpath)
+	Ck(err)
+	var head struct{ Version string }
+	err = json.Unmarshal(buf, &head)
+	Ck(err, "%s is not a grokker database", grokpath)
+	report = &MigrateReport{From: head.Version, To: head.Version}
+	if report.From == "" {
+		report.From = "0.1.0"
+	}
+	if head.Version != Version {
+		report.Backup, err = (&Grokker{grokpath: grokpath}).Backup()
+		Ck(err)
+	}
+	g, migrated, _, now, lock, err := LoadFrom(grokpath, "", false)
+	Ck(err)
+	defer lock.Unlock()
+	if g.migration != nil {
+		report.Converted = g.migration.Converted
+		report.Dropped = g.migration.Dropped
+		report.Stale = g.migration.Stale
+		report.Missing = g.migration.Missing
+	}
+	if migrated {
+		err = g.Save()
+		Ck(err)
+		report.To = now
+	}
+	report.Verify = g.Verify()
+	report.Verify.Problems = append(report.Verify.Problems, g.checkMigrated()...)
+	return
+}
+
+// checkMigrated returns what's left of an older schema in the
+// database: chunks without a hash, with stored text, or whose
+// document isn't in the database.
+func (g *Grokker) checkMigrated() (problems []string) {
+	docs := make(map[string]bool, len(g.Documents))
+	for _, doc := range g.Documents {
+		if doc.RelPath == "" {
+			problems = append(problems, Spf("document %q has no relative path", doc.Path))
+		}
+		docs[doc.RelPath] = true
+	}
+	for i, c := range g.Chunks {
+		switch {
+		case c.Hash == "":
+			problems = append(problems, Spf("chunk %d has no hash", i))
+		case c.Text != "":
+			problems = append(problems, Spf("chunk %.12s still stores its text", c.Hash))
+		case c.Document == nil || !docs[c.Document.RelPath]:
+			problems = append(problems, Spf("chunk %.12s belongs to no document", c.Hash))
+		}
+	}
+	return
+}
+
+// legacyDocKey returns the key that matches a document of a database
+// from before 2.1.0 to the copies of it in its chunks.
+func legacyDocKey(doc *Document) string {
+	if doc.RelPath != "" {
+		return doc.RelPath
+	}
+	return doc.Path
+}
+
+// convertFlatChunks converts the chunks of a database from before
+// 2.1.0, which stored each chunk's text, to chunks that refer to
+// their text by offset and length, keeping their embeddings.  A
+// chunk whose text is no longer in its document is dropped and the
+// document listed as stale; a document that no longer exists is
+// forgotten.
+func (g *Grokker) convertFlatChunks() (err error) {
+	defer Return(&err)
+	report := &MigrateReport{}
+	docs := make(map[string]*Document)
+	contents := make(map[*Document]string)
+	var kept []*Document
+	for _, doc := range g.Documents {
+		key := legacyDocKey(doc)
+		if doc.RelPath == "" {
+			doc.RelPath = g.canon(doc.Path)
+		}
+		buf, err := os.ReadFile(g.absPath(doc))
+		if err != nil {
+			report.Missing = append(report.Missing, key)
+			continue
+		}
+		docs[key] = doc
+		contents[doc] = string(buf)
+		kept = append(kept, doc)
+	}
+	stale := make(map[*Document]bool)
+	next := make(map[*Document]int)
+	seen := make(map[string]bool)
+	var chunks []*Chunk
+	for _, c := range g.Chunks {
+		if c.Document == nil {
+			report.Dropped++
+			continue
+		}
+		doc, ok := docs[legacyDocKey(c.Document)]
+		if !ok {
+			// the document is gone
+			continue
+		}
+		// chunks are in document order, so look after the last one
+		// first
+		content := contents[doc]
+		offset := strings.Index(content[next[doc]:], c.Text)
+		if offset >= 0 {
+			offset += next[doc]
+		} else {
+			offset = strings.Index(content, c.Text)
+		}
+		if c.Text == "" || offset < 0 {
+			report.Dropped++
+			stale[doc] = true
+			continue
+		}
+		chunk := newChunk(doc, "", offset, len(c.Text), c.Text)
+		next[doc] = offset + len(c.Text)
+		if seen[chunk.Hash] {
+			continue
+		}
+		seen[chunk.Hash] = true
+		chunk.Embedding = c.Embedding
+		chunks = append(chunks, chunk)
+		report.Converted++
+	}
+	for _, doc := range kept {
+		if stale[doc] {
+			report.Stale = append(report.Stale, doc.RelPath)
+		}
+	}
+	g.Documents = kept
+	g.Chunks = chunks
+	// the db file holds the old chunks, so the next save rewrites it
+	g.savedSigs = nil
+	g.migration = report
+	Debug("converted %d chunks, dropped %d", report.Converted, report.Dropped)
+	return
+}
+
+// slashRelPaths rewrites the relative paths that versions before
+// 3.1.0 stored with backslashes on Windows -- of documents, their
+// chunks, tombstones, and shards -- to the forward slashes they're
+// stored with now, and rehashes the chunks, whose hashes include
+// their paths.  Elsewhere a backslash can be part of a file name, so
+// there a path is only rewritten if no file has it.  Virtual
+// document names aren't paths, so they're kept.
+func (g *Grokker) slashRelPaths() (err error) {
+	defer Return(&err)
+	// every chunk has its own copy of its document
+	err = g.LoadShards()
+	Ck(err)
+	slash := func(relpath string) string {
+		if !strings.Contains(relpath, `\`) {
+			return relpath
+		}
+		if filepath.Separator != '\\' {
+			if _, err := os.Stat(filepath.Join(g.Root, relpath)); err == nil {
+				return relpath
+			}
+		}
+		return strings.ReplaceAll(relpath, `\`, "/")
+	}
+	var n int
+	for _, doc := range g.Documents {
+		rel := slash(doc.RelPath)
+		if doc.Virtual || rel == doc.RelPath {
+			continue
+		}
+		if doc.Transformed {
+			err = os.Rename(g.transformedPath(doc.RelPath), g.transformedPath(rel))
+			if os.IsNotExist(err) {
+				// the next refresh transforms it again
+				err = nil
+			}
+			Ck(err)
+		}
+		doc.RelPath = rel
+		n++
+	}
+	for _, c := range g.Chunks {
+		if c.Document == nil || c.Document.Virtual {
+			continue
+		}
+		rel := slash(c.Document.RelPath)
+		if rel == c.Document.RelPath {
+			continue
+		}
+		c.Document.RelPath = rel
+		text, err := g.chunkText(c, false, false)
+		if err != nil {
+			// keep the old hash; the next refresh replaces the chunk
+			Debug("rehashing chunk %.12s: %v", c.Hash, err)
+			continue
+		}
+		c.Hash = chunkHash(rel, c.Section, text)
+	}
+	for _, ts := range g.Tombstones {
+		if !ts.Virtual {
+			ts.RelPath = slash(ts.RelPath)
+		}
+	}
+	for i, shard := range g.Shards {
+		if rel := slash(shard); rel != shard {
+			// the old shard's file is removed on save
+			g.Shards[i] = rel
+			g.markShard(rel, nil, "")
+		}
+	}
+	sort.Strings(g.Shards)
+	Debug("rewrote %d relative paths with backslashes", n)
+	return
+}
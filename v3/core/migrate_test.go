@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := TmpTestDir()
+	files := map[string]string{
+		"a.md": "Alpha one.\nAlpha two.\n",
+		"b.md": "Beta, rewritten since.\n",
+	}
+	for fn, content := range files {
+		err := os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Ck(err)
+	}
+	// a v1 database, saved somewhere else, with absolute paths and
+	// the text of each chunk
+	v1 := `{"Root": "/old/home/proj", "Model": "gpt-3.5-turbo",
+	"Documents": [{"Path": "/old/home/proj/a.md"}, {"Path": "/old/home/proj/b.md"}, {"Path": "/old/home/proj/gone.md"}],
+	"Chunks": [
+		{"Document": {"Path": "/old/home/proj/a.md"}, "Text": "Alpha one.\n", "Embedding": [1, 0]},
+		{"Document": {"Path": "/old/home/proj/a.md"}, "Text": "Alpha two.\n", "Embedding": [0, 1]},
+		{"Document": {"Path": "/old/home/proj/b.md"}, "Text": "Beta.\n", "Embedding": [1, 1]},
+		{"Document": {"Path": "/old/home/proj/gone.md"}, "Text": "Gone.\n", "Embedding": [1, 1]}
+	]}`
+	grokpath := filepath.Join(dir, ".grok")
+	err := os.WriteFile(grokpath, []byte(v1), 0644)
+	Ck(err)
+
+	report, err := Migrate(grokpath)
+	Tassert(t, err == nil, "error migrating: %v", err)
+	Tassert(t, report.From == "0.1.0" && report.To == Version, "got versions %s to %s", report.From, report.To)
+	Tassert(t, report.Converted == 2 && report.Dropped == 1, "got %d converted, %d dropped", report.Converted, report.Dropped)
+	Tassert(t, strings.Join(report.Stale, ",") == "b.md" && strings.Join(report.Missing, ",") == "/old/home/proj/gone.md", "got stale %v, missing %v", report.Stale, report.Missing)
+	Tassert(t, len(report.Verify.Problems) == 0 && report.Verify.Chunks == 2, "got %+v", report.Verify)
+	_, err = os.Stat(report.Backup)
+	Tassert(t, err == nil, "no backup at %q: %v", report.Backup, err)
+	os.Remove(report.Backup)
+
+	grok, migrated, _, _, lock, err := LoadFrom(grokpath, "", true)
+	Ck(err)
+	lock.Unlock()
+	Tassert(t, !migrated && grok.Version == Version, "expected a current db, got %s", grok.Version)
+	Tassert(t, len(grok.Documents) == 2 && len(grok.Chunks) == 2, "got %d docs, %d chunks", len(grok.Documents), len(grok.Chunks))
+	c := grok.Chunks[1]
+	text, err := grok.chunkText(c, false, false)
+	Ck(err)
+	Tassert(t, c.Document.RelPath == "a.md" && c.Offset == 11 && c.Text == "" && text == "Alpha two.\n", "got %+v", c)
+	Tassert(t, len(c.Embedding) == 2 && c.Embedding[1] == 1, "lost the embedding: %v", c.Embedding)
+
+	report, err = Migrate(grokpath)
+	Tassert(t, err == nil && report.From == Version && report.To == Version && report.Backup == "", "got %+v, %v", report, err)
+}
+
+func TestMigrateSlashes(t *testing.T) {
+	dir := TmpTestDir()
+	files := map[string]string{
+		"sub/a.md":      "Alpha.\n",
+		"sub/deep/b.md": "Beta.\n",
+		`back\slash.md`: "Kept.\n",
+	}
+	for fn, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(fn))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		Ck(err)
+		err = os.WriteFile(path, []byte(content), 0644)
+		Ck(err)
+	}
+	// a 3.0 database written on Windows, with backslashes in its
+	// relative paths, a shard, and a file whose name really has one
+	doc := func(rel string) *Document { return &Document{RelPath: rel} }
+	chunk := func(rel, text string) *Chunk {
+		c := newChunk(doc(rel), "", 0, len(text), text)
+		c.Embedding = []float64{1, 0}
+		return c
+	}
+	old := &Grokker{
+		Version:    "3.0.25",
+		Root:       dir,
+		Model:      "gpt-3.5-turbo",
+		Documents:  []*Document{doc(`sub\a.md`), doc(`sub\deep\b.md`), doc(`back\slash.md`)},
+		Chunks:     []*Chunk{chunk(`sub\a.md`, "Alpha.\n"), chunk(`back\slash.md`, "Kept.\n")},
+		Tombstones: []*Tombstone{{RelPath: `sub\gone.md`}},
+		Shards:     []string{`sub\deep`},
+	}
+	grokpath := filepath.Join(dir, ".grok")
+	old.grokpath = grokpath
+	buf, err := json.Marshal(old)
+	Ck(err)
+	err = os.WriteFile(grokpath, buf, 0644)
+	Ck(err)
+	oldShard := old.shardPath(`sub\deep`)
+	err = os.MkdirAll(filepath.Dir(oldShard), 0755)
+	Ck(err)
+	buf, err = json.Marshal(shardFile{Shard: `sub\deep`, Chunks: []*Chunk{chunk(`sub\deep\b.md`, "Beta.\n")}})
+	Ck(err)
+	err = os.WriteFile(oldShard, buf, 0644)
+	Ck(err)
+
+	report, err := Migrate(grokpath)
+	Tassert(t, err == nil, "error migrating: %v", err)
+	Tassert(t, report.From == "3.0.25" && report.To == Version, "got versions %s to %s", report.From, report.To)
+	Tassert(t, len(report.Verify.Problems) == 0, "got %+v", report.Verify)
+	os.Remove(report.Backup)
+	_, err = os.Stat(oldShard)
+	Tassert(t, os.IsNotExist(err), "old shard file still there: %v", err)
+
+	grok, _, _, _, lock, err := LoadFrom(grokpath, "", false)
+	Ck(err)
+	lock.Unlock()
+	var rels []string
+	for _, doc := range grok.Documents {
+		rels = append(rels, doc.RelPath)
+	}
+	Tassert(t, strings.Join(rels, ",") == `sub/a.md,sub/deep/b.md,back\slash.md`, "got documents %v", rels)
+	Tassert(t, len(grok.Chunks) == 3, "got %d chunks", len(grok.Chunks))
+	for _, c := range grok.Chunks {
+		text, err := grok.chunkText(c, false, false)
+		Ck(err)
+		Tassert(t, c.Hash == chunkHash(c.Document.RelPath, "", text), "chunk of %s not rehashed", c.Document.RelPath)
+		Tassert(t, len(c.Embedding) == 2, "chunk of %s lost its embedding", c.Document.RelPath)
+	}
+	Tassert(t, grok.Tombstones[0].RelPath == "sub/gone.md", "got tombstone %q", grok.Tombstones[0].RelPath)
+	Tassert(t, strings.Join(grok.Shards, ",") == "sub/deep", "got shards %v", grok.Shards)
+	Tassert(t, grok.shardOf("sub/deep/b.md") == "sub/deep", "chunks not in the renamed shard")
+}
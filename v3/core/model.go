@@ -2,9 +2,11 @@ package core
 
 import (
 	"fmt"
+	"strings"
 
 	oai "github.com/sashabaranov/go-openai"
 	. "github.com/stevegt/goadapt"
+	"github.com/tiktoken-go/tokenizer"
 )
 
 var DefaultModel = "o3-mini"
@@ -15,6 +17,11 @@ type Model struct {
 	TokenLimit   int
 	upstreamName string
 	active       bool
+	// The provider serving the model; empty for OpenAI.
+	provider string
+	// Prices in USD per million tokens, if known.
+	PromptPrice     float64
+	CompletionPrice float64
 }
 
 func (m *Model) String() string {
@@ -22,6 +29,9 @@ func (m *Model) String() string {
 	if m.active {
 		status = "*"
 	}
+	if m.PromptPrice > 0 || m.CompletionPrice > 0 {
+		return fmt.Sprintf("%1s %-20s tokens: %d)  $/Mtok: %.2f in, %.2f out", status, m.Name, m.TokenLimit, m.PromptPrice, m.CompletionPrice)
+	}
 	return fmt.Sprintf("%1s %-20s tokens: %d)", status, m.Name, m.TokenLimit)
 }
 
@@ -37,21 +47,25 @@ func (g *Grokker) GetModel() (model string, m *Model, err error) {
 type Models struct {
 	// The list of available models.
 	Available map[string]*Model
+	// The providers whose model lists have been loaded.
+	loaded map[string]bool
 }
 
 // NewModels creates a new Models object.
 func NewModels() (m *Models) {
-	m = &Models{}
+	m = &Models{loaded: make(map[string]bool)}
+	// prices are OpenAI's list prices in early 2025
 	m.Available = map[string]*Model{
-		"gpt-3.5-turbo":       {"", 4096, oai.GPT3Dot5Turbo, false},
-		"gpt-4":               {"", 8192, oai.GPT4, false},
-		"gpt-4-32k":           {"", 32768, oai.GPT432K, false},
-		"gpt-4-turbo-preview": {"", 128000, oai.GPT4TurboPreview, false},
-		"gpt-4o":              {"", 128000, oai.GPT4o, false},
-		"o1-preview":          {"", 128000, oai.O1Preview, false},
-		"o1-mini":             {"", 128000, oai.O1Mini, false},
-		"o1":                  {"", 128000, oai.O1Preview, false},
-		"o3-mini":             {"", 200000, oai.O3Mini, false},
+		"gpt-3.5-turbo":       {TokenLimit: 4096, upstreamName: oai.GPT3Dot5Turbo, PromptPrice: 0.50, CompletionPrice: 1.50},
+		"gpt-4":               {TokenLimit: 8192, upstreamName: oai.GPT4, PromptPrice: 30, CompletionPrice: 60},
+		"gpt-4-32k":           {TokenLimit: 32768, upstreamName: oai.GPT432K, PromptPrice: 60, CompletionPrice: 120},
+		"gpt-4-turbo-preview": {TokenLimit: 128000, upstreamName: oai.GPT4TurboPreview, PromptPrice: 10, CompletionPrice: 30},
+		"gpt-4o":              {TokenLimit: 128000, upstreamName: oai.GPT4o, PromptPrice: 2.50, CompletionPrice: 10},
+		"o1-preview":          {TokenLimit: 128000, upstreamName: oai.O1Preview, PromptPrice: 15, CompletionPrice: 60},
+		"o1-mini":             {TokenLimit: 128000, upstreamName: oai.O1Mini, PromptPrice: 1.10, CompletionPrice: 4.40},
+		"o1":                  {TokenLimit: 128000, upstreamName: oai.O1Preview, PromptPrice: 15, CompletionPrice: 60},
+		// the go-openai fork in go.mod predates oai.O3Mini
+		"o3-mini": {TokenLimit: 200000, upstreamName: "o3-mini", PromptPrice: 1.10, CompletionPrice: 4.40},
 	}
 	// fill in the model names
 	for k, v := range m.Available {
@@ -62,11 +76,20 @@ func NewModels() (m *Models) {
 }
 
 // FindModel returns the model name and object given a model name.
-// if the given model name is empty, then use DefaultModel.
+// if the given model name is empty, then use DefaultModel.  Model
+// names with a provider prefix, e.g. "openrouter:...", cause that
+// provider's model list to be loaded.
 func (models *Models) FindModel(model string) (name string, m *Model, err error) {
 	if model == "" {
 		model = DefaultModel
 	}
+	provider, _ := splitModelName(model)
+	if _, ok := Providers()[provider]; ok {
+		err = models.loadProvider(provider)
+		if err != nil {
+			return
+		}
+	}
 	m, ok := models.Available[model]
 	if !ok {
 		err = fmt.Errorf("model %q not found", model)
@@ -84,6 +107,10 @@ func (g *Grokker) Setup(model string) (err error) {
 	err = g.initModel(model)
 	Ck(err)
 	g.initClients()
+	err = g.initEmbedder()
+	Ck(err)
+	err = g.initVectors()
+	Ck(err)
 	err = InitTokenizer()
 	Ck(err)
 	return
@@ -101,13 +128,36 @@ func (g *Grokker) initModel(model string) (err error) {
 	m.active = true
 	g.Model = model
 	g.modelObj = m
-	// XXX replace with a real tokenizer.
-	// charsPerToken := 3.1
-	// g.maxChunkLen = int(math.Floor(float64(m.TokenLimit) * charsPerToken))
-	// XXX replace with a real tokenizer.
-	// g.maxEmbeddingChunkLen = int(math.Floor(float64(8192) * charsPerToken))
+	// TokenLimit is in the model's tokens, which context assembly
+	// and TokenCount count with g.tokenizer; EmbeddingTokenLimit is
+	// in the embedding model's, which chunking counts with Tokenizer
+	g.tokenizer, err = tokenizer.Get(modelEncoding(m))
+	Ck(err)
 	g.TokenLimit = m.TokenLimit
 	//TokenLimithardcoded for the text-embedding-ada-002 model
 	g.EmbeddingTokenLimit = 8192
 	return
 }
+
+// o200kPrefixes are the prefixes of the names of the OpenAI models
+// whose tokenizer is o200k_base.
+var o200kPrefixes = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"}
+
+// modelEncoding returns the encoding of the model's tokenizer, as
+// tiktoken has it: o200k_base for GPT-4o and later and the o-series
+// models, and cl100k_base for older models.  Other providers' models
+// have their own tokenizers, which cl100k_base approximates.
+func modelEncoding(m *Model) tokenizer.Encoding {
+	name := m.upstreamName
+	if name == "" {
+		name = m.Name
+	}
+	// providers may serve OpenAI's models as e.g. "openai/gpt-4o"
+	name = name[strings.LastIndex(name, "/")+1:]
+	for _, prefix := range o200kPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return tokenizer.O200kBase
+		}
+	}
+	return tokenizer.Cl100kBase
+}
@@ -0,0 +1,206 @@
+//go:build onnx
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/stevegt/goadapt"
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// onnxEmbedder runs a sentence embedding model with the ONNX
+// runtime.  The model must take input_ids, attention_mask, and
+// token_type_ids and produce last_hidden_state as its first output,
+// which is how the common sentence-transformers exports look.
+type onnxEmbedder struct {
+	specStr string
+	tok     *wordpiece
+	session *ort.DynamicAdvancedSession
+	inputs  []string
+	dim     int
+}
+
+var ortInit sync.Once
+var ortErr error
+
+// initOrt initializes the ONNX runtime once per process.  The
+// shared library is found via ONNXRUNTIME_LIB, or the platform's
+// default library search path.
+func initOrt() error {
+	ortInit.Do(func() {
+		lib := os.Getenv("ONNXRUNTIME_LIB")
+		if lib != "" {
+			ort.SetSharedLibraryPath(lib)
+		}
+		ortErr = ort.InitializeEnvironment()
+		if ortErr != nil {
+			ortErr = fmt.Errorf("cannot initialize ONNX runtime; set ONNXRUNTIME_LIB: %w", ortErr)
+		}
+	})
+	return ortErr
+}
+
+// newOnnxEmbedder loads model.onnx and vocab.txt from dir.
+func newOnnxEmbedder(spec, dir string) (e localEmbedder, err error) {
+	defer Return(&err)
+	err = initOrt()
+	Ck(err)
+
+	tok, err := loadWordpiece(filepath.Join(dir, "vocab.txt"), 512)
+	Ck(err)
+	modelfn := filepath.Join(dir, "model.onnx")
+	ins, outs, err := ort.GetInputOutputInfo(modelfn)
+	Ck(err)
+	Assert(len(outs) > 0, "%s has no outputs", modelfn)
+	shape := outs[0].Dimensions
+	Assert(len(shape) == 3 && shape[2] > 0, "%s: expected [batch, seq, dim] output, got %v", modelfn, shape)
+	oe := &onnxEmbedder{specStr: spec, tok: tok, dim: int(shape[2])}
+	// some exports omit token_type_ids
+	for _, in := range ins {
+		switch in.Name {
+		case "input_ids", "attention_mask", "token_type_ids":
+			oe.inputs = append(oe.inputs, in.Name)
+		}
+	}
+	oe.session, err = ort.NewDynamicAdvancedSession(modelfn, oe.inputs, []string{outs[0].Name}, nil)
+	Ck(err)
+	e = oe
+	return
+}
+
+func (e *onnxEmbedder) spec() string {
+	return e.specStr
+}
+
+// embed runs the model once per text.
+func (e *onnxEmbedder) embed(texts []string) (embeddings [][]float64, err error) {
+	defer Return(&err)
+	for i, text := range texts {
+		if len(text) == 0 {
+			embeddings = append(embeddings, nil)
+			continue
+		}
+		Debug("creating local embedding for chunk %d of %d ...", i+1, len(texts))
+		var vec []float64
+		vec, err = e.embedOne(text)
+		Ck(err)
+		embeddings = append(embeddings, vec)
+	}
+	return
+}
+
+// embedOne runs the model on a single text and mean-pools the output.
+func (e *onnxEmbedder) embedOne(text string) (vec []float64, err error) {
+	defer Return(&err)
+	ids := e.tok.encode(text)
+	n := int64(len(ids))
+	mask := make([]int64, n)
+	types := make([]int64, n)
+	for j := range mask {
+		mask[j] = 1
+	}
+	data := map[string][]int64{
+		"input_ids":      ids,
+		"attention_mask": mask,
+		"token_type_ids": types,
+	}
+	var inputs []ort.Value
+	for _, name := range e.inputs {
+		t, err := ort.NewTensor(ort.NewShape(1, n), data[name])
+		Ck(err)
+		defer t.Destroy()
+		inputs = append(inputs, t)
+	}
+	out, err := ort.NewEmptyTensor[float32](ort.NewShape(1, n, int64(e.dim)))
+	Ck(err)
+	defer out.Destroy()
+	err = e.session.Run(inputs, []ort.Value{out})
+	Ck(err)
+	vec = meanPool(out.GetData(), mask, e.dim)
+	return
+}
+
+// onnxReranker runs a cross-encoder model, such as
+// ms-marco-MiniLM-L-6-v2, with the ONNX runtime.  The model must take
+// input_ids, attention_mask, and optionally token_type_ids, and
+// produce one relevance logit per input as its first output.
+type onnxReranker struct {
+	specStr string
+	tok     *wordpiece
+	session *ort.DynamicAdvancedSession
+	inputs  []string
+}
+
+// newOnnxReranker loads model.onnx and vocab.txt from dir.
+func newOnnxReranker(spec, dir string) (r localReranker, err error) {
+	defer Return(&err)
+	err = initOrt()
+	Ck(err)
+	tok, err := loadWordpiece(filepath.Join(dir, "vocab.txt"), 512)
+	Ck(err)
+	modelfn := filepath.Join(dir, "model.onnx")
+	ins, outs, err := ort.GetInputOutputInfo(modelfn)
+	Ck(err)
+	Assert(len(outs) > 0, "%s has no outputs", modelfn)
+	cr := &onnxReranker{specStr: spec, tok: tok}
+	for _, in := range ins {
+		switch in.Name {
+		case "input_ids", "attention_mask", "token_type_ids":
+			cr.inputs = append(cr.inputs, in.Name)
+		}
+	}
+	cr.session, err = ort.NewDynamicAdvancedSession(modelfn, cr.inputs, []string{outs[0].Name}, nil)
+	Ck(err)
+	r = cr
+	return
+}
+
+func (r *onnxReranker) spec() string {
+	return r.specStr
+}
+
+// score runs the model once per passage.
+func (r *onnxReranker) score(query string, passages []string) (scores []float64, err error) {
+	defer Return(&err)
+	for _, passage := range passages {
+		var s float64
+		s, err = r.scoreOne(query, passage)
+		Ck(err)
+		scores = append(scores, s)
+	}
+	return
+}
+
+// scoreOne returns the relevance logit for one query/passage pair.
+func (r *onnxReranker) scoreOne(query, passage string) (s float64, err error) {
+	defer Return(&err)
+	ids, types := r.tok.encodePair(query, passage)
+	n := int64(len(ids))
+	mask := make([]int64, n)
+	for j := range mask {
+		mask[j] = 1
+	}
+	data := map[string][]int64{
+		"input_ids":      ids,
+		"attention_mask": mask,
+		"token_type_ids": types,
+	}
+	var inputs []ort.Value
+	for _, name := range r.inputs {
+		t, err := ort.NewTensor(ort.NewShape(1, n), data[name])
+		Ck(err)
+		defer t.Destroy()
+		inputs = append(inputs, t)
+	}
+	out, err := ort.NewEmptyTensor[float32](ort.NewShape(1, 1))
+	Ck(err)
+	defer out.Destroy()
+	err = r.session.Run(inputs, []ort.Value{out})
+	Ck(err)
+	s = float64(out.GetData()[0])
+	return
+}
@@ -0,0 +1,17 @@
+//go:build !onnx
+
+package core
+
+import "fmt"
+
+// newOnnxReranker is a stub for builds without ONNX runtime support.
+func newOnnxReranker(spec, dir string) (r localReranker, err error) {
+	err = fmt.Errorf("%s: grokker was built without ONNX support; rebuild with '-tags onnx'", spec)
+	return
+}
+
+// newOnnxEmbedder is a stub for builds without ONNX runtime support.
+func newOnnxEmbedder(spec, dir string) (e localEmbedder, err error) {
+	err = fmt.Errorf("%s: grokker was built without ONNX support; rebuild with '-tags onnx'", spec)
+	return
+}
@@ -2,7 +2,12 @@ package core
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
@@ -27,8 +32,87 @@ var SysMsgRevise = "You are an expert knowledgable in the provided context.  I w
 var SysMsgContinue = "You are an expert knowledgable in the provided context.  I will provide you with context, then you will respond with an acknowledgement, then I will provide you with a block of text.  You will continue the block of text based on the information in the context, maintaining the same style, vocabulary, and reading level."
 
 // createEmbeddings returns the embeddings for a slice of text chunks.
+// Embeddings are reused from the embedding cache when possible.
 func (g *Grokker) createEmbeddings(texts []string) (embeddings [][]float64, err error) {
 	defer Return(&err)
+	err = g.checkEmbedder()
+	Ck(err)
+	defer func() {
+		if err == nil {
+			err = g.checkDims(embeddings)
+		}
+	}()
+	if g.noEmbeddingCache || g.cacheDir() == "" {
+		embeddings, err = g.fetchEmbeddings(texts)
+		Ck(err)
+		g.embeddingsFetched += len(texts)
+		return
+	}
+	embeddings = make([][]float64, len(texts))
+	keys := make([]string, len(texts))
+	var missing []string
+	var missingIdx []int
+	hits := 0
+	for i, text := range texts {
+		if text != "" {
+			keys[i] = g.embeddingCacheKey(text)
+			if g.cacheGet("embeddings", keys[i], &embeddings[i]) {
+				hits++
+				continue
+			}
+		}
+		missing = append(missing, text)
+		missingIdx = append(missingIdx, i)
+	}
+	Debug("embedding cache: %d hits, %d misses", hits, len(missing))
+	recordCache("embeddings", hits, len(missing))
+	g.embeddingsCached += hits
+	if len(missing) == 0 {
+		return
+	}
+	fetched, err := g.fetchEmbeddings(missing)
+	Ck(err)
+	g.embeddingsFetched += len(missing)
+	Assert(len(fetched) == len(missing), "expected %d embeddings, got %d", len(missing), len(fetched))
+	for j, i := range missingIdx {
+		embeddings[i] = fetched[j]
+		if keys[i] != "" && fetched[j] != nil {
+			g.cachePut("embeddings", keys[i], fetched[j])
+		}
+	}
+	return
+}
+
+// embeddingCacheKey returns the embedding cache key for text.  The
+// key is made from the text's sha256 rather than the text itself, so
+// the key of a chunk's embedding can be made from the chunk's Hash
+// after its document is gone; see bury.
+func (g *Grokker) embeddingCacheKey(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return g.embeddingHashKey(hex.EncodeToString(sum[:]))
+}
+
+// embeddingHashKey returns the embedding cache key for the text whose
+// sha256 is hash.
+func (g *Grokker) embeddingHashKey(hash string) string {
+	// a local embedder is identified by its whole spec, since
+	// embedderID only has the model's directory name
+	provider, model := g.embedderID()
+	if g.embedder != nil {
+		model = g.embedder.spec()
+	}
+	return cacheKey(provider, model, "sha256:"+hash)
+}
+
+// fetchEmbeddings gets the embeddings for texts from the embedder.
+func (g *Grokker) fetchEmbeddings(texts []string) (embeddings [][]float64, err error) {
+	defer Return(&err)
+	if g.embedder != nil {
+		embeddings, err = g.embedder.embed(texts)
+		Ck(err)
+		Assert(len(embeddings) == len(texts))
+		return
+	}
 	// use github.com/fabiustech/openai library
 	c := g.embeddingClient
 	// simply call c.CreateEmbeddings() once for each text chunk.
@@ -39,7 +123,8 @@ func (g *Grokker) createEmbeddings(texts []string) (embeddings [][]float64, err
 			embeddings = append(embeddings, nil)
 			continue
 		}
-		inputs := []string{text}
+		inputs, err := g.checkOutgoing(openaiEmbeddingProvider, []string{text})
+		Ck(err)
 		req := &embedLib.EmbeddingRequest{
 			Input: inputs,
 			Model: embedModelLib.AdaEmbeddingV2,
@@ -49,7 +134,9 @@ func (g *Grokker) createEmbeddings(texts []string) (embeddings [][]float64, err
 		// loop with backoff until we get a response
 		var res *embedLib.EmbeddingResponse
 		for backoff := 1; backoff < 10; backoff++ {
+			start := time.Now()
 			res, err = c.CreateEmbeddings(context.Background(), req)
+			recordRequest(openaiEmbeddingProvider, start, err)
 			if err == nil {
 				break
 			}
@@ -58,6 +145,19 @@ func (g *Grokker) createEmbeddings(texts []string) (embeddings [][]float64, err
 			time.Sleep(time.Second * time.Duration(backoff))
 		}
 		Ck(err, "%T: %#v", err, err)
+		_, model := g.embedderID()
+		entry := &AuditEntry{
+			Kind:  "embedding",
+			Model: model,
+		}
+		for _, input := range inputs {
+			entry.Request = append(entry.Request, AuditMsg{Role: "input", Content: input})
+		}
+		if res.Usage != nil {
+			entry.PromptTokens = res.Usage.PromptTokens
+			g.tokensUsed += res.Usage.TotalTokens
+		}
+		g.logAudit(entry)
 		for _, em := range res.Data {
 			embeddings = append(embeddings, em.Embedding)
 		}
@@ -110,12 +210,29 @@ func (g *Grokker) CompleteChat(sysmsg string, msgs []ChatMsg) (response string,
 	return
 }
 
-// generate returns the answer to a question.
+// generate returns the answer to a question.  How the context is
+// laid out in the messages depends on the pipeline; see messages.go.
 func (g *Grokker) generate(sysmsg, question, ctxt string, global bool) (resp gptLib.ChatCompletionResponse, err error) {
+	return g.generateStream(sysmsg, question, ctxt, global, nil)
+}
+
+// generateStream is generate, but if fn isn't nil, the answer is
+// streamed to fn as it arrives.
+func (g *Grokker) generateStream(sysmsg, question, ctxt string, global bool, fn func(text string) error) (resp gptLib.ChatCompletionResponse, err error) {
 	defer Return(&err)
 
 	// XXX don't exceed max tokens
 
+	parts := g.contextParts(ctxt)
+	if g.Pipeline.Harden && len(parts) > 0 {
+		// fence the context off as data; see injection.go
+		var fencemsg string
+		parts, fencemsg = hardenParts(question, parts)
+		sysmsg = strings.TrimSpace(sysmsg) + "\n\n" + fencemsg
+	}
+	if g.Pipeline.CitationMarkers && len(parts) > 0 {
+		sysmsg = strings.TrimSpace(sysmsg) + "\n\n" + markerSysmsg
+	}
 	messages := initMessages(g, sysmsg)
 
 	// first get global knowledge
@@ -133,29 +250,19 @@ func (g *Grokker) generate(sysmsg, question, ctxt string, global bool) (resp gpt
 		})
 	}
 
-	// add context from local sources
-	if len(ctxt) > 0 {
-		messages = append(messages, []gptLib.ChatCompletionMessage{
-			{
-				Role:    gptLib.ChatMessageRoleUser,
-				Content: Spf("Context:\n\n%s", ctxt),
-			},
-			{
-				Role:    gptLib.ChatMessageRoleAssistant,
-				Content: "Great! I've read the context.",
-			},
-		}...)
-	}
-
-	// now ask the question
+	// add context from local sources, then ask the question
+	messages, question = g.placeContext(messages, question, parts)
 	messages = append(messages, gptLib.ChatCompletionMessage{
 		Role:    gptLib.ChatMessageRoleUser,
 		Content: question,
 	})
 
 	// get the answer
-	resp, err = g.chat(messages)
+	resp, err = g.chatStream(messages, fn)
 	Ck(err, "context length: %d type: %T: %#v", len(ctxt), ctxt, ctxt)
+	if g.Pipeline.CitationMarkers && len(resp.Choices) > 0 {
+		resp.Choices[0].Message.Content = expandMarkers(resp.Choices[0].Message.Content, parts)
+	}
 
 	// fmt.Println(resp.Choices[0].Message.Content)
 	// Pprint(messages)
@@ -173,7 +280,7 @@ func (g *Grokker) msg(sysmsg, input string) (resp gptLib.ChatCompletionResponse,
 	inputTc, err := g.TokenCount(input)
 	Ck(err)
 	if sysmsgTc+inputTc > g.TokenLimit {
-		err = fmt.Errorf("token count %d exceeds token limit %d", sysmsgTc+inputTc, g.TokenLimit)
+		err = classify(FailBudget, fmt.Errorf("token count %d exceeds token limit %d", sysmsgTc+inputTc, g.TokenLimit))
 		return
 	}
 
@@ -197,23 +304,8 @@ func (g *Grokker) msg(sysmsg, input string) (resp gptLib.ChatCompletionResponse,
 // the system message if the model supports it, otherwise it includes the
 // system message in the first user message.
 func initMessages(g *Grokker, sysmsg string) []gptLib.ChatCompletionMessage {
-	// noSysMsg that do not support system messages
-	noSysMsg := []string{
-		"o1-preview",
-		"o1-mini",
-		"o3-mini",
-	}
-	sysmsgOk := true
-	for _, model := range noSysMsg {
-		if g.Model == model {
-			sysmsgOk = false
-			break
-		}
-	}
-	sysmsgRole := gptLib.ChatMessageRoleSystem
-	if !sysmsgOk {
-		sysmsgRole = gptLib.ChatMessageRoleUser
-	}
+	sysmsgRole := g.systemRole()
+	sysmsgOk := sysmsgRole == gptLib.ChatMessageRoleSystem
 	messages := []gptLib.ChatCompletionMessage{
 		{
 			Role:    sysmsgRole,
@@ -231,12 +323,35 @@ func initMessages(g *Grokker, sysmsg string) []gptLib.ChatCompletionMessage {
 	return messages
 }
 
+// systemRole returns the role to send system messages as: system,
+// or user for the models that don't support system messages.
+func (g *Grokker) systemRole() string {
+	// models that do not support system messages
+	noSysMsg := []string{
+		"o1-preview",
+		"o1-mini",
+		"o3-mini",
+	}
+	for _, model := range noSysMsg {
+		if g.Model == model {
+			return gptLib.ChatMessageRoleUser
+		}
+	}
+	return gptLib.ChatMessageRoleSystem
+}
+
 // chat uses the openai API to continue a conversation given a
 // (possibly synthesized) message history.
 func (g *Grokker) chat(messages []gptLib.ChatCompletionMessage) (resp gptLib.ChatCompletionResponse, err error) {
+	return g.chatStream(messages, nil)
+}
+
+// chatStream is chat, but if fn isn't nil, the response is streamed
+// to fn as it arrives.
+func (g *Grokker) chatStream(messages []gptLib.ChatCompletionMessage, fn func(text string) error) (resp gptLib.ChatCompletionResponse, err error) {
 	defer Return(&err)
 
-	resp, err = g.complete(messages)
+	resp, err = g.completeStream(messages, fn)
 	Ck(err, "%#v", messages)
 	totalBytes := 0
 	for _, msg := range messages {
@@ -250,15 +365,152 @@ func (g *Grokker) chat(messages []gptLib.ChatCompletionMessage) (resp gptLib.Cha
 }
 
 func (g *Grokker) complete(messages []gptLib.ChatCompletionMessage) (res gptLib.ChatCompletionResponse, err error) {
+	return g.completeStream(messages, nil)
+}
+
+// completeStream sends a chat completion request.  If fn isn't nil,
+// the response is streamed, and fn is called with each piece of its
+// text as it arrives; a cached response is one piece.
+func (g *Grokker) completeStream(messages []gptLib.ChatCompletionMessage, fn func(text string) error) (res gptLib.ChatCompletionResponse, err error) {
+	var texts []string
+	for _, msg := range messages {
+		texts = append(texts, msg.Content)
+	}
+	texts, err = g.checkOutgoing(g.chatProvider(), texts)
+	if err != nil {
+		return
+	}
+	p, err := g.getPolicy()
+	if err != nil {
+		return
+	}
+	_, _, maxResponseTokens := p.limits(g.chatProvider())
+	for i := range messages {
+		messages[i].Content = texts[i]
+	}
 	client := g.chatClient
-	res, err = client.CreateChatCompletion(
-		context.Background(),
-		gptLib.ChatCompletionRequest{
-			Model:    g.modelObj.upstreamName,
-			Messages: messages,
+	req := gptLib.ChatCompletionRequest{
+		Model:    g.modelObj.upstreamName,
+		Messages: messages,
+	}
+	if g.persona != nil {
+		req.Temperature = g.persona.Temperature
+	}
+	req.Seed = g.seed
+	req.MaxTokens = maxResponseTokens
+	// the response cache is keyed by the whole request, so a
+	// different model, prompt, or temperature is a miss
+	var key string
+	if g.responseCache {
+		var buf []byte
+		buf, err = json.Marshal(req)
+		if err != nil {
+			return
+		}
+		key = cacheKey(g.chatProvider(), string(buf))
+		if g.cacheGet("responses", key, &res) {
+			recordCache("responses", 1, 0)
+			g.recordManifest(req, res, true)
+			if fn != nil && len(res.Choices) > 0 {
+				err = fn(res.Choices[0].Message.Content)
+			}
+			return
+		}
+		recordCache("responses", 0, 1)
+	}
+	start := time.Now()
+	if fn != nil {
+		res, err = streamCompletion(client, req, fn)
+	} else {
+		res, err = client.CreateChatCompletion(context.Background(), req)
+	}
+	recordRequest(g.chatProvider(), start, err)
+	if err != nil {
+		return
+	}
+	if key != "" {
+		g.cachePut("responses", key, res)
+	}
+	g.recordManifest(req, res, false)
+	g.tokensUsed += res.Usage.TotalTokens
+	g.promptTokens += res.Usage.PromptTokens
+	g.completionTokens += res.Usage.CompletionTokens
+	entry := &AuditEntry{
+		Kind:             "chat",
+		Model:            g.Model,
+		PromptTokens:     res.Usage.PromptTokens,
+		CompletionTokens: res.Usage.CompletionTokens,
+	}
+	for _, msg := range messages {
+		entry.Request = append(entry.Request, AuditMsg{msg.Role, msg.Content})
+	}
+	if len(res.Choices) > 0 {
+		entry.Response = res.Choices[0].Message.Content
+	}
+	g.logAudit(entry)
+	return
+}
+
+// streamCompletion sends a chat completion request as a stream,
+// calling fn with each piece of the response's text as it arrives,
+// and returns the response as if it hadn't been streamed, usage
+// included if the provider reports it.
+func streamCompletion(client *gptLib.Client, req gptLib.ChatCompletionRequest, fn func(text string) error) (res gptLib.ChatCompletionResponse, err error) {
+	req.StreamOptions = &gptLib.StreamOptions{IncludeUsage: true}
+	stream, err := client.CreateChatCompletionStream(context.Background(), req)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	var content strings.Builder
+	var finish gptLib.FinishReason
+	for {
+		var chunk gptLib.ChatCompletionStreamResponse
+		chunk, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		res.ID, res.Model, res.Created = chunk.ID, chunk.Model, chunk.Created
+		res.SystemFingerprint = chunk.SystemFingerprint
+		if chunk.Usage != nil {
+			res.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if choice.FinishReason != "" {
+				finish = choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			err = fn(choice.Delta.Content)
+			if err != nil {
+				return
+			}
+		}
+	}
+	res.Object = "chat.completion"
+	res.Choices = []gptLib.ChatCompletionChoice{{
+		Message: gptLib.ChatCompletionMessage{
+			Role:    gptLib.ChatMessageRoleAssistant,
+			Content: content.String(),
 		},
-	)
-	return res, err
+		FinishReason: finish,
+	}}
+	return
+}
+
+// TokensUsed returns the number of model tokens, prompt and
+// completion, used by remote requests since the Grokker was loaded.
+func (g *Grokker) TokensUsed() int {
+	return g.tokensUsed
 }
 
 // initClients initializes the OpenAI clients.
@@ -268,5 +520,13 @@ func (g *Grokker) initClients() {
 	authtoken := os.Getenv("OPENAI_API_KEY")
 	g.embeddingClient = embedLib.NewClient(authtoken)
 	g.chatClient = gptLib.NewClient(authtoken)
+	// chat with models from other providers via their
+	// OpenAI-compatible endpoint; embeddings still come from OpenAI
+	if g.modelObj != nil && g.modelObj.provider != "" {
+		p, ok := Providers()[g.modelObj.provider]
+		if ok {
+			g.chatClient = gptLib.NewClientWithConfig(p.clientConfig())
+		}
+	}
 	return
 }
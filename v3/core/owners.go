@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	. "github.com/stevegt/goadapt"
+)
+
+// So that "who should I ask about the billing module?" gets a real
+// answer, the context for a question ends with the owners of the
+// documents it cites, from the tree's CODEOWNERS file, and their
+// main authors, from git blame.  CODEOWNERS is read for each
+// question, so changes apply at once; authors are found when a
+// document is chunked and kept with it, since blame is slow on
+// large files.  Blame only counts committed lines, so a document's
+// authors are kept until the tree's HEAD moves.  The ownership lines
+// count against the context's token budget; those that don't fit
+// are left out.  Names are personal data sent to the model, so
+// Pipeline.NoOwners turns all of this off.
+
+// codeownersPaths are where CODEOWNERS files live, relative to the
+// root, in the order GitHub and GitLab look for them.
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"}
+
+// maxAuthors is the most authors kept for a document.
+const maxAuthors = 3
+
+// codeownersRule is a CODEOWNERS line: a pattern and its owners.
+type codeownersRule struct {
+	pattern *gitignore.GitIgnore
+	owners  []string
+}
+
+// loadCodeowners returns the rules in the tree's CODEOWNERS file, or
+// nil if there is none.
+func loadCodeowners(root string) (rules []codeownersRule, err error) {
+	defer Return(&err)
+	for _, fn := range codeownersPaths {
+		buf, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(fn)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		Ck(err)
+		for _, line := range strings.Split(string(buf), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			var owners []string
+			for _, owner := range fields[1:] {
+				if strings.HasPrefix(owner, "#") {
+					// trailing comment
+					break
+				}
+				owners = append(owners, owner)
+			}
+			rules = append(rules, codeownersRule{
+				pattern: gitignore.CompileIgnoreLines(fields[0]),
+				owners:  owners,
+			})
+		}
+		return rules, nil
+	}
+	return
+}
+
+// matchOwners returns the owners of relpath.  As in CODEOWNERS, the
+// last matching rule wins, and a rule without owners means the file
+// has none.
+func matchOwners(rules []codeownersRule, relpath string) (owners []string) {
+	for _, rule := range rules {
+		if rule.pattern.MatchesPath(relpath) {
+			owners = rule.owners
+		}
+	}
+	return
+}
+
+// blameAuthors returns the authors of the most lines of a file, most
+// lines first.
+func blameAuthors(root, relpath string) (authors []string, err error) {
+	defer Return(&err)
+	cmd := exec.Command("git", "blame", "--line-porcelain", "--", relpath)
+	cmd.Dir = root
+	out, err := cmd.Output()
+	Ck(err)
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "author "); ok {
+			if name == "Not Committed Yet" {
+				continue
+			}
+			if counts[name] == 0 {
+				authors = append(authors, name)
+			}
+			counts[name]++
+		}
+	}
+	sort.SliceStable(authors, func(i, j int) bool {
+		return counts[authors[i]] > counts[authors[j]]
+	})
+	if len(authors) > maxAuthors {
+		authors = authors[:maxAuthors]
+	}
+	return
+}
+
+// headCommit returns the commit checked out in the tree, or "" if
+// the tree isn't a git work tree.  It is read once per refresh; see
+// UpdateEmbeddings.
+func (g *Grokker) headCommit() string {
+	if g.gitHead == nil {
+		head := ""
+		if _, err := os.Stat(filepath.Join(g.Root, ".git")); err == nil {
+			cmd := exec.Command("git", "rev-parse", "HEAD")
+			cmd.Dir = g.Root
+			out, err := cmd.Output()
+			if err == nil {
+				head = strings.TrimSpace(string(out))
+			}
+		}
+		g.gitHead = &head
+	}
+	return *g.gitHead
+}
+
+// setAuthors updates a document's authors from git blame, unless
+// they were found at the current commit.  Documents outside a git
+// work tree, virtual documents, and files git doesn't track have
+// none.
+func (g *Grokker) setAuthors(doc *Document) {
+	if doc.Virtual || g.Pipeline.NoOwners {
+		doc.Authors, doc.AuthorsAt = nil, ""
+		return
+	}
+	head := g.headCommit()
+	if head != "" && head == doc.AuthorsAt {
+		return
+	}
+	doc.Authors, doc.AuthorsAt = nil, ""
+	if head == "" {
+		return
+	}
+	authors, err := blameAuthors(g.Root, doc.RelPath)
+	if err != nil {
+		Debug("no authors for %s: %v", doc.RelPath, err)
+		return
+	}
+	doc.Authors, doc.AuthorsAt = authors, head
+}
+
+// ownership returns a line for each of the given documents that has
+// owners or authors, e.g.
+//
+//	billing/invoice.go: owned by @acme/billing; mostly written by Ann Lee, Bo Chen
+//
+// for as many of them, in order, as fit in budget tokens.
+func (g *Grokker) ownership(relpaths []string, budget int) (text string, err error) {
+	defer Return(&err)
+	if g.Pipeline.NoOwners {
+		return
+	}
+	rules, err := loadCodeowners(g.Root)
+	Ck(err)
+	docs := make(map[string]*Document, len(g.Documents))
+	for _, doc := range g.Documents {
+		docs[doc.RelPath] = doc
+	}
+	const heading = "Ownership of the files above:\n"
+	var lines []string
+	used, err := g.tokens(heading)
+	Ck(err)
+	for _, relpath := range relpaths {
+		var parts []string
+		if owners := matchOwners(rules, relpath); len(owners) > 0 {
+			parts = append(parts, "owned by "+strings.Join(owners, " "))
+		}
+		if doc := docs[relpath]; doc != nil && len(doc.Authors) > 0 {
+			parts = append(parts, "mostly written by "+strings.Join(doc.Authors, ", "))
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		line := Spf("%s: %s\n", relpath, strings.Join(parts, "; "))
+		tokens, err := g.tokens(line)
+		Ck(err)
+		if len(used)+len(tokens) > budget {
+			break
+		}
+		used = append(used, tokens...)
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		text = heading + strings.Join(lines, "")
+	}
+	return
+}
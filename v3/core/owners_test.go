@@ -0,0 +1,96 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestCodeowners(t *testing.T) {
+	dir := TmpTestDir()
+	err := os.MkdirAll(filepath.Join(dir, ".github"), 0755)
+	Tassert(t, err == nil, "error creating dir: %v", err)
+	src := "# owners\n* @acme/core\n/billing/ @acme/billing ann@example.com # money\n*.md @acme/docs\nbilling/vendor/\n"
+	err = os.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte(src), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	rules, err := loadCodeowners(dir)
+	Tassert(t, err == nil, "error loading CODEOWNERS: %v", err)
+	cases := map[string]string{
+		"main.go":              "@acme/core",
+		"billing/invoice.go":   "@acme/billing ann@example.com",
+		"billing/README.md":    "@acme/docs",
+		"billing/vendor/x.go":  "",
+		"docs/guide/README.md": "@acme/docs",
+	}
+	for relpath, want := range cases {
+		got := strings.Join(matchOwners(rules, relpath), " ")
+		Tassert(t, got == want, "%s: expected %q, got %q", relpath, want, got)
+	}
+
+	rules, err = loadCodeowners(TmpTestDir())
+	Tassert(t, err == nil && rules == nil, "expected no rules without CODEOWNERS: %v", err)
+}
+
+func TestOwnership(t *testing.T) {
+	dir := TmpTestDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		out, err := cmd.CombinedOutput()
+		Tassert(t, err == nil, "git %v: %v: %s", args, err, out)
+	}
+	git("init", "-q")
+	write := func(fn, content string) {
+		err := os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Tassert(t, err == nil, "error writing file: %v", err)
+	}
+	write("a.go", "one\ntwo\nthree\n")
+	git("add", "a.go")
+	git("-c", "user.name=Ann Lee", "-c", "user.email=ann@example.com", "commit", "-q", "-m", "add a")
+	write("a.go", "one\ntwo\nthree\nfour\n")
+	git("-c", "user.name=Bo Chen", "-c", "user.email=bo@example.com", "commit", "-q", "-am", "edit a")
+	write("CODEOWNERS", "*.go @acme/core\n")
+
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	doc := &Document{RelPath: "a.go"}
+	grok.Documents = append(grok.Documents, doc)
+	_, err = grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, strings.Join(doc.Authors, ", ") == "Ann Lee, Bo Chen", "unexpected authors %v", doc.Authors)
+
+	text, err := grok.ownership([]string{"a.go", "notes.txt"}, 1000)
+	Tassert(t, err == nil, "error getting ownership: %v", err)
+	want := "Ownership of the files above:\na.go: owned by @acme/core; mostly written by Ann Lee, Bo Chen\n"
+	Tassert(t, text == want, "expected %q, got %q", want, text)
+	// the lines count against the budget
+	text, err = grok.ownership([]string{"a.go"}, 10)
+	Tassert(t, err == nil && text == "", "expected nothing to fit, got %q: %v", text, err)
+
+	// blame runs again only once HEAD moves
+	head := doc.AuthorsAt
+	Tassert(t, len(head) == 40, "expected the commit the authors were found at, got %q", head)
+	write("a.go", "five\nsix\nseven\neight\nnine\n")
+	git("-c", "user.name=Cy Diaz", "-c", "user.email=cy@example.com", "commit", "-q", "-am", "rewrite a")
+	_, err = grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, strings.Join(doc.Authors, ", ") == "Ann Lee, Bo Chen" && doc.AuthorsAt == head, "expected the cached authors, got %v", doc.Authors)
+	grok.gitHead = nil
+	_, err = grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, strings.Join(doc.Authors, ", ") == "Cy Diaz" && doc.AuthorsAt != head, "expected new authors, got %v", doc.Authors)
+
+	// the switch leaves names out entirely
+	err = grok.SetPipeline("noowners", "true")
+	Tassert(t, err == nil, "error setting noowners: %v", err)
+	text, err = grok.ownership([]string{"a.go"}, 1000)
+	Tassert(t, err == nil && text == "", "expected no ownership, got %q: %v", text, err)
+	_, err = grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, doc.Authors == nil, "expected no authors, got %v", doc.Authors)
+}
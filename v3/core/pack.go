@@ -0,0 +1,97 @@
+package core
+
+import (
+	"sort"
+)
+
+// Context packing picks which of the best matching chunks go into
+// the context.  Filling the budget in rank order lets one long,
+// middling chunk crowd out several short, relevant ones.  Instead,
+// the candidates, about packWindow times as many tokens as fit, are
+// scored by relevance per token and packed greedily.  Each chunk
+// costs its tokens plus packOverhead, so the packed context stays
+// within the budget.  The packed chunks keep their rank order.
+
+// packWindow is how many budgets' worth of candidates are packed.
+const packWindow = 2
+
+// packOverhead is the approximate cost in tokens of including a
+// chunk besides its text, e.g. its "from" header.
+const packOverhead = 16
+
+// packFloor places the floor the scores are normalized against
+// below the lowest candidate's score, as a fraction of the spread of
+// the scores, so the lowest candidate is still worth packing.
+const packFloor = 0.25
+
+// packItem is a candidate for the context.
+type packItem struct {
+	chunk  *Chunk
+	score  float64
+	tokens int
+	// forced items are packed first, if they fit; see MinSources
+	forced bool
+}
+
+// pack returns the chunks of the items that best fill tokenLimit, in
+// item order.
+func pack(items []packItem, tokenLimit int) (chunks []*Chunk) {
+	total := 0
+	for _, item := range items {
+		total += item.tokens + packOverhead
+	}
+	if total <= tokenLimit {
+		for _, item := range items {
+			chunks = append(chunks, item.chunk)
+		}
+		return
+	}
+	// normalize the scores, which may be similarities or reranker
+	// logits, to 0..1 against a floor below the candidates
+	lo, hi := items[0].score, items[0].score
+	for _, item := range items {
+		if item.score < lo {
+			lo = item.score
+		}
+		if item.score > hi {
+			hi = item.score
+		}
+	}
+	floor := lo - (hi-lo)*packFloor
+	value := func(item packItem) float64 {
+		if hi == lo {
+			return 1
+		}
+		return (item.score - floor) / (hi - floor)
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	density := func(i int) float64 {
+		return value(items[i]) / float64(items[i].tokens+packOverhead)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ia, ib := items[order[a]], items[order[b]]
+		if ia.forced != ib.forced {
+			return ia.forced
+		}
+		return density(order[a]) > density(order[b])
+	})
+	picked := make([]bool, len(items))
+	room := tokenLimit
+	for _, i := range order {
+		cost := items[i].tokens + packOverhead
+		if cost > room {
+			continue
+		}
+		picked[i] = true
+		room -= cost
+	}
+	for i, item := range items {
+		if picked[i] {
+			chunks = append(chunks, item.chunk)
+		}
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPack(t *testing.T) {
+	c := func(hash string) *Chunk { return &Chunk{Hash: hash} }
+	hashes := func(chunks []*Chunk) (out string) {
+		for _, c := range chunks {
+			out += c.Hash + " "
+		}
+		return
+	}
+	// everything fits, each chunk with its overhead
+	items := []packItem{{c("a"), 0.9, 100, false}, {c("b"), 0.5, 100, false}}
+	got := hashes(pack(items, 200+2*packOverhead))
+	Tassert(t, got == "a b ", "unexpected packing %q", got)
+	got = hashes(pack(items, 200))
+	Tassert(t, got == "a ", "unexpected packing %q", got)
+
+	// a long middling chunk doesn't crowd out short relevant ones,
+	// and the order is kept
+	items = []packItem{
+		{c("best"), 0.90, 300, false},
+		{c("long"), 0.85, 600, false},
+		{c("short1"), 0.84, 150, false},
+		{c("short2"), 0.83, 150, false},
+		{c("tail"), 0.70, 100, false},
+	}
+	got = hashes(pack(items, 700))
+	Tassert(t, got == "best short1 short2 ", "unexpected packing %q", got)
+
+	// the packed chunks and their overhead can fill the limit
+	// exactly, but never exceed it
+	exact := 600 + 3*packOverhead
+	got = hashes(pack(items, exact))
+	Tassert(t, got == "best short1 short2 ", "unexpected packing %q", got)
+	got = hashes(pack(items, exact-1))
+	Tassert(t, got == "short1 short2 tail ", "unexpected packing %q", got)
+
+	// the lowest candidate is packed if there's room
+	got = hashes(pack(items, 1200))
+	Tassert(t, got == "best short1 short2 tail ", "unexpected packing %q", got)
+
+	// forced items go first
+	items[1].forced = true
+	got = hashes(pack(items, 900))
+	Tassert(t, got == "long short1 tail ", "unexpected packing %q", got)
+}
+
+func TestContextLimits(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	var pool []*Chunk
+	for i, path := range []string{"a.md", "b.md", "c.md"} {
+		pool = append(pool, &Chunk{
+			Document:    &Document{RelPath: path},
+			Text:        path,
+			Embedding:   []float64{1, float64(i), 0},
+			tokenLength: 10,
+		})
+	}
+	query := []float64{1, 0, 0}
+	chunks, err := grok.similarChunks("q", query, 1000, nil, pool)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(chunks) == 3, "expected all chunks, got %d", len(chunks))
+
+	grok.SetContextLimits(2, 0)
+	chunks, err = grok.similarChunks("q", query, 1000, nil, pool)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(chunks) == 2 && chunks[0].Text == "a.md" && chunks[1].Text == "b.md", "expected the top 2 chunks, got %v", chunks)
+}
+
+// logitReranker scores each passage by the logit of its document.
+type logitReranker map[string]float64
+
+func (r logitReranker) score(query string, passages []string) (scores []float64, err error) {
+	for _, p := range passages {
+		for path, logit := range r {
+			if strings.Contains(p, path) {
+				scores = append(scores, logit)
+			}
+		}
+	}
+	return
+}
+
+func (r logitReranker) spec() string { return "test:logits" }
+
+func TestPackReranked(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	var pool []*Chunk
+	for i, path := range []string{"a.md", "b.md", "c.md", "d.md"} {
+		pool = append(pool, &Chunk{
+			Document:    &Document{RelPath: path},
+			Text:        path,
+			Embedding:   []float64{1, float64(i), 0},
+			tokenLength: 10,
+		})
+	}
+	// the reranker prefers b.md, and its logits are below the
+	// similarities of the chunks it didn't rescore
+	grok.reranker = logitReranker{"a.md": -3, "b.md": -1}
+	grok.Pipeline.RerankK = 2
+	query := []float64{1, 0, 0}
+	chunks, err := grok.similarChunks("q", query, 2*(10+packOverhead), nil, pool)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(chunks) == 2 && chunks[0].Text == "b.md" && chunks[1].Text == "a.md", "expected the reranked chunks, got %v", chunks)
+	chunks, err = grok.similarChunks("q", query, 1000, nil, pool)
+	Tassert(t, err == nil, "error finding chunks: %v", err)
+	Tassert(t, len(chunks) == 2, "expected only the reranked chunks, got %v", chunks)
+}
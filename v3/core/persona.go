@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Persona is a named preset for the system message, temperature, and
+// output format of completions, e.g. a security reviewer or a tech
+// writer.  Personas are defined under "personas:" in the user
+// config file:
+//
+//	personas:
+//	  security:
+//	    sysmsg: You are a security reviewer...
+//	    temperature: 0.2
+//	    format: bullets
+type Persona struct {
+	// Sysmsg replaces the system message of the command, if set.
+	Sysmsg string `yaml:"sysmsg"`
+	// Temperature is the sampling temperature; if zero, the
+	// provider default is used.
+	Temperature float32 `yaml:"temperature"`
+	// Format is the name of one of the outputFormats, or free-form
+	// formatting instructions to append to the system message.
+	Format string `yaml:"format"`
+}
+
+// outputFormats are the output format presets for Persona.Format.
+var outputFormats = map[string]string{
+	"markdown": "Format your answer as markdown.",
+	"plain":    "Answer in plain text without markdown formatting.",
+	"bullets":  "Answer with a concise markdown bullet list, most important points first.",
+	"json":     "Answer with a single JSON object and nothing else.",
+}
+
+// builtinPersonas can be overridden in the config file.
+var builtinPersonas = map[string]*Persona{
+	"security": {
+		Sysmsg: "You are an experienced application security reviewer.  Look for vulnerabilities such as injection, broken authentication or authorization, unsafe handling of secrets, and insecure defaults.  Cite the file and line for each finding, rate its severity, and suggest a fix.",
+		Format: "bullets",
+	},
+	"techwriter": {
+		Sysmsg: "You are a technical writer.  Explain clearly and accurately for a reader who is new to the code, define jargon on first use, and prefer short sentences and concrete examples.",
+		Format: "markdown",
+	},
+	"sre": {
+		Sysmsg: "You are a site reliability engineer.  Focus on operability: failure modes, timeouts and retries, resource limits, observability, and what an on-call engineer would need to know.  Give concrete commands and settings where they help.",
+		Format: "bullets",
+	},
+}
+
+// Personas returns the built-in personas merged with those in the
+// user config file.
+func Personas() (personas map[string]*Persona, err error) {
+	defer Return(&err)
+	cfg, err := LoadConfig()
+	Ck(err)
+	personas = make(map[string]*Persona)
+	for name, p := range builtinPersonas {
+		personas[name] = p
+	}
+	for name, p := range cfg.Personas {
+		Assert(p != nil, "persona %q is empty", name)
+		personas[name] = p
+	}
+	return
+}
+
+// PersonaNames returns the sorted names of the available personas.
+func PersonaNames() (names []string, err error) {
+	personas, err := Personas()
+	if err != nil {
+		return
+	}
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// SetPersona selects the persona used by subsequent completions.
+// Pass an empty name to go back to the defaults.  The persona is not
+// stored in the database.
+func (g *Grokker) SetPersona(name string) (err error) {
+	defer Return(&err)
+	if name == "" {
+		g.persona = nil
+		return
+	}
+	personas, err := Personas()
+	Ck(err)
+	p, ok := personas[name]
+	if !ok {
+		names, _ := PersonaNames()
+		err = fmt.Errorf("unknown persona %q; available: %s", name, strings.Join(names, ", "))
+		return
+	}
+	g.persona = p
+	return
+}
+
+// Sysmsg returns the system message to send in place of sysmsg,
+// after applying the persona, profile, and answer language, if any.
+// Answer applies it to its own system message; callers that send
+// their own, like aidda, should apply it too.
+func (g *Grokker) Sysmsg(sysmsg string) string {
+	if p := g.persona; p != nil {
+		if p.Sysmsg != "" {
+			sysmsg = p.Sysmsg
+		}
+		format := p.Format
+		if preset, ok := outputFormats[format]; ok {
+			format = preset
+		}
+		if format != "" {
+			sysmsg = strings.TrimSpace(sysmsg) + "\n\n" + format
+		}
+	}
+	if p := g.profile; p != nil && p.Instructions != "" {
+		sysmsg = strings.TrimSpace(sysmsg) + "\n\n" + p.Instructions
+	}
+	return g.withLang(sysmsg)
+}
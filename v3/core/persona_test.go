@@ -0,0 +1,45 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPersona(t *testing.T) {
+	dir := TmpTestDir()
+	fn := filepath.Join(dir, "config.yaml")
+	cfg := "personas:\n  security:\n    sysmsg: Find bugs.\n    temperature: 0.2\n    format: Reply in haiku.\n  terse:\n    format: plain\n"
+	err := os.WriteFile(fn, []byte(cfg), 0644)
+	Tassert(t, err == nil, "error writing config: %v", err)
+	t.Setenv("GROKKER_CONFIG", fn)
+
+	names, err := PersonaNames()
+	Tassert(t, err == nil, "error listing personas: %v", err)
+	Tassert(t, strings.Join(names, ",") == "security,sre,techwriter,terse", "got %v", names)
+
+	g := &Grokker{}
+	Tassert(t, g.Sysmsg("base") == "base", "sysmsg changed without a persona")
+	// the config overrides the built-in persona
+	err = g.SetPersona("security")
+	Tassert(t, err == nil, "error setting persona: %v", err)
+	Tassert(t, g.Sysmsg("base") == "Find bugs.\n\nReply in haiku.", "got %q", g.Sysmsg("base"))
+	Tassert(t, g.persona.Temperature == 0.2, "got temperature %v", g.persona.Temperature)
+	// a persona without a sysmsg keeps the command's sysmsg
+	err = g.SetPersona("terse")
+	Tassert(t, err == nil, "error setting persona: %v", err)
+	Tassert(t, g.Sysmsg("base") == "base\n\n"+outputFormats["plain"], "got %q", g.Sysmsg("base"))
+
+	err = g.SetPersona("nope")
+	Tassert(t, err != nil, "expected error for unknown persona")
+	err = g.SetPersona("")
+	Tassert(t, err == nil && g.persona == nil, "expected persona to be cleared")
+
+	err = os.WriteFile(fn, []byte("personas: [\n"), 0644)
+	Tassert(t, err == nil, "error writing config: %v", err)
+	_, err = LoadConfig()
+	Tassert(t, err != nil, "expected error for bad config")
+}
@@ -0,0 +1,309 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Pipeline holds the retrieval settings for a database.  The
+// settings are stored in the database, so they apply to every query
+// against it; see 'grok pipeline'.
+type Pipeline struct {
+	// Prefilter is an embedder spec, e.g. "onnx:/models/minilm",
+	// used for a cheap first-stage search over all chunks.  Each
+	// chunk stores an extra embedding from this embedder.  The
+	// PrefilterK best matches are then ranked with the primary
+	// embeddings.  Empty disables the first stage.
+	Prefilter string
+	// PrefilterK is the number of first-stage candidates passed to
+	// the second stage.
+	PrefilterK int
+	// Reranker is a cross-encoder spec, e.g.
+	// "onnx:/models/ms-marco-MiniLM-L-6-v2", used to rescore the
+	// RerankK best matches against the query text.  Cross-encoders
+	// read the query and chunk together, so they rank more
+	// precisely than embedding similarity.  Empty disables
+	// reranking.
+	Reranker string
+	// RerankK is the number of candidates the reranker rescores;
+	// only those are packed into the context.
+	RerankK int
+	// Router picks which collections to search when a query
+	// doesn't name any: "embedding" compares the query to the mean
+	// embedding of each collection, "llm" asks the chat model.
+	// Empty searches all collections.
+	Router string
+	// Summarizer, if set, replaces retrieved chunks from short
+	// documents with a summary of the whole document: "llm" asks
+	// the chat model, "cmd:<command>" runs a command with the
+	// document on stdin.  See summarizer.go.
+	Summarizer string
+	// SummaryMaxTokens is the longest document, in tokens, that
+	// the summarizer summarizes.
+	SummaryMaxTokens int
+	// DedupThreshold is the embedding similarity at or above
+	// which a retrieved chunk is dropped as a near-duplicate of a
+	// better one; see dedup.go.  Set it above 1 to keep
+	// duplicates.
+	DedupThreshold float64
+	// MinSources is the number of distinct documents the context
+	// draws from, when that many match, so one long document
+	// can't fill the context; see diversity.go.  0 or 1 ranks
+	// chunks by score alone.
+	MinSources int
+	// Decompose splits compound questions into sub-questions,
+	// answers each from its own context, and combines the
+	// answers; see decompose.go.
+	Decompose bool
+	// NoCorrect turns off correcting misspelled symbols in queries
+	// before retrieval; see correct.go.
+	NoCorrect bool
+	// PostProcess lists the post-processors to run on completions,
+	// in order; see postprocess.go.
+	PostProcess []string
+	// LinkFormat is the URL template for the links post-processor,
+	// e.g. "vscode://file{abs}:{line}" or
+	// "https://github.com/org/repo/blob/main/{path}#L{line}".
+	LinkFormat string
+	// Rewrites are sed-style substitutions, "s/regexp/replacement/",
+	// for the rewrite post-processor.
+	Rewrites []string
+	// ContextPlacement is where the context goes in the messages:
+	// "user", "system", or "question"; empty means "user".
+	// ContextMessages is "single" or "chunk", for one message per
+	// chunk; empty means "single".  CitationMarkers numbers the
+	// chunks so answers can cite them.  See messages.go.
+	ContextPlacement string
+	ContextMessages  string
+	CitationMarkers  bool
+	// Harden sanitizes the context and fences it off as quoted
+	// data.  InjectionCheck is "flag" to report context that
+	// reads like instructions to the model, or "drop" to also
+	// leave it out; empty turns the detector off.  See
+	// injection.go.
+	Harden         bool
+	InjectionCheck string
+	// Verify checks the claims in every answer against the
+	// sources, as SetCheckAnswers does; see claims.go.
+	Verify bool
+	// NoOwners leaves the owners and authors of the sources out of
+	// the context, and stops finding authors with git blame when
+	// documents are indexed; see owners.go.
+	NoOwners bool
+}
+
+// defaultPrefilterK is used when PrefilterK is not set.
+const defaultPrefilterK = 200
+
+// defaultRerankK is used when RerankK is not set.
+const defaultRerankK = 100
+
+// PipelineSettings returns the pipeline settings as name, value
+// pairs in field order.
+func (g *Grokker) PipelineSettings() (settings [][2]string) {
+	v := reflect.ValueOf(g.Pipeline)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.ToLower(t.Field(i).Name)
+		value := Spf("%v", v.Field(i).Interface())
+		if v.Field(i).Kind() == reflect.Slice {
+			buf, err := json.Marshal(v.Field(i).Interface())
+			Ck(err)
+			value = string(buf)
+		}
+		settings = append(settings, [2]string{name, value})
+	}
+	return
+}
+
+// SetPipeline sets a pipeline setting by its case-insensitive name.
+// List settings take either a JSON array or a comma-separated list.
+func (g *Grokker) SetPipeline(name, value string) (err error) {
+	defer Return(&err)
+	v := reflect.ValueOf(&g.Pipeline).Elem()
+	f := v.FieldByNameFunc(func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+	if !f.IsValid() {
+		err = fmt.Errorf("unknown pipeline setting %q", name)
+		return
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int:
+		var n int
+		n, err = strconv.Atoi(value)
+		Ck(err)
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		Ck(err)
+		f.SetBool(b)
+	case reflect.Float64:
+		var x float64
+		x, err = strconv.ParseFloat(value, 64)
+		Ck(err)
+		f.SetFloat(x)
+	case reflect.Slice:
+		Assert(f.Type().Elem().Kind() == reflect.String, "unsupported pipeline setting type %s", f.Type())
+		var list []string
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(value), "["):
+			err = json.Unmarshal([]byte(value), &list)
+			Ck(err)
+		case value != "":
+			for _, item := range strings.Split(value, ",") {
+				list = append(list, strings.TrimSpace(item))
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		Assert(false, "unsupported pipeline setting type %s", f.Kind())
+	}
+	err = g.pipelineChanged()
+	Ck(err)
+	return
+}
+
+// pipelineChanged makes sure new pipeline settings are usable, and
+// loads the embedders and reranker they name.
+func (g *Grokker) pipelineChanged() (err error) {
+	defer Return(&err)
+	err = g.checkPostProcess()
+	Ck(err)
+	err = g.checkSummarizer()
+	Ck(err)
+	err = g.checkMessageShape()
+	Ck(err)
+	err = g.checkInjectionCheck()
+	Ck(err)
+	err = g.initVectors()
+	Ck(err)
+	err = g.updateVectors()
+	Ck(err)
+	return
+}
+
+// initVectors loads the embedders and reranker named in the
+// pipeline.
+func (g *Grokker) initVectors() (err error) {
+	defer Return(&err)
+	err = g.initReranker()
+	Ck(err)
+	spec := g.Pipeline.Prefilter
+	if spec == "" {
+		g.prefilter = nil
+		return
+	}
+	if g.prefilter != nil && g.prefilter.spec() == spec {
+		return
+	}
+	g.prefilter, err = newEmbedder(spec)
+	Ck(err)
+	return
+}
+
+// updateVectors creates the prefilter embeddings for any chunks
+// that don't have them yet, e.g. after the prefilter was enabled or
+// changed, or after a batch job completed, and drops those of any
+// prefilter no longer in use.
+func (g *Grokker) updateVectors() (err error) {
+	defer Return(&err)
+	if g.prefilter == nil {
+		g.dropVectors("")
+		return
+	}
+	spec := g.prefilter.spec()
+	g.dropVectors(spec)
+	var chunks []*Chunk
+	var texts []string
+	for _, chunk := range g.Chunks {
+		if chunk.stale || chunk.Excluded != "" || chunk.Vectors[spec] != nil {
+			continue
+		}
+		text, err := g.chunkText(chunk, true, false)
+		Ck(err)
+		chunks = append(chunks, chunk)
+		texts = append(texts, text)
+	}
+	if len(chunks) == 0 {
+		return
+	}
+	Debug("creating %d prefilter embeddings", len(chunks))
+	vecs, err := g.prefilter.embed(texts)
+	Ck(err)
+	for i, chunk := range chunks {
+		if chunk.Vectors == nil {
+			chunk.Vectors = make(map[string][]float64)
+		}
+		chunk.Vectors[spec] = vecs[i]
+	}
+	return
+}
+
+// dropVectors drops the prefilter embeddings of every embedder but
+// keep, unless the pipeline is only being tried out; see UsePipeline.
+func (g *Grokker) dropVectors(keep string) {
+	if g.pipelineFromDb != nil {
+		return
+	}
+	for _, chunk := range g.Chunks {
+		for k := range chunk.Vectors {
+			if k != keep {
+				delete(chunk.Vectors, k)
+			}
+		}
+		if len(chunk.Vectors) == 0 {
+			chunk.Vectors = nil
+		}
+	}
+}
+
+// prefilterChunks returns the candidates that pass the first search
+// stage for query.  It returns all candidates if there is no
+// prefilter.  Chunks that have no prefilter embedding yet are always
+// passed through.
+func (g *Grokker) prefilterChunks(queryStrings []string, candidates []*Chunk) (pool []*Chunk, err error) {
+	defer Return(&err)
+	if g.prefilter == nil {
+		return candidates, nil
+	}
+	spec := g.prefilter.spec()
+	vecs, err := g.prefilter.embed(queryStrings)
+	Ck(err)
+	query := util.MeanVector(vecs)
+	k := g.Pipeline.PrefilterK
+	if k <= 0 {
+		k = defaultPrefilterK
+	}
+	type sim struct {
+		chunk *Chunk
+		score float64
+	}
+	var sims []sim
+	for _, chunk := range candidates {
+		vec := chunk.Vectors[spec]
+		if vec == nil {
+			pool = append(pool, chunk)
+			continue
+		}
+		sims = append(sims, sim{chunk, util.Similarity(query, vec)})
+	}
+	sort.Slice(sims, func(i, j int) bool {
+		return sims[i].score > sims[j].score
+	})
+	for i := 0; i < len(sims) && i < k; i++ {
+		pool = append(pool, sims[i].chunk)
+	}
+	Debug("prefilter kept %d of %d chunks", len(pool), len(candidates))
+	return
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestDropVectors(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Length: 1, Hash: "h0", Vectors: map[string][]float64{"old:model": {1}}})
+
+	// vectors are kept while a pipeline is only being tried out
+	grok.pipelineFromDb = &Pipeline{}
+	err = grok.gc()
+	Tassert(t, err == nil, "error in gc: %v", err)
+	Tassert(t, grok.Chunks[0].Vectors != nil, "expected the vectors to be kept")
+
+	// with no prefilter, any left from an old one are dropped
+	grok.pipelineFromDb = nil
+	err = grok.gc()
+	Tassert(t, err == nil, "error in gc: %v", err)
+	Tassert(t, grok.Chunks[0].Vectors == nil, "expected the stale vectors to be dropped, got %v", grok.Chunks[0].Vectors)
+}
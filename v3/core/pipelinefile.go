@@ -0,0 +1,334 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"gopkg.in/yaml.v3"
+)
+
+// A pipeline file describes the whole retrieval and answer pipeline
+// in YAML, stage by stage, so a setup can be shared, reviewed, and
+// tried out by name instead of rebuilt with 'grok pipeline set':
+//
+//	# .grok-pipelines/precise.yaml
+//	description: Reranked, cited answers for API questions.
+//	chunker:
+//	  hooks:
+//	    - {pattern: "*.go", command: strip-license}
+//	expander:
+//	  decompose: true
+//	retriever:
+//	  prefilter: onnx:/models/minilm
+//	  prefilter_k: 300
+//	  router: embedding
+//	reranker:
+//	  model: onnx:/models/ms-marco-MiniLM-L-6-v2
+//	  k: 50
+//	packer:
+//	  dedup_threshold: 0.95
+//	  min_sources: 3
+//	  citation_markers: true
+//	  harden: true
+//	  injection_check: flag
+//	verifier:
+//	  check_claims: true
+//	postprocess:
+//	  steps: [links]
+//	  link_format: "vscode://file{abs}:{line}"
+//
+// A name is looked up as .grok-pipelines/<name>.yaml in the root of
+// the knowledge base, then as pipelines/<name>.yaml in ConfigDir(); a
+// name with a path separator or a .yaml or .yml extension is a path.
+// Settings left out of a file take their defaults rather than the
+// database's values, so a file always means the same pipeline.  The
+// chunker's hooks change what gets indexed, so they only apply when
+// the file is loaded into the database, and only if the file has a
+// chunker stage.
+
+// PipelineFile is the content of a pipeline file.
+type PipelineFile struct {
+	Description string            `yaml:"description,omitempty"`
+	Chunker     *ChunkerStage     `yaml:"chunker,omitempty"`
+	Expander    *ExpanderStage    `yaml:"expander,omitempty"`
+	Retriever   *RetrieverStage   `yaml:"retriever,omitempty"`
+	Reranker    *RerankerStage    `yaml:"reranker,omitempty"`
+	Packer      *PackerStage      `yaml:"packer,omitempty"`
+	Verifier    *VerifierStage    `yaml:"verifier,omitempty"`
+	PostProcess *PostProcessStage `yaml:"postprocess,omitempty"`
+}
+
+// ChunkerStage sets the index hooks; see hooks.go.
+type ChunkerStage struct {
+	Hooks []*Hook `yaml:"hooks"`
+}
+
+// ExpanderStage sets how questions are expanded before retrieval.
+type ExpanderStage struct {
+	Decompose bool `yaml:"decompose,omitempty"`
+	NoCorrect bool `yaml:"no_correct,omitempty"`
+}
+
+// RetrieverStage sets how candidate chunks are found.
+type RetrieverStage struct {
+	Prefilter  string `yaml:"prefilter,omitempty"`
+	PrefilterK int    `yaml:"prefilter_k,omitempty"`
+	Router     string `yaml:"router,omitempty"`
+}
+
+// RerankerStage sets the cross-encoder that rescores candidates.
+type RerankerStage struct {
+	Model string `yaml:"model,omitempty"`
+	K     int    `yaml:"k,omitempty"`
+}
+
+// PackerStage sets how the context is chosen from the ranked chunks
+// and laid out in the messages.
+type PackerStage struct {
+	DedupThreshold   float64 `yaml:"dedup_threshold,omitempty"`
+	MinSources       int     `yaml:"min_sources,omitempty"`
+	Summarizer       string  `yaml:"summarizer,omitempty"`
+	SummaryMaxTokens int     `yaml:"summary_max_tokens,omitempty"`
+	ContextPlacement string  `yaml:"context_placement,omitempty"`
+	ContextMessages  string  `yaml:"context_messages,omitempty"`
+	CitationMarkers  bool    `yaml:"citation_markers,omitempty"`
+	Harden           bool    `yaml:"harden,omitempty"`
+	InjectionCheck   string  `yaml:"injection_check,omitempty"`
+	NoOwners         bool    `yaml:"no_owners,omitempty"`
+}
+
+// VerifierStage sets how answers are checked.
+type VerifierStage struct {
+	CheckClaims bool `yaml:"check_claims,omitempty"`
+}
+
+// PostProcessStage sets the post-processors run on completions.
+type PostProcessStage struct {
+	Steps      []string `yaml:"steps,omitempty"`
+	LinkFormat string   `yaml:"link_format,omitempty"`
+	Rewrites   []string `yaml:"rewrites,omitempty"`
+}
+
+// PipelineFileInfo describes a pipeline file found by PipelineFiles.
+type PipelineFileInfo struct {
+	Name        string
+	Path        string
+	Description string
+}
+
+// pipelineDirs returns the directories pipeline files are looked up
+// in, in order.
+func (g *Grokker) pipelineDirs() (dirs []string) {
+	dirs = append(dirs, filepath.Join(g.Root, ".grok-pipelines"))
+	if dir := ConfigDir(); dir != "" {
+		dirs = append(dirs, filepath.Join(dir, "pipelines"))
+	}
+	return
+}
+
+// pipelineFilePath returns the path of the named pipeline file.
+func (g *Grokker) pipelineFilePath(name string) (path string, err error) {
+	ext := filepath.Ext(name)
+	if strings.ContainsAny(name, `/\`) || ext == ".yaml" || ext == ".yml" {
+		return name, nil
+	}
+	for _, dir := range g.pipelineDirs() {
+		path = filepath.Join(dir, name+".yaml")
+		if _, err = os.Stat(path); err == nil {
+			return
+		}
+	}
+	err = fmt.Errorf("no pipeline file named %q in %s", name, strings.Join(g.pipelineDirs(), " or "))
+	return
+}
+
+// ReadPipelineFile reads the named pipeline file.  Unknown settings
+// are errors, so typos don't go unnoticed.
+func (g *Grokker) ReadPipelineFile(name string) (pf *PipelineFile, err error) {
+	defer Return(&err)
+	path, err := g.pipelineFilePath(name)
+	Ck(err)
+	buf, err := os.ReadFile(path)
+	Ck(err)
+	pf = &PipelineFile{}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err = dec.Decode(pf)
+	if err != nil && err != io.EOF {
+		Ck(err, "%s", path)
+	}
+	err = nil
+	return
+}
+
+// PipelineFiles returns the pipeline files that can be used by name.
+// A file in the knowledge base hides one with the same name in the
+// config directory.  Files that can't be read are described by the
+// error.
+func (g *Grokker) PipelineFiles() (infos []PipelineFileInfo, err error) {
+	defer Return(&err)
+	seen := make(map[string]bool)
+	for _, dir := range g.pipelineDirs() {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		Ck(err)
+		for _, path := range paths {
+			name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			info := PipelineFileInfo{Name: name, Path: path}
+			pf, err := g.ReadPipelineFile(path)
+			if err != nil {
+				info.Description = Spf("invalid: %v", err)
+			} else {
+				info.Description = pf.Description
+			}
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return
+}
+
+// Pipeline returns the pipeline settings the file describes.
+func (pf *PipelineFile) Pipeline() (p Pipeline) {
+	if s := pf.Expander; s != nil {
+		p.Decompose = s.Decompose
+		p.NoCorrect = s.NoCorrect
+	}
+	if s := pf.Retriever; s != nil {
+		p.Prefilter = s.Prefilter
+		p.PrefilterK = s.PrefilterK
+		p.Router = s.Router
+	}
+	if s := pf.Reranker; s != nil {
+		p.Reranker = s.Model
+		p.RerankK = s.K
+	}
+	if s := pf.Packer; s != nil {
+		p.DedupThreshold = s.DedupThreshold
+		p.MinSources = s.MinSources
+		p.Summarizer = s.Summarizer
+		p.SummaryMaxTokens = s.SummaryMaxTokens
+		p.ContextPlacement = s.ContextPlacement
+		p.ContextMessages = s.ContextMessages
+		p.CitationMarkers = s.CitationMarkers
+		p.Harden = s.Harden
+		p.InjectionCheck = s.InjectionCheck
+		p.NoOwners = s.NoOwners
+	}
+	if s := pf.Verifier; s != nil {
+		p.Verify = s.CheckClaims
+	}
+	if s := pf.PostProcess; s != nil {
+		p.PostProcess = s.Steps
+		p.LinkFormat = s.LinkFormat
+		p.Rewrites = s.Rewrites
+	}
+	return
+}
+
+// ExportPipeline returns the database's pipeline settings and index
+// hooks as a pipeline file.
+func (g *Grokker) ExportPipeline() (buf []byte, err error) {
+	defer Return(&err)
+	p := g.Pipeline
+	if g.pipelineFromDb != nil {
+		p = *g.pipelineFromDb
+	}
+	pf := &PipelineFile{
+		Expander:  &ExpanderStage{Decompose: p.Decompose, NoCorrect: p.NoCorrect},
+		Retriever: &RetrieverStage{Prefilter: p.Prefilter, PrefilterK: p.PrefilterK, Router: p.Router},
+		Reranker:  &RerankerStage{Model: p.Reranker, K: p.RerankK},
+		Packer: &PackerStage{
+			DedupThreshold:   p.DedupThreshold,
+			MinSources:       p.MinSources,
+			Summarizer:       p.Summarizer,
+			SummaryMaxTokens: p.SummaryMaxTokens,
+			ContextPlacement: p.ContextPlacement,
+			ContextMessages:  p.ContextMessages,
+			CitationMarkers:  p.CitationMarkers,
+			Harden:           p.Harden,
+			InjectionCheck:   p.InjectionCheck,
+			NoOwners:         p.NoOwners,
+		},
+		Verifier:    &VerifierStage{CheckClaims: p.Verify},
+		PostProcess: &PostProcessStage{Steps: p.PostProcess, LinkFormat: p.LinkFormat, Rewrites: p.Rewrites},
+	}
+	if len(g.Hooks) > 0 {
+		pf.Chunker = &ChunkerStage{Hooks: g.Hooks}
+	}
+	// leave out the stages that use the defaults
+	v := reflect.ValueOf(pf).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() == reflect.Pointer && !f.IsNil() && f.Elem().IsZero() {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	err = enc.Encode(pf)
+	Ck(err)
+	buf = out.Bytes()
+	return
+}
+
+// UsePipeline tries out the named pipeline file for the rest of the
+// process without changing the database: Save keeps writing the
+// pipeline stored in it.  The chunker stage is ignored.
+func (g *Grokker) UsePipeline(name string) (err error) {
+	defer Return(&err)
+	pf, err := g.ReadPipelineFile(name)
+	Ck(err)
+	prev, prevFromDb := g.Pipeline, g.pipelineFromDb
+	if g.pipelineFromDb == nil {
+		stored := g.Pipeline
+		g.pipelineFromDb = &stored
+	}
+	g.Pipeline = pf.Pipeline()
+	err = g.pipelineChanged()
+	if err != nil {
+		g.Pipeline, g.pipelineFromDb = prev, prevFromDb
+		err = fmt.Errorf("pipeline %s: %v", name, err)
+	}
+	return
+}
+
+// LoadPipeline replaces the database's pipeline settings with those
+// of the named pipeline file, and its index hooks with the file's
+// chunker hooks, if it has a chunker stage.  It returns true if the
+// hooks changed, which takes a 'grok refresh' to apply.
+func (g *Grokker) LoadPipeline(name string) (hooksChanged bool, err error) {
+	defer Return(&err)
+	pf, err := g.ReadPipelineFile(name)
+	Ck(err)
+	if pf.Chunker != nil {
+		for _, h := range pf.Chunker.Hooks {
+			if h == nil || h.Pattern == "" || h.Command == "" {
+				err = fmt.Errorf("pipeline %s: a hook needs a pattern and a command", name)
+				return
+			}
+		}
+		old, err := yaml.Marshal(g.Hooks)
+		Ck(err)
+		hooks, err := yaml.Marshal(pf.Chunker.Hooks)
+		Ck(err)
+		hooksChanged = !bytes.Equal(old, hooks)
+		g.Hooks = pf.Chunker.Hooks
+	}
+	g.Pipeline = pf.Pipeline()
+	g.pipelineFromDb = nil
+	err = g.pipelineChanged()
+	Ck(err, "pipeline %s", name)
+	return
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPipelineFile(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "config"))
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	pdir := filepath.Join(dir, ".grok-pipelines")
+	err = os.MkdirAll(pdir, 0755)
+	Ck(err)
+	precise := "description: Cited answers.\nchunker:\n  hooks:\n    - {pattern: \"*.go\", command: strip-license}\n" +
+		"expander:\n  decompose: true\npacker:\n  min_sources: 3\n  citation_markers: true\nverifier:\n  check_claims: true\n"
+	err = os.WriteFile(filepath.Join(pdir, "precise.yaml"), []byte(precise), 0644)
+	Ck(err)
+	err = os.WriteFile(filepath.Join(pdir, "typo.yaml"), []byte("packer:\n  min_source: 3\n"), 0644)
+	Ck(err)
+	err = os.WriteFile(filepath.Join(pdir, "bad.yaml"), []byte("packer:\n  context_messages: every\n"), 0644)
+	Ck(err)
+
+	_, err = grok.ReadPipelineFile("typo")
+	Tassert(t, err != nil && strings.Contains(err.Error(), "min_source"), "expected error for unknown setting, got %v", err)
+	_, err = grok.ReadPipelineFile("nope")
+	Tassert(t, err != nil, "expected error for missing file")
+	err = grok.UsePipeline("bad")
+	Tassert(t, err != nil, "expected error for bad setting value")
+
+	// trying a pipeline doesn't change what's saved
+	grok.Pipeline = Pipeline{MinSources: 2}
+	err = grok.UsePipeline("precise")
+	Tassert(t, err == nil, "error using pipeline: %v", err)
+	Tassert(t, grok.Pipeline.MinSources == 3 && grok.Pipeline.Decompose && grok.Pipeline.Verify, "got %+v", grok.Pipeline)
+	Tassert(t, len(grok.Hooks) == 0, "expected hooks unchanged, got %v", grok.Hooks)
+	err = grok.Save()
+	Tassert(t, err == nil, "error saving: %v", err)
+	Tassert(t, grok.Pipeline.MinSources == 3, "expected pipeline kept after save, got %+v", grok.Pipeline)
+	g2, _, _, _, lock, err := LoadFrom(filepath.Join(dir, ".grok"), "", true)
+	Tassert(t, err == nil, "error loading: %v", err)
+	lock.Unlock()
+	Tassert(t, g2.Pipeline.MinSources == 2 && !g2.Pipeline.Decompose, "expected stored pipeline, got %+v", g2.Pipeline)
+
+	// loading it does
+	changed, err := grok.LoadPipeline("precise")
+	Tassert(t, err == nil && changed, "error loading pipeline: %v, %v", changed, err)
+	Tassert(t, len(grok.Hooks) == 1 && grok.Hooks[0].Command == "strip-license", "got hooks %v", grok.Hooks)
+	changed, err = grok.LoadPipeline(filepath.Join(pdir, "precise.yaml"))
+	Tassert(t, err == nil && !changed, "expected hooks unchanged: %v", err)
+
+	// export round-trips
+	buf, err := grok.ExportPipeline()
+	Tassert(t, err == nil, "error exporting: %v", err)
+	fn := filepath.Join(dir, "exported.yaml")
+	err = os.WriteFile(fn, buf, 0644)
+	Ck(err)
+	pf, err := grok.ReadPipelineFile(fn)
+	Tassert(t, err == nil, "error reading export: %v\n%s", err, buf)
+	Tassert(t, reflect.DeepEqual(pf.Pipeline(), grok.Pipeline), "expected %+v, got %+v", grok.Pipeline, pf.Pipeline())
+
+	infos, err := grok.PipelineFiles()
+	Tassert(t, err == nil, "error listing: %v", err)
+	Tassert(t, len(infos) == 3 && infos[1].Name == "precise" && infos[1].Description == "Cited answers.", "got %v", infos)
+	Tassert(t, strings.HasPrefix(infos[2].Description, "invalid: "), "expected typo.yaml to be invalid, got %v", infos[2])
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Plugins let third parties extend grokker without changing it.  A
+// plugin is an executable on PATH whose name says what it does, as
+// with git's subcommands:
+//
+//   - grok-<name> adds the subcommand 'grok <name>'.  It is run with
+//     the remaining arguments and grok's stdin, stdout, and stderr,
+//     and grok exits with its exit status.  GROKKER_DB is set to the
+//     .grok file grok would use, if there is one, and GROKKER_BIN to
+//     the grok executable, so the plugin can run grok itself.
+//   - grok-load-<ext> is the loader for documents with the extension
+//     .<ext>, e.g. grok-load-pdf for PDFs.  Like an index hook, it
+//     runs in the root of the tree with GROKKER_DOCUMENT set to the
+//     document's path, reads the document on stdin, and writes the
+//     text to index on stdout.  It takes the place of any built-in
+//     loader for the extension, and index hooks take the place of
+//     it.  Loaders are looked up once per refresh.
+//   - grok-store-<name> is a store for the chunks, e.g. in a vector
+//     database, used by 'grok store <name>'; see store_plugin.go for
+//     its protocol.
+//
+// Unlike hooks, plugins aren't recorded in the db, so everyone who
+// refreshes a shared db should install the same loaders; a db whose
+// chunks are in a store plugin records the plugin's name, and can't
+// be loaded without it.  Built-in subcommands and stores can't be
+// replaced.
+
+const (
+	pluginPrefix       = "grok-"
+	loaderPluginPrefix = "grok-load-"
+	storePluginPrefix  = "grok-store-"
+)
+
+// Plugin is a plugin found on PATH.
+type Plugin struct {
+	// Name is the subcommand, or for a loader, the extension it
+	// loads.
+	Name string
+	// Kind is "command", "loader", or "store".
+	Kind string
+	Path string
+}
+
+// Plugins returns the plugins on PATH, sorted by kind and name.  A
+// plugin shadowed by one earlier on PATH isn't returned.
+func Plugins() (plugins []*Plugin) {
+	seen := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, pluginPrefix) || !isExecutable(dir, entry) {
+				continue
+			}
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			p := &Plugin{Kind: "command", Name: strings.TrimPrefix(name, pluginPrefix), Path: filepath.Join(dir, entry.Name())}
+			switch {
+			case strings.HasPrefix(name, loaderPluginPrefix):
+				p.Kind = "loader"
+				p.Name = strings.TrimPrefix(name, loaderPluginPrefix)
+			case strings.HasPrefix(name, storePluginPrefix):
+				p.Kind = "store"
+				p.Name = strings.TrimPrefix(name, storePluginPrefix)
+			}
+			plugins = append(plugins, p)
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		if plugins[i].Kind != plugins[j].Kind {
+			return plugins[i].Kind < plugins[j].Kind
+		}
+		return plugins[i].Name < plugins[j].Name
+	})
+	return
+}
+
+// isExecutable returns true if the directory entry is a file that can
+// be run.
+func isExecutable(dir string, entry os.DirEntry) bool {
+	info, err := os.Stat(filepath.Join(dir, entry.Name()))
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		_, err = exec.LookPath(filepath.Join(dir, entry.Name()))
+		return err == nil
+	}
+	return info.Mode()&0111 != 0
+}
+
+// FindPlugin returns the path of the subcommand plugin with the given
+// name, or false if there is none on PATH.
+func FindPlugin(name string) (path string, ok bool) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	return path, err == nil
+}
+
+// PluginCommand returns the command that runs a subcommand plugin
+// with the given arguments.
+func PluginCommand(path string, args []string) *exec.Cmd {
+	cmd := exec.Command(path, args...)
+	cmd.Env = os.Environ()
+	if grokpath, err := FindDB(""); err == nil {
+		if abs, err := filepath.Abs(grokpath); err == nil {
+			cmd.Env = append(cmd.Env, "GROKKER_DB="+abs)
+		}
+	}
+	if bin, err := os.Executable(); err == nil {
+		cmd.Env = append(cmd.Env, "GROKKER_BIN="+bin)
+	}
+	return cmd
+}
+
+// findStorePlugin returns the path of the store plugin with the
+// given name, or false if there is none on PATH.
+func findStorePlugin(name string) (path string, ok bool) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return
+	}
+	path, err := exec.LookPath(storePluginPrefix + name)
+	return path, err == nil
+}
+
+// loaderPlugin returns the path of the loader plugin for relpath, or
+// "" if there is none.  The paths are looked up once per refresh.
+func (g *Grokker) loaderPlugin(relpath string) string {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(relpath)), ".")
+	if ext == "" || strings.ContainsAny(ext, `/\`) {
+		return ""
+	}
+	if path, ok := g.loaderPaths[ext]; ok {
+		return path
+	}
+	path, err := exec.LookPath(loaderPluginPrefix + ext)
+	if err != nil {
+		path = ""
+	}
+	if g.loaderPaths == nil {
+		g.loaderPaths = make(map[string]string)
+	}
+	g.loaderPaths[ext] = path
+	return path
+}
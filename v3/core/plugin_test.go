@@ -0,0 +1,66 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses shell scripts")
+	}
+	bin := TmpTestDir()
+	for name, script := range map[string]string{
+		"grok-hello":    "#!/bin/sh\necho hello \"$@\"\n",
+		"grok-load-xyz": "#!/bin/sh\ntr a-z A-Z\n",
+	} {
+		err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755)
+		Ck(err)
+	}
+	// not executable, so not a plugin
+	err := os.WriteFile(filepath.Join(bin, "grok-notes.txt"), []byte("notes"), 0644)
+	Ck(err)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(bin, "config"))
+
+	plugins := Plugins()
+	found := make(map[string]string)
+	for _, p := range plugins {
+		if filepath.Dir(p.Path) == bin {
+			found[p.Name] = p.Kind
+		}
+	}
+	Tassert(t, len(found) == 2 && found["hello"] == "command" && found["xyz"] == "loader", "unexpected plugins %v", found)
+
+	path, ok := FindPlugin("hello")
+	Tassert(t, ok && path == filepath.Join(bin, "grok-hello"), "expected to find grok-hello, got %q", path)
+	_, ok = FindPlugin("../hello")
+	Tassert(t, !ok, "expected no plugin for a path")
+	out, err := PluginCommand(path, []string{"world"}).Output()
+	Tassert(t, err == nil && string(out) == "hello world\n", "unexpected output %q: %v", out, err)
+
+	// a loader plugin renders documents with its extension
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "a.xyz"), []byte("shout\n"), 0644)
+	Ck(err)
+	doc := &Document{RelPath: "a.xyz"}
+	chunks, err := grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	Tassert(t, doc.Transformed && len(chunks) > 0, "expected a.xyz to be transformed")
+	text, err := grok.chunkText(chunks[0], false, false)
+	Tassert(t, err == nil && text == "SHOUT\n", "unexpected chunk text %q: %v", text, err)
+
+	// hooks take the place of loader plugins
+	err = grok.AddHook("*.xyz", "cat")
+	Ck(err)
+	chunks, err = grok.chunksFromDoc(doc)
+	Tassert(t, err == nil, "error chunking: %v", err)
+	text, err = grok.chunkText(chunks[0], false, false)
+	Tassert(t, err == nil && text == "shout\n", "unexpected chunk text %q: %v", text, err)
+}
@@ -0,0 +1,677 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a set of guardrails that are checked before anything is
+// sent to a remote API: chat completions, embeddings, and batch
+// uploads.  It is set under "policy:" in the user config file:
+//
+//	policy:
+//	  max_bytes: 200000
+//	  max_tokens: 16000
+//	  banned_paths: ["secrets/", "*.pem", ".env"]
+//	  redact: ['AKIA[0-9A-Z]{16}', '(?i)password\s*=\s*\S+']
+//	  pii: [email, phone]
+//	  on_violation: prompt
+//	  providers:
+//	    openrouter: {max_tokens: 4000, max_response_tokens: 1000, pii: [ssn]}
+//	  sensitivity: {hr: confidential, legal: confidential}
+//	  allow_providers: {confidential: [ollama, onnx]}
+//
+// The same rules can also be kept with the documents, in a
+// .grok-policy.yaml file in the root of the knowledge base, without
+// the "policy:" key.  When both are present the stricter setting of
+// each rule applies; see merge.
+//
+// Violations, redactions, and prompt decisions are appended to the
+// policy log, a JSON-lines file next to the database; see
+// policyLogPath and 'grok policy log'.
+type Policy struct {
+	// MaxBytes limits the size of a single request, after
+	// redaction, and MaxTokens its size in tokens.  Zero means no
+	// limit.
+	MaxBytes  int `yaml:"max_bytes"`
+	MaxTokens int `yaml:"max_tokens"`
+	// MaxResponseTokens caps the tokens a chat completion may
+	// return.
+	MaxResponseTokens int `yaml:"max_response_tokens"`
+	// BannedPaths are patterns for files that must not be added
+	// to the knowledge base, used as context, or included in a
+	// prompt.  A pattern ending in "/" matches a directory
+	// anywhere in the path; other patterns are matched with
+	// path.Match against the whole slash-separated relative path
+	// and against the file name.
+	BannedPaths []string `yaml:"banned_paths"`
+	// Redact are regular expressions whose matches are replaced
+	// with redactedText in every request.
+	Redact []string `yaml:"redact"`
+	// PII names built-in detectors, from piiPatterns, whose matches
+	// are redacted like those of Redact.
+	PII []string `yaml:"pii"`
+	// Providers sets stricter limits and more redactions for the
+	// requests to a provider, e.g. "openai" or "openrouter".
+	Providers map[string]*ProviderPolicy `yaml:"providers"`
+	// Sensitivity tags collections, e.g. hr: confidential, and
+	// AllowProviders lists the providers that may receive the text
+	// of the documents with a tag, for embedding or as context.  A
+	// tag without a list may go to any provider.
+	Sensitivity    map[string]string   `yaml:"sensitivity"`
+	AllowProviders map[string][]string `yaml:"allow_providers"`
+	// OnViolation is "block" (the default), which fails the
+	// request, or "prompt", which asks on the terminal whether to
+	// go ahead.
+	OnViolation string `yaml:"on_violation"`
+	// Audit is "off" (the default), "truncated", or "full", and
+	// says how much of each request and response to keep in the
+	// audit log; see audit.go.
+	Audit string `yaml:"audit"`
+	// AuditChain links each audit log entry to the previous one
+	// with a hash.
+	AuditChain bool `yaml:"audit_chain"`
+
+	redactRes []*regexp.Regexp
+}
+
+// ProviderPolicy is the part of a policy that applies to the requests
+// to one provider, on top of the rules for all providers.
+type ProviderPolicy struct {
+	MaxBytes          int      `yaml:"max_bytes"`
+	MaxTokens         int      `yaml:"max_tokens"`
+	MaxResponseTokens int      `yaml:"max_response_tokens"`
+	Redact            []string `yaml:"redact"`
+	PII               []string `yaml:"pii"`
+
+	redactRes []*regexp.Regexp
+}
+
+// policyFile is the name of the policy file in the root of the
+// knowledge base.
+const policyFile = ".grok-policy.yaml"
+
+// redactedText replaces each match of Policy.Redact.
+const redactedText = "[REDACTED]"
+
+// piiPatterns are the detectors Policy.PII can name.  They aim at
+// the common formats and will miss some and catch look-alikes, e.g.
+// a version string that reads as an IP address.
+var piiPatterns = map[string]string{
+	"email":       `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"phone":       `(\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`,
+	"ssn":         `\b\d{3}-\d{2}-\d{4}\b`,
+	"credit_card": `\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{1,4}\b`,
+	"ip":          `\b(\d{1,3}\.){3}\d{1,3}\b`,
+	"aws_key":     `\bAKIA[0-9A-Z]{16}\b`,
+}
+
+// compileRedactions compiles the redact patterns and the named PII
+// detectors.
+func compileRedactions(redact, pii []string) (res []*regexp.Regexp, err error) {
+	defer Return(&err)
+	for _, pat := range redact {
+		re, err := regexp.Compile(pat)
+		Ck(err, "redact: %q", pat)
+		res = append(res, re)
+	}
+	for _, name := range pii {
+		pat, ok := piiPatterns[name]
+		if !ok {
+			err = fmt.Errorf("pii: unknown detector %q; expected one of %v", name, piiNames())
+			return
+		}
+		res = append(res, regexp.MustCompile(pat))
+	}
+	return
+}
+
+// piiNames returns the names of the PII detectors, sorted.
+func piiNames() (names []string) {
+	for name := range piiPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// compile validates the policy and compiles its patterns.
+func (p *Policy) compile() (err error) {
+	defer Return(&err)
+	switch p.OnViolation {
+	case "", "block", "prompt":
+	default:
+		err = fmt.Errorf("policy: on_violation must be block or prompt, not %q", p.OnViolation)
+		return
+	}
+	switch p.Audit {
+	case "", "off", "truncated", "full":
+	default:
+		err = fmt.Errorf("policy: audit must be off, truncated, or full, not %q", p.Audit)
+		return
+	}
+	for _, pat := range p.BannedPaths {
+		_, err = pathpkg.Match(strings.TrimSuffix(pat, "/"), "")
+		Ck(err, "policy: banned_paths: %q", pat)
+	}
+	p.redactRes, err = compileRedactions(p.Redact, p.PII)
+	Ck(err, "policy")
+	for name, pp := range p.Providers {
+		if pp == nil {
+			pp = &ProviderPolicy{}
+			p.Providers[name] = pp
+		}
+		pp.redactRes, err = compileRedactions(pp.Redact, pp.PII)
+		Ck(err, "policy: providers: %s", name)
+	}
+	return
+}
+
+// minLimit returns the stricter of two limits, where zero means no
+// limit.
+func minLimit(a, b int) int {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// limits returns the request limits for provider, the stricter of
+// the policy's and the provider's.
+func (p *Policy) limits(provider string) (maxBytes, maxTokens, maxResponseTokens int) {
+	maxBytes, maxTokens, maxResponseTokens = p.MaxBytes, p.MaxTokens, p.MaxResponseTokens
+	if pp := p.Providers[provider]; pp != nil {
+		maxBytes = minLimit(maxBytes, pp.MaxBytes)
+		maxTokens = minLimit(maxTokens, pp.MaxTokens)
+		maxResponseTokens = minLimit(maxResponseTokens, pp.MaxResponseTokens)
+	}
+	return
+}
+
+// merge adds the rules of q, from the policy file, to p, keeping the
+// stricter of the two where both set a rule: the lower limit, the
+// union of the bans and redactions, the intersection of the allowed
+// providers, block over prompt, and the fuller audit.  A collection
+// has one sensitivity tag, and the file's wins.
+func (p *Policy) merge(q *Policy) {
+	p.MaxBytes = minLimit(p.MaxBytes, q.MaxBytes)
+	p.MaxTokens = minLimit(p.MaxTokens, q.MaxTokens)
+	p.MaxResponseTokens = minLimit(p.MaxResponseTokens, q.MaxResponseTokens)
+	p.BannedPaths = append(p.BannedPaths, q.BannedPaths...)
+	p.Redact = append(p.Redact, q.Redact...)
+	p.PII = append(p.PII, q.PII...)
+	for name, qp := range q.Providers {
+		if p.Providers == nil {
+			p.Providers = make(map[string]*ProviderPolicy)
+		}
+		pp := p.Providers[name]
+		if pp == nil {
+			pp = &ProviderPolicy{}
+			p.Providers[name] = pp
+		}
+		if qp == nil {
+			continue
+		}
+		pp.MaxBytes = minLimit(pp.MaxBytes, qp.MaxBytes)
+		pp.MaxTokens = minLimit(pp.MaxTokens, qp.MaxTokens)
+		pp.MaxResponseTokens = minLimit(pp.MaxResponseTokens, qp.MaxResponseTokens)
+		pp.Redact = append(pp.Redact, qp.Redact...)
+		pp.PII = append(pp.PII, qp.PII...)
+	}
+	for coll, tag := range q.Sensitivity {
+		if p.Sensitivity == nil {
+			p.Sensitivity = make(map[string]string)
+		}
+		p.Sensitivity[coll] = tag
+	}
+	for tag, qa := range q.AllowProviders {
+		if p.AllowProviders == nil {
+			p.AllowProviders = make(map[string][]string)
+		}
+		pa, ok := p.AllowProviders[tag]
+		if !ok {
+			p.AllowProviders[tag] = qa
+			continue
+		}
+		both := []string{}
+		for _, provider := range pa {
+			if util.StringInSlice(provider, qa) {
+				both = append(both, provider)
+			}
+		}
+		p.AllowProviders[tag] = both
+	}
+	if p.OnViolation == "" || q.OnViolation == "block" {
+		p.OnViolation = q.OnViolation
+	}
+	audits := []string{"", "off", "truncated", "full"}
+	if indexOf(audits, q.Audit) > indexOf(audits, p.Audit) {
+		p.Audit = q.Audit
+	}
+	p.AuditChain = p.AuditChain || q.AuditChain
+}
+
+// indexOf returns the index of s in list, or -1.
+func indexOf(list []string, s string) int {
+	for i, x := range list {
+		if x == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// sensitivity returns the sensitivity tag of a document, from its
+// collection, or an empty string.
+func (p *Policy) sensitivity(doc *Document) string {
+	return p.Sensitivity[doc.collection()]
+}
+
+// allows returns whether provider may receive text with the
+// sensitivity tag.
+func (p *Policy) allows(tag, provider string) bool {
+	allowed, ok := p.AllowProviders[tag]
+	return tag == "" || !ok || util.StringInSlice(provider, allowed)
+}
+
+// Rules returns the policy's rules, one per line, for 'grok policy
+// show'.
+func (p *Policy) Rules() (rules []string) {
+	add := func(format string, args ...interface{}) {
+		rules = append(rules, Spf(format, args...))
+	}
+	limits := func(prefix string, maxBytes, maxTokens, maxResponseTokens int) {
+		if maxBytes > 0 {
+			add("%smax_bytes: %d", prefix, maxBytes)
+		}
+		if maxTokens > 0 {
+			add("%smax_tokens: %d", prefix, maxTokens)
+		}
+		if maxResponseTokens > 0 {
+			add("%smax_response_tokens: %d", prefix, maxResponseTokens)
+		}
+	}
+	limits("", p.MaxBytes, p.MaxTokens, p.MaxResponseTokens)
+	for _, pat := range p.BannedPaths {
+		add("banned_paths: %q", pat)
+	}
+	for _, pat := range p.Redact {
+		add("redact: %q", pat)
+	}
+	for _, name := range p.PII {
+		add("pii: %s", name)
+	}
+	var names []string
+	for name := range p.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pp := p.Providers[name]
+		prefix := Spf("providers: %s: ", name)
+		limits(prefix, pp.MaxBytes, pp.MaxTokens, pp.MaxResponseTokens)
+		for _, pat := range pp.Redact {
+			add("%sredact: %q", prefix, pat)
+		}
+		for _, name := range pp.PII {
+			add("%spii: %s", prefix, name)
+		}
+	}
+	var colls []string
+	for coll := range p.Sensitivity {
+		colls = append(colls, coll)
+	}
+	sort.Strings(colls)
+	for _, coll := range colls {
+		add("sensitivity: %s: %s", coll, p.Sensitivity[coll])
+	}
+	var tags []string
+	for tag := range p.AllowProviders {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		add("allow_providers: %s: %v", tag, p.AllowProviders[tag])
+	}
+	onViolation := p.OnViolation
+	if onViolation == "" {
+		onViolation = "block"
+	}
+	add("on_violation: %s", onViolation)
+	return
+}
+
+// banned returns the pattern that bans path, or an empty string.
+// Patterns always use forward slashes, as in the db, on every
+// platform.
+func (p *Policy) banned(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, pat := range p.BannedPaths {
+		if strings.HasSuffix(pat, "/") {
+			dir := strings.TrimSuffix(pat, "/")
+			parts := strings.Split(path, "/")
+			for _, part := range parts[:len(parts)-1] {
+				if ok, _ := pathpkg.Match(dir, part); ok {
+					return pat
+				}
+			}
+			continue
+		}
+		if ok, _ := pathpkg.Match(pat, path); ok {
+			return pat
+		}
+		if ok, _ := pathpkg.Match(pat, pathpkg.Base(path)); ok {
+			return pat
+		}
+	}
+	return ""
+}
+
+// getPolicy returns the policy from the user config file and the
+// policy file, loading it on first use.  Without either, the policy
+// is empty and allows everything.
+func (g *Grokker) getPolicy() (p *Policy, err error) {
+	defer Return(&err)
+	if g.policy == nil {
+		cfg, err := LoadConfig()
+		Ck(err)
+		p := cfg.Policy
+		if p == nil {
+			p = &Policy{}
+		}
+		if g.Root != "" {
+			path := filepath.Join(g.Root, policyFile)
+			buf, err := os.ReadFile(path)
+			if err == nil {
+				fp := &Policy{}
+				err = yaml.Unmarshal(buf, fp)
+				Ck(err, "%s", path)
+				p.merge(fp)
+			} else if !os.IsNotExist(err) {
+				Ck(err)
+			}
+		}
+		err = p.compile()
+		Ck(err)
+		g.policy = p
+	}
+	p = g.policy
+	return
+}
+
+// checkPath returns an error if the policy bans path, unless the
+// user overrides the violation at the prompt.
+func (g *Grokker) checkPath(path string) (err error) {
+	defer Return(&err)
+	p, err := g.getPolicy()
+	Ck(err)
+	pat := p.banned(path)
+	if pat == "" {
+		return
+	}
+	err = g.violation("banned_paths", Spf("%s matches %q", path, pat))
+	return
+}
+
+// Policy returns the policy in effect; see Policy.
+func (g *Grokker) Policy() (p *Policy, err error) {
+	return g.getPolicy()
+}
+
+// allowedChunks drops chunks from documents that the policy bans,
+// e.g. documents added before the ban, and from documents whose
+// sensitivity doesn't allow the chat provider.
+func (g *Grokker) allowedChunks(chunks []*Chunk) (out []*Chunk, err error) {
+	defer Return(&err)
+	p, err := g.getPolicy()
+	Ck(err)
+	if len(p.BannedPaths) == 0 && len(p.AllowProviders) == 0 {
+		return chunks, nil
+	}
+	provider := g.chatProvider()
+	dropped := make(map[string]string)
+	for _, c := range chunks {
+		if c.Document == nil {
+			out = append(out, c)
+			continue
+		}
+		path := c.Document.RelPath
+		if p.banned(path) != "" {
+			dropped[path] = "banned_paths"
+			continue
+		}
+		if tag := p.sensitivity(c.Document); !p.allows(tag, provider) {
+			dropped[path] = "allow_providers"
+			continue
+		}
+		out = append(out, c)
+	}
+	for path, rule := range dropped {
+		g.policyLog("drop", rule, path)
+	}
+	return
+}
+
+// checkProvider returns an error if the policy doesn't let provider
+// receive the text of chunks, unless the user overrides the
+// violation at the prompt.
+func (g *Grokker) checkProvider(provider string, chunks []*Chunk) (err error) {
+	defer Return(&err)
+	p, err := g.getPolicy()
+	Ck(err)
+	seen := make(map[string]bool)
+	for _, c := range chunks {
+		if c.Document == nil || seen[c.Document.RelPath] {
+			continue
+		}
+		seen[c.Document.RelPath] = true
+		tag := p.sensitivity(c.Document)
+		if p.allows(tag, provider) {
+			continue
+		}
+		err = g.violation("allow_providers", Spf("%s is %s, which may not be sent to %s", c.Document.RelPath, tag, provider))
+		Ck(err)
+	}
+	return
+}
+
+// redact replaces the matches of the redact patterns and PII
+// detectors in text, and returns the number replaced.
+func (p *Policy) redact(text string) (out string, n int) {
+	return redactAll(p.redactRes, text)
+}
+
+// redactFor is redact plus the redactions for provider.
+func (p *Policy) redactFor(provider, text string) (out string, n int) {
+	out, n = p.redact(text)
+	if pp := p.Providers[provider]; pp != nil {
+		var m int
+		out, m = redactAll(pp.redactRes, out)
+		n += m
+	}
+	return
+}
+
+// redactAll replaces the matches of res in text, and returns the
+// number replaced.
+func redactAll(res []*regexp.Regexp, text string) (out string, n int) {
+	out = text
+	for _, re := range res {
+		out = re.ReplaceAllStringFunc(out, func(string) string {
+			n++
+			return redactedText
+		})
+	}
+	return
+}
+
+// checkOutgoing applies the policy to the texts of a single request
+// to provider.  It returns the redacted texts, or an error if the
+// request is too large and the user doesn't override the violation.
+func (g *Grokker) checkOutgoing(provider string, texts []string) (out []string, err error) {
+	defer Return(&err)
+	p, err := g.getPolicy()
+	Ck(err)
+	size := 0
+	redactions := 0
+	for _, text := range texts {
+		text, n := p.redactFor(provider, text)
+		redactions += n
+		size += len(text)
+		out = append(out, text)
+	}
+	if redactions > 0 {
+		g.policyLog("redact", "redact", Spf("%d matches in a request to %s", redactions, provider))
+	}
+	maxBytes, maxTokens, _ := p.limits(provider)
+	if maxBytes > 0 && size > maxBytes {
+		err = g.violation("max_bytes", Spf("request to %s is %d bytes, limit is %d", provider, size, maxBytes))
+		if err != nil {
+			err = classify(FailBudget, err)
+		}
+		Ck(err)
+	}
+	if maxTokens > 0 {
+		count, err := CountTokens(strings.Join(out, "\n"))
+		Ck(err)
+		if count > maxTokens {
+			err = g.violation("max_tokens", Spf("request to %s is %d tokens, limit is %d", provider, count, maxTokens))
+			if err != nil {
+				err = classify(FailBudget, err)
+			}
+			Ck(err)
+		}
+	}
+	return
+}
+
+// violation logs a policy violation and either returns an error or,
+// if the policy says to prompt, asks the user whether to go ahead.
+func (g *Grokker) violation(rule, detail string) (err error) {
+	p, err := g.getPolicy()
+	if err != nil {
+		return
+	}
+	g.policyLog("violation", rule, detail)
+	err = fmt.Errorf("policy violation: %s: %s", rule, detail)
+	if p.OnViolation != "prompt" {
+		return
+	}
+	if confirm(Spf("%v; send anyway?", err)) {
+		g.policyLog("allow", rule, detail)
+		return nil
+	}
+	g.policyLog("block", rule, detail)
+	return
+}
+
+// interactive is false if confirm must not ask; see SetInteractive.
+var interactive = true
+
+// SetInteractive sets whether grokker may ask questions on the
+// terminal, e.g. whether to send a request that violates the policy.
+// When it may not, the answer is always no.
+func SetInteractive(on bool) {
+	interactive = on
+}
+
+// confirm asks a yes/no question on the terminal, which may not be
+// stdin, since many commands read their input from stdin.  It
+// returns false if there is no terminal.
+func confirm(question string) bool {
+	if !interactive {
+		return false
+	}
+	in, out, err := openTerminal()
+	if err != nil {
+		return false
+	}
+	defer in.Close()
+	defer out.Close()
+	Fpf(out, "%s [y/N]: ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// PolicyEntry is a line in the policy log.  Event is "violation",
+// "allow" or "block" for the user's decision on a violation at the
+// prompt, "redact", or "drop" for a chunk left out of the context.
+type PolicyEntry struct {
+	Time   time.Time
+	Event  string
+	Rule   string
+	Detail string
+}
+
+func (e PolicyEntry) String() string {
+	return Spf("%s %-9s %-15s %s", e.Time.Format(time.RFC3339), e.Event, e.Rule, e.Detail)
+}
+
+// PolicyLog returns the entries in the policy log, oldest first.
+func (g *Grokker) PolicyLog() (entries []PolicyEntry, err error) {
+	defer Return(&err)
+	path := g.policyLogPath()
+	if path == "" {
+		return
+	}
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	for line := 1; scanner.Scan(); line++ {
+		var e PolicyEntry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		Ck(err, "%s:%d", path, line)
+		entries = append(entries, e)
+	}
+	err = scanner.Err()
+	return
+}
+
+// policyLogPath returns the path of the policy log, or an empty
+// string if the db has no file, e.g. in tests.
+func (g *Grokker) policyLogPath() string {
+	if g.grokpath == "" {
+		return ""
+	}
+	return g.grokpath + ".policy.log"
+}
+
+// policyLog appends an entry to the policy log.  Logging is best
+// effort; a failure is reported on stderr but doesn't stop the
+// request.
+func (g *Grokker) policyLog(event, rule, detail string) {
+	path := g.policyLogPath()
+	if path == "" {
+		return
+	}
+	buf, err := json.Marshal(PolicyEntry{time.Now(), event, rule, detail})
+	if err == nil {
+		var fh *os.File
+		fh, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = fh.Write(append(buf, '\n'))
+			fh.Close()
+		}
+	}
+	if err != nil {
+		Fpf(os.Stderr, "warning: can't write policy log: %v\n", err)
+	}
+}
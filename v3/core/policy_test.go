@@ -0,0 +1,134 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPolicy(t *testing.T) {
+	dir := TmpTestDir()
+	p := &Policy{
+		MaxBytes:    20,
+		BannedPaths: []string{"secrets/", "*.pem", ".env"},
+		Redact:      []string{`AKIA[0-9A-Z]{4}`},
+	}
+	err := p.compile()
+	Tassert(t, err == nil, "error compiling policy: %v", err)
+	g := &Grokker{Root: dir, grokpath: filepath.Join(dir, ".grok"), policy: p}
+
+	for path, ban := range map[string]bool{
+		"secrets/a.txt": true, "a/secrets/b": true, "key.pem": true, "a/key.pem": true,
+		"a/.env": true, "secrets.txt": false, "a/b.go": false, ".envrc": false,
+	} {
+		err = g.checkPath(path)
+		Tassert(t, (err != nil) == ban, "%s: expected ban %v, got %v", path, ban, err)
+	}
+
+	// redaction happens before the size check
+	out, err := g.checkOutgoing("openai", []string{"key AKIA1234", "ok"})
+	Tassert(t, err == nil, "error checking request: %v", err)
+	Tassert(t, out[0] == "key [REDACTED]", "got %q", out[0])
+	_, err = g.checkOutgoing("openai", []string{strings.Repeat("x", 21)})
+	Tassert(t, err != nil, "expected error for oversized request")
+
+	// chunks from banned documents are never used as context
+	ok := &Chunk{Document: &Document{RelPath: "a.go"}}
+	chunks, err := g.allowedChunks([]*Chunk{ok, {Document: &Document{RelPath: "secrets/x"}}})
+	Tassert(t, err == nil, "error filtering chunks: %v", err)
+	Tassert(t, len(chunks) == 1 && chunks[0] == ok, "got %v", chunks)
+
+	buf, err := os.ReadFile(g.policyLogPath())
+	Tassert(t, err == nil, "error reading policy log: %v", err)
+	log := string(buf)
+	Tassert(t, strings.Count(log, `"Event":"violation"`) == 6, "got log %s", log)
+	Tassert(t, strings.Contains(log, `"Event":"redact"`), "got log %s", log)
+	Tassert(t, strings.Contains(log, `"Event":"drop"`), "got log %s", log)
+
+	p = &Policy{OnViolation: "warn"}
+	err = p.compile()
+	Tassert(t, err != nil, "expected error for bad on_violation")
+}
+
+func TestProviderPolicy(t *testing.T) {
+	t.Setenv("GROKKER_CONFIG_DIR", TmpTestDir())
+	dir := TmpTestDir()
+	rules := `
+max_tokens: 100
+pii: [email]
+providers:
+  openrouter: {max_tokens: 20, max_response_tokens: 50, pii: [ssn]}
+sensitivity: {hr: confidential}
+allow_providers: {confidential: [ollama]}
+on_violation: block
+`
+	err := os.WriteFile(filepath.Join(dir, policyFile), []byte(rules), 0644)
+	Ck(err)
+	g := &Grokker{Root: dir, grokpath: filepath.Join(dir, ".grok")}
+	p, err := g.Policy()
+	Tassert(t, err == nil, "error loading policy: %v", err)
+	maxBytes, maxTokens, maxResponseTokens := p.limits("openrouter")
+	Tassert(t, maxBytes == 0 && maxTokens == 20 && maxResponseTokens == 50, "got %d %d %d", maxBytes, maxTokens, maxResponseTokens)
+
+	// the PII detectors for all providers and for the provider apply
+	text := "mail bob@example.com about 123-45-6789"
+	out, err := g.checkOutgoing("openai", []string{text})
+	Tassert(t, err == nil && out[0] == "mail [REDACTED] about 123-45-6789", "got %q, %v", out, err)
+	out, err = g.checkOutgoing("openrouter", []string{text})
+	Tassert(t, err == nil && out[0] == "mail [REDACTED] about [REDACTED]", "got %q, %v", out, err)
+	_, err = g.checkOutgoing("openrouter", []string{strings.Repeat("one two ", 20)})
+	Tassert(t, err != nil && strings.Contains(err.Error(), "max_tokens"), "expected a max_tokens violation, got %v", err)
+
+	// confidential documents only go to the allowed providers
+	hr := &Chunk{Document: &Document{RelPath: "hr/pay.md", Collection: "hr"}}
+	docs := &Chunk{Document: &Document{RelPath: "README.md"}}
+	err = g.checkProvider("openai", []*Chunk{docs, hr})
+	Tassert(t, err != nil && strings.Contains(err.Error(), "hr/pay.md"), "expected a violation for hr/pay.md, got %v", err)
+	err = g.checkProvider("ollama", []*Chunk{docs, hr})
+	Tassert(t, err == nil, "unexpected violation: %v", err)
+	chunks, err := g.allowedChunks([]*Chunk{docs, hr})
+	Tassert(t, err == nil && len(chunks) == 1 && chunks[0] == docs, "got %v, %v", chunks, err)
+
+	entries, err := g.PolicyLog()
+	Tassert(t, err == nil, "error reading policy log: %v", err)
+	var events []string
+	for _, e := range entries {
+		events = append(events, e.Event+" "+e.Rule)
+	}
+	Tassert(t, strings.Join(events, ", ") == "redact redact, redact redact, violation max_tokens, violation allow_providers, drop allow_providers", "got %v", events)
+
+	p = &Policy{PII: []string{"dna"}}
+	err = p.compile()
+	Tassert(t, err != nil, "expected error for unknown PII detector")
+}
+
+func TestPolicyMerge(t *testing.T) {
+	p := &Policy{
+		MaxBytes:       1000,
+		OnViolation:    "prompt",
+		Audit:          "full",
+		Sensitivity:    map[string]string{"hr": "internal"},
+		AllowProviders: map[string][]string{"confidential": {"ollama", "openai"}},
+	}
+	p.merge(&Policy{
+		MaxBytes:       2000,
+		MaxTokens:      300,
+		OnViolation:    "block",
+		Audit:          "truncated",
+		Redact:         []string{"x"},
+		Sensitivity:    map[string]string{"hr": "confidential"},
+		AllowProviders: map[string][]string{"confidential": {"ollama"}},
+		Providers:      map[string]*ProviderPolicy{"openai": {MaxTokens: 10}},
+	})
+	Tassert(t, p.MaxBytes == 1000 && p.MaxTokens == 300, "got %d %d", p.MaxBytes, p.MaxTokens)
+	Tassert(t, p.OnViolation == "block" && p.Audit == "full", "got %q %q", p.OnViolation, p.Audit)
+	Tassert(t, len(p.Redact) == 1 && p.Sensitivity["hr"] == "confidential", "got %v %v", p.Redact, p.Sensitivity)
+	Tassert(t, strings.Join(p.AllowProviders["confidential"], " ") == "ollama", "got %v", p.AllowProviders)
+	Tassert(t, p.Providers["openai"].MaxTokens == 10, "got %+v", p.Providers["openai"])
+	err := p.compile()
+	Ck(err)
+	Tassert(t, strings.Contains(strings.Join(p.Rules(), "\n"), "providers: openai: max_tokens: 10"), "got %v", p.Rules())
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Post-processors rewrite completions before we return them from
+// Answer, Continue, and Revise.  They are listed, in the order they
+// run, in Pipeline.PostProcess:
+//
+//   - strip removes the pleasantries models like to add, e.g.
+//     "Certainly!" and "Let me know if you have any other questions."
+//   - markdown tidies the output into well-formed markdown, e.g.
+//     closing an unterminated code fence.
+//   - links turns cited document paths, optionally with a line
+//     number, into markdown links using Pipeline.LinkFormat.
+//   - rewrite applies the sed-style rules in Pipeline.Rewrites.
+
+// postProcessors maps post-processor names to implementations.
+var postProcessors = map[string]func(g *Grokker, text string) (string, error){
+	"strip":    stripBoilerplate,
+	"markdown": tidyMarkdown,
+	"links":    linkPaths,
+	"rewrite":  applyRewrites,
+}
+
+// defaultLinkFormat is used when Pipeline.LinkFormat is empty.
+const defaultLinkFormat = "file://{abs}"
+
+// PostProcess runs the configured post-processors on a completion.
+func (g *Grokker) PostProcess(text string) (out string, err error) {
+	defer Return(&err)
+	out = text
+	for _, name := range g.Pipeline.PostProcess {
+		fn, ok := postProcessors[name]
+		if !ok {
+			err = fmt.Errorf("unknown post-processor %q", name)
+			return
+		}
+		out, err = fn(g, out)
+		Ck(err)
+	}
+	return
+}
+
+// checkPostProcess verifies the post-processor settings.
+func (g *Grokker) checkPostProcess() (err error) {
+	for _, name := range g.Pipeline.PostProcess {
+		if _, ok := postProcessors[name]; !ok {
+			err = fmt.Errorf("unknown post-processor %q", name)
+			return
+		}
+	}
+	for _, rule := range g.Pipeline.Rewrites {
+		_, _, err = parseRewrite(rule)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// boilerplateRes match model pleasantries at the start or end of a
+// completion.
+var boilerplateRes = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^\s*(sure|certainly|of course|absolutely|great question)[!.,][^\n]*\n+`),
+	regexp.MustCompile(`(?i)^\s*as an ai( language model)?,[^.\n]*[.\n]\s*`),
+	regexp.MustCompile(`(?i)\n+[^\n]*(let me know if you (have|need)|i hope this helps|feel free to ask)[^\n]*\s*$`),
+}
+
+func stripBoilerplate(g *Grokker, text string) (string, error) {
+	for _, re := range boilerplateRes {
+		text = re.ReplaceAllString(text, "")
+	}
+	return strings.TrimSpace(text) + "\n", nil
+}
+
+var blankLinesRe = regexp.MustCompile(`\n{3,}`)
+
+func tidyMarkdown(g *Grokker, text string) (string, error) {
+	lines := strings.Split(text, "\n")
+	fences := 0
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fences++
+		}
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesRe.ReplaceAllString(text, "\n\n")
+	text = strings.TrimSpace(text) + "\n"
+	if fences%2 == 1 {
+		text += "```\n"
+	}
+	return text, nil
+}
+
+// linkPaths turns document paths cited outside of code blocks into
+// markdown links.  LinkFormat can use {path} (relative to the repo
+// root), {abs} (absolute), and {line}; ":{line}" and "#L{line}" are
+// dropped when the citation has no line number.
+func linkPaths(g *Grokker, text string) (string, error) {
+	format := g.Pipeline.LinkFormat
+	if format == "" {
+		format = defaultLinkFormat
+	}
+	var paths []string
+	for _, doc := range g.Documents {
+		if !doc.Virtual {
+			paths = append(paths, regexp.QuoteMeta(doc.RelPath))
+		}
+	}
+	if len(paths) == 0 {
+		return text, nil
+	}
+	// match longer paths first so "a/b.go" wins over "b.go"
+	sort.Slice(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
+	re, err := regexp.Compile(`(^|[\s(\x60'"])(` + strings.Join(paths, "|") + `)(?::(\d+))?\b(\x60?)`)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(text, "\n")
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		lines[i] = re.ReplaceAllStringFunc(line, func(m string) string {
+			sm := re.FindStringSubmatch(m)
+			lead, path, lineno, trail := sm[1], sm[2], sm[3], sm[4]
+			url := format
+			if lineno == "" {
+				url = strings.NewReplacer(":{line}", "", "#L{line}", "", "{line}", "").Replace(url)
+			}
+			url = strings.NewReplacer(
+				"{path}", path,
+				"{abs}", filepath.Join(g.Root, path),
+				"{line}", lineno,
+			).Replace(url)
+			label := path
+			if lineno != "" {
+				label += ":" + lineno
+			}
+			if lead == "`" && trail == "`" {
+				// the citation was code-formatted; keep that
+				// inside the link
+				return Spf("[`%s`](%s)", label, url)
+			}
+			return Spf("%s[%s](%s)%s", lead, label, url, trail)
+		})
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// applyRewrites applies Pipeline.Rewrites, each of which is a
+// sed-style substitution, "s/regexp/replacement/", where any
+// character can take the place of the slash.  The replacement can
+// refer to submatches as $1, $2, etc.
+func applyRewrites(g *Grokker, text string) (out string, err error) {
+	defer Return(&err)
+	out = text
+	for _, rule := range g.Pipeline.Rewrites {
+		var re *regexp.Regexp
+		var repl string
+		re, repl, err = parseRewrite(rule)
+		Ck(err)
+		out = re.ReplaceAllString(out, repl)
+	}
+	return
+}
+
+// parseRewrite parses a "s/regexp/replacement/" rule.
+func parseRewrite(rule string) (re *regexp.Regexp, repl string, err error) {
+	if len(rule) < 4 || rule[0] != 's' {
+		err = fmt.Errorf("invalid rewrite %q; expected s/regexp/replacement/", rule)
+		return
+	}
+	delim := rule[1:2]
+	parts := strings.Split(rule[2:], delim)
+	if len(parts) != 3 || parts[2] != "" {
+		err = fmt.Errorf("invalid rewrite %q; expected s%sregexp%sreplacement%s", rule, delim, delim, delim)
+		return
+	}
+	re, err = regexp.Compile(parts[0])
+	if err != nil {
+		return
+	}
+	repl = parts[1]
+	return
+}
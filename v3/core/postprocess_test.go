@@ -0,0 +1,46 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestPostProcess(t *testing.T) {
+	g := &Grokker{Root: "/repo"}
+	g.Documents = []*Document{{RelPath: "core/api.go"}, {RelPath: "api.go"}, {RelPath: "notes", Virtual: true}}
+
+	// strip and markdown
+	g.Pipeline.PostProcess = []string{"strip", "markdown"}
+	out, err := g.PostProcess("Certainly! Here you go.\n\nUse this:   \n\n\n\n```go\nfoo()\n\nLet me know if you have any other questions.")
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, out == "Use this:\n\n```go\nfoo()\n```\n", "got %q", out)
+
+	// links, with and without line numbers, but not in code blocks
+	g.Pipeline.PostProcess = []string{"links"}
+	g.Pipeline.LinkFormat = "vscode://file{abs}:{line}"
+	out, err = g.PostProcess("See core/api.go:42 and `api.go`, not notes.\n```\napi.go\n```")
+	Tassert(t, err == nil, "%v", err)
+	expect := "See [core/api.go:42](vscode://file/repo/core/api.go:42) and [`api.go`](vscode://file/repo/api.go), not notes.\n```\napi.go\n```"
+	Tassert(t, out == expect, "got %q", out)
+	g.Pipeline.LinkFormat = "https://github.com/o/r/blob/main/{path}#L{line}"
+	out, err = g.PostProcess("in api.go")
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, out == "in [api.go](https://github.com/o/r/blob/main/api.go)", "got %q", out)
+
+	// rewrite
+	g.Pipeline.PostProcess = []string{"rewrite"}
+	g.Pipeline.Rewrites = []string{`s/colou?r/hue/`, `s|(\w+)@example\.com|$1 at example|`}
+	out, err = g.PostProcess("color, colour, bob@example.com")
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, out == "hue, hue, bob at example", "got %q", out)
+
+	// bad settings
+	g.Pipeline.Rewrites = []string{"s/a/b"}
+	err = g.checkPostProcess()
+	Tassert(t, err != nil, "expected error for unterminated rewrite")
+	g.Pipeline.Rewrites = nil
+	g.Pipeline.PostProcess = []string{"nope"}
+	_, err = g.PostProcess("x")
+	Tassert(t, err != nil, "expected error for unknown post-processor")
+}
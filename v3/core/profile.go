@@ -0,0 +1,103 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Profile is a named preset for how much an answer draws on and how
+// long it is: the number of chunks of context, the context budget,
+// and instructions on the length and shape of the answer, so a quick
+// lookup and a deep dive don't each need several flags.  Profiles
+// are defined under "profiles:" in the user config file:
+//
+//	profiles:
+//	  standup:
+//	    k: 6
+//	    context_tokens: 3000
+//	    instructions: Answer in at most three bullet points.
+//
+// Limits set with SetContextLimits, e.g. from -k, take precedence.
+type Profile struct {
+	// K is the most chunks of context; zero means no limit.
+	K int `yaml:"k"`
+	// ContextTokens is the context budget; zero means half the
+	// model's token limit.
+	ContextTokens int `yaml:"context_tokens"`
+	// Instructions are appended to the system message.
+	Instructions string `yaml:"instructions"`
+}
+
+// builtinProfiles can be overridden in the config file.
+var builtinProfiles = map[string]*Profile{
+	"brief": {
+		K:             5,
+		ContextTokens: 2000,
+		Instructions:  "Answer briefly: a few sentences, or a short list, with no preamble or summary.",
+	},
+	"normal": {},
+	"deep": {
+		Instructions: "Answer thoroughly.  Cover the relevant details, edge cases, and trade-offs, explain how the pieces fit together, and cite the file for each point.",
+	},
+}
+
+// Profiles returns the built-in profiles merged with those in the
+// user config file.
+func Profiles() (profiles map[string]*Profile, err error) {
+	defer Return(&err)
+	cfg, err := LoadConfig()
+	Ck(err)
+	profiles = make(map[string]*Profile)
+	for name, p := range builtinProfiles {
+		profiles[name] = p
+	}
+	for name, p := range cfg.Profiles {
+		Assert(p != nil, "profile %q is empty", name)
+		profiles[name] = p
+	}
+	return
+}
+
+// ProfileNames returns the sorted names of the available profiles.
+func ProfileNames() (names []string, err error) {
+	profiles, err := Profiles()
+	if err != nil {
+		return
+	}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// SetProfile selects the profile used by subsequent answers, filling
+// in the context limits not already set with SetContextLimits.  Pass
+// an empty name to go back to the defaults.  The profile is not
+// stored in the database.
+func (g *Grokker) SetProfile(name string) (err error) {
+	defer Return(&err)
+	if name == "" {
+		g.profile = nil
+		return
+	}
+	profiles, err := Profiles()
+	Ck(err)
+	p, ok := profiles[name]
+	if !ok {
+		names, _ := ProfileNames()
+		err = fmt.Errorf("unknown profile %q; available: %s", name, strings.Join(names, ", "))
+		return
+	}
+	g.profile = p
+	if g.maxChunks == 0 {
+		g.maxChunks = p.K
+	}
+	if g.contextTokens == 0 {
+		g.contextTokens = p.ContextTokens
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestProfile(t *testing.T) {
+	dir := TmpTestDir()
+	fn := filepath.Join(dir, "config.yaml")
+	cfg := "profiles:\n  standup:\n    k: 6\n    context_tokens: 3000\n    instructions: Three bullets.\n" +
+		"personas:\n  terse:\n    format: plain\n"
+	err := os.WriteFile(fn, []byte(cfg), 0644)
+	Tassert(t, err == nil, "error writing config: %v", err)
+	t.Setenv("GROKKER_CONFIG", fn)
+
+	names, err := ProfileNames()
+	Tassert(t, err == nil, "error listing profiles: %v", err)
+	Tassert(t, strings.Join(names, ",") == "brief,deep,normal,standup", "got %v", names)
+
+	g := &Grokker{}
+	err = g.SetProfile("standup")
+	Tassert(t, err == nil, "error setting profile: %v", err)
+	Tassert(t, g.maxChunks == 6 && g.contextTokens == 3000, "got k %d, tokens %d", g.maxChunks, g.contextTokens)
+	Tassert(t, g.Sysmsg("base") == "base\n\nThree bullets.", "got %q", g.Sysmsg("base"))
+	// the profile's instructions follow the persona's format
+	err = g.SetPersona("terse")
+	Tassert(t, err == nil, "error setting persona: %v", err)
+	expect := "base\n\n" + outputFormats["plain"] + "\n\nThree bullets."
+	Tassert(t, g.Sysmsg("base") == expect, "got %q", g.Sysmsg("base"))
+
+	// limits set first take precedence
+	g = &Grokker{}
+	g.SetContextLimits(2, 0)
+	err = g.SetProfile("brief")
+	Tassert(t, err == nil, "error setting profile: %v", err)
+	Tassert(t, g.maxChunks == 2 && g.contextTokens == builtinProfiles["brief"].ContextTokens, "got k %d, tokens %d", g.maxChunks, g.contextTokens)
+
+	err = g.SetProfile("nope")
+	Tassert(t, err != nil, "expected error for unknown profile")
+	err = g.SetProfile("")
+	Tassert(t, err == nil && g.profile == nil, "expected profile to be cleared")
+	Tassert(t, g.Sysmsg("base") == "base", "got %q", g.Sysmsg("base"))
+}
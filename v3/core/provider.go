@@ -0,0 +1,301 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	oai "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+// Provider describes an OpenAI-compatible chat completion endpoint
+// other than OpenAI itself.  Models served by a provider are named
+// "<provider>:<upstream model id>", e.g.
+// "openrouter:anthropic/claude-3.5-sonnet".
+type Provider struct {
+	// The prefix used in model names.
+	Name string
+	// The base URL of the API, e.g. "https://openrouter.ai/api/v1".
+	BaseURL string
+	// The API key.
+	AuthToken string
+}
+
+// modelCacheTTL is how long we use a cached provider model list
+// before fetching it again.
+var modelCacheTTL = 24 * time.Hour
+
+// Providers returns the providers that are configured via environment
+// variables.  OpenRouter is enabled by setting OPENROUTER_API_KEY; any
+// other OpenAI-compatible endpoint is enabled by setting
+// GROKKER_OPENAI_COMPAT_URL and, if the endpoint needs one,
+// GROKKER_OPENAI_COMPAT_KEY.
+func Providers() (providers map[string]*Provider) {
+	providers = make(map[string]*Provider)
+	key := os.Getenv("OPENROUTER_API_KEY")
+	if key != "" {
+		url := os.Getenv("OPENROUTER_BASE_URL")
+		if url == "" {
+			url = "https://openrouter.ai/api/v1"
+		}
+		providers["openrouter"] = &Provider{"openrouter", url, key}
+	}
+	url := os.Getenv("GROKKER_OPENAI_COMPAT_URL")
+	if url != "" {
+		key := os.Getenv("GROKKER_OPENAI_COMPAT_KEY")
+		providers["compat"] = &Provider{"compat", url, key}
+	}
+	return
+}
+
+// splitModelName splits a model name into provider and upstream
+// model id.  The provider is empty for OpenAI models.
+func splitModelName(name string) (provider, id string) {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) < 2 {
+		return "", name
+	}
+	return parts[0], parts[1]
+}
+
+// clientConfig returns the go-openai client configuration for the
+// provider.
+func (p *Provider) clientConfig() oai.ClientConfig {
+	config := oai.DefaultConfig(p.AuthToken)
+	config.BaseURL = strings.TrimRight(p.BaseURL, "/")
+	return config
+}
+
+// providerModel is one entry in a provider's /models response.
+// OpenRouter includes context length and per-token pricing as
+// decimal strings; plain OpenAI-compatible servers usually only
+// include the id, and those that report the context window each
+// call it something else.
+type providerModel struct {
+	ID            string `json:"id"`
+	ContextLength int    `json:"context_length"`
+	// Groq
+	ContextWindow int `json:"context_window"`
+	// vLLM
+	MaxModelLen int `json:"max_model_len"`
+	// OpenRouter, for the provider it routes to
+	TopProvider struct {
+		ContextLength int `json:"context_length"`
+	} `json:"top_provider"`
+	// llama.cpp
+	Meta struct {
+		NCtxTrain int `json:"n_ctx_train"`
+	} `json:"meta"`
+	Pricing struct {
+		Prompt     string `json:"prompt"`
+		Completion string `json:"completion"`
+	} `json:"pricing"`
+}
+
+// contextLength returns the model's context window in tokens, or 0
+// if the entry doesn't say.
+func (e providerModel) contextLength() int {
+	for _, n := range []int{e.ContextLength, e.TopProvider.ContextLength, e.ContextWindow, e.MaxModelLen, e.Meta.NCtxTrain} {
+		if n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// Models returns the models served by the provider, using the cached
+// list if it is fresh enough.
+func (p *Provider) Models() (models []*Model, err error) {
+	defer Return(&err)
+	entries, err := p.modelEntries()
+	Ck(err)
+	for _, e := range entries {
+		models = append(models, p.newModel(e))
+	}
+	return
+}
+
+// modelRefreshes are the background fetches of model lists whose
+// cache has expired, by cache path; see modelEntries.
+var modelRefreshes struct {
+	sync.Mutex
+	sync.WaitGroup
+	running map[string]bool
+}
+
+// modelEntries returns the provider's /models entries.  The list is
+// fetched when there is none cached; once the cached list expires it
+// is still used, and fetched again in the background, so a raised
+// limit is picked up without holding up the load of a db.  A list
+// that can't be fetched isn't cached, so the next load tries again.
+func (p *Provider) modelEntries() (entries []providerModel, err error) {
+	defer Return(&err)
+	cachefn := p.cachePath()
+	stale := false
+	if cachefn != "" {
+		fi, err := os.Stat(cachefn)
+		if err == nil {
+			buf, err := os.ReadFile(cachefn)
+			if err == nil && json.Unmarshal(buf, &entries) == nil {
+				Debug("using cached model list %s", cachefn)
+				stale = time.Since(fi.ModTime()) >= modelCacheTTL
+			} else {
+				entries = nil
+			}
+		}
+		if entries != nil {
+			recordCache("models", 1, 0)
+		} else {
+			recordCache("models", 0, 1)
+		}
+	}
+	if entries == nil {
+		entries, err = p.refreshModels(cachefn)
+		Ck(err)
+		return
+	}
+	if stale {
+		mr := &modelRefreshes
+		mr.Lock()
+		defer mr.Unlock()
+		if mr.running[cachefn] {
+			return
+		}
+		if mr.running == nil {
+			mr.running = make(map[string]bool)
+		}
+		mr.running[cachefn] = true
+		mr.Add(1)
+		go func() {
+			defer mr.Done()
+			_, err := p.refreshModels(cachefn)
+			if err != nil {
+				Debug("cannot refresh model list %s: %v", cachefn, err)
+			}
+			mr.Lock()
+			delete(mr.running, cachefn)
+			mr.Unlock()
+		}()
+	}
+	return
+}
+
+// refreshModels fetches the provider's /models entries and, if
+// cachefn isn't empty, caches them there.
+func (p *Provider) refreshModels(cachefn string) (entries []providerModel, err error) {
+	defer Return(&err)
+	start := time.Now()
+	entries, err = p.fetchModels()
+	recordRequest(p.Name, start, err)
+	Ck(err)
+	if cachefn == "" {
+		return
+	}
+	buf, err := json.Marshal(entries)
+	Ck(err)
+	// write a whole file, for another process reading the cache
+	tmpfn := Spf("%s.%d.tmp", cachefn, os.Getpid())
+	err = os.MkdirAll(filepath.Dir(cachefn), 0755)
+	if err == nil {
+		err = os.WriteFile(tmpfn, buf, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpfn, cachefn)
+	}
+	if err != nil {
+		// caching is an optimization; keep going
+		os.Remove(tmpfn)
+		Fpf(os.Stderr, "warning: cannot cache model list: %v\n", err)
+		err = nil
+	}
+	return
+}
+
+// cachePath returns the path of the cached model list for the
+// provider, or an empty string if there is no user cache directory.
+func (p *Provider) cachePath() string {
+	dir := CacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, Spf("%s-models.json", p.Name))
+}
+
+// fetchModels gets the model list from the provider's /models
+// endpoint.
+func (p *Provider) fetchModels() (entries []providerModel, err error) {
+	defer Return(&err)
+	url := strings.TrimRight(p.BaseURL, "/") + "/models"
+	req, err := http.NewRequest("GET", url, nil)
+	Ck(err)
+	if p.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.AuthToken)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	Ck(err)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s: %s", url, res.Status)
+		return
+	}
+	var body struct {
+		Data []providerModel `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	Ck(err)
+	entries = body.Data
+	return
+}
+
+// newModel converts a /models entry to a Model.  Prices are converted
+// from dollars per token to dollars per million tokens.
+func (p *Provider) newModel(e providerModel) *Model {
+	m := &Model{
+		Name:         p.Name + ":" + e.ID,
+		TokenLimit:   e.contextLength(),
+		upstreamName: e.ID,
+		provider:     p.Name,
+	}
+	if m.TokenLimit == 0 {
+		// we don't know; assume something conservative
+		m.TokenLimit = 8192
+	}
+	prompt, err := strconv.ParseFloat(e.Pricing.Prompt, 64)
+	if err == nil {
+		m.PromptPrice = prompt * 1e6
+	}
+	completion, err := strconv.ParseFloat(e.Pricing.Completion, 64)
+	if err == nil {
+		m.CompletionPrice = completion * 1e6
+	}
+	return m
+}
+
+// loadProvider adds the models served by the named provider to the
+// available models.
+func (models *Models) loadProvider(name string) (err error) {
+	defer Return(&err)
+	if models.loaded[name] {
+		return
+	}
+	p, ok := Providers()[name]
+	if !ok {
+		err = fmt.Errorf("provider %q is not configured", name)
+		return
+	}
+	pms, err := p.Models()
+	Ck(err)
+	for _, m := range pms {
+		models.Available[m.Name] = m
+	}
+	models.loaded[name] = true
+	return
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestProviderModels(t *testing.T) {
+	// fake an OpenRouter-style /models endpoint
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Tassert(t, r.URL.Path == "/api/v1/models", "unexpected path %q", r.URL.Path)
+		Tassert(t, r.Header.Get("Authorization") == "Bearer testkey", "missing auth header")
+		w.Write([]byte(`{"data": [
+			{"id": "vendor/big", "context_length": 200000,
+			 "pricing": {"prompt": "0.000003", "completion": "0.000015"}},
+			{"id": "local-llama"}
+		]}`))
+	}))
+	defer srv.Close()
+
+	p := &Provider{"openrouter", srv.URL + "/api/v1/", "testkey"}
+	entries, err := p.fetchModels()
+	Tassert(t, err == nil, "error fetching models: %v", err)
+	Tassert(t, len(entries) == 2, "expected 2 models, got %d", len(entries))
+
+	m := p.newModel(entries[0])
+	Tassert(t, m.Name == "openrouter:vendor/big", "unexpected name %q", m.Name)
+	Tassert(t, m.upstreamName == "vendor/big", "unexpected upstream name %q", m.upstreamName)
+	Tassert(t, m.TokenLimit == 200000, "unexpected token limit %d", m.TokenLimit)
+	Tassert(t, m.PromptPrice > 2.99 && m.PromptPrice < 3.01, "unexpected prompt price %f", m.PromptPrice)
+	Tassert(t, m.CompletionPrice > 14.99 && m.CompletionPrice < 15.01, "unexpected completion price %f", m.CompletionPrice)
+
+	// models without metadata get a conservative token limit
+	m = p.newModel(entries[1])
+	Tassert(t, m.TokenLimit == 8192, "unexpected token limit %d", m.TokenLimit)
+	Tassert(t, m.PromptPrice == 0, "unexpected prompt price %f", m.PromptPrice)
+
+	provider, id := splitModelName("openrouter:vendor/big")
+	Tassert(t, provider == "openrouter" && id == "vendor/big", "unexpected split %q %q", provider, id)
+	provider, id = splitModelName("gpt-4o")
+	Tassert(t, provider == "" && id == "gpt-4o", "unexpected split %q %q", provider, id)
+}
+
+func TestProviderLimits(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	fetches := 0
+	limit := 32768
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		Tassert(t, r.URL.Path == "/v1/models", "unexpected path %q", r.URL.Path)
+		w.Write([]byte(Spf(`{"data": [
+			{"id": "groq", "context_window": 131072},
+			{"id": "llama", "max_model_len": %d},
+			{"id": "gguf", "meta": {"n_ctx_train": 4096}},
+			{"id": "routed", "top_provider": {"context_length": 65536}}
+		]}`, limit)))
+	}))
+	defer srv.Close()
+	p := &Provider{"compat", srv.URL + "/v1", ""}
+	limits := func() map[string]int {
+		pms, err := p.Models()
+		Ck(err)
+		limits := make(map[string]int)
+		for _, m := range pms {
+			limits[m.upstreamName] = m.TokenLimit
+		}
+		return limits
+	}
+	got := limits()
+	Tassert(t, got["groq"] == 131072 && got["llama"] == 32768 && got["gguf"] == 4096 && got["routed"] == 65536, "got %v", got)
+	// the list is cached
+	got = limits()
+	Tassert(t, fetches == 1 && got["llama"] == 32768, "got %d fetches", fetches)
+
+	// an expired list is used while it is fetched again in the
+	// background
+	limit = 65536
+	old := time.Now().Add(-2 * modelCacheTTL)
+	err := os.Chtimes(p.cachePath(), old, old)
+	Ck(err)
+	got = limits()
+	Tassert(t, got["llama"] == 32768, "expected the cached limit, got %v", got)
+	modelRefreshes.Wait()
+	got = limits()
+	Tassert(t, fetches == 2 && got["llama"] == 65536, "expected the refreshed limit, got %d fetches, %v", fetches, got)
+
+	// a list that can't be fetched isn't cached
+	srv.Close()
+	p.Name = "down"
+	_, err = p.Models()
+	Tassert(t, err != nil, "expected an error")
+	_, err = os.Stat(p.cachePath())
+	Tassert(t, os.IsNotExist(err), "expected no cached list after a failure")
+	// nor does it replace an expired one
+	p.Name = "compat"
+	err = os.Chtimes(p.cachePath(), old, old)
+	Ck(err)
+	got = limits()
+	modelRefreshes.Wait()
+	Tassert(t, got["llama"] == 65536, "got %v", got)
+	fi, err := os.Stat(p.cachePath())
+	Tassert(t, err == nil && fi.ModTime().Equal(old), "expected the expired list to stay: %v", err)
+}
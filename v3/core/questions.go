@@ -0,0 +1,358 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The question log records every question answered from the
+// knowledge base, with the sources retrieved for it and the answer,
+// so users can rate answers with 'grok feedback' and 'grok eval' can
+// check that retrieval still finds what it found for the good ones.
+//
+// The log is a pair of JSON-lines files in a .grok-questions
+// directory next to the database: log.jsonl holds the questions and
+// feedback.jsonl the ratings.  Both are only ever appended to; the
+// latest rating of a question wins.  Nothing is logged for a
+// read-only knowledge base, or with "question_log: false" in the
+// config file.
+
+// Ratings for Feedback.
+const (
+	RatingGood = "good"
+	RatingBad  = "bad"
+)
+
+// Question is an entry in the question log.
+type Question struct {
+	ID       int
+	Time     time.Time
+	Model    string
+	Question string
+	// the filter the question was asked with, if any
+	Filter  *Filter `json:",omitempty"`
+	Sources []string
+	Answer  string
+	// The latest feedback, if any; see Feedback.
+	Rating string `json:",omitempty"`
+	Note   string `json:",omitempty"`
+}
+
+// feedback is an entry in the feedback file.
+type feedback struct {
+	ID     int
+	Time   time.Time
+	Rating string
+	Note   string `json:",omitempty"`
+}
+
+// questionsDir returns the directory of the question log, or an
+// empty string if the db has no file, e.g. in tests.
+func (g *Grokker) questionsDir() string {
+	if g.grokpath == "" {
+		return ""
+	}
+	return g.grokpath + "-questions"
+}
+
+// logQuestion appends a question to the question log.
+func (g *Grokker) logQuestion(question string, sources []string, answer string) (err error) {
+	defer Return(&err)
+	dir := g.questionsDir()
+	if dir == "" || g.noQuestionLog || g.readOnly {
+		return
+	}
+	cfg, err := LoadConfig()
+	Ck(err)
+	if cfg.QuestionLog != nil && !*cfg.QuestionLog {
+		return
+	}
+	if g.questionLast == nil {
+		// find the end of the existing log
+		qs, err := g.Questions()
+		Ck(err)
+		g.questionLast = &Question{}
+		if len(qs) > 0 {
+			g.questionLast = qs[len(qs)-1]
+		}
+	}
+	q := &Question{
+		ID:       g.questionLast.ID + 1,
+		Time:     time.Now(),
+		Model:    g.Model,
+		Question: question,
+		Filter:   g.filter,
+		Sources:  sources,
+		Answer:   answer,
+	}
+	err = appendJSONL(filepath.Join(dir, "log.jsonl"), q)
+	Ck(err)
+	g.questionLast = q
+	return
+}
+
+// LastQuestion returns the ID of the question most recently logged
+// by this process, or 0 if there isn't one.
+func (g *Grokker) LastQuestion() int {
+	if g.questionLast == nil {
+		return 0
+	}
+	return g.questionLast.ID
+}
+
+// Feedback rates the answer to a logged question as RatingGood or
+// RatingBad, with an optional note.
+func (g *Grokker) Feedback(id int, rating, note string) (err error) {
+	defer Return(&err)
+	if rating != RatingGood && rating != RatingBad {
+		err = fmt.Errorf("unknown rating %q; use %s or %s", rating, RatingGood, RatingBad)
+		return
+	}
+	qs, err := g.Questions()
+	Ck(err)
+	found := false
+	for _, q := range qs {
+		if q.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = fmt.Errorf("no question %d in the question log", id)
+		return
+	}
+	fb := &feedback{ID: id, Time: time.Now(), Rating: rating, Note: note}
+	err = appendJSONL(filepath.Join(g.questionsDir(), "feedback.jsonl"), fb)
+	Ck(err)
+	return
+}
+
+// Questions returns the logged questions, oldest first, with their
+// latest feedback.
+func (g *Grokker) Questions() (qs []*Question, err error) {
+	defer Return(&err)
+	dir := g.questionsDir()
+	if dir == "" {
+		return
+	}
+	byID := make(map[int]*Question)
+	err = readJSONL(filepath.Join(dir, "log.jsonl"), func(buf []byte) error {
+		q := &Question{}
+		err := json.Unmarshal(buf, q)
+		if err == nil {
+			qs = append(qs, q)
+			byID[q.ID] = q
+		}
+		return err
+	})
+	Ck(err)
+	err = readJSONL(filepath.Join(dir, "feedback.jsonl"), func(buf []byte) error {
+		fb := &feedback{}
+		err := json.Unmarshal(buf, fb)
+		if q, ok := byID[fb.ID]; ok && err == nil {
+			q.Rating = fb.Rating
+			q.Note = fb.Note
+		}
+		return err
+	})
+	Ck(err)
+	return
+}
+
+// PreviousAnswer returns the most recent entry in the question log
+// for the question, or the most recent entry of all if question is
+// empty.
+func (g *Grokker) PreviousAnswer(question string) (q *Question, err error) {
+	defer Return(&err)
+	qs, err := g.Questions()
+	Ck(err)
+	question = strings.TrimSpace(question)
+	for i := len(qs) - 1; i >= 0; i-- {
+		if question == "" || strings.TrimSpace(qs[i].Question) == question {
+			return qs[i], nil
+		}
+	}
+	if question == "" {
+		err = fmt.Errorf("the question log is empty")
+	} else {
+		err = fmt.Errorf("%q is not in the question log", question)
+	}
+	return
+}
+
+// scrubQuestions drops the questions whose sources include any of
+// the given documents from the question log.
+func (g *Grokker) scrubQuestions(gone map[string]bool) (err error) {
+	defer Return(&err)
+	dir := g.questionsDir()
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, "log.jsonl")
+	var keep [][]byte
+	dropped := 0
+	err = readJSONL(path, func(buf []byte) error {
+		q := &Question{}
+		err := json.Unmarshal(buf, q)
+		if err != nil {
+			return err
+		}
+		for _, src := range q.Sources {
+			if gone[citePath(src)] {
+				dropped++
+				return nil
+			}
+		}
+		keep = append(keep, append([]byte(nil), buf...))
+		return nil
+	})
+	Ck(err)
+	if dropped == 0 {
+		return
+	}
+	Debug("dropping %d questions from the question log", dropped)
+	var out bytes.Buffer
+	for _, buf := range keep {
+		out.Write(buf)
+		out.WriteByte('\n')
+	}
+	tmpfn := path + ".tmp"
+	err = os.WriteFile(tmpfn, out.Bytes(), 0600)
+	Ck(err)
+	err = os.Rename(tmpfn, path)
+	Ck(err)
+	return
+}
+
+// appendJSONL appends v to a JSON-lines file, creating the file and
+// its directory if needed.
+func appendJSONL(path string, v interface{}) (err error) {
+	defer Return(&err)
+	buf, err := json.Marshal(v)
+	Ck(err)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	Ck(err)
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	Ck(err)
+	defer fh.Close()
+	_, err = fh.Write(append(buf, '\n'))
+	Ck(err)
+	return
+}
+
+// readJSONL calls fn with each line of a JSON-lines file.  A missing
+// file has no lines.
+func readJSONL(path string, fn func(buf []byte) error) (err error) {
+	defer Return(&err)
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		err = fn(scanner.Bytes())
+		Ck(err, "%s:%d", path, line)
+	}
+	err = scanner.Err()
+	Ck(err)
+	return
+}
+
+// EvalResult is the outcome of replaying a rated question.
+type EvalResult struct {
+	Question *Question
+	// Want are the documents the logged answer used, and Got the
+	// documents retrieval finds now.
+	Want []string
+	Got  []string
+	// Recall is the fraction of Want that is in Got.
+	Recall float64
+}
+
+// Regressed returns true if retrieval no longer finds all of the
+// documents behind a good answer.
+func (r EvalResult) Regressed() bool {
+	return r.Question.Rating == RatingGood && r.Recall < 1
+}
+
+// Changed returns true if retrieval finds different documents than
+// it did when the question was logged.
+func (r EvalResult) Changed() bool {
+	return r.Recall < 1 || len(r.Got) != len(r.Want)
+}
+
+// Eval replays the retrieval for each rated question in the question
+// log and compares the documents found with the ones the logged
+// answer used.  Good answers should keep their documents; bad
+// answers are reported so a change in retrieval can be reviewed.
+// Only retrieval is replayed, with the filter each question was
+// asked with; no answers are generated.
+func (g *Grokker) Eval() (results []EvalResult, err error) {
+	defer Return(&err)
+	qs, err := g.Questions()
+	Ck(err)
+	defer g.SetFilter(g.filter)
+	for _, q := range qs {
+		if q.Rating == "" {
+			continue
+		}
+		g.SetFilter(q.Filter)
+		_, err = g.answerContext(q.Question, false, false)
+		Ck(err)
+		res := EvalResult{Question: q, Want: sourceDocs(q.Sources), Got: sourceDocs(g.sources), Recall: 1}
+		if len(res.Want) > 0 {
+			found := 0
+			for _, doc := range res.Want {
+				for _, got := range res.Got {
+					if got == doc {
+						found++
+						break
+					}
+				}
+			}
+			res.Recall = float64(found) / float64(len(res.Want))
+		}
+		results = append(results, res)
+	}
+	return
+}
+
+// sourceDocs returns the distinct document paths of citations, in
+// order.  Lines move as documents are edited, so evals compare
+// documents.
+func sourceDocs(sources []string) (docs []string) {
+	seen := make(map[string]bool)
+	for _, cite := range sources {
+		doc := citePath(cite)
+		if !seen[doc] {
+			seen[doc] = true
+			docs = append(docs, doc)
+		}
+	}
+	return
+}
+
+// String returns a one-line summary of the question.
+func (q *Question) String() string {
+	rating := q.Rating
+	if rating == "" {
+		rating = "-"
+	}
+	text := strings.Join(strings.Fields(q.Question), " ")
+	if len(text) > 60 {
+		text = text[:57] + "..."
+	}
+	return Spf("%d\t%s\t%s\t%s", q.ID, q.Time.Format(time.RFC3339), rating, text)
+}
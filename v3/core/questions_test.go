@@ -0,0 +1,96 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestQuestions(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	Tassert(t, grok.LastQuestion() == 0, "unexpected last question %d", grok.LastQuestion())
+
+	err = grok.logQuestion("what is a chunk?", []string{"chunk.go:10", "chunk.go:40", "README.md:3"}, "a piece of a document")
+	Tassert(t, err == nil, "error logging question: %v", err)
+	grok.SetFilter(&Filter{Tags: []string{"public"}})
+	err = grok.logQuestion("what is a filter?", []string{"filter.go:1"}, "it limits context")
+	Tassert(t, err == nil, "error logging question: %v", err)
+	Tassert(t, grok.LastQuestion() == 2, "unexpected last question %d", grok.LastQuestion())
+
+	err = grok.Feedback(1, "meh", "")
+	Tassert(t, err != nil, "expected error for an unknown rating")
+	err = grok.Feedback(3, RatingGood, "")
+	Tassert(t, err != nil, "expected error for an unknown question")
+	err = grok.Feedback(1, RatingBad, "")
+	Tassert(t, err == nil, "error giving feedback: %v", err)
+	err = grok.Feedback(1, RatingGood, "cites the code")
+	Tassert(t, err == nil, "error giving feedback: %v", err)
+
+	// a new process picks up the numbering where the log left off
+	grok.questionLast = nil
+	err = grok.logQuestion("why?", nil, "because")
+	Tassert(t, err == nil, "error logging question: %v", err)
+	Tassert(t, grok.LastQuestion() == 3, "unexpected last question %d", grok.LastQuestion())
+
+	prev, err := grok.PreviousAnswer("")
+	Tassert(t, err == nil && prev.ID == 3, "unexpected previous answer %v %v", prev, err)
+	prev, err = grok.PreviousAnswer(" what is a chunk?")
+	Tassert(t, err == nil && prev.ID == 1, "unexpected previous answer %v %v", prev, err)
+	_, err = grok.PreviousAnswer("who?")
+	Tassert(t, err != nil, "expected error for a question that wasn't asked")
+
+	qs, err := grok.Questions()
+	Tassert(t, err == nil, "error reading questions: %v", err)
+	Tassert(t, len(qs) == 3, "expected 3 questions, got %d", len(qs))
+	Tassert(t, qs[0].Rating == RatingGood && qs[0].Note == "cites the code", "the latest feedback should win: %+v", qs[0])
+	Tassert(t, qs[1].Rating == "" && qs[1].Filter != nil && qs[1].Filter.Tags[0] == "public", "unexpected question %+v", qs[1])
+
+	// evals compare documents, not lines
+	docs := sourceDocs(qs[0].Sources)
+	Tassert(t, strings.Join(docs, ",") == "chunk.go,README.md", "unexpected docs %v", docs)
+	res := EvalResult{Question: qs[0], Want: docs, Got: []string{"chunk.go"}, Recall: 0.5}
+	Tassert(t, res.Regressed() && res.Changed(), "expected a regression")
+	res.Question = &Question{Rating: RatingBad}
+	Tassert(t, !res.Regressed() && res.Changed(), "bad answers don't regress")
+	res = EvalResult{Question: qs[0], Want: docs, Got: []string{"README.md", "chunk.go"}, Recall: 1}
+	Tassert(t, !res.Regressed() && !res.Changed(), "expected no change")
+}
+
+func TestQuestionLogOff(t *testing.T) {
+	cfgDir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG", filepath.Join(cfgDir, "config.yaml"))
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+
+	// nothing is logged for a read-only knowledge base
+	grok.SetReadOnly()
+	err = grok.logQuestion("what is a chunk?", nil, "a piece")
+	Tassert(t, err == nil && grok.LastQuestion() == 0, "expected nothing logged, got %d %v", grok.LastQuestion(), err)
+
+	// or when the config says not to
+	grok.readOnly = false
+	err = os.WriteFile(filepath.Join(cfgDir, "config.yaml"), []byte("question_log: false\n"), 0644)
+	Tassert(t, err == nil, "error writing config: %v", err)
+	err = grok.logQuestion("what is a chunk?", nil, "a piece")
+	Tassert(t, err == nil && grok.LastQuestion() == 0, "expected nothing logged, got %d %v", grok.LastQuestion(), err)
+	_, err = os.Stat(grok.questionsDir())
+	Tassert(t, os.IsNotExist(err), "expected no question log")
+}
+
+func TestCitePath(t *testing.T) {
+	for cite, want := range map[string]string{
+		"docs/a.md:12":           "docs/a.md",
+		"pets.yaml (POST /pets)": "pets.yaml",
+		"app.log (log 2024-03-01 14:05 to 2024-03-01 14:10)": "app.log",
+		"a.go":                       "a.go",
+		"https://example.com/page":   "https://example.com/page",
+		"https://example.com/page:3": "https://example.com/page",
+	} {
+		got := citePath(cite)
+		Tassert(t, got == want, "%q: expected %q, got %q", cite, want, got)
+	}
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A chunk's Hash is the sha256 of its path and text, so it names the
+// exact snippet and stays the same across refreshes, saves, and
+// copies of the database.  External systems such as issue trackers
+// and review tools can store it as a chunk ID and fetch the snippet
+// again later with ChunkByID.  Once the document changes so that the
+// snippet is gone, the ID no longer resolves.
+
+// minChunkIDPrefix is the shortest abbreviation of a chunk ID that
+// ChunkByID accepts.
+const minChunkIDPrefix = 8
+
+// ChunkRef is a chunk as seen by an external system.
+type ChunkRef struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// Line is the line the chunk starts on, or for a rendered
+	// document its section's, or 0 if it can't be known; see
+	// rawChunkText.
+	Line int `json:"line"`
+	// Section is the chunk's section, e.g. a markdown heading or an
+	// API operation.
+	Section    string   `json:"section,omitempty"`
+	Collection string   `json:"collection"`
+	Tags       []string `json:"tags,omitempty"`
+	Text       string   `json:"text"`
+}
+
+// matches returns true if text, read with its header, is still the
+// text the chunk was created from.
+func (c *Chunk) matches(text string) bool {
+	sum := sha256.Sum256([]byte(text))
+	return text != "" && hex.EncodeToString(sum[:]) == c.Hash
+}
+
+// ChunkByID returns the chunk with the given ID, or a unique prefix
+// of at least 8 characters of it.  It returns false if no chunk has
+// the ID or the chunk's document has changed since.  An ID doesn't
+// say which shard the chunk is in, so all of them are loaded.
+func (g *Grokker) ChunkByID(id string) (ref *ChunkRef, ok bool, err error) {
+	defer Return(&err)
+	id = strings.ToLower(strings.TrimSpace(id))
+	if len(id) < minChunkIDPrefix {
+		err = fmt.Errorf("chunk ID %q is too short; use at least %d characters", id, minChunkIDPrefix)
+		return
+	}
+	err = g.LoadShards()
+	Ck(err)
+	var found *Chunk
+	for _, c := range g.Chunks {
+		if c.Document == nil || !strings.HasPrefix(c.Hash, id) {
+			continue
+		}
+		if found != nil && found.Hash != c.Hash {
+			err = fmt.Errorf("chunk ID %q is ambiguous", id)
+			return
+		}
+		found = c
+	}
+	if found == nil {
+		return
+	}
+	return g.chunkRef(found)
+}
+
+// chunkRef returns a chunk as seen by an external system.  It returns
+// false if the chunk's document has changed since it was indexed.
+func (g *Grokker) chunkRef(c *Chunk) (ref *ChunkRef, ok bool, err error) {
+	defer Return(&err)
+	text, err := g.chunkText(c, true, false)
+	Ck(err)
+	if !c.matches(text) {
+		return
+	}
+	text, err = g.chunkText(c, false, false)
+	Ck(err)
+	line := c.Line
+	if c.Text == "" {
+		_, line, err = g.rawChunkText(c)
+		Ck(err)
+	}
+	relpath := c.Document.RelPath
+	coll, _ := g.DocumentCollection(relpath)
+	tags, _ := g.DocumentTags(relpath)
+	ref = &ChunkRef{
+		ID:         c.Hash,
+		Path:       relpath,
+		Line:       line,
+		Section:    c.Section,
+		Collection: coll,
+		Tags:       tags,
+		Text:       text,
+	}
+	return ref, true, nil
+}
+
+// SourceIDs returns the IDs of the chunks used as context by the
+// most recent query.  See ChunkByID.
+func (g *Grokker) SourceIDs() []string {
+	return g.sourceIDs
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestChunkByID(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello\nworld"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "a.md", Collection: "docs", Tags: []string{"internal"}}
+	grok.Documents = append(grok.Documents, doc)
+	hello := newChunk(doc, "", 0, 5, "hello")
+	world := newChunk(doc, "", 6, 5, "world")
+	grok.Chunks = []*Chunk{hello, world}
+
+	ref, ok, err := grok.ChunkByID(world.Hash[:12])
+	Tassert(t, err == nil && ok, "expected to find the chunk: %v", err)
+	Tassert(t, ref.ID == world.Hash && ref.Text == "world" && ref.Line == 2, "unexpected ref %#v", ref)
+	Tassert(t, ref.Collection == "docs" && len(ref.Tags) == 1, "unexpected ref %#v", ref)
+
+	_, _, err = grok.ChunkByID("abc")
+	Tassert(t, err != nil, "expected an error for a short id")
+
+	// the id stops resolving when the snippet changes
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("hello\nthere"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	_, ok, err = grok.ChunkByID(world.Hash)
+	Tassert(t, err == nil && !ok, "expected no chunk: %v", err)
+	_, ok, err = grok.ChunkByID(hello.Hash)
+	Tassert(t, err == nil && ok, "expected the unchanged chunk: %v", err)
+}
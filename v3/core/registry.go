@@ -0,0 +1,170 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"gopkg.in/yaml.v3"
+)
+
+// The registry is the user's list of named knowledge bases, so a
+// command can be run from any directory against a named project:
+//
+//	grok db add work ~/src/work
+//	grok db use work
+//	grok q "where is the retry logic?"
+//
+// It is kept in databases.yaml in ConfigDir(), separate from
+// config.yaml so that grok can rewrite it without touching the
+// user's hand-written config.
+
+// Registry maps names to database files.
+type Registry struct {
+	// Current is the database used when there is no .grok file
+	// in the current or any parent directory.
+	Current string `yaml:"current,omitempty"`
+	// Databases maps names to absolute paths of .grok files.
+	Databases map[string]string `yaml:"databases"`
+}
+
+// RegistryPath returns the path of the registry file, or an empty
+// string if there is no user config directory.
+func RegistryPath() string {
+	dir := ConfigDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "databases.yaml")
+}
+
+// LoadRegistry reads the registry.  A missing file yields an empty
+// registry.
+func LoadRegistry() (r *Registry, err error) {
+	defer Return(&err)
+	r = &Registry{Databases: make(map[string]string)}
+	path := RegistryPath()
+	if path == "" {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	err = yaml.Unmarshal(buf, r)
+	Ck(err, "%s", path)
+	if r.Databases == nil {
+		r.Databases = make(map[string]string)
+	}
+	return
+}
+
+// Save writes the registry.
+func (r *Registry) Save() (err error) {
+	defer Return(&err)
+	path := RegistryPath()
+	Assert(path != "", "no user config directory for the database registry")
+	buf, err := yaml.Marshal(r)
+	Ck(err)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	Ck(err)
+	tmpfn := path + ".tmp"
+	err = os.WriteFile(tmpfn, buf, 0644)
+	Ck(err)
+	err = os.Rename(tmpfn, path)
+	Ck(err)
+	return
+}
+
+// Names returns the registered names, sorted.
+func (r *Registry) Names() (names []string) {
+	for name := range r.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Add registers the database at path under name.  The path may be a
+// .grok file or the directory that contains it.
+func (r *Registry) Add(name, path string) (err error) {
+	defer Return(&err)
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		err = fmt.Errorf("invalid database name %q", name)
+		return
+	}
+	path, err = filepath.Abs(path)
+	Ck(err)
+	fi, err := os.Stat(path)
+	Ck(err)
+	if fi.IsDir() {
+		path = filepath.Join(path, ".grok")
+		_, err = os.Stat(path)
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("no .grok file in %s; run 'grok init' there first", filepath.Dir(path))
+			return
+		}
+		Ck(err)
+	}
+	r.Databases[name] = path
+	return
+}
+
+// Use makes name the current database.
+func (r *Registry) Use(name string) (err error) {
+	_, ok := r.Databases[name]
+	if !ok {
+		return fmt.Errorf("no database named %q; see 'grok db list'", name)
+	}
+	r.Current = name
+	return
+}
+
+// Remove forgets name.  The database itself is untouched.
+func (r *Registry) Remove(name string) (err error) {
+	_, ok := r.Databases[name]
+	if !ok {
+		return fmt.Errorf("no database named %q", name)
+	}
+	delete(r.Databases, name)
+	if r.Current == name {
+		r.Current = ""
+	}
+	return
+}
+
+// FindDB returns the path of the database to use.  If name is set,
+// it is looked up in the registry.  Otherwise, the .grok file in the
+// current or nearest parent directory is used, falling back to the
+// registry's current database.
+func FindDB(name string) (grokpath string, err error) {
+	defer Return(&err)
+	if name == "" {
+		for level := 0; level < 99; level++ {
+			path := strings.Repeat("../", level) + ".grok"
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	r, err := LoadRegistry()
+	Ck(err)
+	if name == "" {
+		name = r.Current
+		if name == "" {
+			err = classify(FailNoDB, fmt.Errorf("no .grok file found in the current or any parent directory; run 'grok init', or pick a database with 'grok db use'"))
+			return
+		}
+	}
+	grokpath, ok := r.Databases[name]
+	if !ok {
+		err = classify(FailNoDB, fmt.Errorf("no database named %q; see 'grok db list'", name))
+		return
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG_DIR", filepath.Join(dir, "config"))
+	proj := filepath.Join(dir, "proj")
+	err := os.MkdirAll(proj, 0755)
+	Ck(err)
+	grokpath := filepath.Join(proj, ".grok")
+
+	r, err := LoadRegistry()
+	Tassert(t, err == nil && len(r.Databases) == 0, "expected an empty registry, got %v %v", r, err)
+	err = r.Add("proj", proj)
+	Tassert(t, err != nil, "expected an error adding a directory without a .grok file")
+	err = os.WriteFile(grokpath, []byte("{}"), 0644)
+	Ck(err)
+	err = r.Add("proj", proj)
+	Tassert(t, err == nil, "error adding: %v", err)
+	err = r.Add("a/b", proj)
+	Tassert(t, err != nil, "expected an error for a name with a slash")
+	err = r.Use("nope")
+	Tassert(t, err != nil, "expected an error using an unknown name")
+	err = r.Use("proj")
+	Tassert(t, err == nil, "error using: %v", err)
+	err = r.Save()
+	Tassert(t, err == nil, "error saving: %v", err)
+
+	r, err = LoadRegistry()
+	Tassert(t, err == nil, "error loading: %v", err)
+	Tassert(t, r.Current == "proj" && r.Databases["proj"] == grokpath, "unexpected registry %+v", r)
+
+	// outside any project, the current database is used; a name
+	// always wins
+	cwd, err := os.Getwd()
+	Ck(err)
+	defer os.Chdir(cwd)
+	err = os.Chdir(dir)
+	Ck(err)
+	path, err := FindDB("")
+	Tassert(t, err == nil && path == grokpath, "expected %s, got %q %v", grokpath, path, err)
+	path, err = FindDB("proj")
+	Tassert(t, err == nil && path == grokpath, "expected %s, got %q %v", grokpath, path, err)
+	_, err = FindDB("nope")
+	Tassert(t, err != nil, "expected an error for an unknown name")
+
+	err = r.Remove("proj")
+	Tassert(t, err == nil && r.Current == "", "unexpected remove result %+v %v", r, err)
+	err = r.Save()
+	Ck(err)
+	_, err = FindDB("")
+	Tassert(t, err != nil, "expected an error with no current database")
+}
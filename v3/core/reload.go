@@ -0,0 +1,89 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A knowledge base in use, e.g. by 'grok serve', can be replaced by a
+// newly built one without stopping: CI builds the index elsewhere
+// and exports it with 'grok export', and the server stages the
+// export and swaps it in.  Staging does the slow work while the old
+// knowledge base keeps answering: it reads the export, checks its
+// checksums and, given keys, its signature, writes the new db file
+// beside the old one, and sets up the new Grokker.  Install then only
+// renames the file into place, so the caller can swap the two
+// Grokkers with its requests held for no longer than that.
+
+// Replacement is a knowledge base staged to replace another.
+type Replacement struct {
+	// G is the new knowledge base.  It has the old one's db path,
+	// root, and model override; other settings, such as SetCaches,
+	// are the caller's to apply.
+	G *Grokker
+	// TrustedComment is the trusted comment of the export's
+	// signature, if it was checked.
+	TrustedComment string
+	staged         string
+}
+
+// StageReplacement stages the export at path to replace g.  If keys
+// is not empty, the export must be signed by one of them, as for
+// Import.
+func (g *Grokker) StageReplacement(path string, keys []PublicKey) (r *Replacement, err error) {
+	defer Return(&err)
+	ng, comment, err := readExport(path, keys)
+	Ck(err)
+	// the export has all the chunks, from any shards and store, in
+	// the one file
+	ng.Store = ""
+	ng.Shards = nil
+	ng.grokpath = g.grokpath
+	ng.Root = g.Root
+	data, err := json.Marshal(ng)
+	Ck(err)
+	staged := g.grokpath + ".staged"
+	err = os.WriteFile(staged, data, 0644)
+	Ck(err)
+	defer func() {
+		if err != nil {
+			os.Remove(staged)
+		}
+	}()
+	ng.markSaved()
+	_, _, _, err = ng.migrate()
+	Ck(err)
+	ng.modelFromDb = ng.Model
+	model := ng.Model
+	if g.modelOverride {
+		model = g.Model
+		ng.modelOverride = true
+	}
+	err = ng.Setup(model)
+	Ck(err)
+	r = &Replacement{G: ng, TrustedComment: comment, staged: staged}
+	return
+}
+
+// Install puts the staged db file in place of the old one.  The old
+// Grokker must not be saved after this.
+func (r *Replacement) Install() (err error) {
+	defer Return(&err)
+	// the old journal holds changes to the old chunks; if we stop
+	// between the two steps, losing them is harmless
+	err = os.Remove(r.G.journalPath())
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	Ck(err)
+	err = os.Rename(r.staged, r.G.grokpath)
+	Ck(err)
+	return
+}
+
+// Discard removes the staged db file.
+func (r *Replacement) Discard() {
+	os.Remove(r.staged)
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestStageReplacement(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Length: 1, Hash: "h0", Embedding: []float64{0.5}})
+	err = grok.Save()
+	Ck(err)
+	_, err = os.Stat(grok.journalPath())
+	Tassert(t, err == nil, "expected a journal: %v", err)
+
+	// the new index, built elsewhere
+	build := TmpTestDir()
+	built, err := Init(build, "gpt-3.5-turbo")
+	Ck(err)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		doc := &Document{RelPath: name}
+		built.Documents = append(built.Documents, doc)
+		built.Chunks = append(built.Chunks, &Chunk{Document: doc, Length: 1, Hash: "h" + name, Embedding: []float64{0.5}})
+	}
+	pub, sec, err := GenerateKey()
+	Ck(err)
+	other, _, err := GenerateKey()
+	Ck(err)
+	fn := filepath.Join(build, "kb.grok")
+	err = built.Export(fn, &sec)
+	Ck(err)
+
+	_, err = grok.StageReplacement(fn, []PublicKey{other})
+	Tassert(t, err != nil && strings.Contains(err.Error(), "unknown key"), "expected a signature error, got %v", err)
+	_, err = os.Stat(grok.grokpath + ".staged")
+	Tassert(t, os.IsNotExist(err), "expected no staged file after an error")
+
+	r, err := grok.StageReplacement(fn, []PublicKey{pub})
+	Tassert(t, err == nil, "error staging: %v", err)
+	Tassert(t, strings.Contains(r.TrustedComment, "grok export"), "got %q", r.TrustedComment)
+	Tassert(t, r.G.Root == grok.Root && len(r.G.Chunks) == 2, "unexpected replacement %s %d", r.G.Root, len(r.G.Chunks))
+	// the old knowledge base is untouched until Install
+	g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", true)
+	Ck(err)
+	lock.Unlock()
+	Tassert(t, len(g.Documents) == 1, "expected the old db, got %d documents", len(g.Documents))
+
+	err = r.Install()
+	Tassert(t, err == nil, "error installing: %v", err)
+	_, err = os.Stat(grok.journalPath())
+	Tassert(t, os.IsNotExist(err), "expected the old journal to be removed")
+	g, _, _, _, lock, err = LoadFrom(grok.grokpath, "", true)
+	Ck(err)
+	lock.Unlock()
+	Tassert(t, len(g.Documents) == 2 && len(g.Chunks) == 2 && g.Root == dir, "unexpected db %s %d %d", g.Root, len(g.Documents), len(g.Chunks))
+}
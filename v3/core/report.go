@@ -0,0 +1,333 @@
+package core
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A knowledge base grows by whatever gets added to it, and it's easy
+// to lose track of what it's made of.  'grok report' writes a
+// markdown health report: how big the chunks are, which documents
+// are out of date, which passages are duplicated, how the index is
+// spread over the top-level directories, and what to do about it,
+// e.g. "vendor/ is 60% of your index".
+
+// reportTokenBuckets are the upper bounds of the buckets of the
+// chunk token histogram; the last bucket has no upper bound.
+var reportTokenBuckets = []int{32, 64, 128, 256, 512, 1024, 2048}
+
+// reportDominantShare is the share of the index's tokens above which
+// a directory is called out in the recommendations.
+const reportDominantShare = 0.4
+
+// reportSmallChunk is the token count below which a chunk carries
+// too little to be useful context.
+const reportSmallChunk = 32
+
+// vendoredDirs are directory names that usually hold code or output
+// that isn't the project's own.
+var vendoredDirs = []string{"vendor", "node_modules", "third_party", "dist", "build", "target", ".venv"}
+
+// Report describes the content and health of a knowledge base.
+type Report struct {
+	Stats DBStats
+	// Tokens is the total token count of the chunks.
+	Tokens int
+	// ChunkBytes and ChunkTokens summarize the chunk sizes.
+	ChunkBytes  SizeSummary
+	ChunkTokens SizeSummary
+	// Histogram counts the chunks in each of reportTokenBuckets.
+	Histogram []HistogramBucket
+	// Changed are the documents whose files changed since they
+	// were indexed, and Missing those whose files are gone.
+	Changed []string
+	Missing []string
+	// Duplicates is nil if duplicates weren't looked for.
+	Duplicates []*Duplicate
+	// Directories are sorted by tokens, most first.
+	Directories []DirCoverage
+	// Recommendations are suggestions, most important first.
+	Recommendations []string
+}
+
+// SizeSummary summarizes a set of sizes.
+type SizeSummary struct {
+	Min    int
+	Median int
+	P95    int
+	Max    int
+	Mean   float64
+}
+
+// HistogramBucket counts the chunks with at most Max tokens, and
+// more than the previous bucket's Max; a zero Max has no bound.
+type HistogramBucket struct {
+	Max    int
+	Chunks int
+}
+
+// DirCoverage is how much of the index a top-level directory holds.
+type DirCoverage struct {
+	// Dir is the directory with a trailing slash, or "./" for the
+	// files in the root.
+	Dir       string
+	Documents int
+	Chunks    int
+	Tokens    int
+	// Share is the directory's fraction of the index's tokens.
+	Share float64
+}
+
+// summarize returns the summary of sizes.
+func summarize(sizes []int) (s SizeSummary) {
+	if len(sizes) == 0 {
+		return
+	}
+	sorted := append([]int(nil), sizes...)
+	sort.Ints(sorted)
+	total := 0
+	for _, n := range sorted {
+		total += n
+	}
+	s.Min = sorted[0]
+	s.Median = sorted[len(sorted)/2]
+	s.P95 = sorted[len(sorted)*95/100]
+	s.Max = sorted[len(sorted)-1]
+	s.Mean = float64(total) / float64(len(sorted))
+	return
+}
+
+// topDir returns the top-level directory of relpath.
+func topDir(relpath string) string {
+	dir, _, ok := strings.Cut(path.Clean(relpath), "/")
+	if !ok {
+		return "./"
+	}
+	return dir + "/"
+}
+
+// Report looks over the knowledge base and returns a report on it.
+// If dups is true, it also looks for duplicate passages at or above
+// threshold similarity, as Duplicates does, which takes a while on a
+// large knowledge base.
+func (g *Grokker) Report(dups bool, threshold float64) (r *Report, err error) {
+	defer Return(&err)
+	r = &Report{Stats: g.Stats()}
+
+	// documents
+	dirs := make(map[string]*DirCoverage)
+	dirOf := func(relpath string) *DirCoverage {
+		name := topDir(relpath)
+		d := dirs[name]
+		if d == nil {
+			d = &DirCoverage{Dir: name}
+			dirs[name] = d
+		}
+		return d
+	}
+	missing := make(map[string]bool)
+	for _, doc := range g.Documents {
+		dirOf(doc.RelPath).Documents++
+		info, err := os.Stat(g.absPath(doc))
+		if err != nil {
+			missing[doc.RelPath] = true
+			r.Missing = append(r.Missing, doc.RelPath)
+			continue
+		}
+		if doc.Indexed != nil && info.ModTime().After(*doc.Indexed) {
+			r.Changed = append(r.Changed, doc.RelPath)
+		}
+	}
+	sort.Strings(r.Changed)
+	sort.Strings(r.Missing)
+
+	// chunks
+	var bytes, tokens []int
+	r.Histogram = make([]HistogramBucket, len(reportTokenBuckets)+1)
+	for i, max := range reportTokenBuckets {
+		r.Histogram[i].Max = max
+	}
+	small := 0
+	for _, c := range g.Chunks {
+		if c.Document == nil || missing[c.Document.RelPath] {
+			continue
+		}
+		n, err := c.tokenCount(g, Tokenizer)
+		if err != nil {
+			// the file changed under the chunk; it's counted
+			// as a changed document
+			continue
+		}
+		bytes = append(bytes, c.Length)
+		tokens = append(tokens, n)
+		r.Tokens += n
+		if n < reportSmallChunk {
+			small++
+		}
+		i := sort.SearchInts(reportTokenBuckets, n)
+		r.Histogram[i].Chunks++
+		d := dirOf(c.Document.RelPath)
+		d.Chunks++
+		d.Tokens += n
+	}
+	r.ChunkBytes = summarize(bytes)
+	r.ChunkTokens = summarize(tokens)
+	for _, d := range dirs {
+		if r.Tokens > 0 {
+			d.Share = float64(d.Tokens) / float64(r.Tokens)
+		}
+		r.Directories = append(r.Directories, *d)
+	}
+	sort.Slice(r.Directories, func(i, j int) bool {
+		a, b := r.Directories[i], r.Directories[j]
+		if a.Tokens != b.Tokens {
+			return a.Tokens > b.Tokens
+		}
+		return a.Dir < b.Dir
+	})
+
+	if dups {
+		r.Duplicates, err = g.Duplicates(threshold)
+		Ck(err)
+		if r.Duplicates == nil {
+			r.Duplicates = []*Duplicate{}
+		}
+	}
+
+	// recommendations
+	rec := func(format string, args ...interface{}) {
+		r.Recommendations = append(r.Recommendations, Spf(format, args...))
+	}
+	for _, d := range r.Directories {
+		if d.Dir == "./" {
+			continue
+		}
+		name := strings.TrimSuffix(d.Dir, "/")
+		pct := int(d.Share*100 + 0.5)
+		switch {
+		case util.StringInSlice(name, vendoredDirs) && d.Share >= 0.1:
+			rec("%s is %d%% of your index.  It usually holds code that isn't yours; add it to .grokignore and run 'grok refresh' unless you ask about it.", d.Dir, pct)
+		case d.Share >= reportDominantShare && len(r.Directories) > 1:
+			rec("%s is %d%% of your index, so it will dominate the context.  If it's not what you ask about, add it to .grokignore or move it to its own collection.", d.Dir, pct)
+		}
+	}
+	if len(r.Missing) > 0 {
+		rec("%d documents no longer exist; run 'grok refresh' to forget them.", len(r.Missing))
+	}
+	if len(r.Changed) > 0 {
+		rec("%d documents changed since they were indexed; run 'grok refresh' to update them.", len(r.Changed))
+	}
+	if unembedded := r.Stats.Chunks - r.Stats.Embedded; unembedded > 0 {
+		rec("%d chunks have no embedding and are never retrieved; run 'grok refresh', or 'grok batch status' if a batch is pending.", unembedded)
+	}
+	if len(r.Duplicates) > 0 {
+		passages := 0
+		for _, dup := range r.Duplicates {
+			passages += len(dup.Passages)
+		}
+		rec("%d passages are duplicated across documents, in %d sets; consolidate them or forget the copies.", passages, len(r.Duplicates))
+	}
+	if len(tokens) > 0 && small*10 >= len(tokens) {
+		rec("%d%% of the chunks have fewer than %d tokens and carry little context; check 'grok stoplist' for boilerplate that should be excluded.", small*100/len(tokens), reportSmallChunk)
+	}
+	return
+}
+
+// Markdown returns the report as a markdown document.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	p := func(format string, args ...interface{}) {
+		b.WriteString(Spf(format, args...))
+	}
+	p("# Knowledge base report\n\n")
+	s := r.Stats
+	p("%d documents (%d virtual) in %d collections, %d chunks, %d tokens.  ", s.Documents, s.Virtual, s.Collections, s.Chunks, r.Tokens)
+	p("%d chunks are embedded and %d are excluded from context.\n\n", s.Embedded, s.Excluded)
+
+	p("## Recommendations\n\n")
+	if len(r.Recommendations) == 0 {
+		p("None; the knowledge base looks healthy.\n\n")
+	}
+	for _, rec := range r.Recommendations {
+		p("- %s\n", rec)
+	}
+	if len(r.Recommendations) > 0 {
+		p("\n")
+	}
+
+	p("## Chunk sizes\n\n")
+	p("| | min | median | p95 | max | mean |\n|---|---:|---:|---:|---:|---:|\n")
+	for _, row := range []struct {
+		name string
+		s    SizeSummary
+	}{{"bytes", r.ChunkBytes}, {"tokens", r.ChunkTokens}} {
+		p("| %s | %d | %d | %d | %d | %.0f |\n", row.name, row.s.Min, row.s.Median, row.s.P95, row.s.Max, row.s.Mean)
+	}
+	p("\n")
+
+	p("## Token histogram\n\n")
+	most := 0
+	for _, bucket := range r.Histogram {
+		if bucket.Chunks > most {
+			most = bucket.Chunks
+		}
+	}
+	p("| tokens | chunks | |\n|---|---:|---|\n")
+	prev := 0
+	for _, bucket := range r.Histogram {
+		label := Spf("%d-%d", prev+1, bucket.Max)
+		if prev == 0 {
+			label = Spf("<= %d", bucket.Max)
+		}
+		if bucket.Max == 0 {
+			label = Spf("> %d", prev)
+		}
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("#", (bucket.Chunks*40+most-1)/most)
+		}
+		p("| %s | %d | %s |\n", label, bucket.Chunks, bar)
+		prev = bucket.Max
+	}
+	p("\n")
+
+	p("## Coverage by directory\n\n")
+	p("| directory | documents | chunks | tokens | share |\n|---|---:|---:|---:|---:|\n")
+	for _, d := range r.Directories {
+		p("| %s | %d | %d | %d | %.1f%% |\n", d.Dir, d.Documents, d.Chunks, d.Tokens, d.Share*100)
+	}
+	p("\n")
+
+	p("## Stale documents\n\n")
+	if len(r.Changed)+len(r.Missing) == 0 {
+		p("None.\n\n")
+	}
+	for _, relpath := range r.Changed {
+		p("- %s (changed)\n", relpath)
+	}
+	for _, relpath := range r.Missing {
+		p("- %s (missing)\n", relpath)
+	}
+	if len(r.Changed)+len(r.Missing) > 0 {
+		p("\n")
+	}
+
+	if r.Duplicates != nil {
+		p("## Duplicate content\n\n")
+		if len(r.Duplicates) == 0 {
+			p("None.\n\n")
+		}
+		for _, dup := range r.Duplicates {
+			p("- %d passages, %.4f similar: %s\n", len(dup.Passages), dup.Similarity, strings.Join(dup.Passages, ", "))
+		}
+		if len(r.Duplicates) > 0 {
+			p("\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestReport(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.DedupThreshold = 2
+	files := map[string]string{
+		"README.md":        "# Project\n\nHow to build it.\n",
+		"docs/guide.md":    "# Guide\n\nStart the server with the serve command.\n",
+		"docs/old.md":      "# Old\n\nThis page is going away.\n",
+		"vendor/lib.js":    strings.Repeat("function f() { return 1; }\n", 40),
+		"vendor/copy.md":   "# Guide\n\nStart the server with the serve command.\n",
+		"vendor/other.txt": strings.Repeat("more vendored text, ", 60),
+	}
+	for fn, content := range files {
+		path := filepath.Join(dir, fn)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		Ck(err)
+		err = os.WriteFile(path, []byte(content), 0644)
+		Ck(err)
+		err = grok.AddDocument(path)
+		Ck(err)
+	}
+	err = os.Remove(filepath.Join(dir, "docs/old.md"))
+	Ck(err)
+	later := time.Now().Add(time.Hour)
+	err = os.Chtimes(filepath.Join(dir, "README.md"), later, later)
+	Ck(err)
+
+	r, err := grok.Report(true, 0.9999999)
+	Tassert(t, err == nil, "error reporting: %v", err)
+	Tassert(t, strings.Join(r.Missing, " ") == "docs/old.md", "got missing %v", r.Missing)
+	Tassert(t, strings.Join(r.Changed, " ") == "README.md", "got changed %v", r.Changed)
+	Tassert(t, len(r.Directories) == 3 && r.Directories[0].Dir == "vendor/" && r.Directories[0].Share > 0.5, "got %+v", r.Directories)
+	// the missing document's chunks aren't counted
+	guide := 0
+	for _, c := range grok.Chunks {
+		if c.Document.RelPath == "docs/guide.md" {
+			guide++
+		}
+	}
+	docs := r.Directories[1]
+	Tassert(t, docs.Dir == "docs/" && docs.Documents == 2 && docs.Chunks == guide, "got %+v", docs)
+	// the fake embeddings of long texts are alike, too
+	copied := false
+	for _, dup := range r.Duplicates {
+		passages := strings.Join(dup.Passages, " ")
+		copied = copied || strings.Contains(passages, "docs/guide.md") && strings.Contains(passages, "vendor/copy.md")
+	}
+	Tassert(t, copied, "expected the copied guide in %v", r.Duplicates)
+	chunks := 0
+	for _, b := range r.Histogram {
+		chunks += b.Chunks
+	}
+	Tassert(t, chunks < len(grok.Chunks) && r.ChunkTokens.Max > r.ChunkTokens.Min, "got %+v, %+v", r.Histogram, r.ChunkTokens)
+	Tassert(t, strings.HasPrefix(r.Recommendations[0], "vendor/ is "), "got %v", r.Recommendations)
+
+	md := r.Markdown()
+	for _, want := range []string{"## Recommendations", "## Token histogram", "| vendor/ |", "- docs/old.md (missing)", "## Duplicate content"} {
+		Tassert(t, strings.Contains(md, want), "expected %q in:\n%s", want, md)
+	}
+	r, err = grok.Report(false, 0)
+	Tassert(t, err == nil && !strings.Contains(r.Markdown(), "Duplicate"), "expected no duplicates section: %v", err)
+}
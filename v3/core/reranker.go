@@ -0,0 +1,41 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// localReranker scores how well each passage answers a query by
+// reading them together, as a cross-encoder does.
+type localReranker interface {
+	// score returns one relevance score per passage; higher is
+	// better.
+	score(query string, passages []string) ([]float64, error)
+	// spec returns the spec the reranker was created from.
+	spec() string
+}
+
+// initReranker loads the reranker named in the pipeline.
+func (g *Grokker) initReranker() (err error) {
+	defer Return(&err)
+	spec := g.Pipeline.Reranker
+	if spec == "" {
+		g.reranker = nil
+		return
+	}
+	if g.reranker != nil && g.reranker.spec() == spec {
+		return
+	}
+	kind, dir, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "onnx":
+		Assert(dir != "", "%s: onnx:<dir> requires a model directory", spec)
+		g.reranker, err = newOnnxReranker(spec, dir)
+		Ck(err)
+	default:
+		err = fmt.Errorf("unknown reranker %q", spec)
+	}
+	return
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Retrieve runs the retrieval half of Answer: it finds the passages
+// an answer to a question would use as context, ranked and scored,
+// without asking a chat model.  UIs use it to render their own
+// answers, or to let a person pick the sources before asking; see
+// POST /v1/sources in package serve.
+
+// SourceSpan is a passage retrieved for a question.
+type SourceSpan struct {
+	// ID is the chunk's ID; see ChunkByID.
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// StartLine and EndLine are the first and last lines of the
+	// passage in the document, counting from 1.  For a document
+	// rendered by a loader, both are the line its section starts
+	// on; for one transformed by a hook, both are 0.
+	StartLine  int      `json:"start_line"`
+	EndLine    int      `json:"end_line"`
+	Collection string   `json:"collection"`
+	Tags       []string `json:"tags,omitempty"`
+	// Score is how relevant the passage is: its similarity to the
+	// question, plus any boosts, or the reranker's score if the
+	// pipeline has one.  Scores compare passages of one question,
+	// not of different questions.
+	Score float64 `json:"score"`
+	// Snippet is the start of the passage's text.
+	Snippet string `json:"snippet"`
+}
+
+// Retrieve returns the passages that would be the context of an
+// answer to question, in the order Answer would use them, with at
+// most tokenLimit tokens in all; 0 means as many as Answer would use.
+// Snippets are cut to about snippetLen characters; 0 means the whole
+// passage.  Documents summarized by the pipeline are returned as
+// passages rather than summaries, and passages the injection check
+// drops are left out.
+func (g *Grokker) Retrieve(question string, tokenLimit, snippetLen int) (spans []SourceSpan, err error) {
+	defer Return(&err)
+	if tokenLimit <= 0 {
+		tokenLimit, err = g.answerTokens(question)
+		Ck(err)
+	}
+	chunks, err := g.findChunks(question, tokenLimit, nil)
+	Ck(err)
+	g.injectionFlags = nil
+	for _, c := range chunks {
+		if c.Document == nil {
+			continue
+		}
+		if g.Pipeline.InjectionCheck != "" {
+			flag, ok, err := g.checkChunk(c)
+			Ck(err)
+			if ok {
+				flag.Dropped = g.Pipeline.InjectionCheck == "drop"
+				g.injectionFlags = append(g.injectionFlags, flag)
+				if flag.Dropped {
+					continue
+				}
+			}
+		}
+		text, line, err := g.rawChunkText(c)
+		Ck(err)
+		if c.Text != "" {
+			text, line = c.Text, c.Line
+		}
+		relpath := c.Document.RelPath
+		coll, _ := g.DocumentCollection(relpath)
+		tags, _ := g.DocumentTags(relpath)
+		end := line + strings.Count(strings.TrimRight(text, "\n"), "\n")
+		if c.Document.Transformed {
+			// the text is rendered, so its lines aren't the
+			// document's
+			end = line
+		}
+		spans = append(spans, SourceSpan{
+			ID:         c.Hash,
+			Path:       relpath,
+			StartLine:  line,
+			EndLine:    end,
+			Collection: coll,
+			Tags:       tags,
+			Score:      g.chunkScores[c],
+			Snippet:    snippet(text, snippetLen),
+		})
+	}
+	return
+}
+
+// snippet returns text cut to about n characters at a word boundary,
+// or all of it if n is 0.
+func snippet(text string, n int) string {
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	if n <= 0 || len(runes) <= n {
+		return text
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndexAny(cut, " \t\n"); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimSpace(cut) + "…"
+}
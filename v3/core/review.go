@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Review reviews a diff the way a maintainer who knows the project
+// would: the context is half from the changed files and half from the
+// rest of the knowledge base, so the model sees the code the change
+// calls and the docs it should keep true.
+
+const reviewSysmsg = "You review changes to a software project.  Given a unified diff and source code and documentation from the project, point out bugs, missing tests, and places where the change disagrees with the rest of the project, citing file and line.  Be brief; skip praise and style nits.  If the change looks good, say so in one sentence."
+
+// diffFileRe matches the new path in a unified diff's file header.
+var diffFileRe = regexp.MustCompile(`(?m)^\+\+\+ b/(.+)$`)
+
+// reviewQueryBytes is the most of a diff used to find context; the
+// whole diff is still sent to the model.
+const reviewQueryBytes = 8000
+
+// ChangedFiles returns the files a unified diff changes, in order.
+func ChangedFiles(diff string) (files []string) {
+	for _, m := range diffFileRe.FindAllStringSubmatch(diff, -1) {
+		path := strings.TrimSpace(m[1])
+		if !util.StringInSlice(path, files) {
+			files = append(files, path)
+		}
+	}
+	return
+}
+
+// Review returns a review of a unified diff.
+func (g *Grokker) Review(diff string) (review string, err error) {
+	defer Return(&err)
+	if strings.TrimSpace(diff) == "" {
+		err = fmt.Errorf("no changes to review")
+		return
+	}
+	var files []string
+	for _, path := range ChangedFiles(diff) {
+		if g.hasDocument(path) {
+			files = append(files, path)
+		}
+	}
+	dtokens, err := g.tokens(diff)
+	Ck(err)
+	maxTokens := g.TokenLimit/2 - len(dtokens)
+	if g.contextTokens > 0 {
+		maxTokens = g.contextTokens
+	}
+	if maxTokens <= 0 {
+		err = classify(FailBudget, fmt.Errorf("the diff is %d tokens, too large to review with a %d token limit", len(dtokens), g.TokenLimit))
+		return
+	}
+	query := diff
+	if len(query) > reviewQueryBytes {
+		query = query[:reviewQueryBytes]
+	}
+	context, err := g.focusedContext(query, files, maxTokens)
+	Ck(err)
+	input := Spf("Source:\n\n%s\n\nDiff:\n\n%s", context, diff)
+	resp, err := g.msg(reviewSysmsg, input)
+	Ck(err)
+	review = strings.TrimSpace(resp.Choices[0].Message.Content)
+	return
+}
+
+// ReviewRange reviews the changes from base to head, as shown by
+// 'git diff base...head' in the root of the tree, and returns the
+// files they change.
+func (g *Grokker) ReviewRange(base, head string) (review string, files []string, err error) {
+	defer Return(&err)
+	cmd := exec.Command("git", "diff", base+"..."+head)
+	cmd.Dir = g.Root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	Ck(err, "git diff %s...%s: %s", base, head, strings.TrimSpace(stderr.String()))
+	files = ChangedFiles(string(out))
+	review, err = g.Review(string(out))
+	Ck(err)
+	return
+}
+
+// hasDocument returns true if the knowledge base has a document with
+// the given path.
+func (g *Grokker) hasDocument(relpath string) bool {
+	for _, doc := range g.Documents {
+		if doc.RelPath == relpath {
+			return true
+		}
+	}
+	return false
+}
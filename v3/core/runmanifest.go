@@ -0,0 +1,212 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	gptLib "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A run manifest records what a query or aidda run depended on, so
+// an answer that changed since can be explained: the model and its
+// settings, the user config, the retrieval pipeline, a hash of every
+// document as indexed, and the chunks each request used as context.
+// Two manifests can be compared with any JSON diff tool.
+//
+// Providers only try to make completions repeatable when given a
+// seed; see SetSeed.  The system fingerprint they return with each
+// completion changes when the provider changes the model's backend,
+// which makes answers differ even with the same seed.
+
+// RunManifest is the record of a run; see StartManifest.
+type RunManifest struct {
+	Time time.Time
+	// Args are the command line arguments of the run.
+	Args []string `json:",omitempty"`
+	// GrokVersion is the version of the code, DBVersion the version
+	// the db was last updated with.
+	GrokVersion string
+	DBVersion   string
+	Snapshot    string `json:",omitempty"`
+	// Model is grokker's name for the chat model, UpstreamModel the
+	// provider's name for it.
+	Model         string
+	UpstreamModel string
+	Provider      string
+	Seed          *int     `json:",omitempty"`
+	Persona       *Persona `json:",omitempty"`
+	Profile       *Profile `json:",omitempty"`
+	Lang          string   `json:",omitempty"`
+	// ConfigHash is the sha256 of the user config file, if there
+	// is one.
+	ConfigPath        string `json:",omitempty"`
+	ConfigHash        string `json:",omitempty"`
+	EmbeddingProvider string
+	EmbeddingModel    string
+	EmbeddingDim      int
+	Pipeline          Pipeline
+	Filter            *Filter `json:",omitempty"`
+	MaxChunks         int     `json:",omitempty"`
+	ContextTokens     int     `json:",omitempty"`
+	// ChunkSum is the db's checksum over all chunks; see verify.go.
+	ChunkSum  string `json:",omitempty"`
+	Documents []ManifestDocument
+	// Chunks are the IDs of the chunks used as context, in the
+	// order they were first used.
+	Chunks   []string
+	Requests []ManifestRequest
+}
+
+// ManifestDocument is a document in a run manifest.
+type ManifestDocument struct {
+	Path       string
+	Collection string `json:",omitempty"`
+	// Hash is the sha256 over the hashes of the document's chunks,
+	// in order, so it changes when the indexed content does.
+	Hash    string
+	Indexed *time.Time `json:",omitempty"`
+}
+
+// ManifestRequest is a completion request made during a run.
+type ManifestRequest struct {
+	Model       string
+	Temperature float32 `json:",omitempty"`
+	Seed        *int    `json:",omitempty"`
+	// PromptHash is the sha256 of the messages sent, after any
+	// redaction.
+	PromptHash string
+	// Chunks are the IDs of the chunks in the context at the time
+	// of the request.
+	Chunks []string `json:",omitempty"`
+	// Fingerprint is the system fingerprint the provider returned.
+	Fingerprint string `json:",omitempty"`
+	Cached      bool   `json:",omitempty"`
+}
+
+// SetSeed asks the provider to sample completions deterministically
+// with the given seed, as far as it can.  The seed is not stored in
+// the database.
+func (g *Grokker) SetSeed(seed int) {
+	g.seed = &seed
+}
+
+// StartManifest starts recording the completion requests of the run
+// for Manifest.
+func (g *Grokker) StartManifest() {
+	g.manifest = &RunManifest{Time: time.Now()}
+}
+
+// recordManifest adds a completion request to the run manifest, if
+// one is being recorded.
+func (g *Grokker) recordManifest(req gptLib.ChatCompletionRequest, res gptLib.ChatCompletionResponse, cached bool) {
+	m := g.manifest
+	if m == nil {
+		return
+	}
+	buf, err := json.Marshal(req.Messages)
+	Ck(err)
+	sum := sha256.Sum256(buf)
+	mr := ManifestRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		Seed:        req.Seed,
+		PromptHash:  hex.EncodeToString(sum[:]),
+		Chunks:      append([]string(nil), g.sourceIDs...),
+		Fingerprint: res.SystemFingerprint,
+		Cached:      cached,
+	}
+	for _, id := range mr.Chunks {
+		if !util.StringInSlice(id, m.Chunks) {
+			m.Chunks = append(m.Chunks, id)
+		}
+	}
+	m.Requests = append(m.Requests, mr)
+}
+
+// Manifest returns the run manifest, with the requests recorded
+// since StartManifest and the current state of the db and config.
+func (g *Grokker) Manifest() (m *RunManifest, err error) {
+	defer Return(&err)
+	m = &RunManifest{Time: time.Now()}
+	if g.manifest != nil {
+		m.Time = g.manifest.Time
+		m.Chunks = g.manifest.Chunks
+		m.Requests = g.manifest.Requests
+	}
+	m.GrokVersion = Version
+	m.DBVersion = g.Version
+	m.Snapshot = g.snapshot
+	m.Model = g.Model
+	if g.modelObj != nil {
+		m.UpstreamModel = g.modelObj.upstreamName
+	}
+	m.Provider = g.chatProvider()
+	m.Seed = g.seed
+	m.Persona = g.persona
+	m.Profile = g.profile
+	m.Lang = g.lang
+	m.ConfigPath = ConfigPath()
+	if m.ConfigPath != "" {
+		buf, err := os.ReadFile(m.ConfigPath)
+		if err == nil {
+			sum := sha256.Sum256(buf)
+			m.ConfigHash = hex.EncodeToString(sum[:])
+		} else if os.IsNotExist(err) {
+			m.ConfigPath = ""
+		} else {
+			Ck(err)
+		}
+	}
+	m.EmbeddingProvider = g.EmbeddingProvider
+	m.EmbeddingModel = g.EmbeddingModel
+	m.EmbeddingDim = g.EmbeddingDim
+	m.Pipeline = g.Pipeline
+	m.Filter = g.filter
+	m.MaxChunks = g.maxChunks
+	m.ContextTokens = g.contextTokens
+	m.ChunkSum = g.ChunkSum
+	// hash each document's chunks in document order
+	docChunks := make(map[*Document][]*Chunk)
+	for _, c := range g.Chunks {
+		if c.Document != nil {
+			docChunks[c.Document] = append(docChunks[c.Document], c)
+		}
+	}
+	for _, doc := range g.Documents {
+		chunks := docChunks[doc]
+		sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].Offset < chunks[j].Offset })
+		var hashes []string
+		for _, c := range chunks {
+			hashes = append(hashes, c.Hash)
+		}
+		sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+		m.Documents = append(m.Documents, ManifestDocument{
+			Path:       doc.RelPath,
+			Collection: doc.Collection,
+			Hash:       hex.EncodeToString(sum[:]),
+			Indexed:    doc.Indexed,
+		})
+	}
+	sort.Slice(m.Documents, func(i, j int) bool { return m.Documents[i].Path < m.Documents[j].Path })
+	return
+}
+
+// WriteManifest writes the run manifest to path as indented JSON.
+func (g *Grokker) WriteManifest(path string, args []string) (err error) {
+	defer Return(&err)
+	m, err := g.Manifest()
+	Ck(err)
+	m.Args = args
+	buf, err := json.MarshalIndent(m, "", "  ")
+	Ck(err)
+	err = os.WriteFile(path, append(buf, '\n'), 0644)
+	Ck(err)
+	return
+}
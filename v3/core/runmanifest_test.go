@@ -0,0 +1,56 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gptLib "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+func TestRunManifest(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CONFIG", filepath.Join(dir, "config.yaml"))
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	for fn, content := range map[string]string{"a.md": "alpha\n", "b.md": "beta beta\n"} {
+		err = os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Ck(err)
+		err = grok.AddDocument(filepath.Join(dir, fn))
+		Ck(err)
+	}
+	grok.SetSeed(42)
+	grok.StartManifest()
+	_, err = grok.Context("alpha", 1000, false, false)
+	Ck(err)
+	req := gptLib.ChatCompletionRequest{Model: "gpt-3.5-turbo", Seed: grok.seed, Messages: []gptLib.ChatCompletionMessage{{Role: "user", Content: "alpha?"}}}
+	grok.recordManifest(req, gptLib.ChatCompletionResponse{SystemFingerprint: "fp_1"}, false)
+
+	fn := filepath.Join(dir, "manifest.json")
+	err = grok.WriteManifest(fn, []string{"q", "alpha?"})
+	Tassert(t, err == nil, "error writing manifest: %v", err)
+	buf, err := os.ReadFile(fn)
+	Ck(err)
+	var m RunManifest
+	err = json.Unmarshal(buf, &m)
+	Ck(err)
+	Tassert(t, m.Seed != nil && *m.Seed == 42, "unexpected seed %v", m.Seed)
+	Tassert(t, m.Model == "gpt-3.5-turbo" && m.EmbeddingModel != "", "unexpected models %q %q", m.Model, m.EmbeddingModel)
+	Tassert(t, m.ConfigPath == "", "expected no config file, got %q", m.ConfigPath)
+	Tassert(t, len(m.Documents) == 2 && m.Documents[0].Path == "a.md", "unexpected documents %+v", m.Documents)
+	Tassert(t, len(m.Requests) == 1 && m.Requests[0].Fingerprint == "fp_1", "unexpected requests %+v", m.Requests)
+	Tassert(t, len(m.Chunks) > 0 && len(m.Chunks) == len(grok.SourceIDs()), "expected the context's chunks, got %v", m.Chunks)
+
+	// a document's hash changes with its content
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("alpha, revised\n"), 0644)
+	Ck(err)
+	_, err = grok.UpdateEmbeddings()
+	Ck(err)
+	m2, err := grok.Manifest()
+	Ck(err)
+	Tassert(t, m2.Documents[0].Hash != m.Documents[0].Hash, "expected a new hash for a.md")
+	Tassert(t, m2.Documents[1].Hash == m.Documents[1].Hash, "expected the same hash for b.md")
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A seed is a compact description of a knowledge base that can be
+// committed with the repository: the documents, the hashes of their
+// chunks, and the settings, but no embeddings.  In a new clone,
+// 'grok hydrate' rebuilds the index from the seed and the checked
+// out files.  Embeddings come from the embedding cache when it has
+// them, e.g. when CacheDir() is shared or was restored in CI, so only
+// the chunks nobody has embedded yet cost anything.  Virtual
+// documents live only in the database, so they aren't part of the
+// seed.  Nor are index hooks: they run commands, and a seed from a
+// pull request must not run anything on 'grok hydrate'.  A clone that
+// wants them runs 'grok init' and adds them with 'grok hook add'
+// before hydrating; see hooks.go.
+
+// seedName is the name of the seed file in the root of the
+// repository.
+const seedName = ".grok-seed.json"
+
+// Seed is the content of a seed file.
+type Seed struct {
+	// Version is the grokker version that wrote the seed.
+	Version string
+	Model   string
+	// The embedder the index was built with; hydrating with
+	// another one fails.
+	EmbeddingProvider string
+	EmbeddingModel    string
+	EmbeddingDim      int
+	Pipeline          Pipeline
+	StopAllow         map[string]bool `json:",omitempty"`
+	Documents         []*SeedDocument
+}
+
+// SeedDocument is a document in a seed.
+type SeedDocument struct {
+	RelPath    string
+	Collection string   `json:",omitempty"`
+	Tags       []string `json:",omitempty"`
+	Boost      float64  `json:",omitempty"`
+	Excluded   string   `json:",omitempty"`
+	// Chunks are the hashes of the document's chunks, in order.
+	Chunks []string
+}
+
+// HydrateResult is the outcome of Hydrate.
+type HydrateResult struct {
+	// Added is the number of documents indexed, and Skipped the
+	// number already in the knowledge base.
+	Added   int
+	Skipped int
+	// Missing are the documents whose files aren't in the tree, or
+	// are ignored; see grokignore.go.
+	Missing []string
+	// Chunks is the number of chunks indexed, and Changed the
+	// number of them that aren't in the seed, because their
+	// documents changed since the seed was written.
+	Chunks  int
+	Changed int
+	// Cached is the number of embeddings taken from the embedding
+	// cache, and Fetched the number requested from the embedder.
+	Cached  int
+	Fetched int
+}
+
+// SeedPath returns the path of the seed file in the root.
+func (g *Grokker) SeedPath() string {
+	return filepath.Join(g.Root, seedName)
+}
+
+// MakeSeed returns the seed of the knowledge base.
+func (g *Grokker) MakeSeed() (seed *Seed) {
+	model := g.Model
+	if g.modelOverride {
+		model = g.modelFromDb
+	}
+	pipeline := g.Pipeline
+	if g.pipelineFromDb != nil {
+		pipeline = *g.pipelineFromDb
+	}
+	seed = &Seed{
+		Version:           Version,
+		Model:             model,
+		EmbeddingProvider: g.EmbeddingProvider,
+		EmbeddingModel:    g.EmbeddingModel,
+		EmbeddingDim:      g.EmbeddingDim,
+		Pipeline:          pipeline,
+		StopAllow:         g.StopAllow,
+	}
+	chunks := make(map[string][]*Chunk)
+	for _, c := range g.Chunks {
+		if c.Document != nil && !c.stale {
+			chunks[c.Document.RelPath] = append(chunks[c.Document.RelPath], c)
+		}
+	}
+	for _, doc := range g.Documents {
+		if doc.Virtual {
+			continue
+		}
+		sd := &SeedDocument{RelPath: doc.RelPath, Collection: doc.Collection, Tags: doc.Tags, Boost: doc.Boost, Excluded: doc.Excluded}
+		cs := chunks[doc.RelPath]
+		sort.Slice(cs, func(i, j int) bool { return cs[i].Offset < cs[j].Offset })
+		for _, c := range cs {
+			sd.Chunks = append(sd.Chunks, c.Hash)
+		}
+		seed.Documents = append(seed.Documents, sd)
+	}
+	sort.Slice(seed.Documents, func(i, j int) bool { return seed.Documents[i].RelPath < seed.Documents[j].RelPath })
+	return
+}
+
+// WriteSeed writes the seed of the knowledge base to path, or to
+// SeedPath() if path is empty.
+func (g *Grokker) WriteSeed(path string) (err error) {
+	defer Return(&err)
+	if path == "" {
+		path = g.SeedPath()
+	}
+	buf, err := json.MarshalIndent(g.MakeSeed(), "", "  ")
+	Ck(err)
+	err = os.WriteFile(path, append(buf, '\n'), 0644)
+	Ck(err)
+	return
+}
+
+// ReadSeed reads a seed file.
+func ReadSeed(path string) (seed *Seed, err error) {
+	defer Return(&err)
+	buf, err := os.ReadFile(path)
+	Ck(err)
+	seed = &Seed{}
+	err = json.Unmarshal(buf, seed)
+	Ck(err, "%s is not a seed file", path)
+	return
+}
+
+// Hydrate indexes the documents of a seed.  The seed's settings are
+// applied if the knowledge base has no documents yet, and documents
+// already in it are skipped, so an interrupted hydrate can be run
+// again.
+func (g *Grokker) Hydrate(seed *Seed) (res *HydrateResult, err error) {
+	defer Return(&err)
+	res = &HydrateResult{}
+	if len(g.Documents) == 0 {
+		g.Pipeline = seed.Pipeline
+		g.pipelineFromDb = nil
+		err = g.pipelineChanged()
+		Ck(err, "seed pipeline")
+		g.StopAllow = seed.StopAllow
+		g.EmbeddingProvider = seed.EmbeddingProvider
+		g.EmbeddingModel = seed.EmbeddingModel
+		g.EmbeddingDim = seed.EmbeddingDim
+	}
+	indexed := make(map[string]bool)
+	for _, doc := range g.Documents {
+		indexed[doc.RelPath] = true
+	}
+	cached, fetched := g.embeddingsCached, g.embeddingsFetched
+	// chunk hashes include the document's path, so they are
+	// unique across documents
+	added := make(map[string]bool)
+	want := make(map[string]bool)
+	for _, sd := range seed.Documents {
+		if indexed[sd.RelPath] {
+			res.Skipped++
+			continue
+		}
+		doc, err := g.addDoc(filepath.Join(g.Root, filepath.FromSlash(sd.RelPath)))
+		Ck(err)
+		if doc == nil {
+			res.Missing = append(res.Missing, sd.RelPath)
+			continue
+		}
+		doc.Collection = sd.Collection
+		doc.Tags = sd.Tags
+		doc.Boost = sd.Boost
+		doc.Excluded = sd.Excluded
+		_, err = g.updateDocument(doc)
+		Ck(err, "%s", sd.RelPath)
+		res.Added++
+		added[sd.RelPath] = true
+		for _, hash := range sd.Chunks {
+			want[hash] = true
+		}
+	}
+	for _, c := range g.Chunks {
+		if c.Document == nil || !added[c.Document.RelPath] || c.stale {
+			continue
+		}
+		res.Chunks++
+		if !want[c.Hash] {
+			res.Changed++
+		}
+	}
+	res.Cached = g.embeddingsCached - cached
+	res.Fetched = g.embeddingsFetched - fetched
+	return
+}
+
+// String returns a one-line summary of the result.
+func (r *HydrateResult) String() string {
+	return Spf("indexed %d documents, %d chunks (%d changed since the seed); %d embeddings from the cache, %d fetched; %d documents missing, %d already indexed",
+		r.Added, r.Chunks, r.Changed, r.Cached, r.Fetched, len(r.Missing), r.Skipped)
+}
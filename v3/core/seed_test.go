@@ -0,0 +1,73 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestHydrate(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	files := map[string]string{
+		"README.md":     "# Project\n\nHow to build it.\n",
+		"docs/guide.md": "# Guide\n\nStart the server with the serve command.\n",
+		"docs/faq.md":   "# FAQ\n\nAsk in the chat.\n",
+	}
+	write := func(dir string, files map[string]string) {
+		for fn, content := range files {
+			path := filepath.Join(dir, fn)
+			err := os.MkdirAll(filepath.Dir(path), 0755)
+			Ck(err)
+			err = os.WriteFile(path, []byte(content), 0644)
+			Ck(err)
+		}
+	}
+	dir := TmpTestDir()
+	write(dir, files)
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	grok.Pipeline.MinSources = 2
+	for fn := range files {
+		err = grok.AddDocument(filepath.Join(dir, fn))
+		Ck(err)
+	}
+	for _, doc := range grok.Documents {
+		if doc.RelPath == "README.md" {
+			doc.Boost = 0.1
+		}
+	}
+	err = grok.WriteSeed("")
+	Tassert(t, err == nil, "error writing seed: %v", err)
+	buf, err := os.ReadFile(grok.SeedPath())
+	Tassert(t, err == nil && !strings.Contains(string(buf), "Embedding\""), "expected a seed without embeddings: %v\n%s", err, buf)
+
+	// a clone where the guide changed and the FAQ is gone
+	clone := TmpTestDir()
+	write(clone, map[string]string{
+		"README.md":     files["README.md"],
+		"docs/guide.md": files["docs/guide.md"] + "\nStop it with the stop command.\n",
+	})
+	seed, err := ReadSeed(grok.SeedPath())
+	Tassert(t, err == nil, "error reading seed: %v", err)
+	g2, err := Init(clone, seed.Model)
+	Ck(err)
+	g2.SetEmbedder(lengthEmbedder{}, 256)
+	res, err := g2.Hydrate(seed)
+	Tassert(t, err == nil, "error hydrating: %v", err)
+	Tassert(t, res.Added == 2 && strings.Join(res.Missing, " ") == "docs/faq.md", "got %+v", res)
+	Tassert(t, res.Changed > 0 && res.Fetched == res.Changed && res.Cached == res.Chunks-res.Changed, "got %+v", res)
+	Tassert(t, g2.Pipeline.MinSources == 2, "expected the seed's pipeline, got %+v", g2.Pipeline)
+	boosted := false
+	for _, doc := range g2.Documents {
+		boosted = boosted || doc.Boost == 0.1
+	}
+	Tassert(t, boosted, "expected the seed's boost")
+
+	// nothing left to do
+	res, err = g2.Hydrate(seed)
+	Tassert(t, err == nil && res.Added == 0 && res.Skipped == 2, "got %+v, %v", res, err)
+}
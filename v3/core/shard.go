@@ -0,0 +1,307 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// In a monorepo, most questions are about one service, but every
+// query would load the embeddings of all of them.  A subtree can be
+// made a shard with 'grok shard add services/billing': the chunks of
+// its documents are then kept in their own file in .grok.d/ next to
+// the db, and the db keeps the rest.  Shards are loaded when they
+// are needed: a query limited to paths, e.g. 'grok q --path
+// services/billing', only loads the shards under those paths, a
+// changed document loads its shard to be re-indexed, and a query
+// without paths loads them all.  Only the processes that opt in with
+// SetLazyShards load shards lazily; everything else loads them with
+// the db.  Shard files are rewritten in full when their chunks
+// change, and carry their own checksum over their chunks.
+
+// lazyShards is true if LoadFrom leaves the shards to be loaded when
+// they're needed; see SetLazyShards.
+var lazyShards = false
+
+// SetLazyShards sets whether databases loaded from now on load their
+// shards only when a query or an update needs them.  Use it for
+// processes that only answer queries.
+func SetLazyShards(on bool) {
+	lazyShards = on
+}
+
+// shardFile is the content of a shard file.
+type shardFile struct {
+	Shard string
+	// ChunkSum is the checksum over the checksums of the chunks;
+	// see verify.go.
+	ChunkSum string
+	Chunks   []*Chunk
+}
+
+// ShardInfo describes a shard.
+type ShardInfo struct {
+	Dir       string
+	Documents int
+	// Size is the size of the shard file.
+	Size int64
+}
+
+// shardDir returns the directory of the shard files.
+func (g *Grokker) shardDir() string {
+	return g.grokpath + ".d"
+}
+
+// shardPath returns the path of a shard's file.
+func (g *Grokker) shardPath(shard string) string {
+	return filepath.Join(g.shardDir(), url.PathEscape(shard)+".json")
+}
+
+// shardOf returns the shard that holds the chunks of the document at
+// relpath, or an empty string if the db holds them.  Nested shards
+// take their subtrees from the enclosing ones.
+func (g *Grokker) shardOf(relpath string) (shard string) {
+	for _, s := range g.Shards {
+		if underPath(relpath, s) && len(s) > len(shard) {
+			shard = s
+		}
+	}
+	return
+}
+
+// underPath returns true if relpath is dir or is under it.
+func underPath(relpath, dir string) bool {
+	return relpath == dir || strings.HasPrefix(relpath, dir+"/")
+}
+
+// shardSig summarizes a shard's chunks, so Save can tell whether the
+// shard changed since it was loaded or saved.
+func shardSig(chunks []*Chunk) string {
+	var sigs []string
+	for _, c := range chunks {
+		sigs = append(sigs, c.Hash+" "+chunkSig(c))
+	}
+	sort.Strings(sigs)
+	sum := sha256.Sum256([]byte(strings.Join(sigs, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadShard adds the chunks in a shard's file to the db, unless it's
+// loaded already.  A shard without a file has no chunks yet.
+func (g *Grokker) loadShard(shard string) (err error) {
+	defer Return(&err)
+	if _, loaded := g.shardSigs[shard]; loaded || shard == "" {
+		return
+	}
+	var sf shardFile
+	buf, err := os.ReadFile(g.shardPath(shard))
+	if os.IsNotExist(err) {
+		err = nil
+	} else {
+		Ck(err)
+		err = json.Unmarshal(buf, &sf)
+		Ck(err, "%s", g.shardPath(shard))
+	}
+	have := make(map[string]bool, len(g.Chunks))
+	for _, c := range g.Chunks {
+		have[c.Hash] = true
+	}
+	for _, c := range sf.Chunks {
+		if !have[c.Hash] {
+			g.Chunks = append(g.Chunks, c)
+		}
+	}
+	g.markShard(shard, sf.Chunks, sf.ChunkSum)
+	Debug("loaded shard %s: %d chunks", shard, len(sf.Chunks))
+	return
+}
+
+// LoadShards loads the shards that hold documents under any of the
+// paths, which are relative to the root, or all shards if there are
+// no paths.
+func (g *Grokker) LoadShards(paths ...string) (err error) {
+	defer Return(&err)
+	for _, s := range g.Shards {
+		load := len(paths) == 0
+		for _, p := range paths {
+			p = path.Clean(filepath.ToSlash(p))
+			if p == "." || underPath(s, p) || underPath(p, s) {
+				load = true
+				break
+			}
+		}
+		if load {
+			err = g.loadShard(s)
+			Ck(err)
+		}
+	}
+	return
+}
+
+// markShard records a shard's chunks as they are in its file.
+func (g *Grokker) markShard(shard string, chunks []*Chunk, sum string) {
+	if g.shardSigs == nil {
+		g.shardSigs = make(map[string]string)
+		g.shardSums = make(map[string]string)
+	}
+	g.shardSigs[shard] = shardSig(chunks)
+	g.shardSums[shard] = sum
+}
+
+// splitShards returns the chunks that belong in the db file, and the
+// chunks of each shard.
+func (g *Grokker) splitShards() (main []*Chunk, byShard map[string][]*Chunk) {
+	byShard = make(map[string][]*Chunk)
+	for _, c := range g.Chunks {
+		shard := ""
+		if c.Document != nil {
+			shard = g.shardOf(c.Document.RelPath)
+		}
+		if shard == "" {
+			main = append(main, c)
+		} else {
+			byShard[shard] = append(byShard[shard], c)
+		}
+	}
+	return
+}
+
+// saveShards writes the loaded shards whose chunks changed, removes
+// the files of loaded shards that are no longer shards, and returns
+// the chunks that belong in the db file.
+func (g *Grokker) saveShards() (main []*Chunk, err error) {
+	defer Return(&err)
+	main, byShard := g.splitShards()
+	// don't write a shard file without the chunks already in it
+	for shard := range byShard {
+		err = g.loadShard(shard)
+		Ck(err)
+	}
+	if len(g.Shards) > 0 {
+		// loading may have added chunks
+		main, byShard = g.splitShards()
+	}
+	for _, shard := range g.Shards {
+		sig, loaded := g.shardSigs[shard]
+		if !loaded {
+			continue
+		}
+		chunks := byShard[shard]
+		if sig == shardSig(chunks) {
+			if _, err := os.Stat(g.shardPath(shard)); err == nil || len(chunks) == 0 {
+				continue
+			}
+		}
+		for _, c := range chunks {
+			c.Sum = c.checksum()
+		}
+		sf := shardFile{Shard: shard, ChunkSum: storeChecksum(chunks), Chunks: chunks}
+		buf, err := json.Marshal(sf)
+		Ck(err)
+		err = os.MkdirAll(g.shardDir(), 0755)
+		Ck(err)
+		fn := g.shardPath(shard)
+		err = os.WriteFile(fn+".tmp", buf, 0644)
+		Ck(err)
+		err = os.Rename(fn+".tmp", fn)
+		Ck(err)
+		g.markShard(shard, chunks, sf.ChunkSum)
+		Debug("saved shard %s: %d chunks", shard, len(chunks))
+	}
+	for shard := range g.shardSigs {
+		if util.StringInSlice(shard, g.Shards) {
+			continue
+		}
+		// its chunks moved to the db or another shard
+		err = os.Remove(g.shardPath(shard))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		Ck(err)
+		delete(g.shardSigs, shard)
+		delete(g.shardSums, shard)
+	}
+	return
+}
+
+// AddShard makes a subtree a shard.  dir is relative to the current
+// directory or absolute.  The shard's file is written on the next
+// save.
+func (g *Grokker) AddShard(dir string) (shard string, err error) {
+	defer Return(&err)
+	shard, err = g.shardName(dir)
+	Ck(err)
+	for _, s := range g.Shards {
+		if s == shard {
+			err = fmt.Errorf("%s is already a shard", shard)
+			return
+		}
+	}
+	// the chunks may move from another shard
+	err = g.LoadShards()
+	Ck(err)
+	g.Shards = append(g.Shards, shard)
+	sort.Strings(g.Shards)
+	g.markShard(shard, nil, "")
+	return
+}
+
+// RemoveShard moves a shard's chunks back to the db, or to the
+// enclosing shard, on the next save.
+func (g *Grokker) RemoveShard(dir string) (shard string, err error) {
+	defer Return(&err)
+	shard, err = g.shardName(dir)
+	Ck(err)
+	err = g.LoadShards()
+	Ck(err)
+	for i, s := range g.Shards {
+		if s == shard {
+			g.Shards = append(g.Shards[:i], g.Shards[i+1:]...)
+			return
+		}
+	}
+	err = fmt.Errorf("%s is not a shard", shard)
+	return
+}
+
+// shardName returns the shard name of dir: its path relative to the
+// root, with forward slashes.
+func (g *Grokker) shardName(dir string) (shard string, err error) {
+	defer Return(&err)
+	abs, err := filepath.Abs(dir)
+	Ck(err)
+	rel, err := filepath.Rel(g.Root, abs)
+	Ck(err)
+	shard = filepath.ToSlash(rel)
+	if shard == "." || shard == ".." || strings.HasPrefix(shard, "../") {
+		err = fmt.Errorf("%s is not a subdirectory of %s", dir, g.Root)
+	}
+	return
+}
+
+// ShardInfos describes the shards, sorted by directory.
+func (g *Grokker) ShardInfos() (infos []ShardInfo) {
+	for _, s := range g.Shards {
+		info := ShardInfo{Dir: s}
+		if fi, err := os.Stat(g.shardPath(s)); err == nil {
+			info.Size = fi.Size()
+		}
+		for _, doc := range g.Documents {
+			if g.shardOf(doc.RelPath) == s {
+				info.Documents++
+			}
+		}
+		infos = append(infos, info)
+	}
+	return
+}
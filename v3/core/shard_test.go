@@ -0,0 +1,139 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestShards(t *testing.T) {
+	dir := TmpTestDir()
+	files := map[string]string{
+		"README.md":             "# Monorepo\n\nOne service per directory.\n",
+		"services/billing/a.md": "# Billing\n\nInvoices are sent monthly.\n",
+		"services/search/b.md":  "# Search\n\nThe index is rebuilt nightly.\n",
+	}
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	for fn, content := range files {
+		path := filepath.Join(dir, fn)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		Ck(err)
+		err = os.WriteFile(path, []byte(content), 0644)
+		Ck(err)
+		err = grok.AddDocument(path)
+		Ck(err)
+	}
+	total := len(grok.Chunks)
+	count := func(g *Grokker, prefix string) (n int) {
+		for _, c := range g.Chunks {
+			if underPath(c.Document.RelPath, prefix) {
+				n++
+			}
+		}
+		return
+	}
+	billing := count(grok, "services/billing")
+	Tassert(t, billing > 0, "expected billing chunks")
+
+	_, err = grok.AddShard(filepath.Join(dir, "services", "billing"))
+	Tassert(t, err == nil, "error adding shard: %v", err)
+	_, err = grok.AddShard(filepath.Join(dir, "services", "billing"))
+	Tassert(t, err != nil, "expected an error adding a shard twice")
+	_, err = grok.AddShard(filepath.Join(dir, "services", "search"))
+	Ck(err)
+	err = grok.Save()
+	Ck(err)
+	_, err = os.Stat(filepath.Join(dir, ".grok.d", "services%2Fbilling.json"))
+	Tassert(t, err == nil, "expected a shard file: %v", err)
+
+	load := func(lazy bool) *Grokker {
+		SetLazyShards(lazy)
+		defer SetLazyShards(false)
+		g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", false)
+		Tassert(t, err == nil, "error loading: %v", err)
+		lock.Unlock()
+		g.SetEmbedder(lengthEmbedder{}, 256)
+		return g
+	}
+	g := load(false)
+	Tassert(t, len(g.Chunks) == total, "expected %d chunks, got %d", total, len(g.Chunks))
+	report := g.Verify()
+	Tassert(t, len(report.Problems) == 0, "unexpected problems: %v", report.Problems)
+
+	// a query limited to the search service doesn't load billing
+	g = load(true)
+	Tassert(t, count(g, "services") == 0, "expected no shard chunks before a query")
+	err = g.Visit(&Filter{Paths: []string{"services/search"}}, func(doc *Document, ref *ChunkRef) error {
+		Tassert(t, underPath(doc.RelPath, "services/search"), "unexpected document %s", doc.RelPath)
+		return nil
+	})
+	Ck(err)
+	Tassert(t, count(g, "services/billing") == 0 && count(g, "services/search") > 0, "expected only the search shard to be loaded")
+
+	// a chunk can be looked up by ID before its shard is loaded
+	var billingHash string
+	for _, c := range grok.Chunks {
+		if underPath(c.Document.RelPath, "services/billing") {
+			billingHash = c.Hash
+			break
+		}
+	}
+	g = load(true)
+	ref, ok, err := g.ChunkByID(billingHash)
+	Tassert(t, err == nil && ok && ref.Path == "services/billing/a.md", "expected the billing chunk, got %v, %v, %v", ref, ok, err)
+	g = load(true)
+
+	// a changed document loads its shard, and only its shard is
+	// rewritten
+	path := filepath.Join(dir, "services/billing/a.md")
+	err = os.WriteFile(path, []byte(files["services/billing/a.md"]+"\nLate fees apply.\n"), 0644)
+	Ck(err)
+	err = g.AddDocument(path)
+	Ck(err)
+	Tassert(t, count(g, "services/billing") >= billing, "expected the billing shard to be loaded")
+	err = g.Save()
+	Ck(err)
+	g = load(false)
+	report = g.Verify()
+	Tassert(t, len(report.Problems) == 0, "unexpected problems: %v", report.Problems)
+	Tassert(t, count(g, "services/search") > 0 && count(g, "README.md") > 0, "lost chunks: %d", len(g.Chunks))
+
+	// a value that changes without changing the shape rewrites the
+	// shard
+	var hash string
+	for _, c := range g.Chunks {
+		if underPath(c.Document.RelPath, "services/search") {
+			c.Embedding = make([]float64, len(c.Embedding))
+			c.Embedding[0] = 0.25
+			hash = c.Hash
+			break
+		}
+	}
+	err = g.Save()
+	Ck(err)
+	g = load(false)
+	var saved *Chunk
+	for _, c := range g.Chunks {
+		if c.Hash == hash {
+			saved = c
+		}
+	}
+	Tassert(t, saved != nil && saved.Embedding[0] == 0.25, "expected the new embedding in the shard, got %v", saved)
+
+	// removing a shard moves its chunks back to the db file
+	_, err = g.RemoveShard(filepath.Join(dir, "services", "billing"))
+	Tassert(t, err == nil, "error removing shard: %v", err)
+	err = g.Save()
+	Ck(err)
+	_, err = os.Stat(filepath.Join(dir, ".grok.d", "services%2Fbilling.json"))
+	Tassert(t, os.IsNotExist(err), "expected the shard file to be removed: %v", err)
+	g2 := load(true)
+	Tassert(t, count(g2, "services/billing") == count(g, "services/billing") && count(g2, "services/search") == 0,
+		"expected billing in the db file and search in its shard")
+	infos := g2.ShardInfos()
+	Tassert(t, len(infos) == 1 && infos[0].Dir == "services/search" && infos[0].Documents == 1 && infos[0].Size > 0, "got %+v", infos)
+}
@@ -0,0 +1,323 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"golang.org/x/crypto/blake2b"
+)
+
+// A knowledge base can be exported as a single file, signed, and
+// imported elsewhere after checking the signature, so a team can
+// distribute a shared index and trust that it hasn't been tampered
+// with.  Keys and signatures use the minisign formats, so
+//
+//	minisign -Vm team.grok -P <public key>
+//
+// verifies an export signed by grok, and keys made with 'minisign
+// -G -W' work with grok.  Secret keys are kept unencrypted, as with
+// -W; password-protected minisign secret keys aren't supported.
+// Sigstore isn't supported either.
+
+const (
+	// minisign algorithm ids: Ed signs the file itself, ED signs
+	// its BLAKE2b-512 hash
+	sigAlgLegacy    = "Ed"
+	sigAlgPrehashed = "ED"
+	// minisign secret key checksum algorithm: BLAKE2b-256
+	chkAlgBlake2b = "B2"
+	// minisign secret key kdf id for an unencrypted key
+	kdfNone = "\x00\x00"
+)
+
+// PublicKey is a minisign public key.
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// SecretKey is an unencrypted minisign secret key.
+type SecretKey struct {
+	ID  [8]byte
+	Key ed25519.PrivateKey
+}
+
+// keyID formats a key id the way minisign prints it.
+func keyID(id [8]byte) string {
+	return Spf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// KeyID returns the key's id as minisign prints it.
+func (k PublicKey) KeyID() string {
+	return keyID(k.ID)
+}
+
+// GenerateKey returns a new key pair.
+func GenerateKey() (pub PublicKey, sec SecretKey, err error) {
+	defer Return(&err)
+	pk, sk, err := ed25519.GenerateKey(rand.Reader)
+	Ck(err)
+	_, err = rand.Read(pub.ID[:])
+	Ck(err)
+	pub.Key = pk
+	sec.ID = pub.ID
+	sec.Key = sk
+	return
+}
+
+// String returns the public key file content.  The second line alone
+// is what 'minisign -P' expects.
+func (k PublicKey) String() string {
+	buf := append([]byte(sigAlgLegacy), k.ID[:]...)
+	buf = append(buf, k.Key...)
+	return Spf("untrusted comment: minisign public key %s\n%s\n", keyID(k.ID), base64.StdEncoding.EncodeToString(buf))
+}
+
+// Public returns the public half of the key.
+func (k SecretKey) Public() PublicKey {
+	return PublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// String returns the secret key file content.
+func (k SecretKey) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(sigAlgLegacy)
+	buf.WriteString(kdfNone)
+	buf.WriteString(chkAlgBlake2b)
+	// the kdf salt and limits are unused without a password
+	buf.Write(make([]byte, 32+8+8))
+	buf.Write(k.ID[:])
+	buf.Write(k.Key)
+	buf.Write(k.checksum())
+	return Spf("untrusted comment: minisign unencrypted secret key\n%s\n", base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func (k SecretKey) checksum() []byte {
+	buf := append([]byte(sigAlgLegacy), k.ID[:]...)
+	buf = append(buf, k.Key...)
+	sum := blake2b.Sum256(buf)
+	return sum[:]
+}
+
+// keyData returns the base64-decoded key or signature line of a
+// minisign file, skipping any comment line.  A bare base64 string
+// works too.
+func keyData(text string) (buf []byte, err error) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	return nil, fmt.Errorf("no key found")
+}
+
+// ParsePublicKey parses a public key file, or the base64 key from
+// its second line.
+func ParsePublicKey(text string) (k PublicKey, err error) {
+	buf, err := keyData(text)
+	if err != nil {
+		return
+	}
+	if len(buf) != 2+8+ed25519.PublicKeySize || string(buf[:2]) != sigAlgLegacy {
+		err = fmt.Errorf("not a minisign public key")
+		return
+	}
+	copy(k.ID[:], buf[2:10])
+	k.Key = ed25519.PublicKey(buf[10:])
+	return
+}
+
+// ParseSecretKey parses an unencrypted secret key file.
+func ParseSecretKey(text string) (k SecretKey, err error) {
+	buf, err := keyData(text)
+	if err != nil {
+		return
+	}
+	if len(buf) != 158 || string(buf[:2]) != sigAlgLegacy || string(buf[4:6]) != chkAlgBlake2b {
+		err = fmt.Errorf("not a minisign secret key")
+		return
+	}
+	if string(buf[2:4]) != kdfNone {
+		err = fmt.Errorf("password-protected secret keys aren't supported; make one without a password with 'grok keygen' or 'minisign -G -W'")
+		return
+	}
+	buf = buf[54:]
+	copy(k.ID[:], buf[:8])
+	k.Key = ed25519.PrivateKey(buf[8:72])
+	if !bytes.Equal(k.checksum(), buf[72:]) {
+		err = fmt.Errorf("the secret key is corrupt: checksum mismatch")
+	}
+	return
+}
+
+// Sign returns a minisign signature file for data.  The trusted
+// comment is covered by the signature.
+func Sign(k SecretKey, data []byte, trustedComment string) string {
+	sum := blake2b.Sum512(data)
+	sig := ed25519.Sign(k.Key, sum[:])
+	buf := append([]byte(sigAlgPrehashed), k.ID[:]...)
+	buf = append(buf, sig...)
+	global := ed25519.Sign(k.Key, append(sig, trustedComment...))
+	return Spf("untrusted comment: signature from grok secret key %s\n%s\ntrusted comment: %s\n%s\n",
+		keyID(k.ID),
+		base64.StdEncoding.EncodeToString(buf),
+		trustedComment,
+		base64.StdEncoding.EncodeToString(global))
+}
+
+// VerifySignature checks a minisign signature file for data against
+// the keys, returning the key that signed it and the signature's
+// trusted comment.
+func VerifySignature(keys []PublicKey, data []byte, sigfile string) (signer PublicKey, trustedComment string, err error) {
+	lines := strings.Split(strings.TrimSpace(sigfile), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		err = fmt.Errorf("not a minisign signature")
+		return
+	}
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(buf) != 2+8+ed25519.SignatureSize {
+		err = fmt.Errorf("not a minisign signature")
+		return
+	}
+	alg := string(buf[:2])
+	var id [8]byte
+	copy(id[:], buf[2:10])
+	sig := buf[10:]
+	found := false
+	for _, k := range keys {
+		if k.ID == id {
+			signer = k
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = fmt.Errorf("signed by unknown key %s", keyID(id))
+		return
+	}
+	msg := data
+	switch alg {
+	case sigAlgPrehashed:
+		sum := blake2b.Sum512(data)
+		msg = sum[:]
+	case sigAlgLegacy:
+	default:
+		err = fmt.Errorf("unknown signature algorithm %q", alg)
+		return
+	}
+	if !ed25519.Verify(signer.Key, msg, sig) {
+		err = fmt.Errorf("signature verification failed: the file was modified or signed with another key")
+		return
+	}
+	trustedComment = strings.TrimPrefix(lines[2], "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(signer.Key, append(sig, trustedComment...), global) {
+		err = fmt.Errorf("the trusted comment of the signature was modified")
+		return
+	}
+	return
+}
+
+// TrustedKeys returns the keys listed under trusted_keys in the user
+// config file.
+func TrustedKeys() (keys []PublicKey, err error) {
+	defer Return(&err)
+	cfg, err := LoadConfig()
+	Ck(err)
+	for _, text := range cfg.TrustedKeys {
+		k, err := ParsePublicKey(text)
+		Ck(err, "trusted_keys: %q", text)
+		keys = append(keys, k)
+	}
+	return
+}
+
+// Export writes the whole knowledge base, journal included, to a
+// single file at path.  If key is not nil, it also writes a minisign
+// signature to path.minisig.
+func (g *Grokker) Export(path string, key *SecretKey) (err error) {
+	defer Return(&err)
+	// export what Save would write: the db's own model, not an
+	// override, and up-to-date checksums, with the shards' chunks
+	// in the one file
+	err = g.LoadShards()
+	Ck(err)
+	model, shards := g.Model, g.Shards
+	if g.modelOverride {
+		g.Model = g.modelFromDb
+	}
+	g.Shards = nil
+	g.setChecksums(g.Chunks)
+	data, err := json.Marshal(g)
+	g.Model, g.Shards = model, shards
+	Ck(err)
+	err = os.WriteFile(path, data, 0644)
+	Ck(err)
+	if key != nil {
+		comment := Spf("grok export of %s, version %s", filepath.Base(g.Root), g.Version)
+		err = os.WriteFile(path+".minisig", []byte(Sign(*key, data, comment)), 0644)
+		Ck(err)
+	}
+	return
+}
+
+// Import creates a .grok file in dir from an exported knowledge base.
+// If keys is not empty, the export must have a signature at
+// path.minisig made by one of the keys.  The chunks are checked
+// against their checksums either way.  It returns the trusted comment
+// of the signature, if any.
+func Import(path, dir string, keys []PublicKey) (trustedComment string, err error) {
+	defer Return(&err)
+	g, trustedComment, err := readExport(path, keys)
+	Ck(err)
+	dir, err = filepath.Abs(dir)
+	Ck(err)
+	grokpath := filepath.Join(dir, ".grok")
+	_, err = os.Stat(grokpath)
+	if err == nil {
+		err = fmt.Errorf("%s already exists", grokpath)
+		return
+	}
+	// the documents are found relative to the new root
+	g.Root = dir
+	data, err := json.Marshal(g)
+	Ck(err)
+	err = os.WriteFile(grokpath, data, 0644)
+	Ck(err)
+	return
+}
+
+// readExport reads an exported knowledge base, checking its
+// signature as for Import if keys is not empty, and its chunks
+// against their checksums.
+func readExport(path string, keys []PublicKey) (g *Grokker, trustedComment string, err error) {
+	defer Return(&err)
+	data, err := os.ReadFile(path)
+	Ck(err)
+	if len(keys) > 0 {
+		sigfile, err := os.ReadFile(path + ".minisig")
+		Ck(err, "a signature is required")
+		_, trustedComment, err = VerifySignature(keys, data, string(sigfile))
+		Ck(err, "%s", path)
+	}
+	g = &Grokker{}
+	err = json.Unmarshal(data, g)
+	Ck(err, "%s is not an exported knowledge base", path)
+	report := g.Verify()
+	if len(report.Problems) > 0 {
+		err = fmt.Errorf("%s is corrupt: %s", path, strings.Join(report.Problems, "; "))
+		return
+	}
+	return
+}
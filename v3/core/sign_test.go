@@ -0,0 +1,81 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSign(t *testing.T) {
+	pub, sec, err := GenerateKey()
+	Tassert(t, err == nil, "error generating key: %v", err)
+
+	// keys survive their file formats
+	pub2, err := ParsePublicKey(pub.String())
+	Tassert(t, err == nil && pub2.ID == pub.ID && pub2.Key.Equal(pub.Key), "public key round trip failed: %v", err)
+	line := strings.Split(pub.String(), "\n")[1]
+	_, err = ParsePublicKey(line)
+	Tassert(t, err == nil, "error parsing a bare public key: %v", err)
+	sec2, err := ParseSecretKey(sec.String())
+	Tassert(t, err == nil && sec2.Key.Equal(sec.Key), "secret key round trip failed: %v", err)
+
+	data := []byte("some index")
+	sig := Sign(sec, data, "release 1.0")
+	signer, comment, err := VerifySignature([]PublicKey{pub}, data, sig)
+	Tassert(t, err == nil && signer.ID == pub.ID && comment == "release 1.0", "unexpected verification %v %q %v", signer, comment, err)
+
+	_, _, err = VerifySignature([]PublicKey{pub}, []byte("some index!"), sig)
+	Tassert(t, err != nil, "expected an error for modified data")
+	other, _, err := GenerateKey()
+	Ck(err)
+	_, _, err = VerifySignature([]PublicKey{other}, data, sig)
+	Tassert(t, err != nil && strings.Contains(err.Error(), "unknown key"), "expected an unknown key error, got %v", err)
+	forged := strings.Replace(sig, "release 1.0", "release 2.0", 1)
+	_, _, err = VerifySignature([]PublicKey{pub}, data, forged)
+	Tassert(t, err != nil && strings.Contains(err.Error(), "trusted comment"), "expected a trusted comment error, got %v", err)
+}
+
+func TestExportImport(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Length: 1, Hash: "h0", Embedding: []float64{0.5}})
+	pub, sec, err := GenerateKey()
+	Ck(err)
+	fn := filepath.Join(dir, "kb.grok")
+	err = grok.Export(fn, &sec)
+	Tassert(t, err == nil, "error exporting: %v", err)
+
+	// unsigned import, then signed import
+	into := func(name string) string {
+		d := filepath.Join(dir, name)
+		err := os.MkdirAll(d, 0755)
+		Ck(err)
+		return d
+	}
+	_, err = Import(fn, into("a"), nil)
+	Tassert(t, err == nil, "error importing: %v", err)
+	comment, err := Import(fn, into("b"), []PublicKey{pub})
+	Tassert(t, err == nil && strings.Contains(comment, "grok export"), "unexpected import %q %v", comment, err)
+	g, _, _, _, lock, err := LoadFrom(filepath.Join(dir, "b", ".grok"), "", true)
+	Tassert(t, err == nil, "error loading import: %v", err)
+	lock.Unlock()
+	Tassert(t, g.Root == filepath.Join(dir, "b") && len(g.Chunks) == 1, "unexpected import %s %d", g.Root, len(g.Chunks))
+	_, err = Import(fn, into("b"), []PublicKey{pub})
+	Tassert(t, err != nil, "expected an error importing over an existing .grok")
+
+	// tampering is caught
+	buf, err := os.ReadFile(fn)
+	Ck(err)
+	err = os.WriteFile(fn, []byte(strings.Replace(string(buf), "0.5", "0.6", 1)), 0644)
+	Ck(err)
+	_, err = Import(fn, into("c"), []PublicKey{pub})
+	Tassert(t, err != nil && strings.Contains(err.Error(), "signature"), "expected a signature error, got %v", err)
+	_, err = Import(fn, into("d"), nil)
+	Tassert(t, err != nil && strings.Contains(err.Error(), "corrupt"), "expected a checksum error, got %v", err)
+}
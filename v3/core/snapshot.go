@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A snapshot is a frozen copy of the database, e.g. at a release
+// point.  Unlike the database itself, a snapshot stores the text of
+// each chunk, so questions asked against it see the documents as
+// they were when the snapshot was created, no matter how they have
+// changed since.
+
+// SnapshotInfo describes a stored snapshot.
+type SnapshotInfo struct {
+	Name    string
+	Created time.Time
+	Size    int64
+}
+
+func (s SnapshotInfo) String() string {
+	return Spf("%-30s %s %8d KB", s.Name, s.Created.Format("2006-01-02 15:04:05"), s.Size/1024)
+}
+
+// snapshotDir returns the directory where snapshots are stored.
+func (g *Grokker) snapshotDir() string {
+	return g.grokpath + ".snapshots"
+}
+
+// snapshotPath returns the path of the named snapshot.
+func (g *Grokker) snapshotPath(name string) (path string, err error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		err = fmt.Errorf("invalid snapshot name %q", name)
+		return
+	}
+	path = filepath.Join(g.snapshotDir(), name+".json")
+	return
+}
+
+// CreateSnapshot saves a snapshot of the database, including the
+// current text of every chunk, under the given name.
+func (g *Grokker) CreateSnapshot(name string) (err error) {
+	defer Return(&err)
+	path, err := g.snapshotPath(name)
+	Ck(err)
+	_, err = os.Stat(path)
+	if err == nil {
+		err = fmt.Errorf("snapshot %q already exists", name)
+		return
+	}
+	// a snapshot holds all of the chunks itself; see shard.go
+	err = g.LoadShards()
+	Ck(err)
+	// copy the chunks so we can fill in their text without
+	// touching the live database
+	var chunks []*Chunk
+	for _, c := range g.Chunks {
+		if c.Embedding == nil {
+			continue
+		}
+		var text string
+		var line int
+		text, line, err = g.rawChunkText(c)
+		Ck(err)
+		if text == "" {
+			// the document is missing
+			continue
+		}
+		sc := *c
+		sc.Text = text
+		sc.Line = line
+		chunks = append(chunks, &sc)
+	}
+	orig, shards := g.Chunks, g.Shards
+	g.Chunks, g.Shards = chunks, nil
+	buf, err := json.Marshal(g)
+	g.Chunks, g.Shards = orig, shards
+	Ck(err)
+	err = os.MkdirAll(g.snapshotDir(), 0755)
+	Ck(err)
+	tmpfn := path + ".tmp"
+	err = os.WriteFile(tmpfn, buf, 0644)
+	Ck(err)
+	err = os.Rename(tmpfn, path)
+	Ck(err)
+	return
+}
+
+// ListSnapshots returns the stored snapshots, oldest first.
+func (g *Grokker) ListSnapshots() (snaps []SnapshotInfo, err error) {
+	defer Return(&err)
+	entries, err := os.ReadDir(g.snapshotDir())
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		var fi os.FileInfo
+		fi, err = e.Info()
+		Ck(err)
+		snaps = append(snaps, SnapshotInfo{name, fi.ModTime(), fi.Size()})
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Created.Before(snaps[j].Created)
+	})
+	return
+}
+
+// LoadSnapshot returns the named snapshot as a read-only database
+// that can be queried like the live one, using the current model
+// and embedder.
+func (g *Grokker) LoadSnapshot(name string) (snap *Grokker, err error) {
+	defer Return(&err)
+	path, err := g.snapshotPath(name)
+	Ck(err)
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = fmt.Errorf("snapshot %q not found", name)
+		return
+	}
+	Ck(err)
+	snap = &Grokker{}
+	err = json.Unmarshal(buf, snap)
+	Ck(err)
+	snap.Root = g.Root
+	snap.grokpath = g.grokpath
+	snap.snapshot = name
+	err = snap.Setup(g.Model)
+	Ck(err)
+	return
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	fn := filepath.Join(dir, "a.txt")
+	err = os.WriteFile(fn, []byte("one\ntwo\nthree\n"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: 4, Length: 3, Hash: "h1", Embedding: []float64{1}})
+
+	err = grok.CreateSnapshot("v1")
+	Tassert(t, err == nil, "error creating snapshot: %v", err)
+	err = grok.CreateSnapshot("v1")
+	Tassert(t, err != nil, "expected error for duplicate snapshot")
+	err = grok.CreateSnapshot("../x")
+	Tassert(t, err != nil, "expected error for bad snapshot name")
+	Tassert(t, grok.Chunks[0].Text == "", "snapshot modified the live chunk")
+
+	// change the document after the snapshot
+	err = os.WriteFile(fn, []byte("uno\ndos\ntres\n"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+
+	snaps, err := grok.ListSnapshots()
+	Tassert(t, err == nil, "error listing snapshots: %v", err)
+	Tassert(t, len(snaps) == 1 && snaps[0].Name == "v1", "unexpected snapshots %v", snaps)
+
+	snap, err := grok.LoadSnapshot("v1")
+	Tassert(t, err == nil, "error loading snapshot: %v", err)
+	text, err := snap.chunkText(snap.Chunks[0], false, true)
+	Tassert(t, err == nil, "error getting chunk text: %v", err)
+	Tassert(t, text == "2: two\n", "unexpected snapshot text %q", text)
+	text, err = grok.chunkText(grok.Chunks[0], false, false)
+	Tassert(t, err == nil, "error getting chunk text: %v", err)
+	Tassert(t, text == "dos", "unexpected live text %q", text)
+}
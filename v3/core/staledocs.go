@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Documentation goes stale when the code it describes is renamed or
+// removed.  StaleDocs checks the API names and command-line flags
+// that the documentation in the knowledge base mentions against the
+// code in it, and reports the ones the code no longer contains.  API
+// names are taken from code spans, e.g. `SetFilter` or
+// `core.Filter`, and flags from anywhere in the text, including
+// examples.  Names are compared ignoring case, hyphens, and
+// underscores, so --context-tokens matches a ContextTokens field.
+// Like symbol extraction, this uses patterns rather than parsers,
+// so it can miss stale names and can flag names that are defined
+// outside the knowledge base, such as another program's flags.
+
+// docExts are the extensions of documentation files; every other
+// file is code.
+var docExts = []string{".md", ".markdown", ".mdx", ".rst", ".txt", ".adoc"}
+
+// StaleRef is a name mentioned in the documentation that the code
+// doesn't contain.
+type StaleRef struct {
+	RelPath string
+	Line    int
+	// Name is the name as the documentation gives it, e.g.
+	// SetFilter or --context-tokens.
+	Name string
+	// Kind is "symbol" or "flag".
+	Kind string
+}
+
+// String returns the reference as "path:line: kind name".
+func (r StaleRef) String() string {
+	return Spf("%s:%d: %s %s", r.RelPath, r.Line, r.Kind, r.Name)
+}
+
+// docFlagRe matches long command-line flags.
+var docFlagRe = regexp.MustCompile(`(?:^|[\s\x60(\[])--([a-z][a-z0-9]*(?:-[a-z0-9]+)*)\b`)
+
+// docSpanRe matches code spans that name an API, e.g. `Func`,
+// `Func()`, or `pkg.Type`.
+var docSpanRe = regexp.MustCompile("`([A-Za-z_][A-Za-z0-9_]*(?:\\.[A-Za-z_][A-Za-z0-9_]*)*)(\\(\\))?`")
+
+// codeWordRe matches the words in code from which names are
+// compared, including hyphenated words in strings, e.g. flag names.
+var codeWordRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(?:-[A-Za-z0-9_]+)*`)
+
+// isDocPath returns true if relpath is a documentation file.
+func isDocPath(relpath string) bool {
+	ext := strings.ToLower(path.Ext(relpath))
+	for _, e := range docExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeName lower cases a name and removes its hyphens and
+// underscores.
+func normalizeName(name string) string {
+	name = strings.ReplaceAll(name, "-", "")
+	name = strings.ReplaceAll(name, "_", "")
+	return strings.ToLower(name)
+}
+
+// StaleDocs returns the API names and flags mentioned in the
+// documentation that the code in the knowledge base doesn't contain.
+func (g *Grokker) StaleDocs() (refs []StaleRef, err error) {
+	defer Return(&err)
+	// the names in the code
+	names := make(map[string]bool)
+	var docs []*Document
+	for _, doc := range g.Documents {
+		if isDocPath(doc.RelPath) {
+			docs = append(docs, doc)
+			continue
+		}
+		buf, err := os.ReadFile(g.absPath(doc))
+		if os.IsNotExist(err) {
+			continue
+		}
+		Ck(err)
+		for _, word := range codeWordRe.FindAllString(string(buf), -1) {
+			names[normalizeName(word)] = true
+			// a hyphenated word may be a flag, or parts of
+			// code, like a-b
+			for _, part := range strings.Split(word, "-") {
+				names[normalizeName(part)] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		err = fmt.Errorf("the knowledge base has no code to check the documentation against")
+		return
+	}
+	for _, doc := range docs {
+		buf, err := os.ReadFile(g.absPath(doc))
+		if os.IsNotExist(err) {
+			continue
+		}
+		Ck(err)
+		refs = append(refs, staleRefs(doc.RelPath, buf, names)...)
+	}
+	return
+}
+
+// staleRefs returns the names mentioned in a document that aren't
+// among names.  Each name is reported once per document, at its
+// first mention.
+func staleRefs(relpath string, content []byte, names map[string]bool) (refs []StaleRef) {
+	seen := make(map[string]bool)
+	report := func(line int, name, kind string) {
+		key := normalizeName(name)
+		if names[key] || seen[kind+key] {
+			return
+		}
+		seen[kind+key] = true
+		refs = append(refs, StaleRef{RelPath: relpath, Line: line, Name: name, Kind: kind})
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	fenced := false
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(text), "```") {
+			fenced = !fenced
+			continue
+		}
+		for _, m := range docFlagRe.FindAllStringSubmatch(text, -1) {
+			report(line, "--"+m[1], "flag")
+		}
+		if fenced {
+			// examples are full of local names; only
+			// their flags are checked
+			continue
+		}
+		for _, m := range docSpanRe.FindAllStringSubmatch(text, -1) {
+			name := m[1]
+			if m[2] == "" && !looksLikeAPIName(name) {
+				// probably a command or an ordinary word
+				continue
+			}
+			// check the last part of a dotted name, since the
+			// package or receiver may be spelled differently
+			last := name
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				last = name[i+1:]
+			}
+			if names[normalizeName(last)] {
+				continue
+			}
+			report(line, name, "symbol")
+		}
+	}
+	return
+}
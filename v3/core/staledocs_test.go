@@ -0,0 +1,44 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestStaleDocs(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	code := "package main\n\ntype cli struct {\n\tContextTokens int\n\tListen string `name:\"listen-addr\"`\n}\n\nfunc SetFilter() {}\n"
+	doc := "# Usage\n\nCall `SetFilter()` or `core.SetFilter`, not `SetFilters` or `OldThing()`.\n" +
+		"Use `make` and --context-tokens, --listen-addr, or --verbose.\n\n" +
+		"```\nlocalVar := grok --verbose --dry-run\n```\n\nAgain: --verbose.\n"
+	files := map[string]string{"main.go": code, "README.md": doc}
+	for fn, content := range files {
+		err = os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Tassert(t, err == nil, "error writing file: %v", err)
+		grok.Documents = append(grok.Documents, &Document{RelPath: fn})
+	}
+
+	refs, err := grok.StaleDocs()
+	Tassert(t, err == nil, "error checking docs: %v", err)
+	want := []string{
+		"README.md:3: symbol SetFilters",
+		"README.md:3: symbol OldThing",
+		"README.md:4: flag --verbose",
+		"README.md:7: flag --dry-run",
+	}
+	Tassert(t, len(refs) == len(want), "expected %d stale names, got %v", len(want), refs)
+	for i, ref := range refs {
+		Tassert(t, ref.String() == want[i], "expected %q, got %q", want[i], ref)
+	}
+
+	// without code there is nothing to check against
+	grok.Documents = grok.Documents[:0]
+	grok.Documents = append(grok.Documents, &Document{RelPath: "README.md"})
+	_, err = grok.StaleDocs()
+	Tassert(t, err != nil, "expected an error without code")
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The stop-list keeps low-value chunks -- license headers,
+// generated-code banners, lockfiles -- out of the context we send
+// with queries.  Chunks on the stop-list stay in the database so we
+// don't re-check them on every update, but they are not embedded and
+// are never returned by a search.  Users can review the list with
+// 'grok stoplist' and allow individual chunks back in.
+
+// lockfiles are files whose chunks are always noise.
+var lockfiles = map[string]bool{
+	"go.sum":            true,
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+	"Cargo.lock":        true,
+	"Gemfile.lock":      true,
+	"poetry.lock":       true,
+	"composer.lock":     true,
+	"Pipfile.lock":      true,
+}
+
+// generatedRe matches the usual generated-code banners.
+var generatedRe = regexp.MustCompile(`(?i)(code generated .* do not edit|@generated|auto-?generated file|this file was automatically generated)`)
+
+// licensePhrases are phrases that only appear in license text.
+var licensePhrases = []string{
+	"spdx-license-identifier",
+	"licensed under the apache license",
+	"permission is hereby granted, free of charge",
+	"gnu general public license",
+	"gnu lesser general public license",
+	"mozilla public license",
+	"redistribution and use in source and binary forms",
+	"the software is provided \"as is\"",
+}
+
+// commentRe matches lines that are comments or blank in most
+// languages.
+var commentRe = regexp.MustCompile(`^\s*(//|#|\*|/\*|\*/|--|;|<!--|-->|$)`)
+
+// boilerplate returns the reason a chunk should be on the stop-list,
+// or an empty string if it shouldn't be.
+func boilerplate(relpath, text string) (reason string) {
+	if lockfiles[filepath.Base(relpath)] {
+		return "lockfile"
+	}
+	lines := strings.Split(text, "\n")
+	// a banner is only noise if it's most of the chunk
+	if generatedRe.MatchString(text) && len(lines) <= 10 {
+		return "generated-code banner"
+	}
+	lower := strings.ToLower(text)
+	for _, phrase := range licensePhrases {
+		if !strings.Contains(lower, phrase) {
+			continue
+		}
+		// a license header is mostly comments; a LICENSE file is
+		// all license
+		base := strings.ToUpper(filepath.Base(relpath))
+		if strings.HasPrefix(base, "LICENSE") || strings.HasPrefix(base, "COPYING") {
+			return "license text"
+		}
+		comments := 0
+		for _, line := range lines {
+			if commentRe.MatchString(line) {
+				comments++
+			}
+		}
+		if float64(comments) >= 0.8*float64(len(lines)) {
+			return "license header"
+		}
+	}
+	return ""
+}
+
+// StopList returns the chunks that are on the stop-list.
+func (g *Grokker) StopList() (chunks []*Chunk) {
+	for _, c := range g.Chunks {
+		if c.Excluded != "" {
+			chunks = append(chunks, c)
+		}
+	}
+	return
+}
+
+// StopListEntry returns a one-line description of a stop-listed
+// chunk for review.
+func (g *Grokker) StopListEntry(c *Chunk) (line string, err error) {
+	defer Return(&err)
+	text, err := g.chunkText(c, false, false)
+	Ck(err)
+	first := strings.TrimSpace(strings.SplitN(strings.TrimSpace(text), "\n", 2)[0])
+	if len(first) > 60 {
+		first = first[:60] + "..."
+	}
+	line = Spf("%.12s %-22s %s:%d %q", c.Hash, c.Excluded, c.Document.RelPath, c.Offset, first)
+	return
+}
+
+// AllowChunk takes the chunks whose hashes start with prefix off the
+// stop-list, permanently, and embeds them.
+func (g *Grokker) AllowChunk(prefix string) (err error) {
+	defer Return(&err)
+	var chunks []*Chunk
+	for _, c := range g.Chunks {
+		if c.Excluded != "" && strings.HasPrefix(c.Hash, prefix) {
+			chunks = append(chunks, c)
+		}
+	}
+	if len(chunks) == 0 {
+		err = fmt.Errorf("no stop-listed chunk matches %q", prefix)
+		return
+	}
+	if g.StopAllow == nil {
+		g.StopAllow = make(map[string]bool)
+	}
+	var embed []*Chunk
+	for _, c := range chunks {
+		g.StopAllow[c.Hash] = true
+		c.Excluded = ""
+		if c.Embedding == nil {
+			embed = append(embed, c)
+		}
+	}
+	err = g.embedStored(embed)
+	Ck(err)
+	return
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestBoilerplate(t *testing.T) {
+	cases := []struct {
+		path, text, reason string
+	}{
+		{"go.sum", "github.com/x/y v1.0.0 h1:abc=\n", "lockfile"},
+		{"a.go", "// Code generated by protoc-gen-go. DO NOT EDIT.\npackage a\n", "generated-code banner"},
+		{"a.go", "// Copyright 2024 Foo\n//\n// Licensed under the Apache License, Version 2.0\n// you may not use this file except in compliance\n", "license header"},
+		{"LICENSE", "Permission is hereby granted, free of charge, to any person\nobtaining a copy\n", "license text"},
+		// a license phrase in ordinary code is not boilerplate
+		{"a.go", "// see the GNU General Public License\nfunc main() {\n\tfmt.Println(1)\n\tfmt.Println(2)\n\tfmt.Println(3)\n}\n", ""},
+		{"a.go", "package a\n\nfunc A() {}\n", ""},
+	}
+	for _, c := range cases {
+		reason := boilerplate(c.path, c.text)
+		Tassert(t, reason == c.reason, "%s %q: expected %q, got %q", c.path, c.text, c.reason, reason)
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A store keeps the chunks of the database that aren't in a shard.
+// The rest of the database, the header, is small and always lives in
+// the db file.  The default "json" store keeps the chunks in the db
+// file too, with the changes since it was last written in the
+// journal; see journal.go.  The "sqlite" store keeps them as rows of
+// an SQLite database next to the db file, so a save writes only the
+// rows of the chunks that were added, changed, or removed; see
+// store_sqlite.go.  SQLite support is built with '-tags sqlite'.  Any
+// other kind names a store plugin, grok-store-<kind> on PATH, which
+// keeps the chunks where it likes, e.g. in a vector database; see
+// store_plugin.go.
+
+// stores maps the kinds of store Grokker.Store can name to the
+// suffix of the file each keeps next to the db file.
+var stores = map[string]string{
+	"json":   ".journal",
+	"sqlite": ".sqlite",
+}
+
+// store reads and writes the chunks of the database.
+type store interface {
+	// load reads the chunks into g.Chunks, after the db file has
+	// been read into g.
+	load(g *Grokker) error
+	// save writes g.Chunks and the header.
+	save(g *Grokker) error
+}
+
+// StoreKind returns the kind of store the database uses: "json",
+// "sqlite", or the name of a store plugin.
+func (g *Grokker) StoreKind() string {
+	if g.Store == "" {
+		return "json"
+	}
+	return g.Store
+}
+
+// getStore returns the database's store.
+func (g *Grokker) getStore() (s store, err error) {
+	switch g.StoreKind() {
+	case "json":
+		s = jsonStore{}
+	case "sqlite":
+		s, err = newSqliteStore()
+	default:
+		path, ok := findStorePlugin(g.Store)
+		if !ok {
+			err = fmt.Errorf("unknown store %q; expected json, sqlite, or a store plugin, but there is no %s%s on PATH", g.Store, storePluginPrefix, g.Store)
+			return
+		}
+		s = pluginStore{name: g.Store, path: path}
+	}
+	return
+}
+
+// SetStore moves the chunks to another kind of store, "json",
+// "sqlite", or a store plugin, saves the database, and removes the
+// old store's file, if it has one.
+func (g *Grokker) SetStore(kind string) (err error) {
+	defer Return(&err)
+	old := g.StoreKind()
+	if kind == old {
+		return
+	}
+	g.Store = kind
+	if kind == "json" {
+		g.Store = ""
+	}
+	_, err = g.getStore()
+	if err != nil {
+		g.Store = old
+		Ck(err)
+	}
+	// the new store has none of the chunks yet
+	g.savedSigs = nil
+	err = g.Save()
+	Ck(err)
+	suffix, ok := stores[old]
+	if !ok {
+		// a plugin's chunks are its to clean up
+		return
+	}
+	err = os.Remove(g.grokpath + suffix)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	Ck(err)
+	return
+}
+
+// changedChunks returns the chunks that were added or changed since
+// the last save, and the hashes of those that were removed.  Chunks
+// from before checksums were added count as changed once, to add
+// theirs.
+func (g *Grokker) changedChunks() (changed []*Chunk, removed []string) {
+	current := make(map[string]bool, len(g.Chunks))
+	for _, c := range g.Chunks {
+		current[c.Hash] = true
+		sig, ok := g.savedSigs[c.Hash]
+		if !ok || sig != chunkSig(c) || c.Sum == "" {
+			changed = append(changed, c)
+		}
+	}
+	for hash := range g.savedSigs {
+		if !current[hash] {
+			removed = append(removed, hash)
+		}
+	}
+	return
+}
+
+// writeFile replaces the db file with data.
+func (g *Grokker) writeFile(data []byte) (err error) {
+	defer Return(&err)
+	tmpfn := g.grokpath + ".tmp"
+	err = os.WriteFile(tmpfn, data, 0644)
+	Ck(err)
+	err = os.Rename(tmpfn, g.grokpath)
+	Ck(err)
+	return
+}
+
+// jsonStore keeps the chunks in the db file and the journal.
+type jsonStore struct{}
+
+// load applies the journal, if any, to the chunks read from the db
+// file.
+func (jsonStore) load(g *Grokker) error {
+	return g.replayJournal()
+}
+
+// save appends the changes to the journal, or rewrites the db file
+// and removes the journal once the journal has grown too large.
+func (jsonStore) save(g *Grokker) (err error) {
+	defer Return(&err)
+	if !g.needsCompaction() {
+		err = g.appendJournal()
+		Ck(err)
+		return
+	}
+	Debug("saving grok file")
+	g.setChecksums(g.Chunks)
+	data, err := json.Marshal(g)
+	Ck(err)
+	err = g.writeFile(data)
+	Ck(err)
+	// the db file now includes everything in the journal
+	err = os.Remove(g.journalPath())
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	Ck(err)
+	Debug(" done!")
+	return
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// pluginStore keeps the chunks wherever a grok-store-<name> plugin
+// does, e.g. in a vector database; see plugin.go.  The plugin is run
+// in the directory of the db file with GROKKER_DB set to the db
+// file's absolute path, and one argument:
+//
+//   - "load" writes the chunks to stdout, one JSON object per line,
+//     in the order they were first stored.
+//   - "save" reads changes from stdin, one JSON object per line, and
+//     applies them all or none: {"reset": true} removes every chunk,
+//     {"put": chunk} adds a chunk or replaces the one with the same
+//     hash in place, and {"delete": hash} removes one.
+//
+// Like the sqlite store, a save sends only the chunks that were
+// added, changed, or removed, and the db file keeps the header.
+// Search still runs in grok over the loaded chunks; a plugin decides
+// where they live, not how they are ranked.
+type pluginStore struct {
+	name string
+	path string
+}
+
+// storeChange is a line of the input to a store plugin's save.
+type storeChange struct {
+	Reset  bool   `json:"reset,omitempty"`
+	Put    *Chunk `json:"put,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+// run runs the plugin with op, with stdin as its input, and returns
+// its output.
+func (s pluginStore) run(g *Grokker, op string, stdin io.Reader) (out []byte, err error) {
+	defer Return(&err)
+	abs, err := filepath.Abs(g.grokpath)
+	Ck(err)
+	cmd := exec.Command(s.path, op)
+	cmd.Dir = filepath.Dir(abs)
+	cmd.Env = append(os.Environ(), "GROKKER_DB="+abs)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err = cmd.Output()
+	Ck(err, "store %s %s: %s", s.name, op, strings.TrimSpace(stderr.String()))
+	return
+}
+
+// load reads the chunks from the plugin.
+func (s pluginStore) load(g *Grokker) (err error) {
+	defer Return(&err)
+	out, err := s.run(g, "load", nil)
+	Ck(err)
+	g.Chunks = nil
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		c := &Chunk{}
+		err = dec.Decode(c)
+		if errors.Is(err, io.EOF) {
+			err = nil
+			break
+		}
+		Ck(err, "store %s", s.name)
+		g.Chunks = append(g.Chunks, c)
+	}
+	return
+}
+
+// save sends the plugin the chunks that were added, changed, or
+// removed since the last save, and then writes the header to the db
+// file.
+func (s pluginStore) save(g *Grokker) (err error) {
+	defer Return(&err)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if g.savedSigs == nil {
+		// we don't know what the plugin has
+		err = enc.Encode(storeChange{Reset: true})
+		Ck(err)
+	}
+	changed, removed := g.changedChunks()
+	// the header carries the store checksum, so it comes last
+	g.setChecksums(changed)
+	for _, c := range changed {
+		err = enc.Encode(storeChange{Put: c})
+		Ck(err)
+	}
+	for _, hash := range removed {
+		err = enc.Encode(storeChange{Delete: hash})
+		Ck(err)
+	}
+	_, err = s.run(g, "save", &buf)
+	Ck(err)
+	Debug("stored %d chunks, removed %d", len(changed), len(removed))
+	header, err := g.header()
+	Ck(err)
+	err = g.writeFile(header)
+	Ck(err)
+	return
+}
@@ -0,0 +1,156 @@
+//go:build sqlite
+
+package core
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	. "github.com/stevegt/goadapt"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore keeps the chunks as rows of an SQLite database next to
+// the db file, which then holds only the header.  The embedding of a
+// row is a blob of little-endian float64s rather than JSON, and rows
+// keep the order they were added in, as the chunks in the db file
+// do.
+type sqliteStore struct{}
+
+// sqliteSchema creates the chunks table.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS chunks (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash TEXT NOT NULL UNIQUE,
+	chunk TEXT NOT NULL,
+	embedding BLOB
+)`
+
+// sqliteUpsert adds a chunk, or replaces the one with the same hash
+// in place.
+const sqliteUpsert = `INSERT INTO chunks (hash, chunk, embedding) VALUES (?, ?, ?)
+	ON CONFLICT(hash) DO UPDATE SET chunk = excluded.chunk, embedding = excluded.embedding`
+
+// newSqliteStore returns the SQLite store.
+func newSqliteStore() (s store, err error) {
+	return sqliteStore{}, nil
+}
+
+// open opens the SQLite database of g, creating it if needed.
+func (sqliteStore) open(g *Grokker) (db *sql.DB, err error) {
+	defer Return(&err)
+	path := g.grokpath + stores["sqlite"]
+	db, err = sql.Open("sqlite", path)
+	Ck(err)
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		Ck(err, "%s", path)
+	}
+	return
+}
+
+// load reads the chunks from the table.
+func (s sqliteStore) load(g *Grokker) (err error) {
+	defer Return(&err)
+	db, err := s.open(g)
+	Ck(err)
+	defer db.Close()
+	rows, err := db.Query("SELECT chunk, embedding FROM chunks ORDER BY seq")
+	Ck(err)
+	defer rows.Close()
+	g.Chunks = nil
+	for rows.Next() {
+		var buf, vec []byte
+		err = rows.Scan(&buf, &vec)
+		Ck(err)
+		c := &Chunk{}
+		err = json.Unmarshal(buf, c)
+		Ck(err)
+		c.Embedding, err = decodeVector(vec)
+		Ck(err, "chunk %s", c.Hash)
+		g.Chunks = append(g.Chunks, c)
+	}
+	err = rows.Err()
+	Ck(err)
+	return
+}
+
+// save writes the rows of the chunks that were added, changed, or
+// removed since the last save in one transaction, and then the
+// header to the db file.
+func (s sqliteStore) save(g *Grokker) (err error) {
+	defer Return(&err)
+	db, err := s.open(g)
+	Ck(err)
+	defer db.Close()
+	tx, err := db.Begin()
+	Ck(err)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if g.savedSigs == nil {
+		// we don't know what's in the table
+		_, err = tx.Exec("DELETE FROM chunks")
+		Ck(err)
+	}
+	changed, removed := g.changedChunks()
+	// the header carries the store checksum, so it comes last
+	g.setChecksums(changed)
+	upsert, err := tx.Prepare(sqliteUpsert)
+	Ck(err)
+	defer upsert.Close()
+	for _, c := range changed {
+		row := *c
+		row.Embedding = nil
+		buf, err := json.Marshal(&row)
+		Ck(err)
+		_, err = upsert.Exec(c.Hash, buf, encodeVector(c.Embedding))
+		Ck(err)
+	}
+	for _, hash := range removed {
+		_, err = tx.Exec("DELETE FROM chunks WHERE hash = ?", hash)
+		Ck(err)
+	}
+	err = tx.Commit()
+	Ck(err)
+	Debug("stored %d chunks, removed %d", len(changed), len(removed))
+	header, err := g.header()
+	Ck(err)
+	err = g.writeFile(header)
+	Ck(err)
+	return
+}
+
+// encodeVector returns vec as little-endian float64s, or nil for a
+// chunk without an embedding.
+func encodeVector(vec []float64) (buf []byte) {
+	if vec == nil {
+		return nil
+	}
+	buf = make([]byte, 8*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint64(buf[8*i:], math.Float64bits(v))
+	}
+	return
+}
+
+// decodeVector reverses encodeVector.
+func decodeVector(buf []byte) (vec []float64, err error) {
+	if buf == nil {
+		return
+	}
+	if len(buf)%8 != 0 {
+		err = fmt.Errorf("embedding is %d bytes, not a multiple of 8", len(buf))
+		return
+	}
+	vec = make([]float64, len(buf)/8)
+	for i := range vec {
+		vec[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[8*i:]))
+	}
+	return
+}
@@ -0,0 +1,11 @@
+//go:build !sqlite
+
+package core
+
+import "fmt"
+
+// newSqliteStore is a stub for builds without SQLite support.
+func newSqliteStore() (s store, err error) {
+	err = fmt.Errorf("the sqlite store needs SQLite support; rebuild grokker with '-tags sqlite'")
+	return
+}
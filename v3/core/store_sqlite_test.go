@@ -0,0 +1,70 @@
+//go:build sqlite
+
+package core
+
+import (
+	"database/sql"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSqliteRows(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	a := &Document{RelPath: "a.txt"}
+	b := &Document{RelPath: "b.txt"}
+	grok.Documents = append(grok.Documents, a, b)
+	for i, doc := range []*Document{a, a, b} {
+		grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: i, Length: 1, Hash: Spf("h%d", i), Embedding: []float64{float64(i)}})
+	}
+	err = grok.SetStore("sqlite")
+	Ck(err)
+
+	// rows returns the seq of each row by hash
+	rows := func() map[string]int {
+		db, err := sql.Open("sqlite", grok.grokpath+stores["sqlite"])
+		Ck(err)
+		defer db.Close()
+		rs, err := db.Query("SELECT hash, seq FROM chunks")
+		Ck(err)
+		defer rs.Close()
+		seqs := make(map[string]int)
+		for rs.Next() {
+			var hash string
+			var seq int
+			err = rs.Scan(&hash, &seq)
+			Ck(err)
+			seqs[hash] = seq
+		}
+		Ck(rs.Err())
+		return seqs
+	}
+	before := rows()
+	Tassert(t, len(before) == 3, "got rows %v", before)
+
+	// an added chunk is a new row, and the others are left alone
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: b, Offset: 3, Length: 1, Hash: "h3", Embedding: []float64{3}})
+	err = grok.Save()
+	Ck(err)
+	after := rows()
+	Tassert(t, len(after) == 4 && after["h3"] > before["h2"], "got rows %v", after)
+	for hash, seq := range before {
+		Tassert(t, after[hash] == seq, "row %s moved from %d to %d", hash, seq, after[hash])
+	}
+
+	// collecting the chunks of a forgotten document deletes their
+	// rows
+	grok.Documents = grok.Documents[1:]
+	err = grok.gc()
+	Ck(err)
+	err = grok.Save()
+	Ck(err)
+	after = rows()
+	Tassert(t, len(after) == 2 && after["h2"] == before["h2"] && after["h3"] > 0, "got rows %v", after)
+	g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", true)
+	Ck(err)
+	lock.Unlock()
+	Tassert(t, len(g.Chunks) == 2 && g.Chunks[0].Hash == "h2" && g.Chunks[1].Hash == "h3", "got %d chunks", len(g.Chunks))
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestStore(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	for i := 0; i < 3; i++ {
+		grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: i, Length: 1, Hash: Spf("h%d", i), Embedding: []float64{float64(i), 0.5}})
+	}
+	err = grok.Save()
+	Ck(err)
+
+	err = grok.SetStore("bdb")
+	Tassert(t, err != nil, "expected an error for an unknown store")
+	if _, err := newSqliteStore(); err != nil {
+		err = grok.SetStore("sqlite")
+		Tassert(t, err != nil && grok.StoreKind() == "json", "expected an error without SQLite support, got %v", err)
+		t.Skip("built without SQLite support")
+	}
+
+	load := func() *Grokker {
+		g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", true)
+		Tassert(t, err == nil, "error loading: %v", err)
+		lock.Unlock()
+		report := g.Verify()
+		Tassert(t, len(report.Problems) == 0, "verify: %v", report.Problems)
+		return g
+	}
+
+	err = grok.SetStore("sqlite")
+	Tassert(t, err == nil, "error moving to sqlite: %v", err)
+	_, err = os.Stat(grok.journalPath())
+	Tassert(t, os.IsNotExist(err), "expected no journal")
+	g := load()
+	Tassert(t, g.StoreKind() == "sqlite" && len(g.Chunks) == 3, "got %s, %d chunks", g.StoreKind(), len(g.Chunks))
+	Tassert(t, g.Chunks[2].Embedding[0] == 2 && g.Chunks[2].Embedding[1] == 0.5, "got %v", g.Chunks[2].Embedding)
+
+	// saves change only the rows of changed chunks, which keep
+	// their place
+	grok.Chunks = grok.Chunks[1:]
+	grok.Chunks[0].Excluded = "stop"
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: 9, Length: 1, Hash: "h9"})
+	err = grok.Save()
+	Ck(err)
+	g = load()
+	var hashes string
+	for _, c := range g.Chunks {
+		hashes += c.Hash + " "
+	}
+	Tassert(t, hashes == "h1 h2 h9 ", "got %s", hashes)
+	Tassert(t, g.Chunks[0].Excluded == "stop" && g.Chunks[2].Embedding == nil, "got %v", g.Chunks)
+
+	err = grok.SetStore("json")
+	Tassert(t, err == nil, "error moving to json: %v", err)
+	_, err = os.Stat(grok.grokpath + stores["sqlite"])
+	Tassert(t, os.IsNotExist(err), "expected no sqlite file")
+	g = load()
+	Tassert(t, g.StoreKind() == "json" && len(g.Chunks) == 3, "got %s, %d chunks", g.StoreKind(), len(g.Chunks))
+}
+
+func TestStorePlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test plugin is a shell script")
+	}
+	bin := TmpTestDir()
+	script := Spf("#!/bin/sh\nGROKKER_STORE_PLUGIN_TEST=1 exec %q -test.run='^TestStorePluginProcess$' -- \"$@\"\n", os.Args[0])
+	err := os.WriteFile(filepath.Join(bin, storePluginPrefix+"lines"), []byte(script), 0755)
+	Ck(err)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	for i := 0; i < 3; i++ {
+		grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: i, Length: 1, Hash: Spf("h%d", i), Embedding: []float64{float64(i), 0.5}})
+	}
+	err = grok.Save()
+	Ck(err)
+
+	err = grok.SetStore("nosuch")
+	Tassert(t, err != nil && strings.Contains(err.Error(), storePluginPrefix+"nosuch"), "expected an error for a missing plugin, got %v", err)
+	Tassert(t, grok.StoreKind() == "json", "got %s", grok.StoreKind())
+
+	load := func() *Grokker {
+		g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", true)
+		Tassert(t, err == nil, "error loading: %v", err)
+		lock.Unlock()
+		report := g.Verify()
+		Tassert(t, len(report.Problems) == 0, "verify: %v", report.Problems)
+		return g
+	}
+	changes := func() string {
+		buf, err := os.ReadFile(grok.grokpath + ".lines.log")
+		Ck(err)
+		return string(buf)
+	}
+
+	err = grok.SetStore("lines")
+	Tassert(t, err == nil, "error moving to the plugin: %v", err)
+	Tassert(t, changes() == "reset put put put\n", "got %q", changes())
+	g := load()
+	Tassert(t, g.StoreKind() == "lines" && len(g.Chunks) == 3, "got %s, %d chunks", g.StoreKind(), len(g.Chunks))
+	Tassert(t, g.Chunks[2].Embedding[0] == 2 && g.Chunks[2].Embedding[1] == 0.5, "got %v", g.Chunks[2].Embedding)
+
+	// saves send only the changed chunks, which keep their place
+	grok.Chunks = grok.Chunks[1:]
+	grok.Chunks[0].Excluded = "stop"
+	grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: 9, Length: 1, Hash: "h9"})
+	err = grok.Save()
+	Ck(err)
+	Tassert(t, strings.HasSuffix(changes(), "\nput put delete\n"), "got %q", changes())
+	g = load()
+	var hashes string
+	for _, c := range g.Chunks {
+		hashes += c.Hash + " "
+	}
+	Tassert(t, hashes == "h1 h2 h9 ", "got %s", hashes)
+	Tassert(t, g.Chunks[0].Excluded == "stop" && g.Chunks[2].Embedding == nil, "got %v", g.Chunks)
+
+	// moving away leaves the plugin's chunks to it
+	err = grok.SetStore("json")
+	Tassert(t, err == nil, "error moving to json: %v", err)
+	g = load()
+	Tassert(t, g.StoreKind() == "json" && len(g.Chunks) == 3, "got %s, %d chunks", g.StoreKind(), len(g.Chunks))
+}
+
+// TestStorePluginProcess is the grok-store-lines plugin run by
+// TestStorePlugin.  It keeps the chunks as lines of <db>.lines, and
+// appends the kinds of the changes of each save to <db>.lines.log.
+func TestStorePluginProcess(t *testing.T) {
+	if os.Getenv("GROKKER_STORE_PLUGIN_TEST") != "1" {
+		return
+	}
+	db := os.Getenv("GROKKER_DB")
+	var hashes []string
+	chunks := make(map[string]json.RawMessage)
+	buf, err := os.ReadFile(db + ".lines")
+	if err != nil && !os.IsNotExist(err) {
+		Ck(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		if line == "" {
+			continue
+		}
+		var c Chunk
+		err = json.Unmarshal([]byte(line), &c)
+		Ck(err)
+		hashes = append(hashes, c.Hash)
+		chunks[c.Hash] = json.RawMessage(line)
+	}
+	switch os.Args[len(os.Args)-1] {
+	case "load":
+		for _, hash := range hashes {
+			Pl(string(chunks[hash]))
+		}
+	case "save":
+		var kinds []string
+		dec := json.NewDecoder(os.Stdin)
+		for {
+			var change struct {
+				Reset  bool            `json:"reset"`
+				Put    json.RawMessage `json:"put"`
+				Delete string          `json:"delete"`
+			}
+			err = dec.Decode(&change)
+			if err == io.EOF {
+				break
+			}
+			Ck(err)
+			switch {
+			case change.Reset:
+				kinds = append(kinds, "reset")
+				hashes = nil
+				chunks = make(map[string]json.RawMessage)
+			case change.Put != nil:
+				kinds = append(kinds, "put")
+				var c Chunk
+				err = json.Unmarshal(change.Put, &c)
+				Ck(err)
+				if _, ok := chunks[c.Hash]; !ok {
+					hashes = append(hashes, c.Hash)
+				}
+				chunks[c.Hash] = change.Put
+			case change.Delete != "":
+				kinds = append(kinds, "delete")
+				delete(chunks, change.Delete)
+				for i, hash := range hashes {
+					if hash == change.Delete {
+						hashes = append(hashes[:i], hashes[i+1:]...)
+						break
+					}
+				}
+			}
+		}
+		var out bytes.Buffer
+		for _, hash := range hashes {
+			out.Write(chunks[hash])
+			out.WriteString("\n")
+		}
+		err = os.WriteFile(db+".lines", out.Bytes(), 0644)
+		Ck(err)
+		f, err := os.OpenFile(db+".lines.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		Ck(err)
+		Fpf(f, "%s\n", strings.Join(kinds, " "))
+		f.Close()
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	oai "github.com/sashabaranov/go-openai"
+	. "github.com/stevegt/goadapt"
+)
+
+func TestAnswerStream(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", TmpTestDir())
+	var streamed []bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req oai.ChatCompletionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		Ck(err)
+		streamed = append(streamed, req.Stream)
+		if !req.Stream {
+			w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Certainly!\nWidgets are blue."}}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`))
+			return
+		}
+		Tassert(t, req.StreamOptions != nil && req.StreamOptions.IncludeUsage, "expected a request for usage")
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range []string{"Widgets ", "are ", "blue."} {
+			w.Write([]byte(`data: {"choices": [{"index": 0, "delta": {"content": "` + piece + `"}}]}` + "\n\n"))
+		}
+		w.Write([]byte(`data: {"choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}]}` + "\n\n"))
+		w.Write([]byte(`data: {"choices": [], "usage": {"prompt_tokens": 10, "completion_tokens": 3, "total_tokens": 13}}` + "\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer srv.Close()
+
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	config := oai.DefaultConfig("testkey")
+	config.BaseURL = srv.URL + "/v1"
+	grok.chatClient = oai.NewClientWithConfig(config)
+
+	var pieces []string
+	collect := func(text string) error {
+		pieces = append(pieces, text)
+		return nil
+	}
+	resp, err := grok.AnswerStream("what color are widgets?", false, false, false, collect)
+	Tassert(t, err == nil, "error streaming: %v", err)
+	Tassert(t, resp == "Widgets are blue.", "got %q", resp)
+	Tassert(t, strings.Join(pieces, "|") == "Widgets |are |blue.", "got pieces %q", pieces)
+	Tassert(t, len(streamed) == 1 && streamed[0], "expected a streamed request, got %v", streamed)
+	Tassert(t, grok.TokensUsed() == 13, "expected the streamed usage, got %d", grok.TokensUsed())
+
+	// an answer that is rewritten once complete comes in one piece
+	grok.Pipeline.PostProcess = []string{"strip"}
+	pieces = nil
+	resp, err = grok.AnswerStream("what color are widgets?", false, false, false, collect)
+	Tassert(t, err == nil, "error answering: %v", err)
+	Tassert(t, resp == "Widgets are blue.\n", "got %q", resp)
+	Tassert(t, len(pieces) == 1 && pieces[0] == resp, "got pieces %q", pieces)
+	Tassert(t, !streamed[len(streamed)-1], "expected a request that isn't streamed")
+	Tassert(t, grok.stream == nil, "the stream wasn't cleared")
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// A chunk is a narrow view of its document.  When the pipeline has
+// a summarizer, a retrieved chunk whose document is no longer than
+// Pipeline.SummaryMaxTokens is replaced in the context by a summary
+// of the whole document, which gives the model the higher-level
+// picture.  Summaries are kept in the embedding cache, keyed by the
+// summarizer and the document's content, so each version of a
+// document is only summarized once.
+
+// defaultSummaryMaxTokens is used when SummaryMaxTokens is not set.
+const defaultSummaryMaxTokens = 2000
+
+// summarySysmsg is the system message for the llm summarizer.
+const summarySysmsg = "Summarize the document you are given so the summary can stand in for it when answering questions about it.  Keep names, identifiers, numbers, and key facts; leave out examples and boilerplate.  Reply with the summary only."
+
+// checkSummarizer returns an error if the pipeline's summarizer spec
+// isn't usable.
+func (g *Grokker) checkSummarizer() (err error) {
+	spec := g.Pipeline.Summarizer
+	kind, command, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "", spec == "llm":
+	case kind == "cmd" && strings.TrimSpace(command) != "":
+	default:
+		err = fmt.Errorf("unknown summarizer %q; use llm or cmd:<command>", spec)
+	}
+	return
+}
+
+// docSummary returns a summary of the document to use as context in
+// place of its chunks, or false if the pipeline has no summarizer or
+// the document is too long to summarize.
+func (g *Grokker) docSummary(doc *Document) (summary string, ok bool, err error) {
+	defer Return(&err)
+	spec := g.Pipeline.Summarizer
+	// a snapshot's documents may have changed since
+	if spec == "" || doc == nil || g.snapshot != "" {
+		return
+	}
+	maxTokens := g.Pipeline.SummaryMaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultSummaryMaxTokens
+	}
+	buf, err := os.ReadFile(g.contentPath(doc))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	// skip reading the tokens of documents that are obviously
+	// too long
+	if len(buf) > maxTokens*8 {
+		return
+	}
+	tokens, err := g.tokens(string(buf))
+	Ck(err)
+	if len(tokens) > maxTokens {
+		return
+	}
+	key := g.summaryCacheKey(buf)
+	if !g.noEmbeddingCache && g.cacheGet("embeddings", key, &summary) {
+		return summary, true, nil
+	}
+	Debug("summarizing %s with %s", doc.RelPath, spec)
+	if spec == "llm" {
+		input := Spf("Document %s:\n\n%s", doc.RelPath, buf)
+		resp, err := g.msg(summarySysmsg, input)
+		Ck(err)
+		summary = resp.Choices[0].Message.Content
+	} else {
+		_, command, _ := strings.Cut(spec, ":")
+		summary, err = summarizeWith(command, doc.RelPath, buf)
+		Ck(err)
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return
+	}
+	if !g.noEmbeddingCache {
+		g.cachePut("embeddings", key, summary)
+	}
+	return summary, true, nil
+}
+
+// summaryCacheKey returns the embedding cache key for the summary of
+// a document with the given content.
+func (g *Grokker) summaryCacheKey(content []byte) string {
+	spec := g.Pipeline.Summarizer
+	model := ""
+	if spec == "llm" {
+		model = g.Model
+	}
+	return cacheKey("summary", spec, model, string(content))
+}
+
+// summarizeWith runs a summarizer command with the document on stdin
+// and GROKKER_DOCUMENT set to its path, and returns its stdout.
+func summarizeWith(command, relpath string, content []byte) (summary string, err error) {
+	defer Return(&err)
+	cmd, err := util.Command(command)
+	Ck(err)
+	cmd.Env = append(os.Environ(), "GROKKER_DOCUMENT="+relpath)
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	Ck(err, "summarizer %q: %s", command, strings.TrimSpace(stderr.String()))
+	summary = string(out)
+	return
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSummarizer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses tr")
+	}
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	grok.SetCaches(false, false)
+	err = os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a short document\n"), 0644)
+	Tassert(t, err == nil, "error writing document: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+
+	// no summarizer
+	_, ok, err := grok.docSummary(doc)
+	Tassert(t, err == nil && !ok, "unexpected summary: %v %v", ok, err)
+
+	err = grok.SetPipeline("summarizer", "bogus")
+	Tassert(t, err != nil, "expected error for an unknown summarizer")
+	err = grok.SetPipeline("summarizer", "cmd:tr a-z A-Z")
+	Tassert(t, err == nil, "error setting summarizer: %v", err)
+	summary, ok, err := grok.docSummary(doc)
+	Tassert(t, err == nil && ok, "expected a summary: %v %v", ok, err)
+	Tassert(t, summary == "A SHORT DOCUMENT", "unexpected summary %q", summary)
+
+	// long documents keep their chunks
+	grok.Pipeline.SummaryMaxTokens = 1
+	_, ok, err = grok.docSummary(doc)
+	Tassert(t, err == nil && !ok, "unexpected summary: %v %v", ok, err)
+
+	// a failing command is an error
+	grok.Pipeline.SummaryMaxTokens = 0
+	grok.Pipeline.Summarizer = "cmd:false"
+	_, _, err = grok.docSummary(doc)
+	Tassert(t, err != nil, "expected error from a failing summarizer")
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// We extract symbols from each chunk while indexing so queries can be
+// filtered to chunks that define or mention a given function, type,
+// or API name, and so a query that names a symbol can prefer the
+// chunks that contain it.  Chunks are fragments of files that
+// usually don't parse on their own, so we use patterns rather than
+// language parsers.
+
+// maxSymbols limits the number of symbols stored per chunk.  It
+// only limits the mentions; a chunk keeps all of its definitions.
+const maxSymbols = 64
+
+// symbolBoost is added to the similarity score of chunks that
+// contain a symbol named in the query.
+const symbolBoost = 0.05
+
+// defRe matches definitions in the common languages: Go, Python,
+// Ruby, Rust, JavaScript/TypeScript, Java, C-like, and shell.
+var defRe = regexp.MustCompile(`(?m)^\s*(?:export\s+)?(?:pub\s+)?(?:async\s+)?` +
+	`(?:func\s+(?:\([^)]*\)\s*)?|type\s+|def\s+|class\s+|struct\s+|enum\s+|trait\s+|interface\s+|fn\s+|function\s+|module\s+)` +
+	`([A-Za-z_][A-Za-z0-9_]*)`)
+
+// identRe matches identifiers, optionally dotted, e.g. pkg.Func.
+var identRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*`)
+
+// codeSpanRe matches markdown code spans, which usually name APIs.
+var codeSpanRe = regexp.MustCompile("`([A-Za-z_][A-Za-z0-9_.]*)(?:\\(\\))?`")
+
+// extractSymbols returns the sorted, unique symbols in text.
+// Definitions are always included; other identifiers are included
+// if they look like API names rather than ordinary words, i.e.
+// camelCase, snake_case, or dotted, until there are maxSymbols.
+func extractSymbols(text string) (symbols []string) {
+	seen := make(map[string]bool)
+	add := func(sym string) {
+		if len(sym) < 3 || seen[sym] {
+			return
+		}
+		seen[sym] = true
+		symbols = append(symbols, sym)
+	}
+	for _, m := range defRe.FindAllStringSubmatch(text, -1) {
+		add(m[1])
+	}
+	// the mentions, in the order they appear
+	var mentions []string
+	for _, m := range codeSpanRe.FindAllStringSubmatch(text, -1) {
+		mentions = append(mentions, m[1])
+	}
+	for _, ident := range identRe.FindAllString(text, -1) {
+		if looksLikeAPIName(ident) {
+			mentions = append(mentions, ident)
+			// also index the final part of a dotted name, so
+			// --symbol Func matches pkg.Func
+			if i := strings.LastIndex(ident, "."); i >= 0 {
+				mentions = append(mentions, ident[i+1:])
+			}
+		}
+	}
+	for _, sym := range mentions {
+		if len(symbols) >= maxSymbols {
+			break
+		}
+		add(sym)
+	}
+	sort.Strings(symbols)
+	return
+}
+
+// looksLikeAPIName returns true for identifiers that are unlikely to
+// be ordinary words.
+func looksLikeAPIName(ident string) bool {
+	if i := strings.LastIndex(ident, "."); i >= 0 {
+		// pkg.Func or obj.method, but not "e.g" or file names
+		// like "notes.txt"
+		last := ident[i+1:]
+		if len(last) < 2 {
+			return false
+		}
+		return len(last) > 4 || strings.ToLower(last) != last
+	}
+	if strings.Contains(strings.Trim(ident, "_"), "_") {
+		return true
+	}
+	// an upper case letter after a lower case one: camelCase or
+	// MixedCase
+	runes := []rune(ident)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSymbol returns true if the chunk has the symbol.  The match is
+// case-insensitive, since users often don't remember whether a name
+// is exported.
+func (c *Chunk) hasSymbol(sym string) bool {
+	for _, s := range c.Symbols {
+		if strings.EqualFold(s, sym) {
+			return true
+		}
+	}
+	return false
+}
+
+// querySymbols returns the symbols named in a query, lower cased.
+func querySymbols(query string) (mentions map[string]bool) {
+	mentions = make(map[string]bool)
+	for _, sym := range extractSymbols(query) {
+		mentions[strings.ToLower(sym)] = true
+	}
+	return
+}
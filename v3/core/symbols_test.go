@@ -0,0 +1,48 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestExtractSymbols(t *testing.T) {
+	text := "// findChunks returns the most relevant chunks.\n" +
+		"func (g *Grokker) findChunks(query string) {\n" +
+		"\tsort.Slice(sims, nil)\n" +
+		"\tmax_tokens := g.TokenLimit\n" +
+		"}\n" +
+		"type Sim struct{}\n" +
+		"See `Answer` for details, e.g. the usual case.\n"
+	syms := extractSymbols(text)
+	got := strings.Join(syms, " ")
+	for _, want := range []string{"findChunks", "Sim", "Answer", "max_tokens", "g.TokenLimit", "TokenLimit"} {
+		Tassert(t, strings.Contains(" "+got+" ", " "+want+" "), "missing %q in %q", want, got)
+	}
+	for _, unwanted := range []string{"returns", "the", "details", "e.g"} {
+		Tassert(t, !strings.Contains(" "+got+" ", " "+unwanted+" "), "unexpected %q in %q", unwanted, got)
+	}
+
+	// past maxSymbols, mentions are dropped, not definitions,
+	// wherever they sort
+	var long strings.Builder
+	for i := 0; i < 2*maxSymbols; i++ {
+		long.WriteString(Spf("See `aaCall%03d`.\n", i))
+	}
+	long.WriteString("func zzLate() {}\n")
+	many := extractSymbols(long.String())
+	Tassert(t, len(many) == maxSymbols, "expected %d symbols, got %d", maxSymbols, len(many))
+	Tassert(t, many[len(many)-1] == "zzLate", "lost the definition: %v", many)
+	Tassert(t, many[0] == "aaCall000", "expected the first mentions kept, got %v", many)
+
+	c := &Chunk{Symbols: syms}
+	var f *Filter
+	Tassert(t, f.match(c, nil), "nil filter should match")
+	f = &Filter{Symbols: []string{"FindChunks"}}
+	Tassert(t, f.match(c, nil), "filter should match case-insensitively")
+	f = &Filter{Symbols: []string{"nope", "Sim"}}
+	Tassert(t, f.match(c, nil), "filter should match any symbol")
+	f = &Filter{Symbols: []string{"nope"}}
+	Tassert(t, !f.match(c, nil), "filter should not match")
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Access tags mark who a document is for, e.g. "public", "internal",
+// or "secret", so one knowledge base can serve audiences with
+// different clearance.  A query that sets Filter.Tags can only use
+// documents whose tags are all among the allowed tags; untagged
+// documents are open to every query.
+
+// normalizeTags lowercases, trims, sorts, and dedups tags, dropping
+// empty ones.
+func normalizeTags(tags []string) (out []string) {
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return
+}
+
+// docTags maps document paths to access tags.
+func (g *Grokker) docTags() (tags map[string][]string) {
+	tags = make(map[string][]string, len(g.Documents))
+	for _, doc := range g.Documents {
+		if len(doc.Tags) > 0 {
+			tags[doc.RelPath] = doc.Tags
+		}
+	}
+	return
+}
+
+// cleared returns true if every tag in tags is allowed by the
+// filter.
+func (f *Filter) cleared(tags []string) bool {
+	if f == nil || len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		found := false
+		for _, ok := range f.Tags {
+			if strings.EqualFold(ok, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DocumentTags returns the access tags of the document with the
+// given relative path, and false if there is no such document.
+func (g *Grokker) DocumentTags(relpath string) (tags []string, ok bool) {
+	for _, doc := range g.Documents {
+		if doc.RelPath == relpath {
+			return doc.Tags, true
+		}
+	}
+	return
+}
+
+// SetTags replaces the access tags of a document.  Pass no tags to
+// open the document to every query.
+func (g *Grokker) SetTags(path string, tags []string) (err error) {
+	defer Return(&err)
+	tags = normalizeTags(tags)
+	for _, doc := range g.Documents {
+		if doc.RelPath == path {
+			doc.Tags = tags
+			return
+		}
+	}
+	absPath, err := filepath.Abs(path)
+	Ck(err)
+	for _, doc := range g.Documents {
+		if g.absPath(doc) == absPath {
+			doc.Tags = tags
+			return
+		}
+	}
+	err = fmt.Errorf("%s is not in the knowledge base", path)
+	return
+}
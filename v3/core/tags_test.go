@@ -0,0 +1,55 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestTags(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	a := &Document{RelPath: "a.md"}
+	b := &Document{RelPath: "b.md"}
+	c := &Document{RelPath: "c.md"}
+	grok.Documents = []*Document{a, b, c}
+	grok.Chunks = []*Chunk{
+		{Document: a, Hash: "a1"},
+		{Document: b, Hash: "b1"},
+		{Document: c, Hash: "c1"},
+	}
+
+	err = grok.SetTags("a.md", []string{" Public ", "public"})
+	Tassert(t, err == nil, "error setting tags: %v", err)
+	Tassert(t, strings.Join(a.Tags, ",") == "public", "unexpected tags %v", a.Tags)
+	err = grok.SetTags("b.md", []string{"secret", "internal"})
+	Tassert(t, err == nil, "error setting tags: %v", err)
+	Tassert(t, strings.Join(b.Tags, ",") == "internal,secret", "unexpected tags %v", b.Tags)
+	err = grok.SetTags("nope.md", []string{"public"})
+	Tassert(t, err != nil, "expected error for unknown document")
+
+	hashes := func(f *Filter) string {
+		var out []string
+		for _, c := range f.apply(grok, grok.Chunks) {
+			out = append(out, c.Hash)
+		}
+		return strings.Join(out, ",")
+	}
+	// no tags allows everything
+	Tassert(t, hashes(&Filter{}) == "a1,b1,c1", "got %s", hashes(&Filter{}))
+	// untagged documents always pass
+	f := &Filter{Tags: []string{"public"}}
+	Tassert(t, hashes(f) == "a1,c1", "got %s", hashes(f))
+	// a document needs clearance for all of its tags
+	f = &Filter{Tags: []string{"public", "Internal"}}
+	Tassert(t, hashes(f) == "a1,c1", "got %s", hashes(f))
+	f = &Filter{Tags: []string{"public", "internal", "secret"}}
+	Tassert(t, hashes(f) == "a1,b1,c1", "got %s", hashes(f))
+
+	// clearing the tags opens the document up
+	err = grok.SetTags("b.md", nil)
+	Tassert(t, err == nil, "error clearing tags: %v", err)
+	f = &Filter{Tags: []string{"public"}}
+	Tassert(t, hashes(f) == "a1,b1,c1", "got %s", hashes(f))
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// TODO, FIXME, and XXX comments are scattered through a codebase
+// and rarely reviewed together.  Todos collects them from the
+// documents in the knowledge base, ClusterTodos groups them by topic
+// using their embeddings, and PrioritizeTodos asks the model to turn
+// the groups into a work list, most important first, citing each
+// comment by path and line.
+
+// Todo is a TODO, FIXME, or XXX comment.
+type Todo struct {
+	RelPath string
+	Line    int
+	// Kind is TODO, FIXME, or XXX.
+	Kind string
+	Text string
+}
+
+// String returns the comment as "path:line KIND text".
+func (t *Todo) String() string {
+	return Spf("%s:%d %s %s", t.RelPath, t.Line, t.Kind, t.Text)
+}
+
+// todoRe matches a TODO, FIXME, or XXX comment, capturing its kind
+// and text.
+var todoRe = regexp.MustCompile(`(?://|#|/\*|\*|<!--|--|;)\s*(TODO|FIXME|XXX)\b(?:\([^)]*\))?:?\s*(.*)`)
+
+// todoCommentEndRe matches the end of a block comment.
+var todoCommentEndRe = regexp.MustCompile(`\s*(\*/|-->)\s*$`)
+
+// todoClusterThreshold is the similarity at or above which a comment
+// joins a group.
+const todoClusterThreshold = 0.85
+
+const todosSysmsg = "You turn TODO comments from a codebase into a prioritized work list.  The comments are given in groups of related comments, each as path:line, kind, and text.  Give each group a short topic name, and order the groups and the comments within them by priority: bugs and FIXMEs that affect correctness or security first, then missing features, then cleanups.  Reply in markdown, with a heading per topic and a list item per comment, starting with its path:line in brackets, e.g. [main.go:12], followed by what needs to be done and, in a few words, why it matters.  Merge comments that describe the same work into one item with all their references.  Don't invent work that isn't in the comments."
+
+// Todos returns the TODO, FIXME, and XXX comments in the documents in
+// the knowledge base.
+func (g *Grokker) Todos() (todos []*Todo, err error) {
+	defer Return(&err)
+	for _, doc := range g.Documents {
+		buf, err := os.ReadFile(g.absPath(doc))
+		if os.IsNotExist(err) {
+			// removed since it was indexed
+			continue
+		}
+		Ck(err)
+		todos = append(todos, findTodos(doc.RelPath, buf)...)
+	}
+	return
+}
+
+// findTodos returns the TODO, FIXME, and XXX comments in a file's
+// content.
+func findTodos(relpath string, content []byte) (todos []*Todo) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		m := todoRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		text := strings.TrimSpace(todoCommentEndRe.ReplaceAllString(m[2], ""))
+		todos = append(todos, &Todo{RelPath: relpath, Line: line, Kind: m[1], Text: text})
+	}
+	return
+}
+
+// ClusterTodos groups comments about the same topic, largest group
+// first.
+func (g *Grokker) ClusterTodos(todos []*Todo) (groups [][]*Todo, err error) {
+	defer Return(&err)
+	if len(todos) == 0 {
+		return
+	}
+	var texts []string
+	for _, t := range todos {
+		texts = append(texts, Spf("%s: %s", t.RelPath, t.Text))
+	}
+	embeddings, err := g.createEmbeddings(texts)
+	Ck(err)
+	Assert(len(embeddings) == len(todos), "got %d embeddings for %d comments", len(embeddings), len(todos))
+	// each group is led by its first comment
+	var leaders [][]float64
+	for i, t := range todos {
+		found := false
+		for j, leader := range leaders {
+			if util.Similarity(embeddings[i], leader) >= todoClusterThreshold {
+				groups[j] = append(groups[j], t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			leaders = append(leaders, embeddings[i])
+			groups = append(groups, []*Todo{t})
+		}
+	}
+	// a stable sort keeps groups of equal size in file order
+	for i := 1; i < len(groups); i++ {
+		for j := i; j > 0 && len(groups[j]) > len(groups[j-1]); j-- {
+			groups[j], groups[j-1] = groups[j-1], groups[j]
+		}
+	}
+	return
+}
+
+// PrioritizeTodos asks the model for a prioritized work list in
+// markdown.  Groups that don't fit in half the model's token limit,
+// leaving the rest for the response, are left out, and the list
+// ends with a note saying how many comments were.
+func (g *Grokker) PrioritizeTodos(groups [][]*Todo) (list string, err error) {
+	defer Return(&err)
+	var input strings.Builder
+	budget := g.TokenLimit / 2
+	omitted := 0
+	for i, group := range groups {
+		var text strings.Builder
+		text.WriteString(Spf("Group %d:\n", i+1))
+		for _, t := range group {
+			text.WriteString(Spf("- %s\n", t))
+		}
+		text.WriteString("\n")
+		tc, err := g.TokenCount(text.String())
+		Ck(err)
+		if tc > budget {
+			omitted += len(group)
+			continue
+		}
+		budget -= tc
+		input.WriteString(text.String())
+	}
+	if input.Len() == 0 {
+		list = "No TODO comments found.\n"
+		if omitted > 0 {
+			list = Spf("The %d TODO comments don't fit in the model's token limit.\n", omitted)
+		}
+		return
+	}
+	resp, err := g.msg(todosSysmsg, input.String())
+	Ck(err)
+	list = strings.TrimSpace(resp.Choices[0].Message.Content) + "\n"
+	if omitted > 0 {
+		list += Spf("\n%d more comments didn't fit in the model's token limit; see 'grok todos --list'.\n", omitted)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestFindTodos(t *testing.T) {
+	src := `package main
+
+// TODO: handle errors
+func main() {
+	x-- // FIXME(steve) off by one
+	/* XXX remove this hack */
+	s := "TODO in a string"
+}
+`
+	todos := findTodos("main.go", []byte(src))
+	Tassert(t, len(todos) == 3, "expected 3 comments, got %d: %v", len(todos), todos)
+	Tassert(t, todos[0].String() == "main.go:3 TODO handle errors", "unexpected comment %q", todos[0])
+	Tassert(t, todos[1].Kind == "FIXME" && todos[1].Text == "off by one" && todos[1].Line == 5, "unexpected comment %q", todos[1])
+	Tassert(t, todos[2].Kind == "XXX" && todos[2].Text == "remove this hack", "unexpected comment %q", todos[2])
+}
+
+// todoEmbedder embeds texts that mention "cache" in one direction and
+// everything else in another.
+type todoEmbedder struct{}
+
+func (e *todoEmbedder) embed(texts []string) (embeddings [][]float64, err error) {
+	for _, text := range texts {
+		if strings.Contains(text, "cache") {
+			embeddings = append(embeddings, []float64{1, 0})
+		} else {
+			embeddings = append(embeddings, []float64{0, 1})
+		}
+	}
+	return
+}
+
+func (e *todoEmbedder) spec() string {
+	return "onnx:/models/todo"
+}
+
+func TestClusterTodos(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	src := "# TODO rename flag\n# TODO expire the cache\n# FIXME cache grows forever\n"
+	err = os.WriteFile(filepath.Join(dir, "a.sh"), []byte(src), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	grok.Documents = []*Document{{RelPath: "a.sh"}, {RelPath: "gone.sh"}}
+	grok.embedder = &todoEmbedder{}
+	grok.EmbeddingProvider = "onnx"
+	grok.EmbeddingModel = "todo"
+
+	todos, err := grok.Todos()
+	Tassert(t, err == nil, "error finding comments: %v", err)
+	Tassert(t, len(todos) == 3, "expected 3 comments, got %d", len(todos))
+	groups, err := grok.ClusterTodos(todos)
+	Tassert(t, err == nil, "error grouping comments: %v", err)
+	Tassert(t, len(groups) == 2, "expected 2 groups, got %d", len(groups))
+	Tassert(t, len(groups[0]) == 2 && groups[0][0].Line == 2 && groups[0][1].Line == 3, "expected the cache comments first: %v", groups[0])
+	Tassert(t, groups[1][0].Text == "rename flag", "unexpected group %v", groups[1])
+
+	list, err := grok.PrioritizeTodos(nil)
+	Tassert(t, err == nil, "error prioritizing: %v", err)
+	Tassert(t, list == "No TODO comments found.\n", "unexpected list %q", list)
+}
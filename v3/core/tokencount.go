@@ -0,0 +1,135 @@
+package core
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// 'grok tc' counts tokens with cl100k_base, the tokenizer grokker
+// chunks with, and estimates what embedding the files, or sending
+// them to a chat model, would cost.  Models whose own tokenizer
+// differs, e.g. gpt-4o and the o-series models with o200k_base,
+// count somewhat differently, so their prompt costs are estimates.
+
+// embeddingPrices are the prices, in USD per million tokens, of the
+// remote embedding models; local embedders cost nothing.
+var embeddingPrices = map[string]float64{
+	openaiEmbeddingModel: 0.10,
+}
+
+// FileTokens is the token count of a file.
+type FileTokens struct {
+	Path   string
+	Tokens int
+}
+
+// TokenCost estimates what a number of tokens costs.
+type TokenCost struct {
+	Tokens int
+	// Model is the chat model, and TokenLimit its context size.
+	Model      string
+	TokenLimit int
+	// PromptCost is the cost in USD of sending the tokens to the
+	// chat model as a prompt; PriceKnown is false if the model's
+	// price isn't known.
+	PromptCost float64
+	PriceKnown bool
+	// EmbeddingModel is the embedder that would index the tokens,
+	// and EmbeddingCost what that costs in USD.
+	EmbeddingModel string
+	EmbeddingCost  float64
+}
+
+// CountTokens returns the number of tokens in text.
+func CountTokens(text string) (count int, err error) {
+	defer Return(&err)
+	if Tokenizer == nil {
+		err = InitTokenizer()
+		Ck(err)
+	}
+	ids, _, err := Tokenizer.Encode(text)
+	Ck(err)
+	count = len(ids)
+	return
+}
+
+// CountFileTokens counts the tokens in each of the given files and
+// in the text files under the given directories, in order.  "-"
+// counts stdin.  Directories are walked without .git and other
+// hidden directories, grokker's own files, and binary files.
+func CountFileTokens(paths []string, stdin io.Reader) (counts []FileTokens, err error) {
+	defer Return(&err)
+	count := func(path string, buf []byte) {
+		n, err := CountTokens(string(buf))
+		Ck(err)
+		counts = append(counts, FileTokens{Path: path, Tokens: n})
+	}
+	for _, path := range paths {
+		if path == "-" {
+			buf, err := io.ReadAll(stdin)
+			Ck(err)
+			count(path, buf)
+			continue
+		}
+		fi, err := os.Stat(path)
+		Ck(err)
+		if !fi.IsDir() {
+			buf, err := os.ReadFile(path)
+			Ck(err)
+			count(path, buf)
+			continue
+		}
+		err = filepath.WalkDir(path, func(fn string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if fn != path && strings.HasPrefix(name, ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(name, ".grok") || !d.Type().IsRegular() || !isText(fn) {
+				return nil
+			}
+			buf, err := os.ReadFile(fn)
+			if err != nil {
+				return err
+			}
+			count(fn, buf)
+			return nil
+		})
+		Ck(err)
+	}
+	return
+}
+
+// EstimateCost estimates what embedding tokens, and sending them to
+// the named chat model, would cost.  An empty model name means
+// DefaultModel.  The embedder is the one GROKKER_EMBEDDER selects.
+func EstimateCost(tokens int, model string) (cost *TokenCost, err error) {
+	defer Return(&err)
+	name, m, err := NewModels().FindModel(model)
+	Ck(err)
+	cost = &TokenCost{
+		Tokens:     tokens,
+		Model:      name,
+		TokenLimit: m.TokenLimit,
+		PromptCost: float64(tokens) * m.PromptPrice / 1e6,
+		PriceKnown: m.PromptPrice > 0,
+	}
+	spec := os.Getenv("GROKKER_EMBEDDER")
+	if spec == "" {
+		cost.EmbeddingModel = openaiEmbeddingModel
+		cost.EmbeddingCost = float64(tokens) * embeddingPrices[openaiEmbeddingModel] / 1e6
+	} else {
+		cost.EmbeddingModel = spec
+	}
+	return
+}
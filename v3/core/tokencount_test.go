@@ -0,0 +1,78 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/tiktoken-go/tokenizer"
+)
+
+func TestCountFileTokens(t *testing.T) {
+	dir := TmpTestDir()
+	write := func(fn, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755)
+		Ck(err)
+		err = os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644)
+		Ck(err)
+	}
+	write("a.md", "hello world")
+	write("sub/b.go", "package b")
+	write("logo.png", "\x89PNG\x00\x00")
+	write(".git/config", "[core]")
+	write(".grok", "{}")
+
+	counts, err := CountFileTokens([]string{"-", dir}, strings.NewReader("hello world"))
+	Tassert(t, err == nil, "error counting: %v", err)
+	var paths []string
+	for _, c := range counts {
+		rel, _ := filepath.Rel(dir, c.Path)
+		if c.Path == "-" {
+			rel = "-"
+		}
+		paths = append(paths, rel)
+	}
+	Tassert(t, strings.Join(paths, " ") == "- a.md sub/b.go", "unexpected files %v", paths)
+	Tassert(t, counts[0].Tokens == 2 && counts[1].Tokens == 2, "unexpected counts %+v", counts)
+
+	_, err = CountFileTokens([]string{filepath.Join(dir, "nope")}, nil)
+	Tassert(t, err != nil, "expected an error for a missing file")
+}
+
+func TestEstimateCost(t *testing.T) {
+	t.Setenv("GROKKER_EMBEDDER", "")
+	cost, err := EstimateCost(1000000, "gpt-4o")
+	Tassert(t, err == nil, "error estimating: %v", err)
+	Tassert(t, cost.PriceKnown && cost.PromptCost == 2.50, "unexpected prompt cost %+v", cost)
+	Tassert(t, cost.EmbeddingModel == openaiEmbeddingModel && cost.EmbeddingCost == 0.10, "unexpected embedding cost %+v", cost)
+	t.Setenv("GROKKER_EMBEDDER", "onnx:/models/minilm")
+	cost, err = EstimateCost(1000, "")
+	Tassert(t, err == nil && cost.Model == DefaultModel, "unexpected estimate %+v: %v", cost, err)
+	Tassert(t, cost.EmbeddingCost == 0, "expected local embeddings to be free, got %+v", cost)
+	_, err = EstimateCost(1, "nope")
+	Tassert(t, err != nil, "expected an error for an unknown model")
+}
+
+func TestModelTokenizer(t *testing.T) {
+	grok, err := Init(TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	Tassert(t, grok.codec().GetName() == "cl100k_base", "got %s for gpt-3.5-turbo", grok.codec().GetName())
+	err = grok.Setup("gpt-4o")
+	Ck(err)
+	Tassert(t, grok.codec().GetName() == "o200k_base", "got %s for gpt-4o", grok.codec().GetName())
+	// chunking still counts with the embedding model's tokenizer
+	Tassert(t, Tokenizer.GetName() == "cl100k_base", "got %s for chunking", Tokenizer.GetName())
+	text := "Ünïcödé text counts differently: 日本語のテキスト"
+	n, err := grok.TokenCount(text)
+	Ck(err)
+	ids, _, err := Tokenizer.Encode(text)
+	Ck(err)
+	Tassert(t, n > 0 && n != len(ids), "expected gpt-4o's own count, got %d, cl100k_base %d", n, len(ids))
+
+	m := &Model{Name: "openrouter:openai/o3-mini", upstreamName: "openai/o3-mini"}
+	Tassert(t, modelEncoding(m) == tokenizer.O200kBase, "got %s for %s", modelEncoding(m), m.Name)
+	m = &Model{Name: "openrouter:anthropic/claude-3.5-sonnet", upstreamName: "anthropic/claude-3.5-sonnet"}
+	Tassert(t, modelEncoding(m) == tokenizer.Cl100kBase, "got %s for %s", modelEncoding(m), m.Name)
+}
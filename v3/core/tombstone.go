@@ -0,0 +1,193 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Forgetting a document is a soft delete: the document leaves the
+// index, and a tombstone takes its place.  The tombstone travels with
+// the db, through the journal, exports, and synced copies, so a copy
+// that still has the document can tell that it was removed rather
+// than never added.  Until it is purged, a forgotten document's
+// content can remain in the shared caches and, for a virtual or
+// transformed document, on disk.  Purge erases that content for compliance
+// requests; the tombstone itself, which holds only the path and
+// cache keys, stays.
+
+// Tombstone records a forgotten document.
+type Tombstone struct {
+	RelPath string
+	Virtual bool `json:",omitempty"`
+	Deleted time.Time
+	// Purged is set once the document's content has been erased.
+	Purged *time.Time `json:",omitempty"`
+	// CacheKeys are the embedding cache keys of the document's
+	// chunks and summary, so Purge can erase them without the
+	// content.
+	CacheKeys []string `json:",omitempty"`
+}
+
+// bury replaces any tombstone for doc with a new one.
+func (g *Grokker) bury(doc *Document) (err error) {
+	defer Return(&err)
+	ts := &Tombstone{RelPath: doc.RelPath, Virtual: doc.Virtual, Deleted: time.Now()}
+	ts.CacheKeys, err = g.cacheKeys(doc)
+	Ck(err)
+	g.unbury(doc.RelPath)
+	g.Tombstones = append(g.Tombstones, ts)
+	return
+}
+
+// cacheKeys returns the embedding cache keys of a document's chunks
+// and summary.  A chunk's Hash is the sha256 of the text that was
+// embedded, so its key doesn't need the document, which may already
+// be gone; the summary's key does, so it is only found if the
+// content is still there.
+func (g *Grokker) cacheKeys(doc *Document) (keys []string, err error) {
+	defer Return(&err)
+	for _, c := range g.Chunks {
+		if c.Document == nil || c.Document.RelPath != doc.RelPath {
+			continue
+		}
+		if c.Hash != "" {
+			keys = append(keys, g.embeddingHashKey(c.Hash))
+			continue
+		}
+		text, err := g.chunkText(c, true, false)
+		Ck(err)
+		if text != "" {
+			keys = append(keys, g.embeddingCacheKey(text))
+		}
+	}
+	if g.Pipeline.Summarizer != "" {
+		buf, err := os.ReadFile(g.contentPath(doc))
+		if err == nil {
+			keys = append(keys, g.summaryCacheKey(buf))
+		}
+	}
+	return
+}
+
+// unbury removes the tombstone for a document that is added again.
+func (g *Grokker) unbury(relpath string) {
+	var keep []*Tombstone
+	for _, ts := range g.Tombstones {
+		if ts.RelPath != relpath {
+			keep = append(keep, ts)
+		}
+	}
+	g.Tombstones = keep
+}
+
+// Purge permanently erases the content of forgotten documents: their
+// chunks, their cached embeddings and summaries, their transformed
+// content, and the content of virtual documents.  It also removes
+// them from the snapshots, drops the questions that cited them from
+// the question log, and clears the response cache, since cached
+// responses can't be traced to the documents they quote.  The audit
+// log, which is append-only, is left alone.  If paths is empty,
+// every unpurged tombstone is purged.  Purge returns the paths it
+// purged; the caller should save the db, which Purge forces to be
+// rewritten in full.
+func (g *Grokker) Purge(paths ...string) (purged []string, err error) {
+	defer Return(&err)
+	want := make(map[string]bool)
+	for _, path := range paths {
+		want[path] = true
+	}
+	gone := make(map[string]bool)
+	now := time.Now()
+	for _, ts := range g.Tombstones {
+		if ts.Purged != nil || (len(want) > 0 && !want[ts.RelPath]) {
+			continue
+		}
+		for _, key := range ts.CacheKeys {
+			err = g.cacheDelete("embeddings", key)
+			Ck(err)
+		}
+		fns := []string{g.transformedPath(ts.RelPath)}
+		if ts.Virtual {
+			fns = append(fns, g.virtualPath(ts.RelPath))
+		}
+		for _, fn := range fns {
+			err = os.Remove(fn)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+			Ck(err)
+		}
+		ts.CacheKeys = nil
+		ts.Purged = &now
+		gone[ts.RelPath] = true
+		purged = append(purged, ts.RelPath)
+	}
+	if len(purged) == 0 {
+		return
+	}
+	var keep []*Chunk
+	for _, c := range g.Chunks {
+		if c.Document == nil || !gone[c.Document.RelPath] {
+			keep = append(keep, c)
+		}
+	}
+	g.Chunks = keep
+	err = g.scrubSnapshots(gone)
+	Ck(err)
+	err = g.scrubQuestions(gone)
+	Ck(err)
+	if dir := g.cacheDir(); dir != "" {
+		err = os.RemoveAll(filepath.Join(dir, "responses"))
+		Ck(err)
+	}
+	// the journal and db file still hold the chunks' embeddings
+	g.savedSigs = nil
+	return
+}
+
+// scrubSnapshots removes the given documents and their chunks from
+// every snapshot.
+func (g *Grokker) scrubSnapshots(gone map[string]bool) (err error) {
+	defer Return(&err)
+	snaps, err := g.ListSnapshots()
+	Ck(err)
+	for _, info := range snaps {
+		path, err := g.snapshotPath(info.Name)
+		Ck(err)
+		buf, err := os.ReadFile(path)
+		Ck(err)
+		snap := &Grokker{}
+		err = json.Unmarshal(buf, snap)
+		Ck(err)
+		var docs []*Document
+		for _, doc := range snap.Documents {
+			if !gone[doc.RelPath] {
+				docs = append(docs, doc)
+			}
+		}
+		var chunks []*Chunk
+		for _, c := range snap.Chunks {
+			if c.Document == nil || !gone[c.Document.RelPath] {
+				chunks = append(chunks, c)
+			}
+		}
+		if len(docs) == len(snap.Documents) && len(chunks) == len(snap.Chunks) {
+			continue
+		}
+		Debug("purging %d chunks from snapshot %s", len(snap.Chunks)-len(chunks), info.Name)
+		snap.Documents = docs
+		snap.Chunks = chunks
+		buf, err = json.Marshal(snap)
+		Ck(err)
+		tmpfn := path + ".tmp"
+		err = os.WriteFile(tmpfn, buf, 0644)
+		Ck(err)
+		err = os.Rename(tmpfn, path)
+		Ck(err)
+	}
+	return
+}
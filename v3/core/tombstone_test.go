@@ -0,0 +1,90 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestTombstones(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CACHE_DIR", filepath.Join(dir, "cache"))
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	err = os.WriteFile(filepath.Join(dir, "a.md"), []byte("secret stuff"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "a.md"}
+	grok.Documents = append(grok.Documents, doc)
+	chunk := newChunk(doc, "", 0, 12, "secret stuff")
+	chunk.Embedding = []float64{1, 0}
+	grok.Chunks = []*Chunk{chunk}
+	text, err := grok.chunkText(chunk, true, false)
+	Tassert(t, err == nil, "error reading chunk: %v", err)
+	key := grok.embeddingCacheKey(text)
+	cachePut("embeddings", key, chunk.Embedding)
+	var cached []float64
+	Tassert(t, cacheGet("embeddings", key, &cached), "expected a cached embedding")
+
+	// forgetting leaves a tombstone but not the content
+	err = grok.ForgetDocument("a.md")
+	Tassert(t, err == nil, "error forgetting: %v", err)
+	Tassert(t, len(grok.Documents) == 0, "expected no documents")
+	Tassert(t, len(grok.Tombstones) == 1 && grok.Tombstones[0].RelPath == "a.md", "expected a tombstone: %v", grok.Tombstones)
+	Tassert(t, len(grok.Tombstones[0].CacheKeys) == 1 && grok.Tombstones[0].CacheKeys[0] == key, "expected the cache key: %v", grok.Tombstones[0].CacheKeys)
+	Tassert(t, cacheGet("embeddings", key, &cached), "expected the cached embedding to survive a soft delete")
+
+	purged, err := grok.Purge("other.md")
+	Tassert(t, err == nil && len(purged) == 0, "expected nothing purged: %v %v", purged, err)
+	purged, err = grok.Purge()
+	Tassert(t, err == nil && len(purged) == 1, "expected a.md purged: %v %v", purged, err)
+	Tassert(t, !cacheGet("embeddings", key, &cached), "expected the cached embedding to be erased")
+	Tassert(t, len(grok.Chunks) == 0, "expected the chunks to be erased")
+	ts := grok.Tombstones[0]
+	Tassert(t, ts.Purged != nil && ts.CacheKeys == nil, "expected a purged tombstone: %#v", ts)
+	purged, err = grok.Purge()
+	Tassert(t, err == nil && len(purged) == 0, "expected nothing left to purge: %v %v", purged, err)
+
+	// adding the document again removes the tombstone
+	_, err = grok.addDoc(filepath.Join(dir, "a.md"))
+	Tassert(t, err == nil, "error adding: %v", err)
+	Tassert(t, len(grok.Tombstones) == 0, "expected no tombstones: %v", grok.Tombstones)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	dir := TmpTestDir()
+	t.Setenv("GROKKER_CACHE_DIR", filepath.Join(dir, "cache"))
+	t.Setenv("GROKKER_CONFIG", filepath.Join(dir, "config.yaml"))
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	fn := filepath.Join(dir, "a.md")
+	err = os.WriteFile(fn, []byte("secret stuff"), 0644)
+	Tassert(t, err == nil, "error writing file: %v", err)
+	doc := &Document{RelPath: "a.md"}
+	grok.Documents = append(grok.Documents, doc)
+	chunk := newChunk(doc, "", 0, 12, "secret stuff")
+	chunk.Embedding = []float64{1, 0}
+	grok.Chunks = []*Chunk{chunk}
+	text, err := grok.chunkText(chunk, true, false)
+	Tassert(t, err == nil, "error reading chunk: %v", err)
+	key := grok.embeddingCacheKey(text)
+	cachePut("embeddings", key, chunk.Embedding)
+	err = grok.logQuestion("what's secret?", []string{"a.md:1"}, "stuff")
+	Tassert(t, err == nil, "error logging question: %v", err)
+	err = grok.logQuestion("what else?", []string{"b.md:1"}, "nothing")
+	Tassert(t, err == nil, "error logging question: %v", err)
+
+	// the document is deleted before it is forgotten, as on refresh
+	err = os.Remove(fn)
+	Tassert(t, err == nil, "error removing file: %v", err)
+	err = grok.ForgetDocument("a.md")
+	Tassert(t, err == nil, "error forgetting: %v", err)
+	Tassert(t, len(grok.Tombstones[0].CacheKeys) == 1 && grok.Tombstones[0].CacheKeys[0] == key, "expected the cache key: %v", grok.Tombstones[0].CacheKeys)
+	_, err = grok.Purge()
+	Tassert(t, err == nil, "error purging: %v", err)
+	var cached []float64
+	Tassert(t, !cacheGet("embeddings", key, &cached), "expected the cached embedding to be erased")
+	qs, err := grok.Questions()
+	Tassert(t, err == nil && len(qs) == 1 && qs[0].Question == "what else?", "expected the question citing a.md to be dropped, got %v %v", qs, err)
+}
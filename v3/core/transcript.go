@@ -0,0 +1,206 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A transcript is a self-contained copy of a chat history, for
+// sharing a session between machines or attaching it to an issue as
+// a record of an investigation.  Transcripts come in two formats:
+//
+//   - json is a Transcript struct, and round-trips exactly.
+//   - md is readable markdown with a "## <role>" heading before each
+//     message and the sources of each AI message listed after it.
+//     Import recognizes the same headings, so a markdown transcript
+//     can be imported again as long as the headings are left alone.
+
+// Transcript is the exported form of a chat history.
+type Transcript struct {
+	Name     string
+	Version  string
+	Sysmsg   string
+	Messages []TranscriptMsg
+}
+
+// TranscriptMsg is a single message in a transcript.  Sources lists
+// the document chunks used as context for the message, as
+// "relpath:line".
+type TranscriptMsg struct {
+	Role    string
+	Text    string
+	Sources []string `json:",omitempty"`
+}
+
+// mdSourcesHeader introduces the list of sources after an AI
+// message in a markdown transcript.
+const mdSourcesHeader = "Sources:"
+
+// ExportChat returns a transcript of the chat history in path, in
+// the given format, "md" or "json".
+func (g *Grokker) ExportChat(path, format string) (out string, err error) {
+	defer Return(&err)
+	_, err = os.Stat(path)
+	Ck(err)
+	history, err := g.OpenChatHistory("", path)
+	Ck(err)
+	t := &Transcript{
+		Name:    filepath.Base(path),
+		Version: history.Version,
+		Sysmsg:  history.Sysmsg,
+	}
+	for i, msg := range history.msgs {
+		text := strings.TrimSpace(msg.Txt)
+		if text == "" {
+			continue
+		}
+		t.Messages = append(t.Messages, TranscriptMsg{
+			Role:    msg.Role,
+			Text:    text,
+			Sources: history.Sources[i],
+		})
+	}
+	switch format {
+	case "json":
+		var buf []byte
+		buf, err = json.MarshalIndent(t, "", "  ")
+		Ck(err)
+		out = string(buf) + "\n"
+	case "md":
+		out = t.markdown()
+	default:
+		err = fmt.Errorf("unknown transcript format %q; expected md or json", format)
+	}
+	return
+}
+
+// markdown renders the transcript as markdown.
+func (t *Transcript) markdown() (out string) {
+	out = Spf("# %s\n\n", t.Name)
+	out += Spf("## SYSMSG\n\n%s\n\n", strings.TrimSpace(t.Sysmsg))
+	for _, msg := range t.Messages {
+		out += Spf("## %s\n\n%s\n\n", msg.Role, msg.Text)
+		if len(msg.Sources) > 0 {
+			out += mdSourcesHeader + "\n\n"
+			for _, src := range msg.Sources {
+				out += Spf("- %s\n", src)
+			}
+			out += "\n"
+		}
+	}
+	return
+}
+
+// parseMarkdown parses a markdown transcript.  Headings inside code
+// fences are ignored.
+func parseMarkdown(txt string) (t *Transcript) {
+	t = &Transcript{}
+	var msg *TranscriptMsg
+	var body []string
+	inFence := false
+	flush := func() {
+		if msg == nil {
+			return
+		}
+		msg.Text, msg.Sources = splitSources(body)
+		if msg.Role == "SYSMSG" {
+			t.Sysmsg = msg.Text
+		} else {
+			t.Messages = append(t.Messages, *msg)
+		}
+		msg = nil
+		body = nil
+	}
+	for _, line := range strings.Split(txt, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence {
+			if strings.HasPrefix(line, "# ") && t.Name == "" && msg == nil {
+				t.Name = strings.TrimSpace(line[2:])
+				continue
+			}
+			switch strings.TrimSpace(line) {
+			case "## SYSMSG", "## USER", "## AI":
+				flush()
+				msg = &TranscriptMsg{Role: strings.TrimSpace(line)[3:]}
+				continue
+			}
+		}
+		if msg != nil {
+			body = append(body, line)
+		}
+	}
+	flush()
+	return
+}
+
+// splitSources separates the text of a markdown message from the
+// list of sources at its end, if any.
+func splitSources(lines []string) (text string, sources []string) {
+	end := len(lines)
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	i := end
+	for i > 0 && strings.HasPrefix(lines[i-1], "- ") {
+		i--
+	}
+	j := i
+	for j > 0 && strings.TrimSpace(lines[j-1]) == "" {
+		j--
+	}
+	if i < end && j > 0 && lines[j-1] == mdSourcesHeader {
+		for _, line := range lines[i:end] {
+			sources = append(sources, strings.TrimSpace(line[2:]))
+		}
+		end = j - 1
+	}
+	text = strings.TrimSpace(strings.Join(lines[:end], "\n"))
+	return
+}
+
+// ImportChat creates the chat history file path from a transcript in
+// either format.  It won't overwrite an existing chat history.  If
+// addToDb is true, the chat history is added to the knowledge base.
+func (g *Grokker) ImportChat(path, txt string, addToDb bool) (err error) {
+	defer Return(&err)
+	_, err = os.Stat(path)
+	if err == nil {
+		err = fmt.Errorf("%s already exists", path)
+		return
+	}
+	err = nil
+	var t *Transcript
+	if strings.HasPrefix(strings.TrimSpace(txt), "{") {
+		t = &Transcript{}
+		err = json.Unmarshal([]byte(txt), t)
+		Ck(err)
+	} else {
+		t = parseMarkdown(txt)
+	}
+	Assert(len(t.Messages) > 0, "no messages found in transcript")
+	history, err := g.OpenChatHistory(t.Sysmsg, path)
+	Ck(err)
+	if t.Version != "" {
+		history.Version = t.Version
+	}
+	for _, msg := range t.Messages {
+		role := history.fixRole(msg.Role)
+		history.msgs = append(history.msgs, ChatMsg{Role: role, Txt: msg.Text})
+		if len(msg.Sources) > 0 {
+			if history.Sources == nil {
+				history.Sources = make(map[int][]string)
+			}
+			history.Sources[len(history.msgs)-1] = msg.Sources
+		}
+	}
+	err = history.Save(addToDb)
+	Ck(err)
+	return
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestTranscript(t *testing.T) {
+	dir := TmpTestDir()
+	g := &Grokker{Root: dir}
+	md := "# debug.chat\n\n## SYSMSG\n\nBe terse.\n\n## USER\n\nWhy does Save fail?\n\n" +
+		"## AI\n\nIt checks the mtime:\n\n```\n## USER\n```\n\nSources:\n\n- core/api.go:42\n- core/journal.go:7\n\n" +
+		"## USER\n\nThanks.\n"
+
+	// import markdown, then export it again
+	fn := filepath.Join(dir, "a.chat")
+	err := g.ImportChat(fn, md, false)
+	Tassert(t, err == nil, "error importing: %v", err)
+	err = g.ImportChat(fn, md, false)
+	Tassert(t, err != nil, "expected error importing over an existing chat")
+	out, err := g.ExportChat(fn, "md")
+	Tassert(t, err == nil, "error exporting: %v", err)
+	expect := strings.Replace(md, "# debug.chat", "# a.chat", 1) + "\n"
+	Tassert(t, out == expect, "expected %q, got %q", expect, out)
+
+	// round-trip through json
+	out, err = g.ExportChat(fn, "json")
+	Tassert(t, err == nil, "error exporting: %v", err)
+	tr := &Transcript{}
+	err = json.Unmarshal([]byte(out), tr)
+	Tassert(t, err == nil, "error parsing json: %v", err)
+	Tassert(t, tr.Sysmsg == "Be terse.", "got sysmsg %q", tr.Sysmsg)
+	Tassert(t, len(tr.Messages) == 3, "expected 3 messages, got %d", len(tr.Messages))
+	Tassert(t, len(tr.Messages[1].Sources) == 2, "expected 2 sources, got %v", tr.Messages[1].Sources)
+	fn2 := filepath.Join(dir, "b.chat")
+	err = g.ImportChat(fn2, out, false)
+	Tassert(t, err == nil, "error importing json: %v", err)
+	out2, err := g.ExportChat(fn2, "json")
+	Tassert(t, err == nil, "error exporting: %v", err)
+	Tassert(t, out2 == strings.Replace(out, "a.chat", "b.chat", 1), "json round trip changed transcript:\n%s\n%s", out, out2)
+
+	_, err = g.ExportChat(fn, "html")
+	Tassert(t, err != nil, "expected error for unknown format")
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package core
+
+import "os"
+
+// openTerminal opens the controlling terminal for reading and
+// writing.
+func openTerminal() (in, out *os.File, err error) {
+	in, err = os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	out = in
+	return
+}
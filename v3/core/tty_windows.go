@@ -0,0 +1,19 @@
+//go:build windows
+
+package core
+
+import "os"
+
+// openTerminal opens the console, which Windows splits into an input
+// and an output device.
+func openTerminal() (in, out *os.File, err error) {
+	in, err = os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	out, err = os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		in.Close()
+	}
+	return
+}
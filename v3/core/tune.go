@@ -0,0 +1,193 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// The question log shows which documents retrieval actually uses.
+// A document that is retrieved again and again but never cited in a
+// good answer only crowds out better context, and one that no
+// question ever retrieves is likely dead weight; both are candidates
+// for .grokignore.  A document cited in most of the good answers it
+// is retrieved for deserves to rank higher, so it is a candidate for
+// a boost, which is added to the similarity score of its chunks.  An
+// answer cites a document if it mentions its path, as answers do
+// with citation markers; see messages.go.  'grok tune suggest' lists
+// the suggestions, and applies them with --apply.
+
+// Suggestion actions.
+const (
+	SuggestExclude = "exclude"
+	SuggestBoost   = "boost"
+)
+
+// suggestedBoost is the boost suggested for well-cited documents;
+// it matches symbolBoost.
+const suggestedBoost = 0.05
+
+// tuneMinQuestions is the number of logged questions below which
+// documents that were never retrieved aren't suggested for
+// exclusion.
+const tuneMinQuestions = 20
+
+// DocUsage is how the questions in the question log used a document.
+type DocUsage struct {
+	RelPath string
+	// Retrieved is the number of questions the document was
+	// retrieved for, and Cited the number of answers that cite it.
+	Retrieved int
+	Cited     int
+	// Good and CitedGood are the same for the answers rated good.
+	Good      int
+	CitedGood int
+}
+
+// Suggestion is a change to the knowledge base suggested by the
+// question log.
+type Suggestion struct {
+	RelPath string
+	// Action is SuggestExclude or SuggestBoost.
+	Action string
+	// Boost is the suggested boost.
+	Boost  float64
+	Reason string
+}
+
+// String returns the suggestion as "action relpath: reason".
+func (s Suggestion) String() string {
+	if s.Action == SuggestBoost {
+		return Spf("boost %s by %.2f: %s", s.RelPath, s.Boost, s.Reason)
+	}
+	return Spf("%s %s: %s", s.Action, s.RelPath, s.Reason)
+}
+
+// Usage returns how the logged questions used each document in the
+// knowledge base, sorted by path, and the number of questions.
+func (g *Grokker) Usage() (usage []*DocUsage, questions int, err error) {
+	defer Return(&err)
+	qs, err := g.Questions()
+	Ck(err)
+	byPath := make(map[string]*DocUsage, len(g.Documents))
+	for _, doc := range g.Documents {
+		u := &DocUsage{RelPath: doc.RelPath}
+		byPath[doc.RelPath] = u
+		usage = append(usage, u)
+	}
+	for _, q := range qs {
+		good := q.Rating == RatingGood
+		for _, relpath := range sourceDocs(q.Sources) {
+			u, ok := byPath[relpath]
+			if !ok {
+				// forgotten since
+				continue
+			}
+			cited := strings.Contains(q.Answer, relpath)
+			u.Retrieved++
+			if cited {
+				u.Cited++
+			}
+			if good {
+				u.Good++
+				if cited {
+					u.CitedGood++
+				}
+			}
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].RelPath < usage[j].RelPath })
+	questions = len(qs)
+	return
+}
+
+// Suggest returns the exclusions and boosts that the question log
+// suggests, exclusions first.  Documents retrieved for fewer than
+// minRetrieved questions aren't judged by their citations.
+func (g *Grokker) Suggest(minRetrieved int) (sugs []Suggestion, err error) {
+	defer Return(&err)
+	if minRetrieved < 1 {
+		minRetrieved = 1
+	}
+	usage, questions, err := g.Usage()
+	Ck(err)
+	// without good answers that cite something, citations say
+	// nothing about a document
+	citing := false
+	for _, u := range usage {
+		if u.CitedGood > 0 {
+			citing = true
+			break
+		}
+	}
+	boosts := make(map[string]float64)
+	for _, doc := range g.Documents {
+		boosts[doc.RelPath] = doc.Boost
+	}
+	var excludes []Suggestion
+	for _, u := range usage {
+		switch {
+		case u.Retrieved == 0 && questions >= tuneMinQuestions:
+			excludes = append(excludes, Suggestion{RelPath: u.RelPath, Action: SuggestExclude,
+				Reason: Spf("never retrieved for any of %d questions", questions)})
+		case citing && u.Retrieved >= minRetrieved && u.CitedGood == 0:
+			excludes = append(excludes, Suggestion{RelPath: u.RelPath, Action: SuggestExclude,
+				Reason: Spf("retrieved for %d questions, %d with good answers, and never cited in a good answer", u.Retrieved, u.Good)})
+		case u.Retrieved >= minRetrieved && u.CitedGood >= 2 && u.CitedGood*2 >= u.Good && boosts[u.RelPath] < suggestedBoost:
+			sugs = append(sugs, Suggestion{RelPath: u.RelPath, Action: SuggestBoost, Boost: suggestedBoost,
+				Reason: Spf("cited in %d of the %d good answers it was retrieved for", u.CitedGood, u.Good)})
+		}
+	}
+	sugs = append(excludes, sugs...)
+	return
+}
+
+// ApplySuggestions boosts the suggested documents and excludes the
+// others: they are added to the .grokignore file in the root, unless
+// virtual, and forgotten.
+func (g *Grokker) ApplySuggestions(sugs []Suggestion) (err error) {
+	defer Return(&err)
+	var ignores []string
+	for _, s := range sugs {
+		var doc *Document
+		for _, d := range g.Documents {
+			if d.RelPath == s.RelPath {
+				doc = d
+				break
+			}
+		}
+		if doc == nil {
+			continue
+		}
+		switch s.Action {
+		case SuggestBoost:
+			doc.Boost = s.Boost
+		case SuggestExclude:
+			if !doc.Virtual && !strings.HasPrefix(doc.RelPath, "../") {
+				ignores = append(ignores, "/"+doc.RelPath)
+			}
+			err = g.ForgetDocument(doc.RelPath)
+			Ck(err)
+		default:
+			Assert(false, "unknown suggestion action %q", s.Action)
+		}
+	}
+	if len(ignores) == 0 {
+		return
+	}
+	fn := filepath.Join(g.Root, grokignoreName)
+	buf, err := os.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		Ck(err)
+	}
+	if len(buf) > 0 && !strings.HasSuffix(string(buf), "\n") {
+		buf = append(buf, '\n')
+	}
+	buf = append(buf, []byte("# suggested by 'grok tune suggest'\n"+strings.Join(ignores, "\n")+"\n")...)
+	err = os.WriteFile(fn, buf, 0644)
+	Ck(err)
+	return
+}
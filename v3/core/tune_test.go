@@ -0,0 +1,63 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSuggest(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Ck(err)
+	grok.SetEmbedder(lengthEmbedder{}, 256)
+	for _, fn := range []string{"guide.md", "noise.md", "unused.md", "rare.md"} {
+		path := filepath.Join(dir, fn)
+		err = os.WriteFile(path, []byte("# "+fn+"\n"), 0644)
+		Ck(err)
+		err = grok.AddDocument(path)
+		Ck(err)
+	}
+	// log questions that all retrieve guide.md and noise.md, and
+	// only cite guide.md
+	logQ := func(sources []string, answer string) {
+		err := grok.logQuestion("how?", sources, answer)
+		Ck(err)
+	}
+	for i := 0; i < 18; i++ {
+		logQ([]string{"guide.md:1", "noise.md:1"}, "See guide.md:1.")
+	}
+	logQ([]string{"rare.md:1"}, "no idea")
+	sugs, err := grok.Suggest(5)
+	Tassert(t, err == nil && len(sugs) == 0, "expected no suggestions without ratings, got %v, %v", sugs, err)
+
+	for id := 1; id <= 6; id++ {
+		err = grok.Feedback(id, RatingGood, "")
+		Ck(err)
+	}
+	sugs, err = grok.Suggest(5)
+	Tassert(t, err == nil, "error suggesting: %v", err)
+	var got []string
+	for _, s := range sugs {
+		got = append(got, s.Action+" "+s.RelPath)
+	}
+	Tassert(t, strings.Join(got, ", ") == "exclude noise.md, boost guide.md", "got %v", sugs)
+
+	// a 20th question makes unused.md stand out
+	logQ([]string{"guide.md:1"}, "guide.md:1")
+	sugs, err = grok.Suggest(5)
+	Tassert(t, err == nil && len(sugs) == 3 && sugs[1].RelPath == "unused.md", "got %v, %v", sugs, err)
+
+	err = grok.ApplySuggestions(sugs)
+	Tassert(t, err == nil, "error applying: %v", err)
+	Tassert(t, len(grok.Documents) == 2, "expected 2 documents left, got %d", len(grok.Documents))
+	buf, err := os.ReadFile(filepath.Join(dir, ".grokignore"))
+	Tassert(t, err == nil && strings.HasSuffix(string(buf), "/noise.md\n/unused.md\n"), "got %q, %v", buf, err)
+	ignored, err := grok.Ignored(filepath.Join(dir, "noise.md"))
+	Tassert(t, err == nil && ignored, "expected noise.md to be ignored: %v", err)
+	sugs, err = grok.Suggest(5)
+	Tassert(t, err == nil && len(sugs) == 0, "expected nothing left to suggest, got %v, %v", sugs, err)
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// Every chunk carries a checksum of its stored content, and the db
+// carries a checksum over all of the chunk checksums, so 'grok
+// verify' can tell whether a chunk was damaged and whether chunks
+// were lost or added since the last save.  This is mostly for
+// prebuilt indexes distributed as release artifacts; see SetReadOnly.
+//
+// Checksums are written along with the chunks, on a full save or
+// when the chunk is journaled, so they always describe what is on
+// disk.
+
+// chunkContent is the part of a chunk covered by its checksum.
+type chunkContent struct {
+	RelPath   string
+	Offset    int
+	Length    int
+	Hash      string
+	Text      string
+	Line      int
+	Section   string `json:",omitempty"`
+	Embedding []float64
+	Symbols   []string
+	Excluded  string
+	Vectors   map[string][]float64
+}
+
+// checksum returns the checksum of the chunk's stored content.
+func (c *Chunk) checksum() string {
+	content := chunkContent{
+		Offset:    c.Offset,
+		Length:    c.Length,
+		Hash:      c.Hash,
+		Text:      c.Text,
+		Line:      c.Line,
+		Section:   c.Section,
+		Embedding: c.Embedding,
+		Symbols:   c.Symbols,
+		Excluded:  c.Excluded,
+		Vectors:   c.Vectors,
+	}
+	if c.Document != nil {
+		content.RelPath = c.Document.RelPath
+	}
+	buf, err := json.Marshal(content)
+	Ck(err)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// storeChecksum returns the checksum over the checksums of the
+// chunks, in hash order.
+func storeChecksum(chunks []*Chunk) string {
+	var sums []string
+	for _, c := range chunks {
+		sums = append(sums, c.Hash+":"+c.Sum)
+	}
+	sort.Strings(sums)
+	sum := sha256.Sum256([]byte(strings.Join(sums, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// setChecksums updates the checksums of the given chunks, which are
+// about to be written, and the store checksum.
+func (g *Grokker) setChecksums(chunks []*Chunk) {
+	for _, c := range chunks {
+		c.Sum = c.checksum()
+	}
+	g.ChunkSum = storeChecksum(g.Chunks)
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	// Chunks is the number of chunks checked.
+	Chunks int
+	// Unsummed is the number of chunks saved by a version of
+	// grokker that didn't write checksums.
+	Unsummed int
+	// Problems describes each corrupt chunk and any damage to the
+	// store as a whole.
+	Problems []string
+}
+
+// Verify checks every chunk against its checksum, the chunk store
+// against the store checksum, and the embeddings against the
+// database's embedding dimension.
+func (g *Grokker) Verify() (report VerifyReport) {
+	report.Chunks = len(g.Chunks)
+	for _, c := range g.Chunks {
+		where := Spf("chunk %.12s", c.Hash)
+		if c.Document != nil {
+			where = Spf("chunk %.12s (%s offset %d)", c.Hash, c.Document.RelPath, c.Offset)
+		}
+		switch {
+		case c.Sum == "":
+			report.Unsummed++
+		case c.checksum() != c.Sum:
+			report.Problems = append(report.Problems, where+": content does not match its checksum")
+		}
+		if c.Embedding != nil && g.EmbeddingDim > 0 && len(c.Embedding) != g.EmbeddingDim {
+			report.Problems = append(report.Problems, Spf("%s: embedding has %d dimensions, expected %d", where, len(c.Embedding), g.EmbeddingDim))
+		}
+	}
+	// the db and each loaded shard have their own store checksum;
+	// see shard.go
+	main, byShard := g.splitShards()
+	if g.ChunkSum != "" && g.ChunkSum != storeChecksum(main) {
+		report.Problems = append(report.Problems, "the chunk store does not match its checksum: chunks were removed, added, or altered")
+	}
+	for shard, sum := range g.shardSums {
+		if sum != "" && sum != storeChecksum(byShard[shard]) {
+			report.Problems = append(report.Problems, Spf("shard %s does not match its checksum: chunks were removed, added, or altered", shard))
+		}
+	}
+	return
+}
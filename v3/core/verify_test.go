@@ -0,0 +1,67 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestVerify(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	doc := &Document{RelPath: "a.txt"}
+	grok.Documents = append(grok.Documents, doc)
+	for i := 0; i < 3; i++ {
+		grok.Chunks = append(grok.Chunks, &Chunk{Document: doc, Offset: i, Length: 1, Hash: Spf("h%d", i), Embedding: []float64{float64(i), 0.5}})
+	}
+	grok.EmbeddingDim = 2
+	// journaled, then compacted
+	for _, ratio := range []float64{100, 0} {
+		journalCompactRatio = ratio
+		err = grok.Save()
+		Tassert(t, err == nil, "error saving: %v", err)
+
+		g, _, _, _, lock, err := LoadFrom(grok.grokpath, "", true)
+		Tassert(t, err == nil, "error loading: %v", err)
+		lock.Unlock()
+		report := g.Verify()
+		Tassert(t, report.Chunks == 3 && report.Unsummed == 0 && len(report.Problems) == 0, "unexpected report %+v", report)
+	}
+	journalCompactRatio = 0.25
+
+	// damage a chunk
+	grok.Chunks[1].Embedding[0] = 99
+	report := grok.Verify()
+	Tassert(t, len(report.Problems) == 1, "expected 1 problem, got %v", report.Problems)
+	Tassert(t, strings.Contains(report.Problems[0], "a.txt offset 1"), "unexpected problem %q", report.Problems[0])
+	grok.Chunks[1].Embedding[0] = 1
+
+	// lose a chunk
+	grok.Chunks = grok.Chunks[:2]
+	report = grok.Verify()
+	Tassert(t, len(report.Problems) == 1 && strings.Contains(report.Problems[0], "chunk store"), "unexpected report %+v", report)
+
+	// wrong dimensions
+	grok.Chunks[0].Embedding = []float64{1}
+	grok.Chunks[0].Sum = grok.Chunks[0].checksum()
+	report = grok.Verify()
+	Tassert(t, len(report.Problems) == 2 && strings.Contains(report.Problems[0], "1 dimensions"), "unexpected report %+v", report)
+
+	// old chunks without checksums are counted, not reported
+	grok.Chunks[0].Sum = ""
+	report = grok.Verify()
+	Tassert(t, report.Unsummed == 1, "unexpected report %+v", report)
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	grok.SetReadOnly()
+	err = grok.Save()
+	Tassert(t, err != nil && strings.Contains(err.Error(), "read-only"), "expected a read-only error, got %v", err)
+	updated, err := grok.UpdateEmbeddings()
+	Tassert(t, err == nil && !updated, "unexpected update %v %v", updated, err)
+}
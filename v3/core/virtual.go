@@ -0,0 +1,78 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A virtual document is content that doesn't live at a stable path
+// under g.Root, such as a generated API spec, the output of a
+// command, or a fetched web page.  It is registered under a logical
+// name and updated by name.  We keep a copy of the content next to
+// the db, so everything that reads documents from disk works for
+// virtual documents too.
+
+// virtualDir returns the directory that holds virtual document
+// content.
+func (g *Grokker) virtualDir() string {
+	return g.grokpath + ".virtual"
+}
+
+// virtualPath returns the path of the content of a virtual document.
+func (g *Grokker) virtualPath(name string) string {
+	return filepath.Join(g.virtualDir(), escapeName(name))
+}
+
+// escapeName returns name escaped for use as a file name in one of
+// our own directories.  url.PathEscape leaves names made only of
+// dots, such as "..", as they are, so we escape their dots too;
+// PathEscape never produces "%2E", so no two names collide.
+func escapeName(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.Trim(escaped, ".") == "" {
+		escaped = strings.ReplaceAll(escaped, ".", "%2E")
+	}
+	return escaped
+}
+
+// PutDocument adds or replaces the virtual document with the given
+// name and updates its embeddings.
+func (g *Grokker) PutDocument(name string, content []byte) (err error) {
+	defer Return(&err)
+	if name == "" || name == "." || name == ".." {
+		err = fmt.Errorf("invalid virtual document name %q", name)
+		return
+	}
+	var doc *Document
+	for _, d := range g.Documents {
+		if d.RelPath == name {
+			doc = d
+			break
+		}
+	}
+	if doc != nil && !doc.Virtual {
+		err = fmt.Errorf("%s is already in the knowledge base as a file", name)
+		return
+	}
+	err = os.MkdirAll(g.virtualDir(), 0755)
+	Ck(err)
+	fn := g.virtualPath(name)
+	tmpfn := fn + ".tmp"
+	err = os.WriteFile(tmpfn, content, 0644)
+	Ck(err)
+	err = os.Rename(tmpfn, fn)
+	Ck(err)
+	if doc == nil {
+		doc = &Document{RelPath: name, Virtual: true}
+		g.Documents = append(g.Documents, doc)
+		g.unbury(name)
+	}
+	_, err = g.updateDocument(doc)
+	Ck(err)
+	return
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestVirtualPath(t *testing.T) {
+	dir := TmpTestDir()
+	grok, err := Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	seen := make(map[string]string)
+	for _, name := range []string{".", "..", "...", "%2E", "a/../..", "api/v1.yaml"} {
+		for _, fn := range []string{grok.virtualPath(name), grok.transformedPath(name)} {
+			parent := filepath.Dir(fn)
+			Tassert(t, parent == grok.virtualDir() || parent == grok.transformedDir(), "%q escapes its directory: %s", name, fn)
+			Tassert(t, seen[fn] == "", "%q and %q share %s", name, seen[fn], fn)
+			seen[fn] = name
+		}
+	}
+	for _, name := range []string{"", ".", ".."} {
+		err = grok.PutDocument(name, []byte("x"))
+		Tassert(t, err != nil, "expected an error for %q", name)
+	}
+}
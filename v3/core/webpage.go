@@ -0,0 +1,99 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+// A web page is added by its URL, e.g.
+//
+//	grok add https://example.com/docs/install
+//
+// The page is fetched once and kept as a virtual document whose path
+// is the URL, so answers cite the URL, and refreshing and querying
+// read the kept copy rather than the network.  Adding the URL again
+// fetches it again and re-embeds what changed.  An HTML page goes
+// through the HTML loader like an .html file; see html.go.
+
+// maxPageBytes is the largest web page we fetch.
+const maxPageBytes = 10 << 20
+
+// pageTimeout is how long we wait for a web page.
+const pageTimeout = 30 * time.Second
+
+// IsURL returns true if path is an http or https URL rather than a
+// file path.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// AddURL fetches a web page and adds or updates it as a virtual
+// document.  It returns the document's path: the URL without its
+// fragment.
+func (g *Grokker) AddURL(rawurl string) (name string, err error) {
+	defer Return(&err)
+	u, err := url.Parse(rawurl)
+	Ck(err)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		err = fmt.Errorf("%s is not an http or https URL", rawurl)
+		return
+	}
+	u.Fragment = ""
+	name = u.String()
+	req, err := http.NewRequest("GET", name, nil)
+	Ck(err)
+	req.Header.Set("User-Agent", "grokker")
+	client := &http.Client{Timeout: pageTimeout}
+	res, err := client.Do(req)
+	Ck(err)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s: %s", name, res.Status)
+		return
+	}
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxPageBytes+1))
+	Ck(err)
+	if len(content) > maxPageBytes {
+		err = fmt.Errorf("%s is larger than %d bytes", name, maxPageBytes)
+		return
+	}
+	ctype := res.Header.Get("Content-Type")
+	if ctype == "" {
+		ctype = http.DetectContentType(content)
+	}
+	if !isTextType(ctype) {
+		err = fmt.Errorf("%s is %s, not text", name, ctype)
+		return
+	}
+	Debug("fetched %s: %d bytes of %s", name, len(content), ctype)
+	err = g.PutDocument(name, content)
+	Ck(err)
+	return
+}
+
+// isTextType returns true if a Content-Type is text we can index,
+// such as HTML, markdown, JSON, or YAML.
+func isTextType(ctype string) bool {
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediatype, "text/"):
+		return true
+	case strings.HasSuffix(mediatype, "+xml"), strings.HasSuffix(mediatype, "+json"):
+		return true
+	}
+	switch mediatype {
+	case "application/json", "application/xml", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return false
+}
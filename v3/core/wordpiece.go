@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+
+	. "github.com/stevegt/goadapt"
+	"golang.org/x/text/unicode/norm"
+)
+
+// wordpiece is a BERT-style WordPiece tokenizer, as used by small
+// sentence embedding models such as all-MiniLM-L6-v2 and
+// bge-small-en.  It implements the uncased variant: text is
+// lowercased and accents are stripped before splitting.
+type wordpiece struct {
+	vocab map[string]int64
+	// maximum sequence length, including [CLS] and [SEP]
+	maxLen int
+	cls    int64
+	sep    int64
+	unk    int64
+}
+
+// loadWordpiece reads a vocab.txt file, which has one token per line
+// with the line number as the token id.
+func loadWordpiece(path string, maxLen int) (wp *wordpiece, err error) {
+	defer Return(&err)
+	fh, err := os.Open(path)
+	Ck(err)
+	defer fh.Close()
+	wp = &wordpiece{vocab: make(map[string]int64), maxLen: maxLen}
+	scanner := bufio.NewScanner(fh)
+	var id int64
+	for scanner.Scan() {
+		wp.vocab[strings.TrimRight(scanner.Text(), "\r")] = id
+		id++
+	}
+	err = scanner.Err()
+	Ck(err)
+	var ok bool
+	wp.cls, ok = wp.vocab["[CLS]"]
+	Assert(ok, "%s: missing [CLS] token", path)
+	wp.sep, ok = wp.vocab["[SEP]"]
+	Assert(ok, "%s: missing [SEP] token", path)
+	wp.unk, ok = wp.vocab["[UNK]"]
+	Assert(ok, "%s: missing [UNK] token", path)
+	return
+}
+
+// encode returns the token ids for text, wrapped in [CLS] and [SEP]
+// and truncated to maxLen.
+func (wp *wordpiece) encode(text string) (ids []int64) {
+	ids = append(ids, wp.cls)
+	for _, word := range basicTokenize(text) {
+		for _, id := range wp.wordIDs(word) {
+			if len(ids) >= wp.maxLen-1 {
+				return append(ids, wp.sep)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return append(ids, wp.sep)
+}
+
+// encodePair returns the token ids and token type ids for a pair
+// of texts, as "[CLS] a [SEP] b [SEP]", for cross-encoders.  If the
+// pair is too long, a is cut to no more than half of maxLen, then b
+// is cut to fit.
+func (wp *wordpiece) encodePair(a, b string) (ids, types []int64) {
+	var aids, bids []int64
+	for _, word := range basicTokenize(a) {
+		aids = append(aids, wp.wordIDs(word)...)
+	}
+	for _, word := range basicTokenize(b) {
+		bids = append(bids, wp.wordIDs(word)...)
+	}
+	room := wp.maxLen - 3
+	if len(aids) > room/2 && len(aids)+len(bids) > room {
+		keep := room - len(bids)
+		if keep < room/2 {
+			keep = room / 2
+		}
+		aids = aids[:keep]
+	}
+	if len(aids)+len(bids) > room {
+		bids = bids[:room-len(aids)]
+	}
+	ids = append(ids, wp.cls)
+	ids = append(ids, aids...)
+	ids = append(ids, wp.sep)
+	for range ids {
+		types = append(types, 0)
+	}
+	ids = append(ids, bids...)
+	ids = append(ids, wp.sep)
+	for len(types) < len(ids) {
+		types = append(types, 1)
+	}
+	return
+}
+
+// wordIDs splits a single word into the longest matching vocabulary
+// pieces, greedily from the left.  Continuation pieces are prefixed
+// with "##".  A word that can't be split maps to [UNK].
+func (wp *wordpiece) wordIDs(word string) (ids []int64) {
+	runes := []rune(word)
+	if len(runes) > 100 {
+		return []int64{wp.unk}
+	}
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		found := false
+		var id int64
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			id, found = wp.vocab[piece]
+			if found {
+				break
+			}
+		}
+		if !found {
+			return []int64{wp.unk}
+		}
+		ids = append(ids, id)
+		start = end
+	}
+	return
+}
+
+// basicTokenize lowercases text, strips accents and control
+// characters, and splits on whitespace and punctuation.  Each
+// punctuation character becomes its own word, as do CJK characters.
+func basicTokenize(text string) (words []string) {
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for _, r := range norm.NFD.String(strings.ToLower(text)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// combining accent
+		case r == 0 || r == unicode.ReplacementChar:
+		case unicode.IsSpace(r):
+			flush()
+		case unicode.IsControl(r):
+		case isPunct(r) || isCJK(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			cur = append(cur, r)
+		}
+	}
+	flush()
+	return
+}
+
+// isPunct treats all non-alphanumeric ASCII as punctuation, as BERT
+// does, in addition to the Unicode punctuation classes.
+func isPunct(r rune) bool {
+	if (r >= 33 && r <= 47) || (r >= 58 && r <= 64) || (r >= 91 && r <= 96) || (r >= 123 && r <= 126) {
+		return true
+	}
+	return unicode.IsPunct(r)
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
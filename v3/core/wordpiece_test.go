@@ -0,0 +1,45 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestWordpiece(t *testing.T) {
+	vocab := []string{"[PAD]", "[UNK]", "[CLS]", "[SEP]", "hello", "world", "un", "##aff", "##able", ",", "!", "cafe"}
+	fn := filepath.Join(TmpTestDir(), "vocab.txt")
+	err := os.WriteFile(fn, []byte(strings.Join(vocab, "\n")+"\n"), 0644)
+	Tassert(t, err == nil, "error writing vocab: %v", err)
+	wp, err := loadWordpiece(fn, 512)
+	Tassert(t, err == nil, "error loading vocab: %v", err)
+
+	words := basicTokenize("Hello, WORLD!  Café\tunaffable")
+	Tassert(t, strings.Join(words, " ") == "hello , world ! cafe unaffable", "unexpected words: %q", words)
+
+	ids := wp.encode("Hello, world! unaffable xyzzy")
+	expect := []int64{2, 4, 9, 5, 10, 6, 7, 8, 1, 3}
+	Tassert(t, len(ids) == len(expect), "expected %v, got %v", expect, ids)
+	for i := range ids {
+		Tassert(t, ids[i] == expect[i], "expected %v, got %v", expect, ids)
+	}
+
+	// truncation keeps [CLS] and [SEP]
+	wp.maxLen = 4
+	ids = wp.encode("hello world hello world")
+	Tassert(t, len(ids) == 4 && ids[0] == 2 && ids[3] == 3, "unexpected truncation: %v", ids)
+
+	// pairs get token types, and the second text is cut first
+	wp.maxLen = 8
+	ids, types := wp.encodePair("hello", "world world world world world")
+	Tassert(t, len(ids) == 8 && len(types) == 8, "unexpected pair length: %v", ids)
+	Tassert(t, ids[0] == 2 && ids[1] == 4 && ids[2] == 3 && ids[7] == 3, "unexpected pair: %v", ids)
+	Tassert(t, types[2] == 0 && types[3] == 1, "unexpected token types: %v", types)
+
+	// mean pooling ignores masked tokens and normalizes
+	vec := meanPool([]float32{3, 0, 0, 4, 100, 100}, []int64{1, 1, 0}, 2)
+	Tassert(t, vec[0] > 0.599 && vec[0] < 0.601 && vec[1] > 0.799 && vec[1] < 0.801, "unexpected vector: %v", vec)
+}
@@ -10,11 +10,10 @@ require (
 	github.com/gofrs/flock v0.8.1
 	// github.com/sashabaranov/go-openai v1.24.1
 	github.com/stevegt/goadapt v0.7.0
-	github.com/tiktoken-go/tokenizer v0.1.0
+	github.com/tiktoken-go/tokenizer v0.4.0
 )
 
 require (
-	github.com/anmitsu/go-shlex v0.0.0-20200514113438-38f4b401e2be
 	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06
@@ -22,14 +21,31 @@ require (
 	github.com/sergi/go-diff v1.3.1
 	github.com/stevegt/envi v0.2.0
 	github.com/stevegt/semver v0.0.0-20240217000820-5913d1a31c26
+	github.com/yalue/onnxruntime_go v1.36.0
+	golang.org/x/crypto v0.14.0
+	golang.org/x/sys v0.19.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.29.10
 )
 
 require (
 	github.com/alecthomas/assert/v2 v2.3.0 // indirect
 	github.com/alecthomas/repr v0.2.0 // indirect
-	github.com/dlclark/regexp2 v1.9.0 // indirect
+	github.com/dlclark/regexp2 v1.11.5-0.20240806004527-5bbbed8ea10b // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/rogpeppe/go-internal v1.12.0 // indirect
-	golang.org/x/sys v0.5.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/libc v1.49.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
 )
@@ -0,0 +1,183 @@
+// Package retrieval embeds grokker's index and search in Go programs
+// that bring their own language model.  It needs no provider
+// credentials: the program supplies the embedding model as an
+// Embedder, and nothing in this package sends text to a chat model.
+// A knowledge base created here is an ordinary grokker database, so
+// the grok command can use it too, given the same embedder.
+//
+//	ix, err := retrieval.Create(".", retrieval.Options{Embedder: myEmbedder})
+//	err = ix.Add("docs/deploy.md")
+//	err = ix.Save()
+//	res, err := ix.Search("how do I deploy?", retrieval.Query{TokenLimit: 2000})
+//	// send res.Context and the question to your model
+//	err = ix.Close()
+package retrieval
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// Embedder creates embeddings; see core.Embedder.
+type Embedder = core.Embedder
+
+// defaultChunkTokens is the chunk size used when Options.ChunkTokens
+// isn't set; it suits small BERT-style models.
+const defaultChunkTokens = 256
+
+var errNoEmbedder = fmt.Errorf("an embedder is required")
+
+// Options configure an Index.
+type Options struct {
+	// Embedder creates the embeddings; required.
+	Embedder Embedder
+	// ChunkTokens is the largest chunk, in tokens, the embedder
+	// accepts.  Default 256.
+	ChunkTokens int
+	// ReadOnly opens the index for searching only, sharing it with
+	// other readers.
+	ReadOnly bool
+}
+
+// Index is a knowledge base opened for indexing and search.  Like
+// core.Grokker, it isn't safe for concurrent use.
+type Index struct {
+	g    *core.Grokker
+	lock *flock.Flock
+}
+
+// Query limits a search.
+type Query struct {
+	// TokenLimit is the most tokens of context to return.
+	TokenLimit int
+	// K is the most chunks to return; 0 means no limit.
+	K int
+	// Filter limits the chunks searched, e.g. to some collections;
+	// nil searches them all.
+	Filter *core.Filter
+}
+
+// Result is the outcome of a search.
+type Result struct {
+	// Context is the text of the best chunks, each with a header
+	// naming its document, ready to give to a model.
+	Context string
+	// Sources are the chunks' citations, as "path:line".
+	Sources []string
+	// Chunks are the chunks in Context, best first.
+	Chunks []*core.ChunkRef
+}
+
+// Create creates a knowledge base in root and opens it.
+func Create(root string, opts Options) (ix *Index, err error) {
+	defer Return(&err)
+	if opts.Embedder == nil {
+		err = errNoEmbedder
+		return
+	}
+	_, err = core.Init(root, "")
+	Ck(err)
+	ix, err = Open(filepath.Join(root, ".grok"), opts)
+	Ck(err)
+	return
+}
+
+// Open opens the knowledge base in the given .grok file.
+func Open(grokpath string, opts Options) (ix *Index, err error) {
+	defer Return(&err)
+	if opts.Embedder == nil {
+		err = errNoEmbedder
+		return
+	}
+	if opts.ChunkTokens <= 0 {
+		opts.ChunkTokens = defaultChunkTokens
+	}
+	g, _, _, _, lock, err := core.LoadFrom(grokpath, "", opts.ReadOnly)
+	Ck(err)
+	ix = &Index{g: g, lock: lock}
+	// these pipeline stages ask the chat model
+	if g.Pipeline.Router == "llm" || g.Pipeline.Summarizer == "llm" {
+		ix.Close()
+		ix = nil
+		err = fmt.Errorf("%s: the pipeline's llm router or summarizer needs a chat model; change them with 'grok pipeline'", grokpath)
+		return
+	}
+	g.SetEmbedder(opts.Embedder, opts.ChunkTokens)
+	if opts.ReadOnly {
+		g.SetReadOnly()
+	}
+	return
+}
+
+// Close releases the index's lock without saving it.
+func (ix *Index) Close() error {
+	return ix.lock.Unlock()
+}
+
+// Save writes the index to disk.
+func (ix *Index) Save() error {
+	return ix.g.Save()
+}
+
+// Add indexes a file under the index's root, or re-indexes it if it
+// changed.
+func (ix *Index) Add(path string) error {
+	return ix.g.AddDocument(path)
+}
+
+// Put indexes content as a virtual document with the given name,
+// replacing any document with that name.
+func (ix *Index) Put(name string, content []byte) error {
+	return ix.g.PutDocument(name, content)
+}
+
+// Forget removes a document from the index.
+func (ix *Index) Forget(path string) error {
+	return ix.g.ForgetDocument(path)
+}
+
+// Refresh re-indexes the documents whose files changed since the
+// index was last saved.
+func (ix *Index) Refresh() (err error) {
+	_, err = ix.g.UpdateEmbeddings()
+	return
+}
+
+// Documents returns the paths of the documents in the index.
+func (ix *Index) Documents() []string {
+	return ix.g.ListDocuments()
+}
+
+// Search returns the chunks most relevant to text, as context for a
+// model.
+func (ix *Index) Search(text string, q Query) (res *Result, err error) {
+	defer Return(&err)
+	ix.g.SetFilter(q.Filter)
+	defer ix.g.SetFilter(nil)
+	ix.g.SetContextLimits(q.K, 0)
+	defer ix.g.SetContextLimits(0, 0)
+	res = &Result{}
+	res.Context, err = ix.g.Context(text, q.TokenLimit, true, false)
+	Ck(err)
+	res.Sources = ix.g.Sources()
+	for _, id := range ix.g.SourceIDs() {
+		ref, ok, err := ix.g.ChunkByID(id)
+		Ck(err)
+		if ok {
+			res.Chunks = append(res.Chunks, ref)
+		}
+	}
+	return
+}
+
+// Grokker returns the underlying knowledge base, for the parts of the
+// core API this package doesn't wrap, e.g. collections and tags.
+// Methods that ask a chat model, such as Answer, need provider
+// credentials.
+func (ix *Index) Grokker() *core.Grokker {
+	return ix.g
+}
@@ -0,0 +1,87 @@
+package retrieval
+
+import (
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// wordEmbedder embeds a text as its bag of words, hashed into a few
+// dimensions.
+type wordEmbedder struct{}
+
+func (wordEmbedder) Embed(texts []string) (embeddings [][]float64, err error) {
+	for _, text := range texts {
+		em := make([]float64, 64)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,?")))
+			em[h.Sum32()%64]++
+		}
+		embeddings = append(embeddings, em)
+	}
+	return
+}
+
+func (wordEmbedder) Name() string { return "test:words" }
+
+func TestIndex(t *testing.T) {
+	// no provider credentials are needed
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("GROKKER_EMBEDDER", "")
+	dir := core.TmpTestDir()
+	err := os.WriteFile(filepath.Join(dir, "deploy.md"), []byte("Deploy the server with make deploy.\n"), 0644)
+	Ck(err)
+	err = os.WriteFile(filepath.Join(dir, "cats.md"), []byte("Cats sleep most of the day.\n"), 0644)
+	Ck(err)
+
+	_, err = Create(dir, Options{})
+	Tassert(t, err != nil, "expected an error without an embedder")
+
+	ix, err := Create(dir, Options{Embedder: wordEmbedder{}})
+	Tassert(t, err == nil, "error creating index: %v", err)
+	for _, fn := range []string{"deploy.md", "cats.md"} {
+		err = ix.Add(filepath.Join(dir, fn))
+		Tassert(t, err == nil, "error adding %s: %v", fn, err)
+	}
+	err = ix.Put("notes/release.md", []byte("Release notes for the server.\n"))
+	Tassert(t, err == nil, "error putting document: %v", err)
+	Tassert(t, len(ix.Documents()) == 3, "expected 3 documents, got %v", ix.Documents())
+
+	res, err := ix.Search("how do I deploy the server?", Query{TokenLimit: 1000, K: 1})
+	Tassert(t, err == nil, "error searching: %v", err)
+	Tassert(t, len(res.Chunks) == 1, "expected 1 chunk, got %d", len(res.Chunks))
+	Tassert(t, res.Chunks[0].Path == "deploy.md", "expected deploy.md, got %s", res.Chunks[0].Path)
+	Tassert(t, strings.Contains(res.Context, "make deploy"), "unexpected context %q", res.Context)
+	Tassert(t, len(res.Sources) == 1, "expected 1 source, got %v", res.Sources)
+	err = ix.Save()
+	Ck(err)
+	err = ix.Close()
+	Ck(err)
+
+	// the embedder's name is recorded, and it must match on reopen
+	grokpath := filepath.Join(dir, ".grok")
+	ix, err = Open(grokpath, Options{Embedder: wordEmbedder{}, ReadOnly: true})
+	Tassert(t, err == nil, "error opening index: %v", err)
+	Tassert(t, ix.Grokker().EmbeddingProvider == "test", "unexpected provider %q", ix.Grokker().EmbeddingProvider)
+	res, err = ix.Search("cats", Query{TokenLimit: 1000, K: 1})
+	Tassert(t, err == nil, "error searching: %v", err)
+	Tassert(t, len(res.Chunks) == 1 && res.Chunks[0].Path == "cats.md", "unexpected chunks %v", res.Chunks)
+	err = ix.Close()
+	Ck(err)
+
+	// pipelines that ask the chat model are refused
+	g, _, _, _, lock, err := core.LoadFrom(grokpath, "", false)
+	Ck(err)
+	g.Pipeline.Router = "llm"
+	err = g.Save()
+	Ck(err)
+	lock.Unlock()
+	_, err = Open(grokpath, Options{Embedder: wordEmbedder{}})
+	Tassert(t, err != nil, "expected an error for the llm router")
+}
@@ -0,0 +1,251 @@
+package serve
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the "serve:" section of the grokker config file; see
+// core.ConfigPath.
+//
+//	serve:
+//	  tokens:
+//	    - name: alice
+//	      sha256: 9f86d0...   # from 'grok serve token'
+//	      read: ["*"]
+//	      write: [docs]
+//	    - name: ci
+//	      sha256: 60303a...
+//	      read: [docs, code]
+//	      tags: [public, internal]
+type Config struct {
+	Tokens []*Token `yaml:"tokens"`
+	// Refresh schedules background re-indexing; see scheduler.go.
+	Refresh *RefreshConfig `yaml:"refresh"`
+	// Webhooks are told about index and answer events; see
+	// webhooks.go.
+	Webhooks []*Webhook `yaml:"webhooks"`
+	// LowConfidence is the similarity below which an answer's best
+	// source makes it low-confidence; 0 turns this test off.
+	LowConfidence float64 `yaml:"low_confidence"`
+	// Tenants are other knowledge bases served by the same server;
+	// see tenants.go.
+	Tenants []*Tenant `yaml:"tenants"`
+	// Reload says where to reload the index from; see reload.go.
+	Reload *ReloadConfig `yaml:"reload"`
+}
+
+// Token is an API token and the collections it can use.  Only the
+// sha256 of the token is stored, so the config file doesn't hold
+// credentials.
+type Token struct {
+	// Name identifies the token's owner in logs.
+	Name string `yaml:"name"`
+	// SHA256 is the hex sha256 of the token.
+	SHA256 string `yaml:"sha256"`
+	// Read lists the collections the token can query; "*" means
+	// all of them.
+	Read []string `yaml:"read"`
+	// Write lists the collections the token can add documents to;
+	// "*" means all of them.
+	Write []string `yaml:"write"`
+	// Tags lists the access tags the token is cleared for; the
+	// token can only use documents whose tags are all in this list.
+	// Empty or "*" means all tags; see core.Filter.Tags.
+	Tags []string `yaml:"tags"`
+	// RequestsPerMinute and TokensPerDay are the token's quotas;
+	// see quota.go.
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	TokensPerDay      int `yaml:"tokens_per_day"`
+	// Admin lets the token call the /v1/admin endpoints.
+	Admin bool `yaml:"admin"`
+}
+
+// openToken is the identity used when the server runs without
+// authentication.
+var openToken = &Token{Name: "anonymous", Read: []string{"*"}, Write: []string{"*"}, Admin: true}
+
+// LoadConfig reads the serve section of the config file.
+func LoadConfig() (cfg *Config, err error) {
+	defer Return(&err)
+	cfg = &Config{}
+	path := core.ConfigPath()
+	if path == "" {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	Ck(err)
+	var file struct {
+		Serve *Config `yaml:"serve"`
+	}
+	err = yaml.Unmarshal(buf, &file)
+	Ck(err, "%s", path)
+	if file.Serve != nil {
+		cfg = file.Serve
+	}
+	err = checkTokens(path, cfg.Tokens)
+	Ck(err)
+	err = checkTenants(path, cfg.Tenants)
+	Ck(err)
+	return
+}
+
+// checkTokens verifies the tokens in the config file at path, and
+// puts their hashes and tags in lower case.
+func checkTokens(path string, tokens []*Token) (err error) {
+	for _, tok := range tokens {
+		if len(tok.SHA256) != 64 {
+			return fmt.Errorf("%s: token %q: sha256 must be 64 hex digits", path, tok.Name)
+		}
+		tok.SHA256 = strings.ToLower(tok.SHA256)
+		for i, tag := range tok.Tags {
+			tok.Tags[i] = strings.ToLower(tag)
+		}
+	}
+	return
+}
+
+// NewToken returns a random token and its hex sha256, for the config
+// file.
+func NewToken() (token, sum string, err error) {
+	defer Return(&err)
+	buf := make([]byte, 24)
+	_, err = rand.Read(buf)
+	Ck(err)
+	token = "grok_" + hex.EncodeToString(buf)
+	sum = hashToken(token)
+	return
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns the token for the request's bearer token, or
+// nil if it doesn't match any configured token.
+func (s *Server) authenticate(r *http.Request) *Token {
+	if s.noAuth {
+		return openToken
+	}
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer == "" {
+		return nil
+	}
+	sum := []byte(hashToken(strings.TrimSpace(bearer)))
+	for _, tok := range s.cfg.Tokens {
+		if subtle.ConstantTimeCompare(sum, []byte(tok.SHA256)) == 1 {
+			return tok
+		}
+	}
+	return nil
+}
+
+type tokenKey struct{}
+
+// withToken returns a context that carries the request's token.
+func withToken(ctx context.Context, tok *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, tok)
+}
+
+// token returns the token of an authenticated request.
+func token(ctx context.Context) *Token {
+	return ctx.Value(tokenKey{}).(*Token)
+}
+
+// allowed returns true if collection is in the ACL list.
+func allowed(list []string, collection string) bool {
+	for _, name := range list {
+		if name == "*" || name == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead returns true if the token can query the collection.
+func (tok *Token) CanRead(collection string) bool {
+	return allowed(tok.Read, collection)
+}
+
+// CanWrite returns true if the token can add documents to the
+// collection.
+func (tok *Token) CanWrite(collection string) bool {
+	return allowed(tok.Write, collection)
+}
+
+// readable narrows the requested collections to those the token can
+// read.  If none were requested, it returns all readable collections
+// in the database, or nil if the token can read everything, which
+// leaves the choice to the pipeline's router.
+func (tok *Token) readable(requested []string, all []core.CollectionInfo) (colls []string, err error) {
+	if len(requested) > 0 {
+		for _, name := range requested {
+			if !tok.CanRead(name) {
+				err = fmt.Errorf("token %q can't read collection %q", tok.Name, name)
+				return
+			}
+		}
+		return requested, nil
+	}
+	if allowed(tok.Read, "*") {
+		return nil, nil
+	}
+	for _, info := range all {
+		if tok.CanRead(info.Name) {
+			colls = append(colls, info.Name)
+		}
+	}
+	if len(colls) == 0 {
+		err = fmt.Errorf("token %q can't read any collections", tok.Name)
+	}
+	return
+}
+
+// CanSee returns true if the token is cleared for every tag in
+// tags.
+func (tok *Token) CanSee(tags []string) bool {
+	if len(tok.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if !allowed(tok.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	return true
+}
+
+// clearance narrows the requested tags to those the token is cleared
+// for.  If none were requested, it returns the token's tags, or nil
+// if the token is cleared for all of them.
+func (tok *Token) clearance(requested []string) (tags []string, err error) {
+	for _, tag := range requested {
+		if !tok.CanSee([]string{tag}) {
+			err = fmt.Errorf("token %q isn't cleared for tag %q", tok.Name, tag)
+			return
+		}
+	}
+	if len(requested) > 0 {
+		return requested, nil
+	}
+	if len(tok.Tags) == 0 || allowed(tok.Tags, "*") {
+		return nil, nil
+	}
+	return tok.Tags, nil
+}
@@ -0,0 +1,214 @@
+// Package client is a Go client for the API served by 'grok serve';
+// see package serve for the endpoints, and serve/openapi.json for
+// their OpenAPI document.
+//
+//	c := client.New("http://localhost:7070", os.Getenv("GROK_TOKEN"))
+//	resp, err := c.Query(ctx, serve.QueryRequest{Question: "how do I deploy?"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stevegt/grokker/v3/core"
+	"github.com/stevegt/grokker/v3/serve"
+)
+
+// Client calls a grokker server.
+type Client struct {
+	// BaseURL is the server's URL, e.g. http://localhost:7070, or
+	// a tenant's, e.g. http://localhost:7070/tenants/payments.
+	BaseURL string
+	// Token is the API token, or empty for a server running
+	// without authentication.
+	Token string
+	// Tenant, if not empty, names the tenant whose knowledge base
+	// to use; see serve.TenantHeader.
+	Tenant string
+	// HTTPClient makes the requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is how long to wait before trying again, for
+	// requests refused by a quota.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("grokker server: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+// Query asks a question; see POST /v1/q.
+func (c *Client) Query(ctx context.Context, req serve.QueryRequest) (resp *serve.QueryResponse, err error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	resp = &serve.QueryResponse{}
+	err = c.do(ctx, "POST", "/v1/q", nil, bytes.NewReader(buf), "application/json", resp)
+	if err != nil {
+		resp = nil
+	}
+	return
+}
+
+// Sources returns the passages an answer to a question would use,
+// ranked and scored, without asking a chat model; see POST
+// /v1/sources.
+func (c *Client) Sources(ctx context.Context, req serve.SourcesRequest) (resp *serve.SourcesResponse, err error) {
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	resp = &serve.SourcesResponse{}
+	err = c.do(ctx, "POST", "/v1/sources", nil, bytes.NewReader(buf), "application/json", resp)
+	if err != nil {
+		resp = nil
+	}
+	return
+}
+
+// Chunk returns a chunk cited by an answer, by its ID or a unique
+// prefix of 8 or more characters of it.
+func (c *Client) Chunk(ctx context.Context, id string) (ref *core.ChunkRef, err error) {
+	ref = &core.ChunkRef{}
+	err = c.do(ctx, "GET", "/v1/chunks/"+url.PathEscape(id), nil, nil, "", ref)
+	if err != nil {
+		ref = nil
+	}
+	return
+}
+
+// Collections returns the collections the token can read.
+func (c *Client) Collections(ctx context.Context) (infos []core.CollectionInfo, err error) {
+	err = c.do(ctx, "GET", "/v1/collections", nil, nil, "", &infos)
+	return
+}
+
+// PutDocument adds or replaces a virtual document with the given
+// content, in the given collection, or the default collection if
+// collection is empty, and with the given access tags.
+func (c *Client) PutDocument(ctx context.Context, name string, content io.Reader, collection string, tags []string) error {
+	query := url.Values{}
+	if collection != "" {
+		query.Set("collection", collection)
+	}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	return c.do(ctx, "PUT", "/v1/documents/"+escapeName(name), query, content, "application/octet-stream", nil)
+}
+
+// ForgetDocument forgets a document, leaving a tombstone.
+func (c *Client) ForgetDocument(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", "/v1/documents/"+escapeName(name), nil, nil, "", nil)
+}
+
+// Usage returns the token's usage and quotas.
+func (c *Client) Usage(ctx context.Context) (usage *serve.Usage, err error) {
+	usage = &serve.Usage{}
+	err = c.do(ctx, "GET", "/v1/usage", nil, nil, "", usage)
+	if err != nil {
+		usage = nil
+	}
+	return
+}
+
+// Index returns the re-indexing schedule and when each readable
+// document was last indexed.
+func (c *Client) Index(ctx context.Context) (index *serve.IndexResponse, err error) {
+	index = &serve.IndexResponse{}
+	err = c.do(ctx, "GET", "/v1/index", nil, nil, "", index)
+	if err != nil {
+		index = nil
+	}
+	return
+}
+
+// Reload tells the server to replace its index with the newly built
+// export named in its reload config; the token must be an admin
+// token.  See POST /v1/admin/reload.
+func (c *Client) Reload(ctx context.Context) (resp *serve.ReloadResponse, err error) {
+	resp = &serve.ReloadResponse{}
+	err = c.do(ctx, "POST", "/v1/admin/reload", nil, nil, "", resp)
+	if err != nil {
+		resp = nil
+	}
+	return
+}
+
+// escapeName escapes each part of a document name, keeping its
+// slashes.
+func escapeName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// do sends a request and decodes the JSON response into out, unless
+// out is nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string, out interface{}) (err error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Tenant != "" {
+		req.Header.Set(serve.TenantHeader, c.Tenant)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		e := &Error{StatusCode: resp.StatusCode}
+		var errResp serve.ErrorResponse
+		buf, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(buf, &errResp) == nil && errResp.Error != "" {
+			e.Message = errResp.Error
+		} else {
+			e.Message = strings.TrimSpace(string(buf))
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return e
+	}
+	if out == nil {
+		return
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
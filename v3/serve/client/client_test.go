@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+	"github.com/stevegt/grokker/v3/serve"
+)
+
+func TestClient(t *testing.T) {
+	g, err := core.Init(core.TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	g.Documents = append(g.Documents,
+		&core.Document{RelPath: "notes/a b.md", Collection: "docs"},
+		&core.Document{RelPath: "b.go", Collection: "code"},
+	)
+	alice, aliceSum, err := serve.NewToken()
+	Tassert(t, err == nil, "error creating token: %v", err)
+	cfg := &serve.Config{Tokens: []*serve.Token{
+		{Name: "alice", SHA256: aliceSum, Read: []string{"docs"}, Write: []string{"docs"}},
+	}}
+	srv, err := serve.NewServer(g, cfg, false)
+	Tassert(t, err == nil, "error creating server: %v", err)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	ctx := context.Background()
+
+	// a bad token gets a typed error
+	_, err = New(ts.URL, "grok_nope").Collections(ctx)
+	var e *Error
+	Tassert(t, errors.As(err, &e) && e.StatusCode == http.StatusUnauthorized, "expected 401, got %v", err)
+
+	c := New(ts.URL+"/", alice)
+	infos, err := c.Collections(ctx)
+	Tassert(t, err == nil, "error listing collections: %v", err)
+	Tassert(t, len(infos) == 1 && infos[0].Name == "docs", "expected only docs, got %v", infos)
+
+	index, err := c.Index(ctx)
+	Tassert(t, err == nil, "error getting index: %v", err)
+	Tassert(t, len(index.Documents) == 1 && index.Documents[0].Path == "notes/a b.md", "unexpected index %v", index.Documents)
+
+	usage, err := c.Usage(ctx)
+	Tassert(t, err == nil, "error getting usage: %v", err)
+	Tassert(t, usage.Name == "alice", "expected alice's usage, got %v", usage)
+
+	_, err = c.Chunk(ctx, "0123456789abcdef")
+	Tassert(t, errors.As(err, &e) && e.StatusCode == http.StatusNotFound, "expected 404, got %v", err)
+
+	_, err = c.Query(ctx, serve.QueryRequest{Question: "what is b?", Collections: []string{"code"}})
+	Tassert(t, errors.As(err, &e) && e.StatusCode == http.StatusForbidden, "expected 403, got %v", err)
+	Tassert(t, strings.Contains(e.Message, "code"), "expected the server's message, got %q", e.Message)
+
+	_, err = c.Sources(ctx, serve.SourcesRequest{Question: "what is b?", Collections: []string{"code"}})
+	Tassert(t, errors.As(err, &e) && e.StatusCode == http.StatusForbidden, "expected 403, got %v", err)
+
+	err = c.PutDocument(ctx, "x.md", strings.NewReader("x"), "code", nil)
+	Tassert(t, errors.As(err, &e) && e.StatusCode == http.StatusForbidden, "expected 403, got %v", err)
+
+	// names with slashes and spaces survive the trip
+	err = c.ForgetDocument(ctx, "notes/a b.md")
+	Tassert(t, err == nil, "error forgetting document: %v", err)
+	infos, err = c.Collections(ctx)
+	Tassert(t, err == nil, "error listing collections: %v", err)
+	Tassert(t, len(infos) == 0, "expected no readable collections, got %v", infos)
+}
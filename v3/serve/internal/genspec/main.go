@@ -0,0 +1,18 @@
+// Command genspec writes the OpenAPI document for the serve API to
+// the file named on the command line; see serve/openapi.go.
+package main
+
+import (
+	"os"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/serve"
+)
+
+func main() {
+	Assert(len(os.Args) == 2, "usage: genspec <file>")
+	buf, err := serve.OpenAPIJSON()
+	Ck(err)
+	err = os.WriteFile(os.Args[1], buf, 0644)
+	Ck(err)
+}
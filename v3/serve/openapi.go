@@ -0,0 +1,317 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// The routes below are both what the server serves and what the
+// OpenAPI document describes, so the two can't drift apart.  The
+// document's schemas are made from the request and response types
+// by reflection, following the rules encoding/json uses to encode
+// them.  openapi.json is the document as generated by 'go generate';
+// a test fails if it is out of date.
+
+//go:generate go run ./internal/genspec openapi.json
+
+// specPath is where the OpenAPI document is served.
+const specPath = "/v1/openapi.json"
+
+// rawBody marks a route whose request body is the raw content of a
+// document rather than JSON.
+type rawBody struct{}
+
+// param is a path or query parameter.
+type param struct {
+	name string
+	// in is "path" or "query".
+	in          string
+	description string
+	// repeated is true for a query parameter that can be given
+	// more than once.
+	repeated bool
+}
+
+// route is an API endpoint.
+type route struct {
+	method string
+	// pattern is the http.ServeMux pattern.
+	pattern string
+	// id is the OpenAPI operation ID, which clients generated from
+	// the document use as the method name.
+	id      string
+	summary string
+	params  []param
+	// request is a value of the type of the request body, or nil
+	// if there is none.
+	request interface{}
+	// response is a value of the type of the response body, or
+	// nil if the endpoint responds with 204 No Content.
+	response interface{}
+	// public is true if the endpoint needs no token.
+	public  bool
+	handler func(s *Server, w http.ResponseWriter, r *http.Request)
+}
+
+var routes = []route{
+	{
+		method: "POST", pattern: "/v1/q", id: "query",
+		summary:  "Answer a question using the collections and documents the token can read.",
+		request:  QueryRequest{},
+		response: QueryResponse{},
+		handler:  (*Server).handleQuery,
+	},
+	{
+		method: "POST", pattern: "/v1/sources", id: "sources",
+		summary:  "Find the passages an answer to a question would use, ranked and scored, without asking a chat model.",
+		request:  SourcesRequest{},
+		response: SourcesResponse{},
+		handler:  (*Server).handleSources,
+	},
+	{
+		method: "GET", pattern: "/v1/chunks/{id}", id: "getChunk",
+		summary:  "Get a chunk cited by an answer.",
+		params:   []param{{name: "id", in: "path", description: "The chunk ID, or a unique prefix of 8 or more characters."}},
+		response: core.ChunkRef{},
+		handler:  (*Server).handleChunk,
+	},
+	{
+		method: "GET", pattern: "/v1/collections", id: "listCollections",
+		summary:  "List the collections the token can read.",
+		response: []core.CollectionInfo{},
+		handler:  (*Server).handleCollections,
+	},
+	{
+		method: "PUT", pattern: "/v1/documents/{name...}", id: "putDocument",
+		summary: "Add or replace a virtual document; the body is its content.",
+		params: []param{
+			{name: "name", in: "path", description: "The document name, which may contain slashes."},
+			{name: "collection", in: "query", description: "The collection to put the document in; default is \"default\"."},
+			{name: "tag", in: "query", description: "An access tag for the document.", repeated: true},
+		},
+		request: rawBody{},
+		handler: (*Server).handleDocument,
+	},
+	{
+		method: "DELETE", pattern: "/v1/documents/{name...}", id: "forgetDocument",
+		summary: "Forget a document, leaving a tombstone.",
+		params:  []param{{name: "name", in: "path", description: "The document name, which may contain slashes."}},
+		handler: (*Server).handleForget,
+	},
+	{
+		method: "GET", pattern: "/v1/usage", id: "getUsage",
+		summary:  "Get the token's usage and quotas.",
+		response: Usage{},
+		handler:  (*Server).handleUsage,
+	},
+	{
+		method: "GET", pattern: "/v1/index", id: "getIndex",
+		summary:  "Get the re-indexing schedule and when each readable document was last indexed.",
+		response: IndexResponse{},
+		handler:  (*Server).handleIndex,
+	},
+	{
+		method: "POST", pattern: "/v1/admin/reload", id: "reload",
+		summary:  "Replace the index with the newly built export named in the server's reload config, without dropping queries; needs an admin token.",
+		response: ReloadResponse{},
+		handler:  (*Server).handleReload,
+	},
+	{
+		method: "GET", pattern: specPath, id: "getOpenAPI",
+		summary:  "Get this OpenAPI document.",
+		response: map[string]interface{}{},
+		public:   true,
+		handler:  (*Server).handleSpec,
+	},
+}
+
+func (s *Server) handleSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.spec)
+}
+
+// OpenAPI returns the OpenAPI 3.0 document for the API.
+func OpenAPI() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	errResp := map[string]interface{}{
+		"description": "An error.",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)},
+		},
+	}
+	paths := make(map[string]interface{})
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"summary":     rt.summary,
+			"operationId": rt.id,
+		}
+		var params []interface{}
+		for _, p := range rt.params {
+			var schema interface{} = map[string]interface{}{"type": "string"}
+			if p.repeated {
+				schema = map[string]interface{}{"type": "array", "items": schema}
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          p.in,
+				"description": p.description,
+				"required":    p.in == "path",
+				"schema":      schema,
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		switch rt.request.(type) {
+		case nil:
+		case rawBody:
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/octet-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+				},
+			}
+		default:
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(rt.request), schemas)},
+				},
+			}
+		}
+		responses := map[string]interface{}{"default": errResp}
+		if rt.response == nil {
+			responses["204"] = map[string]interface{}{"description": "Done."}
+		} else {
+			responses["200"] = map[string]interface{}{
+				"description": "OK.",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(rt.response), schemas)},
+				},
+			}
+		}
+		op["responses"] = responses
+		if rt.public {
+			op["security"] = []interface{}{}
+		}
+		path := strings.ReplaceAll(rt.pattern, "...}", "}")
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(rt.method)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "grokker",
+			"description": "Query and update a grokker knowledge base shared with 'grok serve'.",
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"bearer": []interface{}{}}},
+	}
+}
+
+// OpenAPIJSON returns the OpenAPI document as indented JSON.
+func OpenAPIJSON() (buf []byte, err error) {
+	buf, err = json.MarshalIndent(OpenAPI(), "", "  ")
+	if err == nil {
+		buf = append(buf, '\n')
+	}
+	return
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of values of type t as encoded by
+// encoding/json.  Named struct types are added to schemas and
+// referred to by name.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// reserve the name first, for recursive types
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice:
+		// a nil slice is encoded as null
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas), "nullable": true}
+	case t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	// interface{} and anything else can be any JSON value
+	return map[string]interface{}{}
+}
+
+// structSchema returns the schema of a struct type.  Fields without
+// omitempty are always present, so they are required.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		omitempty := false
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitempty = true
+				}
+			}
+		}
+		props[name] = schemaOf(f.Type, schemas)
+		if !omitempty {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,116 @@
+package serve
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Quotas are per token, set in the token's config entry:
+//
+//	serve:
+//	  tokens:
+//	    - name: ci
+//	      sha256: 60303a...
+//	      read: [docs]
+//	      requests_per_minute: 30
+//	      tokens_per_day: 200000
+//
+// Zero means no limit.  A client over quota gets 429 Too Many
+// Requests with a Retry-After header.  Usage is counted in memory, so
+// it starts over when the server restarts; the daily token count
+// starts over at midnight UTC.
+
+// quotas tracks each token's recent usage.
+type quotas struct {
+	mu      sync.Mutex
+	clients map[string]*clientUsage
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// clientUsage is the usage of one token.
+type clientUsage struct {
+	// times of the requests in the last minute
+	requests []time.Time
+	// the UTC date that tokens were counted on
+	day    string
+	tokens int
+}
+
+func newQuotas() *quotas {
+	return &quotas{clients: make(map[string]*clientUsage), now: time.Now}
+}
+
+// usage returns the token's usage record, creating it if needed, and
+// drops counts that have aged out.
+func (q *quotas) usage(tok *Token) *clientUsage {
+	u, ok := q.clients[tok.SHA256]
+	if !ok {
+		u = &clientUsage{}
+		q.clients[tok.SHA256] = u
+	}
+	now := q.now()
+	for len(u.requests) > 0 && now.Sub(u.requests[0]) >= time.Minute {
+		u.requests = u.requests[1:]
+	}
+	day := now.UTC().Format("2006-01-02")
+	if u.day != day {
+		u.day = day
+		u.tokens = 0
+	}
+	return u
+}
+
+// allow counts a request against the token's quota.  If the token is
+// over quota, it returns an error and how long the client should
+// wait before trying again, and the request isn't counted.
+func (q *quotas) allow(tok *Token) (retryAfter time.Duration, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.usage(tok)
+	now := q.now()
+	if tok.TokensPerDay > 0 && u.tokens >= tok.TokensPerDay {
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		retryAfter = midnight.Sub(now)
+		err = fmt.Errorf("token %q has used its %d model tokens for today", tok.Name, tok.TokensPerDay)
+		return
+	}
+	if tok.RequestsPerMinute > 0 && len(u.requests) >= tok.RequestsPerMinute {
+		retryAfter = u.requests[0].Add(time.Minute).Sub(now)
+		err = fmt.Errorf("token %q is limited to %d requests per minute", tok.Name, tok.RequestsPerMinute)
+		return
+	}
+	u.requests = append(u.requests, now)
+	return
+}
+
+// charge adds model tokens to the token's daily usage.
+func (q *quotas) charge(tok *Token, tokens int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.usage(tok).tokens += tokens
+}
+
+// Usage is the response to GET /v1/usage.
+type Usage struct {
+	Name              string
+	RequestsLastMin   int
+	RequestsPerMinute int
+	TokensToday       int
+	TokensPerDay      int
+}
+
+// report returns the token's current usage and limits.
+func (q *quotas) report(tok *Token) Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.usage(tok)
+	return Usage{
+		Name:              tok.Name,
+		RequestsLastMin:   len(u.requests),
+		RequestsPerMinute: tok.RequestsPerMinute,
+		TokensToday:       u.tokens,
+		TokensPerDay:      tok.TokensPerDay,
+	}
+}
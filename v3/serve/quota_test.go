@@ -0,0 +1,53 @@
+package serve
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestQuotas(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 58, 0, 0, time.UTC)
+	q := newQuotas()
+	q.now = func() time.Time { return now }
+	tok := &Token{Name: "ci", SHA256: "x", RequestsPerMinute: 2, TokensPerDay: 100}
+
+	for i := 0; i < 2; i++ {
+		_, err := q.allow(tok)
+		Tassert(t, err == nil, "request %d: %v", i, err)
+		now = now.Add(10 * time.Second)
+	}
+	wait, err := q.allow(tok)
+	Tassert(t, err != nil, "expected the third request to be limited")
+	Tassert(t, wait == 40*time.Second, "expected to wait 40s, got %v", wait)
+	now = now.Add(wait)
+	_, err = q.allow(tok)
+	Tassert(t, err == nil, "expected the oldest request to age out: %v", err)
+
+	q.charge(tok, 100)
+	wait, err = q.allow(tok)
+	Tassert(t, err != nil, "expected the token quota to be used up")
+	Tassert(t, wait > 0 && wait <= time.Minute, "expected to wait until midnight, got %v", wait)
+	u := q.report(tok)
+	Tassert(t, u.TokensToday == 100 && u.RequestsLastMin == 2, "got %+v", u)
+	// the day rolls over
+	now = now.Add(wait)
+	_, err = q.allow(tok)
+	Tassert(t, err == nil, "expected a new day's quota: %v", err)
+	Tassert(t, q.report(tok).TokensToday == 0, "got %+v", q.report(tok))
+}
+
+func TestServeQuota(t *testing.T) {
+	s, _, bob := testServer(t)
+	s.cfg.Tokens[1].RequestsPerMinute = 1
+	w := do(s, "GET", "/v1/collections", bob, "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+	w = do(s, "GET", "/v1/collections", bob, "")
+	Tassert(t, w.Code == http.StatusTooManyRequests, "expected 429, got %d", w.Code)
+	Tassert(t, w.Header().Get("Retry-After") != "", "expected a Retry-After header")
+	// checking usage doesn't count against the quota
+	w = do(s, "GET", "/v1/usage", bob, "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+}
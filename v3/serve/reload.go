@@ -0,0 +1,146 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// A running server can swap in a newly built index without dropping
+// a query.  CI builds the knowledge base elsewhere, exports it with
+// 'grok export', and copies the export to where the reload section
+// says; then it tells the server to reload, with SIGHUP or with
+// POST /v1/admin/reload using a token marked admin:
+//
+//	serve:
+//	  reload:
+//	    from: /srv/grok/incoming.grok
+//	    signed: true    # must be signed by a key in trusted_keys
+//	  tokens:
+//	    - name: ci
+//	      sha256: 60303a...
+//	      admin: true
+//
+// The export is read, checked, and set up while the old index keeps
+// answering; only then does the server wait for the requests in
+// flight to finish, put the new db file in place, and serve from the
+// new index; see core.Grokker.StageReplacement.  If anything fails,
+// the old index stays.  Each reload sends an index.reloaded webhook
+// event, whether it succeeded or not.
+
+// ReloadConfig is the "reload:" section of the serve config.
+type ReloadConfig struct {
+	// From is the path of the export that replaces the index.
+	From string `yaml:"from"`
+	// Signed requires the export to be signed, with the signature in
+	// From.minisig, by one of the trusted_keys in the config file.
+	Signed bool `yaml:"signed"`
+}
+
+// ReloadResponse is the response to POST /v1/admin/reload.
+type ReloadResponse struct {
+	Documents int `json:"documents"`
+	Chunks    int `json:"chunks"`
+	// TrustedComment is the trusted comment of the export's
+	// signature, if reload.signed is set.
+	TrustedComment string `json:"trusted_comment,omitempty"`
+}
+
+// errNoReload is returned when there is nothing to reload from.
+var errNoReload = errors.New("no reload source is configured; set reload.from in the serve config")
+
+// SetConfigure sets a func that is called with each reloaded Grokker
+// before it is served, e.g. to apply command-line settings.
+func (s *Server) SetConfigure(configure func(g *core.Grokker)) {
+	s.configure = configure
+}
+
+// Reload replaces the server's index with the export named in the
+// reload section of its config.
+func (s *Server) Reload() (resp *ReloadResponse, err error) {
+	return s.reload("")
+}
+
+// reload replaces the index; by is the name of the token that asked
+// for it, if any.
+func (s *Server) reload(by string) (resp *ReloadResponse, err error) {
+	rc := s.cfg.Reload
+	if rc == nil || rc.From == "" {
+		return nil, errNoReload
+	}
+	defer func() {
+		ev := Event{Type: EventIndexReloaded, Token: by}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		s.webhooks.send(ev)
+	}()
+	defer Return(&err)
+	// one reload at a time; s.g only changes here
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	var keys []core.PublicKey
+	if rc.Signed {
+		keys, err = core.TrustedKeys()
+		Ck(err)
+		if len(keys) == 0 {
+			err = fmt.Errorf("reload.signed is set, but there are no trusted_keys in %s", core.ConfigPath())
+			return
+		}
+	}
+	r, err := s.g.StageReplacement(rc.From, keys)
+	Ck(err)
+	if s.configure != nil {
+		s.configure(r.G)
+	}
+	s.mu.Lock()
+	err = r.Install()
+	if err == nil {
+		s.g = r.G
+	}
+	s.mu.Unlock()
+	if err != nil {
+		r.Discard()
+	}
+	Ck(err)
+	resp = &ReloadResponse{Documents: len(r.G.Documents), Chunks: len(r.G.Chunks), TrustedComment: r.TrustedComment}
+	log.Printf("reloaded %s from %s: %d documents, %d chunks", r.G.Root, rc.From, resp.Documents, resp.Chunks)
+	return
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	if !tok.Admin {
+		httpError(w, http.StatusForbidden, fmt.Errorf("token %q isn't an admin token", tok.Name))
+		return
+	}
+	resp, err := s.reload(tok.Name)
+	switch {
+	case errors.Is(err, errNoReload):
+		httpError(w, http.StatusConflict, err)
+	case err != nil:
+		httpError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, resp)
+	}
+}
+
+// reloadOnHangup reloads the index whenever the process gets
+// SIGHUP.  Windows has no SIGHUP; use the endpoint there.
+func (s *Server) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		_, err := s.reload("")
+		if err != nil {
+			log.Printf("reload: %v", err)
+		}
+	}
+}
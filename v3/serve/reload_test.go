@@ -0,0 +1,100 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+func TestReload(t *testing.T) {
+	t.Setenv("GROKKER_CONFIG_DIR", core.TmpTestDir())
+	g, err := core.Init(core.TmpTestDir(), "gpt-3.5-turbo")
+	Ck(err)
+	g.Documents = append(g.Documents, &core.Document{RelPath: "old.md", Collection: "docs"})
+	err = g.Save()
+	Ck(err)
+	build, err := core.Init(core.TmpTestDir(), "gpt-3.5-turbo")
+	Ck(err)
+	build.Documents = append(build.Documents,
+		&core.Document{RelPath: "new.md", Collection: "docs"},
+		&core.Document{RelPath: "main.go", Collection: "code"},
+	)
+	fn := filepath.Join(build.Root, "incoming.grok")
+	err = build.Export(fn, nil)
+	Ck(err)
+
+	hooks := &hookRecorder{}
+	hookSrv := httptest.NewServer(hooks)
+	defer hookSrv.Close()
+	ci, ciSum, err := NewToken()
+	Ck(err)
+	bob, bobSum, err := NewToken()
+	Ck(err)
+	cfg := &Config{
+		Tokens: []*Token{
+			{Name: "ci", SHA256: ciSum, Admin: true},
+			{Name: "bob", SHA256: bobSum, Read: []string{"*"}},
+		},
+		Webhooks: []*Webhook{{URL: hookSrv.URL, Events: []string{EventIndexReloaded}}},
+	}
+	s, err := NewServer(g, cfg, false)
+	Ck(err)
+	configured := 0
+	s.SetConfigure(func(g *core.Grokker) { configured++ })
+
+	w := do(s, "POST", "/v1/admin/reload", ci, "")
+	Tassert(t, w.Code == http.StatusConflict, "expected 409 without a reload source, got %d", w.Code)
+	cfg.Reload = &ReloadConfig{From: fn}
+	w = do(s, "POST", "/v1/admin/reload", bob, "")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+
+	// the reload waits for the request in flight, and serves the
+	// new index after it
+	s.mu.Lock()
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do(s, "POST", "/v1/admin/reload", ci, "") }()
+	select {
+	case <-done:
+		t.Fatal("expected the reload to wait for the request in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	Tassert(t, strings.Contains(strings.Join(s.g.ListDocuments(), ","), "old.md"), "expected the old index during the request")
+	s.mu.Unlock()
+	w = <-done
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d: %s", w.Code, w.Body.String())
+	var resp ReloadResponse
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	Ck(err)
+	Tassert(t, resp.Documents == 2, "got %+v", resp)
+	Tassert(t, configured == 1, "expected the new index to be configured, got %d", configured)
+	w = do(s, "GET", "/v1/collections", bob, "")
+	Tassert(t, strings.Contains(w.Body.String(), `"code"`), "expected the new index, got %s", w.Body.String())
+
+	// a failed reload keeps the index
+	cfg.Reload.Signed = true
+	w = do(s, "POST", "/v1/admin/reload", ci, "")
+	Tassert(t, w.Code == http.StatusInternalServerError && strings.Contains(w.Body.String(), "trusted_keys"), "got %d %s", w.Code, w.Body.String())
+	w = do(s, "GET", "/v1/collections", bob, "")
+	Tassert(t, strings.Contains(w.Body.String(), `"code"`), "expected the new index to stay, got %s", w.Body.String())
+
+	s.webhooks.pending.Wait()
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	Tassert(t, len(hooks.events) == 2, "expected 2 events, got %+v", hooks.events)
+	// events are sent in the background, so in any order
+	failed := 0
+	for _, ev := range hooks.events {
+		Tassert(t, ev.Type == EventIndexReloaded && ev.Token == "ci", "got %+v", ev)
+		if ev.Error != "" {
+			failed++
+		}
+	}
+	Tassert(t, failed == 1, "expected one failed reload, got %+v", hooks.events)
+}
@@ -0,0 +1,258 @@
+package serve
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// The scheduler keeps a long-running server's index fresh: every
+// interval, during the off-peak window if one is set, it adds new
+// files under the configured roots and re-embeds documents that
+// changed.
+//
+//	serve:
+//	  refresh:
+//	    interval: 1h
+//	    window: "01:00-05:00"      # local time; may wrap midnight
+//	    roots: [docs, src]         # relative to the repo root
+//	    include: ["*.md", "*.go"]  # new files to add; default all
+
+// RefreshConfig is the "refresh:" section of the serve config.
+type RefreshConfig struct {
+	// Interval is how often to re-index, as a Go duration.
+	Interval string `yaml:"interval"`
+	// Window limits re-indexing to the local times "HH:MM-HH:MM".
+	Window string `yaml:"window"`
+	// Roots are directories scanned for files that aren't in the
+	// knowledge base yet.  Without roots, only documents already
+	// in the knowledge base are refreshed.
+	Roots []string `yaml:"roots"`
+	// Include are file name patterns for new files.
+	Include []string `yaml:"include"`
+}
+
+// IndexStatus is the scheduler's state, as reported by GET /v1/index.
+type IndexStatus struct {
+	LastRun   *time.Time `json:",omitempty"`
+	NextRun   *time.Time `json:",omitempty"`
+	LastAdded int
+	LastError string `json:",omitempty"`
+}
+
+// scheduler re-indexes the knowledge base in the background.
+type scheduler struct {
+	cfg      *RefreshConfig
+	interval time.Duration
+	// the off-peak window, in minutes after midnight; start ==
+	// end means any time
+	start, end int
+	mu         sync.Mutex
+	status     IndexStatus
+}
+
+// newScheduler validates the config.
+func newScheduler(cfg *RefreshConfig) (sch *scheduler, err error) {
+	defer Return(&err)
+	sch = &scheduler{cfg: cfg}
+	sch.interval, err = time.ParseDuration(cfg.Interval)
+	Ck(err, "serve: refresh: interval")
+	Assert(sch.interval >= time.Minute, "serve: refresh: interval must be at least a minute")
+	if cfg.Window != "" {
+		from, to, ok := strings.Cut(cfg.Window, "-")
+		Assert(ok, "serve: refresh: window must look like 01:00-05:00, not %q", cfg.Window)
+		sch.start, err = parseClock(from)
+		Ck(err)
+		sch.end, err = parseClock(to)
+		Ck(err)
+	}
+	for _, pat := range cfg.Include {
+		_, err = filepath.Match(pat, "")
+		Ck(err, "serve: refresh: include %q", pat)
+	}
+	return
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (minutes int, err error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		err = fmt.Errorf("serve: refresh: window: %v", err)
+		return
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// wait returns how long from now until the window opens, or zero if
+// it is open.
+func (sch *scheduler) wait(now time.Time) time.Duration {
+	if sch.start == sch.end {
+		return 0
+	}
+	cur := now.Hour()*60 + now.Minute()
+	open := false
+	if sch.start < sch.end {
+		open = cur >= sch.start && cur < sch.end
+	} else {
+		// the window wraps midnight
+		open = cur >= sch.start || cur < sch.end
+	}
+	if open {
+		return 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(time.Duration(sch.start) * time.Minute)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next.Sub(now)
+}
+
+// run re-indexes every interval until the process exits.
+func (sch *scheduler) run(s *Server) {
+	next := time.Now().Add(sch.interval)
+	for {
+		next = next.Add(sch.wait(next))
+		sch.setNext(next)
+		time.Sleep(time.Until(next))
+		now := sch.runOnce(s)
+		next = now.Add(sch.interval)
+	}
+}
+
+// runOnce re-indexes, records the result in the status, tells the
+// webhooks, and returns when it finished.
+func (sch *scheduler) runOnce(s *Server) time.Time {
+	added, err := s.reindex(sch.cfg)
+	now := time.Now()
+	ev := Event{Type: EventReindexCompleted, Time: now, Added: added}
+	sch.mu.Lock()
+	sch.status.LastRun = &now
+	sch.status.LastAdded = len(added)
+	sch.status.LastError = ""
+	if err != nil {
+		sch.status.LastError = err.Error()
+		ev.Error = err.Error()
+		log.Printf("re-indexing failed: %v", err)
+	}
+	sch.mu.Unlock()
+	s.webhooks.send(ev)
+	return now
+}
+
+func (sch *scheduler) setNext(next time.Time) {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	sch.status.NextRun = &next
+}
+
+// report returns a copy of the scheduler's status.
+func (sch *scheduler) report() IndexStatus {
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	return sch.status
+}
+
+// reindex adds new files under the roots and refreshes the
+// embeddings of changed documents, then saves the db.  It returns the
+// paths of the documents it added.
+func (s *Server) reindex(cfg *RefreshConfig) (added []string, err error) {
+	defer Return(&err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths, err := newFiles(s.g, cfg)
+	Ck(err)
+	for _, path := range paths {
+		// one bad file, e.g. one the policy bans, shouldn't
+		// stop the rest
+		err = s.g.AddDocument(path)
+		if err != nil {
+			log.Printf("can't add %s: %v", path, err)
+			continue
+		}
+		relpath, err := filepath.Rel(s.g.Root, path)
+		Ck(err)
+		added = append(added, relpath)
+		coll, _ := s.g.DocumentCollection(relpath)
+		s.webhooks.send(Event{Type: EventDocumentAdded, Document: relpath, Collection: coll})
+	}
+	updated, err := s.g.UpdateEmbeddings()
+	Ck(err)
+	if len(added) > 0 || updated {
+		err = s.g.Save()
+		Ck(err)
+	}
+	log.Printf("re-indexed: %d new documents, updated %v", len(added), updated)
+	return
+}
+
+// newFiles returns the absolute paths of the files under the roots
+// that match the include patterns and aren't in the knowledge base.
+// Hidden files and directories, and files in .grokignore, are
+// skipped.
+func newFiles(g *core.Grokker, cfg *RefreshConfig) (paths []string, err error) {
+	defer Return(&err)
+	known := make(map[string]bool)
+	for _, relpath := range g.ListDocuments() {
+		known[filepath.Clean(relpath)] = true
+	}
+	for _, root := range cfg.Roots {
+		dir := filepath.Join(g.Root, root)
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), ".") && path != dir {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			relpath, err := filepath.Rel(g.Root, path)
+			if err != nil {
+				return err
+			}
+			if known[relpath] || !included(cfg.Include, d.Name()) {
+				return nil
+			}
+			ignored, err := g.Ignored(path)
+			if err != nil || ignored {
+				return err
+			}
+			known[relpath] = true
+			paths = append(paths, path)
+			return nil
+		})
+		if os.IsNotExist(err) {
+			log.Printf("refresh root %s does not exist", dir)
+			err = nil
+		}
+		Ck(err)
+	}
+	return
+}
+
+// included returns true if name matches one of the patterns, or if
+// there are no patterns.
+func included(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pat := range patterns {
+		if ok, _ := filepath.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
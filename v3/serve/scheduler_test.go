@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+func TestSchedulerWindow(t *testing.T) {
+	_, err := newScheduler(&RefreshConfig{Interval: "10s"})
+	Tassert(t, err != nil, "expected error for a short interval")
+	_, err = newScheduler(&RefreshConfig{Interval: "1h", Window: "1am-5am"})
+	Tassert(t, err != nil, "expected error for a bad window")
+
+	at := func(hhmm string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", "2024-05-01 "+hhmm, time.Local)
+		Ck(err)
+		return tm
+	}
+	sch, err := newScheduler(&RefreshConfig{Interval: "1h", Window: "01:00-05:00"})
+	Tassert(t, err == nil, "error creating scheduler: %v", err)
+	Tassert(t, sch.wait(at("02:00")) == 0, "window should be open")
+	Tassert(t, sch.wait(at("00:30")) == 30*time.Minute, "got %v", sch.wait(at("00:30")))
+	Tassert(t, sch.wait(at("05:00")) == 20*time.Hour, "got %v", sch.wait(at("05:00")))
+
+	sch, err = newScheduler(&RefreshConfig{Interval: "1h", Window: "22:00-02:00"})
+	Tassert(t, err == nil, "error creating scheduler: %v", err)
+	Tassert(t, sch.wait(at("23:00")) == 0, "window should be open")
+	Tassert(t, sch.wait(at("01:00")) == 0, "window should be open")
+	Tassert(t, sch.wait(at("12:00")) == 10*time.Hour, "got %v", sch.wait(at("12:00")))
+
+	sch, err = newScheduler(&RefreshConfig{Interval: "1h"})
+	Tassert(t, err == nil && sch.wait(at("12:00")) == 0, "no window should mean any time")
+}
+
+func TestNewFiles(t *testing.T) {
+	dir := core.TmpTestDir()
+	g, err := core.Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	for _, fn := range []string{"docs/a.md", "docs/b.md", "docs/c.txt", "docs/.hidden/d.md", "src/e.md", "docs/sub/f.md"} {
+		path := filepath.Join(dir, fn)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		Ck(err)
+		err = os.WriteFile(path, []byte("x"), 0644)
+		Ck(err)
+	}
+	g.Documents = append(g.Documents, &core.Document{RelPath: "docs/a.md"})
+	paths, err := newFiles(g, &RefreshConfig{Roots: []string{"docs", "missing"}, Include: []string{"*.md"}})
+	Tassert(t, err == nil, "error scanning: %v", err)
+	var rel []string
+	for _, path := range paths {
+		r, err := filepath.Rel(dir, path)
+		Ck(err)
+		rel = append(rel, r)
+	}
+	sort.Strings(rel)
+	Tassert(t, strings.Join(rel, ",") == "docs/b.md,docs/sub/f.md", "got %v", rel)
+}
+
+func TestServeIndex(t *testing.T) {
+	s, _, bob := testServer(t)
+	now := time.Now()
+	s.g.Documents[0].Indexed = &now
+	w := do(s, "GET", "/v1/index", bob, "")
+	var resp IndexResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	Tassert(t, err == nil, "error parsing %q: %v", w.Body.String(), err)
+	Tassert(t, resp.Schedule == nil, "expected no schedule")
+	Tassert(t, len(resp.Documents) == 1 && resp.Documents[0].Path == "a.md", "got %+v", resp.Documents)
+	Tassert(t, resp.Documents[0].Indexed.Equal(now), "got %v", resp.Documents[0].Indexed)
+}
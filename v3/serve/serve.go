@@ -0,0 +1,553 @@
+// Package serve shares a knowledge base with a team over HTTP.  Each
+// client authenticates with an API token, and each token can read
+// and write only the collections it is granted, and use only the
+// documents whose access tags it is cleared for; see Config.
+//
+// The API is JSON over HTTP:
+//
+//	POST /v1/q                 {"question": "...", "collections": [...], "tags": [...], "global": false,
+//	                            "labels": [...], "owners": [...], "follow_ups": false, "verify": false,
+//	                            "langs": [...], "paths": [...], "lang": "de",
+//	                            "buffers": {"path": "unsaved content", ...}}
+//	                           -> {"id": 12, "answer": "...", "sources": ["path:line", ...],
+//	                               "chunks": ["<chunk id>", ...], "follow_ups": ["...", ...],
+//	                               "claims": [...], "injections": [...]}
+//	POST /v1/sources           {"question": "...", "collections": [...], "tags": [...], "labels": [...],
+//	                            "owners": [...], "langs": [...], "paths": [...], "buffers": {...},
+//	                            "max_tokens": 0, "snippet_chars": 300}
+//	                           -> {"sources": [{"id": "...", "path": "...", "start_line": 1,
+//	                               "end_line": 9, "collection": "docs", "score": 0.83,
+//	                               "snippet": "..."}, ...], "injections": [...]};
+//	                           the passages an answer would use, ranked,
+//	                           without asking a chat model
+//	GET  /v1/chunks/{id}       -> {"id": "...", "path": "...", "line": 1, "collection": "docs",
+//	                               "tags": [...], "text": "..."}; the id may be
+//	                           abbreviated to 8 or more characters
+//	GET  /v1/collections       -> [{"Name": "docs", "Documents": 12}, ...]
+//	PUT  /v1/documents/{name}?collection=docs&tag=internal
+//	                           body is the document content; adds or
+//	                           replaces a virtual document; tag is
+//	                           repeatable
+//	DELETE /v1/documents/{name}
+//	                           forgets a document, leaving a tombstone;
+//	                           see 'grok purge'
+//	GET  /v1/usage             -> the caller's usage and quotas
+//	GET  /v1/index             -> the re-indexing schedule and when each
+//	                           readable document was last indexed
+//	GET  /v1/openapi.json      -> the OpenAPI document for the API; needs
+//	                           no token
+//	POST /v1/admin/reload      -> {"documents": 12, "chunks": 340}; swaps in
+//	                           a newly built index, for admin tokens; see
+//	                           reload.go
+//
+// The server can also tell other systems about documents added and
+// removed, re-indexing, and low-confidence answers; see webhooks.go.
+// One server can host several knowledge bases, routed by the
+// Grok-Tenant header or a /tenants/<name> path prefix; see
+// tenants.go.
+//
+// Clients send "Authorization: Bearer <token>".  Package
+// serve/client is a Go client for the API, and openapi.json in this
+// directory is the OpenAPI document, generated from the routes in
+// openapi.go with 'go generate'.
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// maxDocumentBytes limits the size of an uploaded document.
+const maxDocumentBytes = 32 << 20
+
+// Server serves a knowledge base.  A Grokker isn't safe for
+// concurrent use, so requests take turns.
+type Server struct {
+	g      *core.Grokker
+	cfg    *Config
+	noAuth bool
+	quotas *quotas
+	sched  *scheduler
+	mu     sync.Mutex
+	mux    *http.ServeMux
+	// spec is the OpenAPI document; see openapi.go.
+	spec     []byte
+	webhooks *webhooks
+	// tenant names the tenant the server is for, if any, and limit
+	// holds the tenant's own quotas; see tenants.go.
+	tenant string
+	limit  *Token
+	// configure sets up reloaded Grokkers, and reloading makes
+	// reloads take turns; see reload.go.
+	configure func(g *core.Grokker)
+	reloading sync.Mutex
+}
+
+// NewServer returns a server for g.  If noAuth is true, every request
+// has full access; otherwise cfg must list at least one token.
+func NewServer(g *core.Grokker, cfg *Config, noAuth bool) (s *Server, err error) {
+	if !noAuth && len(cfg.Tokens) == 0 {
+		err = fmt.Errorf("no API tokens are configured; add some under 'serve:' in %s, or run without authentication", core.ConfigPath())
+		return
+	}
+	s = &Server{g: g, cfg: cfg, noAuth: noAuth, quotas: newQuotas(), mux: http.NewServeMux()}
+	if cfg.Refresh != nil && cfg.Refresh.Interval != "" {
+		s.sched, err = newScheduler(cfg.Refresh)
+		if err != nil {
+			return
+		}
+	}
+	s.spec, err = OpenAPIJSON()
+	if err != nil {
+		return
+	}
+	s.webhooks, err = newWebhooks(cfg.Webhooks)
+	if err != nil {
+		return
+	}
+	for _, rt := range routes {
+		handler := rt.handler
+		s.mux.HandleFunc(rt.method+" "+rt.pattern, func(w http.ResponseWriter, r *http.Request) {
+			handler(s, w, r)
+		})
+	}
+	return
+}
+
+// ServeHTTP authenticates the request and dispatches it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && r.URL.Path == specPath {
+		// integrators need the spec before they have a token
+		s.handleSpec(w, r)
+		return
+	}
+	tok := s.authenticate(r)
+	if tok == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="grokker"`)
+		httpError(w, http.StatusUnauthorized, errors.New("missing or unknown API token"))
+		return
+	}
+	if s.tenant != "" {
+		log.Printf("%s: %s %s %s", s.tenant, tok.Name, r.Method, r.URL.Path)
+	} else {
+		log.Printf("%s %s %s", tok.Name, r.Method, r.URL.Path)
+	}
+	if r.URL.Path != "/v1/usage" {
+		retryAfter, err := s.quotas.allow(tok)
+		if err == nil && s.limit != nil {
+			retryAfter, err = s.quotas.allow(s.limit)
+		}
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			httpError(w, http.StatusTooManyRequests, err)
+			return
+		}
+	}
+	r = r.WithContext(withToken(r.Context(), tok))
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves on addr until the listener fails.
+func (s *Server) ListenAndServe(addr string) error {
+	log.Printf("serving %s on %s", s.g.Root, addr)
+	s.start()
+	return http.ListenAndServe(addr, s)
+}
+
+// start starts the server's background work.
+func (s *Server) start() {
+	if s.sched != nil {
+		go s.sched.run(s)
+	}
+	if s.cfg.Reload != nil && s.cfg.Reload.From != "" {
+		go s.reloadOnHangup()
+	}
+}
+
+// charge adds model tokens to the daily usage of tok and of the
+// server's tenant.
+func (s *Server) charge(tok *Token, tokens int) {
+	s.quotas.charge(tok, tokens)
+	if s.limit != nil {
+		s.quotas.charge(s.limit, tokens)
+	}
+}
+
+// QueryRequest is the body of POST /v1/q.
+type QueryRequest struct {
+	Question    string   `json:"question"`
+	Collections []string `json:"collections"`
+	Tags        []string `json:"tags"`
+	Labels      []string `json:"labels"`
+	Owners      []string `json:"owners"`
+	Global      bool     `json:"global"`
+	FollowUps   bool     `json:"follow_ups"`
+	Verify      bool     `json:"verify"`
+	// Langs limits the context to chunks in these languages, e.g.
+	// "go" or "markdown"; see core.Filter.
+	Langs []string `json:"langs"`
+	// Buffers are the unsaved contents of documents open in an
+	// editor, keyed by path relative to the root.  This query uses
+	// them in place of the documents on disk; see
+	// core.Grokker.SetBuffer.
+	Buffers map[string]string `json:"buffers"`
+	// Paths limits the context to documents under these paths,
+	// relative to the root; only the shards under them are
+	// loaded.  See core.Filter.
+	Paths []string `json:"paths"`
+	// Lang is the language to answer in, e.g. "de"; empty means the
+	// server's default.  See core.Grokker.SetLang.
+	Lang string `json:"lang"`
+}
+
+// QueryResponse is the response to POST /v1/q.
+type QueryResponse struct {
+	// ID is the question's number in the question log.
+	ID      int      `json:"id,omitempty"`
+	Answer  string   `json:"answer"`
+	Sources []string `json:"sources"`
+	// Chunks are the IDs of the chunks used as context; see GET
+	// /v1/chunks/{id}.
+	Chunks []string `json:"chunks"`
+	// FollowUps are suggested next questions, if requested.
+	FollowUps []string `json:"follow_ups,omitempty"`
+	// Claims are the verdicts on the answer's claims, if
+	// requested.
+	Claims []core.ClaimCheck `json:"claims,omitempty"`
+	// Injections are the context chunks that read like
+	// instructions to the model, if the pipeline checks for them.
+	Injections []core.InjectionFlag `json:"injections,omitempty"`
+}
+
+// SourcesRequest is the body of POST /v1/sources.  The filters are
+// those of QueryRequest.
+type SourcesRequest struct {
+	Question    string            `json:"question"`
+	Collections []string          `json:"collections"`
+	Tags        []string          `json:"tags"`
+	Labels      []string          `json:"labels"`
+	Owners      []string          `json:"owners"`
+	Langs       []string          `json:"langs"`
+	Paths       []string          `json:"paths"`
+	Buffers     map[string]string `json:"buffers"`
+	// MaxTokens limits the passages' total tokens; 0 means as many
+	// as an answer would use.
+	MaxTokens int `json:"max_tokens"`
+	// SnippetChars cuts each snippet to about this many
+	// characters; 0 means the whole passage.
+	SnippetChars int `json:"snippet_chars"`
+}
+
+// SourcesResponse is the response to POST /v1/sources.
+type SourcesResponse struct {
+	// Sources are the passages, most relevant first.
+	Sources []core.SourceSpan `json:"sources"`
+	// Injections are the passages that read like instructions to
+	// the model, if the pipeline checks for them.
+	Injections []core.InjectionFlag `json:"injections,omitempty"`
+}
+
+// setFilter limits the knowledge base to what the token may read and
+// the request asks for, until the returned function is called.
+func (s *Server) setFilter(tok *Token, f core.Filter) (reset func(), err error) {
+	f.Collections, err = tok.readable(f.Collections, s.g.Collections())
+	if err != nil {
+		return
+	}
+	f.Tags, err = tok.clearance(f.Tags)
+	if err != nil {
+		return
+	}
+	s.g.SetFilter(&f)
+	reset = func() { s.g.SetFilter(nil) }
+	return
+}
+
+// setBuffers stands in the unsaved contents of documents for those
+// on disk, until the returned function is called.
+func (s *Server) setBuffers(buffers map[string]string) (reset func(), err error) {
+	reset = s.g.ClearBuffers
+	for name, content := range buffers {
+		err = s.g.SetBuffer(filepath.Join(s.g.Root, filepath.FromSlash(name)), []byte(content))
+		if err != nil {
+			err = fmt.Errorf("buffer %s: %v", name, err)
+			return
+		}
+	}
+	return
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	var req QueryRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Question == "" {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("expected a JSON body with a question: %v", err))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reset, err := s.setFilter(tok, core.Filter{Collections: req.Collections, Tags: req.Tags, Labels: req.Labels, Owners: req.Owners, Langs: req.Langs, Paths: req.Paths})
+	if err != nil {
+		httpError(w, http.StatusForbidden, err)
+		return
+	}
+	defer reset()
+	if req.FollowUps {
+		s.g.SetFollowUps(core.DefaultFollowUps)
+		defer s.g.SetFollowUps(0)
+	}
+	if req.Verify {
+		s.g.SetCheckAnswers(true)
+		defer s.g.SetCheckAnswers(false)
+	}
+	if req.Lang != "" {
+		lang := s.g.Lang()
+		s.g.SetLang(req.Lang)
+		defer s.g.SetLang(lang)
+	}
+	used := s.g.TokensUsed()
+	unbuffer, err := s.setBuffers(req.Buffers)
+	defer unbuffer()
+	if err != nil {
+		s.charge(tok, s.g.TokensUsed()-used)
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	answer, err := s.g.Answer(req.Question, false, false, req.Global)
+	s.charge(tok, s.g.TokensUsed()-used)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if reason := s.g.LowConfidence(s.cfg.LowConfidence); reason != "" {
+		s.webhooks.send(Event{Type: EventLowConfidence, Token: tok.Name, QuestionID: s.g.LastQuestion(), Question: req.Question, Sources: s.g.Sources(), Reason: reason, TopSimilarity: s.g.TopSimilarity()})
+	}
+	writeJSON(w, QueryResponse{ID: s.g.LastQuestion(), Answer: answer, Sources: s.g.Sources(), Chunks: s.g.SourceIDs(), FollowUps: s.g.FollowUps(), Claims: s.g.ClaimChecks(), Injections: s.g.InjectionFlags()})
+}
+
+func (s *Server) handleSources(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	var req SourcesRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Question == "" {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("expected a JSON body with a question: %v", err))
+		return
+	}
+	if req.MaxTokens < 0 || req.SnippetChars < 0 {
+		httpError(w, http.StatusBadRequest, fmt.Errorf("max_tokens and snippet_chars can't be negative"))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reset, err := s.setFilter(tok, core.Filter{Collections: req.Collections, Tags: req.Tags, Labels: req.Labels, Owners: req.Owners, Langs: req.Langs, Paths: req.Paths})
+	if err != nil {
+		httpError(w, http.StatusForbidden, err)
+		return
+	}
+	defer reset()
+	used := s.g.TokensUsed()
+	unbuffer, err := s.setBuffers(req.Buffers)
+	defer unbuffer()
+	if err != nil {
+		s.charge(tok, s.g.TokensUsed()-used)
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	spans, err := s.g.Retrieve(req.Question, req.MaxTokens, req.SnippetChars)
+	s.charge(tok, s.g.TokensUsed()-used)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if spans == nil {
+		spans = []core.SourceSpan{}
+	}
+	writeJSON(w, SourcesResponse{Sources: spans, Injections: s.g.InjectionFlags()})
+}
+
+func (s *Server) handleCollections(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := []core.CollectionInfo{}
+	for _, info := range s.g.Collections() {
+		if tok.CanRead(info.Name) {
+			infos = append(infos, info)
+		}
+	}
+	writeJSON(w, infos)
+}
+
+func (s *Server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	name := r.PathValue("name")
+	coll := r.URL.Query().Get("collection")
+	if coll == "" {
+		coll = core.DefaultCollection
+	}
+	if !tok.CanWrite(coll) {
+		httpError(w, http.StatusForbidden, fmt.Errorf("token %q can't write collection %q", tok.Name, coll))
+		return
+	}
+	tags := r.URL.Query()["tag"]
+	if !tok.CanSee(tags) {
+		httpError(w, http.StatusForbidden, fmt.Errorf("token %q isn't cleared for tags %v", tok.Name, tags))
+		return
+	}
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDocumentBytes))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// replacing a document also takes it out of its old collection
+	old, exists := s.g.DocumentCollection(name)
+	if exists && !tok.CanWrite(old) {
+		httpError(w, http.StatusForbidden, fmt.Errorf("token %q can't write collection %q", tok.Name, old))
+		return
+	}
+	oldTags, _ := s.g.DocumentTags(name)
+	if !tok.CanSee(oldTags) {
+		httpError(w, http.StatusForbidden, fmt.Errorf("token %q isn't cleared for tags %v", tok.Name, oldTags))
+		return
+	}
+	used := s.g.TokensUsed()
+	err = s.putDocument(name, coll, tags, content)
+	s.charge(tok, s.g.TokensUsed()-used)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.webhooks.send(Event{Type: EventDocumentAdded, Token: tok.Name, Document: name, Collection: coll, Replaced: exists})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleForget(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	name := r.PathValue("name")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll, exists := s.g.DocumentCollection(name)
+	tags, _ := s.g.DocumentTags(name)
+	if !exists || !tok.CanRead(coll) || !tok.CanSee(tags) {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no document %q", name))
+		return
+	}
+	if !tok.CanWrite(coll) {
+		httpError(w, http.StatusForbidden, fmt.Errorf("token %q can't write collection %q", tok.Name, coll))
+		return
+	}
+	err := s.g.ForgetDocument(name)
+	if err == nil {
+		err = s.g.Save()
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.webhooks.send(Event{Type: EventDocumentRemoved, Token: tok.Name, Document: name, Collection: coll})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleChunk(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref, ok, err := s.g.ChunkByID(r.PathValue("id"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	// don't tell callers about chunks they can't read
+	if !ok || !tok.CanRead(ref.Collection) || !tok.CanSee(ref.Tags) {
+		httpError(w, http.StatusNotFound, fmt.Errorf("no chunk %q", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, ref)
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.quotas.report(token(r.Context())))
+}
+
+// IndexResponse is the response to GET /v1/index.
+type IndexResponse struct {
+	Schedule  *IndexStatus `json:",omitempty"`
+	Documents []DocIndex
+}
+
+// DocIndex is a document in the index response.
+type DocIndex struct {
+	Path       string
+	Collection string
+	Tags       []string   `json:",omitempty"`
+	Indexed    *time.Time `json:",omitempty"`
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	tok := token(r.Context())
+	resp := IndexResponse{Documents: []DocIndex{}}
+	if s.sched != nil {
+		status := s.sched.report()
+		resp.Schedule = &status
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range s.g.Documents {
+		coll := doc.Collection
+		if coll == "" {
+			coll = core.DefaultCollection
+		}
+		if tok.CanRead(coll) && tok.CanSee(doc.Tags) {
+			resp.Documents = append(resp.Documents, DocIndex{doc.RelPath, coll, doc.Tags, doc.Indexed})
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// putDocument adds or replaces a virtual document and saves the db.
+func (s *Server) putDocument(name, coll string, tags []string, content []byte) (err error) {
+	defer Return(&err)
+	err = s.g.PutDocument(name, content)
+	Ck(err)
+	err = s.g.SetCollection(name, coll)
+	Ck(err)
+	err = s.g.SetTags(name, tags)
+	Ck(err)
+	err = s.g.Save()
+	Ck(err)
+	return
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func httpError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+}
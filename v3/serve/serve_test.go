@@ -0,0 +1,185 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+func testServer(t *testing.T) (s *Server, alice, bob string) {
+	g, err := core.Init(core.TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	g.Documents = append(g.Documents,
+		&core.Document{RelPath: "a.md", Collection: "docs"},
+		&core.Document{RelPath: "b.go", Collection: "code"},
+		&core.Document{RelPath: "c.txt"},
+	)
+	alice, aliceSum, err := NewToken()
+	Tassert(t, err == nil, "error creating token: %v", err)
+	bob, bobSum, err := NewToken()
+	Tassert(t, err == nil, "error creating token: %v", err)
+	cfg := &Config{Tokens: []*Token{
+		{Name: "alice", SHA256: aliceSum, Read: []string{"*"}, Write: []string{"docs"}},
+		{Name: "bob", SHA256: bobSum, Read: []string{"docs"}},
+	}}
+	_, err = NewServer(g, &Config{}, false)
+	Tassert(t, err != nil, "expected error for a server without tokens")
+	s, err = NewServer(g, cfg, false)
+	Tassert(t, err == nil, "error creating server: %v", err)
+	return
+}
+
+func do(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeACL(t *testing.T) {
+	s, alice, bob := testServer(t)
+
+	w := do(s, "GET", "/v1/collections", "", "")
+	Tassert(t, w.Code == http.StatusUnauthorized, "expected 401, got %d", w.Code)
+	w = do(s, "GET", "/v1/collections", "grok_nope", "")
+	Tassert(t, w.Code == http.StatusUnauthorized, "expected 401, got %d", w.Code)
+
+	names := func(w *httptest.ResponseRecorder) (out []string) {
+		var infos []core.CollectionInfo
+		err := json.Unmarshal(w.Body.Bytes(), &infos)
+		Tassert(t, err == nil, "error parsing %q: %v", w.Body.String(), err)
+		for _, info := range infos {
+			out = append(out, info.Name)
+		}
+		return
+	}
+	w = do(s, "GET", "/v1/collections", alice, "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+	Tassert(t, strings.Join(names(w), ",") == "code,default,docs", "got %v", names(w))
+	w = do(s, "GET", "/v1/collections", bob, "")
+	Tassert(t, strings.Join(names(w), ",") == "docs", "got %v", names(w))
+
+	// writes need write access to the target collection, and to the
+	// collection of the document being replaced
+	w = do(s, "PUT", "/v1/documents/notes?collection=code", alice, "x")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	w = do(s, "PUT", "/v1/documents/b.go?collection=docs", alice, "x")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	w = do(s, "PUT", "/v1/documents/notes?collection=docs", bob, "x")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+
+	w = do(s, "POST", "/v1/q", bob, `{"question": "why?", "collections": ["code"]}`)
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	w = do(s, "POST", "/v1/sources", bob, `{"question": "why?", "collections": ["code"]}`)
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	w = do(s, "POST", "/v1/sources", bob, `{"question": "why?", "snippet_chars": -1}`)
+	Tassert(t, w.Code == http.StatusBadRequest, "expected 400, got %d", w.Code)
+	w = do(s, "POST", "/v1/q", bob, `{}`)
+	Tassert(t, w.Code == http.StatusBadRequest, "expected 400, got %d", w.Code)
+}
+
+func TestReadable(t *testing.T) {
+	all := []core.CollectionInfo{{Name: "code"}, {Name: "docs"}, {Name: "secret"}}
+	tok := &Token{Name: "t", Read: []string{"code", "docs"}}
+	colls, err := tok.readable(nil, all)
+	Tassert(t, err == nil && strings.Join(colls, ",") == "code,docs", "got %v %v", colls, err)
+	colls, err = tok.readable([]string{"docs"}, all)
+	Tassert(t, err == nil && strings.Join(colls, ",") == "docs", "got %v %v", colls, err)
+	_, err = tok.readable([]string{"secret"}, all)
+	Tassert(t, err != nil, "expected error reading secret")
+	colls, err = openToken.readable(nil, all)
+	Tassert(t, err == nil && colls == nil, "got %v %v", colls, err)
+	_, err = (&Token{Name: "none"}).readable(nil, all)
+	Tassert(t, err != nil, "expected error for a token with no access")
+}
+
+func TestClearance(t *testing.T) {
+	tok := &Token{Name: "t", Tags: []string{"public", "internal"}}
+	Tassert(t, tok.CanSee(nil), "untagged documents should be visible")
+	Tassert(t, tok.CanSee([]string{"Public"}), "expected clearance for public")
+	Tassert(t, !tok.CanSee([]string{"public", "secret"}), "expected no clearance for secret")
+	tags, err := tok.clearance(nil)
+	Tassert(t, err == nil && strings.Join(tags, ",") == "public,internal", "got %v %v", tags, err)
+	tags, err = tok.clearance([]string{"public"})
+	Tassert(t, err == nil && strings.Join(tags, ",") == "public", "got %v %v", tags, err)
+	_, err = tok.clearance([]string{"secret"})
+	Tassert(t, err != nil, "expected error for secret")
+	tags, err = openToken.clearance(nil)
+	Tassert(t, err == nil && tags == nil, "got %v %v", tags, err)
+	tags, err = (&Token{Name: "all", Tags: []string{"*"}}).clearance(nil)
+	Tassert(t, err == nil && tags == nil, "got %v %v", tags, err)
+
+	// writes need clearance for the new tags and the old ones
+	s, alice, _ := testServer(t)
+	s.cfg.Tokens[0].Tags = []string{"public"}
+	w := do(s, "PUT", "/v1/documents/notes?collection=docs&tag=secret", alice, "x")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	err = s.g.SetTags("a.md", []string{"secret"})
+	Tassert(t, err == nil, "error setting tags: %v", err)
+	w = do(s, "PUT", "/v1/documents/a.md?collection=docs", alice, "x")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	w = do(s, "POST", "/v1/q", alice, `{"question": "why?", "tags": ["secret"]}`)
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+}
+
+func TestChunkLookup(t *testing.T) {
+	s, alice, _ := testServer(t)
+	w := do(s, "GET", "/v1/chunks/abc", alice, "")
+	Tassert(t, w.Code == http.StatusBadRequest, "expected 400 for a short id, got %d", w.Code)
+	w = do(s, "GET", "/v1/chunks/0123456789abcdef", alice, "")
+	Tassert(t, w.Code == http.StatusNotFound, "expected 404, got %d", w.Code)
+}
+
+func TestForget(t *testing.T) {
+	s, alice, bob := testServer(t)
+	w := do(s, "DELETE", "/v1/documents/b.go", bob, "")
+	Tassert(t, w.Code == http.StatusNotFound, "expected 404, got %d", w.Code)
+	w = do(s, "DELETE", "/v1/documents/a.md", bob, "")
+	Tassert(t, w.Code == http.StatusForbidden, "expected 403, got %d", w.Code)
+	w = do(s, "DELETE", "/v1/documents/a.md", alice, "")
+	Tassert(t, w.Code == http.StatusNoContent, "expected 204, got %d: %s", w.Code, w.Body.String())
+	_, exists := s.g.DocumentCollection("a.md")
+	Tassert(t, !exists, "expected a.md to be forgotten")
+	Tassert(t, len(s.g.Tombstones) == 1, "expected a tombstone: %v", s.g.Tombstones)
+}
+
+func TestOpenAPI(t *testing.T) {
+	s, _, _ := testServer(t)
+
+	// the spec needs no token
+	w := do(s, "GET", "/v1/openapi.json", "", "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d: %s", w.Code, w.Body.String())
+	var spec struct {
+		Paths      map[string]map[string]interface{}
+		Components struct {
+			Schemas map[string]interface{}
+		}
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+	Tassert(t, err == nil, "error parsing spec: %v", err)
+	for _, rt := range routes {
+		path := strings.ReplaceAll(rt.pattern, "...}", "}")
+		_, ok := spec.Paths[path][strings.ToLower(rt.method)]
+		Tassert(t, ok, "spec is missing %s %s", rt.method, path)
+	}
+	for _, name := range []string{"QueryRequest", "QueryResponse", "SourcesRequest", "SourcesResponse", "SourceSpan", "ClaimCheck", "ChunkRef", "CollectionInfo", "Usage", "IndexResponse", "DocIndex", "IndexStatus", "ErrorResponse"} {
+		_, ok := spec.Components.Schemas[name]
+		Tassert(t, ok, "spec is missing schema %s", name)
+	}
+
+	// the checked-in document is up to date
+	buf, err := OpenAPIJSON()
+	Tassert(t, err == nil, "error generating spec: %v", err)
+	file, err := os.ReadFile("openapi.json")
+	Tassert(t, err == nil, "error reading openapi.json: %v", err)
+	Tassert(t, string(file) == string(buf), "openapi.json is out of date; run 'go generate ./serve'")
+}
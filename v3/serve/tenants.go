@@ -0,0 +1,221 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gofrs/flock"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// One server process can host the knowledge bases of several teams
+// or projects, each a tenant with its own database, tokens, quotas,
+// caches, re-indexing schedule, and webhooks:
+//
+//	serve:
+//	  tokens: [...]                 # for the knowledge base grok serve runs in
+//	  tenants:
+//	    - name: payments
+//	      db: /srv/payments/.grok   # or a name from 'grok db list'
+//	      cache_dir: /var/cache/grokker/payments
+//	      requests_per_minute: 120  # for all of the tenant's tokens
+//	      tokens_per_day: 2000000
+//	      tokens:
+//	        - name: payments-ci
+//	          sha256: 60303a...
+//	          read: ["*"]
+//	      refresh: {interval: 1h}
+//
+// A request names its tenant with the Grok-Tenant header or a
+// /tenants/<name> prefix on the path, e.g. POST /tenants/payments/v1/q;
+// a request that names neither goes to the knowledge base grok serve
+// runs in.  A tenant's tokens are only good for that tenant, and its
+// quotas are counted apart from every other tenant's.  Each tenant's
+// embedding and response caches are in cache_dir, by default
+// tenants/<name> in the cache directory, so no tenant can learn from
+// cache timing what another has asked; see core.Grokker.SetCacheDir.
+
+// TenantHeader is the request header that names the tenant.
+const TenantHeader = "Grok-Tenant"
+
+// tenantPrefix starts the paths of requests to a tenant.
+const tenantPrefix = "/tenants/"
+
+// tenantNameRe matches valid tenant names.
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Tenant is an entry in the "tenants:" section of the serve config.
+// Its other settings are those of the serve section, for the
+// tenant's knowledge base.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// DB is the path of the tenant's .grok file, or its name in
+	// the database registry; see 'grok db'.
+	DB string `yaml:"db"`
+	// CacheDir is where the tenant's caches are kept; empty means
+	// tenants/<name> in the cache directory.
+	CacheDir string `yaml:"cache_dir"`
+	// RequestsPerMinute and TokensPerDay are quotas for all of the
+	// tenant's tokens together; zero means no limit.
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	TokensPerDay      int `yaml:"tokens_per_day"`
+	Config            `yaml:",inline"`
+}
+
+// checkTenants verifies the tenants in the config file at path.
+func checkTenants(path string, tenants []*Tenant) (err error) {
+	seen := make(map[string]bool)
+	for _, t := range tenants {
+		switch {
+		case !tenantNameRe.MatchString(t.Name):
+			err = fmt.Errorf("%s: invalid tenant name %q; use letters, digits, '.', '_', and '-'", path, t.Name)
+		case seen[t.Name]:
+			err = fmt.Errorf("%s: tenant %q is listed twice", path, t.Name)
+		case t.DB == "":
+			err = fmt.Errorf("%s: tenant %q has no db", path, t.Name)
+		case len(t.Tenants) > 0:
+			err = fmt.Errorf("%s: tenant %q can't have tenants of its own", path, t.Name)
+		}
+		if err != nil {
+			return
+		}
+		seen[t.Name] = true
+		err = checkTokens(path, t.Tokens)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Router routes each request to the server of its tenant.
+type Router struct {
+	dflt    *Server
+	tenants map[string]*Server
+	locks   []*flock.Flock
+}
+
+// NewRouter returns a router that sends requests that name no tenant
+// to dflt, and loads the knowledge bases of the tenants to serve the
+// rest.  If configure isn't nil, it is called with each tenant's
+// Grokker before it is served, e.g. to apply command-line settings.
+// The tenants' databases stay locked until Close.
+func NewRouter(dflt *Server, tenants []*Tenant, noAuth bool, configure func(g *core.Grokker)) (rt *Router, err error) {
+	rt = &Router{dflt: dflt, tenants: make(map[string]*Server)}
+	defer func() {
+		if err != nil {
+			rt.Close()
+			rt = nil
+		}
+	}()
+	for _, t := range tenants {
+		var g *core.Grokker
+		var lock *flock.Flock
+		g, lock, err = loadTenant(t)
+		if err != nil {
+			err = fmt.Errorf("tenant %s: %v", t.Name, err)
+			return
+		}
+		rt.locks = append(rt.locks, lock)
+		cacheDir := t.CacheDir
+		if cacheDir == "" && core.CacheDir() != "" {
+			cacheDir = filepath.Join(core.CacheDir(), "tenants", t.Name)
+		}
+		setup := func(g *core.Grokker) {
+			if configure != nil {
+				configure(g)
+			}
+			g.SetCacheDir(cacheDir)
+		}
+		setup(g)
+		cfg := t.Config
+		var s *Server
+		s, err = NewServer(g, &cfg, noAuth)
+		if err != nil {
+			err = fmt.Errorf("tenant %s: %v", t.Name, err)
+			return
+		}
+		s.tenant = t.Name
+		s.configure = setup
+		if t.RequestsPerMinute > 0 || t.TokensPerDay > 0 {
+			s.limit = &Token{Name: "tenant " + t.Name, SHA256: "tenant:" + t.Name, RequestsPerMinute: t.RequestsPerMinute, TokensPerDay: t.TokensPerDay}
+		}
+		rt.tenants[t.Name] = s
+	}
+	return
+}
+
+// loadTenant loads and locks a tenant's database, and saves it if it
+// was migrated.
+func loadTenant(t *Tenant) (g *core.Grokker, lock *flock.Flock, err error) {
+	defer Return(&err)
+	var migrated bool
+	if strings.ContainsRune(t.DB, filepath.Separator) || strings.Contains(t.DB, "/") || strings.HasSuffix(t.DB, ".grok") {
+		g, migrated, _, _, lock, err = core.LoadFrom(t.DB, "", false)
+	} else {
+		g, migrated, _, _, lock, err = core.LoadDB(t.DB, "", false)
+	}
+	Ck(err)
+	if migrated {
+		err = g.Save()
+		if err != nil {
+			lock.Unlock()
+		}
+		Ck(err)
+	}
+	return
+}
+
+// Close unlocks the tenants' databases.
+func (rt *Router) Close() {
+	for _, lock := range rt.locks {
+		lock.Unlock()
+	}
+	rt.locks = nil
+}
+
+// ServeHTTP sends the request to the server of its tenant.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.Header.Get(TenantHeader)
+	if rest, ok := strings.CutPrefix(r.URL.Path, tenantPrefix); ok {
+		prefixed, path, _ := strings.Cut(rest, "/")
+		if name != "" && name != prefixed {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("the %s header names tenant %q, but the path names %q", TenantHeader, name, prefixed))
+			return
+		}
+		name = prefixed
+		r = r.Clone(r.Context())
+		r.URL.Path = "/" + path
+		if r.URL.RawPath != "" {
+			_, rawPath, _ := strings.Cut(strings.TrimPrefix(r.URL.RawPath, tenantPrefix), "/")
+			r.URL.RawPath = "/" + rawPath
+		}
+	}
+	s := rt.dflt
+	if name != "" {
+		s = rt.tenants[name]
+	}
+	if s == nil {
+		httpError(w, http.StatusNotFound, errors.New(Spf("no tenant %q", name)))
+		return
+	}
+	s.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves every tenant on addr until the listener
+// fails.
+func (rt *Router) ListenAndServe(addr string) error {
+	log.Printf("serving %s on %s", rt.dflt.g.Root, addr)
+	rt.dflt.start()
+	for name, s := range rt.tenants {
+		log.Printf("serving tenant %s, %s, on %s%s%s", name, s.g.Root, addr, tenantPrefix, name)
+		s.start()
+	}
+	return http.ListenAndServe(addr, rt)
+}
@@ -0,0 +1,86 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+func TestTenants(t *testing.T) {
+	t.Setenv("GROKKER_CACHE_DIR", core.TmpTestDir())
+	dflt, alice, _ := testServer(t)
+	dir := core.TmpTestDir()
+	g, err := core.Init(dir, "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	g.Documents = append(g.Documents, &core.Document{RelPath: "runbook.md", Collection: "ops"})
+	err = g.Save()
+	Tassert(t, err == nil, "error saving grokker: %v", err)
+	carol, carolSum, err := NewToken()
+	Tassert(t, err == nil, "error creating token: %v", err)
+	tenants := []*Tenant{{
+		Name:              "team",
+		DB:                filepath.Join(dir, ".grok"),
+		RequestsPerMinute: 2,
+		Config: Config{Tokens: []*Token{
+			{Name: "carol", SHA256: carolSum, Read: []string{"*"}},
+		}},
+	}}
+	err = checkTenants("config", tenants)
+	Tassert(t, err == nil, "error checking tenants: %v", err)
+	rt, err := NewRouter(dflt, tenants, false, nil)
+	Tassert(t, err == nil, "error creating router: %v", err)
+	defer rt.Close()
+
+	get := func(path, token, tenant string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if tenant != "" {
+			r.Header.Set(TenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	w := get("/v1/collections", carol, "team")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+	Tassert(t, strings.Contains(w.Body.String(), `"ops"`), "expected the tenant's collections, got %s", w.Body.String())
+	w = get("/tenants/team/v1/collections", carol, "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+	Tassert(t, strings.Contains(w.Body.String(), `"ops"`), "expected the tenant's collections, got %s", w.Body.String())
+
+	// tokens are only good for their own tenant
+	w = get("/v1/collections", carol, "")
+	Tassert(t, w.Code == http.StatusUnauthorized, "expected 401, got %d", w.Code)
+	w = get("/v1/collections", alice, "team")
+	Tassert(t, w.Code == http.StatusUnauthorized, "expected 401, got %d", w.Code)
+	w = get("/v1/collections", alice, "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+	Tassert(t, !strings.Contains(w.Body.String(), `"ops"`), "got the tenant's collections: %s", w.Body.String())
+
+	w = get("/tenants/nope/v1/collections", carol, "")
+	Tassert(t, w.Code == http.StatusNotFound, "expected 404, got %d", w.Code)
+	w = get("/tenants/team/v1/collections", carol, "other")
+	Tassert(t, w.Code == http.StatusBadRequest, "expected 400, got %d", w.Code)
+
+	// the tenant's quota covers all of its tokens, and no one else
+	w = get("/v1/collections", carol, "team")
+	Tassert(t, w.Code == http.StatusTooManyRequests, "expected 429, got %d", w.Code)
+	w = get("/v1/collections", alice, "")
+	Tassert(t, w.Code == http.StatusOK, "expected 200, got %d", w.Code)
+
+	for _, bad := range [][]*Tenant{
+		{{Name: "../x", DB: "x"}},
+		{{Name: "a", DB: "x"}, {Name: "a", DB: "y"}},
+		{{Name: "a"}},
+		{{Name: "a", DB: "x", Config: Config{Tenants: []*Tenant{{Name: "b", DB: "y"}}}}},
+		{{Name: "a", DB: "x", Config: Config{Tokens: []*Token{{Name: "t", SHA256: "short"}}}}},
+	} {
+		err = checkTenants("config", bad)
+		Tassert(t, err != nil, "expected an error for %+v", bad[0])
+	}
+}
@@ -0,0 +1,205 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// Webhooks tell other systems what the server did, e.g. to post in a
+// team channel when the shared index is refreshed.  Each event is
+// POSTed as JSON to every webhook that subscribes to it, in the
+// background, with a few retries.  If the webhook has a secret, the
+// body is signed with it, and the X-Grokker-Signature header is
+// "sha256=" and the hex HMAC-SHA256 of the body, as GitHub does it.
+//
+//	serve:
+//	  webhooks:
+//	    - url: https://hooks.example.com/grokker
+//	      events: [reindex.completed, answer.low_confidence]  # default all
+//	      secret_env: GROK_WEBHOOK_SECRET
+//	  low_confidence: 0.3
+//
+// The secret is read from the named environment variable, so the
+// config file doesn't hold credentials.  An answer is low-confidence
+// if it has no sources, if it was verified and some of its claims
+// aren't supported, or if no chunk is at least low_confidence similar
+// to the question; see core.Grokker.LowConfidence.  Good thresholds
+// depend on the embedding model, so the last test is off unless
+// low_confidence is set.
+
+// The events webhooks can subscribe to.
+const (
+	// EventDocumentAdded is sent when a document is added or
+	// replaced, through the API or by the refresh schedule.
+	EventDocumentAdded = "document.added"
+	// EventDocumentRemoved is sent when a document is forgotten.
+	EventDocumentRemoved = "document.removed"
+	// EventReindexCompleted is sent after each scheduled
+	// re-indexing, whether it succeeded or not.
+	EventReindexCompleted = "reindex.completed"
+	// EventLowConfidence is sent when an answer is low-confidence.
+	EventLowConfidence = "answer.low_confidence"
+	// EventIndexReloaded is sent after each reload of the index,
+	// whether it succeeded or not; see reload.go.
+	EventIndexReloaded = "index.reloaded"
+)
+
+var events = []string{EventDocumentAdded, EventDocumentRemoved, EventReindexCompleted, EventLowConfidence, EventIndexReloaded}
+
+// webhookAttempts is how many times an event is sent before giving
+// up, and webhookBackoff is the wait before the first retry, which
+// doubles for each retry after it.
+var (
+	webhookAttempts = 3
+	webhookBackoff  = 2 * time.Second
+)
+
+// Webhook is an entry in the "webhooks:" section of the serve config.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Events are the events to send; empty means all of them.
+	Events []string `yaml:"events"`
+	// SecretEnv is the environment variable that holds the secret
+	// to sign the events with, if any.
+	SecretEnv string `yaml:"secret_env"`
+	secret    []byte
+}
+
+// Event is the body of a webhook request.  Fields that don't apply
+// to the event are omitted.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Token is the name of the API token that caused the event.
+	Token      string `json:"token,omitempty"`
+	Document   string `json:"document,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	// Replaced is true if an added document replaced one with the
+	// same name.
+	Replaced bool `json:"replaced,omitempty"`
+	// Added are the documents a re-indexing added.
+	Added []string `json:"added,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// QuestionID is the question's number in the question log.
+	QuestionID int      `json:"question_id,omitempty"`
+	Question   string   `json:"question,omitempty"`
+	Sources    []string `json:"sources,omitempty"`
+	// Reason says why an answer is low-confidence.
+	Reason        string  `json:"reason,omitempty"`
+	TopSimilarity float64 `json:"top_similarity,omitempty"`
+}
+
+// webhooks sends events to the configured webhooks.
+type webhooks struct {
+	hooks  []*Webhook
+	client *http.Client
+	// pending tracks events being sent, so tests can wait for
+	// them
+	pending sync.WaitGroup
+}
+
+// newWebhooks checks the configured webhooks and reads their
+// secrets.
+func newWebhooks(hooks []*Webhook) (wh *webhooks, err error) {
+	for _, hook := range hooks {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %q: expected an http or https URL", hook.URL)
+		}
+		for _, ev := range hook.Events {
+			if !util.StringInSlice(ev, events) {
+				return nil, fmt.Errorf("webhook %s: unknown event %q; expected one of %v", hook.URL, ev, events)
+			}
+		}
+		if hook.SecretEnv != "" {
+			secret := os.Getenv(hook.SecretEnv)
+			if secret == "" {
+				return nil, fmt.Errorf("webhook %s: %s is not set", hook.URL, hook.SecretEnv)
+			}
+			hook.secret = []byte(secret)
+		}
+	}
+	wh = &webhooks{hooks: hooks, client: &http.Client{Timeout: 10 * time.Second}}
+	return
+}
+
+// send sends an event, in the background, to the webhooks that
+// subscribe to it.
+func (wh *webhooks) send(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("can't encode %s event: %v", ev.Type, err)
+		return
+	}
+	for _, hook := range wh.hooks {
+		if len(hook.Events) > 0 && !util.StringInSlice(ev.Type, hook.Events) {
+			continue
+		}
+		wh.pending.Add(1)
+		go func(hook *Webhook) {
+			defer wh.pending.Done()
+			wh.deliver(hook, ev.Type, body)
+		}(hook)
+	}
+}
+
+// deliver posts an event to a webhook, retrying on network errors
+// and server errors.
+func (wh *webhooks) deliver(hook *Webhook, typ string, body []byte) {
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := wh.post(hook, typ, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			log.Printf("giving up on %s event for %s: %v", typ, hook.URL, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes one attempt to deliver an event.  Client errors aren't
+// retried, since they will happen again.
+func (wh *webhooks) post(hook *Webhook, typ string, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Grokker-Event", typ)
+	if hook.secret != nil {
+		mac := hmac.New(sha256.New, hook.secret)
+		mac.Write(body)
+		req.Header.Set("X-Grokker-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("%s", resp.Status)
+	case resp.StatusCode >= 300:
+		log.Printf("webhook %s refused %s event: %s", hook.URL, typ, resp.Status)
+	}
+	return nil
+}
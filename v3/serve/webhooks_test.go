@@ -0,0 +1,116 @@
+package serve
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+// hookRecorder is a webhook receiver that records the events it gets
+// and fails the first fails requests.
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []Event
+	sigs   []string
+	fails  int
+	status int
+}
+
+func (h *hookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.fails > 0 {
+		h.fails--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var ev Event
+	json.Unmarshal(body, &ev)
+	h.events = append(h.events, ev)
+	h.sigs = append(h.sigs, r.Header.Get("X-Grokker-Signature"))
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if h.sigs[len(h.sigs)-1] != "" && h.sigs[len(h.sigs)-1] != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		h.sigs[len(h.sigs)-1] = "bad"
+	}
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	backoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = backoff }()
+	all := &hookRecorder{fails: 1}
+	allSrv := httptest.NewServer(all)
+	defer allSrv.Close()
+	some := &hookRecorder{}
+	someSrv := httptest.NewServer(some)
+	defer someSrv.Close()
+	t.Setenv("GROK_TEST_WEBHOOK_SECRET", "s3cret")
+
+	g, err := core.Init(core.TmpTestDir(), "gpt-3.5-turbo")
+	Tassert(t, err == nil, "error creating grokker: %v", err)
+	g.Documents = append(g.Documents, &core.Document{RelPath: "a.md", Collection: "docs"})
+	alice, aliceSum, err := NewToken()
+	Tassert(t, err == nil, "error creating token: %v", err)
+	cfg := &Config{
+		Tokens: []*Token{{Name: "alice", SHA256: aliceSum, Read: []string{"*"}, Write: []string{"*"}}},
+		Webhooks: []*Webhook{
+			{URL: allSrv.URL, SecretEnv: "GROK_TEST_WEBHOOK_SECRET"},
+			{URL: someSrv.URL, Events: []string{EventReindexCompleted}},
+		},
+		Refresh: &RefreshConfig{Interval: "1h"},
+	}
+	s, err := NewServer(g, cfg, false)
+	Tassert(t, err == nil, "error creating server: %v", err)
+
+	w := do(s, "DELETE", "/v1/documents/a.md", alice, "")
+	Tassert(t, w.Code == http.StatusNoContent, "expected 204, got %d: %s", w.Code, w.Body.String())
+	// deliveries are concurrent, so wait to keep the order
+	s.webhooks.pending.Wait()
+	s.sched.runOnce(s)
+	s.webhooks.pending.Wait()
+
+	// the first webhook gets every event, signed, after a retry
+	Tassert(t, len(all.events) == 2, "expected 2 events, got %v", all.events)
+	Tassert(t, all.events[0].Type == EventDocumentRemoved && all.events[0].Document == "a.md" && all.events[0].Token == "alice", "unexpected event %+v", all.events[0])
+	Tassert(t, all.events[1].Type == EventReindexCompleted, "unexpected event %+v", all.events[1])
+	for _, sig := range all.sigs {
+		Tassert(t, sig != "" && sig != "bad", "expected a good signature, got %q", sig)
+	}
+	// the second gets only what it subscribes to, unsigned
+	Tassert(t, len(some.events) == 1 && some.events[0].Type == EventReindexCompleted, "unexpected events %v", some.events)
+	Tassert(t, some.sigs[0] == "", "expected no signature, got %q", some.sigs[0])
+
+	// client errors aren't retried
+	some.status = http.StatusBadRequest
+	s.webhooks.send(Event{Type: EventReindexCompleted})
+	s.webhooks.pending.Wait()
+	Tassert(t, len(some.events) == 2, "expected 1 more event, got %d", len(some.events)-1)
+
+	// an answer without sources is low-confidence
+	Tassert(t, s.g.LowConfidence(s.cfg.LowConfidence) == "no sources", "expected no sources, got %q", s.g.LowConfidence(s.cfg.LowConfidence))
+
+	bad := [][]*Webhook{
+		{{URL: "ftp://example.com"}},
+		{{URL: "https://example.com", Events: []string{"document.eaten"}}},
+		{{URL: "https://example.com", SecretEnv: "GROK_TEST_NO_SUCH_VAR"}},
+	}
+	for _, hooks := range bad {
+		_, err = newWebhooks(hooks)
+		Tassert(t, err != nil, "expected an error for %+v", hooks[0])
+	}
+}
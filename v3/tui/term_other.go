@@ -0,0 +1,25 @@
+//go:build !windows
+
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// termSize returns the size of the terminal f, if it is one.
+func termSize(f *os.File) (width, height int, ok bool) {
+	if f == nil {
+		return
+	}
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return
+	}
+	return int(ws.Col), int(ws.Row), true
+}
+
+// enableEscapes lets f interpret ANSI escape sequences; Unix
+// terminals always do.
+func enableEscapes(f *os.File) {}
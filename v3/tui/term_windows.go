@@ -0,0 +1,31 @@
+//go:build windows
+
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// termSize returns the size of the console window f, if it is one.
+func termSize(f *os.File) (width, height int, ok bool) {
+	if f == nil {
+		return
+	}
+	var info windows.ConsoleScreenBufferInfo
+	err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info)
+	if err != nil {
+		return
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, true
+}
+
+// enableEscapes lets the console f interpret ANSI escape sequences.
+func enableEscapes(f *os.File) {
+	var mode uint32
+	h := windows.Handle(f.Fd())
+	if windows.GetConsoleMode(h, &mode) == nil {
+		windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+}
@@ -0,0 +1,433 @@
+// Package tui is a terminal UI for browsing what the knowledge base
+// retrieves for a question, started with 'grok tui':
+//
+//	query> how do I deploy?
+//	── sources (3) ─────────────────────────
+//	> 0.83  docs/deploy.md:12-30  [docs]
+//	  0.71  Makefile:40-52
+//	  0.64  cmd/deploy/main.go:1-38  [code]
+//	── answer ──────────────────────────────
+//	Run make deploy, which ...
+//
+// Type a question and press Enter to see the ranked passages, each
+// with its score and location, and the answer, streamed as it
+// arrives.  Tab moves between the question and the sources; with the
+// sources selected, Up and Down pick a passage, p shows its text in
+// place of the answer, and Enter or o opens its file in
+// $GROKKER_EDITOR at the passage's first line.  PgUp and PgDn scroll
+// the lower pane, and Esc or Ctrl-C quits.
+//
+// The editor is run as "$GROKKER_EDITOR +LINE FILE", which vi, vim,
+// nano, emacs, and most other terminal editors understand.
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/eiannone/keyboard"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+	"github.com/stevegt/grokker/v3/util"
+)
+
+// errCanceled stops an answer that is no longer wanted.
+var errCanceled = errors.New("canceled")
+
+// focus is the part of the screen keys go to.
+type focus int
+
+const (
+	focusQuery focus = iota
+	focusSources
+)
+
+// action is what the loop in Run does after a key.
+type action int
+
+const (
+	actNone action = iota
+	actSearch
+	actOpen
+	actQuit
+)
+
+// model is the state of the screen.  It knows nothing of the
+// terminal or the knowledge base, so it can be tested alone.
+type model struct {
+	query   string
+	focus   focus
+	sources []core.SourceSpan
+	sel     int
+	answer  string
+	// preview shows the selected passage in place of the answer
+	preview bool
+	// scroll is the first line of the lower pane shown, and page
+	// the number of lines it had when last drawn
+	scroll int
+	page   int
+	status string
+	busy   bool
+}
+
+// handle updates the model for a key and returns what to do next.
+func (m *model) handle(ch rune, key keyboard.Key) action {
+	switch key {
+	case keyboard.KeyCtrlC, keyboard.KeyEsc:
+		return actQuit
+	case keyboard.KeyTab:
+		if m.focus == focusQuery && len(m.sources) > 0 {
+			m.focus = focusSources
+		} else {
+			m.focus = focusQuery
+		}
+		return actNone
+	case keyboard.KeyArrowUp:
+		m.selectSource(m.sel - 1)
+		return actNone
+	case keyboard.KeyArrowDown:
+		m.selectSource(m.sel + 1)
+		return actNone
+	case keyboard.KeyPgup:
+		m.scroll = max(0, m.scroll-max(1, m.page-1))
+		return actNone
+	case keyboard.KeyPgdn:
+		m.scroll += max(1, m.page-1)
+		return actNone
+	}
+	if m.focus == focusSources {
+		switch {
+		case key == keyboard.KeyEnter || ch == 'o':
+			if len(m.sources) > 0 {
+				return actOpen
+			}
+		case ch == 'k':
+			m.selectSource(m.sel - 1)
+		case ch == 'j':
+			m.selectSource(m.sel + 1)
+		case ch == 'p':
+			m.preview = !m.preview
+			m.scroll = 0
+		case ch == 'q':
+			return actQuit
+		case ch == '/':
+			m.focus = focusQuery
+		}
+		return actNone
+	}
+	switch {
+	case key == keyboard.KeyEnter:
+		if strings.TrimSpace(m.query) == "" {
+			return actNone
+		}
+		if m.busy {
+			m.status = "still answering; wait for the answer, or Esc to quit"
+			return actNone
+		}
+		return actSearch
+	case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+		if r := []rune(m.query); len(r) > 0 {
+			m.query = string(r[:len(r)-1])
+		}
+	case key == keyboard.KeyCtrlU:
+		m.query = ""
+	case key == keyboard.KeySpace:
+		m.query += " "
+	case ch != 0:
+		m.query += string(ch)
+	}
+	return actNone
+}
+
+// selectSource selects the i'th source, if there is one.
+func (m *model) selectSource(i int) {
+	if i < 0 || i >= len(m.sources) || i == m.sel {
+		return
+	}
+	m.sel = i
+	if m.preview {
+		m.scroll = 0
+	}
+}
+
+// lower returns the text of the lower pane: the answer, or the
+// selected passage.
+func (m *model) lower() (title, text string) {
+	if m.preview && len(m.sources) > 0 {
+		s := m.sources[m.sel]
+		return Spf("%s:%d-%d", s.Path, s.StartLine, s.EndLine), s.Snippet
+	}
+	text = m.answer
+	if text == "" && m.busy {
+		text = "answering…"
+	}
+	return "answer", text
+}
+
+// view returns the screen's lines for a terminal of the given size.
+func (m *model) view(width, height int) (lines []string) {
+	width = max(width, 20)
+	height = max(height, 8)
+	cursor := ""
+	if m.focus == focusQuery {
+		cursor = "_"
+	}
+	lines = append(lines, cut("query> "+m.query+cursor, width))
+	lines = append(lines, rule(Spf("sources (%d)", len(m.sources)), width))
+
+	// two lines for the rules and one for the status line
+	avail := height - 4
+	rows := min(max(len(m.sources), 1), max(avail*2/5, 1))
+	first := min(max(0, m.sel-rows+1), max(0, len(m.sources)-rows))
+	for i := first; i < first+rows; i++ {
+		if i >= len(m.sources) {
+			lines = append(lines, "")
+			continue
+		}
+		s := m.sources[i]
+		row := Spf("  %.2f  %s:%d-%d", s.Score, s.Path, s.StartLine, s.EndLine)
+		if s.Collection != "" && s.Collection != "default" {
+			row += Spf("  [%s]", s.Collection)
+		}
+		if i == m.sel {
+			row = ">" + row[1:]
+			if m.focus == focusSources {
+				lines = append(lines, reverse(cut(row, width)))
+				continue
+			}
+		}
+		lines = append(lines, cut(row, width))
+	}
+
+	title, text := m.lower()
+	lines = append(lines, rule(title, width))
+	m.page = avail - rows
+	wrapped := wrap(text, width)
+	m.scroll = max(0, min(m.scroll, len(wrapped)-m.page))
+	for i := m.scroll; i < m.scroll+m.page; i++ {
+		if i < len(wrapped) {
+			lines = append(lines, wrapped[i])
+		} else {
+			lines = append(lines, "")
+		}
+	}
+
+	status := m.status
+	if status == "" {
+		if m.focus == focusQuery {
+			status = "Enter: ask  Tab: sources  PgUp/PgDn: scroll  Esc: quit"
+		} else {
+			status = "↑/↓: select  Enter: open  p: passage/answer  Tab: question  Esc: quit"
+		}
+	}
+	lines = append(lines, reverse(cut(status, width)))
+	return
+}
+
+// cut returns s cut to width characters.
+func cut(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// rule returns a horizontal rule titled title.
+func rule(title string, width int) string {
+	s := "── " + title + " "
+	return cut(s+strings.Repeat("─", max(0, width-len([]rune(s)))), width)
+}
+
+// reverse shows s in reverse video.
+func reverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
+
+// wrap splits text into lines of at most width characters, breaking
+// at spaces where it can.
+func wrap(text string, width int) (lines []string) {
+	text = strings.ReplaceAll(text, "\t", "    ")
+	for _, para := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		r := []rune(strings.TrimRight(para, " \r"))
+		for len(r) > width {
+			n := width
+			for i := width; i > width/2; i-- {
+				if r[i] == ' ' {
+					n = i
+					break
+				}
+			}
+			lines = append(lines, string(r[:n]))
+			r = r[n:]
+			if len(r) > 0 && r[0] == ' ' {
+				r = r[1:]
+			}
+		}
+		lines = append(lines, string(r))
+	}
+	if text == "" {
+		lines = nil
+	}
+	return
+}
+
+// answerEvent is a piece of a streamed answer, or its end.
+type answerEvent struct {
+	text string
+	done bool
+	err  error
+}
+
+// Run runs the terminal UI on out, which should be a terminal, until
+// the user quits.  If query isn't empty, it is asked first.  global
+// is as for core.Grokker.Answer.
+func Run(g *core.Grokker, out io.Writer, query string, global bool) (err error) {
+	defer Return(&err)
+	f, _ := out.(*os.File)
+	if f != nil {
+		enableEscapes(f)
+	}
+	keys, err := keyboard.GetKeys(10)
+	Ck(err)
+	defer keyboard.Close()
+	// use the alternate screen, so the shell's screen comes back
+	// when we quit
+	Fpf(out, "\x1b[?1049h\x1b[?25l")
+	defer Fpf(out, "\x1b[?25h\x1b[?1049l")
+
+	m := &model{query: query}
+	answers := make(chan answerEvent)
+	var canceled chan struct{}
+	draw := func() {
+		width, height, ok := termSize(f)
+		if !ok {
+			width, height = 80, 24
+		}
+		Fpf(out, "\x1b[H\x1b[2J%s", strings.Join(m.view(width, height), "\r\n"))
+	}
+	search := func() {
+		m.status = "searching…"
+		m.answer = ""
+		m.preview = false
+		m.scroll = 0
+		draw()
+		var rerr error
+		m.sources, rerr = g.Retrieve(m.query, 0, 0)
+		m.sel = 0
+		if rerr != nil {
+			m.status = Spf("error: %v", rerr)
+			return
+		}
+		m.status = ""
+		m.busy = true
+		canceled = make(chan struct{})
+		go func(question string, canceled chan struct{}) {
+			_, err := g.AnswerStream(question, false, false, global, func(text string) error {
+				select {
+				case answers <- answerEvent{text: text}:
+					return nil
+				case <-canceled:
+					return errCanceled
+				}
+			})
+			answers <- answerEvent{done: true, err: err}
+		}(m.query, canceled)
+	}
+	if strings.TrimSpace(query) != "" {
+		search()
+	}
+	for {
+		draw()
+		select {
+		case ev, ok := <-keys:
+			if !ok {
+				return
+			}
+			if ev.Err != nil {
+				err = ev.Err
+				return
+			}
+			m.status = ""
+			switch m.handle(ev.Rune, ev.Key) {
+			case actQuit:
+				if m.busy {
+					close(canceled)
+					for ev := range answers {
+						if ev.done {
+							break
+						}
+					}
+				}
+				return
+			case actSearch:
+				search()
+			case actOpen:
+				keys, err = m.open(g, out)
+				Ck(err)
+			}
+		case ev := <-answers:
+			if !ev.done {
+				m.answer += ev.text
+				continue
+			}
+			m.busy = false
+			if ev.err != nil {
+				m.status = Spf("error: %v", ev.err)
+				continue
+			}
+			var corrected []string
+			for _, c := range g.Corrections() {
+				corrected = append(corrected, c.String())
+			}
+			if len(corrected) > 0 {
+				m.status = "corrected: " + strings.Join(corrected, ", ")
+			}
+		}
+	}
+}
+
+// open opens the selected source in the editor, and returns the new
+// keyboard channel once the editor exits.
+func (m *model) open(g *core.Grokker, out io.Writer) (keys <-chan keyboard.KeyEvent, err error) {
+	defer Return(&err)
+	s := m.sources[m.sel]
+	path := filepath.Join(g.Root, filepath.FromSlash(s.Path))
+	info, statErr := os.Stat(path)
+	if statErr == nil && !info.Mode().IsRegular() {
+		statErr = fmt.Errorf("not a regular file")
+	}
+	// the editor needs the terminal to itself
+	keyboard.Close()
+	Fpf(out, "\x1b[?25h\x1b[?1049l")
+	if statErr == nil {
+		cmd, cmdErr := editorCommand(util.Editor("GROKKER_EDITOR"), path, s.StartLine)
+		if cmdErr == nil {
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			cmdErr = cmd.Run()
+		}
+		if cmdErr != nil {
+			m.status = Spf("editor: %v", cmdErr)
+		}
+	} else {
+		// e.g. a virtual document, or one deleted since indexing
+		m.status = Spf("can't open %s: %v", s.Path, statErr)
+	}
+	Fpf(out, "\x1b[?1049h\x1b[?25l")
+	keys, err = keyboard.GetKeys(10)
+	Ck(err)
+	return
+}
+
+// editorCommand returns the command that opens path in editor at
+// line.
+func editorCommand(editor, path string, line int) (*exec.Cmd, error) {
+	return util.Command(editor, Spf("+%d", max(line, 1)), path)
+}
@@ -0,0 +1,76 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eiannone/keyboard"
+	. "github.com/stevegt/goadapt"
+	"github.com/stevegt/grokker/v3/core"
+)
+
+func TestModel(t *testing.T) {
+	m := &model{}
+	for _, ch := range "how do" {
+		m.handle(ch, 0)
+	}
+	m.handle(0, keyboard.KeySpace)
+	m.handle('I', 0)
+	m.handle('x', 0)
+	m.handle(0, keyboard.KeyBackspace2)
+	Tassert(t, m.query == "how do I", "got %q", m.query)
+	// there's nothing to select before the first search
+	m.handle(0, keyboard.KeyTab)
+	Tassert(t, m.focus == focusQuery, "expected the focus to stay on the question")
+	Tassert(t, m.handle(0, keyboard.KeyEnter) == actSearch, "expected a search")
+
+	m.sources = []core.SourceSpan{
+		{Path: "docs/deploy.md", StartLine: 12, EndLine: 30, Collection: "docs", Score: 0.83, Snippet: "Run make deploy."},
+		{Path: "Makefile", StartLine: 40, EndLine: 52, Collection: "default", Score: 0.71, Snippet: "deploy:\n\tscripts/deploy"},
+	}
+	m.busy = true
+	Tassert(t, m.handle(0, keyboard.KeyEnter) == actNone, "expected no search while answering")
+	Tassert(t, m.status != "", "expected a status message")
+	m.busy = false
+	m.answer = "Run make deploy, which builds the image and pushes it."
+
+	lines := m.view(40, 12)
+	Tassert(t, len(lines) == 12, "expected 12 lines, got %d", len(lines))
+	screen := strings.Join(lines, "\n")
+	Tassert(t, strings.Contains(screen, "> 0.83  docs/deploy.md:12-30  [docs]"), "got\n%s", screen)
+	Tassert(t, strings.Contains(screen, "  0.71  Makefile:40-52\n"), "got\n%s", screen)
+	Tassert(t, strings.Contains(screen, "── answer"), "got\n%s", screen)
+	Tassert(t, strings.Contains(screen, "Run make deploy, which builds the image"), "got\n%s", screen)
+
+	// typed keys go to the selected pane
+	m.handle(0, keyboard.KeyTab)
+	Tassert(t, m.focus == focusSources, "expected the focus on the sources")
+	m.handle('j', 0)
+	Tassert(t, m.sel == 1, "expected the second source, got %d", m.sel)
+	m.handle(0, keyboard.KeyArrowDown)
+	Tassert(t, m.sel == 1, "expected the selection to stop at the last source, got %d", m.sel)
+	Tassert(t, m.query == "how do I", "got %q", m.query)
+	m.handle('p', 0)
+	screen = strings.Join(m.view(40, 12), "\n")
+	Tassert(t, strings.Contains(screen, "── Makefile:40-52") && strings.Contains(screen, "    scripts/deploy"), "expected the passage, got\n%s", screen)
+	Tassert(t, m.handle(0, keyboard.KeyEnter) == actOpen, "expected to open the source")
+	Tassert(t, m.handle('q', 0) == actQuit, "expected to quit")
+	m.handle('/', 0)
+	Tassert(t, m.focus == focusQuery, "expected the focus on the question")
+	Tassert(t, m.handle(0, keyboard.KeyEsc) == actQuit, "expected to quit")
+}
+
+func TestWrap(t *testing.T) {
+	got := wrap("the quick brown fox jumps\n\nover", 10)
+	want := []string{"the quick", "brown fox", "jumps", "", "over"}
+	Tassert(t, strings.Join(got, "|") == strings.Join(want, "|"), "got %q", got)
+	got = wrap("abcdefghijklmnop", 10)
+	Tassert(t, strings.Join(got, "|") == "abcdefghij|klmnop", "got %q", got)
+	Tassert(t, len(wrap("", 10)) == 0, "expected no lines")
+}
+
+func TestEditorCommand(t *testing.T) {
+	cmd, err := editorCommand("vim -R", "/src/docs/deploy.md", 12)
+	Ck(err)
+	Tassert(t, strings.Join(cmd.Args[1:], " ") == "-R +12 /src/docs/deploy.md", "got %q", cmd.Args)
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Command returns a command that runs the given command line with
+// extra args appended, with no quoting needed for the args.  The
+// command line is split the way the platform's shell would split it:
+// with POSIX shell quoting on Unix, and with the Windows
+// CommandLineToArgvW rules on Windows, so paths such as
+// C:\Program Files\... keep their backslashes.  On Windows, a program
+// that isn't an executable on the PATH, such as a cmd builtin like
+// 'echo' or 'start', is run with 'cmd /C'.
+func Command(cmdline string, args ...string) (cmd *exec.Cmd, err error) {
+	parts, err := splitCommand(cmdline)
+	if err != nil {
+		return
+	}
+	if len(parts) == 0 {
+		err = fmt.Errorf("empty command")
+		return
+	}
+	parts = append(parts, args...)
+	cmd = platformCommand(parts)
+	return
+}
+
+// Editor returns the editor command line from the first of the
+// environment variables that is set, or the platform's default
+// editor.
+func Editor(vars ...string) string {
+	for _, v := range vars {
+		editor := os.Getenv(v)
+		if editor != "" {
+			return editor
+		}
+	}
+	return defaultEditor
+}
+
+// SplitWindows splits a command line using the rules of the Windows
+// CommandLineToArgvW function: arguments are separated by spaces or
+// tabs, double quotes group words, and backslashes are literal except
+// before a double quote.
+func SplitWindows(cmdline string) (args []string, err error) {
+	var arg strings.Builder
+	inArg := false
+	inQuote := false
+	backslashes := 0
+	for _, r := range cmdline {
+		switch {
+		case r == '\\':
+			backslashes++
+			inArg = true
+			continue
+		case r == '"':
+			// 2n backslashes and a quote are n backslashes and a
+			// delimiter; 2n+1 are n backslashes and a literal quote
+			arg.WriteString(strings.Repeat(`\`, backslashes/2))
+			if backslashes%2 == 1 {
+				arg.WriteRune('"')
+			} else {
+				inQuote = !inQuote
+			}
+			backslashes = 0
+			inArg = true
+			continue
+		}
+		arg.WriteString(strings.Repeat(`\`, backslashes))
+		backslashes = 0
+		if (r == ' ' || r == '\t') && !inQuote {
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		}
+		arg.WriteRune(r)
+		inArg = true
+	}
+	arg.WriteString(strings.Repeat(`\`, backslashes))
+	if inQuote {
+		err = fmt.Errorf("unterminated quote in %q", cmdline)
+		return
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package util
+
+import (
+	"os/exec"
+
+	"github.com/google/shlex"
+)
+
+// vi + opens the file at its last line
+const defaultEditor = "vi +"
+
+func splitCommand(cmdline string) ([]string, error) {
+	return shlex.Split(cmdline)
+}
+
+func platformCommand(parts []string) *exec.Cmd {
+	return exec.Command(parts[0], parts[1:]...)
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestSplitWindows(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{`notepad`, []string{"notepad"}},
+		{`code --wait`, []string{"code", "--wait"}},
+		{`"C:\Program Files\Vim\gvim.exe" -f`, []string{`C:\Program Files\Vim\gvim.exe`, "-f"}},
+		{`C:\tools\ed.exe  a\b.md`, []string{`C:\tools\ed.exe`, `a\b.md`}},
+		{`a\\"b c"`, []string{`a\b c`}},
+		{`a\"b`, []string{`a"b`}},
+		{`"" x`, []string{"", "x"}},
+		{`powershell -Command "Start-Process notepad -Wait"`, []string{"powershell", "-Command", "Start-Process notepad -Wait"}},
+	}
+	for _, c := range cases {
+		got, err := SplitWindows(c.in)
+		Tassert(t, err == nil, "%s: %v", c.in, err)
+		Tassert(t, reflect.DeepEqual(got, c.want), "%s: expected %q, got %q", c.in, c.want, got)
+	}
+	_, err := SplitWindows(`"unterminated`)
+	Tassert(t, err != nil, "expected an error for an unterminated quote")
+}
+
+func TestCommand(t *testing.T) {
+	_, err := Command("  ")
+	Tassert(t, err != nil, "expected an error for an empty command")
+
+	// the extra arg is passed as is, spaces and all
+	cmd, err := Command("echo hello", "a b")
+	Tassert(t, err == nil, "%v", err)
+	out, err := cmd.Output()
+	Tassert(t, err == nil, "%v", err)
+	Tassert(t, bytes.Contains(out, []byte("hello")) && bytes.Contains(out, []byte("a b")), "unexpected output %q", out)
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const defaultEditor = "notepad"
+
+func splitCommand(cmdline string) ([]string, error) {
+	return SplitWindows(cmdline)
+}
+
+func platformCommand(parts []string) *exec.Cmd {
+	if _, err := exec.LookPath(parts[0]); err == nil {
+		return exec.Command(parts[0], parts[1:]...)
+	}
+	// let cmd.exe find builtins and scripts; pass the command line
+	// through unchanged, since cmd doesn't use the C runtime's
+	// quoting rules
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	var quoted []string
+	for _, part := range parts {
+		if strings.ContainsAny(part, " \t") {
+			part = `"` + part + `"`
+		}
+		quoted = append(quoted, part)
+	}
+	cmd := exec.Command(shell)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine: `"` + shell + `" /S /C "` + strings.Join(quoted, " ") + `"`,
+	}
+	return cmd
+}
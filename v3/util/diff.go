@@ -0,0 +1,33 @@
+package util
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// LineDiff returns a line-by-line diff of two texts, with each line
+// prefixed by "- " if it was removed, "+ " if it was added, or "  "
+// if it is in both.
+func LineDiff(a, b string) string {
+	dmp := diffmatchpatch.New()
+	ca, cb, lines := dmp.DiffLinesToChars(a, b)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(ca, cb, false), lines)
+	var sb strings.Builder
+	for _, d := range diffs {
+		prefix := "  "
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "- "
+		case diffmatchpatch.DiffInsert:
+			prefix = "+ "
+		}
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			sb.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"testing"
+
+	. "github.com/stevegt/goadapt"
+)
+
+func TestLineDiff(t *testing.T) {
+	got := LineDiff("one\ntwo\nthree\n", "one\n2\nthree\nfour")
+	want := "  one\n- two\n+ 2\n  three\n+ four\n"
+	Tassert(t, got == want, "expected %q, got %q", want, got)
+	Tassert(t, LineDiff("same\n", "same\n") == "  same\n", "unexpected diff %q", LineDiff("same\n", "same\n"))
+}